@@ -3,6 +3,7 @@ package app
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"strings"
 	"time"
@@ -14,10 +15,11 @@ import (
 )
 
 type App struct {
-	cfg    config.Config
-	server *http.Server
-	repo   *repo.Repository
-	h      *handler.Handler
+	cfg        config.Config
+	server     *http.Server
+	nodeServer *http.Server
+	repo       *repo.Repository
+	h          *handler.Handler
 }
 
 func New(cfg config.Config) (*App, error) {
@@ -42,6 +44,7 @@ func New(cfg config.Config) (*App, error) {
 	}
 
 	h := handler.New(r, cfg.JWTSecret)
+	h.SetReleaseDir(cfg.ReleaseDir)
 	router := httpserver.NewRouter(h, cfg.JWTSecret)
 
 	s := &http.Server{
@@ -53,13 +56,44 @@ func New(cfg config.Config) (*App, error) {
 		IdleTimeout:       60 * time.Second,
 	}
 
-	return &App{cfg: cfg, server: s, repo: r, h: h}, nil
+	a := &App{cfg: cfg, server: s, repo: r, h: h}
+	if strings.TrimSpace(cfg.NodeTLSAddr) != "" {
+		tlsConfig, err := h.NodeTLSConfig()
+		if err != nil {
+			return nil, fmt.Errorf("node tls: %w", err)
+		}
+		publicAddr := strings.TrimSpace(cfg.NodeTLSPublicAddr)
+		if publicAddr == "" {
+			_, port, err := net.SplitHostPort(cfg.NodeTLSAddr)
+			if err != nil {
+				return nil, fmt.Errorf("node tls: invalid NODE_TLS_ADDR %q", cfg.NodeTLSAddr)
+			}
+			publicAddr = ":" + port
+		}
+		h.EnableNodeTLS(publicAddr)
+		a.nodeServer = &http.Server{
+			Addr:              cfg.NodeTLSAddr,
+			Handler:           httpserver.NewNodeRouter(router),
+			TLSConfig:         tlsConfig,
+			ReadTimeout:       30 * time.Second,
+			ReadHeaderTimeout: 5 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       60 * time.Second,
+		}
+	}
+	return a, nil
 }
 
 func (a *App) Run() error {
 	if a.h != nil {
 		a.h.StartBackgroundJobs()
 	}
+	if a.nodeServer != nil {
+		errCh := make(chan error, 2)
+		go func() { errCh <- a.nodeServer.ListenAndServeTLS("", "") }()
+		go func() { errCh <- a.server.ListenAndServe() }()
+		return <-errCh
+	}
 	return a.server.ListenAndServe()
 }
 
@@ -67,6 +101,9 @@ func (a *App) Shutdown(ctx context.Context) error {
 	if a.h != nil {
 		a.h.StopBackgroundJobs()
 	}
+	if a.nodeServer != nil {
+		_ = a.nodeServer.Shutdown(ctx)
+	}
 	shutdownErr := a.server.Shutdown(ctx)
 	closeErr := a.repo.Close()
 	if shutdownErr != nil {
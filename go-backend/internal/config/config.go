@@ -2,6 +2,10 @@ package config
 
 import "os"
 
+// Version is the panel release, set at build time with
+// -ldflags "-X go-backend/internal/config.Version=...".
+var Version = "dev"
+
 type Config struct {
 	Addr        string
 	DBType      string
@@ -9,16 +13,26 @@ type Config struct {
 	DatabaseURL string
 	JWTSecret   string
 	LogDir      string
+	// NodeTLSAddr enables the mutual TLS listener for node connections.
+	NodeTLSAddr string
+	// NodeTLSPublicAddr is the address agents dial for it; by default the
+	// listener port on the panel host they already use.
+	NodeTLSPublicAddr string
+	// ReleaseDir holds agent binaries uploaded to the panel.
+	ReleaseDir string
 }
 
 func FromEnv() Config {
 	cfg := Config{
-		Addr:        getEnv("SERVER_ADDR", ":6365"),
-		DBType:      getEnv("DB_TYPE", "sqlite"),
-		DBPath:      getEnv("DB_PATH", "/app/data/gost.db"),
-		DatabaseURL: getEnv("DATABASE_URL", ""),
-		JWTSecret:   getEnv("JWT_SECRET", ""),
-		LogDir:      getEnv("LOG_DIR", "/app/logs"),
+		Addr:              getEnv("SERVER_ADDR", ":6365"),
+		DBType:            getEnv("DB_TYPE", "sqlite"),
+		DBPath:            getEnv("DB_PATH", "/app/data/gost.db"),
+		DatabaseURL:       getEnv("DATABASE_URL", ""),
+		JWTSecret:         getEnv("JWT_SECRET", ""),
+		LogDir:            getEnv("LOG_DIR", "/app/logs"),
+		NodeTLSAddr:       getEnv("NODE_TLS_ADDR", ""),
+		NodeTLSPublicAddr: getEnv("NODE_TLS_PUBLIC_ADDR", ""),
+		ReleaseDir:        getEnv("RELEASE_DIR", "/app/data/releases"),
 	}
 
 	return cfg
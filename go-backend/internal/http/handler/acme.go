@@ -0,0 +1,262 @@
+package handler
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
+
+	"go-backend/internal/store/model"
+)
+
+// ACME issuance uses HTTP-01: the key authorization is published on the
+// entry nodes serving the domains, which answer it on port 80 (through the
+// vhost socket when one exists, or a temporary listener otherwise).
+const (
+	acmeIssueTimeout   = 5 * time.Minute
+	acmeChallengeTTL   = 10 * time.Minute
+	acmeDirectoryKey   = "acme_directory_url"
+	certificateRenewAt = 30 * 24 * time.Hour
+	// acmeRetryAfter is how long the renewal job leaves a certificate alone
+	// after a failed attempt, so a broken domain is not retried every run.
+	acmeRetryAfter = 3 * 24 * time.Hour
+)
+
+func validateACMEDomains(domains []string) error {
+	if len(domains) == 0 {
+		return errors.New("域名不能为空")
+	}
+	for _, domain := range domains {
+		if strings.HasPrefix(domain, "*.") {
+			return fmt.Errorf("ACME 证书使用 HTTP-01 验证，不支持通配符域名: %s", domain)
+		}
+	}
+	return nil
+}
+
+// acmeDirectoryURL resolves the certificate's directory, then the panel
+// default, then Let's Encrypt.
+func (h *Handler) acmeDirectoryURL(cert *model.Certificate) string {
+	if dir := strings.TrimSpace(cert.ACMEDirectory); dir != "" {
+		return dir
+	}
+	if cfg, err := h.repo.GetConfigByName(acmeDirectoryKey); err == nil && cfg != nil && strings.TrimSpace(cfg.Value) != "" {
+		return strings.TrimSpace(cfg.Value)
+	}
+	return acme.LetsEncryptURL
+}
+
+// acmeAccountKey returns the certificate's account key, creating and
+// storing one on first use.
+func (h *Handler) acmeAccountKey(cert *model.Certificate) (crypto.Signer, error) {
+	aes, err := h.certificateCrypto()
+	if err != nil {
+		return nil, err
+	}
+	if cert.ACMEAccountKey != "" {
+		der, err := aes.Decrypt(cert.ACMEAccountKey)
+		if err != nil {
+			return nil, fmt.Errorf("ACME 账户密钥解密失败: %v", err)
+		}
+		return x509.ParseECPrivateKey(der)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := aes.Encrypt(der)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.repo.UpdateCertificateACMEAccount(cert.ID, sealed, time.Now().UnixMilli()); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// issueACMECertificate obtains a certificate for all domains and stores it.
+// extraNodeIDs answer challenges in addition to the entry nodes of the
+// forwards already using the certificate.
+func (h *Handler) issueACMECertificate(ctx context.Context, certificateID int64, extraNodeIDs []int64) error {
+	cert, err := h.repo.GetCertificate(certificateID)
+	if err != nil {
+		return err
+	}
+	if cert == nil {
+		return errors.New("证书不存在")
+	}
+	if cert.Source != certificateSourceACME {
+		return errors.New("仅 ACME 证书支持签发")
+	}
+	domains := strings.Split(cert.Domains, "\n")
+	if err := validateACMEDomains(domains); err != nil {
+		return err
+	}
+	accountKey, err := h.acmeAccountKey(cert)
+	if err != nil {
+		return err
+	}
+
+	client := &acme.Client{Key: accountKey, DirectoryURL: h.acmeDirectoryURL(cert)}
+	account := &acme.Account{}
+	if cert.ACMEEmail != "" {
+		account.Contact = []string{"mailto:" + cert.ACMEEmail}
+	}
+	if _, err := client.Register(ctx, account, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return fmt.Errorf("ACME 账户注册失败: %v", err)
+	}
+
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(domains...))
+	if err != nil {
+		return fmt.Errorf("ACME 创建订单失败: %v", err)
+	}
+
+	var nodeIDs []int64
+	var published []string
+	defer func() {
+		if len(published) > 0 {
+			h.clearACMEChallenges(nodeIDs, published)
+		}
+	}()
+	for _, authzURL := range order.AuthzURLs {
+		authz, err := client.GetAuthorization(ctx, authzURL)
+		if err != nil {
+			return fmt.Errorf("ACME 获取授权失败: %v", err)
+		}
+		if authz.Status == acme.StatusValid {
+			continue
+		}
+		var chal *acme.Challenge
+		for _, c := range authz.Challenges {
+			if c.Type == "http-01" {
+				chal = c
+				break
+			}
+		}
+		if chal == nil {
+			return fmt.Errorf("%s 不支持 HTTP-01 验证", authz.Identifier.Value)
+		}
+		keyAuth, err := client.HTTP01ChallengeResponse(chal.Token)
+		if err != nil {
+			return err
+		}
+		if nodeIDs == nil {
+			used, err := h.repo.ListCertificateNodeIDs(cert.ID)
+			if err != nil {
+				return err
+			}
+			nodeIDs = uniqueSortedIDs(used, extraNodeIDs)
+		}
+		if err := h.publishACMEChallenge(nodeIDs, chal.Token, keyAuth); err != nil {
+			return err
+		}
+		published = append(published, chal.Token)
+		if _, err := client.Accept(ctx, chal); err != nil {
+			return fmt.Errorf("ACME 提交验证失败: %v", err)
+		}
+		if _, err := client.WaitAuthorization(ctx, authz.URI); err != nil {
+			return fmt.Errorf("%s 验证失败: %v", authz.Identifier.Value, err)
+		}
+	}
+
+	order, err = client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return fmt.Errorf("ACME 订单失败: %v", err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domains[0]},
+		DNSNames: domains,
+	}, key)
+	if err != nil {
+		return err
+	}
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return fmt.Errorf("ACME 签发失败: %v", err)
+	}
+
+	var certPEM strings.Builder
+	for _, der := range chain {
+		_ = pem.Encode(&certPEM, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return h.storeCertificateMaterial(cert.ID, certPEM.String(), string(keyPEM))
+}
+
+// publishACMEChallenge needs only one node to succeed, since the domain
+// resolves to one of them; the others are best effort.
+func (h *Handler) publishACMEChallenge(nodeIDs []int64, token, keyAuth string) error {
+	if len(nodeIDs) == 0 {
+		return errors.New("没有可用于域名验证的节点，请指定节点")
+	}
+	data := map[string]interface{}{
+		"challenges": []map[string]interface{}{{"token": token, "keyAuth": keyAuth}},
+		"ttl":        int(acmeChallengeTTL / time.Second),
+	}
+	var lastErr error
+	published := 0
+	for _, nodeID := range nodeIDs {
+		if _, err := h.sendNodeCommand(nodeID, "SetACMEChallenges", data, false, false); err != nil {
+			lastErr = err
+			continue
+		}
+		published++
+	}
+	if published == 0 {
+		return fmt.Errorf("下发域名验证失败: %v", lastErr)
+	}
+	return nil
+}
+
+func (h *Handler) clearACMEChallenges(nodeIDs []int64, tokens []string) {
+	for _, nodeID := range nodeIDs {
+		_, _ = h.sendNodeCommand(nodeID, "DeleteACMEChallenges", map[string]interface{}{"tokens": tokens}, false, true)
+	}
+}
+
+// runCertificateRenewalJob renews ACME certificates that expire within
+// certificateRenewAt, including ones that never got issued. A certificate
+// whose last attempt failed waits acmeRetryAfter before the next one.
+func (h *Handler) runCertificateRenewalJob(now time.Time) {
+	if h == nil || h.repo == nil {
+		return
+	}
+	certs, err := h.repo.ListACMECertificatesDue(now.Add(certificateRenewAt).UnixMilli())
+	if err != nil {
+		return
+	}
+	for _, cert := range certs {
+		if cert.LastError != "" && now.Sub(time.UnixMilli(cert.UpdatedTime)) < acmeRetryAfter {
+			continue
+		}
+		if !h.beginACMEIssue(cert.ID) {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), acmeIssueTimeout)
+		_ = h.renewACMECertificate(ctx, cert.ID, nil)
+		cancel()
+		h.endACMEIssue(cert.ID)
+	}
+}
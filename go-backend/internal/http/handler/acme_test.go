@@ -0,0 +1,309 @@
+package handler
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-backend/internal/store/model"
+	"go-backend/internal/store/repo"
+)
+
+// fakeACME is a minimal RFC 8555 directory in the spirit of pebble with
+// PEBBLE_VA_ALWAYS_VALID: authorizations are valid up front and every
+// finalized order is signed by a throwaway CA.
+type fakeACME struct {
+	t      *testing.T
+	srv    *httptest.Server
+	caKey  *ecdsa.PrivateKey
+	caCert *x509.Certificate
+
+	mu       sync.Mutex
+	domains  []string
+	leafPEM  []byte
+	accounts int
+}
+
+func newFakeACME(t *testing.T) *fakeACME {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ca key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fake acme ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("ca cert: %v", err)
+	}
+	caCert, _ := x509.ParseCertificate(der)
+
+	f := &fakeACME{t: t, caKey: caKey, caCert: caCert}
+	f.srv = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+func (f *fakeACME) serve(w http.ResponseWriter, r *http.Request) {
+	base := f.srv.URL
+	w.Header().Set("Replay-Nonce", randomToken(8))
+	payload := f.payload(r)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/directory":
+		writeACMEJSON(w, http.StatusOK, map[string]interface{}{
+			"newNonce":   base + "/nonce",
+			"newAccount": base + "/account",
+			"newOrder":   base + "/order",
+		})
+	case "/nonce":
+		w.WriteHeader(http.StatusOK)
+	case "/account":
+		f.accounts++
+		w.Header().Set("Location", base+"/account/1")
+		writeACMEJSON(w, http.StatusCreated, map[string]interface{}{"status": "valid"})
+	case "/order":
+		var req struct {
+			Identifiers []struct{ Value string } `json:"identifiers"`
+		}
+		_ = json.Unmarshal(payload, &req)
+		f.domains = nil
+		for _, id := range req.Identifiers {
+			f.domains = append(f.domains, id.Value)
+		}
+		f.leafPEM = nil
+		w.Header().Set("Location", base+"/order/1")
+		writeACMEJSON(w, http.StatusCreated, f.order())
+	case "/order/1":
+		writeACMEJSON(w, http.StatusOK, f.order())
+	case "/authz/1":
+		writeACMEJSON(w, http.StatusOK, map[string]interface{}{
+			"status":     "valid",
+			"identifier": map[string]string{"type": "dns", "value": f.domains[0]},
+			"challenges": []interface{}{},
+		})
+	case "/finalize":
+		var req struct {
+			CSR string `json:"csr"`
+		}
+		_ = json.Unmarshal(payload, &req)
+		raw, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+		csr, err := x509.ParseCertificateRequest(raw)
+		if err != nil {
+			f.t.Errorf("parse csr: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.leafPEM = f.sign(csr)
+		writeACMEJSON(w, http.StatusOK, f.order())
+	case "/cert/1":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(f.leafPEM)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeACME) payload(r *http.Request) []byte {
+	if r.Method != http.MethodPost {
+		return nil
+	}
+	body, _ := io.ReadAll(r.Body)
+	var jws struct {
+		Payload string `json:"payload"`
+	}
+	if err := json.Unmarshal(body, &jws); err != nil {
+		return nil
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+	return payload
+}
+
+func (f *fakeACME) order() map[string]interface{} {
+	base := f.srv.URL
+	ids := make([]map[string]string, 0, len(f.domains))
+	for _, d := range f.domains {
+		ids = append(ids, map[string]string{"type": "dns", "value": d})
+	}
+	order := map[string]interface{}{
+		"status":         "ready",
+		"identifiers":    ids,
+		"authorizations": []string{base + "/authz/1"},
+		"finalize":       base + "/finalize",
+	}
+	if f.leafPEM != nil {
+		order["status"] = "valid"
+		order["certificate"] = base + "/cert/1"
+	}
+	return order
+}
+
+func (f *fakeACME) sign(csr *x509.CertificateRequest) []byte {
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: csr.Subject.CommonName},
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, f.caCert, csr.PublicKey, f.caKey)
+	if err != nil {
+		f.t.Errorf("sign: %v", err)
+		return nil
+	}
+	out := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: f.caCert.Raw})...)
+}
+
+func writeACMEJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func TestIssueACMECertificate(t *testing.T) {
+	r, err := repo.Open(filepath.Join(t.TempDir(), "acme.db"))
+	if err != nil {
+		t.Fatalf("open repo: %v", err)
+	}
+	defer r.Close()
+	h := &Handler{repo: r, jwtSecret: "test-secret"}
+	ca := newFakeACME(t)
+
+	now := time.Now().UnixMilli()
+	id, err := r.CreateCertificate(&model.Certificate{
+		Name:          "web",
+		Domains:       "app.example.com\nwww.example.com",
+		Source:        certificateSourceACME,
+		ACMEDirectory: ca.srv.URL + "/directory",
+		ACMEEmail:     "ops@example.com",
+		CreatedTime:   now,
+		UpdatedTime:   now,
+	})
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := h.renewACMECertificate(ctx, id, nil); err != nil {
+		t.Fatalf("issue: %v", err)
+	}
+
+	cert, err := r.GetCertificate(id)
+	if err != nil || cert == nil {
+		t.Fatalf("get certificate: %v", err)
+	}
+	if cert.Status != certificateStatusIssued || cert.LastError != "" {
+		t.Fatalf("expected issued certificate, got status %d (%s)", cert.Status, cert.LastError)
+	}
+	if strings.Contains(cert.CertData, "BEGIN") || strings.Contains(cert.KeyData, "PRIVATE KEY") || cert.ACMEAccountKey == "" {
+		t.Fatalf("expected key material to be stored encrypted")
+	}
+	if cert.NotAfter <= time.Now().Add(60*24*time.Hour).UnixMilli() {
+		t.Fatalf("unexpected expiry: %d", cert.NotAfter)
+	}
+
+	certPEM, keyPEM, err := h.loadCertificatePEM(cert)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	domains, _, _, err := parseCertificatePair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("parse issued pair: %v", err)
+	}
+	if strings.Join(domains, ",") != "app.example.com,www.example.com" {
+		t.Fatalf("unexpected domains: %v", domains)
+	}
+
+	// Issued well ahead of expiry, so the renewal job leaves it alone and
+	// the account key is reused.
+	due, err := r.ListACMECertificatesDue(time.Now().Add(certificateRenewAt).UnixMilli())
+	if err != nil || len(due) != 0 {
+		t.Fatalf("expected nothing due for renewal, got %d (%v)", len(due), err)
+	}
+	accountKey := cert.ACMEAccountKey
+	if err := h.renewACMECertificate(ctx, id, nil); err != nil {
+		t.Fatalf("renew: %v", err)
+	}
+	renewed, _ := r.GetCertificate(id)
+	if renewed.ACMEAccountKey != accountKey {
+		t.Fatalf("expected the account key to be reused")
+	}
+}
+
+func TestIssueACMECertificateRecordsFailure(t *testing.T) {
+	r, err := repo.Open(filepath.Join(t.TempDir(), "acme-fail.db"))
+	if err != nil {
+		t.Fatalf("open repo: %v", err)
+	}
+	defer r.Close()
+	h := &Handler{repo: r, jwtSecret: "test-secret"}
+
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		attempts.Add(1)
+		http.NotFound(w, req)
+	}))
+	defer srv.Close()
+
+	now := time.Now().UnixMilli()
+	id, err := r.CreateCertificate(&model.Certificate{
+		Name:          "web",
+		Domains:       "app.example.com",
+		Source:        certificateSourceACME,
+		ACMEDirectory: srv.URL + "/directory",
+		CreatedTime:   now,
+		UpdatedTime:   now,
+	})
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	if err := h.renewACMECertificate(context.Background(), id, nil); err == nil {
+		t.Fatalf("expected issuance to fail")
+	}
+	cert, _ := r.GetCertificate(id)
+	if cert.Status != 2 || cert.LastError == "" {
+		t.Fatalf("expected failure to be recorded, got status %d (%q)", cert.Status, cert.LastError)
+	}
+
+	// The renewal job backs off from the failed certificate until
+	// acmeRetryAfter has passed.
+	tried := attempts.Load()
+	h.runCertificateRenewalJob(time.Now())
+	if got := attempts.Load(); got != tried {
+		t.Fatalf("expected the renewal job to skip a recent failure, got %d more requests", got-tried)
+	}
+	h.runCertificateRenewalJob(time.Now().Add(acmeRetryAfter + time.Minute))
+	if got := attempts.Load(); got == tried {
+		t.Fatalf("expected the renewal job to retry after the backoff")
+	}
+}
@@ -0,0 +1,500 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-backend/internal/config"
+	"go-backend/internal/http/response"
+	"go-backend/internal/store/model"
+)
+
+// Alert rule types.
+const (
+	alertRuleOffline    = "node_offline"
+	alertRuleCPU        = "cpu"
+	alertRuleMemory     = "memory"
+	alertRuleThroughput = "throughput"
+	alertRuleVersion    = "version_mismatch"
+)
+
+const (
+	// alertEvalInterval is how often rules are evaluated.
+	alertEvalInterval = 10 * time.Second
+	// alertSampleMaxAge is how old a resource sample may be before a node's
+	// resource rules are treated as having no data.
+	alertSampleMaxAge = 2 * time.Minute
+	// defaultAlertRecover is used when a rule is created without a recover
+	// duration, so a flapping node does not notify on every report.
+	defaultAlertRecover = 60
+)
+
+type alertKey struct {
+	ruleID int64
+	nodeID int64
+}
+
+// alertState tracks one rule on one node. breachSince and clearSince are
+// when the condition last started to hold or to be clear; eventID is the
+// open event while the alert is firing.
+type alertState struct {
+	breachSince time.Time
+	clearSince  time.Time
+	eventID     int64
+}
+
+func validAlertRuleType(t string) bool {
+	switch t {
+	case alertRuleOffline, alertRuleCPU, alertRuleMemory, alertRuleThroughput, alertRuleVersion:
+		return true
+	}
+	return false
+}
+
+func joinIDList(ids []int64) string {
+	parts := make([]string, 0, len(ids))
+	seen := make(map[int64]struct{}, len(ids))
+	for _, id := range ids {
+		if _, ok := seen[id]; ok || id <= 0 {
+			continue
+		}
+		seen[id] = struct{}{}
+		parts = append(parts, strconv.FormatInt(id, 10))
+	}
+	return strings.Join(parts, ",")
+}
+
+func splitIDList(raw string) []int64 {
+	ids := make([]int64, 0)
+	for _, part := range strings.Split(raw, ",") {
+		if id, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64); err == nil && id > 0 {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func trimVersion(v string) string {
+	return strings.TrimPrefix(strings.TrimSpace(v), "v")
+}
+
+// evaluateAlertRule reports whether the rule's condition holds on the node
+// and the measured value. known is false when there is no data to decide,
+// in which case the alert keeps its current state.
+func (h *Handler) evaluateAlertRule(rule model.AlertRule, node model.Node, now time.Time) (breach bool, value float64, detail string, known bool) {
+	switch rule.Type {
+	case alertRuleOffline:
+		// Nodes that never connected are not expected to be online.
+		if !node.Version.Valid || node.Version.String == "" {
+			return false, 0, "", false
+		}
+		if node.Status == 1 {
+			return false, 0, "", true
+		}
+		// Nodes that went offline before disconnect times were recorded have
+		// no known offline duration.
+		if node.DisconnectedTime <= 0 {
+			return false, 0, "", false
+		}
+		offline := now.Sub(time.UnixMilli(node.DisconnectedTime)).Seconds()
+		return offline >= float64(rule.Duration), offline, fmt.Sprintf("节点已离线 %d 秒", int64(offline)), true
+	case alertRuleCPU, alertRuleMemory, alertRuleThroughput:
+		if node.Status != 1 {
+			return false, 0, "", false
+		}
+		sample, ok := h.latestNodeMetrics(node.ID, now, alertSampleMaxAge)
+		if !ok {
+			return false, 0, "", false
+		}
+		switch rule.Type {
+		case alertRuleCPU:
+			return sample.CPU >= rule.Threshold, sample.CPU, fmt.Sprintf("CPU 使用率 %.1f%%，阈值 %.1f%%", sample.CPU, rule.Threshold), true
+		case alertRuleMemory:
+			return sample.Memory >= rule.Threshold, sample.Memory, fmt.Sprintf("内存使用率 %.1f%%，阈值 %.1f%%", sample.Memory, rule.Threshold), true
+		default:
+			capacity := rule.CapacityMbps
+			if capacity <= 0 {
+				capacity = node.CapacityMbps
+			}
+			if capacity <= 0 {
+				return false, 0, "", false
+			}
+			mbps := float64(max(sample.RxRate, sample.TxRate)) * 8 / 1e6
+			percent := mbps / float64(capacity) * 100
+			return percent >= rule.Threshold, percent, fmt.Sprintf("带宽 %.1f Mbps，达到容量 %d Mbps 的 %.1f%%", mbps, capacity, percent), true
+		}
+	case alertRuleVersion:
+		expected := trimVersion(rule.ExpectedVersion)
+		if expected == "" {
+			expected = trimVersion(config.Version)
+		}
+		if expected == "" || expected == "dev" || node.Status != 1 || !node.Version.Valid || node.Version.String == "" {
+			return false, 0, "", false
+		}
+		actual := trimVersion(node.Version.String)
+		return actual != expected, 0, fmt.Sprintf("节点版本 %s 与期望版本 %s 不一致", actual, expected), true
+	}
+	return false, 0, "", false
+}
+
+// runAlertJob evaluates every enabled rule against its nodes. A rule fires
+// once its condition has held for Duration (offline rules measure Duration
+// as offline time instead) and resolves once it has been clear for
+// RecoverDuration.
+func (h *Handler) runAlertJob(now time.Time) {
+	if h == nil || h.repo == nil {
+		return
+	}
+	rules, err := h.repo.ListAlertRules()
+	if err != nil {
+		return
+	}
+	nodes, err := h.repo.ListAllNodes()
+	if err != nil {
+		return
+	}
+	channelList, err := h.repo.ListAlertChannels()
+	if err != nil {
+		return
+	}
+	channels := make(map[int64]model.AlertChannel, len(channelList))
+	for _, ch := range channelList {
+		channels[ch.ID] = ch
+	}
+
+	h.alertMu.Lock()
+	defer h.alertMu.Unlock()
+	if h.alertStates == nil {
+		// Pick up alerts that were firing before a restart so they still resolve.
+		h.alertStates = make(map[alertKey]*alertState)
+		if open, err := h.repo.ListOpenAlertEvents(); err == nil {
+			for _, ev := range open {
+				h.alertStates[alertKey{ev.RuleID, ev.NodeID}] = &alertState{
+					breachSince: time.UnixMilli(ev.FiredTime),
+					eventID:     ev.ID,
+				}
+			}
+		}
+	}
+
+	seen := make(map[alertKey]struct{})
+	for _, rule := range rules {
+		if rule.Enabled != 1 {
+			continue
+		}
+		targets := splitIDList(rule.NodeIDs)
+		targetSet := make(map[int64]struct{}, len(targets))
+		for _, id := range targets {
+			targetSet[id] = struct{}{}
+		}
+		for _, node := range nodes {
+			if _, ok := targetSet[node.ID]; len(targets) > 0 && !ok {
+				continue
+			}
+			key := alertKey{rule.ID, node.ID}
+			seen[key] = struct{}{}
+			breach, value, detail, known := h.evaluateAlertRule(rule, node, now)
+			if !known {
+				continue
+			}
+			st := h.alertStates[key]
+			if st == nil {
+				st = &alertState{}
+				h.alertStates[key] = st
+			}
+			h.stepAlert(st, rule, node, channels, breach, value, detail, now)
+			if st.eventID == 0 && st.breachSince.IsZero() {
+				delete(h.alertStates, key)
+			}
+		}
+	}
+
+	// Rules that were disabled or deleted and nodes that were removed resolve
+	// quietly.
+	for key, st := range h.alertStates {
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		if st.eventID > 0 {
+			_ = h.repo.ResolveAlertEvent(st.eventID, now.UnixMilli())
+		}
+		delete(h.alertStates, key)
+	}
+}
+
+func (h *Handler) stepAlert(st *alertState, rule model.AlertRule, node model.Node, channels map[int64]model.AlertChannel, breach bool, value float64, detail string, now time.Time) {
+	if breach {
+		st.clearSince = time.Time{}
+		if st.breachSince.IsZero() {
+			st.breachSince = now
+		}
+		hold := time.Duration(rule.Duration) * time.Second
+		if rule.Type == alertRuleOffline || rule.Type == alertRuleVersion {
+			hold = 0
+		}
+		if st.eventID > 0 || now.Sub(st.breachSince) < hold {
+			return
+		}
+		message := fmt.Sprintf("[告警] %s - 节点 %s：%s", rule.Name, node.Name, detail)
+		id, err := h.repo.CreateAlertEvent(&model.AlertEvent{
+			RuleID:    rule.ID,
+			RuleName:  rule.Name,
+			RuleType:  rule.Type,
+			NodeID:    node.ID,
+			NodeName:  node.Name,
+			Message:   message,
+			Value:     value,
+			FiredTime: now.UnixMilli(),
+		})
+		if err != nil {
+			return
+		}
+		st.eventID = id
+		h.notifyAlert(id, rule.ChannelIDs, channels, alertNotification{
+			Status: "firing", RuleID: rule.ID, RuleName: rule.Name, RuleType: rule.Type,
+			NodeID: node.ID, NodeName: node.Name, Message: message, Value: value, Time: now.UnixMilli(),
+		})
+		return
+	}
+
+	st.breachSince = time.Time{}
+	if st.eventID == 0 {
+		return
+	}
+	if st.clearSince.IsZero() {
+		st.clearSince = now
+	}
+	if now.Sub(st.clearSince) < time.Duration(rule.RecoverDuration)*time.Second {
+		return
+	}
+	eventID := st.eventID
+	if err := h.repo.ResolveAlertEvent(eventID, now.UnixMilli()); err != nil {
+		return
+	}
+	st.eventID = 0
+	st.clearSince = time.Time{}
+	if rule.NotifyRecovery == 1 {
+		message := fmt.Sprintf("[恢复] %s - 节点 %s 已恢复正常", rule.Name, node.Name)
+		h.notifyAlert(eventID, rule.ChannelIDs, channels, alertNotification{
+			Status: "resolved", RuleID: rule.ID, RuleName: rule.Name, RuleType: rule.Type,
+			NodeID: node.ID, NodeName: node.Name, Message: message, Value: value, Time: now.UnixMilli(),
+		})
+	}
+}
+
+// forgetAlertRule drops the evaluator state of a rule so a recreated or
+// reconfigured rule starts fresh.
+func (h *Handler) forgetAlertRule(ruleID int64) {
+	h.alertMu.Lock()
+	defer h.alertMu.Unlock()
+	for key := range h.alertStates {
+		if key.ruleID == ruleID {
+			delete(h.alertStates, key)
+		}
+	}
+}
+
+func alertRuleToMap(rule model.AlertRule) map[string]interface{} {
+	return map[string]interface{}{
+		"id":              rule.ID,
+		"name":            rule.Name,
+		"type":            rule.Type,
+		"nodeIds":         splitIDList(rule.NodeIDs),
+		"threshold":       rule.Threshold,
+		"duration":        rule.Duration,
+		"recoverDuration": rule.RecoverDuration,
+		"capacityMbps":    rule.CapacityMbps,
+		"expectedVersion": rule.ExpectedVersion,
+		"channelIds":      splitIDList(rule.ChannelIDs),
+		"notifyRecovery":  rule.NotifyRecovery,
+		"enabled":         rule.Enabled,
+		"createdTime":     rule.CreatedTime,
+		"updatedTime":     rule.UpdatedTime,
+	}
+}
+
+func boolFlag(v interface{}, def bool) int {
+	if asBool(v, def) {
+		return 1
+	}
+	return 0
+}
+
+// alertRuleFromRequest builds a rule from a create or update request.
+func alertRuleFromRequest(req map[string]interface{}) (model.AlertRule, error) {
+	rule := model.AlertRule{
+		Name:            strings.TrimSpace(asString(req["name"])),
+		Type:            strings.TrimSpace(asString(req["type"])),
+		NodeIDs:         joinIDList(asInt64Slice(req["nodeIds"])),
+		Threshold:       asFloat(req["threshold"], 0),
+		Duration:        asInt(req["duration"], 0),
+		RecoverDuration: asInt(req["recoverDuration"], defaultAlertRecover),
+		CapacityMbps:    asInt64(req["capacityMbps"], 0),
+		ExpectedVersion: strings.TrimSpace(asString(req["expectedVersion"])),
+		ChannelIDs:      joinIDList(asInt64Slice(req["channelIds"])),
+		NotifyRecovery:  boolFlag(req["notifyRecovery"], true),
+		Enabled:         boolFlag(req["enabled"], true),
+	}
+	if rule.Name == "" {
+		return rule, fmt.Errorf("规则名称不能为空")
+	}
+	if !validAlertRuleType(rule.Type) {
+		return rule, fmt.Errorf("不支持的规则类型")
+	}
+	if rule.Duration < 0 || rule.RecoverDuration < 0 || rule.Threshold < 0 {
+		return rule, fmt.Errorf("阈值和持续时间不能为负数")
+	}
+	switch rule.Type {
+	case alertRuleCPU, alertRuleMemory:
+		if rule.Threshold <= 0 || rule.Threshold > 100 {
+			return rule, fmt.Errorf("阈值必须在 0-100 之间")
+		}
+	case alertRuleThroughput:
+		if rule.CapacityMbps < 0 {
+			return rule, fmt.Errorf("带宽容量不能为负数")
+		}
+		if rule.Threshold <= 0 {
+			return rule, fmt.Errorf("阈值必须大于 0")
+		}
+	}
+	return rule, nil
+}
+
+func (h *Handler) alertRuleList(w http.ResponseWriter, r *http.Request) {
+	rules, err := h.repo.ListAlertRules()
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	items := make([]map[string]interface{}, 0, len(rules))
+	for _, rule := range rules {
+		items = append(items, alertRuleToMap(rule))
+	}
+	response.WriteJSON(w, response.OK(items))
+}
+
+func (h *Handler) alertRuleCreate(w http.ResponseWriter, r *http.Request) {
+	h.saveAlertRule(w, r, false)
+}
+
+func (h *Handler) alertRuleUpdate(w http.ResponseWriter, r *http.Request) {
+	h.saveAlertRule(w, r, true)
+}
+
+func (h *Handler) saveAlertRule(w http.ResponseWriter, r *http.Request, update bool) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req map[string]interface{}
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	rule, err := alertRuleFromRequest(req)
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
+	now := time.Now().UnixMilli()
+	rule.UpdatedTime = now
+	if update {
+		rule.ID = asInt64(req["id"], 0)
+		existing, err := h.repo.GetAlertRule(rule.ID)
+		if err != nil {
+			response.WriteJSON(w, response.Err(-2, err.Error()))
+			return
+		}
+		if existing == nil {
+			response.WriteJSON(w, response.ErrDefault("告警规则不存在"))
+			return
+		}
+		rule.CreatedTime = existing.CreatedTime
+	} else {
+		rule.CreatedTime = now
+	}
+	if err := h.repo.SaveAlertRule(&rule); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if update {
+		h.forgetAlertRuleUnlessFiring(rule.ID)
+	}
+	response.WriteJSON(w, response.OK(alertRuleToMap(rule)))
+}
+
+// forgetAlertRuleUnlessFiring resets pending conditions of an updated rule
+// but keeps firing alerts, so they still resolve against the new settings.
+func (h *Handler) forgetAlertRuleUnlessFiring(ruleID int64) {
+	h.alertMu.Lock()
+	defer h.alertMu.Unlock()
+	for key, st := range h.alertStates {
+		if key.ruleID != ruleID {
+			continue
+		}
+		if st.eventID == 0 {
+			delete(h.alertStates, key)
+			continue
+		}
+		st.clearSince = time.Time{}
+	}
+}
+
+func (h *Handler) alertRuleDelete(w http.ResponseWriter, r *http.Request) {
+	id := idFromBody(r, w)
+	if id <= 0 {
+		return
+	}
+	if err := h.repo.DeleteAlertRule(id); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	h.forgetAlertRule(id)
+	response.WriteJSON(w, response.OKEmpty())
+}
+
+func (h *Handler) alertEventList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req map[string]interface{}
+	_ = decodeJSON(r.Body, &req)
+	limit := asInt(req["limit"], 100)
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	events, err := h.repo.ListAlertEvents(asBool(req["active"], false), limit)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	items := make([]map[string]interface{}, 0, len(events))
+	for _, ev := range events {
+		items = append(items, map[string]interface{}{
+			"id":           ev.ID,
+			"ruleId":       ev.RuleID,
+			"ruleName":     ev.RuleName,
+			"ruleType":     ev.RuleType,
+			"nodeId":       ev.NodeID,
+			"nodeName":     ev.NodeName,
+			"message":      ev.Message,
+			"value":        ev.Value,
+			"firedTime":    ev.FiredTime,
+			"resolvedTime": nullableMillis(ev.ResolvedTime),
+			"notifyStatus": ev.NotifyStatus,
+			"notifyError":  ev.NotifyError,
+		})
+	}
+	response.WriteJSON(w, response.OK(items))
+}
+
+func nullableMillis(v int64) interface{} {
+	if v <= 0 {
+		return nil
+	}
+	return v
+}
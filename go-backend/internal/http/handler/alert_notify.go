@@ -0,0 +1,310 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/store/model"
+)
+
+// Notification channel types. Webhook config is {"url", "headers"}; the
+// alert is POSTed as JSON. Telegram config is {"botToken", "chatId"} plus an
+// optional "apiBase" for self-hosted bot API servers.
+const (
+	alertChannelWebhook  = "webhook"
+	alertChannelTelegram = "telegram"
+)
+
+const defaultTelegramAPIBase = "https://api.telegram.org"
+
+var alertHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+type alertNotification struct {
+	Status   string  `json:"status"`
+	RuleID   int64   `json:"ruleId"`
+	RuleName string  `json:"ruleName"`
+	RuleType string  `json:"ruleType"`
+	NodeID   int64   `json:"nodeId"`
+	NodeName string  `json:"nodeName"`
+	Message  string  `json:"message"`
+	Value    float64 `json:"value"`
+	Time     int64   `json:"time"`
+}
+
+type alertChannelConfig struct {
+	URL      string            `json:"url,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	BotToken string            `json:"botToken,omitempty"`
+	ChatID   string            `json:"chatId,omitempty"`
+	APIBase  string            `json:"apiBase,omitempty"`
+}
+
+// normalizeAlertChannelConfig validates a channel config for its type and
+// returns it re-encoded without unrelated fields.
+func normalizeAlertChannelConfig(channelType string, raw interface{}) (string, error) {
+	var cfg alertChannelConfig
+	switch v := raw.(type) {
+	case string:
+		if err := json.Unmarshal([]byte(v), &cfg); err != nil {
+			return "", fmt.Errorf("通知配置格式错误")
+		}
+	case map[string]interface{}:
+		b, _ := json.Marshal(v)
+		if err := json.Unmarshal(b, &cfg); err != nil {
+			return "", fmt.Errorf("通知配置格式错误")
+		}
+	default:
+		return "", fmt.Errorf("通知配置不能为空")
+	}
+
+	var out alertChannelConfig
+	switch channelType {
+	case alertChannelWebhook:
+		u, err := url.Parse(strings.TrimSpace(cfg.URL))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "", fmt.Errorf("Webhook 地址无效")
+		}
+		out.URL = u.String()
+		out.Headers = cfg.Headers
+	case alertChannelTelegram:
+		out.BotToken = strings.TrimSpace(cfg.BotToken)
+		out.ChatID = strings.TrimSpace(cfg.ChatID)
+		if out.BotToken == "" || out.ChatID == "" {
+			return "", fmt.Errorf("Telegram 机器人 Token 和 Chat ID 不能为空")
+		}
+		out.APIBase = strings.TrimRight(strings.TrimSpace(cfg.APIBase), "/")
+	default:
+		return "", fmt.Errorf("不支持的通知类型")
+	}
+	b, _ := json.Marshal(out)
+	return string(b), nil
+}
+
+// Delivery results recorded on an alert event.
+const (
+	alertNotifySent   = "sent"
+	alertNotifyFailed = "failed"
+)
+
+// notifyAlert sends n to the enabled channels listed in channelIDs without
+// blocking the evaluator. Once every channel has answered, the outcome is
+// recorded on the event and failures are logged.
+func (h *Handler) notifyAlert(eventID int64, channelIDs string, channels map[int64]model.AlertChannel, n alertNotification) {
+	targets := make([]model.AlertChannel, 0)
+	for _, id := range splitIDList(channelIDs) {
+		ch, ok := channels[id]
+		if !ok || ch.Enabled != 1 {
+			continue
+		}
+		targets = append(targets, ch)
+	}
+	if len(targets) == 0 {
+		return
+	}
+	go func() {
+		errs := make([]error, len(targets))
+		var wg sync.WaitGroup
+		for i, ch := range targets {
+			wg.Add(1)
+			go func(i int, ch model.AlertChannel) {
+				defer wg.Done()
+				errs[i] = sendAlertNotification(ch, n)
+			}(i, ch)
+		}
+		wg.Wait()
+
+		failures := make([]string, 0)
+		for i, err := range errs {
+			if err == nil {
+				continue
+			}
+			log.Printf("alert %d: %s notification via channel %q failed: %v", eventID, n.Status, targets[i].Name, err)
+			failures = append(failures, targets[i].Name+": "+err.Error())
+		}
+		status := alertNotifySent
+		if len(failures) > 0 {
+			status = alertNotifyFailed
+		}
+		if err := h.repo.SetAlertEventDelivery(eventID, status, strings.Join(failures, "; ")); err != nil {
+			log.Printf("alert %d: record notification result failed: %v", eventID, err)
+		}
+	}()
+}
+
+func sendAlertNotification(ch model.AlertChannel, n alertNotification) error {
+	var cfg alertChannelConfig
+	if err := json.Unmarshal([]byte(ch.Config), &cfg); err != nil {
+		return fmt.Errorf("通知配置格式错误")
+	}
+	switch ch.Type {
+	case alertChannelWebhook:
+		return postAlertJSON(cfg.URL, cfg.Headers, n)
+	case alertChannelTelegram:
+		base := cfg.APIBase
+		if base == "" {
+			base = defaultTelegramAPIBase
+		}
+		return postAlertJSON(base+"/bot"+cfg.BotToken+"/sendMessage", nil, map[string]interface{}{
+			"chat_id": cfg.ChatID,
+			"text":    n.Message,
+		})
+	}
+	return fmt.Errorf("不支持的通知类型")
+}
+
+func postAlertJSON(target string, headers map[string]string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := alertHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("通知发送失败: HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func alertChannelToMap(ch model.AlertChannel) map[string]interface{} {
+	var cfg map[string]interface{}
+	_ = json.Unmarshal([]byte(ch.Config), &cfg)
+	return map[string]interface{}{
+		"id":          ch.ID,
+		"name":        ch.Name,
+		"type":        ch.Type,
+		"config":      cfg,
+		"enabled":     ch.Enabled,
+		"createdTime": ch.CreatedTime,
+		"updatedTime": ch.UpdatedTime,
+	}
+}
+
+func (h *Handler) alertChannelList(w http.ResponseWriter, r *http.Request) {
+	channels, err := h.repo.ListAlertChannels()
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	items := make([]map[string]interface{}, 0, len(channels))
+	for _, ch := range channels {
+		items = append(items, alertChannelToMap(ch))
+	}
+	response.WriteJSON(w, response.OK(items))
+}
+
+func (h *Handler) alertChannelCreate(w http.ResponseWriter, r *http.Request) {
+	h.saveAlertChannel(w, r, false)
+}
+
+func (h *Handler) alertChannelUpdate(w http.ResponseWriter, r *http.Request) {
+	h.saveAlertChannel(w, r, true)
+}
+
+func (h *Handler) saveAlertChannel(w http.ResponseWriter, r *http.Request, update bool) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req map[string]interface{}
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	ch := model.AlertChannel{
+		Name:    strings.TrimSpace(asString(req["name"])),
+		Type:    strings.TrimSpace(asString(req["type"])),
+		Enabled: boolFlag(req["enabled"], true),
+	}
+	if ch.Name == "" {
+		response.WriteJSON(w, response.ErrDefault("通知渠道名称不能为空"))
+		return
+	}
+	cfg, err := normalizeAlertChannelConfig(ch.Type, req["config"])
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
+	ch.Config = cfg
+	now := time.Now().UnixMilli()
+	ch.UpdatedTime = now
+	if update {
+		ch.ID = asInt64(req["id"], 0)
+		existing, err := h.repo.GetAlertChannel(ch.ID)
+		if err != nil {
+			response.WriteJSON(w, response.Err(-2, err.Error()))
+			return
+		}
+		if existing == nil {
+			response.WriteJSON(w, response.ErrDefault("通知渠道不存在"))
+			return
+		}
+		ch.CreatedTime = existing.CreatedTime
+	} else {
+		ch.CreatedTime = now
+	}
+	if err := h.repo.SaveAlertChannel(&ch); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(alertChannelToMap(ch)))
+}
+
+func (h *Handler) alertChannelDelete(w http.ResponseWriter, r *http.Request) {
+	id := idFromBody(r, w)
+	if id <= 0 {
+		return
+	}
+	if err := h.repo.DeleteAlertChannel(id); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OKEmpty())
+}
+
+// alertChannelTest sends a test message and reports the delivery error.
+func (h *Handler) alertChannelTest(w http.ResponseWriter, r *http.Request) {
+	id := idFromBody(r, w)
+	if id <= 0 {
+		return
+	}
+	ch, err := h.repo.GetAlertChannel(id)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if ch == nil {
+		response.WriteJSON(w, response.ErrDefault("通知渠道不存在"))
+		return
+	}
+	err = sendAlertNotification(*ch, alertNotification{
+		Status:  "test",
+		Message: "[测试] 这是一条来自面板的测试通知",
+		Time:    time.Now().UnixMilli(),
+	})
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OKEmpty())
+}
@@ -0,0 +1,265 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go-backend/internal/store/model"
+	"go-backend/internal/store/repo"
+)
+
+func setupAlertTest(t *testing.T) (*Handler, *repo.Repository, chan alertNotification) {
+	t.Helper()
+	r, err := repo.Open(filepath.Join(t.TempDir(), "alert.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = r.Close() })
+	h := New(r, "secret")
+
+	received := make(chan alertNotification, 8)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var n alertNotification
+		_ = json.NewDecoder(req.Body).Decode(&n)
+		if req.Header.Get("X-Token") != "abc" {
+			n.Status = "missing header"
+		}
+		received <- n
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg, err := normalizeAlertChannelConfig(alertChannelWebhook, map[string]interface{}{
+		"url": srv.URL, "headers": map[string]interface{}{"X-Token": "abc"},
+	})
+	if err != nil {
+		t.Fatalf("normalize webhook config: %v", err)
+	}
+	if err := r.SaveAlertChannel(&model.AlertChannel{Name: "hook", Type: alertChannelWebhook, Config: cfg, Enabled: 1}); err != nil {
+		t.Fatalf("save channel: %v", err)
+	}
+	return h, r, received
+}
+
+func expectNotification(t *testing.T, received chan alertNotification, status string) alertNotification {
+	t.Helper()
+	select {
+	case n := <-received:
+		if n.Status != status {
+			t.Fatalf("expected %s notification, got %+v", status, n)
+		}
+		return n
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected %s notification", status)
+	}
+	return alertNotification{}
+}
+
+func expectNoNotification(t *testing.T, received chan alertNotification) {
+	t.Helper()
+	select {
+	case n := <-received:
+		t.Fatalf("unexpected notification %+v", n)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestAlertOfflineRuleFiresAndRecovers(t *testing.T) {
+	h, r, received := setupAlertTest(t)
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	// The node was edited after it went offline; the offline time still
+	// counts from the disconnect.
+	if err := r.DB().Exec(`INSERT INTO node(id, name, secret, server_ip, port, version, created_time, updated_time, disconnected_time, status) VALUES(1, 'n1', 's1', '1.1.1.1', '1000-2000', '1.0.0', ?, ?, ?, 0)`,
+		now.UnixMilli(), now.UnixMilli(), now.Add(-30*time.Second).UnixMilli()).Error; err != nil {
+		t.Fatalf("insert node: %v", err)
+	}
+	rule := model.AlertRule{Name: "offline", Type: alertRuleOffline, Duration: 60, RecoverDuration: 30, ChannelIDs: "1", NotifyRecovery: 1, Enabled: 1}
+	if err := r.SaveAlertRule(&rule); err != nil {
+		t.Fatalf("save rule: %v", err)
+	}
+
+	h.runAlertJob(now)
+	expectNoNotification(t, received)
+
+	h.runAlertJob(now.Add(40 * time.Second))
+	n := expectNotification(t, received, "firing")
+	if n.NodeID != 1 || n.RuleID != rule.ID {
+		t.Fatalf("unexpected notification: %+v", n)
+	}
+	h.runAlertJob(now.Add(50 * time.Second))
+	expectNoNotification(t, received)
+
+	// Back online, but a short flap does not resolve the alert.
+	if err := r.DB().Exec(`UPDATE node SET status = 1 WHERE id = 1`).Error; err != nil {
+		t.Fatalf("update node: %v", err)
+	}
+	h.runAlertJob(now.Add(60 * time.Second))
+	if err := r.DB().Exec(`UPDATE node SET status = 0, disconnected_time = ? WHERE id = 1`, now.Add(-time.Hour).UnixMilli()).Error; err != nil {
+		t.Fatalf("update node: %v", err)
+	}
+	h.runAlertJob(now.Add(70 * time.Second))
+	if err := r.DB().Exec(`UPDATE node SET status = 1 WHERE id = 1`).Error; err != nil {
+		t.Fatalf("update node: %v", err)
+	}
+	h.runAlertJob(now.Add(80 * time.Second))
+	h.runAlertJob(now.Add(100 * time.Second))
+	expectNoNotification(t, received)
+	if n := mustQueryInt(t, r, `SELECT COUNT(1) FROM alert_event WHERE resolved_time = 0`); n != 1 {
+		t.Fatalf("expected one open event, got %d", n)
+	}
+
+	h.runAlertJob(now.Add(110 * time.Second))
+	expectNotification(t, received, "resolved")
+	if n := mustQueryInt(t, r, `SELECT COUNT(1) FROM alert_event WHERE resolved_time > 0`); n != 1 {
+		t.Fatalf("expected the event to be resolved, got %d", n)
+	}
+}
+
+func TestAlertCPURuleHoldsBeforeFiring(t *testing.T) {
+	h, r, received := setupAlertTest(t)
+	now := time.Now()
+	if err := r.DB().Exec(`INSERT INTO node(id, name, secret, server_ip, port, version, created_time, status) VALUES(1, 'n1', 's1', '1.1.1.1', '1000-2000', '1.0.0', ?, 1)`, now.UnixMilli()).Error; err != nil {
+		t.Fatalf("insert node: %v", err)
+	}
+	rule := model.AlertRule{Name: "cpu", Type: alertRuleCPU, Threshold: 90, Duration: 60, ChannelIDs: "1", Enabled: 1}
+	if err := r.SaveAlertRule(&rule); err != nil {
+		t.Fatalf("save rule: %v", err)
+	}
+	report := func(at time.Duration, cpu float64) {
+		h.recordNodeInfo(1, fmt.Sprintf(`{"cpu_usage":%v,"memory_usage":10}`, cpu), now.Add(at))
+		h.runAlertJob(now.Add(at))
+	}
+
+	report(0, 95)
+	report(30*time.Second, 50) // dips below, the hold restarts
+	report(40*time.Second, 95)
+	report(90*time.Second, 96)
+	expectNoNotification(t, received)
+	report(100*time.Second, 97)
+	n := expectNotification(t, received, "firing")
+	if n.Value != 97 {
+		t.Fatalf("expected value 97, got %+v", n)
+	}
+
+	// Without recovery notifications the alert resolves quietly.
+	report(110*time.Second, 10)
+	report(200*time.Second, 10)
+	expectNoNotification(t, received)
+	if n := mustQueryInt(t, r, `SELECT COUNT(1) FROM alert_event WHERE resolved_time > 0`); n != 1 {
+		t.Fatalf("expected the event to be resolved, got %d", n)
+	}
+}
+
+func TestAlertVersionMismatchAndRestart(t *testing.T) {
+	h, r, received := setupAlertTest(t)
+	now := time.Now()
+	if err := r.DB().Exec(`INSERT INTO node(id, name, secret, server_ip, port, version, created_time, status) VALUES(1, 'n1', 's1', '1.1.1.1', '1000-2000', 'v1.0.0', ?, 1), (2, 'n2', 's2', '2.2.2.2', '1000-2000', '1.1.0', ?, 1)`,
+		now.UnixMilli(), now.UnixMilli()).Error; err != nil {
+		t.Fatalf("insert nodes: %v", err)
+	}
+	rule := model.AlertRule{Name: "version", Type: alertRuleVersion, ExpectedVersion: "1.1.0", ChannelIDs: "1", NotifyRecovery: 1, Enabled: 1}
+	if err := r.SaveAlertRule(&rule); err != nil {
+		t.Fatalf("save rule: %v", err)
+	}
+
+	h.runAlertJob(now)
+	if n := expectNotification(t, received, "firing"); n.NodeID != 1 {
+		t.Fatalf("expected node 1 to mismatch, got %+v", n)
+	}
+	expectNoNotification(t, received)
+
+	// A restarted panel picks the open alert up and still resolves it.
+	restarted := New(r, "secret")
+	if err := r.DB().Exec(`UPDATE node SET version = '1.1.0' WHERE id = 1`).Error; err != nil {
+		t.Fatalf("update node: %v", err)
+	}
+	restarted.runAlertJob(now.Add(10 * time.Second))
+	restarted.runAlertJob(now.Add(80 * time.Second))
+	expectNotification(t, received, "resolved")
+}
+
+func TestAlertNotificationFailureIsRecorded(t *testing.T) {
+	h, r, received := setupAlertTest(t)
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+	cfg, err := normalizeAlertChannelConfig(alertChannelWebhook, map[string]interface{}{"url": broken.URL})
+	if err != nil {
+		t.Fatalf("normalize webhook config: %v", err)
+	}
+	if err := r.SaveAlertChannel(&model.AlertChannel{Name: "broken", Type: alertChannelWebhook, Config: cfg, Enabled: 1}); err != nil {
+		t.Fatalf("save channel: %v", err)
+	}
+	now := time.Now()
+	if err := r.DB().Exec(`INSERT INTO node(id, name, secret, server_ip, port, version, created_time, status) VALUES(1, 'n1', 's1', '1.1.1.1', '1000-2000', '1.0.0', ?, 1)`, now.UnixMilli()).Error; err != nil {
+		t.Fatalf("insert node: %v", err)
+	}
+	rule := model.AlertRule{Name: "version", Type: alertRuleVersion, ExpectedVersion: "1.1.0", ChannelIDs: "1,2", NotifyRecovery: 1, Enabled: 1}
+	if err := r.SaveAlertRule(&rule); err != nil {
+		t.Fatalf("save rule: %v", err)
+	}
+	delivery := func(want string) string {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			var status, errText string
+			if err := r.DB().Raw(`SELECT notify_status, notify_error FROM alert_event WHERE node_id = 1`).Row().Scan(&status, &errText); err != nil {
+				t.Fatalf("query event: %v", err)
+			}
+			if status == want {
+				return errText
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected delivery status %q, got %q (%s)", want, status, errText)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	h.runAlertJob(now)
+	expectNotification(t, received, "firing")
+	if errText := delivery(alertNotifyFailed); !strings.Contains(errText, "broken") || !strings.Contains(errText, "HTTP 500") {
+		t.Fatalf("expected the failing channel to be recorded, got %q", errText)
+	}
+
+	// The recovery notification records its own outcome.
+	rule.ChannelIDs = "1"
+	if err := r.SaveAlertRule(&rule); err != nil {
+		t.Fatalf("update rule: %v", err)
+	}
+	if err := r.DB().Exec(`UPDATE node SET version = '1.1.0' WHERE id = 1`).Error; err != nil {
+		t.Fatalf("update node: %v", err)
+	}
+	h.runAlertJob(now.Add(10 * time.Second))
+	h.runAlertJob(now.Add(80 * time.Second))
+	expectNotification(t, received, "resolved")
+	if errText := delivery(alertNotifySent); errText != "" {
+		t.Fatalf("expected no delivery error, got %q", errText)
+	}
+}
+
+func TestNormalizeAlertChannelConfig(t *testing.T) {
+	got, err := normalizeAlertChannelConfig(alertChannelTelegram, `{"botToken":" 123:abc ","chatId":"42","url":"ignored"}`)
+	if err != nil || got != `{"botToken":"123:abc","chatId":"42"}` {
+		t.Fatalf("unexpected telegram config %q (%v)", got, err)
+	}
+	for _, bad := range []struct {
+		typ string
+		raw interface{}
+	}{
+		{alertChannelWebhook, `{"url":"ftp://example.com"}`},
+		{alertChannelTelegram, `{"botToken":"x"}`},
+		{"email", `{}`},
+		{alertChannelWebhook, nil},
+	} {
+		if _, err := normalizeAlertChannelConfig(bad.typ, bad.raw); err == nil {
+			t.Fatalf("expected %s %v to be rejected", bad.typ, bad.raw)
+		}
+	}
+}
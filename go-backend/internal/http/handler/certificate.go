@@ -0,0 +1,479 @@
+package handler
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/security"
+	"go-backend/internal/store/model"
+)
+
+// Certificates terminate TLS for HTTP reverse proxy forwards. The panel keeps
+// the PEM encrypted with its secret and pushes it to the entry nodes as
+// certs/cert_<id>.{crt,key} right before the services that reference it.
+const (
+	certificateSourceUpload = "upload"
+	certificateSourceACME   = "acme"
+
+	certificateStatusPending = 0
+	certificateStatusIssued  = 1
+
+	maxCertificateDomains = 100
+)
+
+func certificateAgentName(id int64) string {
+	return fmt.Sprintf("cert_%d", id)
+}
+
+func certificateAgentPaths(id int64) (string, string) {
+	name := certificateAgentName(id)
+	return "certs/" + name + ".crt", "certs/" + name + ".key"
+}
+
+func (h *Handler) certificateCrypto() (*security.AESCrypto, error) {
+	crypto, err := security.NewAESCrypto(h.jwtSecret)
+	if err != nil {
+		return nil, errors.New("未配置 JWT_SECRET，无法加密存储证书")
+	}
+	return crypto, nil
+}
+
+// parseCertificatePair validates a PEM certificate chain against its key and
+// returns the names and validity of the leaf.
+func parseCertificatePair(certPEM, keyPEM string) ([]string, time.Time, time.Time, error) {
+	pair, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		return nil, time.Time{}, time.Time{}, fmt.Errorf("证书或私钥无效: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, time.Time{}, time.Time{}, fmt.Errorf("证书无效: %v", err)
+	}
+	domains := make([]string, 0, len(leaf.DNSNames)+1)
+	for _, name := range leaf.DNSNames {
+		domains = append(domains, strings.ToLower(name))
+	}
+	if len(domains) == 0 && leaf.Subject.CommonName != "" {
+		domains = append(domains, strings.ToLower(leaf.Subject.CommonName))
+	}
+	if len(domains) == 0 {
+		return nil, time.Time{}, time.Time{}, errors.New("证书未包含域名")
+	}
+	return domains, leaf.NotBefore, leaf.NotAfter, nil
+}
+
+// parseCertificateDomains parses a newline/comma/space separated domain list.
+func parseCertificateDomains(raw string) ([]string, error) {
+	fields := strings.FieldsFunc(raw, func(r rune) bool {
+		return r == ',' || r == ';' || r == '\n' || r == '\r' || r == ' ' || r == '\t'
+	})
+	if len(fields) > maxCertificateDomains {
+		return nil, fmt.Errorf("域名最多 %d 个", maxCertificateDomains)
+	}
+	seen := make(map[string]struct{}, len(fields))
+	out := make([]string, 0, len(fields))
+	for _, field := range fields {
+		domain, err := normalizeForwardDomain(field)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := seen[domain]; ok {
+			continue
+		}
+		seen[domain] = struct{}{}
+		out = append(out, domain)
+	}
+	return out, nil
+}
+
+// certificateCoversDomain matches a forward domain against certificate
+// names; a wildcard name covers exactly one extra label.
+func certificateCoversDomain(names []string, domain string) bool {
+	for _, name := range names {
+		if name == domain {
+			return true
+		}
+		if suffix, ok := strings.CutPrefix(name, "*."); ok && !strings.HasPrefix(domain, "*.") {
+			if i := strings.IndexByte(domain, '.'); i > 0 && domain[i+1:] == suffix {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// storeCertificateMaterial encrypts and saves a certificate pair.
+func (h *Handler) storeCertificateMaterial(id int64, certPEM, keyPEM string) error {
+	domains, notBefore, notAfter, err := parseCertificatePair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	crypto, err := h.certificateCrypto()
+	if err != nil {
+		return err
+	}
+	certData, err := crypto.Encrypt([]byte(certPEM))
+	if err != nil {
+		return err
+	}
+	keyData, err := crypto.Encrypt([]byte(keyPEM))
+	if err != nil {
+		return err
+	}
+	return h.repo.UpdateCertificateMaterial(id, strings.Join(domains, "\n"), certData, keyData,
+		notBefore.UnixMilli(), notAfter.UnixMilli(), time.Now().UnixMilli())
+}
+
+// loadCertificatePEM decrypts the stored pair of an issued certificate.
+func (h *Handler) loadCertificatePEM(cert *model.Certificate) (string, string, error) {
+	if cert == nil || cert.CertData == "" || cert.KeyData == "" {
+		return "", "", errors.New("证书尚未签发")
+	}
+	crypto, err := h.certificateCrypto()
+	if err != nil {
+		return "", "", err
+	}
+	certPEM, err := crypto.Decrypt(cert.CertData)
+	if err != nil {
+		return "", "", fmt.Errorf("证书解密失败: %v", err)
+	}
+	keyPEM, err := crypto.Decrypt(cert.KeyData)
+	if err != nil {
+		return "", "", fmt.Errorf("证书解密失败: %v", err)
+	}
+	return string(certPEM), string(keyPEM), nil
+}
+
+// pushCertificate writes the certificate files on a node ahead of the
+// services that reference them.
+func (h *Handler) pushCertificate(nodeID, certificateID int64) error {
+	cert, err := h.repo.GetCertificate(certificateID)
+	if err != nil {
+		return err
+	}
+	if cert == nil {
+		return errors.New("证书不存在")
+	}
+	certPEM, keyPEM, err := h.loadCertificatePEM(cert)
+	if err != nil {
+		return err
+	}
+	_, err = h.sendNodeCommand(nodeID, "UpdateCertificates", []map[string]interface{}{{
+		"name": certificateAgentName(cert.ID),
+		"cert": certPEM,
+		"key":  keyPEM,
+	}}, false, false)
+	return err
+}
+
+// deployCertificate rolls a renewed certificate out to its forwards. The
+// agent loads certificates when a listener starts, so the services are
+// re-synced.
+func (h *Handler) deployCertificate(certificateID int64) error {
+	forwardIDs, err := h.repo.ListForwardIDsByCertificate(certificateID)
+	if err != nil {
+		return err
+	}
+	var errs []string
+	for _, forwardID := range forwardIDs {
+		forward, err := h.getForwardRecord(forwardID)
+		if err != nil || forward == nil {
+			continue
+		}
+		if err := h.resyncForwardServices(forward); err != nil {
+			errs = append(errs, fmt.Sprintf("转发 %s: %v", forward.Name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// resyncForwardServices re-sends a forward's services. UpdateService starts
+// them, so a paused forward is paused again afterwards.
+func (h *Handler) resyncForwardServices(forward *forwardRecord) error {
+	if err := h.syncForwardServices(forward, "UpdateService", true); err != nil {
+		return err
+	}
+	if forward.Status != 1 {
+		return h.controlForwardServices(forward, "PauseService", true)
+	}
+	return nil
+}
+
+func certificateView(cert model.Certificate, forwardCount int) map[string]interface{} {
+	domains := []string{}
+	if cert.Domains != "" {
+		domains = strings.Split(cert.Domains, "\n")
+	}
+	return map[string]interface{}{
+		"id":            cert.ID,
+		"name":          cert.Name,
+		"domains":       domains,
+		"source":        cert.Source,
+		"notBefore":     cert.NotBefore,
+		"notAfter":      cert.NotAfter,
+		"acmeDirectory": cert.ACMEDirectory,
+		"acmeEmail":     cert.ACMEEmail,
+		"status":        cert.Status,
+		"lastError":     cert.LastError,
+		"forwardCount":  forwardCount,
+		"createdTime":   cert.CreatedTime,
+		"updatedTime":   cert.UpdatedTime,
+	}
+}
+
+func (h *Handler) certificateList(w http.ResponseWriter, r *http.Request) {
+	certs, err := h.repo.ListCertificates()
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	items := make([]map[string]interface{}, 0, len(certs))
+	for _, cert := range certs {
+		forwardIDs, err := h.repo.ListForwardIDsByCertificate(cert.ID)
+		if err != nil {
+			response.WriteJSON(w, response.Err(-2, err.Error()))
+			return
+		}
+		items = append(items, certificateView(cert, len(forwardIDs)))
+	}
+	response.WriteJSON(w, response.OK(items))
+}
+
+func (h *Handler) certificateCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req map[string]interface{}
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	name := asString(req["name"])
+	if name == "" {
+		response.WriteJSON(w, response.ErrDefault("证书名称不能为空"))
+		return
+	}
+	if _, err := h.certificateCrypto(); err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
+
+	now := time.Now().UnixMilli()
+	cert := &model.Certificate{
+		Name:        name,
+		Source:      defaultString(asString(req["source"]), certificateSourceUpload),
+		Status:      certificateStatusPending,
+		CreatedTime: now,
+		UpdatedTime: now,
+	}
+	switch cert.Source {
+	case certificateSourceUpload:
+		certPEM, keyPEM := asString(req["cert"]), asString(req["key"])
+		domains, _, _, err := parseCertificatePair(certPEM, keyPEM)
+		if err != nil {
+			response.WriteJSON(w, response.ErrDefault(err.Error()))
+			return
+		}
+		cert.Domains = strings.Join(domains, "\n")
+		id, err := h.repo.CreateCertificate(cert)
+		if err != nil {
+			response.WriteJSON(w, response.Err(-2, err.Error()))
+			return
+		}
+		if err := h.storeCertificateMaterial(id, certPEM, keyPEM); err != nil {
+			_ = h.repo.DeleteCertificate(id)
+			response.WriteJSON(w, response.ErrDefault(err.Error()))
+			return
+		}
+		response.WriteJSON(w, response.OK(map[string]interface{}{"id": id}))
+
+	case certificateSourceACME:
+		domains, err := parseCertificateDomains(asString(req["domains"]))
+		if err != nil {
+			response.WriteJSON(w, response.ErrDefault(err.Error()))
+			return
+		}
+		if err := validateACMEDomains(domains); err != nil {
+			response.WriteJSON(w, response.ErrDefault(err.Error()))
+			return
+		}
+		cert.Domains = strings.Join(domains, "\n")
+		cert.ACMEDirectory = asString(req["acmeDirectory"])
+		cert.ACMEEmail = asString(req["acmeEmail"])
+		id, err := h.repo.CreateCertificate(cert)
+		if err != nil {
+			response.WriteJSON(w, response.Err(-2, err.Error()))
+			return
+		}
+		h.startACMEIssue(id, asInt64Slice(req["nodeIds"]))
+		response.WriteJSON(w, response.OK(map[string]interface{}{"id": id}))
+
+	default:
+		response.WriteJSON(w, response.ErrDefault("不支持的证书来源"))
+	}
+}
+
+// certificateUpdate replaces the pair of an uploaded certificate and rolls
+// it out to the forwards using it.
+func (h *Handler) certificateUpdate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req map[string]interface{}
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	id := asInt64(req["id"], 0)
+	cert, err := h.repo.GetCertificate(id)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if cert == nil {
+		response.WriteJSON(w, response.ErrDefault("证书不存在"))
+		return
+	}
+	if cert.Source != certificateSourceUpload {
+		response.WriteJSON(w, response.ErrDefault("ACME 证书请使用重新签发"))
+		return
+	}
+	if err := h.storeCertificateMaterial(id, asString(req["cert"]), asString(req["key"])); err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
+	if err := h.deployCertificate(id); err != nil {
+		response.WriteJSON(w, response.ErrDefault("证书已保存，下发失败: "+err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OKEmpty())
+}
+
+// certificateIssue (re)issues an ACME certificate in the background.
+// nodeIds adds entry nodes to answer the HTTP-01 challenge, for
+// certificates not used by any forward yet.
+func (h *Handler) certificateIssue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req map[string]interface{}
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	id := asInt64(req["id"], 0)
+	cert, err := h.repo.GetCertificate(id)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if cert == nil {
+		response.WriteJSON(w, response.ErrDefault("证书不存在"))
+		return
+	}
+	if cert.Source != certificateSourceACME {
+		response.WriteJSON(w, response.ErrDefault("仅 ACME 证书支持签发"))
+		return
+	}
+	if !h.startACMEIssue(id, asInt64Slice(req["nodeIds"])) {
+		response.WriteJSON(w, response.ErrDefault("证书正在签发中"))
+		return
+	}
+	response.WriteJSON(w, response.OKEmpty())
+}
+
+func (h *Handler) certificateDelete(w http.ResponseWriter, r *http.Request) {
+	id := idFromBody(r, w)
+	if id <= 0 {
+		return
+	}
+	forwardIDs, err := h.repo.ListForwardIDsByCertificate(id)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if len(forwardIDs) > 0 {
+		response.WriteJSON(w, response.ErrDefault(fmt.Sprintf("证书仍被 %d 个转发使用", len(forwardIDs))))
+		return
+	}
+	if err := h.repo.DeleteCertificate(id); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OKEmpty())
+}
+
+// beginACMEIssue allows one issuance per certificate at a time.
+func (h *Handler) beginACMEIssue(certificateID int64) bool {
+	h.certMu.Lock()
+	defer h.certMu.Unlock()
+	if h.certIssuing == nil {
+		h.certIssuing = make(map[int64]struct{})
+	}
+	if _, ok := h.certIssuing[certificateID]; ok {
+		return false
+	}
+	h.certIssuing[certificateID] = struct{}{}
+	return true
+}
+
+func (h *Handler) endACMEIssue(certificateID int64) {
+	h.certMu.Lock()
+	delete(h.certIssuing, certificateID)
+	h.certMu.Unlock()
+}
+
+// startACMEIssue issues in the background; it reports false when an
+// issuance for the certificate is already running.
+func (h *Handler) startACMEIssue(certificateID int64, nodeIDs []int64) bool {
+	if !h.beginACMEIssue(certificateID) {
+		return false
+	}
+	go func() {
+		defer h.endACMEIssue(certificateID)
+		ctx, cancel := context.WithTimeout(context.Background(), acmeIssueTimeout)
+		defer cancel()
+		_ = h.renewACMECertificate(ctx, certificateID, nodeIDs)
+	}()
+	return true
+}
+
+// renewACMECertificate issues the certificate, records the outcome and rolls
+// a new certificate out to its forwards.
+func (h *Handler) renewACMECertificate(ctx context.Context, certificateID int64, nodeIDs []int64) error {
+	if err := h.issueACMECertificate(ctx, certificateID, nodeIDs); err != nil {
+		_ = h.repo.UpdateCertificateError(certificateID, err.Error(), time.Now().UnixMilli())
+		return err
+	}
+	return h.deployCertificate(certificateID)
+}
+
+func uniqueSortedIDs(groups ...[]int64) []int64 {
+	seen := make(map[int64]struct{})
+	out := make([]int64, 0)
+	for _, ids := range groups {
+		for _, id := range ids {
+			if _, ok := seen[id]; ok || id <= 0 {
+				continue
+			}
+			seen[id] = struct{}{}
+			out = append(out, id)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
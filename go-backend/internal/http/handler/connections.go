@@ -0,0 +1,335 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go-backend/internal/http/response"
+)
+
+// Live connections come from the agents' in-process connection tables.
+// Forward services are named <forwardId>_<userId>_<userTunnelId>_<tcp|udp>,
+// so a forward or user scope becomes a service name prefix on each node.
+const maxConnectionListLimit = 5000
+
+type connectionRequest struct {
+	NodeID    int64    `json:"nodeId"`
+	ForwardID int64    `json:"forwardId"`
+	UserID    int64    `json:"userId"`
+	Service   string   `json:"service"`
+	IDs       []string `json:"ids"`
+	Limit     int      `json:"limit"`
+}
+
+// connectionScope is the part of a connection request sent to one node.
+type connectionScope struct {
+	NodeID   int64
+	Services []string
+	Prefixes []string
+}
+
+func (s connectionScope) empty() bool {
+	return len(s.Services) == 0 && len(s.Prefixes) == 0
+}
+
+func forwardServicePrefix(forwardID, userID int64) string {
+	return fmt.Sprintf("%d_%d_", forwardID, userID)
+}
+
+// parseForwardServiceName extracts the forward and user ids from a forward
+// service name; ok is false for tunnel and other services.
+func parseForwardServiceName(name string) (forwardID, userID int64, ok bool) {
+	parts := strings.Split(name, "_")
+	if len(parts) != 4 || (parts[3] != "tcp" && parts[3] != "udp") {
+		return 0, 0, false
+	}
+	forwardID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	userID, err = strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return forwardID, userID, true
+}
+
+// connectionScopes resolves which nodes to ask and which services on each
+// node belong to the request.
+func (h *Handler) connectionScopes(req connectionRequest) ([]connectionScope, error) {
+	byNode := map[int64]*connectionScope{}
+	order := make([]int64, 0)
+	add := func(nodeID int64, prefix string) {
+		if req.NodeID > 0 && nodeID != req.NodeID {
+			return
+		}
+		scope, ok := byNode[nodeID]
+		if !ok {
+			scope = &connectionScope{NodeID: nodeID}
+			byNode[nodeID] = scope
+			order = append(order, nodeID)
+		}
+		if prefix != "" {
+			scope.Prefixes = append(scope.Prefixes, prefix)
+		}
+	}
+
+	switch {
+	case req.ForwardID > 0:
+		forward, err := h.getForwardRecord(req.ForwardID)
+		if err != nil {
+			return nil, err
+		}
+		if req.UserID > 0 && forward.UserID != req.UserID {
+			return nil, errForwardNotFound
+		}
+		ports, err := h.listForwardPorts(forward.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, port := range ports {
+			add(port.NodeID, forwardServicePrefix(forward.ID, forward.UserID))
+		}
+	case req.UserID > 0:
+		ports, err := h.repo.ListForwardPortsByUser(req.UserID)
+		if err != nil {
+			return nil, err
+		}
+		seen := map[string]struct{}{}
+		for _, port := range ports {
+			key := fmt.Sprintf("%d/%d", port.NodeID, port.ForwardID)
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			add(port.NodeID, forwardServicePrefix(port.ForwardID, req.UserID))
+		}
+	case req.NodeID > 0:
+		add(req.NodeID, "")
+	default:
+		return nil, errors.New("请指定节点、转发或用户")
+	}
+
+	scopes := make([]connectionScope, 0, len(order))
+	for _, nodeID := range order {
+		scope := *byNode[nodeID]
+		if service := strings.TrimSpace(req.Service); service != "" {
+			if !scope.empty() && !scopeMatches(scope, service) {
+				continue
+			}
+			scope = connectionScope{NodeID: nodeID, Services: []string{service}}
+		}
+		scopes = append(scopes, scope)
+	}
+	return scopes, nil
+}
+
+func scopeMatches(scope connectionScope, service string) bool {
+	for _, name := range scope.Services {
+		if name == service {
+			return true
+		}
+	}
+	for _, prefix := range scope.Prefixes {
+		if strings.HasPrefix(service, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) listConnections(req connectionRequest) (map[string]interface{}, error) {
+	scopes, err := h.connectionScopes(req)
+	if err != nil {
+		return nil, err
+	}
+	if req.Limit <= 0 || req.Limit > maxConnectionListLimit {
+		req.Limit = maxConnectionListLimit
+	}
+
+	connections := make([]map[string]interface{}, 0)
+	nodes := make([]map[string]interface{}, 0, len(scopes))
+	total := 0
+	for _, scope := range scopes {
+		nodeResult := map[string]interface{}{"nodeId": scope.NodeID}
+		nodes = append(nodes, nodeResult)
+		node, err := h.getNodeRecord(scope.NodeID)
+		if err != nil {
+			nodeResult["error"] = err.Error()
+			continue
+		}
+		nodeResult["nodeName"] = node.Name
+		if node.IsRemote == 1 {
+			nodeResult["error"] = "远程节点暂不支持查看连接"
+			continue
+		}
+		res, err := h.sendNodeCommand(node.ID, "ListConnections", map[string]interface{}{
+			"services": scope.Services,
+			"prefixes": scope.Prefixes,
+			"limit":    req.Limit,
+		}, false, false)
+		if err != nil {
+			nodeResult["error"] = err.Error()
+			continue
+		}
+		nodeTotal := int(asInt64(res.Data["total"], 0))
+		nodeResult["total"] = nodeTotal
+		total += nodeTotal
+
+		items, _ := res.Data["connections"].([]interface{})
+		for _, raw := range items {
+			item, ok := raw.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			item["nodeId"] = node.ID
+			item["nodeName"] = node.Name
+			if forwardID, userID, ok := parseForwardServiceName(asString(item["service"])); ok {
+				item["forwardId"] = forwardID
+				item["userId"] = userID
+			}
+			connections = append(connections, item)
+		}
+	}
+
+	sort.SliceStable(connections, func(i, j int) bool {
+		return asInt64(connections[i]["startTime"], 0) < asInt64(connections[j]["startTime"], 0)
+	})
+	return map[string]interface{}{
+		"total":       total,
+		"connections": connections,
+		"nodes":       nodes,
+	}, nil
+}
+
+func (h *Handler) closeConnections(req connectionRequest) (map[string]interface{}, error) {
+	scopes, err := h.connectionScopes(req)
+	if err != nil {
+		return nil, err
+	}
+	closed := 0
+	nodes := make([]map[string]interface{}, 0, len(scopes))
+	for _, scope := range scopes {
+		if scope.empty() && len(req.IDs) == 0 {
+			return nil, errors.New("请指定要断开的连接或服务")
+		}
+		nodeResult := map[string]interface{}{"nodeId": scope.NodeID}
+		nodes = append(nodes, nodeResult)
+		node, err := h.getNodeRecord(scope.NodeID)
+		if err != nil {
+			nodeResult["error"] = err.Error()
+			continue
+		}
+		if node.IsRemote == 1 {
+			nodeResult["error"] = "远程节点暂不支持断开连接"
+			continue
+		}
+		res, err := h.sendNodeCommand(node.ID, "CloseConnections", map[string]interface{}{
+			"services": scope.Services,
+			"prefixes": scope.Prefixes,
+			"ids":      req.IDs,
+		}, false, false)
+		if err != nil {
+			nodeResult["error"] = err.Error()
+			continue
+		}
+		n := int(asInt64(res.Data["closed"], 0))
+		nodeResult["closed"] = n
+		closed += n
+	}
+	return map[string]interface{}{"closed": closed, "nodes": nodes}, nil
+}
+
+func (h *Handler) decodeConnectionRequest(w http.ResponseWriter, r *http.Request) (connectionRequest, bool) {
+	var req connectionRequest
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return req, false
+	}
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return req, false
+	}
+	return req, true
+}
+
+func writeConnectionResult(w http.ResponseWriter, result map[string]interface{}, err error) {
+	if err != nil {
+		if errors.Is(err, errForwardNotFound) {
+			response.WriteJSON(w, response.ErrDefault("转发不存在"))
+			return
+		}
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(result))
+}
+
+// nodeConnections lists live connections by node, forward or user (admin).
+func (h *Handler) nodeConnections(w http.ResponseWriter, r *http.Request) {
+	req, ok := h.decodeConnectionRequest(w, r)
+	if !ok {
+		return
+	}
+	result, err := h.listConnections(req)
+	writeConnectionResult(w, result, err)
+}
+
+// nodeConnectionsClose closes connections by id, or every connection of a
+// service, forward or user (admin).
+func (h *Handler) nodeConnectionsClose(w http.ResponseWriter, r *http.Request) {
+	req, ok := h.decodeConnectionRequest(w, r)
+	if !ok {
+		return
+	}
+	result, err := h.closeConnections(req)
+	writeConnectionResult(w, result, err)
+}
+
+// forwardConnections lists the live connections of a forward the caller can access.
+func (h *Handler) forwardConnections(w http.ResponseWriter, r *http.Request) {
+	req, ok := h.forwardConnectionRequest(w, r)
+	if !ok {
+		return
+	}
+	result, err := h.listConnections(req)
+	writeConnectionResult(w, result, err)
+}
+
+// forwardConnectionsClose closes connections of a forward the caller can access.
+func (h *Handler) forwardConnectionsClose(w http.ResponseWriter, r *http.Request) {
+	req, ok := h.forwardConnectionRequest(w, r)
+	if !ok {
+		return
+	}
+	result, err := h.closeConnections(req)
+	writeConnectionResult(w, result, err)
+}
+
+// forwardConnectionRequest limits a request to the forward's own services.
+func (h *Handler) forwardConnectionRequest(w http.ResponseWriter, r *http.Request) (connectionRequest, bool) {
+	req, ok := h.decodeConnectionRequest(w, r)
+	if !ok {
+		return req, false
+	}
+	if req.ForwardID <= 0 {
+		response.WriteJSON(w, response.ErrDefault("参数错误"))
+		return req, false
+	}
+	forward, _, _, err := h.resolveForwardAccess(r, req.ForwardID)
+	if err != nil {
+		if errors.Is(err, errForwardNotFound) {
+			response.WriteJSON(w, response.ErrDefault("转发不存在"))
+			return req, false
+		}
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return req, false
+	}
+	req.UserID = forward.UserID
+	req.Service = ""
+	return req, true
+}
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net"
@@ -8,6 +9,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"go-backend/internal/http/client"
@@ -24,6 +26,143 @@ type nodeRecord = model.NodeRecord
 
 type chainNodeRecord = model.ChainNodeRecord
 
+// Traceroute settings used by the diagnose endpoints. Five rounds with a one
+// second probe timeout keep a trace well inside the node command timeout.
+const (
+	diagnoseTraceCount   = 5
+	diagnoseTraceMaxHops = 30
+	diagnoseTraceTimeout = 1000
+)
+
+// diagnoseResponseTimeout bounds a diagnose response. A check runs at most a
+// TCP ping, a UDP fallback probe and a traceroute, each limited by the node
+// command timeout, and all checks run at the same time.
+const diagnoseResponseTimeout = time.Minute
+
+// diagnosisResults runs the checks of one diagnosis concurrently, so a long
+// chain is not probed hop by hop, and returns their items in the order the
+// checks were added.
+type diagnosisResults struct {
+	wg    sync.WaitGroup
+	slots []*map[string]interface{}
+}
+
+func (d *diagnosisResults) add(check func() map[string]interface{}) {
+	slot := new(map[string]interface{})
+	d.slots = append(d.slots, slot)
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		*slot = check()
+	}()
+}
+
+func (d *diagnosisResults) wait() []map[string]interface{} {
+	d.wg.Wait()
+	items := make([]map[string]interface{}, 0, len(d.slots))
+	for _, slot := range d.slots {
+		items = append(items, *slot)
+	}
+	return items
+}
+
+// diagnosisNodeCache holds the nodes looked up by one diagnosis; its checks
+// share it from their goroutines.
+type diagnosisNodeCache struct {
+	mu    sync.Mutex
+	nodes map[int64]*nodeRecord
+}
+
+func extendDiagnoseDeadline(w http.ResponseWriter) {
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(diagnoseResponseTimeout))
+}
+
+// normalizeTraceProtocol validates the optional "trace" diagnose parameter.
+// An empty value disables the traceroute.
+func normalizeTraceProtocol(v string) (string, error) {
+	v = strings.ToLower(strings.TrimSpace(v))
+	switch v {
+	case "", "icmp", "udp", "tcp":
+		return v, nil
+	}
+	return "", errors.New("不支持的路由追踪协议")
+}
+
+// diagnoseOptions holds the optional diagnose parameters.
+type diagnoseOptions struct {
+	// Trace is the traceroute probe protocol; empty disables the traceroute.
+	Trace string
+	// Probe selects how forward targets are checked: auto, tcp, or one of the
+	// UDP probes (udp echo, dns, stun). Chain hops are always checked over TCP.
+	Probe string
+	// Payload and PayloadHex set the UDP echo payload.
+	Payload    string
+	PayloadHex string
+}
+
+// UDP probe settings. A silent UDP target costs count*timeout, so the probe
+// is kept shorter than the TCP ping.
+const (
+	diagnoseUDPProbeCount   = 3
+	diagnoseUDPProbeTimeout = 1500
+	maxDiagnoseProbePayload = 1400
+)
+
+// normalizeDiagnoseProbe validates the optional "probe" diagnose parameter.
+// An empty value means auto.
+func normalizeDiagnoseProbe(v string) (string, error) {
+	v = strings.ToLower(strings.TrimSpace(v))
+	switch v {
+	case "", "auto":
+		return "auto", nil
+	case "tcp", "udp", "dns", "stun":
+		return v, nil
+	}
+	return "", errors.New("不支持的探测方式")
+}
+
+// parseDiagnoseOptions reads trace, probe and payload from a diagnose body.
+func parseDiagnoseOptions(req map[string]interface{}) (diagnoseOptions, error) {
+	trace, err := normalizeTraceProtocol(asString(req["trace"]))
+	if err != nil {
+		return diagnoseOptions{}, err
+	}
+	probe, err := normalizeDiagnoseProbe(asString(req["probe"]))
+	if err != nil {
+		return diagnoseOptions{}, err
+	}
+	opts := diagnoseOptions{
+		Trace:      trace,
+		Probe:      probe,
+		Payload:    asString(req["payload"]),
+		PayloadHex: strings.TrimSpace(asString(req["payloadHex"])),
+	}
+	size := len(opts.Payload)
+	if opts.PayloadHex != "" {
+		raw, err := hex.DecodeString(opts.PayloadHex)
+		if err != nil {
+			return diagnoseOptions{}, errors.New("探测负载不是有效的十六进制")
+		}
+		size = len(raw)
+	}
+	if size > maxDiagnoseProbePayload {
+		return diagnoseOptions{}, fmt.Errorf("探测负载不能超过%d字节", maxDiagnoseProbePayload)
+	}
+	return opts, nil
+}
+
+// udpProbeModeForPort picks the UDP probe that a service on the well-known
+// port will answer; anything else gets the echo probe.
+func udpProbeModeForPort(port int) string {
+	switch port {
+	case 53, 5353:
+		return "dns"
+	case 3478, 3479, 5349, 19302:
+		return "stun"
+	}
+	return "udp"
+}
+
 type diagnosisTarget struct {
 	Address string
 	IP      string
@@ -140,10 +279,6 @@ func (h *Handler) syncForwardServices(forward *forwardRecord, method string, all
 		return errors.New("invalid forward sync context")
 	}
 
-	tunnel, err := h.getTunnelRecord(forward.TunnelID)
-	if err != nil {
-		return err
-	}
 	ports, err := h.listForwardPorts(forward.ID)
 	if err != nil {
 		return err
@@ -151,6 +286,16 @@ func (h *Handler) syncForwardServices(forward *forwardRecord, method string, all
 	if len(ports) == 0 {
 		return errors.New("转发入口端口不存在")
 	}
+	return h.syncForwardServicesOnPorts(forward, ports, method, allowFallbackAdd)
+}
+
+// syncForwardServicesOnPorts is syncForwardServices limited to the nodes of
+// ports.
+func (h *Handler) syncForwardServicesOnPorts(forward *forwardRecord, ports []forwardPortRecord, method string, allowFallbackAdd bool) error {
+	tunnel, err := h.getTunnelRecord(forward.TunnelID)
+	if err != nil {
+		return err
+	}
 
 	userTunnelID, limiterID, speed, err := h.resolveUserTunnelAndLimiter(forward.UserID, forward.TunnelID)
 	if err != nil {
@@ -171,7 +316,28 @@ func (h *Handler) syncForwardServices(forward *forwardRecord, method string, all
 		if err != nil {
 			return err
 		}
+		admissions, err := h.pushForwardAdmissions(forward, node, serviceBase)
+		if err != nil {
+			return fmt.Errorf("节点 %s 下发失败: %w", node.Name, err)
+		}
+		if forward.ProxyMode == forwardProxyModeHTTP && forward.CertificateID > 0 {
+			if err := h.pushCertificate(node.ID, forward.CertificateID); err != nil {
+				return fmt.Errorf("节点 %s 证书下发失败: %w", node.Name, err)
+			}
+		}
 		services := buildForwardServiceConfigs(serviceBase, forward, tunnel, node, fp.Port, limiterID, tunnelTLSProtocol)
+		if len(admissions) > 0 {
+			for _, service := range services {
+				service["admissions"] = admissions
+			}
+		}
+		protocolScopes, err := h.forwardProtocolScopes(forward, node.ID)
+		if err == nil {
+			err = applyForwardProtocolRules(services, protocolScopes)
+		}
+		if err != nil {
+			return fmt.Errorf("节点 %s 下发失败: %w", node.Name, err)
+		}
 		_, err = h.sendNodeCommand(node.ID, method, services, true, false)
 		if err != nil && allowFallbackAdd && method == "UpdateService" {
 			_, err = h.sendNodeCommand(node.ID, "AddService", services, true, false)
@@ -179,6 +345,13 @@ func (h *Handler) syncForwardServices(forward *forwardRecord, method string, all
 		if err != nil {
 			return fmt.Errorf("节点 %s 下发失败: %w", node.Name, err)
 		}
+		if (forward.Domain != "" || forward.ProxyMode == forwardProxyModeHTTP) && method == "UpdateService" {
+			// A forward switched to shared or HTTP proxy mode leaves its UDP
+			// service behind.
+			_, _ = h.sendNodeCommand(node.ID, "DeleteService", map[string]interface{}{
+				"services": []string{serviceBase + "_udp"},
+			}, false, true)
+		}
 	}
 	return nil
 }
@@ -191,6 +364,12 @@ func (h *Handler) controlForwardServices(forward *forwardRecord, commandType str
 	if err != nil {
 		return err
 	}
+	return h.controlForwardServicesOnPorts(forward, ports, commandType, tolerateNotFound)
+}
+
+// controlForwardServicesOnPorts is controlForwardServices limited to the
+// nodes of ports.
+func (h *Handler) controlForwardServicesOnPorts(forward *forwardRecord, ports []forwardPortRecord, commandType string, tolerateNotFound bool) error {
 	if len(ports) == 0 {
 		return nil
 	}
@@ -281,23 +460,37 @@ func (h *Handler) sendNodeCommand(nodeID int64, commandType string, data interfa
 	if nodeErr == nil && node != nil && node.IsRemote == 1 {
 		result, err = h.sendRemoteNodeCommand(node, commandType, data)
 	} else {
+		if queued, qErr := h.enqueueBehindReplay(nodeID, commandType, data, tolerateExists, tolerateNotFound); qErr == nil && queued {
+			return queuedCommandResult(commandType), nil
+		}
 		result, err = h.wsServer.SendCommand(nodeID, commandType, data, 12*time.Second)
+		if isNodeOfflineError(err) && nodeErr == nil && node != nil {
+			if queued, qErr := h.enqueueNodeCommand(nodeID, commandType, data, tolerateExists, tolerateNotFound); qErr == nil && queued {
+				return queuedCommandResult(commandType), nil
+			}
+		}
 	}
+	return result, tolerateNodeCommandError(err, tolerateExists, tolerateNotFound)
+}
+
+// tolerateNodeCommandError drops "already exists" and "not found" failures
+// the caller asked to ignore.
+func tolerateNodeCommandError(err error, tolerateExists bool, tolerateNotFound bool) error {
 	if err == nil {
-		return result, nil
+		return nil
 	}
 	msg := strings.ToLower(strings.TrimSpace(err.Error()))
 	if tolerateExists {
 		if strings.Contains(msg, "exists") || strings.Contains(msg, "already") || strings.Contains(msg, "已存在") {
-			return result, nil
+			return nil
 		}
 	}
 	if tolerateNotFound {
 		if strings.Contains(msg, "not found") || strings.Contains(msg, "不存在") {
-			return result, nil
+			return nil
 		}
 	}
-	return result, err
+	return err
 }
 
 func (h *Handler) sendRemoteNodeCommand(node *nodeRecord, commandType string, data interface{}) (ws.CommandResult, error) {
@@ -338,7 +531,7 @@ func (h *Handler) sendRemoteNodeCommand(node *nodeRecord, commandType string, da
 	return result, nil
 }
 
-func (h *Handler) diagnoseForwardRuntime(forward *forwardRecord) (map[string]interface{}, error) {
+func (h *Handler) diagnoseForwardRuntime(forward *forwardRecord, opts diagnoseOptions) (map[string]interface{}, error) {
 	if forward == nil {
 		return nil, errForwardNotFound
 	}
@@ -363,17 +556,17 @@ func (h *Handler) diagnoseForwardRuntime(forward *forwardRecord) (map[string]int
 	ipPreference := h.repo.GetTunnelIPPreference(forward.TunnelID)
 
 	inNodes, chainHops, outNodes := splitChainNodeGroups(chainRows)
-	results := make([]map[string]interface{}, 0, len(chainRows)*2+len(targets))
-	nodeCache := map[int64]*nodeRecord{}
+	results := &diagnosisResults{}
+	nodeCache := &diagnosisNodeCache{nodes: map[int64]*nodeRecord{}}
 
 	switch tunnel.Type {
 	case 1:
 		for _, inNode := range inNodes {
 			for _, target := range targets {
 				description := fmt.Sprintf("入口(%s)->目标(%s)", inNode.NodeName, target.Address)
-				h.appendPathDiagnosis(&results, nodeCache, inNode.NodeID, target.IP, target.Port, description, map[string]interface{}{
+				h.appendTargetDiagnosis(results, nodeCache, inNode.NodeID, target.IP, target.Port, description, map[string]interface{}{
 					"fromChainType": 1,
-				})
+				}, opts)
 			}
 		}
 	case 2:
@@ -381,19 +574,19 @@ func (h *Handler) diagnoseForwardRuntime(forward *forwardRecord) (map[string]int
 			if len(chainHops) > 0 {
 				for _, firstNode := range chainHops[0] {
 					description := fmt.Sprintf("入口(%s)->第1跳(%s)", inNode.NodeName, firstNode.NodeName)
-					h.appendChainHopDiagnosis(&results, nodeCache, inNode.NodeID, firstNode, description, map[string]interface{}{
+					h.appendChainHopDiagnosis(results, nodeCache, inNode.NodeID, firstNode, description, map[string]interface{}{
 						"fromChainType": 1,
 						"toChainType":   2,
 						"toInx":         firstNode.Inx,
-					}, ipPreference)
+					}, ipPreference, opts)
 				}
 			} else {
 				for _, outNode := range outNodes {
 					description := fmt.Sprintf("入口(%s)->出口(%s)", inNode.NodeName, outNode.NodeName)
-					h.appendChainHopDiagnosis(&results, nodeCache, inNode.NodeID, outNode, description, map[string]interface{}{
+					h.appendChainHopDiagnosis(results, nodeCache, inNode.NodeID, outNode, description, map[string]interface{}{
 						"fromChainType": 1,
 						"toChainType":   3,
-					}, ipPreference)
+					}, ipPreference, opts)
 				}
 			}
 		}
@@ -403,21 +596,21 @@ func (h *Handler) diagnoseForwardRuntime(forward *forwardRecord) (map[string]int
 				if i+1 < len(chainHops) {
 					for _, nextNode := range chainHops[i+1] {
 						description := fmt.Sprintf("第%d跳(%s)->第%d跳(%s)", i+1, currentNode.NodeName, i+2, nextNode.NodeName)
-						h.appendChainHopDiagnosis(&results, nodeCache, currentNode.NodeID, nextNode, description, map[string]interface{}{
+						h.appendChainHopDiagnosis(results, nodeCache, currentNode.NodeID, nextNode, description, map[string]interface{}{
 							"fromChainType": 2,
 							"fromInx":       currentNode.Inx,
 							"toChainType":   2,
 							"toInx":         nextNode.Inx,
-						}, ipPreference)
+						}, ipPreference, opts)
 					}
 				} else {
 					for _, outNode := range outNodes {
 						description := fmt.Sprintf("第%d跳(%s)->出口(%s)", i+1, currentNode.NodeName, outNode.NodeName)
-						h.appendChainHopDiagnosis(&results, nodeCache, currentNode.NodeID, outNode, description, map[string]interface{}{
+						h.appendChainHopDiagnosis(results, nodeCache, currentNode.NodeID, outNode, description, map[string]interface{}{
 							"fromChainType": 2,
 							"fromInx":       currentNode.Inx,
 							"toChainType":   3,
-						}, ipPreference)
+						}, ipPreference, opts)
 					}
 				}
 			}
@@ -426,18 +619,18 @@ func (h *Handler) diagnoseForwardRuntime(forward *forwardRecord) (map[string]int
 		for _, outNode := range outNodes {
 			for _, target := range targets {
 				description := fmt.Sprintf("出口(%s)->目标(%s)", outNode.NodeName, target.Address)
-				h.appendPathDiagnosis(&results, nodeCache, outNode.NodeID, target.IP, target.Port, description, map[string]interface{}{
+				h.appendTargetDiagnosis(results, nodeCache, outNode.NodeID, target.IP, target.Port, description, map[string]interface{}{
 					"fromChainType": 3,
-				})
+				}, opts)
 			}
 		}
 	default:
 		for _, inNode := range inNodes {
 			for _, target := range targets {
 				description := fmt.Sprintf("入口(%s)->目标(%s)", inNode.NodeName, target.Address)
-				h.appendPathDiagnosis(&results, nodeCache, inNode.NodeID, target.IP, target.Port, description, map[string]interface{}{
+				h.appendTargetDiagnosis(results, nodeCache, inNode.NodeID, target.IP, target.Port, description, map[string]interface{}{
 					"fromChainType": 1,
-				})
+				}, opts)
 			}
 		}
 	}
@@ -445,12 +638,12 @@ func (h *Handler) diagnoseForwardRuntime(forward *forwardRecord) (map[string]int
 	payload := map[string]interface{}{
 		"forwardName": forward.Name,
 		"timestamp":   time.Now().UnixMilli(),
-		"results":     results,
+		"results":     results.wait(),
 	}
 	return payload, nil
 }
 
-func (h *Handler) diagnoseTunnelRuntime(tunnelID int64) (map[string]interface{}, error) {
+func (h *Handler) diagnoseTunnelRuntime(tunnelID int64, opts diagnoseOptions) (map[string]interface{}, error) {
 	tunnel, err := h.getTunnelRecord(tunnelID)
 	if err != nil {
 		return nil, err
@@ -474,35 +667,35 @@ func (h *Handler) diagnoseTunnelRuntime(tunnelID int64) (map[string]interface{},
 
 	ipPreference := h.repo.GetTunnelIPPreference(tunnelID)
 	inNodes, chainHops, outNodes := splitChainNodeGroups(chainRows)
-	results := make([]map[string]interface{}, 0, len(chainRows)*2)
-	nodeCache := map[int64]*nodeRecord{}
+	results := &diagnosisResults{}
+	nodeCache := &diagnosisNodeCache{nodes: map[int64]*nodeRecord{}}
 
 	switch tunnel.Type {
 	case 1:
 		for _, inNode := range inNodes {
 			description := fmt.Sprintf("入口(%s)->外网", inNode.NodeName)
-			h.appendPathDiagnosis(&results, nodeCache, inNode.NodeID, "www.bing.com", 443, description, map[string]interface{}{
+			h.appendPathDiagnosis(results, nodeCache, inNode.NodeID, "www.bing.com", 443, description, map[string]interface{}{
 				"fromChainType": 1,
-			})
+			}, opts)
 		}
 	case 2:
 		for _, inNode := range inNodes {
 			if len(chainHops) > 0 {
 				for _, firstNode := range chainHops[0] {
 					description := fmt.Sprintf("入口(%s)->第1跳(%s)", inNode.NodeName, firstNode.NodeName)
-					h.appendChainHopDiagnosis(&results, nodeCache, inNode.NodeID, firstNode, description, map[string]interface{}{
+					h.appendChainHopDiagnosis(results, nodeCache, inNode.NodeID, firstNode, description, map[string]interface{}{
 						"fromChainType": 1,
 						"toChainType":   2,
 						"toInx":         firstNode.Inx,
-					}, ipPreference)
+					}, ipPreference, opts)
 				}
 			} else {
 				for _, outNode := range outNodes {
 					description := fmt.Sprintf("入口(%s)->出口(%s)", inNode.NodeName, outNode.NodeName)
-					h.appendChainHopDiagnosis(&results, nodeCache, inNode.NodeID, outNode, description, map[string]interface{}{
+					h.appendChainHopDiagnosis(results, nodeCache, inNode.NodeID, outNode, description, map[string]interface{}{
 						"fromChainType": 1,
 						"toChainType":   3,
-					}, ipPreference)
+					}, ipPreference, opts)
 				}
 			}
 		}
@@ -512,21 +705,21 @@ func (h *Handler) diagnoseTunnelRuntime(tunnelID int64) (map[string]interface{},
 				if i+1 < len(chainHops) {
 					for _, nextNode := range chainHops[i+1] {
 						description := fmt.Sprintf("第%d跳(%s)->第%d跳(%s)", i+1, currentNode.NodeName, i+2, nextNode.NodeName)
-						h.appendChainHopDiagnosis(&results, nodeCache, currentNode.NodeID, nextNode, description, map[string]interface{}{
+						h.appendChainHopDiagnosis(results, nodeCache, currentNode.NodeID, nextNode, description, map[string]interface{}{
 							"fromChainType": 2,
 							"fromInx":       currentNode.Inx,
 							"toChainType":   2,
 							"toInx":         nextNode.Inx,
-						}, ipPreference)
+						}, ipPreference, opts)
 					}
 				} else {
 					for _, outNode := range outNodes {
 						description := fmt.Sprintf("第%d跳(%s)->出口(%s)", i+1, currentNode.NodeName, outNode.NodeName)
-						h.appendChainHopDiagnosis(&results, nodeCache, currentNode.NodeID, outNode, description, map[string]interface{}{
+						h.appendChainHopDiagnosis(results, nodeCache, currentNode.NodeID, outNode, description, map[string]interface{}{
 							"fromChainType": 2,
 							"fromInx":       currentNode.Inx,
 							"toChainType":   3,
-						}, ipPreference)
+						}, ipPreference, opts)
 					}
 				}
 			}
@@ -534,16 +727,16 @@ func (h *Handler) diagnoseTunnelRuntime(tunnelID int64) (map[string]interface{},
 
 		for _, outNode := range outNodes {
 			description := fmt.Sprintf("出口(%s)->外网", outNode.NodeName)
-			h.appendPathDiagnosis(&results, nodeCache, outNode.NodeID, "www.bing.com", 443, description, map[string]interface{}{
+			h.appendPathDiagnosis(results, nodeCache, outNode.NodeID, "www.bing.com", 443, description, map[string]interface{}{
 				"fromChainType": 3,
-			})
+			}, opts)
 		}
 	default:
 		for _, inNode := range inNodes {
 			description := fmt.Sprintf("入口(%s)->外网", inNode.NodeName)
-			h.appendPathDiagnosis(&results, nodeCache, inNode.NodeID, "www.bing.com", 443, description, map[string]interface{}{
+			h.appendPathDiagnosis(results, nodeCache, inNode.NodeID, "www.bing.com", 443, description, map[string]interface{}{
 				"fromChainType": 1,
-			})
+			}, opts)
 		}
 	}
 
@@ -551,7 +744,7 @@ func (h *Handler) diagnoseTunnelRuntime(tunnelID int64) (map[string]interface{},
 		"tunnelName": tunnelName,
 		"tunnelType": map[bool]string{true: "端口转发", false: "隧道转发"}[tunnel.Type == 1],
 		"timestamp":  time.Now().UnixMilli(),
-		"results":    results,
+		"results":    results.wait(),
 	}
 	return payload, nil
 }
@@ -605,15 +798,17 @@ func resolveDiagnosisTargets(remoteAddr string) ([]diagnosisTarget, error) {
 	return targets, nil
 }
 
-func (h *Handler) cachedNode(nodeCache map[int64]*nodeRecord, nodeID int64) (*nodeRecord, error) {
-	if node, ok := nodeCache[nodeID]; ok {
+func (h *Handler) cachedNode(nodeCache *diagnosisNodeCache, nodeID int64) (*nodeRecord, error) {
+	nodeCache.mu.Lock()
+	defer nodeCache.mu.Unlock()
+	if node, ok := nodeCache.nodes[nodeID]; ok {
 		return node, nil
 	}
 	node, err := h.getNodeRecord(nodeID)
 	if err != nil {
 		return nil, err
 	}
-	nodeCache[nodeID] = node
+	nodeCache.nodes[nodeID] = node
 	return node, nil
 }
 
@@ -633,7 +828,7 @@ func newDiagnosisResultItem(fromNodeID int64, targetIP string, targetPort int, d
 	return item
 }
 
-func (h *Handler) appendFailedDiagnosis(results *[]map[string]interface{}, nodeCache map[int64]*nodeRecord, fromNodeID int64, targetIP string, targetPort int, description string, metadata map[string]interface{}, message string) {
+func (h *Handler) appendFailedDiagnosis(results *diagnosisResults, nodeCache *diagnosisNodeCache, fromNodeID int64, targetIP string, targetPort int, description string, metadata map[string]interface{}, message string) {
 	item := newDiagnosisResultItem(fromNodeID, targetIP, targetPort, description, metadata)
 	if node, err := h.cachedNode(nodeCache, fromNodeID); err == nil {
 		item["nodeName"] = node.Name
@@ -643,18 +838,73 @@ func (h *Handler) appendFailedDiagnosis(results *[]map[string]interface{}, nodeC
 	}
 	item["success"] = false
 	item["message"] = message
-	*results = append(*results, item)
+	results.add(func() map[string]interface{} { return item })
+}
+
+// appendPathDiagnosis checks TCP reachability of targetIP:targetPort from the
+// given node. When opts.Trace names a probe protocol the node also traces the
+// route to the target and the per-hop result is attached under "trace".
+func (h *Handler) appendPathDiagnosis(results *diagnosisResults, nodeCache *diagnosisNodeCache, fromNodeID int64, targetIP string, targetPort int, description string, metadata map[string]interface{}, opts diagnoseOptions) {
+	results.add(func() map[string]interface{} {
+		item, _ := h.tcpPathDiagnosis(nodeCache, fromNodeID, targetIP, targetPort, description, metadata, opts)
+		return item
+	})
+}
+
+// appendTargetDiagnosis checks a forward target. In auto mode a target that
+// refuses TCP is probed again over UDP, so UDP-only services such as game
+// servers are reported by their UDP result instead of a TCP failure.
+func (h *Handler) appendTargetDiagnosis(results *diagnosisResults, nodeCache *diagnosisNodeCache, fromNodeID int64, targetIP string, targetPort int, description string, metadata map[string]interface{}, opts diagnoseOptions) {
+	results.add(func() map[string]interface{} {
+		return h.targetDiagnosis(nodeCache, fromNodeID, targetIP, targetPort, description, metadata, opts)
+	})
+}
+
+func (h *Handler) targetDiagnosis(nodeCache *diagnosisNodeCache, fromNodeID int64, targetIP string, targetPort int, description string, metadata map[string]interface{}, opts diagnoseOptions) map[string]interface{} {
+	switch opts.Probe {
+	case "udp", "dns", "stun":
+		return h.udpPathDiagnosis(nodeCache, fromNodeID, targetIP, targetPort, description, metadata, opts.Probe, opts)
+	}
+
+	item, probed := h.tcpPathDiagnosis(nodeCache, fromNodeID, targetIP, targetPort, description, metadata, opts)
+	if opts.Probe != "auto" || !probed || asBool(item["success"], false) {
+		return item
+	}
+
+	fromNode, _ := h.cachedNode(nodeCache, fromNodeID)
+	mode := udpProbeModeForPort(targetPort)
+	udp, err := h.udpProbeViaNode(fromNode, targetIP, targetPort, mode, opts)
+	if err != nil {
+		return item
+	}
+	item["udpProbe"] = udp
+	if !asBool(udp["success"], false) {
+		if asBool(udp["refused"], false) {
+			item["message"] = "TCP连接失败，UDP端口不可达"
+		} else {
+			item["message"] = "TCP连接失败，UDP探测无应答"
+		}
+		return item
+	}
+	item["probe"] = mode
+	item["success"] = true
+	item["averageTime"] = asFloat(udp["averageTime"], 0)
+	item["packetLoss"] = asFloat(udp["packetLoss"], 100)
+	item["message"] = "UDP响应正常（目标不接受TCP连接）"
+	return item
 }
 
-func (h *Handler) appendPathDiagnosis(results *[]map[string]interface{}, nodeCache map[int64]*nodeRecord, fromNodeID int64, targetIP string, targetPort int, description string, metadata map[string]interface{}) {
+// tcpPathDiagnosis builds a TCP reachability result item. probed reports
+// whether the node actually ran the check.
+func (h *Handler) tcpPathDiagnosis(nodeCache *diagnosisNodeCache, fromNodeID int64, targetIP string, targetPort int, description string, metadata map[string]interface{}, opts diagnoseOptions) (map[string]interface{}, bool) {
 	item := newDiagnosisResultItem(fromNodeID, targetIP, targetPort, description, metadata)
+	item["probe"] = "tcp"
 
 	fromNode, err := h.cachedNode(nodeCache, fromNodeID)
 	if err != nil {
 		item["success"] = false
 		item["message"] = err.Error()
-		*results = append(*results, item)
-		return
+		return item, false
 	}
 	item["nodeName"] = fromNode.Name
 
@@ -670,8 +920,7 @@ func (h *Handler) appendPathDiagnosis(results *[]map[string]interface{}, nodeCac
 	if pingErr != nil {
 		item["success"] = false
 		item["message"] = pingErr.Error()
-		*results = append(*results, item)
-		return
+		return item, false
 	}
 
 	success := asBool(pingData["success"], false)
@@ -693,10 +942,47 @@ func (h *Handler) appendPathDiagnosis(results *[]map[string]interface{}, nodeCac
 		}
 	}
 	item["message"] = message
-	*results = append(*results, item)
+	if opts.Trace != "" {
+		item["trace"] = h.traceDiagnosis(fromNode, targetIP, targetPort, opts.Trace)
+	}
+	return item, true
 }
 
-func (h *Handler) appendChainHopDiagnosis(results *[]map[string]interface{}, nodeCache map[int64]*nodeRecord, fromNodeID int64, toNode chainNodeRecord, description string, metadata map[string]interface{}, ipPreference string) {
+// udpPathDiagnosis builds a result item from a UDP probe of the target.
+func (h *Handler) udpPathDiagnosis(nodeCache *diagnosisNodeCache, fromNodeID int64, targetIP string, targetPort int, description string, metadata map[string]interface{}, mode string, opts diagnoseOptions) map[string]interface{} {
+	item := newDiagnosisResultItem(fromNodeID, targetIP, targetPort, description, metadata)
+	item["probe"] = mode
+
+	fromNode, err := h.cachedNode(nodeCache, fromNodeID)
+	if err != nil {
+		item["success"] = false
+		item["message"] = err.Error()
+		return item
+	}
+	item["nodeName"] = fromNode.Name
+
+	data, err := h.udpProbeViaNode(fromNode, targetIP, targetPort, mode, opts)
+	if err != nil {
+		item["success"] = false
+		item["message"] = err.Error()
+		return item
+	}
+	success := asBool(data["success"], false)
+	item["success"] = success
+	item["averageTime"] = asFloat(data["averageTime"], 0)
+	item["packetLoss"] = asFloat(data["packetLoss"], 100)
+	if success {
+		item["message"] = "UDP响应正常"
+	} else {
+		item["message"] = defaultString(strings.TrimSpace(asString(data["errorMessage"])), "UDP探测无应答")
+	}
+	if opts.Trace != "" {
+		item["trace"] = h.traceDiagnosis(fromNode, targetIP, targetPort, opts.Trace)
+	}
+	return item
+}
+
+func (h *Handler) appendChainHopDiagnosis(results *diagnosisResults, nodeCache *diagnosisNodeCache, fromNodeID int64, toNode chainNodeRecord, description string, metadata map[string]interface{}, ipPreference string, opts diagnoseOptions) {
 	fromNode, _ := h.cachedNode(nodeCache, fromNodeID)
 	targetNode, err := h.cachedNode(nodeCache, toNode.NodeID)
 	if err != nil {
@@ -708,7 +994,7 @@ func (h *Handler) appendChainHopDiagnosis(results *[]map[string]interface{}, nod
 		h.appendFailedDiagnosis(results, nodeCache, fromNodeID, strings.Trim(strings.TrimSpace(targetNode.ServerIP), "[]"), toNode.Port, description, metadata, err.Error())
 		return
 	}
-	h.appendPathDiagnosis(results, nodeCache, fromNodeID, targetIP, targetPort, description, metadata)
+	h.appendPathDiagnosis(results, nodeCache, fromNodeID, targetIP, targetPort, description, metadata, opts)
 }
 
 func resolveChainProbeTarget(fromNode, targetNode *nodeRecord, preferredPort int, ipPreference string) (string, int, error) {
@@ -777,6 +1063,82 @@ func (h *Handler) tcpPingViaNode(nodeID int64, ip string, port int) (map[string]
 	return res.Data, nil
 }
 
+// udpProbeViaNode asks the node to probe a UDP target. mode "udp" is the
+// echo probe carrying the configured payload.
+func (h *Handler) udpProbeViaNode(node *nodeRecord, ip string, port int, mode string, opts diagnoseOptions) (map[string]interface{}, error) {
+	if node == nil {
+		return nil, errors.New("节点不存在")
+	}
+	if node.IsRemote == 1 {
+		return nil, errors.New("远程节点暂不支持UDP探测")
+	}
+	if mode == "udp" {
+		mode = "echo"
+	}
+	res, err := h.sendNodeCommand(node.ID, "UdpProbe", map[string]interface{}{
+		"ip":         ip,
+		"port":       port,
+		"mode":       mode,
+		"payload":    opts.Payload,
+		"payloadHex": opts.PayloadHex,
+		"count":      diagnoseUDPProbeCount,
+		"timeout":    diagnoseUDPProbeTimeout,
+	}, false, false)
+	if err != nil {
+		return nil, err
+	}
+	if res.Data == nil {
+		return nil, errors.New("节点未返回UDP探测数据")
+	}
+	return res.Data, nil
+}
+
+// traceDiagnosis runs a traceroute from node to the target and returns the
+// agent's per-hop report, or a failed report when the trace cannot run.
+func (h *Handler) traceDiagnosis(node *nodeRecord, ip string, port int, protocol string) map[string]interface{} {
+	failed := func(message string) map[string]interface{} {
+		return map[string]interface{}{
+			"target":       ip,
+			"protocol":     protocol,
+			"success":      false,
+			"reached":      false,
+			"hops":         []interface{}{},
+			"errorMessage": message,
+		}
+	}
+	if node.IsRemote == 1 {
+		return failed("远程节点暂不支持路由追踪")
+	}
+	data, err := h.tracerouteViaNode(node.ID, ip, port, protocol)
+	if err != nil {
+		return failed(err.Error())
+	}
+	return data
+}
+
+func (h *Handler) tracerouteViaNode(nodeID int64, ip string, port int, protocol string) (map[string]interface{}, error) {
+	// Only TCP needs the service port; UDP probes use the classic
+	// traceroute port range so that the target answers with port unreachable.
+	if protocol != "tcp" {
+		port = 0
+	}
+	res, err := h.sendNodeCommand(nodeID, "Traceroute", map[string]interface{}{
+		"target":   ip,
+		"port":     port,
+		"protocol": protocol,
+		"count":    diagnoseTraceCount,
+		"maxHops":  diagnoseTraceMaxHops,
+		"timeout":  diagnoseTraceTimeout,
+	}, false, false)
+	if err != nil {
+		return nil, err
+	}
+	if res.Data == nil {
+		return nil, errors.New("节点未返回路由追踪数据")
+	}
+	return res.Data, nil
+}
+
 func (h *Handler) tcpPingViaRemoteNode(node *nodeRecord, ip string, port int) (map[string]interface{}, error) {
 	if node == nil {
 		return nil, errors.New("节点不存在")
@@ -886,6 +1248,9 @@ func isNotFoundError(err error) bool {
 
 func buildForwardServiceConfigs(baseName string, forward *forwardRecord, tunnel *tunnelRecord, node *nodeRecord, port int, limiterID *int64, tunnelTLSProtocol bool) []map[string]interface{} {
 	protocols := []string{"tcp", "udp"}
+	if forward.Domain != "" || forward.ProxyMode == forwardProxyModeHTTP {
+		protocols = []string{"tcp"}
+	}
 	services := make([]map[string]interface{}, 0, 2)
 	targets := splitRemoteTargets(forward.RemoteAddr)
 	strategy := strings.TrimSpace(forward.Strategy)
@@ -916,6 +1281,12 @@ func buildForwardServiceConfigs(baseName string, forward *forwardRecord, tunnel
 				},
 			},
 		}
+		if forward.Domain != "" {
+			service["listener"] = map[string]interface{}{
+				"type":     vhostListenerType,
+				"metadata": map[string]interface{}{"hosts": []string{forward.Domain}},
+			}
+		}
 		if protocol == "udp" {
 			listenerMetadata := map[string]interface{}{"keepAlive": true}
 			if tunnelTLSProtocol {
@@ -932,6 +1303,9 @@ func buildForwardServiceConfigs(baseName string, forward *forwardRecord, tunnel
 		if limiterID != nil && *limiterID > 0 {
 			service["limiter"] = strconv.FormatInt(*limiterID, 10)
 		}
+		if protocol == "tcp" && forward.ProxyMode == forwardProxyModeHTTP {
+			applyHTTPProxyService(service, forward)
+		}
 		services = append(services, service)
 	}
 
@@ -5,10 +5,16 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"go-backend/internal/store/repo"
 )
 
 const bytesPerGB int64 = 1024 * 1024 * 1024
 
+// flowSeqRetention is how long applied traffic report sequence numbers are
+// remembered. Agents drop journaled reports well before that.
+const flowSeqRetention = 7 * 24 * time.Hour
+
 type userTunnelPolicy struct {
 	ID       int64
 	UserID   int64
@@ -30,19 +36,114 @@ type namedConfigItem struct {
 	Name string `json:"name"`
 }
 
+// flowReport is a traffic report resolved against the current forwards and
+// shares. Its counter updates are applied in one transaction together with
+// the report's sequence number; quota enforcement runs after the commit.
+type flowReport struct {
+	writes  []func(tx *repo.Repository) error
+	enforce []func()
+}
+
+// parseFlowSeq returns the sequence number of a traffic report, or 0 when the
+// agent does not number its reports.
+func parseFlowSeq(rawSeq string) int64 {
+	seq, err := strconv.ParseInt(strings.TrimSpace(rawSeq), 10, 64)
+	if err != nil || seq <= 0 {
+		return 0
+	}
+	return seq
+}
+
+// applyFlowReport claims the report's sequence number and applies its
+// updates in one transaction and reports whether it was applied. Agents that
+// journal unsent reports number them; a report resent after its response was
+// lost carries a sequence number already claimed and is skipped. On error
+// nothing is applied, so the agent can resend the report.
+func (h *Handler) applyFlowReport(nodeID, seq int64, report *flowReport) (bool, error) {
+	fresh := true
+	err := h.repo.Transaction(func(tx *repo.Repository) error {
+		if seq > 0 {
+			claimed, err := tx.ClaimNodeFlowSeq(nodeID, seq, time.Now().UnixMilli())
+			if err != nil || !claimed {
+				fresh = false
+				return err
+			}
+		}
+		for _, write := range report.writes {
+			if err := write(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil || !fresh {
+		return false, err
+	}
+	for _, enforce := range report.enforce {
+		enforce()
+	}
+	return true, nil
+}
+
+func (h *Handler) pruneFlowSeqs(now time.Time) {
+	_ = h.repo.PruneNodeFlowSeqs(now.Add(-flowSeqRetention).UnixMilli())
+}
+
+// planFlowReport resolves the items of a node's traffic report.
+func (h *Handler) planFlowReport(nodeID int64, items []flowItem) *flowReport {
+	report := &flowReport{}
+	var protocolBlocked int64
+	for _, item := range items {
+		if isProtocolBlockedItem(item) {
+			protocolBlocked += item.R
+		}
+		h.planFlowItem(report, item)
+	}
+	if protocolBlocked > 0 {
+		report.writes = append(report.writes, func(tx *repo.Repository) error {
+			return tx.AddNodeProtocolBlocked(nodeID, protocolBlocked)
+		})
+	}
+	return report
+}
+
 func (h *Handler) processFlowItem(item flowItem) {
+	report := &flowReport{}
+	h.planFlowItem(report, item)
+	_, _ = h.applyFlowReport(0, 0, report)
+}
+
+func (h *Handler) planFlowItem(report *flowReport, item flowItem) {
 	serviceName := strings.TrimSpace(item.N)
 	if serviceName == "" || serviceName == "web_api" {
 		return
 	}
 
+	if forwardID, ok := parseGeoRejectedItem(item); ok {
+		report.writes = append(report.writes, func(tx *repo.Repository) error {
+			return tx.AddForwardGeoRejected(forwardID, item.R)
+		})
+		return
+	}
+	if isProtocolBlockedItem(item) {
+		if forwardID, ok := parseProtocolBlockedItem(item); ok {
+			report.writes = append(report.writes, func(tx *repo.Repository) error {
+				return tx.AddForwardProtocolBlocked(forwardID, item.R)
+			})
+		}
+		return
+	}
+
 	forwardID, userID, userTunnelID, ok := parseFlowServiceIDs(serviceName)
 	if ok {
 		inFlow, outFlow := h.scaleFlowByTunnel(forwardID, item.D, item.U)
-		_ = h.repo.AddFlow(forwardID, userID, userTunnelID, inFlow, outFlow)
-
+		report.writes = append(report.writes, func(tx *repo.Repository) error {
+			return tx.AddFlow(forwardID, userID, userTunnelID, inFlow, outFlow)
+		})
 		if userTunnelID > 0 {
-			h.enforceFlowPolicies(userID, userTunnelID)
+			report.enforce = append(report.enforce, func() {
+				h.enforceFlowPolicies(userID, userTunnelID)
+			})
 		}
 		return
 	}
@@ -51,7 +152,7 @@ func (h *Handler) processFlowItem(item flowItem) {
 	if !ok {
 		return
 	}
-	h.processPeerShareFlow(runtimeID, item)
+	h.planPeerShareFlow(report, runtimeID, item)
 }
 
 func parseFlowServiceIDs(serviceName string) (int64, int64, int64, bool) {
@@ -87,7 +188,7 @@ func parsePeerShareRuntimeServiceID(serviceName string) (int64, bool) {
 	return runtimeID, true
 }
 
-func (h *Handler) processPeerShareFlow(runtimeID int64, item flowItem) {
+func (h *Handler) planPeerShareFlow(report *flowReport, runtimeID int64, item flowItem) {
 	if h == nil || h.repo == nil || runtimeID <= 0 {
 		return
 	}
@@ -101,16 +202,20 @@ func (h *Handler) processPeerShareFlow(runtimeID int64, item flowItem) {
 		return
 	}
 
-	_ = h.repo.AddPeerShareCurrentFlow(runtime.ShareID, delta)
-
-	share, err := h.repo.GetPeerShare(runtime.ShareID)
-	if err != nil || share == nil {
-		return
-	}
-	if !isPeerShareFlowExceeded(share) {
-		return
-	}
-	h.enforcePeerShareFlowLimit(share.ID)
+	shareID := runtime.ShareID
+	report.writes = append(report.writes, func(tx *repo.Repository) error {
+		return tx.AddPeerShareCurrentFlow(shareID, delta)
+	})
+	report.enforce = append(report.enforce, func() {
+		share, err := h.repo.GetPeerShare(shareID)
+		if err != nil || share == nil {
+			return
+		}
+		if !isPeerShareFlowExceeded(share) {
+			return
+		}
+		h.enforcePeerShareFlowLimit(share.ID)
+	})
 }
 
 func (h *Handler) enforcePeerShareFlowLimit(shareID int64) {
@@ -0,0 +1,361 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/store/model"
+)
+
+// A scheduled forward runs only inside its active period and weekly
+// windows. The scheduler pauses it outside them and marks it
+// schedule-paused, so it never resumes a forward a user paused by hand;
+// a manual pause or resume clears the mark. Expiry is independent of the
+// owner's ExpTime and either pauses the forward for good or deletes it.
+const (
+	forwardExpirePause  = "pause"
+	forwardExpireDelete = "delete"
+
+	maxForwardWindows = 28
+)
+
+// forwardWindow is a weekly window. Days use time.Weekday (0 = Sunday);
+// an End at or before Start runs past midnight into the next day.
+type forwardWindow struct {
+	Days  []int  `json:"days"`
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+func parseClockMinutes(value string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("无效的时间: %s", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// normalizeForwardWindows validates the windows and returns their stored
+// JSON form, or "" when there are none.
+func normalizeForwardWindows(raw interface{}) (string, error) {
+	if raw == nil {
+		return "", nil
+	}
+	var windows []forwardWindow
+	switch v := raw.(type) {
+	case string:
+		if strings.TrimSpace(v) == "" {
+			return "", nil
+		}
+		if err := json.Unmarshal([]byte(v), &windows); err != nil {
+			return "", errors.New("时间窗口格式错误")
+		}
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return "", errors.New("时间窗口格式错误")
+		}
+		if err := json.Unmarshal(data, &windows); err != nil {
+			return "", errors.New("时间窗口格式错误")
+		}
+	}
+	if len(windows) == 0 {
+		return "", nil
+	}
+	if len(windows) > maxForwardWindows {
+		return "", fmt.Errorf("时间窗口最多 %d 个", maxForwardWindows)
+	}
+	for i := range windows {
+		w := &windows[i]
+		if len(w.Days) == 0 {
+			return "", errors.New("时间窗口需要至少选择一天")
+		}
+		seen := map[int]struct{}{}
+		days := make([]int, 0, len(w.Days))
+		for _, d := range w.Days {
+			if d < 0 || d > 6 {
+				return "", fmt.Errorf("无效的星期: %d", d)
+			}
+			if _, ok := seen[d]; !ok {
+				seen[d] = struct{}{}
+				days = append(days, d)
+			}
+		}
+		sort.Ints(days)
+		w.Days = days
+		start, err := parseClockMinutes(w.Start)
+		if err != nil {
+			return "", err
+		}
+		end, err := parseClockMinutes(w.End)
+		if err != nil {
+			return "", err
+		}
+		w.Start = fmt.Sprintf("%02d:%02d", start/60, start%60)
+		w.End = fmt.Sprintf("%02d:%02d", end/60, end%60)
+	}
+	data, err := json.Marshal(windows)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func forwardScheduleLocation(name string) *time.Location {
+	if name == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+func windowHasDay(days []int, day time.Weekday) bool {
+	for _, d := range days {
+		if d == int(day) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardInWindows reports whether now falls in one of the windows; no
+// windows means always.
+func forwardInWindows(rawWindows string, loc *time.Location, now time.Time) bool {
+	if rawWindows == "" {
+		return true
+	}
+	var windows []forwardWindow
+	if err := json.Unmarshal([]byte(rawWindows), &windows); err != nil || len(windows) == 0 {
+		return true
+	}
+	local := now.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+	today := local.Weekday()
+	yesterday := (today + 6) % 7
+	for _, w := range windows {
+		start, err1 := parseClockMinutes(w.Start)
+		end, err2 := parseClockMinutes(w.End)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		if start < end {
+			if windowHasDay(w.Days, today) && minute >= start && minute < end {
+				return true
+			}
+			continue
+		}
+		if windowHasDay(w.Days, today) && minute >= start {
+			return true
+		}
+		if windowHasDay(w.Days, yesterday) && minute < end {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardScheduleActive reports whether a forward should run at now,
+// ignoring expiry.
+func forwardScheduleActive(f *model.Forward, now time.Time) bool {
+	nowMs := now.UnixMilli()
+	if f.ScheduleStart > 0 && nowMs < f.ScheduleStart {
+		return false
+	}
+	if f.ScheduleEnd > 0 && nowMs >= f.ScheduleEnd {
+		return false
+	}
+	return forwardInWindows(f.ScheduleWindows, forwardScheduleLocation(f.ScheduleTimezone), now)
+}
+
+// forwardOwnerAllowsResume keeps the scheduler from resuming forwards
+// whose user or user tunnel is expired, disabled or out of traffic.
+func (h *Handler) forwardOwnerAllowsResume(forward *forwardRecord, nowMs int64) bool {
+	if h.shouldPauseUser(forward.UserID, nowMs) {
+		return false
+	}
+	userTunnelID, _, _, err := h.resolveUserTunnelAndLimiter(forward.UserID, forward.TunnelID)
+	if err != nil {
+		return false
+	}
+	policy, err := h.getUserTunnelPolicy(userTunnelID)
+	if err != nil {
+		return false
+	}
+	return !shouldPauseUserTunnel(policy, nowMs)
+}
+
+// applyForwardSchedule moves one forward to the state its schedule asks for.
+func (h *Handler) applyForwardSchedule(f *model.Forward, now time.Time) error {
+	nowMs := now.UnixMilli()
+	if f.Status != 0 && f.Status != 1 {
+		return nil
+	}
+	expired := f.ExpireTime > 0 && nowMs >= f.ExpireTime
+	active := !expired && forwardScheduleActive(f, now)
+	switch {
+	case expired && f.ExpireAction == forwardExpireDelete:
+		forward, err := h.getForwardRecord(f.ID)
+		if err != nil {
+			return err
+		}
+		if err := h.controlForwardServices(forward, "DeleteService", true); err != nil {
+			return err
+		}
+		h.deleteForwardAdmissions(forward)
+		return h.deleteForwardByID(f.ID)
+	case expired && (f.Status == 1 || f.SchedulePaused == 1):
+		// Paused for good: a later window must not bring it back.
+		if f.Status == 1 {
+			forward, err := h.getForwardRecord(f.ID)
+			if err != nil {
+				return err
+			}
+			_ = h.controlForwardServices(forward, "PauseService", false)
+		}
+		return h.repo.UpdateForwardScheduleState(f.ID, 0, 0, nowMs)
+	case f.Status == 1 && !active:
+		forward, err := h.getForwardRecord(f.ID)
+		if err != nil {
+			return err
+		}
+		_ = h.controlForwardServices(forward, "PauseService", false)
+		return h.repo.UpdateForwardScheduleState(f.ID, 0, 1, nowMs)
+	case f.Status == 0 && f.SchedulePaused == 1 && active:
+		forward, err := h.getForwardRecord(f.ID)
+		if err != nil {
+			return err
+		}
+		if !h.forwardOwnerAllowsResume(forward, nowMs) {
+			return nil
+		}
+		if err := h.controlForwardServices(forward, "ResumeService", false); err != nil {
+			return err
+		}
+		return h.repo.UpdateForwardScheduleState(f.ID, 1, 0, nowMs)
+	}
+	return nil
+}
+
+// runForwardScheduleJob applies schedules and expiry to all scheduled
+// forwards. Failures are retried on the next run.
+func (h *Handler) runForwardScheduleJob(now time.Time) {
+	if h == nil || h.repo == nil {
+		return
+	}
+	forwards, err := h.repo.ListScheduledForwards()
+	if err != nil {
+		return
+	}
+	for i := range forwards {
+		_ = h.applyForwardSchedule(&forwards[i], now)
+	}
+}
+
+// forwardSchedule sets or clears the schedule and expiry of a forward and
+// applies it right away. Owners may edit the schedule; the expiry is
+// admin-only.
+func (h *Handler) forwardSchedule(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req map[string]interface{}
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	id := asInt64(req["id"], 0)
+	if id <= 0 {
+		response.WriteJSON(w, response.ErrDefault("转发ID不能为空"))
+		return
+	}
+	_, _, roleID, err := h.resolveForwardAccess(r, id)
+	if err != nil {
+		if errors.Is(err, errForwardNotFound) {
+			response.WriteJSON(w, response.ErrDefault("转发不存在"))
+			return
+		}
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+
+	next := model.Forward{
+		ID:               id,
+		ScheduleStart:    asInt64(req["scheduleStart"], 0),
+		ScheduleEnd:      asInt64(req["scheduleEnd"], 0),
+		ScheduleTimezone: strings.TrimSpace(asString(req["scheduleTimezone"])),
+		ExpireTime:       asInt64(req["expireTime"], 0),
+		ExpireAction:     strings.TrimSpace(asString(req["expireAction"])),
+	}
+	if next.ScheduleStart < 0 || next.ScheduleEnd < 0 || next.ExpireTime < 0 {
+		response.WriteJSON(w, response.ErrDefault("时间不能为负数"))
+		return
+	}
+	if next.ScheduleStart > 0 && next.ScheduleEnd > 0 && next.ScheduleEnd <= next.ScheduleStart {
+		response.WriteJSON(w, response.ErrDefault("结束时间必须晚于开始时间"))
+		return
+	}
+	windows, err := normalizeForwardWindows(req["scheduleWindows"])
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
+	next.ScheduleWindows = windows
+	if next.ScheduleTimezone != "" {
+		if _, err := time.LoadLocation(next.ScheduleTimezone); err != nil {
+			response.WriteJSON(w, response.ErrDefault("无效的时区"))
+			return
+		}
+	}
+	switch {
+	case next.ExpireTime == 0:
+		next.ExpireAction = ""
+	case next.ExpireAction == "":
+		next.ExpireAction = forwardExpirePause
+	case next.ExpireAction != forwardExpirePause && next.ExpireAction != forwardExpireDelete:
+		response.WriteJSON(w, response.ErrDefault("到期操作只能是 pause 或 delete"))
+		return
+	}
+	if roleID != 0 {
+		// The expiry ends a forward the user paid for, so only admins set
+		// it. Owners may still edit the active periods.
+		current, err := h.repo.GetForwardSchedule(id)
+		if err != nil || current == nil {
+			response.WriteJSON(w, response.ErrDefault("转发不存在"))
+			return
+		}
+		_, setsExpire := req["expireTime"]
+		_, setsAction := req["expireAction"]
+		if (setsExpire && next.ExpireTime != current.ExpireTime) ||
+			(setsAction && next.ExpireTime > 0 && next.ExpireAction != current.ExpireAction) {
+			response.WriteJSON(w, response.ErrDefault("只有管理员可以修改到期时间"))
+			return
+		}
+		next.ExpireTime, next.ExpireAction = current.ExpireTime, current.ExpireAction
+	}
+
+	now := time.Now()
+	if err := h.repo.UpdateForwardSchedule(&next, now.UnixMilli()); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	current, err := h.repo.GetForwardSchedule(id)
+	if err != nil || current == nil {
+		response.WriteJSON(w, response.OKEmpty())
+		return
+	}
+	if err := h.applyForwardSchedule(current, now); err != nil {
+		response.WriteJSON(w, response.ErrDefault("计划已保存，但应用失败: "+err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OKEmpty())
+}
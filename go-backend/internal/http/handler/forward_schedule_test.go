@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-backend/internal/store/repo"
+)
+
+func TestNormalizeForwardWindows(t *testing.T) {
+	got, err := normalizeForwardWindows([]interface{}{
+		map[string]interface{}{"days": []interface{}{5, 1, 5}, "start": "9:00", "end": "18:30"},
+	})
+	if err != nil {
+		t.Fatalf("normalize: %v", err)
+	}
+	if got != `[{"days":[1,5],"start":"09:00","end":"18:30"}]` {
+		t.Fatalf("unexpected windows: %s", got)
+	}
+	if got, err := normalizeForwardWindows(""); err != nil || got != "" {
+		t.Fatalf("expected empty windows, got %q (%v)", got, err)
+	}
+
+	for _, bad := range []interface{}{
+		`[{"days":[],"start":"09:00","end":"10:00"}]`,
+		`[{"days":[7],"start":"09:00","end":"10:00"}]`,
+		`[{"days":[1],"start":"25:00","end":"10:00"}]`,
+		`not json`,
+	} {
+		if _, err := normalizeForwardWindows(bad); err == nil {
+			t.Fatalf("expected %v to be rejected", bad)
+		}
+	}
+}
+
+func TestForwardInWindowsOvernight(t *testing.T) {
+	// Friday 22:00 until Saturday 02:00.
+	windows := `[{"days":[5],"start":"22:00","end":"02:00"}]`
+	cases := map[string]bool{
+		"2026-10-16T21:59:00Z": false,
+		"2026-10-16T22:00:00Z": true,
+		"2026-10-17T01:59:00Z": true,
+		"2026-10-17T02:00:00Z": false,
+		"2026-10-17T23:00:00Z": false,
+	}
+	for at, want := range cases {
+		now, _ := time.Parse(time.RFC3339, at)
+		if got := forwardInWindows(windows, time.UTC, now); got != want {
+			t.Fatalf("%s: expected %v, got %v", at, want, got)
+		}
+	}
+
+	shanghai := time.FixedZone("UTC+8", 8*3600)
+	now, _ := time.Parse(time.RFC3339, "2026-10-16T14:30:00Z") // Friday 22:30 at UTC+8
+	if !forwardInWindows(windows, shanghai, now) {
+		t.Fatalf("expected window to be evaluated in the schedule timezone")
+	}
+}
+
+func TestRunForwardScheduleJobPausesResumesAndExpires(t *testing.T) {
+	r, err := repo.Open(filepath.Join(t.TempDir(), "forward-schedule.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = r.Close() })
+	h := New(r, "secret")
+
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	nowMs := now.UnixMilli()
+	insert := func(id int64, status int, scheduleStart, scheduleEnd, expireTime int64, expireAction string) {
+		t.Helper()
+		if err := r.DB().Exec(`
+			INSERT INTO forward(id, user_id, user_name, name, tunnel_id, remote_addr, strategy, in_flow, out_flow, created_time, updated_time, status, inx, schedule_start, schedule_end, expire_time, expire_action)
+			VALUES(?, 1, 'admin_user', 'f', 1, '1.1.1.1:443', 'fifo', 0, 0, ?, ?, ?, 0, ?, ?, ?, ?)
+		`, id, nowMs, nowMs, status, scheduleStart, scheduleEnd, expireTime, expireAction).Error; err != nil {
+			t.Fatalf("insert forward %d: %v", id, err)
+		}
+	}
+	hour := int64(time.Hour / time.Millisecond)
+	insert(1, 1, nowMs+hour, 0, 0, "")          // not started yet
+	insert(2, 1, 0, 0, nowMs-1, "delete")       // expired, delete
+	insert(3, 1, 0, 0, nowMs-1, "pause")        // expired, pause
+	insert(4, 0, nowMs-hour, nowMs+hour, 0, "") // paused by a user inside its period
+
+	h.runForwardScheduleJob(now)
+
+	if status, paused := mustQueryInt(t, r, `SELECT status FROM forward WHERE id = 1`), mustQueryInt(t, r, `SELECT schedule_paused FROM forward WHERE id = 1`); status != 0 || paused != 1 {
+		t.Fatalf("expected forward 1 to be schedule-paused, got status=%d paused=%d", status, paused)
+	}
+	if n := mustQueryInt(t, r, `SELECT COUNT(1) FROM forward WHERE id = 2`); n != 0 {
+		t.Fatalf("expected expired forward 2 to be deleted")
+	}
+	if status, paused := mustQueryInt(t, r, `SELECT status FROM forward WHERE id = 3`), mustQueryInt(t, r, `SELECT schedule_paused FROM forward WHERE id = 3`); status != 0 || paused != 0 {
+		t.Fatalf("expected forward 3 to be paused for good, got status=%d paused=%d", status, paused)
+	}
+	if status := mustQueryInt(t, r, `SELECT status FROM forward WHERE id = 4`); status != 0 {
+		t.Fatalf("expected manually paused forward 4 to stay paused, got status=%d", status)
+	}
+
+	// Once the period starts, only the forward the scheduler paused resumes.
+	h.runForwardScheduleJob(now.Add(2 * time.Hour))
+	if status, paused := mustQueryInt(t, r, `SELECT status FROM forward WHERE id = 1`), mustQueryInt(t, r, `SELECT schedule_paused FROM forward WHERE id = 1`); status != 1 || paused != 0 {
+		t.Fatalf("expected forward 1 to resume, got status=%d paused=%d", status, paused)
+	}
+	if status := mustQueryInt(t, r, `SELECT status FROM forward WHERE id = 3`); status != 0 {
+		t.Fatalf("expected expired forward 3 to stay paused, got status=%d", status)
+	}
+
+	// A disabled owner blocks resuming.
+	if err := r.DB().Exec(`UPDATE forward SET status = 0, schedule_paused = 1 WHERE id = 1`).Error; err != nil {
+		t.Fatalf("reset forward 1: %v", err)
+	}
+	if err := r.DB().Exec(`UPDATE user SET status = 0 WHERE id = 1`).Error; err != nil {
+		t.Fatalf("disable user: %v", err)
+	}
+	h.runForwardScheduleJob(now.Add(2 * time.Hour))
+	if status := mustQueryInt(t, r, `SELECT status FROM forward WHERE id = 1`); status != 0 {
+		t.Fatalf("expected forward of a disabled user to stay paused, got status=%d", status)
+	}
+}
@@ -0,0 +1,278 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/store/model"
+)
+
+// Country rules travel as a third fixed admission "<base>_geo" next to the
+// source-IP pair. The agent resolves the client address against its local
+// MaxMind country database and counts every rejection per admission name;
+// the counters come back with the traffic report as {"n": "<base>_geo", "r": N}.
+const (
+	admissionGeoSuffix = "_geo"
+
+	maxCountryEntries = 300
+)
+
+// parseCountryCodes parses a newline/comma/space separated list of ISO
+// 3166-1 alpha-2 codes into a sorted, upper-cased, de-duplicated slice.
+func parseCountryCodes(raw string) ([]string, error) {
+	fields := strings.FieldsFunc(raw, func(r rune) bool {
+		return r == ',' || r == ';' || r == '\n' || r == '\r' || r == ' ' || r == '\t'
+	})
+	if len(fields) > maxCountryEntries {
+		return nil, fmt.Errorf("国家列表最多 %d 条", maxCountryEntries)
+	}
+	seen := make(map[string]struct{}, len(fields))
+	out := make([]string, 0, len(fields))
+	for _, field := range fields {
+		code := strings.ToUpper(field)
+		if len(code) != 2 || code[0] < 'A' || code[0] > 'Z' || code[1] < 'A' || code[1] > 'Z' {
+			return nil, fmt.Errorf("无效的国家代码: %s", field)
+		}
+		if _, ok := seen[code]; ok {
+			continue
+		}
+		seen[code] = struct{}{}
+		out = append(out, code)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// normalizeCountryCodes validates raw input and returns the canonical
+// newline separated form stored in the database.
+func normalizeCountryCodes(raw string) (string, error) {
+	codes, err := parseCountryCodes(raw)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(codes, "\n"), nil
+}
+
+// compileGeoACL merges country lists the same way compileSourceACL merges
+// CIDRs: allow lists intersect, deny lists union.
+func compileGeoACL(scopes []model.GeoACL) (allow []string, deny []string, restricted bool, err error) {
+	denySet := make(map[string]struct{})
+	for _, scope := range scopes {
+		allowList, err := parseCountryCodes(scope.AllowCountries)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		denyList, err := parseCountryCodes(scope.DenyCountries)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		if len(allowList) > 0 {
+			if !restricted {
+				allow = allowList
+				restricted = true
+			} else {
+				allow = intersectCountryCodes(allow, allowList)
+			}
+		}
+		for _, code := range denyList {
+			if _, ok := denySet[code]; ok {
+				continue
+			}
+			denySet[code] = struct{}{}
+			deny = append(deny, code)
+		}
+	}
+	sort.Strings(deny)
+	return allow, deny, restricted, nil
+}
+
+func intersectCountryCodes(a, b []string) []string {
+	set := make(map[string]struct{}, len(b))
+	for _, code := range b {
+		set[code] = struct{}{}
+	}
+	out := make([]string, 0)
+	for _, code := range a {
+		if _, ok := set[code]; ok {
+			out = append(out, code)
+		}
+	}
+	return out
+}
+
+// buildForwardGeoAdmission renders the country admission of a forward
+// service base name. With an allow list the admission runs in whitelist
+// mode, so clients whose country cannot be resolved are rejected.
+func buildForwardGeoAdmission(baseName string, scopes []model.GeoACL) (admission map[string]interface{}, hasRules bool, err error) {
+	allow, deny, restricted, err := compileGeoACL(scopes)
+	if err != nil {
+		return nil, false, err
+	}
+	if allow == nil {
+		allow = make([]string, 0)
+	}
+	if deny == nil {
+		deny = make([]string, 0)
+	}
+	return map[string]interface{}{
+		"name":      baseName + admissionGeoSuffix,
+		"whitelist": restricted,
+		"geoip": map[string]interface{}{
+			"allow": allow,
+			"deny":  deny,
+		},
+	}, restricted || len(deny) > 0, nil
+}
+
+func (h *Handler) forwardGeoACLScopes(forward *forwardRecord) ([]model.GeoACL, error) {
+	scopes := make([]model.GeoACL, 0, 2)
+	tunnelACL, err := h.repo.GetTunnelGeoACL(forward.TunnelID)
+	if err != nil {
+		return nil, err
+	}
+	if tunnelACL != nil {
+		scopes = append(scopes, *tunnelACL)
+	}
+	fwdACL, err := h.repo.GetForwardGeoACL(forward.ID)
+	if err != nil {
+		return nil, err
+	}
+	if fwdACL != nil {
+		scopes = append(scopes, *fwdACL)
+	}
+	return scopes, nil
+}
+
+// parseGeoRejectedItem recognises a rejection counter reported for a
+// forward's country admission and returns the forward id.
+func parseGeoRejectedItem(item flowItem) (int64, bool) {
+	name := strings.TrimSpace(item.N)
+	if item.R <= 0 || !strings.HasSuffix(name, admissionGeoSuffix) {
+		return 0, false
+	}
+	forwardID, _, _, ok := parseFlowServiceIDs(strings.TrimSuffix(name, admissionGeoSuffix))
+	return forwardID, ok
+}
+
+func (h *Handler) forwardGeo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req map[string]interface{}
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	id := asInt64(req["id"], 0)
+	if id <= 0 {
+		response.WriteJSON(w, response.ErrDefault("转发ID不能为空"))
+		return
+	}
+	forward, _, _, err := h.resolveForwardAccess(r, id)
+	if err != nil {
+		if errors.Is(err, errForwardNotFound) {
+			response.WriteJSON(w, response.ErrDefault("转发不存在"))
+			return
+		}
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	allow, deny, ok := normalizeGeoACLRequest(w, req)
+	if !ok {
+		return
+	}
+	old, err := h.repo.GetForwardGeoACL(id)
+	if err != nil || old == nil {
+		response.WriteJSON(w, response.ErrDefault("转发不存在"))
+		return
+	}
+
+	now := time.Now().UnixMilli()
+	if err := h.repo.UpdateForwardGeoACL(id, allow, deny, now); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	// Services created before country rules existed do not reference the
+	// geo admission yet, so the services are re-synced rather than only the
+	// admissions.
+	if err := h.syncForwardServices(forward, "UpdateService", true); err != nil {
+		_ = h.repo.UpdateForwardGeoACL(id, old.AllowCountries, old.DenyCountries, now)
+		_ = h.syncForwardServices(forward, "UpdateService", true)
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OKEmpty())
+}
+
+func (h *Handler) tunnelGeo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req map[string]interface{}
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	id := asInt64(req["id"], 0)
+	if id <= 0 {
+		response.WriteJSON(w, response.ErrDefault("隧道ID不能为空"))
+		return
+	}
+	old, err := h.repo.GetTunnelGeoACL(id)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if old == nil {
+		response.WriteJSON(w, response.ErrDefault("隧道不存在"))
+		return
+	}
+	allow, deny, ok := normalizeGeoACLRequest(w, req)
+	if !ok {
+		return
+	}
+	if err := h.repo.UpdateTunnelGeoACL(id, allow, deny, time.Now().UnixMilli()); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+
+	forwards, err := h.listForwardsByTunnel(id)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	failed := make([]string, 0)
+	for i := range forwards {
+		if err := h.syncForwardServices(&forwards[i], "UpdateService", true); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", forwards[i].Name, err))
+		}
+	}
+	if len(failed) > 0 {
+		response.WriteJSON(w, response.ErrDefault("部分转发下发失败: "+strings.Join(failed, "; ")))
+		return
+	}
+	response.WriteJSON(w, response.OKEmpty())
+}
+
+// normalizeGeoACLRequest reads allowCountries/denyCountries from the request
+// and writes the validation error itself when the input is rejected.
+func normalizeGeoACLRequest(w http.ResponseWriter, req map[string]interface{}) (string, string, bool) {
+	allow, err := normalizeCountryCodes(sourceCIDRInput(req["allowCountries"]))
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault("允许国家: "+err.Error()))
+		return "", "", false
+	}
+	deny, err := normalizeCountryCodes(sourceCIDRInput(req["denyCountries"]))
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault("拒绝国家: "+err.Error()))
+		return "", "", false
+	}
+	return allow, deny, true
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"reflect"
+	"testing"
+
+	"go-backend/internal/store/model"
+)
+
+func TestNormalizeCountryCodes(t *testing.T) {
+	got, err := normalizeCountryCodes("us, cn\nDE;us jp")
+	if err != nil {
+		t.Fatalf("normalize: %v", err)
+	}
+	if want := "CN\nDE\nJP\nUS"; got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+
+	for _, bad := range []string{"USA", "1A", "中国"} {
+		if _, err := normalizeCountryCodes(bad); err == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestBuildForwardGeoAdmissionWithoutRules(t *testing.T) {
+	admission, hasRules, err := buildForwardGeoAdmission("1_2_3", []model.GeoACL{{}, {}})
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if hasRules {
+		t.Fatalf("expected no rules")
+	}
+	if admission["name"] != "1_2_3_geo" {
+		t.Fatalf("unexpected admission name: %v", admission["name"])
+	}
+	if admission["whitelist"] != false {
+		t.Fatalf("expected whitelist off without allow lists")
+	}
+}
+
+func TestBuildForwardGeoAdmissionMergesScopes(t *testing.T) {
+	scopes := []model.GeoACL{
+		{AllowCountries: "CN\nHK\nUS", DenyCountries: "RU"},
+		{AllowCountries: "HK\nUS\nJP", DenyCountries: "KP\nRU"},
+	}
+	admission, hasRules, err := buildForwardGeoAdmission("1_2_3", scopes)
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if !hasRules {
+		t.Fatalf("expected rules")
+	}
+	if admission["whitelist"] != true {
+		t.Fatalf("expected whitelist mode")
+	}
+	geo := admission["geoip"].(map[string]interface{})
+	if got, want := geo["allow"], []string{"HK", "US"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("allow: expected %v, got %v", want, got)
+	}
+	if got, want := geo["deny"], []string{"KP", "RU"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("deny: expected %v, got %v", want, got)
+	}
+}
+
+func TestParseGeoRejectedItem(t *testing.T) {
+	if id, ok := parseGeoRejectedItem(flowItem{N: "7_2_3_geo", R: 5}); !ok || id != 7 {
+		t.Fatalf("expected forward 7, got %d (%v)", id, ok)
+	}
+	if _, ok := parseGeoRejectedItem(flowItem{N: "7_2_3_geo"}); ok {
+		t.Fatalf("expected item without rejections to be ignored")
+	}
+	if _, ok := parseGeoRejectedItem(flowItem{N: "7_2_3_tcp", R: 5}); ok {
+		t.Fatalf("expected service item to be ignored")
+	}
+}
@@ -37,6 +37,35 @@ type Handler struct {
 
 	upgradeMu              sync.Mutex
 	pendingUpgradeRedeploy map[int64]struct{}
+	upgradeHealth          map[int64]*nodeUpgradeHealth
+	rolloutMu              sync.Mutex
+	rolloutRuns            map[int64]*rolloutRun
+
+	certMu      sync.Mutex
+	certIssuing map[int64]struct{}
+
+	metricsMu   sync.Mutex
+	nodeMetrics map[int64]*nodeMetricState
+
+	alertMu     sync.Mutex
+	alertStates map[alertKey]*alertState
+
+	reconcileMu       sync.Mutex
+	nodeConfigReports map[int64]nodeConfigReport
+	reconcileLocks    map[int64]*sync.Mutex
+
+	outboxMu        sync.Mutex
+	outboxReplaying map[int64]struct{}
+
+	// enrollMu serializes enrollments so retries with the same enrollment
+	// id cannot both create a node.
+	enrollMu sync.Mutex
+
+	nodeTLSMu   sync.Mutex
+	nodeTLSAddr string
+	nodeCA      *nodeCAState
+
+	releaseDir string
 }
 
 type loginRequest struct {
@@ -70,6 +99,7 @@ type flowItem struct {
 	N string `json:"n"`
 	U int64  `json:"u"`
 	D int64  `json:"d"`
+	R int64  `json:"r,omitempty"`
 }
 
 func New(repo *repo.Repository, jwtSecret string) *Handler {
@@ -79,8 +109,12 @@ func New(repo *repo.Repository, jwtSecret string) *Handler {
 		wsServer:               ws.NewServer(repo, jwtSecret),
 		captchaTokens:          make(map[string]int64),
 		pendingUpgradeRedeploy: make(map[int64]struct{}),
+		upgradeHealth:          make(map[int64]*nodeUpgradeHealth),
+		rolloutRuns:            make(map[int64]*rolloutRun),
 	}
 	h.wsServer.SetNodeOnlineHook(h.onNodeOnline)
+	h.wsServer.SetNodeInfoHook(h.onNodeInfo)
+	h.wsServer.SetNodeAuthorizer(h.authorizeNodeTransport)
 	return h
 }
 
@@ -118,10 +152,50 @@ func (h *Handler) Register(mux *http.ServeMux) {
 	mux.HandleFunc("/api/v1/node/update-order", h.nodeUpdateOrder)
 	mux.HandleFunc("/api/v1/node/batch-delete", h.nodeBatchDelete)
 	mux.HandleFunc("/api/v1/node/check-status", h.nodeCheckStatus)
+	mux.HandleFunc("/api/v1/node/acl", h.nodeACL)
 	mux.HandleFunc("/api/v1/node/upgrade", h.nodeUpgrade)
 	mux.HandleFunc("/api/v1/node/batch-upgrade", h.nodeBatchUpgrade)
 	mux.HandleFunc("/api/v1/node/rollback", h.nodeRollback)
 	mux.HandleFunc("/api/v1/node/releases", h.listReleases)
+	mux.HandleFunc("/api/v1/node/upgrade/status", h.nodeUpgradeStatus)
+	mux.HandleFunc("/api/v1/node/upgrade/rollout/create", h.rolloutCreate)
+	mux.HandleFunc("/api/v1/node/upgrade/rollout/list", h.rolloutList)
+	mux.HandleFunc("/api/v1/node/upgrade/rollout/get", h.rolloutGet)
+	mux.HandleFunc("/api/v1/node/upgrade/rollout/pause", h.rolloutPause)
+	mux.HandleFunc("/api/v1/node/upgrade/rollout/resume", h.rolloutResume)
+	mux.HandleFunc("/api/v1/node/upgrade/rollout/abort", h.rolloutAbort)
+	mux.HandleFunc("/api/v1/node/metrics", h.nodeMetricsList)
+	mux.HandleFunc("/api/v1/node/maintenance", h.nodeMaintenance)
+	mux.HandleFunc("/api/v1/node/drift", h.nodeDrift)
+	mux.HandleFunc("/api/v1/node/reconcile", h.nodeReconcile)
+	mux.HandleFunc("/api/v1/node/outbox", h.nodeOutboxList)
+	mux.HandleFunc("/api/v1/node/outbox/cancel", h.nodeOutboxCancel)
+	mux.HandleFunc("/api/v1/node/ca", h.nodeCAInfo)
+	mux.HandleFunc("/api/v1/node/certificate/list", h.nodeCertificateList)
+	mux.HandleFunc("/api/v1/node/certificate/revoke", h.nodeCertificateRevoke)
+	mux.HandleFunc("/api/v1/node/release/upload", h.releaseUpload)
+	mux.HandleFunc("/api/v1/node/release/artifacts", h.releaseArtifactList)
+	mux.HandleFunc("/api/v1/node/release/delete", h.releaseArtifactDelete)
+	mux.HandleFunc("/api/v1/node/speedtest", h.nodeSpeedTest)
+	mux.HandleFunc("/api/v1/node/connections", h.nodeConnections)
+	mux.HandleFunc("/api/v1/node/connections/close", h.nodeConnectionsClose)
+	mux.HandleFunc("/api/v1/node/logs", h.nodeLogs)
+	mux.HandleFunc("/api/v1/node/logs/stop", h.nodeLogsStop)
+	mux.HandleFunc("/api/v1/node/log-level", h.nodeLogLevel)
+	mux.HandleFunc("/api/v1/node/protocols", h.nodeProtocols)
+	mux.HandleFunc("/api/v1/node/enroll-token/create", h.nodeEnrollTokenCreate)
+	mux.HandleFunc("/api/v1/node/enroll-token/list", h.nodeEnrollTokenList)
+	mux.HandleFunc("/api/v1/node/enroll-token/delete", h.nodeEnrollTokenDelete)
+	mux.HandleFunc("/api/v1/alert/rule/list", h.alertRuleList)
+	mux.HandleFunc("/api/v1/alert/rule/create", h.alertRuleCreate)
+	mux.HandleFunc("/api/v1/alert/rule/update", h.alertRuleUpdate)
+	mux.HandleFunc("/api/v1/alert/rule/delete", h.alertRuleDelete)
+	mux.HandleFunc("/api/v1/alert/channel/list", h.alertChannelList)
+	mux.HandleFunc("/api/v1/alert/channel/create", h.alertChannelCreate)
+	mux.HandleFunc("/api/v1/alert/channel/update", h.alertChannelUpdate)
+	mux.HandleFunc("/api/v1/alert/channel/delete", h.alertChannelDelete)
+	mux.HandleFunc("/api/v1/alert/channel/test", h.alertChannelTest)
+	mux.HandleFunc("/api/v1/alert/event/list", h.alertEventList)
 	mux.HandleFunc("/api/v1/tunnel/list", h.tunnelList)
 	mux.HandleFunc("/api/v1/tunnel/create", h.tunnelCreate)
 	mux.HandleFunc("/api/v1/tunnel/get", h.tunnelGet)
@@ -135,6 +209,9 @@ func (h *Handler) Register(mux *http.ServeMux) {
 	mux.HandleFunc("/api/v1/tunnel/user/batch-assign", h.userTunnelBatchAssign)
 	mux.HandleFunc("/api/v1/tunnel/user/remove", h.userTunnelRemove)
 	mux.HandleFunc("/api/v1/tunnel/user/update", h.userTunnelUpdate)
+	mux.HandleFunc("/api/v1/tunnel/user/acl", h.userTunnelACL)
+	mux.HandleFunc("/api/v1/tunnel/geo", h.tunnelGeo)
+	mux.HandleFunc("/api/v1/tunnel/protocols", h.tunnelProtocols)
 	mux.HandleFunc("/api/v1/forward/list", h.forwardList)
 	mux.HandleFunc("/api/v1/forward/create", h.forwardCreate)
 	mux.HandleFunc("/api/v1/forward/update", h.forwardUpdate)
@@ -143,12 +220,24 @@ func (h *Handler) Register(mux *http.ServeMux) {
 	mux.HandleFunc("/api/v1/forward/pause", h.forwardPause)
 	mux.HandleFunc("/api/v1/forward/resume", h.forwardResume)
 	mux.HandleFunc("/api/v1/forward/diagnose", h.forwardDiagnose)
+	mux.HandleFunc("/api/v1/forward/connections", h.forwardConnections)
+	mux.HandleFunc("/api/v1/forward/connections/close", h.forwardConnectionsClose)
+	mux.HandleFunc("/api/v1/forward/acl", h.forwardACL)
+	mux.HandleFunc("/api/v1/forward/geo", h.forwardGeo)
+	mux.HandleFunc("/api/v1/forward/protocols", h.forwardProtocols)
+	mux.HandleFunc("/api/v1/forward/http-proxy", h.forwardHTTPProxy)
+	mux.HandleFunc("/api/v1/forward/schedule", h.forwardSchedule)
 	mux.HandleFunc("/api/v1/forward/update-order", h.forwardUpdateOrder)
 	mux.HandleFunc("/api/v1/forward/batch-delete", h.forwardBatchDelete)
 	mux.HandleFunc("/api/v1/forward/batch-pause", h.forwardBatchPause)
 	mux.HandleFunc("/api/v1/forward/batch-resume", h.forwardBatchResume)
 	mux.HandleFunc("/api/v1/forward/batch-redeploy", h.forwardBatchRedeploy)
 	mux.HandleFunc("/api/v1/forward/batch-change-tunnel", h.forwardBatchChangeTunnel)
+	mux.HandleFunc("/api/v1/certificate/list", h.certificateList)
+	mux.HandleFunc("/api/v1/certificate/create", h.certificateCreate)
+	mux.HandleFunc("/api/v1/certificate/update", h.certificateUpdate)
+	mux.HandleFunc("/api/v1/certificate/issue", h.certificateIssue)
+	mux.HandleFunc("/api/v1/certificate/delete", h.certificateDelete)
 	mux.HandleFunc("/api/v1/speed-limit/list", h.speedLimitList)
 	mux.HandleFunc("/api/v1/speed-limit/create", h.speedLimitCreate)
 	mux.HandleFunc("/api/v1/speed-limit/update", h.speedLimitUpdate)
@@ -190,6 +279,9 @@ func (h *Handler) Register(mux *http.ServeMux) {
 	mux.HandleFunc("/flow/test", h.flowTest)
 	mux.HandleFunc("/flow/config", h.flowConfig)
 	mux.HandleFunc("/flow/upload", h.flowUpload)
+	mux.HandleFunc("/flow/enroll", h.flowEnroll)
+	mux.HandleFunc("/flow/certificate", h.flowCertificate)
+	mux.HandleFunc("/flow/release/", h.flowReleaseFile)
 	mux.HandleFunc("/error", h.errorPage)
 }
 
@@ -360,13 +452,29 @@ func (h *Handler) nodeList(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var req map[string]interface{}
+	_ = decodeJSON(r.Body, &req)
+
 	items, err := h.repo.ListNodes()
 	if err != nil {
 		response.WriteJSON(w, response.Err(-2, err.Error()))
 		return
 	}
+	if raw := strings.TrimSpace(asString(req["labels"])); raw != "" {
+		sel, err := parseLabelSelector(raw)
+		if err != nil {
+			response.WriteJSON(w, response.ErrDefault(err.Error()))
+			return
+		}
+		items = filterNodesByLabels(items, sel)
+	}
 
 	h.syncRemoteNodeStatuses(items)
+	if pending, err := h.repo.CountPendingNodeCommands(); err == nil {
+		for _, item := range items {
+			item["pendingCommands"] = pending[asInt64(item["id"], 0)]
+		}
+	}
 
 	response.WriteJSON(w, response.OK(items))
 }
@@ -574,6 +682,8 @@ func (h *Handler) userTunnelList(w http.ResponseWriter, r *http.Request) {
 			"tunnelFlow":     t.TunnelFlow,
 			"speedId":        nil,
 			"speedLimitName": nil,
+			"allowCidrs":     t.AllowCIDRs,
+			"denyCidrs":      t.DenyCIDRs,
 		}
 		if t.SpeedID.Valid {
 			item["speedId"] = t.SpeedID.Int64
@@ -693,10 +803,15 @@ func (h *Handler) flowConfig(w http.ResponseWriter, r *http.Request) {
 		_, _ = w.Write([]byte("ok"))
 		return
 	}
+	if !h.authorizeNodeTransport(r, node.ID) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
 
 	rawData, err := readAndDecryptFlowBody(r.Body, secret)
 	if err == nil && strings.TrimSpace(rawData) != "" {
 		h.cleanNodeConfigs(node.ID, rawData)
+		go h.reconcileNodeReport(node.ID, rawData)
 	}
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	_, _ = w.Write([]byte("ok"))
@@ -704,18 +819,27 @@ func (h *Handler) flowConfig(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) flowUpload(w http.ResponseWriter, r *http.Request) {
 	secret := r.URL.Query().Get("secret")
-	if ok, _ := h.repo.NodeExistsBySecret(secret); !ok {
+	node, err := h.repo.GetNodeBySecret(secret)
+	if err != nil || node == nil {
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		_, _ = w.Write([]byte("ok"))
 		return
 	}
+	if !h.authorizeNodeTransport(r, node.ID) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
 
 	raw, err := readAndDecryptFlowBody(r.Body, secret)
 	if err == nil && strings.TrimSpace(raw) != "" {
 		var items []flowItem
 		if json.Unmarshal([]byte(raw), &items) == nil {
-			for _, item := range items {
-				h.processFlowItem(item)
+			report := h.planFlowReport(node.ID, items)
+			if _, err := h.applyFlowReport(node.ID, parseFlowSeq(r.URL.Query().Get("seq")), report); err != nil {
+				// Nothing was applied; the agent keeps the report in its
+				// journal and resends it.
+				http.Error(w, "retry", http.StatusServiceUnavailable)
+				return
 			}
 		}
 	}
@@ -0,0 +1,227 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+
+	"go-backend/internal/http/response"
+)
+
+// An HTTP proxy forward keeps its entry service but runs the agent's
+// forward handler with HTTP sniffing: each request is re-written (Host,
+// extra headers, X-Forwarded-For) and proxied to the targets. With a
+// certificate the listener terminates TLS first, either as a dedicated
+// "tls" listener or on the shared vhost socket after SNI routing.
+const (
+	forwardProxyModeHTTP = "http"
+
+	maxProxyHeaders = 30
+)
+
+// parseProxyHeaders parses "Name: value" lines into a header map.
+func parseProxyHeaders(raw string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, line := range strings.Split(raw, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || !isHTTPHeaderToken(name) {
+			return nil, fmt.Errorf("无效的请求头: %s", line)
+		}
+		headers[textproto.CanonicalMIMEHeaderKey(name)] = strings.TrimSpace(value)
+	}
+	if len(headers) > maxProxyHeaders {
+		return nil, fmt.Errorf("请求头最多 %d 条", maxProxyHeaders)
+	}
+	return headers, nil
+}
+
+func isHTTPHeaderToken(s string) bool {
+	for _, c := range s {
+		if c <= ' ' || c >= 0x7f || strings.ContainsRune("()<>@,;:\\\"/[]?={}", c) {
+			return false
+		}
+	}
+	return true
+}
+
+func normalizeProxyHeaders(raw string) (string, error) {
+	headers, err := parseProxyHeaders(raw)
+	if err != nil {
+		return "", err
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		lines = append(lines, name+": "+headers[name])
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// applyHTTPProxyService turns a forward's TCP entry service into an HTTP
+// reverse proxy.
+func applyHTTPProxyService(service map[string]interface{}, forward *forwardRecord) {
+	handler := service["handler"].(map[string]interface{})
+	handler["metadata"] = map[string]interface{}{
+		"sniffing":          true,
+		"http.keepalive":    true,
+		"http.forwardedFor": true,
+	}
+
+	proto := "http"
+	if forward.CertificateID > 0 {
+		proto = "https"
+	}
+	headers, _ := parseProxyHeaders(forward.ProxyHeaders)
+	if _, ok := headers["X-Forwarded-Proto"]; !ok {
+		headers["X-Forwarded-Proto"] = proto
+	}
+	nodeHTTP := map[string]interface{}{"requestHeader": headers}
+	if forward.ProxyHost != "" {
+		nodeHTTP["host"] = forward.ProxyHost
+	}
+	forwarder := service["forwarder"].(map[string]interface{})
+	for _, node := range forwarder["nodes"].([]map[string]interface{}) {
+		node["http"] = nodeHTTP
+	}
+
+	if forward.CertificateID <= 0 {
+		return
+	}
+	certFile, keyFile := certificateAgentPaths(forward.CertificateID)
+	listener := service["listener"].(map[string]interface{})
+	if listener["type"] == vhostListenerType {
+		listener["metadata"].(map[string]interface{})["tls"] = true
+	} else {
+		listener["type"] = "tls"
+	}
+	listener["tls"] = map[string]interface{}{
+		"certFile": certFile,
+		"keyFile":  keyFile,
+		// The proxy speaks HTTP/1.1 to clients.
+		"options": map[string]interface{}{"alpn": []string{"http/1.1"}},
+	}
+}
+
+// validateForwardCertificate checks that a certificate can serve the forward
+// on all of its entry nodes.
+func (h *Handler) validateForwardCertificate(forward *forwardRecord, certificateID int64) error {
+	cert, err := h.repo.GetCertificate(certificateID)
+	if err != nil {
+		return err
+	}
+	if cert == nil {
+		return errors.New("证书不存在")
+	}
+	if cert.CertData == "" {
+		return errors.New("证书尚未签发")
+	}
+	if forward.Domain != "" {
+		if !certificateCoversDomain(strings.Split(cert.Domains, "\n"), forward.Domain) {
+			return fmt.Errorf("证书不包含域名 %s", forward.Domain)
+		}
+	}
+	ports, err := h.listForwardPorts(forward.ID)
+	if err != nil {
+		return err
+	}
+	for _, fp := range ports {
+		if forward.Domain != "" && fp.Port == 80 {
+			return errors.New("80 端口的域名转发不支持 TLS，请使用 443 端口")
+		}
+		node, err := h.getNodeRecord(fp.NodeID)
+		if err != nil {
+			return err
+		}
+		if node.IsRemote == 1 {
+			return fmt.Errorf("远程节点 %s 不支持证书下发", node.Name)
+		}
+	}
+	return nil
+}
+
+// forwardHTTPProxy switches a forward between plain TCP forwarding and HTTP
+// reverse proxying. Only administrators may attach certificates.
+func (h *Handler) forwardHTTPProxy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req map[string]interface{}
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	id := asInt64(req["id"], 0)
+	if id <= 0 {
+		response.WriteJSON(w, response.ErrDefault("转发ID不能为空"))
+		return
+	}
+	forward, _, roleID, err := h.resolveForwardAccess(r, id)
+	if err != nil {
+		if errors.Is(err, errForwardNotFound) {
+			response.WriteJSON(w, response.ErrDefault("转发不存在"))
+			return
+		}
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+
+	mode := asString(req["proxyMode"])
+	if mode != "" && mode != forwardProxyModeHTTP {
+		response.WriteJSON(w, response.ErrDefault("不支持的代理模式"))
+		return
+	}
+	certificateID := asInt64(req["certificateId"], 0)
+	host := strings.ToLower(asString(req["proxyHost"]))
+	headers, err := normalizeProxyHeaders(asString(req["proxyHeaders"]))
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
+	if mode == "" {
+		certificateID, host, headers = 0, "", ""
+	}
+	if len(host) > 255 || strings.ContainsAny(host, " \t\r\n/") {
+		response.WriteJSON(w, response.ErrDefault("无效的 Host"))
+		return
+	}
+	if certificateID != forward.CertificateID && certificateID > 0 && roleID != 0 {
+		response.WriteJSON(w, response.Err(403, "权限不足，仅管理员可配置证书"))
+		return
+	}
+
+	next := *forward
+	next.ProxyMode, next.CertificateID, next.ProxyHost, next.ProxyHeaders = mode, certificateID, host, headers
+	if certificateID > 0 {
+		if err := h.validateForwardCertificate(&next, certificateID); err != nil {
+			response.WriteJSON(w, response.ErrDefault(err.Error()))
+			return
+		}
+	}
+
+	now := time.Now().UnixMilli()
+	if err := h.repo.UpdateForwardHTTPProxy(id, mode, certificateID, host, headers, now); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if err := h.resyncForwardServices(&next); err != nil {
+		_ = h.repo.UpdateForwardHTTPProxy(id, forward.ProxyMode, forward.CertificateID, forward.ProxyHost, forward.ProxyHeaders, now)
+		_ = h.resyncForwardServices(forward)
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OKEmpty())
+}
@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go-backend/internal/store/model"
+	"go-backend/internal/store/repo"
+)
+
+func TestNormalizeProxyHeaders(t *testing.T) {
+	got, err := normalizeProxyHeaders("x-real-host: a.example.com\n\n  Authorization :  Basic abc  ")
+	if err != nil {
+		t.Fatalf("normalize: %v", err)
+	}
+	if got != "Authorization: Basic abc\nX-Real-Host: a.example.com" {
+		t.Fatalf("unexpected headers: %q", got)
+	}
+
+	for _, bad := range []string{"no-colon", ": empty", "bad name: v", "a/b: v"} {
+		if _, err := normalizeProxyHeaders(bad); err == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestCertificateCoversDomain(t *testing.T) {
+	names := []string{"example.com", "*.app.example.com"}
+	for domain, want := range map[string]bool{
+		"example.com":         true,
+		"www.example.com":     false,
+		"a.app.example.com":   true,
+		"a.b.app.example.com": false,
+		"app.example.com":     false,
+		"*.app.example.com":   true,
+		"*.example.com":       false,
+	} {
+		if got := certificateCoversDomain(names, domain); got != want {
+			t.Fatalf("%s: expected %v, got %v", domain, want, got)
+		}
+	}
+}
+
+func TestBuildForwardServiceConfigsHTTPProxy(t *testing.T) {
+	forward := &forwardRecord{
+		ID: 1, UserID: 2, TunnelID: 3, RemoteAddr: "10.0.0.1:8080",
+		ProxyMode: forwardProxyModeHTTP, CertificateID: 7,
+		ProxyHost: "backend.internal", ProxyHeaders: "X-Env: prod",
+	}
+	node := &nodeRecord{ID: 4, TCPListenAddr: "[::]", UDPListenAddr: "[::]"}
+	services := buildForwardServiceConfigs("1_2_3", forward, &tunnelRecord{ID: 3, Type: 1}, node, 8443, nil, false)
+	if len(services) != 1 || services[0]["name"] != "1_2_3_tcp" {
+		t.Fatalf("expected a single tcp service, got %v", services)
+	}
+
+	listener := services[0]["listener"].(map[string]interface{})
+	if listener["type"] != "tls" {
+		t.Fatalf("expected tls listener, got %v", listener["type"])
+	}
+	tlsCfg := listener["tls"].(map[string]interface{})
+	if tlsCfg["certFile"] != "certs/cert_7.crt" || tlsCfg["keyFile"] != "certs/cert_7.key" {
+		t.Fatalf("unexpected tls config: %v", tlsCfg)
+	}
+
+	md := services[0]["handler"].(map[string]interface{})["metadata"].(map[string]interface{})
+	if md["sniffing"] != true || md["http.forwardedFor"] != true {
+		t.Fatalf("unexpected handler metadata: %v", md)
+	}
+	nodes := services[0]["forwarder"].(map[string]interface{})["nodes"].([]map[string]interface{})
+	nodeHTTP := nodes[0]["http"].(map[string]interface{})
+	headers := nodeHTTP["requestHeader"].(map[string]string)
+	if nodeHTTP["host"] != "backend.internal" || headers["X-Env"] != "prod" || headers["X-Forwarded-Proto"] != "https" {
+		t.Fatalf("unexpected node http settings: %v", nodeHTTP)
+	}
+
+	// Shared-port domain forwards keep the vhost listener and terminate TLS
+	// after SNI routing.
+	forward.Domain = "app.example.com"
+	services = buildForwardServiceConfigs("1_2_3", forward, &tunnelRecord{ID: 3, Type: 1}, node, 443, nil, false)
+	listener = services[0]["listener"].(map[string]interface{})
+	if listener["type"] != vhostListenerType || listener["metadata"].(map[string]interface{})["tls"] != true {
+		t.Fatalf("expected vhost listener with tls, got %v", listener)
+	}
+
+	// Without a certificate the proxy stays on plain HTTP.
+	forward.Domain, forward.CertificateID = "", 0
+	services = buildForwardServiceConfigs("1_2_3", forward, &tunnelRecord{ID: 3, Type: 1}, node, 8080, nil, false)
+	listener = services[0]["listener"].(map[string]interface{})
+	if listener["type"] != "tcp" || listener["tls"] != nil {
+		t.Fatalf("expected plain tcp listener, got %v", listener)
+	}
+	nodes = services[0]["forwarder"].(map[string]interface{})["nodes"].([]map[string]interface{})
+	if nodes[0]["http"].(map[string]interface{})["requestHeader"].(map[string]string)["X-Forwarded-Proto"] != "http" {
+		t.Fatalf("expected X-Forwarded-Proto http")
+	}
+}
+
+func TestStoreCertificateMaterialEncrypts(t *testing.T) {
+	r, err := repo.Open(filepath.Join(t.TempDir(), "cert.db"))
+	if err != nil {
+		t.Fatalf("open repo: %v", err)
+	}
+	defer r.Close()
+	h := &Handler{repo: r, jwtSecret: "test-secret"}
+
+	certPEM, keyPEM := selfSignedPair(t, "app.example.com", "*.api.example.com")
+	now := time.Now().UnixMilli()
+	id, err := r.CreateCertificate(&model.Certificate{Name: "web", Source: certificateSourceUpload, CreatedTime: now, UpdatedTime: now})
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	if err := h.storeCertificateMaterial(id, certPEM, keyPEM); err != nil {
+		t.Fatalf("store: %v", err)
+	}
+
+	cert, _ := r.GetCertificate(id)
+	if cert.Status != certificateStatusIssued || cert.Domains != "app.example.com\n*.api.example.com" {
+		t.Fatalf("unexpected certificate: status %d domains %q", cert.Status, cert.Domains)
+	}
+	if strings.Contains(cert.CertData, "BEGIN") || strings.Contains(cert.KeyData, "BEGIN") {
+		t.Fatalf("expected encrypted material")
+	}
+	gotCert, gotKey, err := h.loadCertificatePEM(cert)
+	if err != nil || gotCert != certPEM || gotKey != keyPEM {
+		t.Fatalf("round trip failed: %v", err)
+	}
+
+	// A key from another pair is rejected.
+	_, otherKey := selfSignedPair(t, "app.example.com")
+	if err := h.storeCertificateMaterial(id, certPEM, otherKey); err == nil {
+		t.Fatalf("expected mismatched key to be rejected")
+	}
+}
+
+func selfSignedPair(t *testing.T, domains ...string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: domains[0]},
+		DNSNames:     domains,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(30 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("cert: %v", err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
@@ -18,11 +18,17 @@ func (h *Handler) StartBackgroundJobs() {
 	ctx, cancel := context.WithCancel(context.Background())
 	h.jobsCancel = cancel
 	h.jobsStarted = true
-	h.jobsWG.Add(2)
+	h.jobsWG.Add(6)
 	h.jobsMu.Unlock()
 
+	h.pauseInterruptedRollouts()
+
 	go h.runHourlyStatsLoop(ctx)
 	go h.runDailyMaintenanceLoop(ctx)
+	go h.runCertificateRenewalLoop(ctx)
+	go h.runForwardScheduleLoop(ctx)
+	go h.runNodeMetricsLoop(ctx)
+	go h.runAlertLoop(ctx)
 }
 
 func (h *Handler) StopBackgroundJobs() {
@@ -82,6 +88,89 @@ func (h *Handler) runDailyMaintenanceLoop(ctx context.Context) {
 	}
 }
 
+// certificateRenewalInterval is how often ACME certificates are checked for
+// renewal; the first check runs shortly after start.
+const certificateRenewalInterval = 12 * time.Hour
+
+func (h *Handler) runCertificateRenewalLoop(ctx context.Context) {
+	defer h.jobsWG.Done()
+
+	wait := time.Minute
+	for {
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			if !timer.Stop() {
+				<-timer.C
+			}
+			return
+		case <-timer.C:
+			h.runCertificateRenewalJob(time.Now())
+		}
+		wait = certificateRenewalInterval
+	}
+}
+
+// runForwardScheduleLoop applies forward schedules at the start of every
+// minute, the granularity of weekly windows.
+func (h *Handler) runForwardScheduleLoop(ctx context.Context) {
+	defer h.jobsWG.Done()
+
+	for {
+		now := time.Now()
+		timer := time.NewTimer(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+		select {
+		case <-ctx.Done():
+			if !timer.Stop() {
+				<-timer.C
+			}
+			return
+		case <-timer.C:
+			h.runForwardScheduleJob(time.Now())
+		}
+	}
+}
+
+// runNodeMetricsLoop flushes node resource samples at the start of every
+// minute, then rolls up any completed hour still missing its hourly bucket.
+func (h *Handler) runNodeMetricsLoop(ctx context.Context) {
+	defer h.jobsWG.Done()
+
+	for {
+		now := time.Now()
+		timer := time.NewTimer(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+		select {
+		case <-ctx.Done():
+			if !timer.Stop() {
+				<-timer.C
+			}
+			return
+		case <-timer.C:
+			now := time.Now()
+			h.runNodeMetricsFlushJob(now)
+			h.runNodeMetricsRollupJob(now)
+		}
+	}
+}
+
+// runAlertLoop evaluates node alert rules every alertEvalInterval.
+func (h *Handler) runAlertLoop(ctx context.Context) {
+	defer h.jobsWG.Done()
+
+	for {
+		timer := time.NewTimer(alertEvalInterval)
+		select {
+		case <-ctx.Done():
+			if !timer.Stop() {
+				<-timer.C
+			}
+			return
+		case <-timer.C:
+			h.runAlertJob(time.Now())
+		}
+	}
+}
+
 func durationUntilNextHour(now time.Time) time.Duration {
 	next := now.Truncate(time.Hour).Add(time.Hour)
 	return next.Sub(now)
@@ -137,6 +226,8 @@ func (h *Handler) runResetAndExpiryJob(now time.Time) {
 	h.resetMonthlyFlow(now)
 	h.disableExpiredUsers(now.UnixMilli())
 	h.disableExpiredUserTunnels(now.UnixMilli())
+	h.expireNodeOutbox(now)
+	h.pruneFlowSeqs(now)
 }
 
 func (h *Handler) resetMonthlyFlow(now time.Time) {
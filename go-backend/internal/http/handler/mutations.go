@@ -247,9 +247,15 @@ func (h *Handler) nodeCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	labels, capacityMbps, maxForwards, err := nodePlacementFromRequest(req, "", 0, 0)
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
+
 	now := time.Now().UnixMilli()
 	inx := h.repo.NextIndex("node")
-	if err := h.repo.CreateNode(
+	nodeID, err := h.repo.CreateNode(
 		name,
 		randomToken(16),
 		serverIP,
@@ -270,10 +276,17 @@ func (h *Handler) nodeCreate(w http.ResponseWriter, r *http.Request) {
 		nullableText(asString(req["remoteUrl"])),
 		nullableText(asString(req["remoteToken"])),
 		nullableText(asString(req["remoteConfig"])),
-	); err != nil {
+	)
+	if err != nil {
 		response.WriteJSON(w, response.Err(-2, err.Error()))
 		return
 	}
+	if labels != "" || capacityMbps > 0 || maxForwards > 0 {
+		if err := h.repo.UpdateNodePlacement(nodeID, labels, capacityMbps, maxForwards); err != nil {
+			response.WriteJSON(w, response.Err(-2, err.Error()))
+			return
+		}
+	}
 	response.WriteJSON(w, response.OKEmpty())
 }
 
@@ -303,6 +316,17 @@ func (h *Handler) nodeUpdate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	curLabels, curCapacity, curMax, err := h.repo.GetNodePlacement(id)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	labels, capacityMbps, maxForwards, err := nodePlacementFromRequest(req, curLabels, curCapacity, curMax)
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
+
 	newHTTP := asInt(req["http"], currentHTTP)
 	newTLS := asInt(req["tls"], currentTLS)
 	newSocks := asInt(req["socks"], currentSocks)
@@ -331,6 +355,10 @@ func (h *Handler) nodeUpdate(w http.ResponseWriter, r *http.Request) {
 		response.WriteJSON(w, response.Err(-2, err.Error()))
 		return
 	}
+	if err := h.repo.UpdateNodePlacement(id, labels, capacityMbps, maxForwards); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
 	response.WriteJSON(w, response.OKEmpty())
 }
 
@@ -350,6 +378,14 @@ func (h *Handler) nodeDelete(w http.ResponseWriter, r *http.Request) {
 	response.WriteJSON(w, response.OKEmpty())
 }
 
+// nodeInstallCommand returns the install command pinned to version.
+// credential is "-s <secret>" for an existing node or "-t <token>" for an
+// enrollment.
+func nodeInstallCommand(version, panelAddr, credential string) string {
+	script := fmt.Sprintf(githubProxy+"/%s/%s/releases/download/%s/install.sh", githubHTMLBase, githubRepo, version)
+	return fmt.Sprintf("curl -L %s -o ./install.sh && chmod +x ./install.sh && VERSION=%s ./install.sh -a %s %s", script, version, processServerAddress(panelAddr), credential)
+}
+
 func (h *Handler) nodeInstall(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		response.WriteJSON(w, response.ErrDefault("请求失败"))
@@ -390,8 +426,7 @@ func (h *Handler) nodeInstall(w http.ResponseWriter, r *http.Request) {
 		response.WriteJSON(w, response.Err(-2, err.Error()))
 		return
 	}
-	cmd := fmt.Sprintf("curl -L https://gcode.hostcentral.cc/https://github.com/Sagit-chu/flvx/releases/download/%s/install.sh -o ./install.sh && chmod +x ./install.sh && VERSION=%s ./install.sh -a %s -s %s", version, version, processServerAddress(panelAddr), secret)
-	response.WriteJSON(w, response.OK(cmd))
+	response.WriteJSON(w, response.OK(nodeInstallCommand(version, panelAddr, "-s "+secret)))
 }
 
 func (h *Handler) nodeUpdateOrder(w http.ResponseWriter, r *http.Request) {
@@ -469,6 +504,11 @@ func (h *Handler) tunnelCreate(w http.ResponseWriter, r *http.Request) {
 	}
 
 	typeVal := asInt(req["type"], 1)
+	inSelector, outSelector, err := h.expandTunnelNodeSelectors(req, 0, typeVal)
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
 	flow := asInt64(req["flow"], 1)
 	status := asInt(req["status"], 1)
 	trafficRatio := asFloat(req["trafficRatio"], 1.0)
@@ -548,17 +588,19 @@ func (h *Handler) tunnelCreate(w http.ResponseWriter, r *http.Request) {
 		tunnelInIP = sql.NullString{String: trimmed, Valid: true}
 	}
 	tunnel := model.Tunnel{
-		Name:         name,
-		TrafficRatio: trafficRatio,
-		Type:         typeVal,
-		Protocol:     "tls",
-		Flow:         flow,
-		CreatedTime:  now,
-		UpdatedTime:  now,
-		Status:       status,
-		InIP:         tunnelInIP,
-		Inx:          inx,
-		IPPreference: ipPreference,
+		Name:            name,
+		TrafficRatio:    trafficRatio,
+		Type:            typeVal,
+		Protocol:        "tls",
+		Flow:            flow,
+		CreatedTime:     now,
+		UpdatedTime:     now,
+		Status:          status,
+		InIP:            tunnelInIP,
+		Inx:             inx,
+		IPPreference:    ipPreference,
+		InNodeSelector:  inSelector,
+		OutNodeSelector: outSelector,
 	}
 	if err := tx.Create(&tunnel).Error; err != nil {
 		response.WriteJSON(w, response.Err(-2, err.Error()))
@@ -666,11 +708,28 @@ func (h *Handler) tunnelUpdate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	typeVal := asInt(req["type"], 1)
+	storedIn, storedOut, err := h.repo.GetTunnelNodeSelectors(id)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if _, ok := req["inNodeSelector"]; !ok {
+		req["inNodeSelector"] = storedIn
+	}
+	if _, ok := req["outNodeSelector"]; !ok {
+		req["outNodeSelector"] = storedOut
+	}
+	inSelector, outSelector, err := h.expandTunnelNodeSelectors(req, id, typeVal)
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
+
 	h.cleanupTunnelRuntime(id)
 	h.cleanupFederationRuntime(id)
 
 	now := time.Now().UnixMilli()
-	typeVal := asInt(req["type"], 1)
 	ipPreference := asString(req["ipPreference"])
 	localDomain := h.federationLocalDomain()
 
@@ -716,6 +775,11 @@ func (h *Handler) tunnelUpdate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := h.repo.UpdateTunnelNodeSelectorsTx(tx, id, inSelector, outSelector); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+
 	if err := h.repo.DeleteChainTunnelsByTunnelTx(tx, id); err != nil {
 		response.WriteJSON(w, response.Err(-2, err.Error()))
 		return
@@ -783,11 +847,23 @@ func (h *Handler) tunnelDiagnose(w http.ResponseWriter, r *http.Request) {
 		response.WriteJSON(w, response.ErrDefault("请求失败"))
 		return
 	}
-	id := asInt64FromBodyKey(r, w, "tunnelId")
+	var req map[string]interface{}
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	id := asInt64(req["tunnelId"], 0)
 	if id <= 0 {
+		response.WriteJSON(w, response.ErrDefault("参数错误"))
 		return
 	}
-	result, err := h.diagnoseTunnelRuntime(id)
+	opts, err := parseDiagnoseOptions(req)
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
+	extendDiagnoseDeadline(w)
+	result, err := h.diagnoseTunnelRuntime(id, opts)
 	if err != nil {
 		if strings.Contains(err.Error(), "不存在") || strings.Contains(err.Error(), "不完整") {
 			response.WriteJSON(w, response.ErrDefault(err.Error()))
@@ -1103,14 +1179,26 @@ func (h *Handler) forwardCreate(w http.ResponseWriter, r *http.Request) {
 		response.WriteJSON(w, response.ErrDefault("转发名称和目标地址不能为空"))
 		return
 	}
+	domain, err := normalizeForwardDomain(asString(req["domain"]))
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
 	port := asInt(req["inPort"], 0)
+	if port <= 0 && domain != "" {
+		port = 443
+	}
 	if port <= 0 {
 		port = h.pickTunnelPort(tunnelID)
 	}
 	if port <= 0 {
 		port = 10000
 	}
-	entryNodes, _ := h.tunnelEntryNodeIDs(tunnelID)
+	entryNodes, _ := h.activeTunnelEntryNodeIDs(tunnelID)
+	if len(entryNodes) == 0 {
+		response.WriteJSON(w, response.ErrDefault("隧道没有可用的入口节点"))
+		return
+	}
 	for _, nodeID := range entryNodes {
 		node, nodeErr := h.getNodeRecord(nodeID)
 		if nodeErr != nil {
@@ -1120,6 +1208,14 @@ func (h *Handler) forwardCreate(w http.ResponseWriter, r *http.Request) {
 			response.WriteJSON(w, response.ErrDefault(err.Error()))
 			return
 		}
+		if err := h.nodeForwardCapacityError(node); err != nil {
+			response.WriteJSON(w, response.ErrDefault(err.Error()))
+			return
+		}
+	}
+	if err := h.validateForwardListenPlacement(domain, port, entryNodes, 0); err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
 	}
 	now := time.Now().UnixMilli()
 	inx := h.repo.NextIndex("forward")
@@ -1127,7 +1223,7 @@ func (h *Handler) forwardCreate(w http.ResponseWriter, r *http.Request) {
 	if userName == "" {
 		userName = "user"
 	}
-	forwardID, err := h.repo.CreateForwardTx(userID, userName, name, tunnelID, remoteAddr, defaultString(asString(req["strategy"]), "fifo"), now, inx, entryNodes, port)
+	forwardID, err := h.repo.CreateForwardTx(userID, userName, name, tunnelID, remoteAddr, defaultString(asString(req["strategy"]), "fifo"), domain, now, inx, entryNodes, port)
 	if err != nil {
 		response.WriteJSON(w, response.Err(-2, err.Error()))
 		return
@@ -1203,7 +1299,24 @@ func (h *Handler) forwardUpdate(w http.ResponseWriter, r *http.Request) {
 		strategy = forward.Strategy
 	}
 
+	domain := forward.Domain
+	if v, ok := req["domain"]; ok {
+		domain, err = normalizeForwardDomain(asString(v))
+		if err != nil {
+			response.WriteJSON(w, response.ErrDefault(err.Error()))
+			return
+		}
+	}
+
 	port := asInt(req["inPort"], 0)
+	if port <= 0 && domain != forward.Domain && (domain == "" || forward.Domain == "") {
+		// Switching between shared and dedicated mode moves the listener.
+		if domain != "" {
+			port = 443
+		} else {
+			port = h.pickTunnelPort(tunnelID)
+		}
+	}
 	if port <= 0 {
 		minPort := h.repo.GetMinForwardPort(id)
 		if minPort.Valid {
@@ -1213,7 +1326,11 @@ func (h *Handler) forwardUpdate(w http.ResponseWriter, r *http.Request) {
 			port = h.pickTunnelPort(tunnelID)
 		}
 	}
-	fwdEntryNodes, _ := h.tunnelEntryNodeIDs(tunnelID)
+	fwdEntryNodes, _ := h.forwardEntryNodeIDs(id, tunnelID)
+	if len(fwdEntryNodes) == 0 {
+		response.WriteJSON(w, response.ErrDefault("隧道没有可用的入口节点"))
+		return
+	}
 	for _, nodeID := range fwdEntryNodes {
 		node, nodeErr := h.getNodeRecord(nodeID)
 		if nodeErr != nil {
@@ -1224,8 +1341,12 @@ func (h *Handler) forwardUpdate(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	if err := h.validateForwardListenPlacement(domain, port, fwdEntryNodes, id); err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
 	now := time.Now().UnixMilli()
-	if err := h.repo.UpdateForward(id, name, tunnelID, remoteAddr, strategy, now); err != nil {
+	if err := h.repo.UpdateForward(id, name, tunnelID, remoteAddr, strategy, domain, now); err != nil {
 		response.WriteJSON(w, response.Err(-2, err.Error()))
 		return
 	}
@@ -1266,6 +1387,7 @@ func (h *Handler) forwardDelete(w http.ResponseWriter, r *http.Request) {
 		response.WriteJSON(w, response.ErrDefault(err.Error()))
 		return
 	}
+	h.deleteForwardAdmissions(forward)
 	if err := h.deleteForwardByID(id); err != nil {
 		response.WriteJSON(w, response.Err(-2, err.Error()))
 		return
@@ -1322,8 +1444,23 @@ func (h *Handler) forwardResume(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) forwardDiagnose(w http.ResponseWriter, r *http.Request) {
-	id := asInt64FromBodyKey(r, w, "forwardId")
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req map[string]interface{}
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	id := asInt64(req["forwardId"], 0)
 	if id <= 0 {
+		response.WriteJSON(w, response.ErrDefault("参数错误"))
+		return
+	}
+	opts, err := parseDiagnoseOptions(req)
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
 		return
 	}
 	forward, _, _, err := h.resolveForwardAccess(r, id)
@@ -1335,7 +1472,8 @@ func (h *Handler) forwardDiagnose(w http.ResponseWriter, r *http.Request) {
 		response.WriteJSON(w, response.Err(-2, err.Error()))
 		return
 	}
-	payload, err := h.diagnoseForwardRuntime(forward)
+	extendDiagnoseDeadline(w)
+	payload, err := h.diagnoseForwardRuntime(forward, opts)
 	if err != nil {
 		if strings.Contains(err.Error(), "不存在") || strings.Contains(err.Error(), "不能为空") || strings.Contains(err.Error(), "错误") {
 			response.WriteJSON(w, response.ErrDefault(err.Error()))
@@ -1386,6 +1524,7 @@ func (h *Handler) forwardBatchDelete(w http.ResponseWriter, r *http.Request) {
 			f++
 			continue
 		}
+		h.deleteForwardAdmissions(forward)
 		if err := h.deleteForwardByID(id); err != nil {
 			f++
 		} else {
@@ -1543,7 +1682,7 @@ func (h *Handler) forwardBatchChangeTunnel(w http.ResponseWriter, r *http.Reques
 		if p <= 0 {
 			p = h.pickTunnelPort(req.TargetTunnelID)
 		}
-		bctEntryNodes, _ := h.tunnelEntryNodeIDs(req.TargetTunnelID)
+		bctEntryNodes, _ := h.forwardEntryNodeIDs(id, req.TargetTunnelID)
 		portRangeOk := true
 		for _, nid := range bctEntryNodes {
 			nd, ndErr := h.getNodeRecord(nid)
@@ -2028,6 +2167,9 @@ func (h *Handler) prepareTunnelCreateState(tx *gorm.DB, req map[string]interface
 		if node.IsRemote != 1 && node.Status != 1 {
 			return nil, errors.New("部分节点不在线")
 		}
+		if excludeTunnelID == 0 && node.Maintenance == 1 {
+			return nil, fmt.Errorf("节点 %s 正在维护中", node.Name)
+		}
 		state.Nodes[nodeID] = node
 	}
 
@@ -2822,8 +2964,46 @@ func (h *Handler) tunnelEntryNodeIDs(tunnelID int64) ([]int64, error) {
 	return h.repo.TunnelEntryNodeIDs(tunnelID)
 }
 
-func (h *Handler) pickTunnelPort(tunnelID int64) int {
+// activeTunnelEntryNodeIDs returns the entry nodes of a tunnel that new
+// forwards may land on, leaving out nodes in maintenance.
+func (h *Handler) activeTunnelEntryNodeIDs(tunnelID int64) ([]int64, error) {
+	return h.repo.TunnelActiveEntryNodeIDs(tunnelID)
+}
+
+// forwardEntryNodeIDs returns the entry nodes an existing forward should
+// listen on: the tunnel's active entry nodes plus the nodes in maintenance it
+// already listens on, so editing a forward does not drop it from those.
+func (h *Handler) forwardEntryNodeIDs(forwardID, tunnelID int64) ([]int64, error) {
 	entryNodes, err := h.tunnelEntryNodeIDs(tunnelID)
+	if err != nil {
+		return nil, err
+	}
+	activeNodes, err := h.activeTunnelEntryNodeIDs(tunnelID)
+	if err != nil {
+		return nil, err
+	}
+	ports, err := h.listForwardPorts(forwardID)
+	if err != nil {
+		return nil, err
+	}
+	keep := make(map[int64]struct{}, len(activeNodes)+len(ports))
+	for _, id := range activeNodes {
+		keep[id] = struct{}{}
+	}
+	for _, p := range ports {
+		keep[p.NodeID] = struct{}{}
+	}
+	result := make([]int64, 0, len(keep))
+	for _, id := range entryNodes {
+		if _, ok := keep[id]; ok {
+			result = append(result, id)
+		}
+	}
+	return result, nil
+}
+
+func (h *Handler) pickTunnelPort(tunnelID int64) int {
+	entryNodes, err := h.activeTunnelEntryNodeIDs(tunnelID)
 	if err != nil || len(entryNodes) == 0 {
 		return 10000
 	}
@@ -2924,7 +3104,7 @@ func parsePorts(portRange string) ([]int, error) {
 }
 
 func (h *Handler) replaceForwardPorts(forwardID, tunnelID int64, port int) error {
-	entryNodes, err := h.tunnelEntryNodeIDs(tunnelID)
+	entryNodes, err := h.forwardEntryNodeIDs(forwardID, tunnelID)
 	if err != nil {
 		return err
 	}
@@ -2962,7 +3142,7 @@ func (h *Handler) rollbackForwardMutation(oldForward *forwardRecord, oldPorts []
 
 	h.repo.RollbackForwardFields(
 		oldForward.ID, oldForward.UserID, oldForward.UserName, oldForward.Name,
-		oldForward.TunnelID, oldForward.RemoteAddr, oldForward.Strategy, oldForward.Status,
+		oldForward.TunnelID, oldForward.RemoteAddr, oldForward.Strategy, oldForward.Domain, oldForward.Status,
 		time.Now().UnixMilli(),
 	)
 
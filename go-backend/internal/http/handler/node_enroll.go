@@ -0,0 +1,338 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/store/model"
+	"go-backend/internal/store/repo"
+)
+
+// Enrollment tokens let an agent register itself: started with a token
+// instead of a secret, it posts its detected addresses to /flow/enroll, the
+// panel creates the node and answers with the node secret, and the agent
+// then connects over /system-info like any other node. An agent may send a
+// CSR along to be issued a client certificate for node mutual TLS.
+
+const (
+	enrollTokenPrefix        = "fxe_"
+	defaultEnrollTokenExpire = 24 * time.Hour
+	maxEnrollTokenExpire     = 365 * 24 * time.Hour
+	defaultNodePortRange     = "1000-65535"
+)
+
+func hashEnrollToken(token string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(token)))
+	return hex.EncodeToString(sum[:])
+}
+
+func (h *Handler) nodeEnrollTokenCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req map[string]interface{}
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	now := time.Now()
+	maxUses := asInt(req["maxUses"], 1)
+	if maxUses < 0 {
+		response.WriteJSON(w, response.ErrDefault("使用次数不能为负数"))
+		return
+	}
+	expire := now.Add(defaultEnrollTokenExpire)
+	if ms := asInt64(req["expireTime"], 0); ms > 0 {
+		expire = time.UnixMilli(ms)
+	} else if hours := asInt64(req["expireHours"], 0); hours > 0 {
+		expire = now.Add(time.Duration(hours) * time.Hour)
+	}
+	if !expire.After(now) || expire.Sub(now) > maxEnrollTokenExpire {
+		response.WriteJSON(w, response.ErrDefault("过期时间必须在一年以内"))
+		return
+	}
+	labels, err := normalizeNodeLabels(req["labels"])
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
+	port := strings.TrimSpace(asString(req["port"]))
+	if port != "" {
+		if _, err := parsePorts(port); err != nil {
+			response.WriteJSON(w, response.ErrDefault("端口范围格式错误"))
+			return
+		}
+	}
+
+	// The install command pins the release current at creation, so hosts
+	// enrolled later with the same token get the same agent.
+	installVersion := ""
+	panelAddr, _ := h.repo.GetViteConfigValue("ip")
+	if strings.TrimSpace(panelAddr) != "" {
+		channel := normalizeReleaseChannel(asString(req["channel"]))
+		installVersion, err = resolveLatestReleaseByChannel(channel)
+		if err != nil {
+			response.WriteJSON(w, response.Err(-2, fmt.Sprintf("获取最新%s失败: %v", releaseChannelLabel(channel), err)))
+			return
+		}
+	}
+
+	token := enrollTokenPrefix + randomToken(24)
+	record := model.NodeEnrollToken{
+		Name:        defaultString(strings.TrimSpace(asString(req["name"])), "enroll"),
+		TokenHash:   hashEnrollToken(token),
+		TokenPrefix: token[:len(enrollTokenPrefix)+6],
+		MaxUses:     maxUses,
+		ExpireTime:  expire.UnixMilli(),
+		Labels:      labels,
+		Port:        port,
+		CreatedTime: now.UnixMilli(),
+	}
+	if err := h.repo.CreateNodeEnrollToken(&record); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	out := enrollTokenView(record, now.UnixMilli())
+	// The token itself is only shown once.
+	out["token"] = token
+	if installVersion != "" {
+		out["command"] = nodeInstallCommand(installVersion, panelAddr, "-t "+token)
+	}
+	response.WriteJSON(w, response.OK(out))
+}
+
+func (h *Handler) nodeEnrollTokenList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	tokens, err := h.repo.ListNodeEnrollTokens()
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	now := time.Now().UnixMilli()
+	items := make([]map[string]interface{}, 0, len(tokens))
+	for _, t := range tokens {
+		items = append(items, enrollTokenView(t, now))
+	}
+	response.WriteJSON(w, response.OK(items))
+}
+
+func (h *Handler) nodeEnrollTokenDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	id := idFromBody(r, w)
+	if id <= 0 {
+		return
+	}
+	if err := h.repo.DeleteNodeEnrollToken(id); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OKEmpty())
+}
+
+func enrollTokenView(t model.NodeEnrollToken, now int64) map[string]interface{} {
+	return map[string]interface{}{
+		"id":           t.ID,
+		"name":         t.Name,
+		"tokenPrefix":  t.TokenPrefix,
+		"maxUses":      t.MaxUses,
+		"usedCount":    t.UsedCount,
+		"expireTime":   t.ExpireTime,
+		"labels":       nodeLabels(t.Labels),
+		"port":         t.Port,
+		"createdTime":  t.CreatedTime,
+		"lastUsedTime": t.LastUsedTime,
+		"expired":      t.ExpireTime <= now,
+		"exhausted":    t.MaxUses > 0 && t.UsedCount >= t.MaxUses,
+	}
+}
+
+// flowEnroll registers an agent holding an enrollment token as a new node
+// and returns the node secret. Agents send a random enrollment id; a retry
+// with the same token and id gets the node the first request created
+// instead of a second one.
+func (h *Handler) flowEnroll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req map[string]interface{}
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	token := strings.TrimSpace(asString(req["token"]))
+	if !strings.HasPrefix(token, enrollTokenPrefix) {
+		response.WriteJSON(w, response.ErrDefault("注册令牌无效或已过期"))
+		return
+	}
+	enrollID := strings.TrimSpace(asString(req["enrollId"]))
+	if len(enrollID) > 64 {
+		response.WriteJSON(w, response.ErrDefault("注册标识过长"))
+		return
+	}
+	csr := strings.TrimSpace(asString(req["csr"]))
+
+	h.enrollMu.Lock()
+	defer h.enrollMu.Unlock()
+	if enrollID != "" {
+		node, err := h.repo.FindEnrolledNode(hashEnrollToken(token), enrollID)
+		if err != nil {
+			response.WriteJSON(w, response.Err(-2, err.Error()))
+			return
+		}
+		if node != nil {
+			response.WriteJSON(w, response.OK(h.enrollResult(node.ID, node.Name, node.Secret, csr)))
+			return
+		}
+	}
+
+	now := time.Now().UnixMilli()
+	enroll, err := h.repo.ConsumeNodeEnrollToken(hashEnrollToken(token), now)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if enroll == nil {
+		response.WriteJSON(w, response.ErrDefault("注册令牌无效或已过期"))
+		return
+	}
+
+	ipv4, ipv6 := enrollAddresses(asString(req["serverIpV4"]), asString(req["serverIpV6"]), resolvePeerClientIP(r))
+	serverIP := defaultString(ipv4, ipv6)
+	if serverIP == "" {
+		_ = h.repo.ReleaseNodeEnrollToken(enroll.ID)
+		response.WriteJSON(w, response.ErrDefault("无法确定节点地址"))
+		return
+	}
+	name, err := h.uniqueNodeName(defaultString(strings.TrimSpace(asString(req["name"])), serverIP))
+	if err != nil {
+		_ = h.repo.ReleaseNodeEnrollToken(enroll.ID)
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+
+	// The node, its placement and the enrollment mark are written together so
+	// a retry never finds a node without the enrollment that created it.
+	secret := randomToken(16)
+	var nodeID int64
+	err = h.repo.Transaction(func(tx *repo.Repository) error {
+		id, err := tx.CreateNode(
+			name,
+			secret,
+			serverIP,
+			nullableText(ipv4),
+			nullableText(ipv6),
+			defaultString(enroll.Port, defaultNodePortRange),
+			nullableText(strings.TrimSpace(asString(req["interfaceName"]))),
+			nullableText(""),
+			0, 0, 0,
+			now,
+			0,
+			"[::]",
+			"[::]",
+			tx.NextIndex("node"),
+			0,
+			nullableText(""),
+			nullableText(""),
+			nullableText(""),
+		)
+		if err != nil {
+			return err
+		}
+		if enroll.Labels != "" {
+			if err := tx.UpdateNodePlacement(id, enroll.Labels, 0, 0); err != nil {
+				return err
+			}
+		}
+		if enrollID != "" {
+			if err := tx.SetNodeEnrollment(id, enroll.ID, enrollID); err != nil {
+				return err
+			}
+		}
+		nodeID = id
+		return nil
+	})
+	if err != nil {
+		_ = h.repo.ReleaseNodeEnrollToken(enroll.ID)
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(h.enrollResult(nodeID, name, secret, csr)))
+}
+
+// enrollResult is the /flow/enroll response for an enrolled node.
+func (h *Handler) enrollResult(nodeID int64, name, secret, csr string) map[string]interface{} {
+	out := map[string]interface{}{
+		"id":     nodeID,
+		"name":   name,
+		"secret": secret,
+	}
+	// Agents that send a CSR get a client certificate when node mTLS is on.
+	if csr != "" {
+		if _, enabled := h.nodeTLSEnabled(); enabled {
+			tlsInfo, err := h.issueNodeCertificate(nodeID, csr)
+			if err != nil {
+				out["certificateError"] = err.Error()
+			}
+			for k, v := range tlsInfo {
+				out[k] = v
+			}
+		}
+	}
+	return out
+}
+
+// enrollAddresses keeps the public addresses an agent detected and falls
+// back to the address its request came from.
+func enrollAddresses(reportedV4, reportedV6 string, peer net.IP) (string, string) {
+	var ipv4, ipv6 string
+	if ip := net.ParseIP(strings.TrimSpace(reportedV4)); ip != nil && ip.To4() != nil && isPublicIP(ip) {
+		ipv4 = ip.String()
+	}
+	if ip := net.ParseIP(strings.TrimSpace(reportedV6)); ip != nil && ip.To4() == nil && isPublicIP(ip) {
+		ipv6 = ip.String()
+	}
+	if peer != nil && !peer.IsLoopback() && !peer.IsUnspecified() {
+		if peer.To4() != nil && ipv4 == "" {
+			ipv4 = peer.String()
+		} else if peer.To4() == nil && ipv6 == "" {
+			ipv6 = peer.String()
+		}
+	}
+	return ipv4, ipv6
+}
+
+func isPublicIP(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
+
+// uniqueNodeName appends a numeric suffix while the name is taken.
+func (h *Handler) uniqueNodeName(base string) (string, error) {
+	if len(base) > 90 {
+		base = base[:90]
+	}
+	name := base
+	for i := 2; ; i++ {
+		taken, err := h.repo.NodeNameTaken(name)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return name, nil
+		}
+		name = fmt.Sprintf("%s-%d", base, i)
+	}
+}
@@ -0,0 +1,22 @@
+package handler
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNodeInstallCommandPinsVersion(t *testing.T) {
+	cmd := nodeInstallCommand("2.1.0", "panel.example.com:6365", "-t fxe_abc")
+	for _, want := range []string{
+		"/releases/download/2.1.0/install.sh",
+		"VERSION=2.1.0 ./install.sh",
+		"-a panel.example.com:6365 -t fxe_abc",
+	} {
+		if !strings.Contains(cmd, want) {
+			t.Fatalf("expected %q in %q", want, cmd)
+		}
+	}
+	if strings.Contains(cmd, "/latest/") {
+		t.Fatalf("expected no latest download in %q", cmd)
+	}
+}
@@ -0,0 +1,385 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"go-backend/internal/store/model"
+)
+
+// Node labels are free-form key/value pairs such as region=hk or tier=premium.
+// A label selector is a comma separated list of requirements, all of which
+// must hold: "key=value", "key!=value", "key" (label present) and "!key"
+// (label absent).
+
+var nodeLabelKeyPattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]{0,61}[A-Za-z0-9])?$`)
+
+const maxNodeLabelValue = 63
+
+type labelRequirement struct {
+	key   string
+	op    string // "=", "!=", "exists", "!exists"
+	value string
+}
+
+type labelSelector []labelRequirement
+
+func validNodeLabelValue(v string) bool {
+	return len(v) <= maxNodeLabelValue && !strings.ContainsAny(v, ",=!\n\r\t")
+}
+
+// normalizeNodeLabels accepts labels as an object or as "k=v,k=v" text and
+// returns them encoded as a JSON object with sorted keys.
+func normalizeNodeLabels(v interface{}) (string, error) {
+	labels := map[string]string{}
+	switch raw := v.(type) {
+	case nil:
+	case map[string]interface{}:
+		for k, val := range raw {
+			labels[strings.TrimSpace(k)] = strings.TrimSpace(asString(val))
+		}
+	case string:
+		text := strings.TrimSpace(raw)
+		if strings.HasPrefix(text, "{") {
+			var obj map[string]interface{}
+			if err := json.Unmarshal([]byte(text), &obj); err != nil {
+				return "", errors.New("标签格式错误")
+			}
+			return normalizeNodeLabels(obj)
+		}
+		for _, part := range strings.Split(text, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			k, val, ok := strings.Cut(part, "=")
+			if !ok {
+				return "", fmt.Errorf("标签 %s 格式错误，应为 key=value", part)
+			}
+			labels[strings.TrimSpace(k)] = strings.TrimSpace(val)
+		}
+	default:
+		return "", errors.New("标签格式错误")
+	}
+	if len(labels) == 0 {
+		return "", nil
+	}
+	for k, val := range labels {
+		if !nodeLabelKeyPattern.MatchString(k) {
+			return "", fmt.Errorf("无效的标签名 %q", k)
+		}
+		if !validNodeLabelValue(val) {
+			return "", fmt.Errorf("标签 %s 的值无效", k)
+		}
+	}
+	b, _ := json.Marshal(labels)
+	return string(b), nil
+}
+
+// nodePlacementFromRequest reads labels, capacityMbps and maxForwards from a
+// node request, keeping the current values for keys that are absent.
+func nodePlacementFromRequest(req map[string]interface{}, labels string, capacityMbps int64, maxForwards int) (string, int64, int, error) {
+	if v, ok := req["labels"]; ok {
+		normalized, err := normalizeNodeLabels(v)
+		if err != nil {
+			return "", 0, 0, err
+		}
+		labels = normalized
+	}
+	if _, ok := req["capacityMbps"]; ok {
+		capacityMbps = asInt64(req["capacityMbps"], 0)
+	}
+	if _, ok := req["maxForwards"]; ok {
+		maxForwards = asInt(req["maxForwards"], 0)
+	}
+	if capacityMbps < 0 || maxForwards < 0 {
+		return "", 0, 0, errors.New("节点容量和转发上限不能为负数")
+	}
+	return labels, capacityMbps, maxForwards, nil
+}
+
+func nodeLabels(raw string) map[string]string {
+	labels := map[string]string{}
+	if strings.TrimSpace(raw) != "" {
+		_ = json.Unmarshal([]byte(raw), &labels)
+	}
+	return labels
+}
+
+func parseLabelSelector(raw string) (labelSelector, error) {
+	var sel labelSelector
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		var req labelRequirement
+		switch {
+		case strings.Contains(part, "!="):
+			k, v, _ := strings.Cut(part, "!=")
+			req = labelRequirement{key: strings.TrimSpace(k), op: "!=", value: strings.TrimSpace(v)}
+		case strings.Contains(part, "="):
+			k, v, _ := strings.Cut(part, "=")
+			req = labelRequirement{key: strings.TrimSpace(strings.TrimSuffix(k, "=")), op: "=", value: strings.TrimSpace(strings.TrimPrefix(v, "="))}
+		case strings.HasPrefix(part, "!"):
+			req = labelRequirement{key: strings.TrimSpace(part[1:]), op: "!exists"}
+		default:
+			req = labelRequirement{key: part, op: "exists"}
+		}
+		if !nodeLabelKeyPattern.MatchString(req.key) || !validNodeLabelValue(req.value) {
+			return nil, fmt.Errorf("无效的标签选择器 %q", part)
+		}
+		sel = append(sel, req)
+	}
+	if len(sel) == 0 {
+		return nil, errors.New("标签选择器不能为空")
+	}
+	return sel, nil
+}
+
+func (sel labelSelector) matches(labels map[string]string) bool {
+	for _, req := range sel {
+		v, ok := labels[req.key]
+		switch req.op {
+		case "=":
+			if !ok || v != req.value {
+				return false
+			}
+		case "!=":
+			if ok && v == req.value {
+				return false
+			}
+		case "exists":
+			if !ok {
+				return false
+			}
+		case "!exists":
+			if ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// nodeForwardCapacityError reports whether a node has reached its forward limit.
+func (h *Handler) nodeForwardCapacityError(node *nodeRecord) error {
+	if node == nil || node.MaxForwards <= 0 {
+		return nil
+	}
+	count, err := h.repo.CountForwardsOnNode(node.ID)
+	if err != nil {
+		return err
+	}
+	if count >= int64(node.MaxForwards) {
+		return fmt.Errorf("节点 %s 转发数量已达上限 %d", node.Name, node.MaxForwards)
+	}
+	return nil
+}
+
+// selectableNode reports whether a local, online node outside maintenance
+// with room for forwards matches sel. Nodes already in the tunnel keep their
+// place even when full.
+func (h *Handler) selectableNode(node model.Node, sel labelSelector, member bool) bool {
+	if node.IsRemote == 1 || node.Status != 1 || node.Maintenance == 1 {
+		return false
+	}
+	if !sel.matches(nodeLabels(node.Labels)) {
+		return false
+	}
+	return member || h.nodeForwardCapacityError(&nodeRecord{ID: node.ID, Name: node.Name, MaxForwards: node.MaxForwards}) == nil
+}
+
+// selectNodesByLabels resolves a label selector to node IDs in display order.
+func (h *Handler) selectNodesByLabels(raw string, exclude, members map[int64]struct{}) ([]int64, error) {
+	sel, err := parseLabelSelector(raw)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := h.repo.ListAllNodes()
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].Inx < nodes[j].Inx })
+	ids := make([]int64, 0)
+	for _, node := range nodes {
+		if _, skip := exclude[node.ID]; skip {
+			continue
+		}
+		_, member := members[node.ID]
+		if h.selectableNode(node, sel, member) {
+			ids = append(ids, node.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("没有匹配标签选择器 %s 的可用节点", raw)
+	}
+	return ids, nil
+}
+
+// expandTunnelNodeSelectors replaces inNodeId/outNodeId in a tunnel request
+// with the nodes matched by inNodeSelector/outNodeSelector. Protocol and
+// strategy for selected nodes come from inNodeProtocol/inNodeStrategy and
+// outNodeProtocol/outNodeStrategy. It returns the selectors to store.
+func (h *Handler) expandTunnelNodeSelectors(req map[string]interface{}, tunnelID int64, tunnelType int) (string, string, error) {
+	inSelector := strings.TrimSpace(asString(req["inNodeSelector"]))
+	outSelector := strings.TrimSpace(asString(req["outNodeSelector"]))
+	if tunnelType != 2 {
+		outSelector = ""
+	}
+	members := make(map[int64]struct{})
+	if tunnelID > 0 && (inSelector != "" || outSelector != "") {
+		if state, err := h.reconstructTunnelState(tunnelID); err == nil {
+			for _, id := range state.NodeIDList {
+				members[id] = struct{}{}
+			}
+		}
+	}
+	used := make(map[int64]struct{})
+	if inSelector != "" {
+		ids, err := h.selectNodesByLabels(inSelector, nil, members)
+		if err != nil {
+			return "", "", err
+		}
+		req["inNodeId"] = selectedTunnelNodes(ids, req["inNodeProtocol"], req["inNodeStrategy"])
+		for _, id := range ids {
+			used[id] = struct{}{}
+		}
+	} else {
+		for _, item := range asMapSlice(req["inNodeId"]) {
+			used[asInt64(item["nodeId"], 0)] = struct{}{}
+		}
+	}
+	if outSelector != "" {
+		for _, hop := range asAnySlice(req["chainNodes"]) {
+			for _, item := range asMapSlice(hop) {
+				used[asInt64(item["nodeId"], 0)] = struct{}{}
+			}
+		}
+		// A node cannot be both entry and exit of the same tunnel.
+		ids, err := h.selectNodesByLabels(outSelector, used, members)
+		if err != nil {
+			return "", "", err
+		}
+		req["outNodeId"] = selectedTunnelNodes(ids, req["outNodeProtocol"], req["outNodeStrategy"])
+	}
+	return inSelector, outSelector, nil
+}
+
+func selectedTunnelNodes(ids []int64, protocol, strategy interface{}) []interface{} {
+	items := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		items = append(items, map[string]interface{}{
+			"nodeId":   id,
+			"protocol": defaultString(asString(protocol), "tls"),
+			"strategy": defaultString(asString(strategy), "round"),
+		})
+	}
+	return items
+}
+
+// joinSelectorTunnels adds a node that came online to every tunnel whose
+// entry selector it matches, then places the tunnel's forwards on it.
+func (h *Handler) joinSelectorTunnels(nodeID int64) {
+	if h == nil || h.repo == nil {
+		return
+	}
+	tunnels, err := h.repo.ListTunnelsWithInNodeSelector()
+	if err != nil || len(tunnels) == 0 {
+		return
+	}
+	var node *model.Node
+	nodes, err := h.repo.ListAllNodes()
+	if err != nil {
+		return
+	}
+	for i := range nodes {
+		if nodes[i].ID == nodeID {
+			node = &nodes[i]
+		}
+	}
+	if node == nil {
+		return
+	}
+	for _, tunnel := range tunnels {
+		sel, err := parseLabelSelector(tunnel.InNodeSelector)
+		if err != nil || !h.selectableNode(*node, sel, false) {
+			continue
+		}
+		if err := h.addTunnelEntryNode(tunnel.ID, nodeID); err != nil {
+			fmt.Printf("node selector: add node %d to tunnel %d failed: %v\n", nodeID, tunnel.ID, err)
+		}
+	}
+}
+
+func (h *Handler) addTunnelEntryNode(tunnelID, nodeID int64) error {
+	state, err := h.reconstructTunnelState(tunnelID)
+	if err != nil {
+		return err
+	}
+	strategy, protocol := "round", "tls"
+	for _, in := range state.InNodes {
+		if in.NodeID == nodeID {
+			return nil
+		}
+		strategy, protocol = defaultString(in.Strategy, strategy), defaultString(in.Protocol, protocol)
+	}
+	for _, id := range state.NodeIDList {
+		if id == nodeID {
+			return errors.New("节点已在隧道的转发链中")
+		}
+	}
+	if err := h.repo.AddTunnelEntryNode(tunnelID, nodeID, strategy, protocol); err != nil {
+		return err
+	}
+	if state, err = h.reconstructTunnelState(tunnelID); err != nil {
+		_ = h.repo.RemoveTunnelEntryNode(tunnelID, nodeID)
+		return err
+	}
+	if state.Type == 2 {
+		targets := state.OutNodes
+		if len(state.ChainHops) > 0 {
+			targets = state.ChainHops[0]
+		}
+		chainData, err := buildTunnelChainConfig(tunnelID, nodeID, targets, state.Nodes, state.IPPreference)
+		if err == nil {
+			_, err = h.sendNodeCommand(nodeID, "AddChains", chainData, true, false)
+		}
+		if err != nil {
+			_ = h.repo.RemoveTunnelEntryNode(tunnelID, nodeID)
+			return err
+		}
+	}
+	_ = h.repo.UpdateTunnelInIP(tunnelID, buildTunnelInIP(state.InNodes, state.Nodes, state.IPPreference), time.Now().UnixMilli())
+
+	forwards, err := h.listForwardsByTunnel(tunnelID)
+	if err != nil {
+		return err
+	}
+	for i := range forwards {
+		port := 0
+		if p := h.repo.GetMinForwardPort(forwards[i].ID); p.Valid {
+			port = int(p.Int64)
+		}
+		if _, err := h.moveForwardOntoNode(&forwards[i], nodeID, port); err != nil {
+			fmt.Printf("node selector: place forward %d on node %d failed: %v\n", forwards[i].ID, nodeID, err)
+		}
+	}
+	return nil
+}
+
+func filterNodesByLabels(items []map[string]interface{}, sel labelSelector) []map[string]interface{} {
+	out := make([]map[string]interface{}, 0, len(items))
+	for _, item := range items {
+		labels, _ := item["labels"].(map[string]string)
+		if sel.matches(labels) {
+			out = append(out, item)
+		}
+	}
+	return out
+}
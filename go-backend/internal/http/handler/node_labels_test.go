@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-backend/internal/store/model"
+	"go-backend/internal/store/repo"
+)
+
+func TestNormalizeNodeLabels(t *testing.T) {
+	cases := []struct {
+		in      interface{}
+		want    string
+		wantErr bool
+	}{
+		{in: "region=hk, tier=premium", want: `{"region":"hk","tier":"premium"}`},
+		{in: map[string]interface{}{"tier": "std", "region": " jp "}, want: `{"region":"jp","tier":"std"}`},
+		{in: `{"isp":"cn2"}`, want: `{"isp":"cn2"}`},
+		{in: "", want: ""},
+		{in: nil, want: ""},
+		{in: "region", wantErr: true},
+		{in: "bad key=x", wantErr: true},
+		{in: map[string]interface{}{"region": "a=b"}, wantErr: true},
+		{in: 42, wantErr: true},
+	}
+	for _, tc := range cases {
+		got, err := normalizeNodeLabels(tc.in)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("normalizeNodeLabels(%v): expected error, got %q", tc.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("normalizeNodeLabels(%v) = %q, %v; want %q", tc.in, got, err, tc.want)
+		}
+	}
+}
+
+func TestLabelSelectorMatches(t *testing.T) {
+	labels := map[string]string{"region": "hk", "tier": "premium"}
+	cases := map[string]bool{
+		"region=hk":              true,
+		"region==hk":             true,
+		"region=jp":              false,
+		"region=hk,tier=premium": true,
+		"region=hk,tier=std":     false,
+		"region!=jp":             true,
+		"tier!=premium":          false,
+		"isp!=cn2":               true,
+		"tier":                   true,
+		"isp":                    false,
+		"!isp":                   true,
+		"!region":                false,
+	}
+	for raw, want := range cases {
+		sel, err := parseLabelSelector(raw)
+		if err != nil {
+			t.Fatalf("parseLabelSelector(%q): %v", raw, err)
+		}
+		if got := sel.matches(labels); got != want {
+			t.Errorf("selector %q matched=%v, want %v", raw, got, want)
+		}
+	}
+	for _, raw := range []string{"", " , ", "bad key=x", "region=a!b"} {
+		if _, err := parseLabelSelector(raw); err == nil {
+			t.Errorf("parseLabelSelector(%q): expected error", raw)
+		}
+	}
+}
+
+func TestSelectNodesByLabelsSkipsUnavailableNodes(t *testing.T) {
+	r, err := repo.Open(filepath.Join(t.TempDir(), "labels.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = r.Close() })
+	h := New(r, "secret")
+	now := time.Now().UnixMilli()
+
+	nodes := []model.Node{
+		{Name: "hk-1", Secret: "s1", ServerIP: "10.0.0.1", Port: "1000-2000", Status: 1, Inx: 2, Labels: `{"region":"hk"}`, CreatedTime: now},
+		{Name: "hk-2", Secret: "s2", ServerIP: "10.0.0.2", Port: "1000-2000", Status: 1, Inx: 1, Labels: `{"region":"hk"}`, CreatedTime: now},
+		{Name: "hk-offline", Secret: "s3", ServerIP: "10.0.0.3", Port: "1000-2000", Status: 0, Labels: `{"region":"hk"}`, CreatedTime: now},
+		{Name: "hk-maint", Secret: "s4", ServerIP: "10.0.0.4", Port: "1000-2000", Status: 1, Maintenance: 1, Labels: `{"region":"hk"}`, CreatedTime: now},
+		{Name: "hk-full", Secret: "s5", ServerIP: "10.0.0.5", Port: "1000-2000", Status: 1, MaxForwards: 1, Labels: `{"region":"hk"}`, CreatedTime: now},
+		{Name: "jp-1", Secret: "s6", ServerIP: "10.0.0.6", Port: "1000-2000", Status: 1, Labels: `{"region":"jp"}`, CreatedTime: now},
+	}
+	for i := range nodes {
+		if err := r.DB().Create(&nodes[i]).Error; err != nil {
+			t.Fatalf("create node: %v", err)
+		}
+	}
+	full := nodes[4].ID
+	if err := r.DB().Create(&model.ForwardPort{ForwardID: 1, NodeID: full, Port: 1500}).Error; err != nil {
+		t.Fatalf("create forward port: %v", err)
+	}
+
+	ids, err := h.selectNodesByLabels("region=hk", nil, nil)
+	if err != nil {
+		t.Fatalf("select nodes: %v", err)
+	}
+	if len(ids) != 2 || ids[0] != nodes[1].ID || ids[1] != nodes[0].ID {
+		t.Fatalf("expected [hk-2 hk-1], got %v", ids)
+	}
+
+	// A full node stays selected for a tunnel it already belongs to.
+	ids, err = h.selectNodesByLabels("region=hk", map[int64]struct{}{nodes[1].ID: {}}, map[int64]struct{}{full: {}})
+	if err != nil {
+		t.Fatalf("select nodes with members: %v", err)
+	}
+	if len(ids) != 2 || ids[0] != full || ids[1] != nodes[0].ID {
+		t.Fatalf("expected [hk-full hk-1], got %v", ids)
+	}
+
+	if _, err := h.selectNodesByLabels("region=us", nil, nil); err == nil {
+		t.Fatalf("expected an error when no node matches")
+	}
+}
@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"go-backend/internal/http/response"
+)
+
+// Agents keep their recent logs in a ring buffer. A tail request returns the
+// matching tail of the buffer; with follow set the agent also pushes new
+// entries as LogEntries messages, which reach the admin WebSocket as "logs"
+// messages carrying the tailId returned here.
+
+var logLevels = map[string]struct{}{
+	"trace": {}, "debug": {}, "info": {}, "warn": {}, "error": {},
+}
+
+type nodeLogsRequest struct {
+	NodeID   int64  `json:"nodeId"`
+	TailID   string `json:"tailId"`
+	Service  string `json:"service"`
+	Level    string `json:"level"`
+	Lines    int    `json:"lines"`
+	Follow   bool   `json:"follow"`
+	Duration int    `json:"duration"`
+}
+
+func normalizeLogLevel(level string) (string, error) {
+	level = strings.ToLower(strings.TrimSpace(level))
+	if level == "warning" {
+		level = "warn"
+	}
+	if level == "" {
+		return "", nil
+	}
+	if _, ok := logLevels[level]; !ok {
+		return "", errors.New("无效的日志级别")
+	}
+	return level, nil
+}
+
+// decodeNodeLogsRequest parses the body and checks the node can be asked for logs.
+func (h *Handler) decodeNodeLogsRequest(w http.ResponseWriter, r *http.Request) (nodeLogsRequest, bool) {
+	var req nodeLogsRequest
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return req, false
+	}
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return req, false
+	}
+	if req.NodeID <= 0 {
+		response.WriteJSON(w, response.ErrDefault("节点ID不能为空"))
+		return req, false
+	}
+	level, err := normalizeLogLevel(req.Level)
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return req, false
+	}
+	req.Level = level
+	req.Service = strings.TrimSpace(req.Service)
+
+	node, err := h.getNodeRecord(req.NodeID)
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return req, false
+	}
+	if node.IsRemote == 1 {
+		response.WriteJSON(w, response.ErrDefault("远程节点暂不支持查看日志"))
+		return req, false
+	}
+	return req, true
+}
+
+// nodeLogs returns recent agent logs and optionally starts a live tail.
+func (h *Handler) nodeLogs(w http.ResponseWriter, r *http.Request) {
+	req, ok := h.decodeNodeLogsRequest(w, r)
+	if !ok {
+		return
+	}
+	res, err := h.sendNodeCommand(req.NodeID, "TailLogs", map[string]interface{}{
+		"service":  req.Service,
+		"level":    req.Level,
+		"lines":    req.Lines,
+		"follow":   req.Follow,
+		"duration": req.Duration,
+	}, false, false)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	data := res.Data
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	data["nodeId"] = req.NodeID
+	if _, ok := data["entries"]; !ok {
+		data["entries"] = []interface{}{}
+	}
+	response.WriteJSON(w, response.OK(data))
+}
+
+// nodeLogsStop ends a live tail, or every tail on the node without tailId.
+func (h *Handler) nodeLogsStop(w http.ResponseWriter, r *http.Request) {
+	req, ok := h.decodeNodeLogsRequest(w, r)
+	if !ok {
+		return
+	}
+	res, err := h.sendNodeCommand(req.NodeID, "StopLogTail", map[string]interface{}{
+		"tailId": strings.TrimSpace(req.TailID),
+	}, false, false)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(res.Data))
+}
+
+// nodeLogLevel temporarily raises the log level of one service on a node.
+// An empty level restores the default.
+func (h *Handler) nodeLogLevel(w http.ResponseWriter, r *http.Request) {
+	req, ok := h.decodeNodeLogsRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.Service == "" {
+		response.WriteJSON(w, response.ErrDefault("服务名称不能为空"))
+		return
+	}
+	res, err := h.sendNodeCommand(req.NodeID, "SetLogLevel", map[string]interface{}{
+		"service":  req.Service,
+		"level":    req.Level,
+		"duration": req.Duration,
+	}, false, false)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(res.Data))
+}
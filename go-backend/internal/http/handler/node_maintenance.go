@@ -0,0 +1,378 @@
+package handler
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"time"
+
+	"go-backend/internal/http/response"
+)
+
+// A node in maintenance receives no new forwards or tunnels. Entering
+// maintenance can migrate the forwards listening on it: each one drops its
+// port there and is placed on the tunnel's other entry nodes that are not in
+// maintenance, keeping its port where it is free. Migrated forwards are
+// recorded so leaving maintenance can move them back.
+
+const (
+	maintenanceForwardKept     = "kept"
+	maintenanceForwardMoved    = "moved"
+	maintenanceForwardStranded = "stranded"
+	maintenanceForwardRestored = "restored"
+	maintenanceForwardSkipped  = "skipped"
+	maintenanceForwardFailed   = "failed"
+)
+
+var errNoAlternateEntryNode = errors.New("隧道没有其他可用的入口节点")
+
+type maintenanceForwardResult struct {
+	ForwardID int64                    `json:"forwardId"`
+	Name      string                   `json:"name"`
+	UserID    int64                    `json:"userId"`
+	UserName  string                   `json:"userName"`
+	Action    string                   `json:"action"`
+	Error     string                   `json:"error,omitempty"`
+	Ports     []map[string]interface{} `json:"ports,omitempty"`
+}
+
+func newMaintenanceForwardResult(fr *forwardRecord, action string, ports []forwardPortRecord, err error) maintenanceForwardResult {
+	res := maintenanceForwardResult{
+		ForwardID: fr.ID,
+		Name:      fr.Name,
+		UserID:    fr.UserID,
+		UserName:  fr.UserName,
+		Action:    action,
+	}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	for _, p := range ports {
+		res.Ports = append(res.Ports, map[string]interface{}{"nodeId": p.NodeID, "port": p.Port})
+	}
+	return res
+}
+
+// maintenanceAffectedUsers groups the forwards of a report by owner.
+func maintenanceAffectedUsers(results []maintenanceForwardResult) []map[string]interface{} {
+	users := make([]map[string]interface{}, 0)
+	index := make(map[int64]int)
+	for _, res := range results {
+		i, ok := index[res.UserID]
+		if !ok {
+			i = len(users)
+			index[res.UserID] = i
+			users = append(users, map[string]interface{}{
+				"userId":     res.UserID,
+				"userName":   res.UserName,
+				"forwardIds": []int64{},
+			})
+		}
+		users[i]["forwardIds"] = append(users[i]["forwardIds"].([]int64), res.ForwardID)
+	}
+	return users
+}
+
+func (h *Handler) nodeMaintenance(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req map[string]interface{}
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	nodeID := asInt64(req["id"], 0)
+	if nodeID <= 0 {
+		response.WriteJSON(w, response.ErrDefault("节点ID不能为空"))
+		return
+	}
+	if _, err := h.getNodeRecord(nodeID); err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
+	enabled := asBool(req["enabled"], true)
+	now := time.Now().UnixMilli()
+
+	var results []maintenanceForwardResult
+	if enabled {
+		if err := h.repo.SetNodeMaintenance(nodeID, 1, now); err != nil {
+			response.WriteJSON(w, response.Err(-2, err.Error()))
+			return
+		}
+		results = h.drainNodeForwards(nodeID, asBool(req["migrate"], false), now)
+	} else {
+		if err := h.repo.SetNodeMaintenance(nodeID, 0, now); err != nil {
+			response.WriteJSON(w, response.Err(-2, err.Error()))
+			return
+		}
+		if asBool(req["restore"], false) {
+			results = h.restoreNodeForwards(nodeID)
+		}
+		_ = h.repo.DeleteNodeMaintenanceMoves(nodeID)
+	}
+	if results == nil {
+		results = []maintenanceForwardResult{}
+	}
+	response.WriteJSON(w, response.OK(map[string]interface{}{
+		"nodeId":      nodeID,
+		"maintenance": enabled,
+		"forwards":    results,
+		"users":       maintenanceAffectedUsers(results),
+	}))
+}
+
+// drainNodeForwards reports the forwards on a node entering maintenance and,
+// when migrate is set, moves them to the other entry nodes of their tunnel.
+func (h *Handler) drainNodeForwards(nodeID int64, migrate bool, now int64) []maintenanceForwardResult {
+	ids, err := h.repo.ListForwardIDsOnNode(nodeID)
+	if err != nil {
+		return nil
+	}
+	results := make([]maintenanceForwardResult, 0, len(ids))
+	for _, id := range ids {
+		fr, err := h.getForwardRecord(id)
+		if err != nil {
+			continue
+		}
+		if !migrate {
+			results = append(results, newMaintenanceForwardResult(fr, maintenanceForwardKept, nil, nil))
+			continue
+		}
+		ports, origPort, err := h.moveForwardOffNode(fr, nodeID)
+		switch {
+		case errors.Is(err, errNoAlternateEntryNode):
+			results = append(results, newMaintenanceForwardResult(fr, maintenanceForwardStranded, nil, err))
+		case err != nil:
+			results = append(results, newMaintenanceForwardResult(fr, maintenanceForwardFailed, nil, err))
+		default:
+			_ = h.repo.CreateNodeMaintenanceMove(nodeID, fr.ID, origPort, now)
+			results = append(results, newMaintenanceForwardResult(fr, maintenanceForwardMoved, ports, nil))
+		}
+	}
+	return results
+}
+
+// moveForwardOffNode removes a forward from nodeID, making sure it listens on
+// every other available entry node of its tunnel. It returns the new ports
+// and the port the forward had on nodeID.
+func (h *Handler) moveForwardOffNode(fr *forwardRecord, nodeID int64) ([]forwardPortRecord, int, error) {
+	oldPorts, err := h.listForwardPorts(fr.ID)
+	if err != nil {
+		return nil, 0, err
+	}
+	origPort := 0
+	ports := make([]forwardPortRecord, 0, len(oldPorts))
+	have := make(map[int64]struct{}, len(oldPorts))
+	for _, p := range oldPorts {
+		if p.NodeID == nodeID {
+			origPort = p.Port
+			continue
+		}
+		ports = append(ports, p)
+		have[p.NodeID] = struct{}{}
+	}
+	entryNodes, err := h.activeTunnelEntryNodeIDs(fr.TunnelID)
+	if err != nil {
+		return nil, 0, err
+	}
+	added := make([]forwardPortRecord, 0)
+	for _, candidate := range entryNodes {
+		if _, ok := have[candidate]; ok || candidate == nodeID {
+			continue
+		}
+		port, err := h.placeForwardOnNode(fr, candidate, origPort)
+		if err != nil {
+			continue
+		}
+		added = append(added, forwardPortRecord{NodeID: candidate, Port: port})
+	}
+	ports = append(ports, added...)
+	if len(ports) == 0 {
+		return nil, origPort, errNoAlternateEntryNode
+	}
+
+	if err := h.replaceForwardPortsWithRecords(fr.ID, ports); err != nil {
+		return nil, origPort, err
+	}
+	if err := h.syncForwardServices(fr, "UpdateService", true); err != nil {
+		_ = h.replaceForwardPortsWithRecords(fr.ID, oldPorts)
+		_ = h.controlForwardServicesOnPorts(fr, added, "DeleteService", true)
+		return nil, origPort, err
+	}
+	if fr.Status != 1 {
+		_ = h.controlForwardServicesOnPorts(fr, added, "PauseService", true)
+	}
+	// The node is usually about to go down; a failed cleanup is harmless.
+	_ = h.controlForwardServicesOnPorts(fr, []forwardPortRecord{{NodeID: nodeID, Port: origPort}}, "DeleteService", true)
+	return ports, origPort, nil
+}
+
+// restoreNodeForwards puts the forwards moved off a node back on it.
+func (h *Handler) restoreNodeForwards(nodeID int64) []maintenanceForwardResult {
+	moves, err := h.repo.ListNodeMaintenanceMoves(nodeID)
+	if err != nil {
+		return nil
+	}
+	results := make([]maintenanceForwardResult, 0, len(moves))
+	for _, mv := range moves {
+		fr, err := h.getForwardRecord(mv.ForwardID)
+		if err != nil {
+			continue
+		}
+		ports, err := h.moveForwardOntoNode(fr, nodeID, mv.Port)
+		switch {
+		case errors.Is(err, errNoAlternateEntryNode):
+			results = append(results, newMaintenanceForwardResult(fr, maintenanceForwardSkipped, nil, errors.New("节点已不是该转发隧道的入口")))
+		case err != nil:
+			results = append(results, newMaintenanceForwardResult(fr, maintenanceForwardFailed, nil, err))
+		default:
+			results = append(results, newMaintenanceForwardResult(fr, maintenanceForwardRestored, ports, nil))
+		}
+	}
+	return results
+}
+
+func (h *Handler) moveForwardOntoNode(fr *forwardRecord, nodeID int64, prefer int) ([]forwardPortRecord, error) {
+	entryNodes, err := h.tunnelEntryNodeIDs(fr.TunnelID)
+	if err != nil {
+		return nil, err
+	}
+	isEntry := false
+	for _, id := range entryNodes {
+		isEntry = isEntry || id == nodeID
+	}
+	if !isEntry {
+		return nil, errNoAlternateEntryNode
+	}
+	oldPorts, err := h.listForwardPorts(fr.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range oldPorts {
+		if p.NodeID == nodeID {
+			return oldPorts, nil
+		}
+	}
+	port, err := h.placeForwardOnNode(fr, nodeID, prefer)
+	if err != nil {
+		return nil, err
+	}
+	added := []forwardPortRecord{{NodeID: nodeID, Port: port}}
+	ports := append(append([]forwardPortRecord{}, oldPorts...), added...)
+	if err := h.replaceForwardPortsWithRecords(fr.ID, ports); err != nil {
+		return nil, err
+	}
+	if err := h.syncForwardServices(fr, "UpdateService", true); err != nil {
+		_ = h.replaceForwardPortsWithRecords(fr.ID, oldPorts)
+		_ = h.controlForwardServicesOnPorts(fr, added, "DeleteService", true)
+		return nil, err
+	}
+	if fr.Status != 1 {
+		_ = h.controlForwardServicesOnPorts(fr, added, "PauseService", true)
+	}
+	return ports, nil
+}
+
+// placeForwardOnNode picks the port a forward listens on when added to a
+// node: prefer if it is free there, otherwise any free port of the node.
+// Domain forwards always share prefer.
+func (h *Handler) placeForwardOnNode(fr *forwardRecord, nodeID int64, prefer int) (int, error) {
+	node, err := h.getNodeRecord(nodeID)
+	if err != nil {
+		return 0, err
+	}
+	if err := h.nodeForwardCapacityError(node); err != nil {
+		return 0, err
+	}
+	check := func(port int) error {
+		if port <= 0 {
+			return errors.New("没有可用端口")
+		}
+		if err := validateRemoteNodePort(node, port); err != nil {
+			return err
+		}
+		if fr.Domain == "" {
+			if err := h.checkNodePortFree(node, port); err != nil {
+				return err
+			}
+		}
+		return h.validateForwardListenPlacement(fr.Domain, port, []int64{nodeID}, fr.ID)
+	}
+	err = check(prefer)
+	if err == nil || fr.Domain != "" {
+		return prefer, err
+	}
+	port := h.pickNodePort(nodeID)
+	if err := check(port); err != nil {
+		return 0, err
+	}
+	return port, nil
+}
+
+// checkNodePortFree fails unless port is inside the node's port range and not
+// used by another forward or chain hop there.
+func (h *Handler) checkNodePortFree(node *nodeRecord, port int) error {
+	portRange, err := h.repo.GetNodePortRange(node.ID)
+	if err != nil {
+		return err
+	}
+	if portRange == "" {
+		portRange = "1000-65535"
+	}
+	nodePorts, err := parsePorts(portRange)
+	if err != nil {
+		return err
+	}
+	inRange := false
+	for _, p := range nodePorts {
+		if p == port {
+			inRange = true
+			break
+		}
+	}
+	if !inRange {
+		return fmt.Errorf("端口 %d 不在节点 %s 的端口范围内", port, node.Name)
+	}
+	used, err := h.getUsedPorts(node.ID)
+	if err != nil {
+		return err
+	}
+	if used[port] {
+		return fmt.Errorf("节点 %s 端口 %d 已被占用", node.Name, port)
+	}
+	return nil
+}
+
+// pickNodePort returns a random free port in a node's port range, or 0.
+func (h *Handler) pickNodePort(nodeID int64) int {
+	portRange, err := h.repo.GetNodePortRange(nodeID)
+	if err != nil {
+		return 0
+	}
+	if portRange == "" {
+		portRange = "1000-65535"
+	}
+	nodePorts, err := parsePorts(portRange)
+	if err != nil {
+		return 0
+	}
+	used, err := h.getUsedPorts(nodeID)
+	if err != nil {
+		return 0
+	}
+	available := make([]int, 0, len(nodePorts))
+	for _, p := range nodePorts {
+		if !used[p] {
+			available = append(available, p)
+		}
+	}
+	if len(available) == 0 {
+		return 0
+	}
+	idx, _ := rand.Int(rand.Reader, big.NewInt(int64(len(available))))
+	return available[idx.Int64()]
+}
@@ -0,0 +1,263 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/store/model"
+)
+
+// Node system info reports are folded into per-minute buckets in memory and
+// written once a minute. Every hour the previous hour of minute buckets is
+// rolled up into an hourly bucket; minute buckets are kept for two days and
+// hourly ones for 90 days.
+const (
+	nodeMetricMinute = 60
+	nodeMetricHour   = 3600
+
+	nodeMetricMinuteRetention = 48 * time.Hour
+	nodeMetricHourRetention   = 90 * 24 * time.Hour
+)
+
+type nodeInfoReport struct {
+	Uptime           uint64   `json:"uptime"`
+	BytesReceived    uint64   `json:"bytes_received"`
+	BytesTransmitted uint64   `json:"bytes_transmitted"`
+	CPUUsage         *float64 `json:"cpu_usage"`
+	MemoryUsage      float64  `json:"memory_usage"`
+	Connections      int64    `json:"connections"`
+}
+
+// nodeMetricSnapshot is the most recent sample of a node.
+type nodeMetricSnapshot struct {
+	At     time.Time
+	CPU    float64
+	Memory float64
+	RxRate int64
+	TxRate int64
+}
+
+// nodeMetricState holds the last counters of a node, to derive rates, and
+// the bucket being filled.
+type nodeMetricState struct {
+	lastAt time.Time
+	lastRx uint64
+	lastTx uint64
+	latest nodeMetricSnapshot
+
+	samples     int
+	rateSamples int
+	cpuSum      float64
+	cpuMax      float64
+	memSum      float64
+	memMax      float64
+	rxSum       int64
+	rxMax       int64
+	txSum       int64
+	txMax       int64
+	connSum     int64
+	connMax     int64
+	uptime      int64
+}
+
+func (st *nodeMetricState) add(report nodeInfoReport, now time.Time) {
+	cpu := *report.CPUUsage
+	st.samples++
+	st.cpuSum += cpu
+	st.cpuMax = max(st.cpuMax, cpu)
+	st.memSum += report.MemoryUsage
+	st.memMax = max(st.memMax, report.MemoryUsage)
+	st.connSum += report.Connections
+	st.connMax = max(st.connMax, report.Connections)
+	st.uptime = int64(report.Uptime)
+	st.latest.At, st.latest.CPU, st.latest.Memory = now, cpu, report.MemoryUsage
+
+	// Counters restart with the host; skip the first sample after that.
+	if !st.lastAt.IsZero() && now.After(st.lastAt) && report.BytesReceived >= st.lastRx && report.BytesTransmitted >= st.lastTx {
+		seconds := now.Sub(st.lastAt).Seconds()
+		rx := int64(float64(report.BytesReceived-st.lastRx) / seconds)
+		tx := int64(float64(report.BytesTransmitted-st.lastTx) / seconds)
+		st.rateSamples++
+		st.rxSum += rx
+		st.rxMax = max(st.rxMax, rx)
+		st.txSum += tx
+		st.txMax = max(st.txMax, tx)
+		st.latest.RxRate, st.latest.TxRate = rx, tx
+	}
+	st.lastAt, st.lastRx, st.lastTx = now, report.BytesReceived, report.BytesTransmitted
+}
+
+// take returns the filled bucket and starts a new one, keeping the counters.
+func (st *nodeMetricState) take(nodeID int64, bucket int64) (model.NodeMetric, bool) {
+	if st.samples == 0 {
+		return model.NodeMetric{}, false
+	}
+	m := model.NodeMetric{
+		NodeID:         nodeID,
+		Resolution:     nodeMetricMinute,
+		BucketTime:     bucket,
+		Samples:        st.samples,
+		CPU:            st.cpuSum / float64(st.samples),
+		CPUMax:         st.cpuMax,
+		Memory:         st.memSum / float64(st.samples),
+		MemoryMax:      st.memMax,
+		Connections:    st.connSum / int64(st.samples),
+		ConnectionsMax: st.connMax,
+		Uptime:         st.uptime,
+	}
+	if st.rateSamples > 0 {
+		m.RxRate = st.rxSum / int64(st.rateSamples)
+		m.RxRateMax = st.rxMax
+		m.TxRate = st.txSum / int64(st.rateSamples)
+		m.TxRateMax = st.txMax
+	}
+	*st = nodeMetricState{lastAt: st.lastAt, lastRx: st.lastRx, lastTx: st.lastTx, latest: st.latest}
+	return m, true
+}
+
+// onNodeInfo is the websocket hook for node system info reports.
+func (h *Handler) onNodeInfo(nodeID int64, info string) {
+	h.recordNodeInfo(nodeID, info, time.Now())
+}
+
+func (h *Handler) recordNodeInfo(nodeID int64, info string, now time.Time) {
+	var report nodeInfoReport
+	if err := json.Unmarshal([]byte(info), &report); err != nil || report.CPUUsage == nil {
+		return
+	}
+	h.metricsMu.Lock()
+	defer h.metricsMu.Unlock()
+	if h.nodeMetrics == nil {
+		h.nodeMetrics = make(map[int64]*nodeMetricState)
+	}
+	st := h.nodeMetrics[nodeID]
+	if st == nil {
+		st = &nodeMetricState{}
+		h.nodeMetrics[nodeID] = st
+	}
+	st.add(report, now)
+}
+
+// latestNodeMetrics returns a node's most recent sample if it is at most
+// maxAge old.
+func (h *Handler) latestNodeMetrics(nodeID int64, now time.Time, maxAge time.Duration) (nodeMetricSnapshot, bool) {
+	h.metricsMu.Lock()
+	defer h.metricsMu.Unlock()
+	st := h.nodeMetrics[nodeID]
+	if st == nil || st.latest.At.IsZero() || now.Sub(st.latest.At) > maxAge {
+		return nodeMetricSnapshot{}, false
+	}
+	return st.latest, true
+}
+
+// runNodeMetricsFlushJob writes the samples gathered so far as the bucket of
+// the minute before now.
+func (h *Handler) runNodeMetricsFlushJob(now time.Time) {
+	if h == nil || h.repo == nil {
+		return
+	}
+	bucket := now.Truncate(time.Minute).Add(-time.Minute).UnixMilli()
+	h.metricsMu.Lock()
+	metrics := make([]model.NodeMetric, 0, len(h.nodeMetrics))
+	for nodeID, st := range h.nodeMetrics {
+		if m, ok := st.take(nodeID, bucket); ok {
+			metrics = append(metrics, m)
+		}
+		// Forget nodes that stopped reporting so deleted nodes do not linger.
+		if now.Sub(st.lastAt) > 10*time.Minute {
+			delete(h.nodeMetrics, nodeID)
+		}
+	}
+	h.metricsMu.Unlock()
+	_ = h.repo.UpsertNodeMetrics(metrics)
+}
+
+// runNodeMetricsRollupJob rolls up every completed hour that has no hourly
+// bucket yet, so hours missed while the panel was down are caught up, and
+// purges old buckets.
+func (h *Handler) runNodeMetricsRollupJob(now time.Time) {
+	if h == nil || h.repo == nil {
+		return
+	}
+	starts, _ := h.repo.ListNodeMetricRollupsDue(nodeMetricMinute, nodeMetricHour, now.Truncate(time.Hour).UnixMilli())
+	for _, start := range starts {
+		_ = h.repo.RollupNodeMetrics(nodeMetricMinute, nodeMetricHour, start, start+int64(time.Hour/time.Millisecond))
+	}
+	_ = h.repo.PurgeNodeMetrics(nodeMetricMinute, now.Add(-nodeMetricMinuteRetention).UnixMilli())
+	_ = h.repo.PurgeNodeMetrics(nodeMetricHour, now.Add(-nodeMetricHourRetention).UnixMilli())
+}
+
+// nodeMetricsList returns a node's resource history. Ranges that minute buckets
+// still cover use them; anything older uses hourly buckets.
+func (h *Handler) nodeMetricsList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req map[string]interface{}
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	nodeID := asInt64(req["nodeId"], 0)
+	if nodeID <= 0 {
+		response.WriteJSON(w, response.ErrDefault("节点ID不能为空"))
+		return
+	}
+	now := time.Now()
+	end := asInt64(req["end"], 0)
+	if end <= 0 || end > now.UnixMilli() {
+		end = now.UnixMilli()
+	}
+	start := asInt64(req["start"], 0)
+	if start <= 0 {
+		start = end - int64(24*time.Hour/time.Millisecond)
+	}
+	if start >= end {
+		response.WriteJSON(w, response.ErrDefault("开始时间必须早于结束时间"))
+		return
+	}
+	if end-start > int64(nodeMetricHourRetention/time.Millisecond) {
+		response.WriteJSON(w, response.ErrDefault("查询范围不能超过 90 天"))
+		return
+	}
+
+	resolution := int(asInt64(req["resolution"], 0))
+	if resolution != nodeMetricMinute && resolution != nodeMetricHour {
+		resolution = nodeMetricHour
+		if start >= now.Add(-nodeMetricMinuteRetention).UnixMilli() {
+			resolution = nodeMetricMinute
+		}
+	}
+	metrics, err := h.repo.ListNodeMetrics(nodeID, resolution, start, end)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	points := make([]map[string]interface{}, 0, len(metrics))
+	for _, m := range metrics {
+		points = append(points, map[string]interface{}{
+			"time":           m.BucketTime,
+			"cpu":            m.CPU,
+			"cpuMax":         m.CPUMax,
+			"memory":         m.Memory,
+			"memoryMax":      m.MemoryMax,
+			"rxRate":         m.RxRate,
+			"rxRateMax":      m.RxRateMax,
+			"txRate":         m.TxRate,
+			"txRateMax":      m.TxRateMax,
+			"connections":    m.Connections,
+			"connectionsMax": m.ConnectionsMax,
+			"uptime":         m.Uptime,
+		})
+	}
+	response.WriteJSON(w, response.OK(map[string]interface{}{
+		"nodeId":     nodeID,
+		"resolution": resolution,
+		"start":      start,
+		"end":        end,
+		"points":     points,
+	}))
+}
@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-backend/internal/store/model"
+	"go-backend/internal/store/repo"
+)
+
+func TestNodeMetricsFlushRollupAndList(t *testing.T) {
+	r, err := repo.Open(filepath.Join(t.TempDir(), "node-metrics.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = r.Close() })
+	h := New(r, "secret")
+
+	base := time.Now().Truncate(time.Hour).Add(-time.Hour)
+	report := func(at time.Duration, cpu float64, rx uint64, conns int) {
+		info, _ := json.Marshal(map[string]interface{}{
+			"uptime": 100, "bytes_received": rx, "bytes_transmitted": rx / 2,
+			"cpu_usage": cpu, "memory_usage": 40.0, "connections": conns,
+		})
+		h.recordNodeInfo(7, string(info), base.Add(at))
+	}
+	report(10*time.Second, 10, 1000, 4)
+	report(20*time.Second, 30, 11000, 6) // 1000 B/s
+	h.recordNodeInfo(7, `{"type":"PauseService","success":true}`, base.Add(25*time.Second))
+	h.runNodeMetricsFlushJob(base.Add(time.Minute))
+	report(70*time.Second, 50, 36000, 8) // 500 B/s
+	h.runNodeMetricsFlushJob(base.Add(2 * time.Minute))
+	h.runNodeMetricsFlushJob(base.Add(3 * time.Minute)) // nothing reported
+
+	minutes, err := r.ListNodeMetrics(7, nodeMetricMinute, base.UnixMilli(), base.Add(time.Hour).UnixMilli())
+	if err != nil {
+		t.Fatalf("list minutes: %v", err)
+	}
+	if len(minutes) != 2 {
+		t.Fatalf("expected 2 minute buckets, got %d", len(minutes))
+	}
+	first := minutes[0]
+	if first.BucketTime != base.UnixMilli() || first.Samples != 2 || first.CPU != 20 || first.CPUMax != 30 ||
+		first.RxRate != 1000 || first.TxRate != 500 || first.Connections != 5 || first.ConnectionsMax != 6 {
+		t.Fatalf("unexpected first bucket: %+v", first)
+	}
+	if minutes[1].RxRate != 500 || minutes[1].CPU != 50 {
+		t.Fatalf("unexpected second bucket: %+v", minutes[1])
+	}
+
+	h.runNodeMetricsRollupJob(base.Add(time.Hour))
+	hours, err := r.ListNodeMetrics(7, nodeMetricHour, base.UnixMilli(), base.Add(time.Hour).UnixMilli())
+	if err != nil || len(hours) != 1 {
+		t.Fatalf("expected one hourly bucket, got %d (%v)", len(hours), err)
+	}
+	if hours[0].Samples != 3 || hours[0].CPU != 30 || hours[0].CPUMax != 50 || hours[0].RxRate != 833 || hours[0].RxRateMax != 1000 {
+		t.Fatalf("unexpected hourly bucket: %+v", hours[0])
+	}
+
+	list := func(body map[string]interface{}) map[string]interface{} {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/node/metrics", bytes.NewReader(raw))
+		res := httptest.NewRecorder()
+		h.nodeMetricsList(res, req)
+		var payload struct {
+			Code int                    `json:"code"`
+			Data map[string]interface{} `json:"data"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&payload); err != nil || payload.Code != 0 {
+			t.Fatalf("list metrics: code %d (%v)", payload.Code, err)
+		}
+		return payload.Data
+	}
+	data := list(map[string]interface{}{"nodeId": 7, "start": base.UnixMilli()})
+	if data["resolution"].(float64) != nodeMetricMinute || len(data["points"].([]interface{})) != 2 {
+		t.Fatalf("expected minute points, got %v", data)
+	}
+	data = list(map[string]interface{}{"nodeId": 7, "start": base.UnixMilli(), "resolution": nodeMetricHour})
+	if len(data["points"].([]interface{})) != 1 {
+		t.Fatalf("expected one hourly point, got %v", data)
+	}
+}
+
+func TestNodeMetricsRollupCatchesUpMissedHours(t *testing.T) {
+	r, err := repo.Open(filepath.Join(t.TempDir(), "node-metrics-catchup.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = r.Close() })
+	h := New(r, "secret")
+
+	// Three hours of samples while no rollup ran; the last hour is still open.
+	current := time.Now().Truncate(time.Hour)
+	var metrics []model.NodeMetric
+	for _, hour := range []time.Time{current.Add(-3 * time.Hour), current.Add(-2 * time.Hour), current} {
+		metrics = append(metrics, model.NodeMetric{
+			NodeID: 7, Resolution: nodeMetricMinute, BucketTime: hour.Add(5 * time.Minute).UnixMilli(), Samples: 2, CPU: 10, CPUMax: 20,
+		})
+	}
+	if err := r.UpsertNodeMetrics(metrics); err != nil {
+		t.Fatalf("upsert minutes: %v", err)
+	}
+
+	h.runNodeMetricsRollupJob(current.Add(17 * time.Minute))
+	hours, err := r.ListNodeMetrics(7, nodeMetricHour, current.Add(-4*time.Hour).UnixMilli(), current.Add(time.Hour).UnixMilli())
+	if err != nil {
+		t.Fatalf("list hours: %v", err)
+	}
+	if len(hours) != 2 || hours[0].BucketTime != current.Add(-3*time.Hour).UnixMilli() || hours[1].BucketTime != current.Add(-2*time.Hour).UnixMilli() {
+		t.Fatalf("expected the two completed hours to be rolled up, got %+v", hours)
+	}
+	if hours[0].Samples != 2 || hours[0].CPUMax != 20 {
+		t.Fatalf("unexpected hourly bucket: %+v", hours[0])
+	}
+}
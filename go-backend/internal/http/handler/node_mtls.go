@@ -0,0 +1,452 @@
+package handler
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/store/model"
+)
+
+// Optional mutual TLS for node connections. When the panel runs a node TLS
+// listener, it keeps an internal CA and signs a client certificate for each
+// agent that sends a CSR at enrollment (or later over /flow/certificate).
+// Agents pin the public key of the listener's server certificate. A node
+// holding an active certificate is refused on the plain listener; nodes
+// without one keep connecting with their secret only.
+
+const (
+	nodeCAValidity          = 20 * 365 * 24 * time.Hour
+	nodeCertificateValidity = 365 * 24 * time.Hour
+	nodeCertificatePrefix   = "node-"
+)
+
+// nodeCAState is the decoded internal CA and listener certificate.
+type nodeCAState struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM string
+	server  tls.Certificate
+	pin     string
+}
+
+// EnableNodeTLS turns on mutual TLS for nodes. publicAddr is the address
+// agents dial, host:port or :port to keep the panel host they already use.
+func (h *Handler) EnableNodeTLS(publicAddr string) {
+	h.nodeTLSMu.Lock()
+	h.nodeTLSAddr = strings.TrimSpace(publicAddr)
+	h.nodeTLSMu.Unlock()
+}
+
+func (h *Handler) nodeTLSEnabled() (string, bool) {
+	h.nodeTLSMu.Lock()
+	defer h.nodeTLSMu.Unlock()
+	return h.nodeTLSAddr, h.nodeTLSAddr != ""
+}
+
+// NodeTLSConfig returns the server configuration of the node TLS listener,
+// creating the internal CA on first use.
+func (h *Handler) NodeTLSConfig() (*tls.Config, error) {
+	ca, err := h.loadNodeCA()
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{ca.server},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}, nil
+}
+
+// loadNodeCA returns the internal CA, generating and storing it once.
+func (h *Handler) loadNodeCA() (*nodeCAState, error) {
+	h.nodeTLSMu.Lock()
+	defer h.nodeTLSMu.Unlock()
+	if h.nodeCA != nil {
+		return h.nodeCA, nil
+	}
+	crypto, err := h.certificateCrypto()
+	if err != nil {
+		return nil, err
+	}
+	record, err := h.repo.GetNodeCA()
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		record, err = generateNodeCA(time.Now())
+		if err != nil {
+			return nil, err
+		}
+		plainKey, plainServerKey := record.KeyData, record.ServerKey
+		if record.KeyData, err = crypto.Encrypt([]byte(plainKey)); err != nil {
+			return nil, err
+		}
+		if record.ServerKey, err = crypto.Encrypt([]byte(plainServerKey)); err != nil {
+			return nil, err
+		}
+		if err := h.repo.CreateNodeCA(record); err != nil {
+			return nil, err
+		}
+		record.KeyData, record.ServerKey = plainKey, plainServerKey
+	} else {
+		key, err := crypto.Decrypt(record.KeyData)
+		if err != nil {
+			return nil, fmt.Errorf("节点 CA 密钥解密失败: %v", err)
+		}
+		serverKey, err := crypto.Decrypt(record.ServerKey)
+		if err != nil {
+			return nil, fmt.Errorf("节点 CA 密钥解密失败: %v", err)
+		}
+		record.KeyData, record.ServerKey = string(key), string(serverKey)
+	}
+	state, err := decodeNodeCA(record)
+	if err != nil {
+		return nil, err
+	}
+	h.nodeCA = state
+	return state, nil
+}
+
+// generateNodeCA creates a CA and a listener certificate signed by it. The
+// keys are returned in plain PEM.
+func generateNodeCA(now time.Time) (*model.NodeCA, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          randomSerial(),
+		Subject:               pkix.Name{CommonName: "flux-panel node CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(nodeCAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return nil, err
+	}
+
+	serverKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serverDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject:      pkix.Name{CommonName: "flux-panel"},
+		DNSNames:     []string{"flux-panel"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(nodeCAValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, caCert, &serverKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	caKeyPEM, err := marshalECKeyPEM(caKey)
+	if err != nil {
+		return nil, err
+	}
+	serverKeyPEM, err := marshalECKeyPEM(serverKey)
+	if err != nil {
+		return nil, err
+	}
+	return &model.NodeCA{
+		CertData:    string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})),
+		KeyData:     caKeyPEM,
+		ServerCert:  string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: serverDER})),
+		ServerKey:   serverKeyPEM,
+		CreatedTime: now.UnixMilli(),
+	}, nil
+}
+
+func decodeNodeCA(record *model.NodeCA) (*nodeCAState, error) {
+	pair, err := tls.X509KeyPair([]byte(record.CertData), []byte(record.KeyData))
+	if err != nil {
+		return nil, fmt.Errorf("节点 CA 无效: %v", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("节点 CA 密钥类型不支持")
+	}
+	server, err := tls.X509KeyPair([]byte(record.ServerCert), []byte(record.ServerKey))
+	if err != nil {
+		return nil, fmt.Errorf("节点 TLS 证书无效: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(server.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	return &nodeCAState{
+		cert:    cert,
+		key:     key,
+		certPEM: record.CertData,
+		server:  server,
+		pin:     publicKeyPin(leaf),
+	}, nil
+}
+
+func marshalECKeyPEM(key *ecdsa.PrivateKey) (string, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})), nil
+}
+
+func randomSerial() *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		return big.NewInt(time.Now().UnixNano())
+	}
+	return serial
+}
+
+// publicKeyPin is the pin agents check: the base64 SHA-256 of the
+// certificate's SubjectPublicKeyInfo.
+func publicKeyPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "sha256/" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func certificateSerial(cert *x509.Certificate) string {
+	return hex.EncodeToString(cert.SerialNumber.Bytes())
+}
+
+// nodeIDFromCertificate reads the node id from a client certificate subject.
+func nodeIDFromCertificate(cert *x509.Certificate) (int64, bool) {
+	raw, ok := strings.CutPrefix(cert.Subject.CommonName, nodeCertificatePrefix)
+	if !ok {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(raw, 10, 64)
+	return id, err == nil && id > 0
+}
+
+// signNodeCertificate issues a client certificate for a node's CSR.
+func signNodeCertificate(ca *nodeCAState, nodeID int64, csrPEM string, now time.Time) (*x509.Certificate, string, error) {
+	block, _ := pem.Decode([]byte(strings.TrimSpace(csrPEM)))
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, "", errors.New("证书请求格式错误")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, "", fmt.Errorf("证书请求格式错误: %v", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, "", fmt.Errorf("证书请求签名无效: %v", err)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject:      pkix.Name{CommonName: nodeCertificatePrefix + strconv.FormatInt(nodeID, 10)},
+		NotBefore:    now.Add(-5 * time.Minute),
+		NotAfter:     now.Add(nodeCertificateValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca.cert, csr.PublicKey, ca.key)
+	if err != nil {
+		return nil, "", err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, "", err
+	}
+	return cert, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})), nil
+}
+
+// issueNodeCertificate signs and records a client certificate and returns
+// what the agent needs to switch to mutual TLS.
+func (h *Handler) issueNodeCertificate(nodeID int64, csrPEM string) (map[string]interface{}, error) {
+	tlsAddr, ok := h.nodeTLSEnabled()
+	if !ok {
+		return nil, errors.New("面板未启用节点 mTLS")
+	}
+	ca, err := h.loadNodeCA()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	cert, certPEM, err := signNodeCertificate(ca, nodeID, csrPEM, now)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint := sha256.Sum256(cert.Raw)
+	if err := h.repo.CreateNodeCertificate(&model.NodeCertificate{
+		NodeID:      nodeID,
+		Serial:      certificateSerial(cert),
+		Fingerprint: hex.EncodeToString(fingerprint[:]),
+		NotAfter:    cert.NotAfter.UnixMilli(),
+		CreatedTime: now.UnixMilli(),
+	}); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"certificate":   certPEM,
+		"caCertificate": ca.certPEM,
+		"panelPin":      ca.pin,
+		"tlsAddr":       tlsAddr,
+		"notAfter":      cert.NotAfter.UnixMilli(),
+	}, nil
+}
+
+// authorizeNodeTransport checks that a node request arrives over the
+// channel the node is entitled to: with its own valid client certificate,
+// or on the plain listener only while it holds no active certificate.
+func (h *Handler) authorizeNodeTransport(r *http.Request, nodeID int64) bool {
+	if h == nil || h.repo == nil {
+		return true
+	}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		leaf := r.TLS.PeerCertificates[0]
+		certNodeID, ok := nodeIDFromCertificate(leaf)
+		if !ok || certNodeID != nodeID {
+			return false
+		}
+		record, err := h.repo.GetNodeCertificateBySerial(certificateSerial(leaf))
+		return err == nil && record != nil && record.NodeID == nodeID && record.Revoked == 0
+	}
+	if _, ok := h.nodeTLSEnabled(); !ok {
+		return true
+	}
+	active, err := h.repo.NodeHasActiveCertificate(nodeID, time.Now().UnixMilli())
+	return err == nil && !active
+}
+
+// flowCertificate issues a node certificate to an agent. The first one is
+// requested with the node secret on the plain listener; once the node holds
+// a certificate, renewals must come over mutual TLS.
+func (h *Handler) flowCertificate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	node, err := h.repo.GetNodeBySecret(r.URL.Query().Get("secret"))
+	if err != nil || node == nil {
+		response.WriteJSON(w, response.ErrDefault("节点不存在"))
+		return
+	}
+	if !h.authorizeNodeTransport(r, node.ID) {
+		response.WriteJSON(w, response.ErrDefault("节点已启用 mTLS，请通过 mTLS 通道续期证书"))
+		return
+	}
+	var req map[string]interface{}
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	out, err := h.issueNodeCertificate(node.ID, asString(req["csr"]))
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(out))
+}
+
+// nodeCertificateList lists the certificates issued to a node.
+func (h *Handler) nodeCertificateList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	id := idFromBody(r, w)
+	if id <= 0 {
+		return
+	}
+	certs, err := h.repo.ListNodeCertificates(id)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	now := time.Now().UnixMilli()
+	items := make([]map[string]interface{}, 0, len(certs))
+	for _, c := range certs {
+		items = append(items, map[string]interface{}{
+			"id":          c.ID,
+			"serial":      c.Serial,
+			"fingerprint": c.Fingerprint,
+			"notAfter":    c.NotAfter,
+			"revoked":     c.Revoked == 1,
+			"expired":     c.NotAfter <= now,
+			"createdTime": c.CreatedTime,
+		})
+	}
+	response.WriteJSON(w, response.OK(items))
+}
+
+// nodeCertificateRevoke revokes a node's certificates and closes its live
+// session. The agent's reconnect over mutual TLS is refused, so it drops back
+// to secret-only connections on the plain listener.
+func (h *Handler) nodeCertificateRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	id := idFromBody(r, w)
+	if id <= 0 {
+		return
+	}
+	n, err := h.repo.RevokeNodeCertificates(id)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if n > 0 {
+		h.wsServer.DisconnectNode(id)
+	}
+	response.WriteJSON(w, response.OK(map[string]interface{}{"revoked": n}))
+}
+
+// nodeCAInfo returns the CA certificate and listener pin for agents
+// configured by hand.
+func (h *Handler) nodeCAInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	tlsAddr, ok := h.nodeTLSEnabled()
+	if !ok {
+		response.WriteJSON(w, response.OK(map[string]interface{}{"enabled": false}))
+		return
+	}
+	ca, err := h.loadNodeCA()
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(map[string]interface{}{
+		"enabled":       true,
+		"tlsAddr":       tlsAddr,
+		"panelPin":      ca.pin,
+		"caCertificate": ca.certPEM,
+	}))
+}
@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+)
+
+func TestSignNodeCertificate(t *testing.T) {
+	now := time.Now()
+	record, err := generateNodeCA(now)
+	if err != nil {
+		t.Fatalf("generate ca: %v", err)
+	}
+	ca, err := decodeNodeCA(record)
+	if err != nil {
+		t.Fatalf("decode ca: %v", err)
+	}
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{}, key)
+	if err != nil {
+		t.Fatalf("create csr: %v", err)
+	}
+	csrPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER}))
+
+	cert, _, err := signNodeCertificate(ca, 42, csrPEM, now)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: pool, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err != nil {
+		t.Fatalf("expected a client certificate chaining to the CA: %v", err)
+	}
+	if id, ok := nodeIDFromCertificate(cert); !ok || id != 42 {
+		t.Fatalf("expected node 42, got %d %v", id, ok)
+	}
+	if ca.pin == "" {
+		t.Fatalf("expected a listener pin")
+	}
+
+	if _, _, err := signNodeCertificate(ca, 42, "not a csr", now); err == nil {
+		t.Fatalf("expected a malformed CSR to be rejected")
+	}
+	// A CSR whose signature does not match its key is rejected.
+	tampered := append([]byte(nil), csrDER...)
+	tampered[len(tampered)-1] ^= 0xff
+	if _, _, err := signNodeCertificate(ca, 42, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: tampered})), now); err == nil {
+		t.Fatalf("expected a CSR with a bad signature to be rejected")
+	}
+}
@@ -0,0 +1,285 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/store/model"
+	"go-backend/internal/ws"
+)
+
+// Control commands for a node that dropped its session are written to a
+// per-node outbox instead of failing, and replayed in order when the node
+// reconnects. Only nodes that have been connected before and went offline
+// within nodeOutboxTTL are queued for: a node that never connected, or has
+// been gone for longer, still fails immediately. While a reconnected node's
+// outbox is being replayed, new commands for it are queued behind the replay
+// too, so they cannot overtake the older ones.
+
+const (
+	nodeOutboxTTL = 24 * time.Hour
+	// nodeOutboxRetention is how long finished commands stay visible.
+	nodeOutboxRetention = 7 * 24 * time.Hour
+	nodeOutboxListLimit = 200
+)
+
+// outboxCommandTypes are the commands that change node state and can be
+// applied late. Probes and agent upgrades are not queued.
+var outboxCommandTypes = map[string]struct{}{
+	"AddService":           {},
+	"UpdateService":        {},
+	"DeleteService":        {},
+	"PauseService":         {},
+	"ResumeService":        {},
+	"AddChains":            {},
+	"UpdateChains":         {},
+	"DeleteChains":         {},
+	"AddLimiters":          {},
+	"UpdateLimiters":       {},
+	"DeleteLimiters":       {},
+	"UpdateAdmissions":     {},
+	"DeleteAdmissions":     {},
+	"UpdateCertificates":   {},
+	"DeleteCertificates":   {},
+	"SetACMEChallenges":    {},
+	"DeleteACMEChallenges": {},
+	"SetProtocol":          {},
+}
+
+var nodeCommandStatusNames = map[int]string{
+	model.NodeCommandPending:   "pending",
+	model.NodeCommandDone:      "done",
+	model.NodeCommandFailed:    "failed",
+	model.NodeCommandExpired:   "expired",
+	model.NodeCommandCancelled: "cancelled",
+}
+
+func isNodeOfflineError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "节点不在线")
+}
+
+// nodeCommandIdempotencyKey identifies a command by type and payload, so
+// issuing the same command again while it is pending only moves it to the
+// end of the queue.
+func nodeCommandIdempotencyKey(commandType string, payload []byte) string {
+	sum := sha256.Sum256(append([]byte(commandType+"\n"), payload...))
+	return commandType + ":" + hex.EncodeToString(sum[:16])
+}
+
+// enqueueNodeCommand queues a command for a recently disconnected node. It
+// reports false when the node does not qualify for queueing.
+func (h *Handler) enqueueNodeCommand(nodeID int64, commandType string, data interface{}, tolerateExists, tolerateNotFound bool) (bool, error) {
+	if _, ok := outboxCommandTypes[commandType]; !ok {
+		return false, nil
+	}
+	disconnectedAt, err := h.repo.NodeDisconnectedAt(nodeID)
+	if err != nil || disconnectedAt <= 0 {
+		return false, err
+	}
+	if time.Since(time.UnixMilli(disconnectedAt)) > nodeOutboxTTL {
+		return false, nil
+	}
+	if err := h.queueNodeCommand(nodeID, commandType, data, tolerateExists, tolerateNotFound); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// enqueueBehindReplay queues a command for a node whose outbox is being
+// replayed. It reports false when no replay is running.
+func (h *Handler) enqueueBehindReplay(nodeID int64, commandType string, data interface{}, tolerateExists, tolerateNotFound bool) (bool, error) {
+	if _, ok := outboxCommandTypes[commandType]; !ok {
+		return false, nil
+	}
+	h.outboxMu.Lock()
+	defer h.outboxMu.Unlock()
+	if _, busy := h.outboxReplaying[nodeID]; !busy {
+		return false, nil
+	}
+	if err := h.queueNodeCommand(nodeID, commandType, data, tolerateExists, tolerateNotFound); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (h *Handler) queueNodeCommand(nodeID int64, commandType string, data interface{}, tolerateExists, tolerateNotFound bool) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	cmd := &model.NodeCommand{
+		NodeID:           nodeID,
+		CommandType:      commandType,
+		Payload:          string(payload),
+		IdempotencyKey:   nodeCommandIdempotencyKey(commandType, payload),
+		TolerateExists:   boolToInt(tolerateExists),
+		TolerateNotFound: boolToInt(tolerateNotFound),
+		CreatedTime:      now.UnixMilli(),
+		UpdatedTime:      now.UnixMilli(),
+		ExpireTime:       now.Add(nodeOutboxTTL).UnixMilli(),
+	}
+	return h.repo.EnqueueNodeCommand(cmd)
+}
+
+// replayNodeOutbox sends a reconnected node its pending commands in order.
+// It stops when the node drops again; a command the node rejects is marked
+// failed and does not hold back the rest.
+func (h *Handler) replayNodeOutbox(nodeID int64) {
+	h.outboxMu.Lock()
+	if h.outboxReplaying == nil {
+		h.outboxReplaying = make(map[int64]struct{})
+	}
+	if _, busy := h.outboxReplaying[nodeID]; busy {
+		h.outboxMu.Unlock()
+		return
+	}
+	h.outboxReplaying[nodeID] = struct{}{}
+	h.outboxMu.Unlock()
+	defer func() {
+		h.outboxMu.Lock()
+		delete(h.outboxReplaying, nodeID)
+		h.outboxMu.Unlock()
+	}()
+
+	for {
+		// Commands issued during the replay land in the outbox as well, so
+		// the replay only ends once it is empty. The outbox is re-read for
+		// every command so ones cancelled or superseded meanwhile are skipped.
+		h.outboxMu.Lock()
+		cmds, err := h.repo.ListPendingNodeCommands(nodeID)
+		if err == nil && len(cmds) == 0 {
+			delete(h.outboxReplaying, nodeID)
+		}
+		h.outboxMu.Unlock()
+		if err != nil {
+			fmt.Printf("outbox: list commands for node %d failed: %v\n", nodeID, err)
+			return
+		}
+		if len(cmds) == 0 || !h.sendNodeOutboxCommand(nodeID, cmds[0]) {
+			return
+		}
+	}
+}
+
+// sendNodeOutboxCommand sends one queued command and records the outcome.
+// It reports false when the node dropped.
+func (h *Handler) sendNodeOutboxCommand(nodeID int64, cmd model.NodeCommand) bool {
+	now := time.Now().UnixMilli()
+	if cmd.ExpireTime <= now {
+		_ = h.repo.FinishNodeCommand(cmd.ID, model.NodeCommandExpired, "", now)
+		return true
+	}
+	var data interface{}
+	if err := json.Unmarshal([]byte(cmd.Payload), &data); err != nil {
+		_ = h.repo.FinishNodeCommand(cmd.ID, model.NodeCommandFailed, err.Error(), now)
+		return true
+	}
+	_, err := h.wsServer.SendCommand(nodeID, cmd.CommandType, data, 12*time.Second)
+	err = tolerateNodeCommandError(err, cmd.TolerateExists == 1, cmd.TolerateNotFound == 1)
+	if isNodeOfflineError(err) {
+		return false
+	}
+	status, lastError := model.NodeCommandDone, ""
+	if err != nil {
+		status, lastError = model.NodeCommandFailed, err.Error()
+	}
+	_ = h.repo.FinishNodeCommand(cmd.ID, status, lastError, time.Now().UnixMilli())
+	return true
+}
+
+func (h *Handler) expireNodeOutbox(now time.Time) {
+	_ = h.repo.ExpireNodeCommands(now.UnixMilli(), now.Add(-nodeOutboxRetention).UnixMilli())
+}
+
+func nodeCommandView(cmd model.NodeCommand) map[string]interface{} {
+	var payload interface{}
+	_ = json.Unmarshal([]byte(cmd.Payload), &payload)
+	return map[string]interface{}{
+		"id":             cmd.ID,
+		"nodeId":         cmd.NodeID,
+		"commandType":    cmd.CommandType,
+		"payload":        payload,
+		"idempotencyKey": cmd.IdempotencyKey,
+		"status":         nodeCommandStatusNames[cmd.Status],
+		"attempts":       cmd.Attempts,
+		"lastError":      cmd.LastError,
+		"createdTime":    cmd.CreatedTime,
+		"updatedTime":    cmd.UpdatedTime,
+		"expireTime":     cmd.ExpireTime,
+	}
+}
+
+// nodeOutboxList lists the queued commands of a node, pending only unless
+// "all" is set.
+func (h *Handler) nodeOutboxList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req map[string]interface{}
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	nodeID := asInt64(req["id"], 0)
+	if nodeID <= 0 {
+		response.WriteJSON(w, response.ErrDefault("节点ID不能为空"))
+		return
+	}
+	status := model.NodeCommandPending
+	if asBool(req["all"], false) {
+		status = -1
+	}
+	cmds, err := h.repo.ListNodeCommands(nodeID, status, nodeOutboxListLimit)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	items := make([]map[string]interface{}, 0, len(cmds))
+	for _, cmd := range cmds {
+		items = append(items, nodeCommandView(cmd))
+	}
+	response.WriteJSON(w, response.OK(items))
+}
+
+// nodeOutboxCancel drops a pending command.
+func (h *Handler) nodeOutboxCancel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	id := idFromBody(r, w)
+	if id <= 0 {
+		return
+	}
+	ok, err := h.repo.CancelNodeCommand(id, time.Now().UnixMilli())
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if !ok {
+		response.WriteJSON(w, response.ErrDefault("命令不存在或已执行"))
+		return
+	}
+	response.WriteJSON(w, response.OKEmpty())
+}
+
+func boolToInt(v bool) int {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// queuedCommandResult is what callers get for a command written to the
+// outbox.
+func queuedCommandResult(commandType string) ws.CommandResult {
+	return ws.CommandResult{Type: commandType + "Response", Success: true, Message: "queued"}
+}
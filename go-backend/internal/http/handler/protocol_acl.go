@@ -0,0 +1,353 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/store/model"
+)
+
+// Protocol rules of the node, tunnel and forward scopes are compiled per
+// forward and entry node into the metadata of the forward's services
+// ("protocol.allow", "protocol.deny", "protocol.whitelist"). The agent
+// identifies the protocol from the first read of a connection, or the first
+// datagram of a UDP session, and counts blocked connections per service; the
+// counters come back with the traffic report as {"n": "<service>_proto", "r": N}.
+//
+// The node HTTP/TLS/Socks switches are separate: the agent applies them to
+// every service on the node, tunnel relays included.
+const (
+	protocolBlockedSuffix = "_proto"
+
+	protocolUnknown = "unknown"
+)
+
+// knownProtocols are the protocols the agent can identify. "unknown" stands
+// for traffic no detector recognises and only matters in allow lists.
+var knownProtocols = map[string]struct{}{
+	"http": {}, "tls": {}, "socks": {}, "ssh": {}, "bittorrent": {},
+	"wireguard": {}, "openvpn": {}, "quic": {}, protocolUnknown: {},
+}
+
+// parseProtocolNames parses a newline/comma/space separated list of protocol
+// names into a sorted, lower-cased, de-duplicated slice.
+func parseProtocolNames(raw string) ([]string, error) {
+	fields := strings.FieldsFunc(raw, func(r rune) bool {
+		return r == ',' || r == ';' || r == '\n' || r == '\r' || r == ' ' || r == '\t'
+	})
+	seen := make(map[string]struct{}, len(fields))
+	out := make([]string, 0, len(fields))
+	for _, field := range fields {
+		name := strings.ToLower(field)
+		if _, ok := knownProtocols[name]; !ok {
+			return nil, fmt.Errorf("不支持的协议: %s", field)
+		}
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// normalizeProtocolNames validates raw input and returns the canonical
+// newline separated form stored in the database.
+func normalizeProtocolNames(raw string) (string, error) {
+	names, err := parseProtocolNames(raw)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(names, "\n"), nil
+}
+
+// compileProtocolACL merges protocol lists the same way compileGeoACL merges
+// countries: allow lists intersect, deny lists union.
+func compileProtocolACL(scopes []model.ProtocolACL) (allow []string, deny []string, restricted bool, err error) {
+	denySet := make(map[string]struct{})
+	for _, scope := range scopes {
+		allowList, err := parseProtocolNames(scope.AllowProtocols)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		denyList, err := parseProtocolNames(scope.DenyProtocols)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		if len(allowList) > 0 {
+			if !restricted {
+				allow = allowList
+				restricted = true
+			} else {
+				allow = intersectCountryCodes(allow, allowList)
+			}
+		}
+		for _, name := range denyList {
+			if _, ok := denySet[name]; ok {
+				continue
+			}
+			denySet[name] = struct{}{}
+			deny = append(deny, name)
+		}
+	}
+	sort.Strings(deny)
+	return allow, deny, restricted, nil
+}
+
+// applyForwardProtocolRules writes the compiled rules into the metadata of
+// the services of a forward. Services without rules carry no keys, so an
+// update that clears the rules also clears them on the agent.
+func applyForwardProtocolRules(services []map[string]interface{}, scopes []model.ProtocolACL) error {
+	allow, deny, restricted, err := compileProtocolACL(scopes)
+	if err != nil {
+		return err
+	}
+	if !restricted && len(deny) == 0 {
+		return nil
+	}
+	if allow == nil {
+		allow = make([]string, 0)
+	}
+	if deny == nil {
+		deny = make([]string, 0)
+	}
+	for _, service := range services {
+		metadata, _ := service["metadata"].(map[string]interface{})
+		if metadata == nil {
+			metadata = make(map[string]interface{})
+			service["metadata"] = metadata
+		}
+		metadata["protocol.whitelist"] = restricted
+		metadata["protocol.allow"] = allow
+		metadata["protocol.deny"] = deny
+	}
+	return nil
+}
+
+func (h *Handler) forwardProtocolScopes(forward *forwardRecord, nodeID int64) ([]model.ProtocolACL, error) {
+	scopes := make([]model.ProtocolACL, 0, 3)
+	nodeACL, err := h.repo.GetNodeProtocolACL(nodeID)
+	if err != nil {
+		return nil, err
+	}
+	if nodeACL != nil {
+		scopes = append(scopes, *nodeACL)
+	}
+	tunnelACL, err := h.repo.GetTunnelProtocolACL(forward.TunnelID)
+	if err != nil {
+		return nil, err
+	}
+	if tunnelACL != nil {
+		scopes = append(scopes, *tunnelACL)
+	}
+	fwdACL, err := h.repo.GetForwardProtocolACL(forward.ID)
+	if err != nil {
+		return nil, err
+	}
+	if fwdACL != nil {
+		scopes = append(scopes, *fwdACL)
+	}
+	return scopes, nil
+}
+
+// isProtocolBlockedItem recognises a protocol blocking counter.
+func isProtocolBlockedItem(item flowItem) bool {
+	return item.R > 0 && strings.HasSuffix(strings.TrimSpace(item.N), protocolBlockedSuffix)
+}
+
+// parseProtocolBlockedItem returns the forward a protocol blocking counter
+// belongs to. Counters of other services only add to the node total.
+func parseProtocolBlockedItem(item flowItem) (int64, bool) {
+	if !isProtocolBlockedItem(item) {
+		return 0, false
+	}
+	forwardID, _, _, ok := parseFlowServiceIDs(strings.TrimSuffix(strings.TrimSpace(item.N), protocolBlockedSuffix))
+	return forwardID, ok
+}
+
+func (h *Handler) forwardProtocols(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req map[string]interface{}
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	id := asInt64(req["id"], 0)
+	if id <= 0 {
+		response.WriteJSON(w, response.ErrDefault("转发ID不能为空"))
+		return
+	}
+	forward, _, _, err := h.resolveForwardAccess(r, id)
+	if err != nil {
+		if errors.Is(err, errForwardNotFound) {
+			response.WriteJSON(w, response.ErrDefault("转发不存在"))
+			return
+		}
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	allow, deny, ok := normalizeProtocolACLRequest(w, req)
+	if !ok {
+		return
+	}
+	old, err := h.repo.GetForwardProtocolACL(id)
+	if err != nil || old == nil {
+		response.WriteJSON(w, response.ErrDefault("转发不存在"))
+		return
+	}
+
+	now := time.Now().UnixMilli()
+	if err := h.repo.UpdateForwardProtocolACL(id, allow, deny, now); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if err := h.syncForwardServices(forward, "UpdateService", true); err != nil {
+		_ = h.repo.UpdateForwardProtocolACL(id, old.AllowProtocols, old.DenyProtocols, now)
+		_ = h.syncForwardServices(forward, "UpdateService", true)
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OKEmpty())
+}
+
+func (h *Handler) tunnelProtocols(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req map[string]interface{}
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	id := asInt64(req["id"], 0)
+	if id <= 0 {
+		response.WriteJSON(w, response.ErrDefault("隧道ID不能为空"))
+		return
+	}
+	old, err := h.repo.GetTunnelProtocolACL(id)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if old == nil {
+		response.WriteJSON(w, response.ErrDefault("隧道不存在"))
+		return
+	}
+	allow, deny, ok := normalizeProtocolACLRequest(w, req)
+	if !ok {
+		return
+	}
+	if err := h.repo.UpdateTunnelProtocolACL(id, allow, deny, time.Now().UnixMilli()); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+
+	forwards, err := h.listForwardsByTunnel(id)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	failed := make([]string, 0)
+	for i := range forwards {
+		if err := h.syncForwardServices(&forwards[i], "UpdateService", true); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", forwards[i].Name, err))
+		}
+	}
+	if len(failed) > 0 {
+		response.WriteJSON(w, response.ErrDefault("部分转发下发失败: "+strings.Join(failed, "; ")))
+		return
+	}
+	response.WriteJSON(w, response.OKEmpty())
+}
+
+func (h *Handler) nodeProtocols(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req map[string]interface{}
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	id := asInt64(req["id"], 0)
+	if id <= 0 {
+		response.WriteJSON(w, response.ErrDefault("节点ID不能为空"))
+		return
+	}
+	old, err := h.repo.GetNodeProtocolACL(id)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if old == nil {
+		response.WriteJSON(w, response.ErrDefault("节点不存在"))
+		return
+	}
+	allow, deny, ok := normalizeProtocolACLRequest(w, req)
+	if !ok {
+		return
+	}
+	if err := h.repo.UpdateNodeProtocolACL(id, allow, deny, time.Now().UnixMilli()); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+
+	forwardIDs, err := h.repo.ListForwardIDsByNode(id)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	failed := make([]string, 0)
+	for _, forwardID := range forwardIDs {
+		forward, err := h.getForwardRecord(forwardID)
+		if err != nil || forward == nil {
+			continue
+		}
+		ports, err := h.listForwardPorts(forwardID)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", forward.Name, err))
+			continue
+		}
+		onNode := make([]forwardPortRecord, 0, 1)
+		for _, fp := range ports {
+			if fp.NodeID == id {
+				onNode = append(onNode, fp)
+			}
+		}
+		if err := h.syncForwardServicesOnPorts(forward, onNode, "UpdateService", true); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", forward.Name, err))
+		}
+	}
+	if len(failed) > 0 {
+		response.WriteJSON(w, response.ErrDefault("部分转发下发失败: "+strings.Join(failed, "; ")))
+		return
+	}
+	response.WriteJSON(w, response.OKEmpty())
+}
+
+// normalizeProtocolACLRequest reads allowProtocols/denyProtocols from the
+// request and writes the validation error itself when the input is rejected.
+func normalizeProtocolACLRequest(w http.ResponseWriter, req map[string]interface{}) (string, string, bool) {
+	allow, err := normalizeProtocolNames(sourceCIDRInput(req["allowProtocols"]))
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault("允许协议: "+err.Error()))
+		return "", "", false
+	}
+	deny, err := normalizeProtocolNames(sourceCIDRInput(req["denyProtocols"]))
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault("拒绝协议: "+err.Error()))
+		return "", "", false
+	}
+	return allow, deny, true
+}
@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"reflect"
+	"testing"
+
+	"go-backend/internal/store/model"
+)
+
+func TestNormalizeProtocolNames(t *testing.T) {
+	got, err := normalizeProtocolNames("SSH, bittorrent\nquic;ssh unknown")
+	if err != nil {
+		t.Fatalf("normalize: %v", err)
+	}
+	if want := "bittorrent\nquic\nssh\nunknown"; got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+
+	for _, bad := range []string{"ftp", "ss h", "udp"} {
+		if _, err := normalizeProtocolNames(bad); err == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestApplyForwardProtocolRulesWithoutRules(t *testing.T) {
+	services := []map[string]interface{}{{"name": "1_2_3_tcp"}}
+	if err := applyForwardProtocolRules(services, []model.ProtocolACL{{}, {}}); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if _, ok := services[0]["metadata"]; ok {
+		t.Fatalf("expected no metadata without rules, got %v", services[0]["metadata"])
+	}
+}
+
+func TestApplyForwardProtocolRulesMergesScopes(t *testing.T) {
+	services := []map[string]interface{}{
+		{"name": "1_2_3_tcp", "metadata": map[string]interface{}{"drainGrace": "30s"}},
+		{"name": "1_2_3_udp"},
+	}
+	scopes := []model.ProtocolACL{
+		{DenyProtocols: "bittorrent"},
+		{AllowProtocols: "http\ntls\nquic\nunknown", DenyProtocols: "ssh"},
+		{AllowProtocols: "tls\nquic", DenyProtocols: "bittorrent"},
+	}
+	if err := applyForwardProtocolRules(services, scopes); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	for _, service := range services {
+		metadata := service["metadata"].(map[string]interface{})
+		if metadata["protocol.whitelist"] != true {
+			t.Fatalf("expected whitelist mode for %v", service["name"])
+		}
+		if got, want := metadata["protocol.allow"], []string{"quic", "tls"}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("allow: expected %v, got %v", want, got)
+		}
+		if got, want := metadata["protocol.deny"], []string{"bittorrent", "ssh"}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("deny: expected %v, got %v", want, got)
+		}
+	}
+	if services[0]["metadata"].(map[string]interface{})["drainGrace"] != "30s" {
+		t.Fatalf("expected existing metadata to be kept")
+	}
+}
+
+func TestApplyForwardProtocolRulesRejectsStoredGarbage(t *testing.T) {
+	services := []map[string]interface{}{{"name": "1_2_3_tcp"}}
+	if err := applyForwardProtocolRules(services, []model.ProtocolACL{{DenyProtocols: "ftp"}}); err == nil {
+		t.Fatalf("expected unknown protocol to fail")
+	}
+}
+
+func TestParseProtocolBlockedItem(t *testing.T) {
+	if id, ok := parseProtocolBlockedItem(flowItem{N: "7_2_3_tcp_proto", R: 5}); !ok || id != 7 {
+		t.Fatalf("expected forward 7, got %d (%v)", id, ok)
+	}
+	if _, ok := parseProtocolBlockedItem(flowItem{N: "7_2_3_tcp_proto"}); ok {
+		t.Fatalf("expected item without blocks to be ignored")
+	}
+	if _, ok := parseProtocolBlockedItem(flowItem{N: "7_2_3_tcp", R: 5}); ok {
+		t.Fatalf("expected service item to be ignored")
+	}
+	if !isProtocolBlockedItem(flowItem{N: "relay_tcp_proto", R: 2}) {
+		t.Fatalf("expected non-forward counter to still count for the node")
+	}
+	if _, ok := parseProtocolBlockedItem(flowItem{N: "relay_tcp_proto", R: 2}); ok {
+		t.Fatalf("expected non-forward counter to have no forward")
+	}
+}
@@ -0,0 +1,805 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-backend/internal/http/response"
+)
+
+// The database is the desired state of every node. Each config report an
+// agent posts to /flow/config (or returns for the GetConfig command) is
+// compared with the services, chains, limiters and admissions the database
+// says the node should run. Missing and drifted items are pushed again, paused state
+// is corrected, and items still owned by a forward or tunnel that no longer
+// places them on the node are deleted. Items whose owner is gone entirely
+// are left to cleanNodeConfigs.
+
+const (
+	driftMissing  = "missing"
+	driftChanged  = "drifted"
+	driftPaused   = "paused"   // running in the database, paused on the node
+	driftUnpaused = "unpaused" // paused in the database, running on the node
+	driftOrphaned = "orphaned"
+
+	driftKindService   = "service"
+	driftKindChain     = "chain"
+	driftKindLimiter   = "limiter"
+	driftKindAdmission = "admission"
+)
+
+var (
+	errReconcileOwnerInactive = errors.New("owner inactive")
+
+	forwardServiceNamePattern = regexp.MustCompile(`^(\d+)_\d+_\d+(_tcp|_udp)?$`)
+	tunnelServiceNamePattern  = regexp.MustCompile(`^(\d+)_tls$`)
+	tunnelChainNamePattern    = regexp.MustCompile(`^chains_(\d+)$`)
+)
+
+// reportedGostConfig is the part of an agent config report the reconciler
+// compares.
+type reportedGostConfig struct {
+	Services   []map[string]interface{} `json:"services"`
+	Chains     []map[string]interface{} `json:"chains"`
+	Limiters   []map[string]interface{} `json:"limiters"`
+	Admissions []map[string]interface{} `json:"admissions"`
+}
+
+type nodeConfigReport struct {
+	at     int64
+	config reportedGostConfig
+}
+
+type driftItem struct {
+	Kind      string   `json:"kind"`
+	Name      string   `json:"name"`
+	Status    string   `json:"status"`
+	ForwardID int64    `json:"forwardId,omitempty"`
+	TunnelID  int64    `json:"tunnelId,omitempty"`
+	Fields    []string `json:"fields,omitempty"`
+	Repaired  bool     `json:"repaired"`
+	Error     string   `json:"error,omitempty"`
+}
+
+type desiredConfigItem struct {
+	kind     string
+	name     string
+	config   map[string]interface{}
+	forward  *forwardRecord
+	port     forwardPortRecord
+	tunnelID int64
+	paused   bool
+	// optional marks admissions, and references to them, that filter
+	// nothing. Agents too old for admissions run without them, so their
+	// absence is not drift.
+	optional bool
+}
+
+type desiredNodeConfig struct {
+	items           map[string]*desiredConfigItem
+	skippedForwards map[int64]struct{}
+	skippedTunnels  map[int64]struct{}
+	errors          []string
+}
+
+func desiredConfigKey(kind, name string) string {
+	return kind + "/" + name
+}
+
+func (d *desiredNodeConfig) add(item *desiredConfigItem) {
+	item.config = normalizeConfigMap(item.config)
+	d.items[desiredConfigKey(item.kind, item.name)] = item
+}
+
+// normalizeConfigMap gives a built config the shape it has once decoded from
+// an agent report.
+func normalizeConfigMap(cfg map[string]interface{}) map[string]interface{} {
+	raw, err := json.Marshal(cfg)
+	if err != nil {
+		return cfg
+	}
+	var out map[string]interface{}
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return cfg
+	}
+	return out
+}
+
+// desiredNodeConfig builds what the database says a local node should run.
+// Owners that cannot be resolved are recorded as skipped so their items on
+// the node are neither repaired nor deleted.
+func (h *Handler) desiredNodeConfig(node *nodeRecord) (*desiredNodeConfig, error) {
+	desired := &desiredNodeConfig{
+		items:           make(map[string]*desiredConfigItem),
+		skippedForwards: make(map[int64]struct{}),
+		skippedTunnels:  make(map[int64]struct{}),
+	}
+
+	tunnelIDs, err := h.repo.ListActiveTunnelIDsByNode(node.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, tunnelID := range tunnelIDs {
+		if err := h.addDesiredTunnel(desired, node, tunnelID); err != nil {
+			desired.skippedTunnels[tunnelID] = struct{}{}
+			desired.errors = append(desired.errors, fmt.Sprintf("隧道 %d: %v", tunnelID, err))
+		}
+	}
+
+	forwardIDs, err := h.repo.ListForwardIDsOnNode(node.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, forwardID := range forwardIDs {
+		if err := h.addDesiredForward(desired, node, forwardID); err != nil {
+			desired.skippedForwards[forwardID] = struct{}{}
+			if !errors.Is(err, errReconcileOwnerInactive) {
+				desired.errors = append(desired.errors, fmt.Sprintf("转发 %d: %v", forwardID, err))
+			}
+		}
+	}
+	return desired, nil
+}
+
+func (h *Handler) addDesiredForward(desired *desiredNodeConfig, node *nodeRecord, forwardID int64) error {
+	forward, err := h.getForwardRecord(forwardID)
+	if err != nil {
+		return err
+	}
+	tunnel, err := h.getTunnelRecord(forward.TunnelID)
+	if err != nil {
+		return err
+	}
+	if tunnel.Status != 1 {
+		return errReconcileOwnerInactive
+	}
+	if _, skipped := desired.skippedTunnels[forward.TunnelID]; skipped {
+		return errReconcileOwnerInactive
+	}
+	ports, err := h.listForwardPorts(forward.ID)
+	if err != nil {
+		return err
+	}
+	var port *forwardPortRecord
+	for i := range ports {
+		if ports[i].NodeID == node.ID {
+			port = &ports[i]
+			break
+		}
+	}
+	if port == nil {
+		return errReconcileOwnerInactive
+	}
+
+	userTunnelID, limiterID, speed, err := h.resolveUserTunnelAndLimiter(forward.UserID, forward.TunnelID)
+	if err != nil {
+		return err
+	}
+	tunnelTLSProtocol, err := h.isTunnelSelectedTLSProtocol(forward.TunnelID)
+	if err != nil {
+		return err
+	}
+	serviceBase := buildForwardServiceBase(forward.ID, forward.UserID, userTunnelID)
+	admissions, hasAdmissionRules, err := h.forwardAdmissions(forward, node.ID, serviceBase)
+	if err != nil {
+		return err
+	}
+	protocolScopes, err := h.forwardProtocolScopes(forward, node.ID)
+	if err != nil {
+		return err
+	}
+	services := buildForwardServiceConfigs(serviceBase, forward, tunnel, node, port.Port, limiterID, tunnelTLSProtocol)
+	for _, service := range services {
+		service["admissions"] = forwardAdmissionNames(serviceBase)
+	}
+	if err := applyForwardProtocolRules(services, protocolScopes); err != nil {
+		return err
+	}
+	for _, service := range services {
+		desired.add(&desiredConfigItem{
+			kind:     driftKindService,
+			name:     asString(service["name"]),
+			config:   service,
+			forward:  forward,
+			port:     *port,
+			paused:   forward.Status != 1,
+			optional: !hasAdmissionRules,
+		})
+	}
+	for _, admission := range admissions {
+		desired.add(&desiredConfigItem{
+			kind:     driftKindAdmission,
+			name:     asString(admission["name"]),
+			config:   admission,
+			forward:  forward,
+			port:     *port,
+			optional: !hasAdmissionRules,
+		})
+	}
+	if limiterID != nil && *limiterID > 0 && speed != nil {
+		name := strconv.FormatInt(*limiterID, 10)
+		rate := float64(*speed) / 8.0
+		desired.add(&desiredConfigItem{
+			kind: driftKindLimiter,
+			name: name,
+			config: map[string]interface{}{
+				"name":   name,
+				"limits": []string{fmt.Sprintf("$ %.1fMB %.1fMB", rate, rate)},
+			},
+		})
+	}
+	return nil
+}
+
+// addDesiredTunnel adds the chain and relay service a type-2 tunnel places on
+// the node, following applyTunnelRuntime.
+func (h *Handler) addDesiredTunnel(desired *desiredNodeConfig, node *nodeRecord, tunnelID int64) error {
+	state, err := h.reconstructTunnelState(tunnelID)
+	if err != nil {
+		return err
+	}
+	if state.Type != 2 {
+		return nil
+	}
+	addChain := func(targets []tunnelRuntimeNode) error {
+		chain, err := buildTunnelChainConfig(tunnelID, node.ID, targets, state.Nodes, state.IPPreference)
+		if err != nil {
+			return err
+		}
+		desired.add(&desiredConfigItem{kind: driftKindChain, name: asString(chain["name"]), config: chain, tunnelID: tunnelID})
+		return nil
+	}
+	addService := func(chainNode tunnelRuntimeNode) {
+		for _, service := range buildTunnelChainServiceConfig(tunnelID, chainNode, node) {
+			desired.add(&desiredConfigItem{kind: driftKindService, name: asString(service["name"]), config: service, tunnelID: tunnelID})
+		}
+	}
+
+	for _, inNode := range state.InNodes {
+		if inNode.NodeID != node.ID {
+			continue
+		}
+		targets := state.OutNodes
+		if len(state.ChainHops) > 0 {
+			targets = state.ChainHops[0]
+		}
+		if err := addChain(targets); err != nil {
+			return err
+		}
+	}
+	for i, hop := range state.ChainHops {
+		nextTargets := state.OutNodes
+		if i+1 < len(state.ChainHops) {
+			nextTargets = state.ChainHops[i+1]
+		}
+		for _, chainNode := range hop {
+			if chainNode.NodeID != node.ID {
+				continue
+			}
+			if err := addChain(nextTargets); err != nil {
+				return err
+			}
+			addService(chainNode)
+		}
+	}
+	for _, outNode := range state.OutNodes {
+		if outNode.NodeID == node.ID {
+			addService(outNode)
+		}
+	}
+	return nil
+}
+
+type configField struct {
+	key   string
+	value string
+}
+
+// Only the fields that decide where traffic goes are compared; metadata the
+// agent adds at runtime would otherwise show every item as drifted.
+func serviceConfigFields(svc map[string]interface{}) []configField {
+	return []configField{
+		{"addr", configValue(svc, "addr")},
+		{"handler", configValue(svc, "handler", "type")},
+		{"chain", configValue(svc, "handler", "chain")},
+		{"listener", configValue(svc, "listener", "type")},
+		{"limiter", configValue(svc, "limiter")},
+		{"forwarder", strings.Join(configNodeAddrs(configMap(svc, "forwarder")), ",")},
+		{"admissions", configList(svc["admissions"])},
+		{"protocol", strings.Join([]string{
+			configValue(svc, "metadata", "protocol.whitelist"),
+			configList(configMap(svc, "metadata")["protocol.allow"]),
+			configList(configMap(svc, "metadata")["protocol.deny"]),
+		}, "/")},
+	}
+}
+
+func chainConfigFields(chain map[string]interface{}) []configField {
+	nodes := make([]string, 0)
+	for _, hop := range configSlice(chain["hops"]) {
+		hopMap, _ := hop.(map[string]interface{})
+		for _, n := range configSlice(hopMap["nodes"]) {
+			nodeMap, _ := n.(map[string]interface{})
+			nodes = append(nodes, configValue(nodeMap, "addr")+"/"+configValue(nodeMap, "dialer", "type"))
+		}
+	}
+	return []configField{{"hops", strings.Join(nodes, ",")}}
+}
+
+func limiterConfigFields(limiter map[string]interface{}) []configField {
+	limits := make([]string, 0)
+	for _, l := range configSlice(limiter["limits"]) {
+		limits = append(limits, asString(l))
+	}
+	return []configField{{"limits", strings.Join(limits, ",")}}
+}
+
+// admissionConfigFields compares the rules of an admission. The agent omits
+// false and empty values from its report.
+func admissionConfigFields(admission map[string]interface{}) []configField {
+	whitelist, _ := admission["whitelist"].(bool)
+	return []configField{
+		{"whitelist", strconv.FormatBool(whitelist)},
+		{"matchers", configList(admission["matchers"])},
+		{"geoip", configList(configMap(admission, "geoip")["allow"]) + "/" + configList(configMap(admission, "geoip")["deny"])},
+	}
+}
+
+func configFieldsOf(kind string, cfg map[string]interface{}) []configField {
+	switch kind {
+	case driftKindAdmission:
+		return admissionConfigFields(cfg)
+	case driftKindChain:
+		return chainConfigFields(cfg)
+	case driftKindLimiter:
+		return limiterConfigFields(cfg)
+	default:
+		return serviceConfigFields(cfg)
+	}
+}
+
+// changedConfigFields lists the compared fields that differ.
+func changedConfigFields(kind string, want, got map[string]interface{}) []string {
+	wantFields := configFieldsOf(kind, want)
+	gotFields := configFieldsOf(kind, got)
+	changed := make([]string, 0)
+	for i := range wantFields {
+		if wantFields[i].value != gotFields[i].value {
+			changed = append(changed, wantFields[i].key)
+		}
+	}
+	return changed
+}
+
+// changedItemFields is changedConfigFields for a desired item. A service may
+// lack optional admission references.
+func changedItemFields(want *desiredConfigItem, got map[string]interface{}) []string {
+	changed := changedConfigFields(want.kind, want.config, got)
+	if !want.optional || want.kind != driftKindService || len(configSlice(got["admissions"])) > 0 {
+		return changed
+	}
+	out := make([]string, 0, len(changed))
+	for _, field := range changed {
+		if field != "admissions" {
+			out = append(out, field)
+		}
+	}
+	return out
+}
+
+func configMap(m map[string]interface{}, key string) map[string]interface{} {
+	v, _ := m[key].(map[string]interface{})
+	return v
+}
+
+func configSlice(v interface{}) []interface{} {
+	s, _ := v.([]interface{})
+	return s
+}
+
+// configList joins the values of a list field.
+func configList(v interface{}) string {
+	values := make([]string, 0)
+	for _, item := range configSlice(v) {
+		values = append(values, asString(item))
+	}
+	return strings.Join(values, ",")
+}
+
+func configValue(m map[string]interface{}, path ...string) string {
+	for i, key := range path {
+		if m == nil {
+			return ""
+		}
+		if i == len(path)-1 {
+			return asString(m[key])
+		}
+		m = configMap(m, key)
+	}
+	return ""
+}
+
+func configNodeAddrs(forwarder map[string]interface{}) []string {
+	addrs := make([]string, 0)
+	for _, n := range configSlice(forwarder["nodes"]) {
+		nodeMap, _ := n.(map[string]interface{})
+		addrs = append(addrs, configValue(nodeMap, "addr"))
+	}
+	return addrs
+}
+
+func isPausedServiceConfig(svc map[string]interface{}) bool {
+	paused, _ := configMap(svc, "metadata")["paused"].(bool)
+	return paused
+}
+
+// diffNodeConfig compares the desired state of a node with its report.
+func (h *Handler) diffNodeConfig(desired *desiredNodeConfig, reported reportedGostConfig) []driftItem {
+	reportedItems := make(map[string]map[string]interface{})
+	index := func(kind string, items []map[string]interface{}) {
+		for _, item := range items {
+			if name := asString(item["name"]); name != "" {
+				reportedItems[desiredConfigKey(kind, name)] = item
+			}
+		}
+	}
+	index(driftKindService, reported.Services)
+	index(driftKindChain, reported.Chains)
+	index(driftKindLimiter, reported.Limiters)
+	index(driftKindAdmission, reported.Admissions)
+
+	keys := make([]string, 0, len(desired.items))
+	for key := range desired.items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	items := make([]driftItem, 0)
+	for _, key := range keys {
+		want := desired.items[key]
+		item := driftItem{Kind: want.kind, Name: want.name, TunnelID: want.tunnelID}
+		if want.forward != nil {
+			item.ForwardID = want.forward.ID
+			item.TunnelID = want.forward.TunnelID
+		}
+		got, ok := reportedItems[key]
+		var fields []string
+		if ok {
+			fields = changedItemFields(want, got)
+		}
+		switch {
+		case !ok && want.optional && want.kind == driftKindAdmission:
+			continue
+		case !ok:
+			item.Status = driftMissing
+		case len(fields) > 0:
+			item.Status = driftChanged
+			item.Fields = fields
+		case want.kind == driftKindService && want.paused != isPausedServiceConfig(got):
+			item.Status = driftPaused
+			if want.paused {
+				item.Status = driftUnpaused
+			}
+		default:
+			continue
+		}
+		items = append(items, item)
+	}
+
+	orphans := make([]driftItem, 0)
+	for key, got := range reportedItems {
+		if _, ok := desired.items[key]; ok {
+			continue
+		}
+		if item, ok := h.orphanedDriftItem(desired, key, asString(got["name"])); ok {
+			orphans = append(orphans, item)
+		}
+	}
+	sort.Slice(orphans, func(i, j int) bool {
+		return desiredConfigKey(orphans[i].Kind, orphans[i].Name) < desiredConfigKey(orphans[j].Kind, orphans[j].Name)
+	})
+	return append(items, orphans...)
+}
+
+// orphanedDriftItem reports an undesired item whose owner still exists and
+// is active. Limiters are never orphaned: the panel pushes them to every
+// entry node of a tunnel, with or without forwards there.
+func (h *Handler) orphanedDriftItem(desired *desiredNodeConfig, key, name string) (driftItem, bool) {
+	item := driftItem{Name: name, Status: driftOrphaned}
+	switch {
+	case strings.HasPrefix(key, driftKindService+"/"):
+		item.Kind = driftKindService
+		if m := forwardServiceNamePattern.FindStringSubmatch(name); m != nil {
+			forwardID, _ := strconv.ParseInt(m[1], 10, 64)
+			if _, skipped := desired.skippedForwards[forwardID]; skipped || !h.forwardExists(forwardID) {
+				return item, false
+			}
+			item.ForwardID = forwardID
+			return item, true
+		}
+		if m := tunnelServiceNamePattern.FindStringSubmatch(name); m != nil {
+			item.TunnelID, _ = strconv.ParseInt(m[1], 10, 64)
+			return item, h.tunnelOwnsOrphan(desired, item.TunnelID)
+		}
+	case strings.HasPrefix(key, driftKindChain+"/"):
+		item.Kind = driftKindChain
+		if m := tunnelChainNamePattern.FindStringSubmatch(name); m != nil {
+			item.TunnelID, _ = strconv.ParseInt(m[1], 10, 64)
+			return item, h.tunnelOwnsOrphan(desired, item.TunnelID)
+		}
+	}
+	return item, false
+}
+
+func (h *Handler) tunnelOwnsOrphan(desired *desiredNodeConfig, tunnelID int64) bool {
+	if _, skipped := desired.skippedTunnels[tunnelID]; skipped {
+		return false
+	}
+	tunnel, err := h.getTunnelRecord(tunnelID)
+	return err == nil && tunnel.Status == 1
+}
+
+type forwardRepair struct {
+	item  *desiredConfigItem
+	sync  bool
+	items []int
+}
+
+// repairNodeDrift pushes the desired state for every drift item and records
+// the outcome on it.
+func (h *Handler) repairNodeDrift(nodeID int64, desired *desiredNodeConfig, items []driftItem) {
+	setResult := func(i int, err error) {
+		items[i].Repaired = err == nil
+		if err != nil {
+			items[i].Error = err.Error()
+		}
+	}
+
+	forwards := make(map[int64]*forwardRepair)
+	forwardOrder := make([]int64, 0)
+	orphanServices := make([]int, 0)
+	orphanChains := make([]int, 0)
+	for i := range items {
+		if items[i].Status == driftOrphaned {
+			if items[i].Kind == driftKindChain {
+				orphanChains = append(orphanChains, i)
+			} else {
+				orphanServices = append(orphanServices, i)
+			}
+			continue
+		}
+		want := desired.items[desiredConfigKey(items[i].Kind, items[i].Name)]
+		if want.forward != nil {
+			repair := forwards[want.forward.ID]
+			if repair == nil {
+				repair = &forwardRepair{item: want}
+				forwards[want.forward.ID] = repair
+				forwardOrder = append(forwardOrder, want.forward.ID)
+			}
+			repair.sync = repair.sync || items[i].Status == driftMissing || items[i].Status == driftChanged
+			repair.items = append(repair.items, i)
+			continue
+		}
+		var err error
+		switch want.kind {
+		case driftKindLimiter:
+			_, err = h.sendNodeCommand(nodeID, "UpdateLimiters", map[string]interface{}{"limiter": want.name, "data": want.config}, false, false)
+		case driftKindChain:
+			_, err = h.sendNodeCommand(nodeID, "UpdateChains", map[string]interface{}{"chain": want.name, "data": want.config}, false, false)
+		default:
+			_, err = h.sendNodeCommand(nodeID, "UpdateService", []map[string]interface{}{want.config}, false, false)
+		}
+		setResult(i, err)
+	}
+
+	for _, forwardID := range forwardOrder {
+		repair := forwards[forwardID]
+		forward, ports := repair.item.forward, []forwardPortRecord{repair.item.port}
+		var err error
+		if repair.sync {
+			// UpdateService recreates the services running, so a paused
+			// forward is paused again afterwards.
+			err = h.syncForwardServicesOnPorts(forward, ports, "UpdateService", true)
+			if err == nil && forward.Status != 1 {
+				err = h.controlForwardServicesOnPorts(forward, ports, "PauseService", true)
+			}
+		} else if forward.Status == 1 {
+			err = h.controlForwardServicesOnPorts(forward, ports, "ResumeService", true)
+		} else {
+			err = h.controlForwardServicesOnPorts(forward, ports, "PauseService", true)
+		}
+		for _, i := range repair.items {
+			setResult(i, err)
+		}
+	}
+
+	if len(orphanServices) > 0 {
+		names := make([]string, 0, len(orphanServices))
+		for _, i := range orphanServices {
+			names = append(names, items[i].Name)
+		}
+		_, err := h.sendNodeCommand(nodeID, "DeleteService", map[string]interface{}{"services": names}, false, true)
+		for _, i := range orphanServices {
+			setResult(i, err)
+		}
+	}
+	for _, i := range orphanChains {
+		_, err := h.sendNodeCommand(nodeID, "DeleteChains", map[string]interface{}{"chain": items[i].Name}, false, true)
+		setResult(i, err)
+	}
+}
+
+func (h *Handler) storeNodeConfigReport(nodeID int64, cfg reportedGostConfig) {
+	h.reconcileMu.Lock()
+	defer h.reconcileMu.Unlock()
+	if h.nodeConfigReports == nil {
+		h.nodeConfigReports = make(map[int64]nodeConfigReport)
+	}
+	h.nodeConfigReports[nodeID] = nodeConfigReport{at: time.Now().UnixMilli(), config: cfg}
+}
+
+func (h *Handler) lastNodeConfigReport(nodeID int64) (nodeConfigReport, bool) {
+	h.reconcileMu.Lock()
+	defer h.reconcileMu.Unlock()
+	report, ok := h.nodeConfigReports[nodeID]
+	return report, ok
+}
+
+// nodeReconcileLock serialises reconciliation runs of one node.
+func (h *Handler) nodeReconcileLock(nodeID int64) *sync.Mutex {
+	h.reconcileMu.Lock()
+	defer h.reconcileMu.Unlock()
+	if h.reconcileLocks == nil {
+		h.reconcileLocks = make(map[int64]*sync.Mutex)
+	}
+	lock := h.reconcileLocks[nodeID]
+	if lock == nil {
+		lock = &sync.Mutex{}
+		h.reconcileLocks[nodeID] = lock
+	}
+	return lock
+}
+
+// reconcileNodeReport runs after a periodic config report. A run already in
+// progress for the node makes it a no-op.
+func (h *Handler) reconcileNodeReport(nodeID int64, rawConfig string) {
+	var cfg reportedGostConfig
+	if err := json.Unmarshal([]byte(rawConfig), &cfg); err != nil {
+		return
+	}
+	h.storeNodeConfigReport(nodeID, cfg)
+
+	lock := h.nodeReconcileLock(nodeID)
+	if !lock.TryLock() {
+		return
+	}
+	defer lock.Unlock()
+	if _, err := h.reconcileNode(nodeID, cfg, true); err != nil {
+		fmt.Printf("reconcile: node %d failed: %v\n", nodeID, err)
+	}
+}
+
+// reconcileNode diffs a node against cfg and, when repair is set, pushes the
+// fixes.
+func (h *Handler) reconcileNode(nodeID int64, cfg reportedGostConfig, repair bool) (map[string]interface{}, error) {
+	node, err := h.getNodeRecord(nodeID)
+	if err != nil {
+		return nil, err
+	}
+	if node.IsRemote == 1 {
+		return nil, errors.New("远程节点不支持对账")
+	}
+	desired, err := h.desiredNodeConfig(node)
+	if err != nil {
+		return nil, err
+	}
+	items := h.diffNodeConfig(desired, cfg)
+	if repair && len(items) > 0 {
+		h.repairNodeDrift(nodeID, desired, items)
+	}
+
+	counts := map[string]int{}
+	for _, item := range items {
+		counts[item.Status]++
+	}
+	return map[string]interface{}{
+		"nodeId":   node.ID,
+		"nodeName": node.Name,
+		"inSync":   len(items) == 0,
+		"desired":  len(desired.items),
+		"counts":   counts,
+		"items":    items,
+		"errors":   desired.errors,
+	}, nil
+}
+
+// nodeDrift reports the drift of a node against its last config report
+// without changing anything.
+func (h *Handler) nodeDrift(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	id := idFromBody(r, w)
+	if id <= 0 {
+		return
+	}
+	last, ok := h.lastNodeConfigReport(id)
+	if !ok {
+		response.WriteJSON(w, response.ErrDefault("节点尚未上报配置"))
+		return
+	}
+	report, err := h.reconcileNode(id, last.config, false)
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
+	report["reportedAt"] = last.at
+	response.WriteJSON(w, response.OK(report))
+}
+
+// nodeReconcile fetches the live config of a node and repairs it now. The
+// last periodic report is used when the agent cannot return its config.
+func (h *Handler) nodeReconcile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	id := idFromBody(r, w)
+	if id <= 0 {
+		return
+	}
+	node, err := h.getNodeRecord(id)
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
+	if node.Status != 1 {
+		response.WriteJSON(w, response.ErrDefault("节点不在线"))
+		return
+	}
+
+	lock := h.nodeReconcileLock(id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	source := "live"
+	reportedAt := time.Now().UnixMilli()
+	var cfg reportedGostConfig
+	result, err := h.sendNodeCommand(id, "GetConfig", map[string]interface{}{}, false, false)
+	if err == nil && len(result.Data) > 0 {
+		raw, _ := json.Marshal(result.Data)
+		if err = json.Unmarshal(raw, &cfg); err == nil {
+			h.cleanNodeConfigs(id, string(raw))
+		}
+	} else if err == nil {
+		err = errors.New("节点未返回配置")
+	}
+	if err == nil {
+		h.storeNodeConfigReport(id, cfg)
+	} else {
+		last, ok := h.lastNodeConfigReport(id)
+		if !ok {
+			response.WriteJSON(w, response.ErrDefault("获取节点配置失败: "+err.Error()))
+			return
+		}
+		source, reportedAt, cfg = "report", last.at, last.config
+	}
+
+	report, err := h.reconcileNode(id, cfg, true)
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
+	report["source"] = source
+	report["reportedAt"] = reportedAt
+	response.WriteJSON(w, response.OK(report))
+}
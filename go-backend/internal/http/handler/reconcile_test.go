@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"reflect"
+	"testing"
+
+	"go-backend/internal/store/model"
+)
+
+func TestChangedConfigFields(t *testing.T) {
+	nodes := map[int64]*nodeRecord{
+		1: {ID: 1, ServerIP: "10.0.0.1", ServerIPv4: "10.0.0.1", TCPListenAddr: "[::]"},
+		2: {ID: 2, ServerIP: "10.0.0.2", ServerIPv4: "10.0.0.2", TCPListenAddr: "[::]"},
+	}
+	chain, err := buildTunnelChainConfig(7, 1, []tunnelRuntimeNode{{NodeID: 2, Protocol: "tls", Port: 9000}}, nodes, "")
+	if err != nil {
+		t.Fatalf("build chain: %v", err)
+	}
+	want := normalizeConfigMap(chain)
+
+	// The agent adds runtime fields that are not compared.
+	got := normalizeConfigMap(chain)
+	got["metadata"] = map[string]interface{}{"updated": true}
+	if fields := changedConfigFields(driftKindChain, want, got); len(fields) != 0 {
+		t.Fatalf("expected an unchanged chain, got %v", fields)
+	}
+
+	moved, _ := buildTunnelChainConfig(7, 1, []tunnelRuntimeNode{{NodeID: 2, Protocol: "mtls", Port: 9001}}, nodes, "")
+	if fields := changedConfigFields(driftKindChain, want, normalizeConfigMap(moved)); !reflect.DeepEqual(fields, []string{"hops"}) {
+		t.Fatalf("expected the hops to differ, got %v", fields)
+	}
+
+	limiter := map[string]interface{}{"name": "3", "limits": []interface{}{"$ 12.5MB 12.5MB"}}
+	stale := map[string]interface{}{"name": "3", "limits": []interface{}{"$ 1.0MB 1.0MB"}}
+	if fields := changedConfigFields(driftKindLimiter, limiter, stale); !reflect.DeepEqual(fields, []string{"limits"}) {
+		t.Fatalf("expected the limits to differ, got %v", fields)
+	}
+
+	service := map[string]interface{}{"name": "1_2_3_tcp", "addr": "[::]:1000", "handler": map[string]interface{}{"type": "tcp"}}
+	if fields := changedConfigFields(driftKindService, service, map[string]interface{}{"name": "1_2_3_tcp"}); !reflect.DeepEqual(fields, []string{"addr", "handler"}) {
+		t.Fatalf("expected addr and handler to differ, got %v", fields)
+	}
+}
+
+func TestChangedConfigFieldsComparesAdmissionsAndProtocolRules(t *testing.T) {
+	admissions, _, err := buildForwardAdmissionConfigs("1_2_0", nil)
+	if err != nil {
+		t.Fatalf("build admissions: %v", err)
+	}
+	allow, deny := normalizeConfigMap(admissions[0]), normalizeConfigMap(admissions[1])
+	// The agent omits false and empty values from its report.
+	reportedAllow := map[string]interface{}{"name": "1_2_0_allow", "whitelist": true, "matchers": allow["matchers"]}
+	if fields := changedConfigFields(driftKindAdmission, allow, reportedAllow); len(fields) != 0 {
+		t.Fatalf("expected an unchanged allow admission, got %v", fields)
+	}
+	if fields := changedConfigFields(driftKindAdmission, deny, map[string]interface{}{"name": "1_2_0_deny"}); len(fields) != 0 {
+		t.Fatalf("expected an unchanged deny admission, got %v", fields)
+	}
+	stale := map[string]interface{}{"name": "1_2_0_deny", "whitelist": true, "matchers": []interface{}{"203.0.113.0/24"}}
+	if fields := changedConfigFields(driftKindAdmission, deny, stale); !reflect.DeepEqual(fields, []string{"whitelist", "matchers"}) {
+		t.Fatalf("expected whitelist and matchers to differ, got %v", fields)
+	}
+	geo, _, _ := buildForwardGeoAdmission("1_2_0", []model.GeoACL{{DenyCountries: "CN"}})
+	if fields := changedConfigFields(driftKindAdmission, normalizeConfigMap(geo), map[string]interface{}{"name": "1_2_0_geo"}); !reflect.DeepEqual(fields, []string{"geoip"}) {
+		t.Fatalf("expected the countries to differ, got %v", fields)
+	}
+
+	services := []map[string]interface{}{{"name": "1_2_0_tcp", "addr": "[::]:1000"}}
+	if err := applyForwardProtocolRules(services, []model.ProtocolACL{{DenyProtocols: "bittorrent"}}); err != nil {
+		t.Fatalf("apply protocol rules: %v", err)
+	}
+	want := normalizeConfigMap(services[0])
+	if fields := changedConfigFields(driftKindService, want, map[string]interface{}{"name": "1_2_0_tcp", "addr": "[::]:1000"}); !reflect.DeepEqual(fields, []string{"protocol"}) {
+		t.Fatalf("expected the protocol rules to differ, got %v", fields)
+	}
+}
+
+func TestDiffNodeConfigOptionalAdmissions(t *testing.T) {
+	h := &Handler{}
+	forward := &forwardRecord{ID: 1, TunnelID: 2, Status: 1}
+	service := map[string]interface{}{"name": "1_2_0_tcp", "addr": "[::]:1000", "admissions": []string{"1_2_0_allow", "1_2_0_deny", "1_2_0_geo"}}
+	admission := map[string]interface{}{"name": "1_2_0_deny", "matchers": []string{}}
+	desired := func(optional bool) *desiredNodeConfig {
+		d := &desiredNodeConfig{items: map[string]*desiredConfigItem{}}
+		d.add(&desiredConfigItem{kind: driftKindService, name: "1_2_0_tcp", config: service, forward: forward, optional: optional})
+		d.add(&desiredConfigItem{kind: driftKindAdmission, name: "1_2_0_deny", config: admission, forward: forward, optional: optional})
+		return d
+	}
+	// An agent without admission support reports neither the admissions
+	// nor the references.
+	reported := reportedGostConfig{Services: []map[string]interface{}{{"name": "1_2_0_tcp", "addr": "[::]:1000"}}}
+
+	if items := h.diffNodeConfig(desired(true), reported); len(items) != 0 {
+		t.Fatalf("expected no drift while no rule applies, got %+v", items)
+	}
+	items := h.diffNodeConfig(desired(false), reported)
+	if len(items) != 2 {
+		t.Fatalf("expected the admission and the service to drift, got %+v", items)
+	}
+	if items[0].Kind != driftKindAdmission || items[0].Status != driftMissing {
+		t.Fatalf("expected the admission to be missing, got %+v", items[0])
+	}
+	if items[1].Kind != driftKindService || items[1].Status != driftChanged || !reflect.DeepEqual(items[1].Fields, []string{"admissions"}) {
+		t.Fatalf("expected the service references to drift, got %+v", items[1])
+	}
+
+	// References to optional admissions still have to be right when present.
+	reported.Services[0]["admissions"] = []interface{}{"1_2_0_allow"}
+	if items := h.diffNodeConfig(desired(true), reported); len(items) != 1 || !reflect.DeepEqual(items[0].Fields, []string{"admissions"}) {
+		t.Fatalf("expected stale references to drift, got %+v", items)
+	}
+}
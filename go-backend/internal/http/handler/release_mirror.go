@@ -0,0 +1,475 @@
+package handler
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/store/model"
+)
+
+// The panel can host agent binaries itself for nodes that cannot reach
+// GitHub. Admins upload one binary per version and architecture together
+// with its detached signature; the panel computes the checksum and serves
+// all three to nodes under /flow/release, authenticated like the other node
+// endpoints.
+
+const (
+	releaseSourceGitHub = "github"
+	releaseSourceLocal  = "local"
+
+	maxReleaseUploadSize = 256 << 20
+	// releaseTransferTimeout replaces the server's 30s read/write timeouts
+	// for binary uploads and downloads, which can take minutes on slow links.
+	releaseTransferTimeout = 30 * time.Minute
+)
+
+var (
+	releaseVersionPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+	releaseArchPattern    = regexp.MustCompile(`^[a-z0-9_]{2,32}$`)
+)
+
+// SetReleaseDir sets where uploaded agent binaries are stored.
+func (h *Handler) SetReleaseDir(dir string) {
+	h.releaseDir = strings.TrimSpace(dir)
+}
+
+func (h *Handler) releaseFilePath(version, arch string) string {
+	return filepath.Join(h.releaseDir, version, "gost-"+arch)
+}
+
+func normalizeReleaseSource(source string) string {
+	switch strings.ToLower(strings.TrimSpace(source)) {
+	case releaseSourceGitHub:
+		return releaseSourceGitHub
+	case releaseSourceLocal:
+		return releaseSourceLocal
+	default:
+		return ""
+	}
+}
+
+// localReleaseVersions groups the hosted binaries by version, newest upload
+// first.
+func (h *Handler) localReleaseVersions() ([]string, map[string][]model.AgentRelease, error) {
+	releases, err := h.repo.ListAgentReleases()
+	if err != nil {
+		return nil, nil, err
+	}
+	byVersion := make(map[string][]model.AgentRelease)
+	versions := make([]string, 0)
+	for _, rel := range releases {
+		if _, ok := byVersion[rel.Version]; !ok {
+			versions = append(versions, rel.Version)
+		}
+		byVersion[rel.Version] = append(byVersion[rel.Version], rel)
+	}
+	return versions, byVersion, nil
+}
+
+// resolveUpgradeRelease picks the version to install and whether it comes
+// from the panel. Without an explicit source a version hosted by the panel
+// is preferred, and the newest local version is used when GitHub cannot be
+// reached.
+func (h *Handler) resolveUpgradeRelease(source, channel, version string) (string, bool, error) {
+	source = normalizeReleaseSource(source)
+	if source == releaseSourceGitHub {
+		if version != "" {
+			return version, false, nil
+		}
+		latest, err := resolveLatestReleaseByChannel(channel)
+		return latest, false, err
+	}
+
+	versions, _, err := h.localReleaseVersions()
+	if err != nil {
+		return "", false, err
+	}
+	latestLocal := ""
+	for _, v := range versions {
+		if version != "" && v == version {
+			return version, true, nil
+		}
+		if latestLocal == "" && releaseChannelFromTag(v) == channel {
+			latestLocal = v
+		}
+	}
+	if source == releaseSourceLocal {
+		if version != "" {
+			return "", false, fmt.Errorf("面板未托管版本 %s", version)
+		}
+		if latestLocal == "" {
+			return "", false, fmt.Errorf("面板未托管%s", releaseChannelLabel(channel))
+		}
+		return latestLocal, true, nil
+	}
+
+	if version != "" {
+		return version, false, nil
+	}
+	latest, err := resolveLatestReleaseByChannel(channel)
+	if err != nil {
+		if latestLocal != "" {
+			return latestLocal, true, nil
+		}
+		return "", false, err
+	}
+	return latest, false, nil
+}
+
+// nodeHostsRelease reports whether the panel hosts version for the node's
+// architecture and returns the node. Nodes that do not report their
+// architecture are assumed to match.
+func (h *Handler) nodeHostsRelease(nodeID int64, version string) (*model.Node, bool, error) {
+	node, err := h.repo.GetNodeByID(nodeID)
+	if err != nil || node == nil || node.Arch == "" {
+		return node, true, err
+	}
+	rel, err := h.repo.GetAgentRelease(version, node.Arch)
+	if err != nil {
+		return node, false, err
+	}
+	return node, rel != nil, nil
+}
+
+// checkHostedRelease refuses a panel-only upgrade when a node's architecture
+// has no hosted binary of version.
+func (h *Handler) checkHostedRelease(source, version string, nodeIDs []int64) error {
+	if normalizeReleaseSource(source) != releaseSourceLocal {
+		return nil
+	}
+	for _, nodeID := range nodeIDs {
+		node, hosted, err := h.nodeHostsRelease(nodeID, version)
+		if err != nil {
+			return err
+		}
+		if !hosted {
+			return fmt.Errorf("面板未托管节点 %s 的 %s 架构版本 %s", node.Name, node.Arch, version)
+		}
+	}
+	return nil
+}
+
+// nodeUpgradeCommandData returns the UpgradeAgent payload for one node. A
+// version hosted by the panel without a binary for the node's architecture
+// is fetched from GitHub instead.
+func (h *Handler) nodeUpgradeCommandData(nodeID int64, version string, local bool) map[string]interface{} {
+	if local {
+		if _, hosted, err := h.nodeHostsRelease(nodeID, version); err == nil && !hosted {
+			local = false
+		}
+	}
+	return upgradeCommandData(version, local)
+}
+
+// upgradeCommandData is the UpgradeAgent payload. Panel-hosted URLs are
+// relative; the agent resolves them against its panel address and adds its
+// secret.
+func upgradeCommandData(version string, local bool) map[string]interface{} {
+	data := map[string]interface{}{
+		"version":        version,
+		"healthDeadline": int(upgradeHealthDeadline / time.Second),
+	}
+	if local {
+		query := "?version=" + url.QueryEscape(version) + "&arch={ARCH}"
+		data["downloadUrl"] = "/flow/release/download" + query
+		data["checksumUrl"] = "/flow/release/checksum" + query
+		data["signatureUrl"] = "/flow/release/signature" + query
+		return data
+	}
+	base := fmt.Sprintf(githubProxy+"/%s/%s/releases/download/%s/gost-{ARCH}", githubHTMLBase, githubRepo, version)
+	data["downloadUrl"] = base
+	data["checksumUrl"] = base + ".sha256"
+	data["signatureUrl"] = base + ".sig"
+	return data
+}
+
+// releaseUpload stores an agent binary from a multipart form with the
+// fields version, arch, signature and file. The signature is required since
+// agents refuse unsigned upgrades.
+func (h *Handler) releaseUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	if h.releaseDir == "" {
+		response.WriteJSON(w, response.ErrDefault("未配置发布目录"))
+		return
+	}
+	extendReleaseTransferDeadline(w)
+	r.Body = http.MaxBytesReader(w, r.Body, maxReleaseUploadSize+1<<20)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault("请使用 multipart/form-data 上传"))
+		return
+	}
+
+	fields := map[string]string{}
+	var (
+		tmpPath string
+		size    int64
+		sum     string
+	)
+	defer func() {
+		if tmpPath != "" {
+			_ = os.Remove(tmpPath)
+		}
+	}()
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			response.WriteJSON(w, response.ErrDefault("上传数据读取失败"))
+			return
+		}
+		if part.FormName() != "file" {
+			value, _ := io.ReadAll(io.LimitReader(part, 4096))
+			fields[part.FormName()] = strings.TrimSpace(string(value))
+			continue
+		}
+		if tmpPath != "" {
+			response.WriteJSON(w, response.ErrDefault("只能上传一个文件"))
+			return
+		}
+		tmpPath, size, sum, err = h.spoolReleaseFile(part)
+		if err != nil {
+			response.WriteJSON(w, response.ErrDefault(err.Error()))
+			return
+		}
+	}
+
+	version, arch := fields["version"], strings.ToLower(fields["arch"])
+	if !releaseVersionPattern.MatchString(version) {
+		response.WriteJSON(w, response.ErrDefault("版本号格式错误"))
+		return
+	}
+	if !releaseArchPattern.MatchString(arch) {
+		response.WriteJSON(w, response.ErrDefault("架构格式错误"))
+		return
+	}
+	if tmpPath == "" || size == 0 {
+		response.WriteJSON(w, response.ErrDefault("升级包不能为空"))
+		return
+	}
+	// Agents refuse binaries without a valid signature, so require one here.
+	signature := fields["signature"]
+	raw, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || len(raw) != ed25519.SignatureSize {
+		response.WriteJSON(w, response.ErrDefault("签名必须是 base64 编码的 Ed25519 签名"))
+		return
+	}
+	if sha := strings.ToLower(fields["sha256"]); sha != "" && sha != sum {
+		response.WriteJSON(w, response.ErrDefault(fmt.Sprintf("校验和不匹配: 上传文件为 %s", sum)))
+		return
+	}
+
+	dest := h.releaseFilePath(version, arch)
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if err := os.Rename(tmpPath, dest); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	tmpPath = ""
+	release := &model.AgentRelease{
+		Version:     version,
+		Arch:        arch,
+		FileName:    filepath.Base(dest),
+		Size:        size,
+		SHA256:      sum,
+		Signature:   signature,
+		CreatedTime: time.Now().UnixMilli(),
+	}
+	if err := h.repo.SaveAgentRelease(release); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(agentReleaseView(*release)))
+}
+
+// spoolReleaseFile writes an uploaded binary to a temporary file in the
+// release directory and returns its size and SHA-256.
+func (h *Handler) spoolReleaseFile(src io.Reader) (string, int64, string, error) {
+	if err := os.MkdirAll(h.releaseDir, 0o755); err != nil {
+		return "", 0, "", err
+	}
+	tmp, err := os.CreateTemp(h.releaseDir, ".upload-*")
+	if err != nil {
+		return "", 0, "", err
+	}
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), io.LimitReader(src, maxReleaseUploadSize+1))
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil && size > maxReleaseUploadSize {
+		err = errors.New("升级包过大")
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", 0, "", err
+	}
+	return tmp.Name(), size, hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+func agentReleaseView(rel model.AgentRelease) map[string]interface{} {
+	return map[string]interface{}{
+		"id":          rel.ID,
+		"version":     rel.Version,
+		"arch":        rel.Arch,
+		"size":        rel.Size,
+		"sha256":      rel.SHA256,
+		"signed":      rel.Signature != "",
+		"channel":     releaseChannelFromTag(rel.Version),
+		"createdTime": rel.CreatedTime,
+	}
+}
+
+// releaseArtifactList lists every hosted binary.
+func (h *Handler) releaseArtifactList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	releases, err := h.repo.ListAgentReleases()
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	items := make([]map[string]interface{}, 0, len(releases))
+	for _, rel := range releases {
+		items = append(items, agentReleaseView(rel))
+	}
+	response.WriteJSON(w, response.OK(items))
+}
+
+func (h *Handler) releaseArtifactDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	id := idFromBody(r, w)
+	if id <= 0 {
+		return
+	}
+	rel, err := h.repo.DeleteAgentRelease(id)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if rel == nil {
+		response.WriteJSON(w, response.ErrDefault("升级包不存在"))
+		return
+	}
+	if h.releaseDir != "" {
+		_ = os.Remove(h.releaseFilePath(rel.Version, rel.Arch))
+		_ = os.Remove(filepath.Dir(h.releaseFilePath(rel.Version, rel.Arch)))
+	}
+	response.WriteJSON(w, response.OKEmpty())
+}
+
+// localReleaseItems are the list entries of hosted versions in a channel.
+func (h *Handler) localReleaseItems(channel string) ([]releaseItem, error) {
+	versions, byVersion, err := h.localReleaseVersions()
+	if err != nil {
+		return nil, err
+	}
+	items := make([]releaseItem, 0, len(versions))
+	for _, v := range versions {
+		itemChannel := releaseChannelFromTag(v)
+		if itemChannel != channel {
+			continue
+		}
+		rels := byVersion[v]
+		arches := make([]string, 0, len(rels))
+		published := rels[0].CreatedTime
+		for _, rel := range rels {
+			arches = append(arches, rel.Arch)
+		}
+		sort.Strings(arches)
+		items = append(items, releaseItem{
+			Version:     v,
+			Name:        v,
+			PublishedAt: time.UnixMilli(published).UTC().Format(time.RFC3339),
+			Prerelease:  itemChannel == releaseChannelDev,
+			Channel:     itemChannel,
+			Source:      releaseSourceLocal,
+			Arches:      arches,
+		})
+	}
+	return items, nil
+}
+
+func extendReleaseTransferDeadline(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	deadline := time.Now().Add(releaseTransferTimeout)
+	_ = rc.SetReadDeadline(deadline)
+	_ = rc.SetWriteDeadline(deadline)
+}
+
+// flowReleaseFile serves a hosted binary, its checksum or its signature to
+// an authenticated node.
+func (h *Handler) flowReleaseFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	node, err := h.repo.GetNodeBySecret(query.Get("secret"))
+	if err != nil || node == nil || !h.authorizeNodeTransport(r, node.ID) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	rel, err := h.repo.GetAgentRelease(query.Get("version"), strings.ToLower(query.Get("arch")))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if rel == nil || h.releaseDir == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch strings.TrimPrefix(r.URL.Path, "/flow/release/") {
+	case "checksum":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = fmt.Fprintf(w, "%s  %s\n", rel.SHA256, rel.FileName)
+	case "signature":
+		if rel.Signature == "" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = io.WriteString(w, rel.Signature+"\n")
+	case "download":
+		f, err := os.Open(h.releaseFilePath(rel.Version, rel.Arch))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+		extendReleaseTransferDeadline(w)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, r, rel.FileName, time.UnixMilli(rel.CreatedTime), f)
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go-backend/internal/store/model"
+	"go-backend/internal/store/repo"
+)
+
+func TestUpgradeFallsBackForUnhostedArch(t *testing.T) {
+	r, err := repo.Open(filepath.Join(t.TempDir(), "release.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = r.Close() })
+	h := &Handler{repo: r}
+
+	if err := r.SaveAgentRelease(&model.AgentRelease{Version: "2.1.0", Arch: "amd64", FileName: "gost-amd64", SHA256: "x", CreatedTime: 1}); err != nil {
+		t.Fatalf("save release: %v", err)
+	}
+	nodeIDs := map[string]int64{}
+	for _, arch := range []string{"amd64", "arm64", ""} {
+		name := "node-" + arch
+		if err := r.DB().Exec(`INSERT INTO node(name, secret, server_ip, port, created_time, status, arch) VALUES(?, ?, '1.1.1.1', '1000-2000', 1, 1, ?)`, name, name, arch).Error; err != nil {
+			t.Fatalf("insert node: %v", err)
+		}
+		nodeIDs[arch] = mustLastInsertID(t, r, name)
+	}
+
+	for arch, wantLocal := range map[string]bool{"amd64": true, "arm64": false, "": true} {
+		data := h.nodeUpgradeCommandData(nodeIDs[arch], "2.1.0", true)
+		url, _ := data["downloadUrl"].(string)
+		if local := strings.HasPrefix(url, "/flow/release/"); local != wantLocal {
+			t.Fatalf("node %q: expected local=%v, got %q", arch, wantLocal, url)
+		}
+	}
+
+	if err := h.checkHostedRelease(releaseSourceLocal, "2.1.0", []int64{nodeIDs["amd64"], nodeIDs[""]}); err != nil {
+		t.Fatalf("expected hosted architectures to pass, got %v", err)
+	}
+	if err := h.checkHostedRelease(releaseSourceLocal, "2.1.0", []int64{nodeIDs["amd64"], nodeIDs["arm64"]}); err == nil || !strings.Contains(err.Error(), "arm64") {
+		t.Fatalf("expected the panel-only upgrade to name the missing arch, got %v", err)
+	}
+	if err := h.checkHostedRelease("", "2.1.0", []int64{nodeIDs["arm64"]}); err != nil {
+		t.Fatalf("expected the default source to fall back instead, got %v", err)
+	}
+}
@@ -0,0 +1,498 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/store/model"
+)
+
+// Every forward service references the same two admissions on its entry
+// node: "<base>_allow" (whitelist) and "<base>_deny" (blacklist). The gost
+// registry resolves admissions by name on each accepted connection, so
+// pushing new matchers under these names takes effect without restarting
+// the listener.
+const (
+	admissionAllowSuffix = "_allow"
+	admissionDenySuffix  = "_deny"
+
+	maxSourceCIDREntries = 1024
+)
+
+var allowAllPrefixes = []string{"0.0.0.0/0", "::/0"}
+
+func forwardAdmissionNames(baseName string) []string {
+	return []string{baseName + admissionAllowSuffix, baseName + admissionDenySuffix, baseName + admissionGeoSuffix}
+}
+
+// parseSourceCIDRs parses a newline/comma/space separated list of CIDRs or
+// bare IPs. Bare IPs are widened to single-host prefixes.
+func parseSourceCIDRs(raw string) ([]netip.Prefix, error) {
+	fields := strings.FieldsFunc(raw, func(r rune) bool {
+		return r == ',' || r == ';' || r == '\n' || r == '\r' || r == ' ' || r == '\t'
+	})
+	if len(fields) > maxSourceCIDREntries {
+		return nil, fmt.Errorf("IP 列表最多 %d 条", maxSourceCIDREntries)
+	}
+	seen := make(map[netip.Prefix]struct{}, len(fields))
+	out := make([]netip.Prefix, 0, len(fields))
+	for _, field := range fields {
+		var prefix netip.Prefix
+		if strings.Contains(field, "/") {
+			p, err := netip.ParsePrefix(field)
+			if err != nil {
+				return nil, fmt.Errorf("无效的 CIDR: %s", field)
+			}
+			prefix = p
+		} else {
+			addr, err := netip.ParseAddr(field)
+			if err != nil {
+				return nil, fmt.Errorf("无效的 IP: %s", field)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefix = prefix.Masked()
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96).Masked()
+		}
+		if _, ok := seen[prefix]; ok {
+			continue
+		}
+		seen[prefix] = struct{}{}
+		out = append(out, prefix)
+	}
+	sortPrefixes(out)
+	return out, nil
+}
+
+// normalizeSourceCIDRs validates raw input and returns the canonical
+// newline separated form stored in the database.
+func normalizeSourceCIDRs(raw string) (string, error) {
+	prefixes, err := parseSourceCIDRs(raw)
+	if err != nil {
+		return "", err
+	}
+	return joinPrefixes(prefixes, "\n"), nil
+}
+
+// sourceCIDRInput accepts either a string or a JSON array of strings.
+func sourceCIDRInput(v interface{}) string {
+	if arr, ok := v.([]interface{}); ok {
+		parts := make([]string, 0, len(arr))
+		for _, item := range arr {
+			parts = append(parts, asString(item))
+		}
+		return strings.Join(parts, "\n")
+	}
+	return asString(v)
+}
+
+func sortPrefixes(prefixes []netip.Prefix) {
+	sort.Slice(prefixes, func(i, j int) bool {
+		if c := prefixes[i].Addr().Compare(prefixes[j].Addr()); c != 0 {
+			return c < 0
+		}
+		return prefixes[i].Bits() < prefixes[j].Bits()
+	})
+}
+
+func joinPrefixes(prefixes []netip.Prefix, sep string) string {
+	parts := make([]string, 0, len(prefixes))
+	for _, p := range prefixes {
+		parts = append(parts, p.String())
+	}
+	return strings.Join(parts, sep)
+}
+
+// intersectPrefixes returns the address set covered by both a and b. Two
+// prefixes either nest or are disjoint, so each overlapping pair contributes
+// its narrower member.
+func intersectPrefixes(a, b []netip.Prefix) []netip.Prefix {
+	seen := make(map[netip.Prefix]struct{})
+	out := make([]netip.Prefix, 0)
+	for _, pa := range a {
+		for _, pb := range b {
+			if !pa.Overlaps(pb) {
+				continue
+			}
+			narrow := pa
+			if pb.Bits() > pa.Bits() {
+				narrow = pb
+			}
+			if _, ok := seen[narrow]; ok {
+				continue
+			}
+			seen[narrow] = struct{}{}
+			out = append(out, narrow)
+		}
+	}
+	sortPrefixes(out)
+	return out
+}
+
+// compileSourceACL merges the lists of every scope into the effective
+// allow and deny sets: an address must be allowed by every scope that has
+// an allow list, and must not be denied by any scope. restricted reports
+// whether any scope has an allow list, in which case an empty allow set
+// admits nobody.
+func compileSourceACL(scopes []model.SourceACL) (allow []netip.Prefix, deny []netip.Prefix, restricted bool, err error) {
+	seenDeny := make(map[netip.Prefix]struct{})
+	for _, scope := range scopes {
+		allowList, err := parseSourceCIDRs(scope.AllowCIDRs)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		denyList, err := parseSourceCIDRs(scope.DenyCIDRs)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		if len(allowList) > 0 {
+			if !restricted {
+				allow = allowList
+				restricted = true
+			} else {
+				allow = intersectPrefixes(allow, allowList)
+			}
+		}
+		for _, p := range denyList {
+			if _, ok := seenDeny[p]; ok {
+				continue
+			}
+			seenDeny[p] = struct{}{}
+			deny = append(deny, p)
+		}
+	}
+	sortPrefixes(deny)
+	return allow, deny, restricted, nil
+}
+
+// buildForwardAdmissionConfigs renders the allow/deny admission pair for a
+// forward service base name. hasRules reports whether the pair filters
+// anything at all.
+func buildForwardAdmissionConfigs(baseName string, scopes []model.SourceACL) (admissions []map[string]interface{}, hasRules bool, err error) {
+	allow, deny, restricted, err := compileSourceACL(scopes)
+	if err != nil {
+		return nil, false, err
+	}
+
+	allowMatchers := make([]string, 0, len(allow))
+	if restricted {
+		for _, p := range allow {
+			allowMatchers = append(allowMatchers, p.String())
+		}
+	} else {
+		allowMatchers = append(allowMatchers, allowAllPrefixes...)
+	}
+	denyMatchers := make([]string, 0, len(deny))
+	for _, p := range deny {
+		denyMatchers = append(denyMatchers, p.String())
+	}
+
+	names := forwardAdmissionNames(baseName)
+	return []map[string]interface{}{
+		{
+			"name":      names[0],
+			"whitelist": true,
+			"matchers":  allowMatchers,
+		},
+		{
+			"name":     names[1],
+			"matchers": denyMatchers,
+		},
+	}, restricted || len(deny) > 0, nil
+}
+
+func (h *Handler) forwardSourceACLScopes(forward *forwardRecord, nodeID int64) ([]model.SourceACL, error) {
+	scopes := make([]model.SourceACL, 0, 3)
+	nodeACL, err := h.repo.GetNodeSourceACL(nodeID)
+	if err != nil {
+		return nil, err
+	}
+	if nodeACL != nil {
+		scopes = append(scopes, *nodeACL)
+	}
+	utACL, err := h.repo.GetUserTunnelSourceACL(forward.UserID, forward.TunnelID)
+	if err != nil {
+		return nil, err
+	}
+	if utACL != nil {
+		scopes = append(scopes, *utACL)
+	}
+	fwdACL, err := h.repo.GetForwardSourceACL(forward.ID)
+	if err != nil {
+		return nil, err
+	}
+	if fwdACL != nil {
+		scopes = append(scopes, *fwdACL)
+	}
+	return scopes, nil
+}
+
+// forwardAdmissions renders the source and country admissions of a forward
+// on one node. hasRules reports whether any of them filters anything.
+func (h *Handler) forwardAdmissions(forward *forwardRecord, nodeID int64, baseName string) ([]map[string]interface{}, bool, error) {
+	scopes, err := h.forwardSourceACLScopes(forward, nodeID)
+	if err != nil {
+		return nil, false, err
+	}
+	admissions, hasRules, err := buildForwardAdmissionConfigs(baseName, scopes)
+	if err != nil {
+		return nil, false, err
+	}
+	geoScopes, err := h.forwardGeoACLScopes(forward)
+	if err != nil {
+		return nil, false, err
+	}
+	geoAdmission, hasGeoRules, err := buildForwardGeoAdmission(baseName, geoScopes)
+	if err != nil {
+		return nil, false, err
+	}
+	return append(admissions, geoAdmission), hasRules || hasGeoRules, nil
+}
+
+// pushForwardAdmissions deploys the admissions of a forward to one
+// node and returns the names its services should reference. Agents that
+// predate admission commands get no references as long as no rule applies,
+// so existing forwards keep working until the node is upgraded.
+func (h *Handler) pushForwardAdmissions(forward *forwardRecord, node *nodeRecord, baseName string) ([]string, error) {
+	admissions, hasRules, err := h.forwardAdmissions(forward, node.ID, baseName)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := h.sendNodeCommand(node.ID, "UpdateAdmissions", admissions, false, false); err != nil {
+		if isUnsupportedCommandError(err) && !hasRules {
+			return nil, nil
+		}
+		if isUnsupportedCommandError(err) {
+			return nil, fmt.Errorf("节点 %s 版本过旧，不支持来源访问控制，请先升级节点", node.Name)
+		}
+		return nil, err
+	}
+	return forwardAdmissionNames(baseName), nil
+}
+
+func isUnsupportedCommandError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "未知命令类型") || strings.Contains(msg, "command not allowed")
+}
+
+// deleteForwardAdmissions removes the admissions of a forward from its
+// entry nodes. It is best effort: leftovers are inert once the services
+// referencing them are gone.
+func (h *Handler) deleteForwardAdmissions(forward *forwardRecord) {
+	if h == nil || forward == nil {
+		return
+	}
+	ports, err := h.listForwardPorts(forward.ID)
+	if err != nil {
+		return
+	}
+	userTunnelID, _, _, err := h.resolveUserTunnelAndLimiter(forward.UserID, forward.TunnelID)
+	if err != nil {
+		return
+	}
+	payload := map[string]interface{}{
+		"admissions": forwardAdmissionNames(buildForwardServiceBase(forward.ID, forward.UserID, userTunnelID)),
+	}
+	seen := make(map[int64]struct{}, len(ports))
+	for _, fp := range ports {
+		if _, ok := seen[fp.NodeID]; ok {
+			continue
+		}
+		seen[fp.NodeID] = struct{}{}
+		_, _ = h.sendNodeCommand(fp.NodeID, "DeleteAdmissions", payload, false, true)
+	}
+}
+
+func (h *Handler) forwardACL(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req map[string]interface{}
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	id := asInt64(req["id"], 0)
+	if id <= 0 {
+		response.WriteJSON(w, response.ErrDefault("转发ID不能为空"))
+		return
+	}
+	forward, _, _, err := h.resolveForwardAccess(r, id)
+	if err != nil {
+		if errors.Is(err, errForwardNotFound) {
+			response.WriteJSON(w, response.ErrDefault("转发不存在"))
+			return
+		}
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	allow, deny, ok := normalizeSourceACLRequest(w, req)
+	if !ok {
+		return
+	}
+	old, err := h.repo.GetForwardSourceACL(id)
+	if err != nil || old == nil {
+		response.WriteJSON(w, response.ErrDefault("转发不存在"))
+		return
+	}
+
+	now := time.Now().UnixMilli()
+	if err := h.repo.UpdateForwardSourceACL(id, allow, deny, now); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	// Services created before source rules existed do not reference the
+	// admission yet, so the services are re-synced rather than only the
+	// admissions.
+	if err := h.syncForwardServices(forward, "UpdateService", true); err != nil {
+		_ = h.repo.UpdateForwardSourceACL(id, old.AllowCIDRs, old.DenyCIDRs, now)
+		_ = h.syncForwardServices(forward, "UpdateService", true)
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OKEmpty())
+}
+
+func (h *Handler) userTunnelACL(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req map[string]interface{}
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	id := asInt64(req["id"], 0)
+	if id <= 0 {
+		response.WriteJSON(w, response.ErrDefault("权限ID不能为空"))
+		return
+	}
+	userID, tunnelID, err := h.repo.GetUserTunnelUserAndTunnel(id)
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault("权限不存在"))
+		return
+	}
+	allow, deny, ok := normalizeSourceACLRequest(w, req)
+	if !ok {
+		return
+	}
+	if err := h.repo.UpdateUserTunnelSourceACL(id, allow, deny); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+
+	forwards, err := h.listForwardsByTunnel(tunnelID)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	failed := make([]string, 0)
+	for i := range forwards {
+		f := &forwards[i]
+		if f.UserID != userID {
+			continue
+		}
+		if err := h.syncForwardServices(f, "UpdateService", true); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", f.Name, err))
+		}
+	}
+	if len(failed) > 0 {
+		response.WriteJSON(w, response.ErrDefault("部分转发下发失败: "+strings.Join(failed, "; ")))
+		return
+	}
+	response.WriteJSON(w, response.OKEmpty())
+}
+
+func (h *Handler) nodeACL(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req map[string]interface{}
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	id := asInt64(req["id"], 0)
+	if id <= 0 {
+		response.WriteJSON(w, response.ErrDefault("节点ID不能为空"))
+		return
+	}
+	old, err := h.repo.GetNodeSourceACL(id)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if old == nil {
+		response.WriteJSON(w, response.ErrDefault("节点不存在"))
+		return
+	}
+	allow, deny, ok := normalizeSourceACLRequest(w, req)
+	if !ok {
+		return
+	}
+	if err := h.repo.UpdateNodeSourceACL(id, allow, deny, time.Now().UnixMilli()); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+
+	forwardIDs, err := h.repo.ListForwardIDsByNode(id)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	failed := make([]string, 0)
+	for _, forwardID := range forwardIDs {
+		forward, err := h.getForwardRecord(forwardID)
+		if err != nil || forward == nil {
+			continue
+		}
+		ports, err := h.listForwardPorts(forwardID)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", forward.Name, err))
+			continue
+		}
+		onNode := make([]forwardPortRecord, 0, 1)
+		for _, fp := range ports {
+			if fp.NodeID == id {
+				onNode = append(onNode, fp)
+			}
+		}
+		if err := h.syncForwardServicesOnPorts(forward, onNode, "UpdateService", true); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", forward.Name, err))
+		}
+	}
+	if len(failed) > 0 {
+		response.WriteJSON(w, response.ErrDefault("部分转发下发失败: "+strings.Join(failed, "; ")))
+		return
+	}
+	response.WriteJSON(w, response.OKEmpty())
+}
+
+// normalizeSourceACLRequest reads allowCidrs/denyCidrs from the request and
+// writes the validation error itself when the input is rejected.
+func normalizeSourceACLRequest(w http.ResponseWriter, req map[string]interface{}) (string, string, bool) {
+	allow, err := normalizeSourceCIDRs(sourceCIDRInput(req["allowCidrs"]))
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault("允许列表: "+err.Error()))
+		return "", "", false
+	}
+	deny, err := normalizeSourceCIDRs(sourceCIDRInput(req["denyCidrs"]))
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault("拒绝列表: "+err.Error()))
+		return "", "", false
+	}
+	return allow, deny, true
+}
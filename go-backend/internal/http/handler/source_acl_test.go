@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"reflect"
+	"testing"
+
+	"go-backend/internal/store/model"
+)
+
+func TestNormalizeSourceCIDRs(t *testing.T) {
+	got, err := normalizeSourceCIDRs("10.0.0.7/8, 1.1.1.1\n2001:db8::1/32;10.0.0.0/8 ::ffff:192.0.2.9")
+	if err != nil {
+		t.Fatalf("normalize: %v", err)
+	}
+	want := "1.1.1.1/32\n10.0.0.0/8\n192.0.2.9/32\n2001:db8::/32"
+	if got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+
+	if _, err := normalizeSourceCIDRs("10.0.0.0/33"); err == nil {
+		t.Fatalf("expected invalid prefix to be rejected")
+	}
+	if _, err := normalizeSourceCIDRs("example.com"); err == nil {
+		t.Fatalf("expected hostname to be rejected")
+	}
+}
+
+func TestBuildForwardAdmissionConfigsWithoutRules(t *testing.T) {
+	admissions, hasRules, err := buildForwardAdmissionConfigs("1_2_3", []model.SourceACL{{}, {}})
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if hasRules {
+		t.Fatalf("expected no rules")
+	}
+	if got := admissions[0]["matchers"]; !reflect.DeepEqual(got, allowAllPrefixes) {
+		t.Fatalf("expected allow-all matchers, got %v", got)
+	}
+	if got := admissions[1]["matchers"].([]string); len(got) != 0 {
+		t.Fatalf("expected empty deny matchers, got %v", got)
+	}
+	if admissions[0]["name"] != "1_2_3_allow" || admissions[1]["name"] != "1_2_3_deny" {
+		t.Fatalf("unexpected admission names: %v, %v", admissions[0]["name"], admissions[1]["name"])
+	}
+}
+
+func TestBuildForwardAdmissionConfigsMergesScopes(t *testing.T) {
+	scopes := []model.SourceACL{
+		{AllowCIDRs: "10.0.0.0/8\n192.168.0.0/16", DenyCIDRs: "10.9.9.9"},
+		{AllowCIDRs: "10.1.0.0/16\n172.16.0.0/12"},
+		{DenyCIDRs: "10.1.2.0/24\n10.9.9.9/32"},
+	}
+	admissions, hasRules, err := buildForwardAdmissionConfigs("1_2_3", scopes)
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if !hasRules {
+		t.Fatalf("expected rules")
+	}
+	if got, want := admissions[0]["matchers"], []string{"10.1.0.0/16"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("allow: expected %v, got %v", want, got)
+	}
+	if got, want := admissions[1]["matchers"], []string{"10.1.2.0/24", "10.9.9.9/32"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("deny: expected %v, got %v", want, got)
+	}
+}
+
+func TestBuildForwardAdmissionConfigsDisjointAllowListsAdmitNobody(t *testing.T) {
+	scopes := []model.SourceACL{
+		{AllowCIDRs: "10.0.0.0/8"},
+		{AllowCIDRs: "192.168.0.0/16"},
+	}
+	admissions, hasRules, err := buildForwardAdmissionConfigs("1_2_3", scopes)
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if !hasRules {
+		t.Fatalf("expected rules")
+	}
+	if got := admissions[0]["matchers"].([]string); len(got) != 0 {
+		t.Fatalf("expected empty whitelist, got %v", got)
+	}
+}
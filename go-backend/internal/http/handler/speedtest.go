@@ -0,0 +1,207 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-backend/internal/http/response"
+)
+
+// Speed tests start a temporary sink on the target node and let the source
+// node push traffic to it over the tunnel protocol, so the result reflects
+// what a chain hop can actually carry.
+const (
+	defaultSpeedTestDuration = 10
+	maxSpeedTestDuration     = 60
+	maxSpeedTestStreams      = 8
+	// speedTestCommandSlack covers dialing and handshakes on top of the
+	// transmit time when waiting for RunSpeedTest.
+	speedTestCommandSlack = 20 * time.Second
+	// speedTestResponseSlack covers starting and stopping the sink around
+	// the run when extending the response deadline.
+	speedTestResponseSlack = 30 * time.Second
+)
+
+// speedTestDrain is how long the panel waits after the sender finishes before
+// collecting the sink report, so in-flight data is still counted.
+var speedTestDrain = time.Second
+
+type speedTestRequest struct {
+	FromNodeID int64  `json:"fromNodeId"`
+	ToNodeID   int64  `json:"toNodeId"`
+	TunnelID   int64  `json:"tunnelId"`
+	Protocol   string `json:"protocol"`
+	Network    string `json:"network"`
+	Duration   int    `json:"duration"`
+	Streams    int    `json:"streams"`
+	Bandwidth  int    `json:"bandwidth"`
+}
+
+func (h *Handler) nodeSpeedTest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req speedTestRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	if req.FromNodeID <= 0 || req.ToNodeID <= 0 {
+		response.WriteJSON(w, response.ErrDefault("参数错误"))
+		return
+	}
+	if req.FromNodeID == req.ToNodeID {
+		response.WriteJSON(w, response.ErrDefault("测速的源节点和目标节点不能相同"))
+		return
+	}
+	req.Network = strings.ToLower(strings.TrimSpace(req.Network))
+	if req.Network == "" {
+		req.Network = "tcp"
+	}
+	if req.Network != "tcp" && req.Network != "udp" {
+		response.WriteJSON(w, response.ErrDefault("不支持的测速网络类型"))
+		return
+	}
+	if req.Duration <= 0 {
+		req.Duration = defaultSpeedTestDuration
+	}
+	if req.Duration > maxSpeedTestDuration {
+		req.Duration = maxSpeedTestDuration
+	}
+	if req.Streams <= 0 {
+		req.Streams = 1
+	}
+	if req.Streams > maxSpeedTestStreams {
+		req.Streams = maxSpeedTestStreams
+	}
+
+	// The test blocks for its whole duration, which can outlast the
+	// server's WriteTimeout.
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Now().Add(time.Duration(req.Duration)*time.Second + speedTestCommandSlack + speedTestResponseSlack))
+
+	result, err := h.runSpeedTest(req)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(result))
+}
+
+// speedTestProtocol returns the listener protocol and IP preference to test
+// with. For a tunnel hop they come from the tunnel's chain configuration.
+func (h *Handler) speedTestProtocol(req speedTestRequest) (string, string, error) {
+	if req.TunnelID <= 0 {
+		return defaultString(strings.TrimSpace(req.Protocol), "tls"), "", nil
+	}
+	rows, err := h.listChainNodesForTunnel(req.TunnelID)
+	if err != nil {
+		return "", "", err
+	}
+	for _, row := range rows {
+		if row.NodeID == req.ToNodeID && (row.ChainType == 2 || row.ChainType == 3) {
+			return defaultString(strings.TrimSpace(row.Protocol), "tls"), h.repo.GetTunnelIPPreference(req.TunnelID), nil
+		}
+	}
+	return "", "", errors.New("目标节点不是该隧道的转发或出口节点")
+}
+
+func (h *Handler) runSpeedTest(req speedTestRequest) (map[string]interface{}, error) {
+	from, err := h.getNodeRecord(req.FromNodeID)
+	if err != nil {
+		return nil, err
+	}
+	to, err := h.getNodeRecord(req.ToNodeID)
+	if err != nil {
+		return nil, err
+	}
+	if from.IsRemote == 1 || to.IsRemote == 1 {
+		return nil, errors.New("远程节点暂不支持测速")
+	}
+	protocol, ipPreference, err := h.speedTestProtocol(req)
+	if err != nil {
+		return nil, err
+	}
+	host, err := selectTunnelDialHost(from, to, ipPreference)
+	if err != nil {
+		return nil, err
+	}
+	port := h.pickNodePort(to.ID)
+	if port <= 0 {
+		return nil, errors.New("目标节点没有可用端口")
+	}
+
+	id := randomToken(8)
+	token := randomToken(16)
+	if _, err := h.sendNodeCommand(to.ID, "StartSpeedTestSink", map[string]interface{}{
+		"id":       id,
+		"token":    token,
+		"port":     port,
+		"protocol": protocol,
+		"network":  req.Network,
+		"duration": req.Duration,
+	}, false, false); err != nil {
+		return nil, fmt.Errorf("启动测速接收端失败: %v", err)
+	}
+
+	sent, sendErr := h.wsServer.SendCommand(from.ID, "RunSpeedTest", map[string]interface{}{
+		"id":        id,
+		"token":     token,
+		"addr":      net.JoinHostPort(host, strconv.Itoa(port)),
+		"protocol":  protocol,
+		"network":   req.Network,
+		"duration":  req.Duration,
+		"streams":   req.Streams,
+		"bandwidth": req.Bandwidth,
+		"interface": strings.TrimSpace(from.InterfaceName),
+	}, time.Duration(req.Duration)*time.Second+speedTestCommandSlack)
+	if sendErr == nil {
+		time.Sleep(speedTestDrain)
+	}
+	received, stopErr := h.sendNodeCommand(to.ID, "StopSpeedTestSink", map[string]interface{}{"id": id}, false, false)
+	if sendErr != nil {
+		return nil, fmt.Errorf("测速失败: %v", sendErr)
+	}
+	if stopErr != nil {
+		return nil, fmt.Errorf("获取测速接收端结果失败: %v", stopErr)
+	}
+	return speedTestResult(req, protocol, sent.Data, received.Data), nil
+}
+
+// speedTestResult merges the sender and sink reports. Throughput is what the
+// sink received; loss compares datagrams sent with datagrams received.
+func speedTestResult(req speedTestRequest, protocol string, sent, received map[string]interface{}) map[string]interface{} {
+	result := map[string]interface{}{
+		"fromNodeId":     req.FromNodeID,
+		"toNodeId":       req.ToNodeID,
+		"protocol":       protocol,
+		"network":        req.Network,
+		"duration":       req.Duration,
+		"throughputMbps": asFloat(received["throughputMbps"], 0),
+		"sender":         sent,
+		"receiver":       received,
+	}
+	if req.TunnelID > 0 {
+		result["tunnelId"] = req.TunnelID
+	}
+	if req.Network == "udp" {
+		sentPackets := asInt64(sent["packets"], 0)
+		receivedPackets := asInt64(received["packets"], 0)
+		loss := 0.0
+		if sentPackets > 0 && receivedPackets < sentPackets {
+			loss = float64(sentPackets-receivedPackets) / float64(sentPackets) * 100
+		}
+		result["packetLoss"] = loss
+		result["jitterMs"] = asFloat(received["jitterMs"], 0)
+	} else {
+		result["retransmits"] = asInt64(sent["retransmits"], 0)
+		result["retransmitRate"] = asFloat(sent["retransmitRate"], 0)
+	}
+	return result
+}
@@ -128,6 +128,7 @@ func (h *Handler) nodeUpgrade(w http.ResponseWriter, r *http.Request) {
 		ID      int64  `json:"id"`
 		Version string `json:"version"`
 		Channel string `json:"channel"`
+		Source  string `json:"source"`
 	}
 	if err := decodeJSON(r.Body, &req); err != nil {
 		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
@@ -139,34 +140,23 @@ func (h *Handler) nodeUpgrade(w http.ResponseWriter, r *http.Request) {
 	}
 
 	channel := normalizeReleaseChannel(req.Channel)
-	version := strings.TrimSpace(req.Version)
-	if version == "" {
-		var err error
-		version, err = resolveLatestReleaseByChannel(channel)
-		if err != nil {
-			response.WriteJSON(w, response.Err(-2, fmt.Sprintf("获取最新%s失败: %v", releaseChannelLabel(channel), err)))
-			return
-		}
+	version, local, err := h.resolveUpgradeRelease(req.Source, channel, strings.TrimSpace(req.Version))
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, fmt.Sprintf("获取最新%s失败: %v", releaseChannelLabel(channel), err)))
+		return
+	}
+	if err := h.checkHostedRelease(req.Source, version, []int64{req.ID}); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
 	}
 
-	downloadURL := fmt.Sprintf(
-		githubProxy+"/%s/%s/releases/download/%s/gost-{ARCH}",
-		githubHTMLBase, githubRepo, version,
-	)
-	checksumURL := fmt.Sprintf(
-		githubProxy+"/%s/%s/releases/download/%s/gost-{ARCH}.sha256",
-		githubHTMLBase, githubRepo, version,
-	)
-
-	result, err := h.wsServer.SendCommand(req.ID, "UpgradeAgent", map[string]interface{}{
-		"downloadUrl": downloadURL,
-		"checksumUrl": checksumURL,
-	}, upgradeTimeout)
+	result, err := h.wsServer.SendCommand(req.ID, "UpgradeAgent", h.nodeUpgradeCommandData(req.ID, version, local), upgradeTimeout)
 	if err != nil {
 		response.WriteJSON(w, response.Err(-2, fmt.Sprintf("升级失败: %v", err)))
 		return
 	}
 	h.markNodePendingUpgradeRedeploy(req.ID)
+	h.startUpgradeHealthCheck(req.ID, version)
 
 	response.WriteJSON(w, response.OK(map[string]interface{}{
 		"version": version,
@@ -192,6 +182,7 @@ func (h *Handler) nodeBatchUpgrade(w http.ResponseWriter, r *http.Request) {
 		IDs     []int64 `json:"ids"`
 		Version string  `json:"version"`
 		Channel string  `json:"channel"`
+		Source  string  `json:"source"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
@@ -203,24 +194,15 @@ func (h *Handler) nodeBatchUpgrade(w http.ResponseWriter, r *http.Request) {
 	}
 
 	channel := normalizeReleaseChannel(req.Channel)
-	version := strings.TrimSpace(req.Version)
-	if version == "" {
-		var err error
-		version, err = resolveLatestReleaseByChannel(channel)
-		if err != nil {
-			response.WriteJSON(w, response.Err(-2, fmt.Sprintf("获取最新%s失败: %v", releaseChannelLabel(channel), err)))
-			return
-		}
+	version, local, err := h.resolveUpgradeRelease(req.Source, channel, strings.TrimSpace(req.Version))
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, fmt.Sprintf("获取最新%s失败: %v", releaseChannelLabel(channel), err)))
+		return
+	}
+	if err := h.checkHostedRelease(req.Source, version, req.IDs); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
 	}
-
-	downloadURL := fmt.Sprintf(
-		githubProxy+"/%s/%s/releases/download/%s/gost-{ARCH}",
-		githubHTMLBase, githubRepo, version,
-	)
-	checksumURL := fmt.Sprintf(
-		githubProxy+"/%s/%s/releases/download/%s/gost-{ARCH}.sha256",
-		githubHTMLBase, githubRepo, version,
-	)
 
 	type upgradeResult struct {
 		ID      int64  `json:"id"`
@@ -239,15 +221,13 @@ func (h *Handler) nodeBatchUpgrade(w http.ResponseWriter, r *http.Request) {
 			sem <- struct{}{}
 			defer func() { <-sem }()
 
-			result, err := h.wsServer.SendCommand(nodeID, "UpgradeAgent", map[string]interface{}{
-				"downloadUrl": downloadURL,
-				"checksumUrl": checksumURL,
-			}, upgradeTimeout)
+			result, err := h.wsServer.SendCommand(nodeID, "UpgradeAgent", h.nodeUpgradeCommandData(nodeID, version, local), upgradeTimeout)
 			if err != nil {
 				results[index] = upgradeResult{ID: nodeID, Success: false, Message: err.Error()}
 				return
 			}
 			h.markNodePendingUpgradeRedeploy(nodeID)
+			h.startUpgradeHealthCheck(nodeID, version)
 			results[index] = upgradeResult{ID: nodeID, Success: true, Message: result.Message}
 		}(i, id)
 	}
@@ -267,6 +247,7 @@ func (h *Handler) listReleases(w http.ResponseWriter, r *http.Request) {
 
 	var req struct {
 		Channel string `json:"channel"`
+		Source  string `json:"source"`
 	}
 	if err := decodeJSON(r.Body, &req); err != nil && err != io.EOF {
 		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
@@ -274,28 +255,42 @@ func (h *Handler) listReleases(w http.ResponseWriter, r *http.Request) {
 	}
 
 	channel := normalizeReleaseChannel(req.Channel)
+	source := normalizeReleaseSource(req.Source)
+
+	items := make([]releaseItem, 0)
+	seen := make(map[string]bool)
+	if source != releaseSourceGitHub {
+		localItems, err := h.localReleaseItems(channel)
+		if err != nil {
+			response.WriteJSON(w, response.Err(-2, err.Error()))
+			return
+		}
+		for _, item := range localItems {
+			seen[item.Version] = true
+		}
+		items = append(items, localItems...)
+	}
+	if source == releaseSourceLocal {
+		response.WriteJSON(w, response.OK(items))
+		return
+	}
 
 	releases, err := fetchGitHubReleases(50)
 	if err != nil {
+		if len(items) > 0 {
+			response.WriteJSON(w, response.OK(items))
+			return
+		}
 		response.WriteJSON(w, response.Err(-2, fmt.Sprintf("获取版本列表失败: %v", err)))
 		return
 	}
 
-	type releaseItem struct {
-		Version     string `json:"version"`
-		Name        string `json:"name"`
-		PublishedAt string `json:"publishedAt"`
-		Prerelease  bool   `json:"prerelease"`
-		Channel     string `json:"channel"`
-	}
-
-	items := make([]releaseItem, 0, len(releases))
 	for _, r := range releases {
 		if r.Draft {
 			continue
 		}
 		tag := strings.TrimSpace(r.TagName)
-		if tag == "" {
+		if tag == "" || seen[tag] {
 			continue
 		}
 		itemChannel := releaseChannelFromTag(tag)
@@ -308,12 +303,25 @@ func (h *Handler) listReleases(w http.ResponseWriter, r *http.Request) {
 			PublishedAt: r.PublishedAt,
 			Prerelease:  itemChannel == releaseChannelDev,
 			Channel:     itemChannel,
+			Source:      releaseSourceGitHub,
 		})
 	}
 
 	response.WriteJSON(w, response.OK(items))
 }
 
+// releaseItem is one entry of the upgrade version list. Arches is only set
+// for versions hosted by the panel.
+type releaseItem struct {
+	Version     string   `json:"version"`
+	Name        string   `json:"name"`
+	PublishedAt string   `json:"publishedAt"`
+	Prerelease  bool     `json:"prerelease"`
+	Channel     string   `json:"channel"`
+	Source      string   `json:"source"`
+	Arches      []string `json:"arches,omitempty"`
+}
+
 func (h *Handler) nodeRollback(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		response.WriteJSON(w, response.ErrDefault("请求失败"))
@@ -366,6 +374,9 @@ func (h *Handler) consumeNodePendingUpgradeRedeploy(nodeID int64) bool {
 }
 
 func (h *Handler) onNodeOnline(nodeID int64) {
+	h.replayNodeOutbox(nodeID)
+	h.joinSelectorTunnels(nodeID)
+	h.checkUpgradeHealth(nodeID)
 	if !h.consumeNodePendingUpgradeRedeploy(nodeID) {
 		return
 	}
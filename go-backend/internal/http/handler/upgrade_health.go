@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"go-backend/internal/http/response"
+)
+
+// After an UpgradeAgent command succeeds the agent restarts into the new
+// binary. The new agent has upgradeHealthDeadline to reconnect and report
+// the target version in its handshake; otherwise its watchdog restores
+// flux_agent.old. The panel tracks the same deadline, plus a grace period
+// for the restore and reconnect, to report the outcome.
+
+const (
+	upgradeHealthDeadline = 2 * time.Minute
+	upgradeHealthGrace    = 30 * time.Second
+
+	upgradeHealthPending = "pending"
+	upgradeHealthHealthy = "healthy"
+	upgradeHealthFailed  = "failed"
+)
+
+type nodeUpgradeHealth struct {
+	NodeID      int64  `json:"nodeId"`
+	Version     string `json:"version"`
+	Previous    string `json:"previous"`
+	Status      string `json:"status"`
+	Message     string `json:"message"`
+	StartedTime int64  `json:"startedTime"`
+	Deadline    int64  `json:"deadline"`
+	UpdatedTime int64  `json:"updatedTime"`
+
+	timer *time.Timer
+}
+
+// startUpgradeHealthCheck records that nodeID is restarting into version and
+// arms the deadline.
+func (h *Handler) startUpgradeHealthCheck(nodeID int64, version string) {
+	if h == nil || nodeID <= 0 {
+		return
+	}
+	previous := ""
+	if node, err := h.repo.GetNodeByID(nodeID); err == nil && node != nil && node.Version.Valid {
+		previous = node.Version.String
+	}
+	now := time.Now()
+	state := &nodeUpgradeHealth{
+		NodeID:      nodeID,
+		Version:     version,
+		Previous:    previous,
+		Status:      upgradeHealthPending,
+		Message:     "等待新版本上报",
+		StartedTime: now.UnixMilli(),
+		Deadline:    now.Add(upgradeHealthDeadline).UnixMilli(),
+		UpdatedTime: now.UnixMilli(),
+	}
+	started := state.StartedTime
+	state.timer = time.AfterFunc(upgradeHealthDeadline+upgradeHealthGrace, func() {
+		h.expireUpgradeHealth(nodeID, started)
+	})
+
+	h.upgradeMu.Lock()
+	if old := h.upgradeHealth[nodeID]; old != nil && old.timer != nil {
+		old.timer.Stop()
+	}
+	h.upgradeHealth[nodeID] = state
+	h.upgradeMu.Unlock()
+}
+
+// checkUpgradeHealth runs when a node connects. A pending upgrade becomes
+// healthy once the node reports the target version. Any other version is
+// ignored: it is either the old agent before the restart or a restored
+// binary, which the deadline reports.
+func (h *Handler) checkUpgradeHealth(nodeID int64) {
+	node, err := h.repo.GetNodeByID(nodeID)
+	if err != nil || node == nil {
+		return
+	}
+	h.upgradeMu.Lock()
+	state := h.upgradeHealth[nodeID]
+	if state == nil || state.Status != upgradeHealthPending || trimVersion(node.Version.String) != trimVersion(state.Version) {
+		h.upgradeMu.Unlock()
+		return
+	}
+	if state.timer != nil {
+		state.timer.Stop()
+	}
+	state.Status = upgradeHealthHealthy
+	state.Message = fmt.Sprintf("已升级到 %s", node.Version.String)
+	state.UpdatedTime = time.Now().UnixMilli()
+	message := state.Message
+	h.upgradeMu.Unlock()
+
+	if h.wsServer != nil {
+		h.wsServer.BroadcastUpgradeProgress(nodeID, upgradeHealthHealthy, 100, true, message)
+	}
+}
+
+// expireUpgradeHealth marks an upgrade as failed when the node has not
+// reported the new version in time. started guards against a newer upgrade
+// of the same node.
+func (h *Handler) expireUpgradeHealth(nodeID int64, started int64) {
+	h.upgradeMu.Lock()
+	state := h.upgradeHealth[nodeID]
+	if state == nil || state.StartedTime != started || state.Status != upgradeHealthPending {
+		h.upgradeMu.Unlock()
+		return
+	}
+	state.Status = upgradeHealthFailed
+	state.Message = fmt.Sprintf("新版本 %s 未在期限内上报，节点将恢复旧版本", state.Version)
+	state.UpdatedTime = time.Now().UnixMilli()
+	message := state.Message
+	h.upgradeMu.Unlock()
+
+	if h.wsServer != nil {
+		h.wsServer.BroadcastUpgradeProgress(nodeID, upgradeHealthFailed, 100, false, message)
+	}
+}
+
+// upgradeHealthOf returns a copy of the node's last upgrade state.
+func (h *Handler) upgradeHealthOf(nodeID int64) (nodeUpgradeHealth, bool) {
+	h.upgradeMu.Lock()
+	defer h.upgradeMu.Unlock()
+	state := h.upgradeHealth[nodeID]
+	if state == nil {
+		return nodeUpgradeHealth{}, false
+	}
+	out := *state
+	out.timer = nil
+	return out, true
+}
+
+// nodeUpgradeStatus lists the last upgrade outcome of the given nodes, or
+// of every node upgraded since the panel started.
+func (h *Handler) nodeUpgradeStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req map[string]interface{}
+	if err := decodeJSON(r.Body, &req); err != nil && err != io.EOF {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	ids := asInt64Slice(req["ids"])
+	if len(ids) == 0 {
+		h.upgradeMu.Lock()
+		for id := range h.upgradeHealth {
+			ids = append(ids, id)
+		}
+		h.upgradeMu.Unlock()
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	}
+	items := make([]nodeUpgradeHealth, 0, len(ids))
+	for _, id := range ids {
+		if state, ok := h.upgradeHealthOf(id); ok {
+			items = append(items, state)
+		}
+	}
+	response.WriteJSON(w, response.OK(items))
+}
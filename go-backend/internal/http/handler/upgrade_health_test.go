@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-backend/internal/store/repo"
+)
+
+func TestUpgradeHealthTracksReportedVersion(t *testing.T) {
+	r, err := repo.Open(filepath.Join(t.TempDir(), "upgrade.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = r.Close() })
+	h := New(r, "secret")
+
+	now := time.Now().UnixMilli()
+	if err := r.DB().Exec(`INSERT INTO node(id, name, secret, server_ip, port, version, created_time, status) VALUES(1, 'n1', 's1', '1.1.1.1', '1000-2000', '1.0.0', ?, 1), (2, 'n2', 's2', '2.2.2.2', '1000-2000', '1.0.0', ?, 1)`, now, now).Error; err != nil {
+		t.Fatalf("insert nodes: %v", err)
+	}
+
+	h.startUpgradeHealthCheck(1, "v1.1.0")
+	h.startUpgradeHealthCheck(2, "1.1.0")
+	if state, _ := h.upgradeHealthOf(1); state.Status != upgradeHealthPending || state.Previous != "1.0.0" {
+		t.Fatalf("expected a pending upgrade from 1.0.0, got %+v", state)
+	}
+
+	// The old agent reconnecting before the restart does not settle it.
+	h.checkUpgradeHealth(1)
+	if state, _ := h.upgradeHealthOf(1); state.Status != upgradeHealthPending {
+		t.Fatalf("expected the upgrade to stay pending, got %+v", state)
+	}
+
+	if err := r.DB().Exec(`UPDATE node SET version = '1.1.0' WHERE id = 1`).Error; err != nil {
+		t.Fatalf("update version: %v", err)
+	}
+	h.checkUpgradeHealth(1)
+	state, _ := h.upgradeHealthOf(1)
+	if state.Status != upgradeHealthHealthy {
+		t.Fatalf("expected the upgrade to be healthy, got %+v", state)
+	}
+	h.expireUpgradeHealth(1, state.StartedTime)
+	if state, _ := h.upgradeHealthOf(1); state.Status != upgradeHealthHealthy {
+		t.Fatalf("expected the deadline to leave a healthy upgrade alone, got %+v", state)
+	}
+
+	pending, _ := h.upgradeHealthOf(2)
+	h.expireUpgradeHealth(2, pending.StartedTime-1)
+	if state, _ := h.upgradeHealthOf(2); state.Status != upgradeHealthPending {
+		t.Fatalf("expected a stale deadline to be ignored, got %+v", state)
+	}
+	h.expireUpgradeHealth(2, pending.StartedTime)
+	if state, _ := h.upgradeHealthOf(2); state.Status != upgradeHealthFailed {
+		t.Fatalf("expected the upgrade to fail at the deadline, got %+v", state)
+	}
+}
@@ -0,0 +1,615 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/store/model"
+)
+
+// A rollout upgrades a canary wave first and then the remaining nodes in
+// waves. A node counts as upgraded once it reconnects with the target
+// version (see upgrade_health.go), stays online for the stable window and,
+// if it was reporting traffic before, delivers a traffic report again. A
+// wave with more failures than the budget stops the rollout and rolls the
+// wave back.
+
+const (
+	rolloutPending   = "pending"
+	rolloutRunning   = "running"
+	rolloutPaused    = "paused"
+	rolloutCompleted = "completed"
+	rolloutAborted   = "aborted"
+	rolloutFailed    = "failed"
+
+	rolloutNodeQueued    = "queued"
+	rolloutNodeUpgrading = "upgrading"
+	rolloutNodeVerifying = "verifying"
+	rolloutNodeHealthy   = "healthy"
+	rolloutNodeFailed    = "failed"
+	rolloutNodeRollback  = "rolled_back"
+
+	defaultRolloutWaveSize      = 5
+	defaultRolloutStableSeconds = 60
+
+	// rolloutTrafficWindow is how recent a traffic report must be for the
+	// node to count as reporting before its upgrade.
+	rolloutTrafficWindow = 10 * time.Minute
+	// rolloutTrafficGrace is how long after the stable window a reporting
+	// node may take to deliver its first report.
+	rolloutTrafficGrace = 2 * time.Minute
+	rolloutListLimit    = 50
+)
+
+var rolloutPollInterval = time.Second
+
+type rolloutNodeResult struct {
+	NodeID      int64  `json:"nodeId"`
+	Wave        int    `json:"wave"`
+	Status      string `json:"status"`
+	Message     string `json:"message"`
+	UpdatedTime int64  `json:"updatedTime"`
+}
+
+// rolloutRun controls a rollout that is executing in this process.
+type rolloutRun struct {
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	pause   bool
+	results []rolloutNodeResult
+}
+
+func (run *rolloutRun) pauseRequested() bool {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	return run.pause
+}
+
+func (run *rolloutRun) setResult(nodeID int64, status, message string) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	for i := range run.results {
+		if run.results[i].NodeID == nodeID {
+			run.results[i].Status = status
+			run.results[i].Message = message
+			run.results[i].UpdatedTime = time.Now().UnixMilli()
+			return
+		}
+	}
+}
+
+func (run *rolloutRun) result(nodeID int64) rolloutNodeResult {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	for _, res := range run.results {
+		if res.NodeID == nodeID {
+			return res
+		}
+	}
+	return rolloutNodeResult{NodeID: nodeID}
+}
+
+func (run *rolloutRun) encodeResults() string {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	raw, _ := json.Marshal(run.results)
+	return string(raw)
+}
+
+// rolloutWaves splits the ordered node list into the canary wave and the
+// following waves.
+func rolloutWaves(nodeIDs []int64, canarySize, waveSize int) [][]int64 {
+	if waveSize <= 0 {
+		waveSize = 1
+	}
+	waves := make([][]int64, 0)
+	rest := nodeIDs
+	if canarySize > 0 {
+		if canarySize > len(rest) {
+			canarySize = len(rest)
+		}
+		waves = append(waves, rest[:canarySize])
+		rest = rest[canarySize:]
+	}
+	for len(rest) > 0 {
+		n := waveSize
+		if n > len(rest) {
+			n = len(rest)
+		}
+		waves = append(waves, rest[:n])
+		rest = rest[n:]
+	}
+	return waves
+}
+
+func parseRolloutNodeIDs(raw string) []int64 {
+	ids := make([]int64, 0)
+	for _, part := range strings.Split(raw, ",") {
+		if id, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64); err == nil && id > 0 {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func decodeRolloutResults(raw string) []rolloutNodeResult {
+	var results []rolloutNodeResult
+	_ = json.Unmarshal([]byte(raw), &results)
+	return results
+}
+
+func rolloutView(rollout *model.UpgradeRollout) map[string]interface{} {
+	nodeIDs := parseRolloutNodeIDs(rollout.NodeIDs)
+	return map[string]interface{}{
+		"id":            rollout.ID,
+		"version":       rollout.Version,
+		"source":        rollout.Source,
+		"nodeIds":       nodeIDs,
+		"canarySize":    rollout.CanarySize,
+		"waveSize":      rollout.WaveSize,
+		"concurrency":   rollout.Concurrency,
+		"failureBudget": rollout.FailureBudget,
+		"stableSeconds": rollout.StableSeconds,
+		"status":        rollout.Status,
+		"currentWave":   rollout.CurrentWave,
+		"waves":         len(rolloutWaves(nodeIDs, rollout.CanarySize, rollout.WaveSize)),
+		"results":       decodeRolloutResults(rollout.Results),
+		"message":       rollout.Message,
+		"createdTime":   rollout.CreatedTime,
+		"updatedTime":   rollout.UpdatedTime,
+	}
+}
+
+// broadcastRollout streams rollout progress on the upgrade_progress admin
+// channel. Rollout-level events use node id 0.
+func (h *Handler) broadcastRollout(rollout *model.UpgradeRollout) {
+	if h.wsServer == nil {
+		return
+	}
+	nodeIDs := parseRolloutNodeIDs(rollout.NodeIDs)
+	waves := len(rolloutWaves(nodeIDs, rollout.CanarySize, rollout.WaveSize))
+	percent := 100
+	if waves > 0 && rollout.Status != rolloutCompleted {
+		percent = rollout.CurrentWave * 100 / waves
+	}
+	message := fmt.Sprintf("升级计划 #%d %s: 第 %d/%d 批", rollout.ID, rollout.Status, rollout.CurrentWave, waves)
+	if rollout.Message != "" {
+		message += ", " + rollout.Message
+	}
+	h.wsServer.BroadcastUpgradeProgress(0, "rollout_"+rollout.Status, percent, rollout.Status != rolloutFailed, message)
+}
+
+func (h *Handler) broadcastRolloutNode(rolloutID int64, res rolloutNodeResult) {
+	if h.wsServer == nil {
+		return
+	}
+	message := fmt.Sprintf("升级计划 #%d 第 %d 批: %s", rolloutID, res.Wave, res.Status)
+	if res.Message != "" {
+		message += ", " + res.Message
+	}
+	h.wsServer.BroadcastUpgradeProgress(res.NodeID, "rollout_"+res.Status, 0, res.Status != rolloutNodeFailed, message)
+}
+
+func (h *Handler) saveRollout(rollout *model.UpgradeRollout, run *rolloutRun) {
+	if run != nil {
+		rollout.Results = run.encodeResults()
+	}
+	rollout.UpdatedTime = time.Now().UnixMilli()
+	if err := h.repo.SaveUpgradeRollout(rollout); err != nil {
+		fmt.Printf("upgrade rollout %d: save failed: %v\n", rollout.ID, err)
+	}
+	h.broadcastRollout(rollout)
+}
+
+// startRollout launches the runner of a rollout unless it is already running.
+func (h *Handler) startRollout(rollout *model.UpgradeRollout) bool {
+	h.rolloutMu.Lock()
+	if _, ok := h.rolloutRuns[rollout.ID]; ok {
+		h.rolloutMu.Unlock()
+		return false
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &rolloutRun{cancel: cancel, results: decodeRolloutResults(rollout.Results)}
+	h.rolloutRuns[rollout.ID] = run
+	h.rolloutMu.Unlock()
+
+	go func() {
+		defer func() {
+			h.rolloutMu.Lock()
+			delete(h.rolloutRuns, rollout.ID)
+			h.rolloutMu.Unlock()
+			cancel()
+		}()
+		h.runUpgradeRollout(ctx, run, rollout)
+	}()
+	return true
+}
+
+func (h *Handler) runUpgradeRollout(ctx context.Context, run *rolloutRun, rollout *model.UpgradeRollout) {
+	waves := rolloutWaves(parseRolloutNodeIDs(rollout.NodeIDs), rollout.CanarySize, rollout.WaveSize)
+	rollout.Status = rolloutRunning
+	rollout.Message = ""
+	h.saveRollout(rollout, run)
+
+	for rollout.CurrentWave < len(waves) {
+		if run.pauseRequested() {
+			rollout.Status = rolloutPaused
+			h.saveRollout(rollout, run)
+			return
+		}
+		wave := rollout.CurrentWave
+		failed, upgraded := h.runRolloutWave(ctx, run, rollout, wave, waves[wave])
+		if ctx.Err() != nil {
+			rollout.Status = rolloutAborted
+			rollout.Message = "已中止"
+			h.saveRollout(rollout, run)
+			return
+		}
+		if failed > rollout.FailureBudget {
+			h.rollbackRolloutWave(run, rollout.ID, upgraded)
+			rollout.Status = rolloutFailed
+			rollout.Message = fmt.Sprintf("第 %d 批失败 %d 个节点，超过允许的 %d 个，已回退该批次", wave, failed, rollout.FailureBudget)
+			h.saveRollout(rollout, run)
+			return
+		}
+		rollout.CurrentWave++
+		h.saveRollout(rollout, run)
+	}
+	rollout.Status = rolloutCompleted
+	h.saveRollout(rollout, run)
+}
+
+// runRolloutWave upgrades one wave and waits for every node to settle. It
+// returns the number of failed nodes and the nodes that accepted the
+// upgrade command.
+func (h *Handler) runRolloutWave(ctx context.Context, run *rolloutRun, rollout *model.UpgradeRollout, wave int, nodeIDs []int64) (int, []int64) {
+	local := rollout.Source == releaseSourceLocal
+	started := time.Now()
+	concurrency := rollout.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	var (
+		mu       sync.Mutex
+		upgraded []int64
+		wg       sync.WaitGroup
+	)
+	reporting := make(map[int64]bool, len(nodeIDs))
+	sem := make(chan struct{}, concurrency)
+	for _, nodeID := range nodeIDs {
+		if run.result(nodeID).Status == rolloutNodeHealthy {
+			// Already upgraded before the rollout was paused or interrupted.
+			continue
+		}
+		last, _ := h.repo.LastNodeFlowReportTime(nodeID)
+		reporting[nodeID] = last > 0 && started.Sub(time.UnixMilli(last)) <= rolloutTrafficWindow
+
+		wg.Add(1)
+		go func(nodeID int64) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if ctx.Err() != nil {
+				return
+			}
+			h.setRolloutNode(run, rollout.ID, nodeID, rolloutNodeUpgrading, "")
+			result, err := h.wsServer.SendCommand(nodeID, "UpgradeAgent", h.nodeUpgradeCommandData(nodeID, rollout.Version, local), upgradeTimeout)
+			if err != nil {
+				h.setRolloutNode(run, rollout.ID, nodeID, rolloutNodeFailed, err.Error())
+				return
+			}
+			h.markNodePendingUpgradeRedeploy(nodeID)
+			h.startUpgradeHealthCheck(nodeID, rollout.Version)
+			h.setRolloutNode(run, rollout.ID, nodeID, rolloutNodeUpgrading, result.Message)
+			mu.Lock()
+			upgraded = append(upgraded, nodeID)
+			mu.Unlock()
+		}(nodeID)
+	}
+	wg.Wait()
+	h.saveRollout(rollout, run)
+
+	stable := time.Duration(rollout.StableSeconds) * time.Second
+	ticker := time.NewTicker(rolloutPollInterval)
+	defer ticker.Stop()
+	for {
+		settled := true
+		for _, nodeID := range nodeIDs {
+			status := run.result(nodeID).Status
+			if status != rolloutNodeUpgrading && status != rolloutNodeVerifying {
+				continue
+			}
+			if !h.settleRolloutNode(run, rollout.ID, nodeID, reporting[nodeID], stable, time.Now()) {
+				settled = false
+			}
+		}
+		if settled {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return 0, upgraded
+		case <-ticker.C:
+		}
+	}
+
+	failed := 0
+	for _, nodeID := range nodeIDs {
+		if run.result(nodeID).Status == rolloutNodeFailed {
+			failed++
+		}
+	}
+	return failed, upgraded
+}
+
+// settleRolloutNode advances a node of the running wave and reports whether
+// it has reached a final state.
+func (h *Handler) settleRolloutNode(run *rolloutRun, rolloutID, nodeID int64, reporting bool, stable time.Duration, now time.Time) bool {
+	health, ok := h.upgradeHealthOf(nodeID)
+	if !ok || health.Status == upgradeHealthPending {
+		return false
+	}
+	if health.Status == upgradeHealthFailed {
+		h.setRolloutNode(run, rolloutID, nodeID, rolloutNodeFailed, health.Message)
+		return true
+	}
+
+	healthyAt := time.UnixMilli(health.UpdatedTime)
+	node, err := h.repo.GetNodeByID(nodeID)
+	if err != nil || node == nil || node.Status != 1 {
+		h.setRolloutNode(run, rolloutID, nodeID, rolloutNodeFailed, "升级后节点离线")
+		return true
+	}
+	if now.Sub(healthyAt) < stable {
+		if run.result(nodeID).Status != rolloutNodeVerifying {
+			h.setRolloutNode(run, rolloutID, nodeID, rolloutNodeVerifying, "观察运行状态")
+		}
+		return false
+	}
+	if reporting {
+		last, _ := h.repo.LastNodeFlowReportTime(nodeID)
+		if last < health.UpdatedTime {
+			if now.Sub(healthyAt) > stable+rolloutTrafficGrace {
+				h.setRolloutNode(run, rolloutID, nodeID, rolloutNodeFailed, "升级后未恢复流量上报")
+				return true
+			}
+			if run.result(nodeID).Status != rolloutNodeVerifying {
+				h.setRolloutNode(run, rolloutID, nodeID, rolloutNodeVerifying, "等待流量上报")
+			}
+			return false
+		}
+	}
+	h.setRolloutNode(run, rolloutID, nodeID, rolloutNodeHealthy, health.Message)
+	return true
+}
+
+func (h *Handler) setRolloutNode(run *rolloutRun, rolloutID, nodeID int64, status, message string) {
+	run.setResult(nodeID, status, message)
+	h.broadcastRolloutNode(rolloutID, run.result(nodeID))
+}
+
+// rollbackRolloutWave restores the previous agent on every node of a failed
+// wave that took the upgrade. Nodes whose new agent never reported in have
+// already been restored by their watchdog; RollbackAgent is harmless there.
+func (h *Handler) rollbackRolloutWave(run *rolloutRun, rolloutID int64, nodeIDs []int64) {
+	for _, nodeID := range nodeIDs {
+		if _, err := h.wsServer.SendCommand(nodeID, "RollbackAgent", map[string]interface{}{}, 30*time.Second); err != nil {
+			res := run.result(nodeID)
+			h.setRolloutNode(run, rolloutID, nodeID, res.Status, fmt.Sprintf("%s; 回退失败: %v", res.Message, err))
+			continue
+		}
+		h.setRolloutNode(run, rolloutID, nodeID, rolloutNodeRollback, run.result(nodeID).Message)
+	}
+}
+
+// pauseInterruptedRollouts marks rollouts that were running when the panel
+// stopped as paused; an admin resumes them explicitly.
+func (h *Handler) pauseInterruptedRollouts() {
+	if err := h.repo.PauseUpgradeRollouts(rolloutRunning, "面板重启，升级计划已暂停", time.Now().UnixMilli()); err != nil {
+		fmt.Printf("upgrade rollout: pause interrupted runs failed: %v\n", err)
+	}
+}
+
+func (h *Handler) rolloutCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req map[string]interface{}
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	ids := asInt64Slice(req["ids"])
+	if len(ids) == 0 {
+		response.WriteJSON(w, response.ErrDefault("ids不能为空"))
+		return
+	}
+	seen := make(map[int64]bool, len(ids))
+	ordered := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		ordered = append(ordered, strconv.FormatInt(id, 10))
+	}
+
+	channel := normalizeReleaseChannel(asString(req["channel"]))
+	version, local, err := h.resolveUpgradeRelease(asString(req["source"]), channel, strings.TrimSpace(asString(req["version"])))
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, fmt.Sprintf("获取最新%s失败: %v", releaseChannelLabel(channel), err)))
+		return
+	}
+	if err := h.checkHostedRelease(asString(req["source"]), version, ids); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	source := releaseSourceGitHub
+	if local {
+		source = releaseSourceLocal
+	}
+
+	rollout := &model.UpgradeRollout{
+		Version:       version,
+		Source:        source,
+		NodeIDs:       strings.Join(ordered, ","),
+		CanarySize:    asInt(req["canarySize"], 1),
+		WaveSize:      asInt(req["waveSize"], defaultRolloutWaveSize),
+		Concurrency:   asInt(req["concurrency"], batchWorkers),
+		FailureBudget: asInt(req["failureBudget"], 0),
+		StableSeconds: asInt(req["stableSeconds"], defaultRolloutStableSeconds),
+		Status:        rolloutPending,
+	}
+	if rollout.CanarySize < 0 || rollout.WaveSize <= 0 || rollout.Concurrency <= 0 || rollout.FailureBudget < 0 || rollout.StableSeconds < 0 {
+		response.WriteJSON(w, response.ErrDefault("升级计划参数无效"))
+		return
+	}
+	results := make([]rolloutNodeResult, 0, len(ordered))
+	for wave, nodes := range rolloutWaves(parseRolloutNodeIDs(rollout.NodeIDs), rollout.CanarySize, rollout.WaveSize) {
+		for _, nodeID := range nodes {
+			results = append(results, rolloutNodeResult{NodeID: nodeID, Wave: wave, Status: rolloutNodeQueued})
+		}
+	}
+	raw, _ := json.Marshal(results)
+	rollout.Results = string(raw)
+	now := time.Now().UnixMilli()
+	rollout.CreatedTime = now
+	rollout.UpdatedTime = now
+	if err := h.repo.CreateUpgradeRollout(rollout); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	view := rolloutView(rollout)
+	h.startRollout(rollout)
+	response.WriteJSON(w, response.OK(view))
+}
+
+func (h *Handler) rolloutList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	rollouts, err := h.repo.ListUpgradeRollouts(rolloutListLimit)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	items := make([]map[string]interface{}, 0, len(rollouts))
+	for i := range rollouts {
+		items = append(items, h.currentRolloutView(&rollouts[i]))
+	}
+	response.WriteJSON(w, response.OK(items))
+}
+
+// currentRolloutView prefers the in-memory per-node results of a running
+// rollout, which are saved to the database only between steps.
+func (h *Handler) currentRolloutView(rollout *model.UpgradeRollout) map[string]interface{} {
+	view := rolloutView(rollout)
+	h.rolloutMu.Lock()
+	run := h.rolloutRuns[rollout.ID]
+	h.rolloutMu.Unlock()
+	if run != nil {
+		view["results"] = decodeRolloutResults(run.encodeResults())
+	}
+	return view
+}
+
+func (h *Handler) rolloutGet(w http.ResponseWriter, r *http.Request) {
+	rollout := h.rolloutFromBody(w, r)
+	if rollout == nil {
+		return
+	}
+	response.WriteJSON(w, response.OK(h.currentRolloutView(rollout)))
+}
+
+func (h *Handler) rolloutFromBody(w http.ResponseWriter, r *http.Request) *model.UpgradeRollout {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return nil
+	}
+	id := idFromBody(r, w)
+	if id <= 0 {
+		return nil
+	}
+	rollout, err := h.repo.GetUpgradeRollout(id)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return nil
+	}
+	if rollout == nil {
+		response.WriteJSON(w, response.ErrDefault("升级计划不存在"))
+		return nil
+	}
+	return rollout
+}
+
+// rolloutPause stops a rollout after its current wave settles.
+func (h *Handler) rolloutPause(w http.ResponseWriter, r *http.Request) {
+	rollout := h.rolloutFromBody(w, r)
+	if rollout == nil {
+		return
+	}
+	h.rolloutMu.Lock()
+	run := h.rolloutRuns[rollout.ID]
+	h.rolloutMu.Unlock()
+	if run == nil {
+		response.WriteJSON(w, response.ErrDefault("升级计划未在运行"))
+		return
+	}
+	run.mu.Lock()
+	run.pause = true
+	run.mu.Unlock()
+	response.WriteJSON(w, response.OKEmpty())
+}
+
+func (h *Handler) rolloutResume(w http.ResponseWriter, r *http.Request) {
+	rollout := h.rolloutFromBody(w, r)
+	if rollout == nil {
+		return
+	}
+	if rollout.Status != rolloutPaused {
+		response.WriteJSON(w, response.ErrDefault("只能继续已暂停的升级计划"))
+		return
+	}
+	if !h.startRollout(rollout) {
+		response.WriteJSON(w, response.ErrDefault("升级计划正在运行"))
+		return
+	}
+	response.WriteJSON(w, response.OKEmpty())
+}
+
+// rolloutAbort stops a rollout immediately. Nodes already upgraded keep the
+// new version.
+func (h *Handler) rolloutAbort(w http.ResponseWriter, r *http.Request) {
+	rollout := h.rolloutFromBody(w, r)
+	if rollout == nil {
+		return
+	}
+	h.rolloutMu.Lock()
+	run := h.rolloutRuns[rollout.ID]
+	h.rolloutMu.Unlock()
+	if run != nil {
+		run.cancel()
+		response.WriteJSON(w, response.OKEmpty())
+		return
+	}
+	switch rollout.Status {
+	case rolloutCompleted, rolloutAborted, rolloutFailed:
+		response.WriteJSON(w, response.ErrDefault("升级计划已结束"))
+		return
+	}
+	rollout.Status = rolloutAborted
+	rollout.Message = "已中止"
+	h.saveRollout(rollout, nil)
+	response.WriteJSON(w, response.OKEmpty())
+}
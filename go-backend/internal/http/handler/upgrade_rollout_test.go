@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"go-backend/internal/store/model"
+	"go-backend/internal/store/repo"
+)
+
+func TestRolloutWaves(t *testing.T) {
+	ids := []int64{1, 2, 3, 4, 5, 6}
+	tests := []struct {
+		name   string
+		canary int
+		wave   int
+		want   [][]int64
+	}{
+		{name: "canary then waves", canary: 1, wave: 2, want: [][]int64{{1}, {2, 3}, {4, 5}, {6}}},
+		{name: "no canary", canary: 0, wave: 4, want: [][]int64{{1, 2, 3, 4}, {5, 6}}},
+		{name: "canary covers all", canary: 10, wave: 2, want: [][]int64{{1, 2, 3, 4, 5, 6}}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := rolloutWaves(ids, tc.canary, tc.wave); !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("rolloutWaves(%d, %d) = %v, want %v", tc.canary, tc.wave, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRolloutStopsWhenWaveExceedsFailureBudget(t *testing.T) {
+	r, err := repo.Open(filepath.Join(t.TempDir(), "rollout.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = r.Close() })
+	h := New(r, "secret")
+	interval := rolloutPollInterval
+	rolloutPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { rolloutPollInterval = interval })
+
+	run := func(budget int) *model.UpgradeRollout {
+		t.Helper()
+		// Nodes 1 and 2 have no agent connected, so their upgrade fails.
+		rollout := &model.UpgradeRollout{
+			Version:       "2.0.0",
+			Source:        releaseSourceGitHub,
+			NodeIDs:       "1,2",
+			CanarySize:    1,
+			WaveSize:      1,
+			Concurrency:   1,
+			FailureBudget: budget,
+			Status:        rolloutPending,
+			Results:       `[{"nodeId":1,"wave":0,"status":"queued"},{"nodeId":2,"wave":1,"status":"queued"}]`,
+			CreatedTime:   time.Now().UnixMilli(),
+			UpdatedTime:   time.Now().UnixMilli(),
+		}
+		if err := r.CreateUpgradeRollout(rollout); err != nil {
+			t.Fatalf("create rollout: %v", err)
+		}
+		if !h.startRollout(rollout) {
+			t.Fatalf("expected the rollout to start")
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			got, err := r.GetUpgradeRollout(rollout.ID)
+			if err != nil {
+				t.Fatalf("get rollout: %v", err)
+			}
+			if got.Status == rolloutFailed || got.Status == rolloutCompleted {
+				return got
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("rollout did not finish, status %q", got.Status)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	failed := run(0)
+	if failed.Status != rolloutFailed || failed.CurrentWave != 0 {
+		t.Fatalf("expected the canary wave to stop the rollout, got %+v", failed)
+	}
+	results := decodeRolloutResults(failed.Results)
+	if results[0].Status != rolloutNodeFailed || results[1].Status != rolloutNodeQueued {
+		t.Fatalf("expected only the canary to be attempted, got %+v", results)
+	}
+
+	tolerated := run(1)
+	if tolerated.Status != rolloutCompleted || tolerated.CurrentWave != 2 {
+		t.Fatalf("expected failures within the budget to continue, got %+v", tolerated)
+	}
+}
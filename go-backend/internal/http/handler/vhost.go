@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Forwards with a domain do not own a port. Their entry service uses the
+// agent's "vhost" listener, which shares one physical socket on 443/80
+// between every such forward on the node and hands each connection to the
+// service whose domain matches the TLS SNI or HTTP Host header. Each forward
+// keeps its own service, so traffic accounting, limiters and admissions
+// work unchanged.
+const vhostListenerType = "vhost"
+
+func isSharedForwardPort(port int) bool {
+	return port == 443 || port == 80
+}
+
+// normalizeForwardDomain validates a shared-listener domain. A single
+// leading "*." label is accepted as a wildcard; exact domains win over
+// wildcards on the agent.
+func normalizeForwardDomain(raw string) (string, error) {
+	domain := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(raw)), ".")
+	if domain == "" {
+		return "", nil
+	}
+	if len(domain) > 253 {
+		return "", errors.New("域名过长")
+	}
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return "", fmt.Errorf("无效的域名: %s", raw)
+	}
+	for i, label := range labels {
+		if i == 0 && label == "*" {
+			continue
+		}
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return "", fmt.Errorf("无效的域名: %s", raw)
+		}
+		for _, c := range label {
+			if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+				return "", fmt.Errorf("无效的域名: %s", raw)
+			}
+		}
+	}
+	if labels[0] == "*" && len(labels) < 3 {
+		return "", fmt.Errorf("通配符域名至少需要两级: %s", raw)
+	}
+	return domain, nil
+}
+
+// validateForwardListenPlacement keeps shared and dedicated forwards from
+// colliding: a domain must be unique panel-wide, and a node port serves
+// either domain-routed forwards or plain ones, never both.
+func (h *Handler) validateForwardListenPlacement(domain string, port int, entryNodes []int64, excludeForwardID int64) error {
+	if domain != "" {
+		if !isSharedForwardPort(port) {
+			return errors.New("域名转发仅支持 443 或 80 端口")
+		}
+		exists, err := h.repo.ForwardDomainExists(domain, excludeForwardID)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("域名 %s 已被其他转发使用", domain)
+		}
+	}
+	for _, nodeID := range entryNodes {
+		node, err := h.getNodeRecord(nodeID)
+		if err != nil {
+			continue
+		}
+		if domain != "" && node.IsRemote == 1 {
+			return fmt.Errorf("远程节点 %s 不支持域名转发", node.Name)
+		}
+		shared, dedicated, err := h.repo.CountForwardsOnNodePort(nodeID, port, excludeForwardID)
+		if err != nil {
+			return err
+		}
+		if domain != "" && dedicated > 0 {
+			return fmt.Errorf("节点 %s 端口 %d 已被其他转发独占", node.Name, port)
+		}
+		if domain == "" && shared > 0 {
+			return fmt.Errorf("节点 %s 端口 %d 为域名共享端口，请填写域名", node.Name, port)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-backend/internal/store/repo"
+)
+
+func TestNormalizeForwardDomain(t *testing.T) {
+	cases := map[string]string{
+		"":                  "",
+		" Example.COM. ":    "example.com",
+		"*.app.example.com": "*.app.example.com",
+		"a-b.example.io":    "a-b.example.io",
+	}
+	for in, want := range cases {
+		got, err := normalizeForwardDomain(in)
+		if err != nil {
+			t.Fatalf("%q: %v", in, err)
+		}
+		if got != want {
+			t.Fatalf("%q: expected %q, got %q", in, want, got)
+		}
+	}
+
+	for _, bad := range []string{"localhost", "*.com", "-a.example.com", "a_b.example.com", "a.*.example.com", "exa mple.com"} {
+		if _, err := normalizeForwardDomain(bad); err == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestBuildForwardServiceConfigsSharedDomain(t *testing.T) {
+	forward := &forwardRecord{ID: 1, UserID: 2, TunnelID: 3, RemoteAddr: "10.0.0.1:443", Domain: "app.example.com"}
+	node := &nodeRecord{ID: 4, TCPListenAddr: "[::]", UDPListenAddr: "[::]"}
+	services := buildForwardServiceConfigs("1_2_3", forward, &tunnelRecord{ID: 3, Type: 1}, node, 443, nil, false)
+	if len(services) != 1 {
+		t.Fatalf("expected a single tcp service, got %d", len(services))
+	}
+	if services[0]["name"] != "1_2_3_tcp" || services[0]["addr"] != "[::]:443" {
+		t.Fatalf("unexpected service: %v", services[0])
+	}
+	listener := services[0]["listener"].(map[string]interface{})
+	if listener["type"] != vhostListenerType {
+		t.Fatalf("expected vhost listener, got %v", listener["type"])
+	}
+	hosts := listener["metadata"].(map[string]interface{})["hosts"].([]string)
+	if len(hosts) != 1 || hosts[0] != "app.example.com" {
+		t.Fatalf("unexpected hosts: %v", hosts)
+	}
+}
+
+func TestValidateForwardListenPlacement(t *testing.T) {
+	r, err := repo.Open(filepath.Join(t.TempDir(), "vhost.db"))
+	if err != nil {
+		t.Fatalf("open repo: %v", err)
+	}
+	defer r.Close()
+
+	h := &Handler{repo: r}
+	now := time.Now().UnixMilli()
+	if err := r.DB().Exec(`INSERT INTO node(id, name, secret, server_ip, port, created_time, status) VALUES(1, 'n1', 's1', '1.1.1.1', '1000-2000', ?, 1)`, now).Error; err != nil {
+		t.Fatalf("insert node: %v", err)
+	}
+	if _, err := r.CreateForwardTx(1, "u", "shared", 1, "10.0.0.1:443", "fifo", "app.example.com", now, 1, []int64{1}, 443); err != nil {
+		t.Fatalf("create shared forward: %v", err)
+	}
+	if _, err := r.CreateForwardTx(1, "u", "plain", 1, "10.0.0.2:80", "fifo", "", now, 2, []int64{1}, 80); err != nil {
+		t.Fatalf("create plain forward: %v", err)
+	}
+
+	if err := h.validateForwardListenPlacement("www.example.com", 443, []int64{1}, 0); err != nil {
+		t.Fatalf("expected a second domain on the shared port to be accepted: %v", err)
+	}
+	if err := h.validateForwardListenPlacement("app.example.com", 443, []int64{1}, 0); err == nil {
+		t.Fatalf("expected duplicate domain to be rejected")
+	}
+	if err := h.validateForwardListenPlacement("www.example.com", 80, []int64{1}, 0); err == nil {
+		t.Fatalf("expected shared forward on a dedicated port to be rejected")
+	}
+	if err := h.validateForwardListenPlacement("", 443, []int64{1}, 0); err == nil {
+		t.Fatalf("expected plain forward on a shared port to be rejected")
+	}
+	if err := h.validateForwardListenPlacement("www.example.com", 8443, []int64{1}, 0); err == nil {
+		t.Fatalf("expected non-shared port to be rejected")
+	}
+}
@@ -117,6 +117,14 @@ func requiresAdmin(path string) bool {
 		return true
 	}
 
+	if strings.HasPrefix(path, "/api/v1/alert/") {
+		return true
+	}
+
+	if strings.HasPrefix(path, "/api/v1/certificate/") {
+		return true
+	}
+
 	if strings.HasPrefix(path, "/api/v1/backup/") {
 		return true
 	}
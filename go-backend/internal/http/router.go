@@ -2,6 +2,7 @@ package httpserver
 
 import (
 	"net/http"
+	"strings"
 
 	"go-backend/internal/http/handler"
 	"go-backend/internal/http/middleware"
@@ -18,3 +19,15 @@ func NewRouter(h *handler.Handler, jwtSecret string) http.Handler {
 	wrapped = middleware.CORS(wrapped)
 	return wrapped
 }
+
+// NewNodeRouter restricts a router to the node endpoints, for the mutual TLS
+// listener.
+func NewNodeRouter(router http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/flow/") && r.URL.Path != "/system-info" {
+			http.NotFound(w, r)
+			return
+		}
+		router.ServeHTTP(w, r)
+	})
+}
@@ -29,19 +29,46 @@ func (User) TableName() string { return "user" }
 
 // Forward maps to the "forward" table.
 type Forward struct {
-	ID          int64  `gorm:"primaryKey;autoIncrement"`
-	UserID      int64  `gorm:"column:user_id;not null"`
-	UserName    string `gorm:"column:user_name;type:varchar(100);not null"`
-	Name        string `gorm:"type:varchar(100);not null"`
-	TunnelID    int64  `gorm:"column:tunnel_id;not null"`
-	RemoteAddr  string `gorm:"column:remote_addr;type:text;not null"`
-	Strategy    string `gorm:"type:varchar(100);not null;default:'fifo'"`
-	InFlow      int64  `gorm:"column:in_flow;not null;default:0"`
-	OutFlow     int64  `gorm:"column:out_flow;not null;default:0"`
-	CreatedTime int64  `gorm:"column:created_time;not null"`
-	UpdatedTime int64  `gorm:"column:updated_time;not null"`
-	Status      int    `gorm:"not null"`
-	Inx         int    `gorm:"not null;default:0"`
+	ID             int64  `gorm:"primaryKey;autoIncrement"`
+	UserID         int64  `gorm:"column:user_id;not null"`
+	UserName       string `gorm:"column:user_name;type:varchar(100);not null"`
+	Name           string `gorm:"type:varchar(100);not null"`
+	TunnelID       int64  `gorm:"column:tunnel_id;not null"`
+	RemoteAddr     string `gorm:"column:remote_addr;type:text;not null"`
+	Strategy       string `gorm:"type:varchar(100);not null;default:'fifo'"`
+	InFlow         int64  `gorm:"column:in_flow;not null;default:0"`
+	OutFlow        int64  `gorm:"column:out_flow;not null;default:0"`
+	CreatedTime    int64  `gorm:"column:created_time;not null"`
+	UpdatedTime    int64  `gorm:"column:updated_time;not null"`
+	Status         int    `gorm:"not null"`
+	Inx            int    `gorm:"not null;default:0"`
+	AllowCIDRs     string `gorm:"column:allow_cidrs;type:text;not null;default:''"`
+	DenyCIDRs      string `gorm:"column:deny_cidrs;type:text;not null;default:''"`
+	AllowCountries string `gorm:"column:allow_countries;type:text;not null;default:''"`
+	DenyCountries  string `gorm:"column:deny_countries;type:text;not null;default:''"`
+	GeoRejected    int64  `gorm:"column:geo_rejected;not null;default:0"`
+	Domain         string `gorm:"column:domain;type:varchar(255);not null;default:''"`
+	ProxyMode      string `gorm:"column:proxy_mode;type:varchar(16);not null;default:''"`
+	CertificateID  int64  `gorm:"column:certificate_id;not null;default:0"`
+	ProxyHost      string `gorm:"column:proxy_host;type:varchar(255);not null;default:''"`
+	ProxyHeaders   string `gorm:"column:proxy_headers;type:text;not null;default:''"`
+	// Schedule: the forward only runs between ScheduleStart and ScheduleEnd
+	// (ms, 0 = open) and inside ScheduleWindows (JSON weekly windows in
+	// ScheduleTimezone). SchedulePaused marks forwards paused by the
+	// scheduler rather than by a user. At ExpireTime the forward is paused
+	// or deleted according to ExpireAction.
+	ScheduleStart    int64  `gorm:"column:schedule_start;not null;default:0"`
+	ScheduleEnd      int64  `gorm:"column:schedule_end;not null;default:0"`
+	ScheduleWindows  string `gorm:"column:schedule_windows;type:text;not null;default:''"`
+	ScheduleTimezone string `gorm:"column:schedule_timezone;type:varchar(64);not null;default:''"`
+	SchedulePaused   int    `gorm:"column:schedule_paused;not null;default:0"`
+	ExpireTime       int64  `gorm:"column:expire_time;not null;default:0"`
+	ExpireAction     string `gorm:"column:expire_action;type:varchar(16);not null;default:''"`
+	// Protocol rules: AllowProtocols switches the forward to allow-list mode.
+	// ProtocolBlocked counts connections the agents blocked by protocol.
+	AllowProtocols  string `gorm:"column:allow_protocols;type:text;not null;default:''"`
+	DenyProtocols   string `gorm:"column:deny_protocols;type:text;not null;default:''"`
+	ProtocolBlocked int64  `gorm:"column:protocol_blocked;not null;default:0"`
 }
 
 func (Forward) TableName() string { return "forward" }
@@ -78,6 +105,31 @@ type Node struct {
 	RemoteURL     sql.NullString `gorm:"column:remote_url;type:text"`
 	RemoteToken   sql.NullString `gorm:"column:remote_token;type:text"`
 	RemoteConfig  sql.NullString `gorm:"column:remote_config;type:text"`
+	AllowCIDRs    string         `gorm:"column:allow_cidrs;type:text;not null;default:''"`
+	DenyCIDRs     string         `gorm:"column:deny_cidrs;type:text;not null;default:''"`
+	// Maintenance keeps new forwards and tunnels off the node.
+	Maintenance     int   `gorm:"not null;default:0"`
+	MaintenanceTime int64 `gorm:"column:maintenance_time;not null;default:0"`
+	// Labels is a JSON object of free-form key/value pairs (region,
+	// provider, ISP, tier...) matched by tunnel node selectors.
+	Labels       string `gorm:"type:text;not null;default:''"`
+	CapacityMbps int64  `gorm:"column:capacity_mbps;not null;default:0"`
+	MaxForwards  int    `gorm:"column:max_forwards;not null;default:0"`
+	// Protocol rules applied to every forward entering through the node, on
+	// top of the HTTP/TLS/Socks switches.
+	AllowProtocols  string `gorm:"column:allow_protocols;type:text;not null;default:''"`
+	DenyProtocols   string `gorm:"column:deny_protocols;type:text;not null;default:''"`
+	ProtocolBlocked int64  `gorm:"column:protocol_blocked;not null;default:0"`
+	// EnrollTokenID and EnrollID identify the enrollment that created the
+	// node, so an agent retrying after a lost response gets the same node.
+	EnrollTokenID int64  `gorm:"column:enroll_token_id;not null;default:0;index:idx_node_enroll"`
+	EnrollID      string `gorm:"column:enroll_id;type:varchar(64);not null;default:'';index:idx_node_enroll"`
+	// DisconnectedTime is when the node's session last closed. UpdatedTime
+	// also moves on edits, so it cannot tell how long a node has been down.
+	DisconnectedTime int64 `gorm:"column:disconnected_time;not null;default:0"`
+	// Arch is the agent's architecture as reported on connect; empty for
+	// agents that do not report it.
+	Arch string `gorm:"type:varchar(32);not null;default:''"`
 }
 
 func (Node) TableName() string { return "node" }
@@ -107,18 +159,27 @@ type StatisticsFlow struct {
 func (StatisticsFlow) TableName() string { return "statistics_flow" }
 
 type Tunnel struct {
-	ID           int64          `gorm:"primaryKey;autoIncrement"`
-	Name         string         `gorm:"type:varchar(100);not null"`
-	TrafficRatio float64        `gorm:"column:traffic_ratio;not null;default:1.0"`
-	Type         int            `gorm:"not null"`
-	Protocol     string         `gorm:"type:varchar(10);not null;default:'tls'"`
-	Flow         int64          `gorm:"not null"`
-	CreatedTime  int64          `gorm:"column:created_time;not null"`
-	UpdatedTime  int64          `gorm:"column:updated_time;not null"`
-	Status       int            `gorm:"not null"`
-	InIP         sql.NullString `gorm:"column:in_ip;type:text"`
-	Inx          int            `gorm:"not null;default:0"`
-	IPPreference string         `gorm:"column:ip_preference;type:varchar(10);not null;default:''"`
+	ID             int64          `gorm:"primaryKey;autoIncrement"`
+	Name           string         `gorm:"type:varchar(100);not null"`
+	TrafficRatio   float64        `gorm:"column:traffic_ratio;not null;default:1.0"`
+	Type           int            `gorm:"not null"`
+	Protocol       string         `gorm:"type:varchar(10);not null;default:'tls'"`
+	Flow           int64          `gorm:"not null"`
+	CreatedTime    int64          `gorm:"column:created_time;not null"`
+	UpdatedTime    int64          `gorm:"column:updated_time;not null"`
+	Status         int            `gorm:"not null"`
+	InIP           sql.NullString `gorm:"column:in_ip;type:text"`
+	Inx            int            `gorm:"not null;default:0"`
+	IPPreference   string         `gorm:"column:ip_preference;type:varchar(10);not null;default:''"`
+	AllowCountries string         `gorm:"column:allow_countries;type:text;not null;default:''"`
+	DenyCountries  string         `gorm:"column:deny_countries;type:text;not null;default:''"`
+	AllowProtocols string         `gorm:"column:allow_protocols;type:text;not null;default:''"`
+	DenyProtocols  string         `gorm:"column:deny_protocols;type:text;not null;default:''"`
+	// Node selectors pick entry and exit nodes by label instead of fixed
+	// IDs; entry nodes matching InNodeSelector join the tunnel when they
+	// come online.
+	InNodeSelector  string `gorm:"column:in_node_selector;type:text;not null;default:''"`
+	OutNodeSelector string `gorm:"column:out_node_selector;type:text;not null;default:''"`
 }
 
 func (Tunnel) TableName() string { return "tunnel" }
@@ -148,6 +209,8 @@ type UserTunnel struct {
 	FlowResetTime int64         `gorm:"column:flow_reset_time;not null"`
 	ExpTime       int64         `gorm:"column:exp_time;not null"`
 	Status        int           `gorm:"not null"`
+	AllowCIDRs    string        `gorm:"column:allow_cidrs;type:text;not null;default:''"`
+	DenyCIDRs     string        `gorm:"column:deny_cidrs;type:text;not null;default:''"`
 }
 
 func (UserTunnel) TableName() string { return "user_tunnel" }
@@ -293,6 +356,254 @@ type FederationTunnelBinding struct {
 
 func (FederationTunnelBinding) TableName() string { return "federation_tunnel_binding" }
 
+// Certificate is a TLS certificate managed by the panel for HTTP reverse
+// proxy forwards. CertData and KeyData hold the PEM encrypted with the
+// panel secret; the ACME account key is stored the same way.
+type Certificate struct {
+	ID             int64  `gorm:"primaryKey;autoIncrement"`
+	Name           string `gorm:"type:varchar(100);not null"`
+	Domains        string `gorm:"type:text;not null"`
+	Source         string `gorm:"type:varchar(16);not null"`
+	CertData       string `gorm:"column:cert_data;type:text;not null;default:''"`
+	KeyData        string `gorm:"column:key_data;type:text;not null;default:''"`
+	NotBefore      int64  `gorm:"column:not_before;not null;default:0"`
+	NotAfter       int64  `gorm:"column:not_after;not null;default:0"`
+	ACMEDirectory  string `gorm:"column:acme_directory;type:text;not null;default:''"`
+	ACMEEmail      string `gorm:"column:acme_email;type:varchar(255);not null;default:''"`
+	ACMEAccountKey string `gorm:"column:acme_account_key;type:text;not null;default:''"`
+	Status         int    `gorm:"not null;default:0"`
+	LastError      string `gorm:"column:last_error;type:text;not null;default:''"`
+	CreatedTime    int64  `gorm:"column:created_time;not null"`
+	UpdatedTime    int64  `gorm:"column:updated_time;not null"`
+}
+
+func (Certificate) TableName() string { return "certificate" }
+
+// NodeMetric is one time bucket of a node's resource history. Resolution is
+// the bucket length in seconds; minute buckets are rolled up into hourly
+// ones. Rates are bytes per second over the bucket.
+type NodeMetric struct {
+	ID             int64   `gorm:"primaryKey;autoIncrement"`
+	NodeID         int64   `gorm:"column:node_id;not null;uniqueIndex:idx_node_metric_bucket"`
+	Resolution     int     `gorm:"not null;uniqueIndex:idx_node_metric_bucket"`
+	BucketTime     int64   `gorm:"column:bucket_time;not null;uniqueIndex:idx_node_metric_bucket"`
+	Samples        int     `gorm:"not null;default:0"`
+	CPU            float64 `gorm:"column:cpu;not null;default:0"`
+	CPUMax         float64 `gorm:"column:cpu_max;not null;default:0"`
+	Memory         float64 `gorm:"not null;default:0"`
+	MemoryMax      float64 `gorm:"column:memory_max;not null;default:0"`
+	RxRate         int64   `gorm:"column:rx_rate;not null;default:0"`
+	RxRateMax      int64   `gorm:"column:rx_rate_max;not null;default:0"`
+	TxRate         int64   `gorm:"column:tx_rate;not null;default:0"`
+	TxRateMax      int64   `gorm:"column:tx_rate_max;not null;default:0"`
+	Connections    int64   `gorm:"not null;default:0"`
+	ConnectionsMax int64   `gorm:"column:connections_max;not null;default:0"`
+	Uptime         int64   `gorm:"not null;default:0"`
+}
+
+func (NodeMetric) TableName() string { return "node_metric" }
+
+// NodeMaintenanceMove records a forward moved off a node entering
+// maintenance, with the port it had there, so it can be moved back.
+type NodeMaintenanceMove struct {
+	ID          int64 `gorm:"primaryKey;autoIncrement"`
+	NodeID      int64 `gorm:"column:node_id;not null;index"`
+	ForwardID   int64 `gorm:"column:forward_id;not null;index"`
+	Port        int   `gorm:"not null"`
+	CreatedTime int64 `gorm:"column:created_time;not null"`
+}
+
+func (NodeMaintenanceMove) TableName() string { return "node_maintenance_move" }
+
+// NodeEnrollToken lets an agent register itself as a new node. Only the
+// SHA-256 of the token is stored; TokenPrefix identifies it in the panel.
+// MaxUses 0 means unlimited until ExpireTime. Labels and Port are applied
+// to the nodes it creates.
+type NodeEnrollToken struct {
+	ID           int64  `gorm:"primaryKey;autoIncrement"`
+	Name         string `gorm:"type:varchar(100);not null"`
+	TokenHash    string `gorm:"column:token_hash;type:varchar(64);not null;uniqueIndex"`
+	TokenPrefix  string `gorm:"column:token_prefix;type:varchar(16);not null"`
+	MaxUses      int    `gorm:"column:max_uses;not null;default:0"`
+	UsedCount    int    `gorm:"column:used_count;not null;default:0"`
+	ExpireTime   int64  `gorm:"column:expire_time;not null"`
+	Labels       string `gorm:"type:text;not null;default:''"`
+	Port         string `gorm:"type:text;not null;default:''"`
+	CreatedTime  int64  `gorm:"column:created_time;not null"`
+	LastUsedTime int64  `gorm:"column:last_used_time;not null;default:0"`
+}
+
+func (NodeEnrollToken) TableName() string { return "node_enroll_token" }
+
+// NodeCommand is a control command issued while its node was disconnected,
+// replayed in ID order when the node reconnects. A newer command with the
+// same IdempotencyKey replaces a pending one. Payload is the JSON command
+// data.
+type NodeCommand struct {
+	ID               int64  `gorm:"primaryKey;autoIncrement"`
+	NodeID           int64  `gorm:"column:node_id;not null;index:idx_node_command_node_status"`
+	CommandType      string `gorm:"column:command_type;type:varchar(64);not null"`
+	Payload          string `gorm:"type:text;not null"`
+	IdempotencyKey   string `gorm:"column:idempotency_key;type:varchar(128);not null;default:''"`
+	TolerateExists   int    `gorm:"column:tolerate_exists;not null;default:0"`
+	TolerateNotFound int    `gorm:"column:tolerate_not_found;not null;default:0"`
+	Status           int    `gorm:"not null;default:0;index:idx_node_command_node_status"`
+	Attempts         int    `gorm:"not null;default:0"`
+	LastError        string `gorm:"column:last_error;type:text;not null;default:''"`
+	CreatedTime      int64  `gorm:"column:created_time;not null"`
+	UpdatedTime      int64  `gorm:"column:updated_time;not null"`
+	ExpireTime       int64  `gorm:"column:expire_time;not null"`
+}
+
+func (NodeCommand) TableName() string { return "node_command" }
+
+// NodeCommand states.
+const (
+	NodeCommandPending   = 0
+	NodeCommandDone      = 1
+	NodeCommandFailed    = 2
+	NodeCommandExpired   = 3
+	NodeCommandCancelled = 4
+)
+
+// NodeFlowSeq records a traffic report sequence number already applied for a
+// node, so a report the agent resends from its journal is counted once.
+type NodeFlowSeq struct {
+	ID          int64 `gorm:"primaryKey;autoIncrement"`
+	NodeID      int64 `gorm:"column:node_id;not null;uniqueIndex:idx_node_flow_seq_unique"`
+	Seq         int64 `gorm:"not null;uniqueIndex:idx_node_flow_seq_unique"`
+	CreatedTime int64 `gorm:"column:created_time;not null;index"`
+}
+
+func (NodeFlowSeq) TableName() string { return "node_flow_seq" }
+
+// NodeCA is the panel's internal certificate authority for node mutual TLS,
+// a single row. It also holds the server certificate of the node TLS
+// listener, whose public key agents pin. Keys are encrypted with the JWT
+// secret.
+type NodeCA struct {
+	ID          int64  `gorm:"primaryKey;autoIncrement"`
+	CertData    string `gorm:"column:cert_data;type:text;not null"`
+	KeyData     string `gorm:"column:key_data;type:text;not null"`
+	ServerCert  string `gorm:"column:server_cert;type:text;not null"`
+	ServerKey   string `gorm:"column:server_key;type:text;not null"`
+	CreatedTime int64  `gorm:"column:created_time;not null"`
+}
+
+func (NodeCA) TableName() string { return "node_ca" }
+
+// NodeCertificate is a client certificate issued to a node. While a node
+// holds an unexpired, unrevoked certificate it must connect over mutual TLS.
+type NodeCertificate struct {
+	ID          int64  `gorm:"primaryKey;autoIncrement"`
+	NodeID      int64  `gorm:"column:node_id;not null;index"`
+	Serial      string `gorm:"type:varchar(64);not null;uniqueIndex"`
+	Fingerprint string `gorm:"type:varchar(64);not null"`
+	NotAfter    int64  `gorm:"column:not_after;not null"`
+	Revoked     int    `gorm:"not null;default:0"`
+	CreatedTime int64  `gorm:"column:created_time;not null"`
+}
+
+func (NodeCertificate) TableName() string { return "node_certificate" }
+
+// AgentRelease is an agent binary hosted by the panel for one version and
+// architecture. The file lives under the release directory; SHA256 is
+// computed on upload and Signature is the detached signature supplied with
+// it, base64 encoded.
+type AgentRelease struct {
+	ID          int64  `gorm:"primaryKey;autoIncrement"`
+	Version     string `gorm:"type:varchar(64);not null;uniqueIndex:idx_agent_release_version_arch"`
+	Arch        string `gorm:"type:varchar(32);not null;uniqueIndex:idx_agent_release_version_arch"`
+	FileName    string `gorm:"column:file_name;type:varchar(255);not null"`
+	Size        int64  `gorm:"not null;default:0"`
+	SHA256      string `gorm:"column:sha256;type:varchar(64);not null"`
+	Signature   string `gorm:"type:text;not null;default:''"`
+	CreatedTime int64  `gorm:"column:created_time;not null"`
+}
+
+func (AgentRelease) TableName() string { return "agent_release" }
+
+// UpgradeRollout is a staged agent upgrade. NodeIDs is comma separated in
+// rollout order: the first CanarySize nodes form the canary wave and the
+// rest are upgraded WaveSize at a time. CurrentWave is the index of the
+// next wave to run and Results holds the per-node outcome as JSON.
+type UpgradeRollout struct {
+	ID            int64  `gorm:"primaryKey;autoIncrement"`
+	Version       string `gorm:"type:varchar(100);not null"`
+	Source        string `gorm:"type:varchar(16);not null;default:''"`
+	NodeIDs       string `gorm:"column:node_ids;type:text;not null"`
+	CanarySize    int    `gorm:"column:canary_size;not null;default:1"`
+	WaveSize      int    `gorm:"column:wave_size;not null;default:1"`
+	Concurrency   int    `gorm:"not null;default:1"`
+	FailureBudget int    `gorm:"column:failure_budget;not null;default:0"`
+	StableSeconds int    `gorm:"column:stable_seconds;not null;default:0"`
+	Status        string `gorm:"type:varchar(16);not null;index"`
+	CurrentWave   int    `gorm:"column:current_wave;not null;default:0"`
+	Results       string `gorm:"type:text;not null;default:''"`
+	Message       string `gorm:"type:text;not null;default:''"`
+	CreatedTime   int64  `gorm:"column:created_time;not null"`
+	UpdatedTime   int64  `gorm:"column:updated_time;not null"`
+}
+
+func (UpgradeRollout) TableName() string { return "upgrade_rollout" }
+
+// AlertRule is a node health condition evaluated by the panel. NodeIDs and
+// ChannelIDs are comma separated; no NodeIDs means every node. Duration is
+// how long the condition must hold before firing (for offline rules, how
+// long the node has been offline) and RecoverDuration how long it must be
+// clear before the alert resolves.
+type AlertRule struct {
+	ID              int64   `gorm:"primaryKey;autoIncrement"`
+	Name            string  `gorm:"type:varchar(100);not null"`
+	Type            string  `gorm:"type:varchar(32);not null"`
+	NodeIDs         string  `gorm:"column:node_ids;type:text;not null;default:''"`
+	Threshold       float64 `gorm:"not null;default:0"`
+	Duration        int     `gorm:"not null;default:0"`
+	RecoverDuration int     `gorm:"column:recover_duration;not null;default:0"`
+	CapacityMbps    int64   `gorm:"column:capacity_mbps;not null;default:0"`
+	ExpectedVersion string  `gorm:"column:expected_version;type:varchar(100);not null;default:''"`
+	ChannelIDs      string  `gorm:"column:channel_ids;type:text;not null;default:''"`
+	NotifyRecovery  int     `gorm:"column:notify_recovery;not null;default:0"`
+	Enabled         int     `gorm:"not null;default:0"`
+	CreatedTime     int64   `gorm:"column:created_time;not null"`
+	UpdatedTime     int64   `gorm:"column:updated_time;not null"`
+}
+
+func (AlertRule) TableName() string { return "alert_rule" }
+
+// AlertChannel is a notification target; Config is JSON whose fields
+// depend on Type.
+type AlertChannel struct {
+	ID          int64  `gorm:"primaryKey;autoIncrement"`
+	Name        string `gorm:"type:varchar(100);not null"`
+	Type        string `gorm:"type:varchar(32);not null"`
+	Config      string `gorm:"type:text;not null"`
+	Enabled     int    `gorm:"not null;default:0"`
+	CreatedTime int64  `gorm:"column:created_time;not null"`
+	UpdatedTime int64  `gorm:"column:updated_time;not null"`
+}
+
+func (AlertChannel) TableName() string { return "alert_channel" }
+
+// AlertEvent is one firing of a rule on a node; ResolvedTime stays 0 while
+// the alert is active.
+type AlertEvent struct {
+	ID           int64   `gorm:"primaryKey;autoIncrement"`
+	RuleID       int64   `gorm:"column:rule_id;not null;index"`
+	RuleName     string  `gorm:"column:rule_name;type:varchar(100);not null"`
+	RuleType     string  `gorm:"column:rule_type;type:varchar(32);not null"`
+	NodeID       int64   `gorm:"column:node_id;not null;index"`
+	NodeName     string  `gorm:"column:node_name;type:varchar(100);not null"`
+	Message      string  `gorm:"type:text;not null"`
+	Value        float64 `gorm:"not null;default:0"`
+	FiredTime    int64   `gorm:"column:fired_time;not null"`
+	ResolvedTime int64   `gorm:"column:resolved_time;not null;default:0"`
+	NotifyStatus string  `gorm:"column:notify_status;type:varchar(16);not null;default:''"`
+	NotifyError  string  `gorm:"column:notify_error;type:text;not null;default:''"`
+}
+
+func (AlertEvent) TableName() string { return "alert_event" }
+
 // ─── Backup / Import-Export Structs ──────────────────────────────────
 // These are not GORM models; they define the JSON wire format for the
 // backup/restore API and MUST keep their existing json tags unchanged.
@@ -330,44 +641,57 @@ type UserBackup struct {
 }
 
 type NodeBackup struct {
-	ID            int64  `json:"id"`
-	Name          string `json:"name"`
-	Secret        string `json:"secret"`
-	ServerIP      string `json:"serverIp"`
-	ServerIPv4    string `json:"serverIpV4,omitempty"`
-	ServerIPv6    string `json:"serverIpV6,omitempty"`
-	Port          string `json:"port"`
-	InterfaceName string `json:"interfaceName,omitempty"`
-	Version       string `json:"version,omitempty"`
-	HTTP          int    `json:"http"`
-	TLS           int    `json:"tls"`
-	Socks         int    `json:"socks"`
-	CreatedTime   int64  `json:"createdTime"`
-	UpdatedTime   int64  `json:"updatedTime,omitempty"`
-	Status        int    `json:"status"`
-	TCPListenAddr string `json:"tcpListenAddr"`
-	UDPListenAddr string `json:"udpListenAddr"`
-	Inx           int    `json:"inx"`
-	IsRemote      int    `json:"isRemote"`
-	RemoteURL     string `json:"remoteUrl,omitempty"`
-	RemoteToken   string `json:"remoteToken,omitempty"`
-	RemoteConfig  string `json:"remoteConfig,omitempty"`
+	ID             int64  `json:"id"`
+	Name           string `json:"name"`
+	Secret         string `json:"secret"`
+	ServerIP       string `json:"serverIp"`
+	ServerIPv4     string `json:"serverIpV4,omitempty"`
+	ServerIPv6     string `json:"serverIpV6,omitempty"`
+	Port           string `json:"port"`
+	InterfaceName  string `json:"interfaceName,omitempty"`
+	Version        string `json:"version,omitempty"`
+	HTTP           int    `json:"http"`
+	TLS            int    `json:"tls"`
+	Socks          int    `json:"socks"`
+	CreatedTime    int64  `json:"createdTime"`
+	UpdatedTime    int64  `json:"updatedTime,omitempty"`
+	Status         int    `json:"status"`
+	TCPListenAddr  string `json:"tcpListenAddr"`
+	UDPListenAddr  string `json:"udpListenAddr"`
+	Inx            int    `json:"inx"`
+	IsRemote       int    `json:"isRemote"`
+	RemoteURL      string `json:"remoteUrl,omitempty"`
+	RemoteToken    string `json:"remoteToken,omitempty"`
+	RemoteConfig   string `json:"remoteConfig,omitempty"`
+	AllowCIDRs     string `json:"allowCidrs,omitempty"`
+	DenyCIDRs      string `json:"denyCidrs,omitempty"`
+	Labels         string `json:"labels,omitempty"`
+	CapacityMbps   int64  `json:"capacityMbps,omitempty"`
+	MaxForwards    int    `json:"maxForwards,omitempty"`
+	AllowProtocols string `json:"allowProtocols,omitempty"`
+	DenyProtocols  string `json:"denyProtocols,omitempty"`
 }
 
 type TunnelBackup struct {
-	ID           int64               `json:"id"`
-	Name         string              `json:"name"`
-	TrafficRatio float64             `json:"trafficRatio"`
-	Type         int                 `json:"type"`
-	Protocol     string              `json:"protocol"`
-	Flow         int64               `json:"flow"`
-	CreatedTime  int64               `json:"createdTime"`
-	UpdatedTime  int64               `json:"updatedTime"`
-	Status       int                 `json:"status"`
-	InIP         string              `json:"inIp,omitempty"`
-	Inx          int                 `json:"inx"`
-	IPPreference string              `json:"ipPreference,omitempty"`
-	ChainTunnels []ChainTunnelBackup `json:"chainTunnels,omitempty"`
+	ID              int64               `json:"id"`
+	Name            string              `json:"name"`
+	TrafficRatio    float64             `json:"trafficRatio"`
+	Type            int                 `json:"type"`
+	Protocol        string              `json:"protocol"`
+	Flow            int64               `json:"flow"`
+	CreatedTime     int64               `json:"createdTime"`
+	UpdatedTime     int64               `json:"updatedTime"`
+	Status          int                 `json:"status"`
+	InIP            string              `json:"inIp,omitempty"`
+	Inx             int                 `json:"inx"`
+	IPPreference    string              `json:"ipPreference,omitempty"`
+	AllowCountries  string              `json:"allowCountries,omitempty"`
+	DenyCountries   string              `json:"denyCountries,omitempty"`
+	AllowProtocols  string              `json:"allowProtocols,omitempty"`
+	DenyProtocols   string              `json:"denyProtocols,omitempty"`
+	InNodeSelector  string              `json:"inNodeSelector,omitempty"`
+	OutNodeSelector string              `json:"outNodeSelector,omitempty"`
+	ChainTunnels    []ChainTunnelBackup `json:"chainTunnels,omitempty"`
 }
 
 type ChainTunnelBackup struct {
@@ -382,20 +706,36 @@ type ChainTunnelBackup struct {
 }
 
 type ForwardBackup struct {
-	ID           int64                `json:"id"`
-	UserID       int64                `json:"userId"`
-	UserName     string               `json:"userName"`
-	Name         string               `json:"name"`
-	TunnelID     int64                `json:"tunnelId"`
-	RemoteAddr   string               `json:"remoteAddr"`
-	Strategy     string               `json:"strategy"`
-	InFlow       int64                `json:"inFlow"`
-	OutFlow      int64                `json:"outFlow"`
-	CreatedTime  int64                `json:"createdTime"`
-	UpdatedTime  int64                `json:"updatedTime"`
-	Status       int                  `json:"status"`
-	Inx          int                  `json:"inx"`
-	ForwardPorts *[]ForwardPortBackup `json:"forwardPorts,omitempty"`
+	ID               int64                `json:"id"`
+	UserID           int64                `json:"userId"`
+	UserName         string               `json:"userName"`
+	Name             string               `json:"name"`
+	TunnelID         int64                `json:"tunnelId"`
+	RemoteAddr       string               `json:"remoteAddr"`
+	Strategy         string               `json:"strategy"`
+	InFlow           int64                `json:"inFlow"`
+	OutFlow          int64                `json:"outFlow"`
+	CreatedTime      int64                `json:"createdTime"`
+	UpdatedTime      int64                `json:"updatedTime"`
+	Status           int                  `json:"status"`
+	Inx              int                  `json:"inx"`
+	AllowCIDRs       string               `json:"allowCidrs,omitempty"`
+	DenyCIDRs        string               `json:"denyCidrs,omitempty"`
+	AllowCountries   string               `json:"allowCountries,omitempty"`
+	DenyCountries    string               `json:"denyCountries,omitempty"`
+	AllowProtocols   string               `json:"allowProtocols,omitempty"`
+	DenyProtocols    string               `json:"denyProtocols,omitempty"`
+	Domain           string               `json:"domain,omitempty"`
+	ProxyMode        string               `json:"proxyMode,omitempty"`
+	ProxyHost        string               `json:"proxyHost,omitempty"`
+	ProxyHeaders     string               `json:"proxyHeaders,omitempty"`
+	ScheduleStart    int64                `json:"scheduleStart,omitempty"`
+	ScheduleEnd      int64                `json:"scheduleEnd,omitempty"`
+	ScheduleWindows  string               `json:"scheduleWindows,omitempty"`
+	ScheduleTimezone string               `json:"scheduleTimezone,omitempty"`
+	ExpireTime       int64                `json:"expireTime,omitempty"`
+	ExpireAction     string               `json:"expireAction,omitempty"`
+	ForwardPorts     *[]ForwardPortBackup `json:"forwardPorts,omitempty"`
 }
 
 type ForwardPortBackup struct {
@@ -404,17 +744,19 @@ type ForwardPortBackup struct {
 }
 
 type UserTunnelBackup struct {
-	ID            int64 `json:"id"`
-	UserID        int64 `json:"userId"`
-	TunnelID      int64 `json:"tunnelId"`
-	SpeedID       int64 `json:"speedId,omitempty"`
-	Num           int   `json:"num"`
-	Flow          int64 `json:"flow"`
-	InFlow        int64 `json:"inFlow"`
-	OutFlow       int64 `json:"outFlow"`
-	FlowResetTime int64 `json:"flowResetTime"`
-	ExpTime       int64 `json:"expTime"`
-	Status        int   `json:"status"`
+	ID            int64  `json:"id"`
+	UserID        int64  `json:"userId"`
+	TunnelID      int64  `json:"tunnelId"`
+	SpeedID       int64  `json:"speedId,omitempty"`
+	Num           int    `json:"num"`
+	Flow          int64  `json:"flow"`
+	InFlow        int64  `json:"inFlow"`
+	OutFlow       int64  `json:"outFlow"`
+	FlowResetTime int64  `json:"flowResetTime"`
+	ExpTime       int64  `json:"expTime"`
+	Status        int    `json:"status"`
+	AllowCIDRs    string `json:"allowCidrs,omitempty"`
+	DenyCIDRs     string `json:"denyCidrs,omitempty"`
 }
 
 type SpeedLimitBackup struct {
@@ -492,6 +834,15 @@ type ForwardRecord struct {
 	RemoteAddr string
 	Strategy   string
 	Status     int
+	// Domain is set for forwards sharing a vhost listener (routed by TLS
+	// SNI or HTTP Host) instead of owning a dedicated port.
+	Domain string
+	// ProxyMode "http" turns the forward into an HTTP reverse proxy,
+	// terminating TLS with CertificateID when set.
+	ProxyMode     string
+	CertificateID int64
+	ProxyHost     string
+	ProxyHeaders  string
 }
 
 // TunnelRecord is a minimal tunnel view used by control plane.
@@ -525,6 +876,8 @@ type NodeRecord struct {
 	RemoteURL     string
 	RemoteToken   string
 	RemoteConfig  string
+	Maintenance   int
+	MaxForwards   int
 }
 
 type ChainNodeRecord struct {
@@ -537,6 +890,27 @@ type ChainNodeRecord struct {
 	Strategy  string
 }
 
+// SourceACL holds the raw source-IP allow/deny lists configured on one
+// scope (node, user tunnel or forward). Entries are newline separated.
+type SourceACL struct {
+	AllowCIDRs string
+	DenyCIDRs  string
+}
+
+// GeoACL holds the raw country allow/deny lists configured on one scope
+// (tunnel or forward).
+type GeoACL struct {
+	AllowCountries string
+	DenyCountries  string
+}
+
+// ProtocolACL holds the raw protocol allow/deny lists configured on one
+// scope (node, tunnel or forward).
+type ProtocolACL struct {
+	AllowProtocols string
+	DenyProtocols  string
+}
+
 type UserTunnelLimiterInfo struct {
 	UserTunnelID int64
 	LimiterID    *int64
@@ -573,6 +947,8 @@ type UserTunnelDetail struct {
 	SpeedID       sql.NullInt64
 	SpeedLimit    sql.NullString
 	Speed         sql.NullInt64
+	AllowCIDRs    string `gorm:"column:allow_cidrs"`
+	DenyCIDRs     string `gorm:"column:deny_cidrs"`
 }
 
 // UserForwardDetail is a joined view of forward + tunnel.
@@ -61,6 +61,18 @@ func (r *Repository) DB() *gorm.DB {
 	return r.db
 }
 
+// Transaction runs fn with a repository bound to a single transaction. fn
+// must only use tx: the pool holds one connection, so calls on r inside fn
+// would block until the transaction ends.
+func (r *Repository) Transaction(fn func(tx *Repository) error) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Transaction(func(db *gorm.DB) error {
+		return fn(&Repository{db: db})
+	})
+}
+
 // ─── Open / Close ────────────────────────────────────────────────────
 
 func Open(path string) (*Repository, error) {
@@ -179,6 +191,19 @@ func autoMigrateAll(db *gorm.DB) error {
 		&model.PeerShare{},
 		&model.PeerShareRuntime{},
 		&model.FederationTunnelBinding{},
+		&model.Certificate{},
+		&model.NodeMetric{},
+		&model.NodeMaintenanceMove{},
+		&model.NodeEnrollToken{},
+		&model.NodeCommand{},
+		&model.NodeFlowSeq{},
+		&model.NodeCA{},
+		&model.NodeCertificate{},
+		&model.AgentRelease{},
+		&model.UpgradeRollout{},
+		&model.AlertRule{},
+		&model.AlertChannel{},
+		&model.AlertEvent{},
 		&model.Announcement{},
 		&model.SchemaVersion{},
 	}
@@ -260,7 +285,7 @@ func prepareSQLiteLegacyColumns(db *gorm.DB) error {
 	m := db.Migrator()
 
 	if m.HasTable(&model.Node{}) {
-		for _, field := range []string{"ServerIPV4", "ServerIPV6", "Inx", "IsRemote", "RemoteURL", "RemoteToken", "RemoteConfig"} {
+		for _, field := range []string{"ServerIPV4", "ServerIPV6", "Inx", "IsRemote", "RemoteURL", "RemoteToken", "RemoteConfig", "AllowCIDRs", "DenyCIDRs", "DisconnectedTime", "Arch", "Maintenance", "MaintenanceTime", "Labels", "CapacityMbps", "MaxForwards", "EnrollTokenID", "EnrollID", "AllowProtocols", "DenyProtocols", "ProtocolBlocked"} {
 			if m.HasColumn(&model.Node{}, field) {
 				continue
 			}
@@ -268,10 +293,15 @@ func prepareSQLiteLegacyColumns(db *gorm.DB) error {
 				return fmt.Errorf("add node.%s: %w", field, err)
 			}
 		}
+		if !m.HasIndex(&model.Node{}, "idx_node_enroll") {
+			if err := m.CreateIndex(&model.Node{}, "idx_node_enroll"); err != nil {
+				return fmt.Errorf("add node index idx_node_enroll: %w", err)
+			}
+		}
 	}
 
 	if m.HasTable(&model.Tunnel{}) {
-		for _, field := range []string{"Inx", "IPPreference"} {
+		for _, field := range []string{"Inx", "IPPreference", "AllowCountries", "DenyCountries", "InNodeSelector", "OutNodeSelector", "AllowProtocols", "DenyProtocols"} {
 			if m.HasColumn(&model.Tunnel{}, field) {
 				continue
 			}
@@ -447,7 +477,7 @@ func (r *Repository) GetUserPackageTunnels(userID int64) ([]model.UserTunnelDeta
 	}
 	var items []model.UserTunnelDetail
 	err := r.db.Model(&model.UserTunnel{}).
-		Select("user_tunnel.id, user_tunnel.user_id, user_tunnel.tunnel_id, tunnel.name AS tunnel_name, tunnel.flow AS tunnel_flow, user_tunnel.flow, user_tunnel.in_flow, user_tunnel.out_flow, user_tunnel.num, user_tunnel.flow_reset_time, user_tunnel.exp_time, user_tunnel.speed_id, speed_limit.name AS speed_limit, speed_limit.speed").
+		Select("user_tunnel.id, user_tunnel.user_id, user_tunnel.tunnel_id, tunnel.name AS tunnel_name, tunnel.flow AS tunnel_flow, user_tunnel.flow, user_tunnel.in_flow, user_tunnel.out_flow, user_tunnel.num, user_tunnel.flow_reset_time, user_tunnel.exp_time, user_tunnel.speed_id, speed_limit.name AS speed_limit, speed_limit.speed, user_tunnel.allow_cidrs, user_tunnel.deny_cidrs").
 		Joins("LEFT JOIN tunnel ON tunnel.id = user_tunnel.tunnel_id").
 		Joins("LEFT JOIN speed_limit ON speed_limit.id = user_tunnel.speed_id").
 		Where("user_tunnel.user_id = ?", userID).
@@ -567,23 +597,28 @@ func (r *Repository) GetNodeByID(id int64) (*model.Node, error) {
 	return &n, nil
 }
 
-func (r *Repository) UpdateNodeOnline(nodeID int64, status int, version string, httpVal, tlsVal, socksVal int) error {
+func (r *Repository) UpdateNodeOnline(nodeID int64, status int, version, arch string, httpVal, tlsVal, socksVal int) error {
 	if r == nil || r.db == nil {
 		return errors.New("repository not initialized")
 	}
 	return r.db.Model(&model.Node{}).Where("id = ?", nodeID).Updates(map[string]interface{}{
-		"status": status, "version": version, "http": httpVal, "tls": tlsVal,
+		"status": status, "version": version, "arch": arch, "http": httpVal, "tls": tlsVal,
 		"socks": socksVal, "updated_time": unixMilliNow(),
 	}).Error
 }
 
+// UpdateNodeStatus sets a node's online status; going offline also records
+// the disconnect time.
 func (r *Repository) UpdateNodeStatus(nodeID int64, status int) error {
 	if r == nil || r.db == nil {
 		return errors.New("repository not initialized")
 	}
-	return r.db.Model(&model.Node{}).Where("id = ?", nodeID).Updates(map[string]interface{}{
-		"status": status, "updated_time": unixMilliNow(),
-	}).Error
+	now := unixMilliNow()
+	fields := map[string]interface{}{"status": status, "updated_time": now}
+	if status == 0 {
+		fields["disconnected_time"] = now
+	}
+	return r.db.Model(&model.Node{}).Where("id = ?", nodeID).Updates(fields).Error
 }
 
 // ─── Flow ────────────────────────────────────────────────────────────
@@ -646,6 +681,10 @@ func (r *Repository) ListNodes() ([]map[string]interface{}, error) {
 			"remoteUrl":    nullableString(n.RemoteURL),
 			"remoteToken":  nullableString(n.RemoteToken),
 			"remoteConfig": nullableString(n.RemoteConfig),
+			"allowCidrs":   n.AllowCIDRs, "denyCidrs": n.DenyCIDRs,
+			"maintenance": n.Maintenance, "maintenanceTime": n.MaintenanceTime,
+			"labels": decodeNodeLabels(n.Labels), "capacityMbps": n.CapacityMbps, "maxForwards": n.MaxForwards,
+			"allowProtocols": n.AllowProtocols, "denyProtocols": n.DenyProtocols, "protocolBlocked": n.ProtocolBlocked,
 		})
 	}
 	return items, nil
@@ -699,24 +738,43 @@ func (r *Repository) ListForwards() ([]map[string]interface{}, error) {
 	}
 
 	type fwdRow struct {
-		ID          int64
-		UserID      int64
-		UserName    string
-		Name        string
-		TunnelID    int64
-		TunnelName  string
-		RemoteAddr  string
-		Strategy    string
-		InFlow      int64
-		OutFlow     int64
-		CreatedTime int64
-		Status      int
-		Inx         int
+		ID               int64
+		UserID           int64
+		UserName         string
+		Name             string
+		TunnelID         int64
+		TunnelName       string
+		RemoteAddr       string
+		Strategy         string
+		InFlow           int64
+		OutFlow          int64
+		CreatedTime      int64
+		Status           int
+		Inx              int
+		AllowCIDRs       string `gorm:"column:allow_cidrs"`
+		DenyCIDRs        string `gorm:"column:deny_cidrs"`
+		AllowCountries   string
+		DenyCountries    string
+		GeoRejected      int64
+		Domain           string
+		ProxyMode        string
+		CertificateID    int64
+		ProxyHost        string
+		ProxyHeaders     string
+		ScheduleStart    int64
+		ScheduleEnd      int64
+		ScheduleWindows  string
+		ScheduleTimezone string
+		ExpireTime       int64
+		ExpireAction     string
+		AllowProtocols   string
+		DenyProtocols    string
+		ProtocolBlocked  int64
 	}
 
 	var rows []fwdRow
 	err := r.db.Model(&model.Forward{}).
-		Select("forward.id, forward.user_id, forward.user_name, forward.name, forward.tunnel_id, COALESCE(tunnel.name, '') AS tunnel_name, forward.remote_addr, COALESCE(forward.strategy, 'fifo') AS strategy, forward.in_flow, forward.out_flow, forward.created_time, forward.status, forward.inx").
+		Select("forward.id, forward.user_id, forward.user_name, forward.name, forward.tunnel_id, COALESCE(tunnel.name, '') AS tunnel_name, forward.remote_addr, COALESCE(forward.strategy, 'fifo') AS strategy, forward.in_flow, forward.out_flow, forward.created_time, forward.status, forward.inx, forward.allow_cidrs, forward.deny_cidrs, forward.allow_countries, forward.deny_countries, forward.geo_rejected, forward.domain, forward.proxy_mode, forward.certificate_id, forward.proxy_host, forward.proxy_headers, forward.schedule_start, forward.schedule_end, forward.schedule_windows, forward.schedule_timezone, forward.expire_time, forward.expire_action, forward.allow_protocols, forward.deny_protocols, forward.protocol_blocked").
 		Joins("LEFT JOIN tunnel ON tunnel.id = forward.tunnel_id").
 		Order("forward.inx ASC, forward.id ASC").
 		Find(&rows).Error
@@ -737,6 +795,16 @@ func (r *Repository) ListForwards() ([]map[string]interface{}, error) {
 			"remoteAddr": row.RemoteAddr, "strategy": row.Strategy,
 			"inFlow": row.InFlow, "outFlow": row.OutFlow,
 			"createdTime": row.CreatedTime, "status": row.Status, "inx": int64(row.Inx),
+			"allowCidrs": row.AllowCIDRs, "denyCidrs": row.DenyCIDRs,
+			"allowCountries": row.AllowCountries, "denyCountries": row.DenyCountries,
+			"geoRejected": row.GeoRejected, "domain": row.Domain,
+			"proxyMode": row.ProxyMode, "certificateId": row.CertificateID,
+			"proxyHost": row.ProxyHost, "proxyHeaders": row.ProxyHeaders,
+			"scheduleStart": row.ScheduleStart, "scheduleEnd": row.ScheduleEnd,
+			"scheduleWindows": row.ScheduleWindows, "scheduleTimezone": row.ScheduleTimezone,
+			"expireTime": row.ExpireTime, "expireAction": row.ExpireAction,
+			"allowProtocols": row.AllowProtocols, "denyProtocols": row.DenyProtocols,
+			"protocolBlocked": row.ProtocolBlocked,
 		})
 	}
 	return items, nil
@@ -807,11 +875,17 @@ func (r *Repository) ListTunnels() ([]map[string]interface{}, error) {
 			"id": t.ID, "inx": t.Inx, "name": t.Name,
 			"type": t.Type, "flow": t.Flow, "trafficRatio": t.TrafficRatio,
 			"status": t.Status, "createdTime": t.CreatedTime,
-			"inIp":         nullableString(t.InIP),
-			"ipPreference": t.IPPreference,
-			"inNodeId":     make([]map[string]interface{}, 0),
-			"outNodeId":    make([]map[string]interface{}, 0),
-			"chainNodes":   make([][]map[string]interface{}, 0),
+			"inIp":            nullableString(t.InIP),
+			"ipPreference":    t.IPPreference,
+			"allowCountries":  t.AllowCountries,
+			"denyCountries":   t.DenyCountries,
+			"allowProtocols":  t.AllowProtocols,
+			"denyProtocols":   t.DenyProtocols,
+			"inNodeSelector":  t.InNodeSelector,
+			"outNodeSelector": t.OutNodeSelector,
+			"inNodeId":        make([]map[string]interface{}, 0),
+			"outNodeId":       make([]map[string]interface{}, 0),
+			"chainNodes":      make([][]map[string]interface{}, 0),
 		}
 		orderedIDs = append(orderedIDs, t.ID)
 	}
@@ -1495,6 +1569,9 @@ func (r *Repository) exportNodes() ([]model.NodeBackup, error) {
 			CreatedTime: n.CreatedTime, Status: n.Status,
 			TCPListenAddr: n.TCPListenAddr, UDPListenAddr: n.UDPListenAddr,
 			Inx: n.Inx, IsRemote: n.IsRemote,
+			AllowCIDRs: n.AllowCIDRs, DenyCIDRs: n.DenyCIDRs,
+			Labels: n.Labels, CapacityMbps: n.CapacityMbps, MaxForwards: n.MaxForwards,
+			AllowProtocols: n.AllowProtocols, DenyProtocols: n.DenyProtocols,
 		}
 		if n.UpdatedTime.Valid {
 			b.UpdatedTime = n.UpdatedTime.Int64
@@ -1537,6 +1614,9 @@ func (r *Repository) exportTunnels() ([]model.TunnelBackup, error) {
 			Type: t.Type, Protocol: t.Protocol, Flow: t.Flow,
 			CreatedTime: t.CreatedTime, UpdatedTime: t.UpdatedTime,
 			Status: t.Status, Inx: t.Inx, IPPreference: t.IPPreference,
+			AllowCountries: t.AllowCountries, DenyCountries: t.DenyCountries,
+			AllowProtocols: t.AllowProtocols, DenyProtocols: t.DenyProtocols,
+			InNodeSelector: t.InNodeSelector, OutNodeSelector: t.OutNodeSelector,
 		}
 		if t.InIP.Valid {
 			b.InIP = t.InIP.String
@@ -1590,6 +1670,14 @@ func (r *Repository) exportForwards() ([]model.ForwardBackup, error) {
 			TunnelID: f.TunnelID, RemoteAddr: f.RemoteAddr, Strategy: f.Strategy,
 			InFlow: f.InFlow, OutFlow: f.OutFlow, CreatedTime: f.CreatedTime,
 			UpdatedTime: f.UpdatedTime, Status: f.Status, Inx: f.Inx,
+			AllowCIDRs: f.AllowCIDRs, DenyCIDRs: f.DenyCIDRs,
+			AllowCountries: f.AllowCountries, DenyCountries: f.DenyCountries,
+			AllowProtocols: f.AllowProtocols, DenyProtocols: f.DenyProtocols,
+			Domain: f.Domain, ProxyMode: f.ProxyMode, ProxyHost: f.ProxyHost,
+			ProxyHeaders: f.ProxyHeaders, ScheduleStart: f.ScheduleStart,
+			ScheduleEnd: f.ScheduleEnd, ScheduleWindows: f.ScheduleWindows,
+			ScheduleTimezone: f.ScheduleTimezone, ExpireTime: f.ExpireTime,
+			ExpireAction: f.ExpireAction,
 		}
 		ports, err := r.exportForwardPorts(f.ID)
 		if err != nil {
@@ -1625,6 +1713,7 @@ func (r *Repository) exportUserTunnels() ([]model.UserTunnelBackup, error) {
 			ID: ut.ID, UserID: ut.UserID, TunnelID: ut.TunnelID,
 			Num: ut.Num, Flow: ut.Flow, InFlow: ut.InFlow, OutFlow: ut.OutFlow,
 			FlowResetTime: ut.FlowResetTime, ExpTime: ut.ExpTime, Status: ut.Status,
+			AllowCIDRs: ut.AllowCIDRs, DenyCIDRs: ut.DenyCIDRs,
 		}
 		if ut.SpeedID.Valid {
 			b.SpeedID = ut.SpeedID.Int64
@@ -1844,35 +1933,43 @@ func importNodes(tx *gorm.DB, nodes []model.NodeBackup, now int64) (int, error)
 	count := 0
 	for _, n := range nodes {
 		item := model.Node{
-			ID:            n.ID,
-			Name:          n.Name,
-			Secret:        n.Secret,
-			ServerIP:      n.ServerIP,
-			ServerIPV4:    sql.NullString{String: n.ServerIPv4, Valid: true},
-			ServerIPV6:    sql.NullString{String: n.ServerIPv6, Valid: true},
-			Port:          n.Port,
-			InterfaceName: sql.NullString{String: n.InterfaceName, Valid: true},
-			Version:       sql.NullString{String: n.Version, Valid: true},
-			HTTP:          n.HTTP,
-			TLS:           n.TLS,
-			Socks:         n.Socks,
-			CreatedTime:   n.CreatedTime,
-			UpdatedTime:   sql.NullInt64{Int64: now, Valid: true},
-			Status:        n.Status,
-			TCPListenAddr: n.TCPListenAddr,
-			UDPListenAddr: n.UDPListenAddr,
-			Inx:           n.Inx,
-			IsRemote:      n.IsRemote,
-			RemoteURL:     sql.NullString{String: n.RemoteURL, Valid: true},
-			RemoteToken:   sql.NullString{String: n.RemoteToken, Valid: true},
-			RemoteConfig:  sql.NullString{String: n.RemoteConfig, Valid: true},
+			ID:             n.ID,
+			Name:           n.Name,
+			Secret:         n.Secret,
+			ServerIP:       n.ServerIP,
+			ServerIPV4:     sql.NullString{String: n.ServerIPv4, Valid: true},
+			ServerIPV6:     sql.NullString{String: n.ServerIPv6, Valid: true},
+			Port:           n.Port,
+			InterfaceName:  sql.NullString{String: n.InterfaceName, Valid: true},
+			Version:        sql.NullString{String: n.Version, Valid: true},
+			HTTP:           n.HTTP,
+			TLS:            n.TLS,
+			Socks:          n.Socks,
+			CreatedTime:    n.CreatedTime,
+			UpdatedTime:    sql.NullInt64{Int64: now, Valid: true},
+			Status:         n.Status,
+			TCPListenAddr:  n.TCPListenAddr,
+			UDPListenAddr:  n.UDPListenAddr,
+			Inx:            n.Inx,
+			IsRemote:       n.IsRemote,
+			RemoteURL:      sql.NullString{String: n.RemoteURL, Valid: true},
+			RemoteToken:    sql.NullString{String: n.RemoteToken, Valid: true},
+			RemoteConfig:   sql.NullString{String: n.RemoteConfig, Valid: true},
+			AllowCIDRs:     n.AllowCIDRs,
+			DenyCIDRs:      n.DenyCIDRs,
+			Labels:         n.Labels,
+			CapacityMbps:   n.CapacityMbps,
+			MaxForwards:    n.MaxForwards,
+			AllowProtocols: n.AllowProtocols,
+			DenyProtocols:  n.DenyProtocols,
 		}
 		err := tx.Clauses(clause.OnConflict{
 			Columns: []clause.Column{{Name: "id"}},
 			DoUpdates: clause.AssignmentColumns([]string{
 				"name", "secret", "server_ip", "server_ip_v4", "server_ip_v6", "port", "interface_name", "version",
 				"http", "tls", "socks", "updated_time", "status", "tcp_listen_addr", "udp_listen_addr",
-				"inx", "is_remote", "remote_url", "remote_token", "remote_config",
+				"inx", "is_remote", "remote_url", "remote_token", "remote_config", "allow_cidrs", "deny_cidrs",
+				"labels", "capacity_mbps", "max_forwards", "allow_protocols", "deny_protocols",
 			}),
 		}).Create(&item).Error
 		if err != nil {
@@ -1887,23 +1984,30 @@ func importTunnels(tx *gorm.DB, tunnels []model.TunnelBackup, now int64) (int, e
 	count := 0
 	for _, t := range tunnels {
 		item := model.Tunnel{
-			ID:           t.ID,
-			Name:         t.Name,
-			TrafficRatio: t.TrafficRatio,
-			Type:         t.Type,
-			Protocol:     t.Protocol,
-			Flow:         t.Flow,
-			CreatedTime:  t.CreatedTime,
-			UpdatedTime:  now,
-			Status:       t.Status,
-			InIP:         sql.NullString{String: t.InIP, Valid: true},
-			Inx:          t.Inx,
-			IPPreference: t.IPPreference,
+			ID:              t.ID,
+			Name:            t.Name,
+			TrafficRatio:    t.TrafficRatio,
+			Type:            t.Type,
+			Protocol:        t.Protocol,
+			Flow:            t.Flow,
+			CreatedTime:     t.CreatedTime,
+			UpdatedTime:     now,
+			Status:          t.Status,
+			InIP:            sql.NullString{String: t.InIP, Valid: true},
+			Inx:             t.Inx,
+			IPPreference:    t.IPPreference,
+			AllowCountries:  t.AllowCountries,
+			DenyCountries:   t.DenyCountries,
+			AllowProtocols:  t.AllowProtocols,
+			DenyProtocols:   t.DenyProtocols,
+			InNodeSelector:  t.InNodeSelector,
+			OutNodeSelector: t.OutNodeSelector,
 		}
 		err := tx.Clauses(clause.OnConflict{
 			Columns: []clause.Column{{Name: "id"}},
 			DoUpdates: clause.AssignmentColumns([]string{
 				"name", "traffic_ratio", "type", "protocol", "flow", "updated_time", "status", "in_ip", "inx", "ip_preference",
+				"allow_countries", "deny_countries", "allow_protocols", "deny_protocols", "in_node_selector", "out_node_selector",
 			}),
 		}).Create(&item).Error
 		if err != nil {
@@ -1939,25 +2043,44 @@ func importForwards(tx *gorm.DB, forwards []model.ForwardBackup, now int64) (int
 	count := 0
 	for _, f := range forwards {
 		item := model.Forward{
-			ID:          f.ID,
-			UserID:      f.UserID,
-			UserName:    f.UserName,
-			Name:        f.Name,
-			TunnelID:    f.TunnelID,
-			RemoteAddr:  f.RemoteAddr,
-			Strategy:    f.Strategy,
-			InFlow:      f.InFlow,
-			OutFlow:     f.OutFlow,
-			CreatedTime: f.CreatedTime,
-			UpdatedTime: now,
-			Status:      f.Status,
-			Inx:         f.Inx,
+			ID:               f.ID,
+			UserID:           f.UserID,
+			UserName:         f.UserName,
+			Name:             f.Name,
+			TunnelID:         f.TunnelID,
+			RemoteAddr:       f.RemoteAddr,
+			Strategy:         f.Strategy,
+			InFlow:           f.InFlow,
+			OutFlow:          f.OutFlow,
+			CreatedTime:      f.CreatedTime,
+			UpdatedTime:      now,
+			Status:           f.Status,
+			Inx:              f.Inx,
+			AllowCIDRs:       f.AllowCIDRs,
+			DenyCIDRs:        f.DenyCIDRs,
+			AllowCountries:   f.AllowCountries,
+			DenyCountries:    f.DenyCountries,
+			AllowProtocols:   f.AllowProtocols,
+			DenyProtocols:    f.DenyProtocols,
+			Domain:           f.Domain,
+			ProxyMode:        f.ProxyMode,
+			ProxyHost:        f.ProxyHost,
+			ProxyHeaders:     f.ProxyHeaders,
+			ScheduleStart:    f.ScheduleStart,
+			ScheduleEnd:      f.ScheduleEnd,
+			ScheduleWindows:  f.ScheduleWindows,
+			ScheduleTimezone: f.ScheduleTimezone,
+			ExpireTime:       f.ExpireTime,
+			ExpireAction:     f.ExpireAction,
 		}
 		err := tx.Clauses(clause.OnConflict{
 			Columns: []clause.Column{{Name: "id"}},
 			DoUpdates: clause.AssignmentColumns([]string{
 				"user_id", "user_name", "name", "tunnel_id", "remote_addr", "strategy",
-				"in_flow", "out_flow", "updated_time", "status", "inx",
+				"in_flow", "out_flow", "updated_time", "status", "inx", "allow_cidrs", "deny_cidrs",
+				"allow_countries", "deny_countries", "domain", "proxy_mode", "proxy_host",
+				"proxy_headers", "schedule_start", "schedule_end", "schedule_windows",
+				"schedule_timezone", "expire_time", "expire_action", "allow_protocols", "deny_protocols",
 			}),
 		}).Create(&item).Error
 		if err != nil {
@@ -1993,12 +2116,14 @@ func importUserTunnels(tx *gorm.DB, userTunnels []model.UserTunnelBackup, _ int6
 			FlowResetTime: ut.FlowResetTime,
 			ExpTime:       ut.ExpTime,
 			Status:        ut.Status,
+			AllowCIDRs:    ut.AllowCIDRs,
+			DenyCIDRs:     ut.DenyCIDRs,
 		}
 		err := tx.Clauses(clause.OnConflict{
 			Columns: []clause.Column{{Name: "id"}},
 			DoUpdates: clause.AssignmentColumns([]string{
 				"user_id", "tunnel_id", "speed_id", "num", "flow", "in_flow", "out_flow",
-				"flow_reset_time", "exp_time", "status",
+				"flow_reset_time", "exp_time", "status", "allow_cidrs", "deny_cidrs",
 			}),
 		}).Create(&item).Error
 		if err != nil {
@@ -0,0 +1,298 @@
+package repo
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+
+	"go-backend/internal/store/model"
+)
+
+// GetForwardSourceACL returns the source-IP lists configured on a forward, or nil if it does not exist.
+func (r *Repository) GetForwardSourceACL(forwardID int64) (*model.SourceACL, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var f model.Forward
+	err := r.db.Select("allow_cidrs", "deny_cidrs").Where("id = ?", forwardID).First(&f).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &model.SourceACL{AllowCIDRs: f.AllowCIDRs, DenyCIDRs: f.DenyCIDRs}, nil
+}
+
+// GetUserTunnelSourceACL returns the source-IP lists of the user's permission on a tunnel, or nil if none exists.
+func (r *Repository) GetUserTunnelSourceACL(userID, tunnelID int64) (*model.SourceACL, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var ut model.UserTunnel
+	err := r.db.Select("allow_cidrs", "deny_cidrs").
+		Where("user_id = ? AND tunnel_id = ?", userID, tunnelID).
+		Order("id ASC").
+		First(&ut).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &model.SourceACL{AllowCIDRs: ut.AllowCIDRs, DenyCIDRs: ut.DenyCIDRs}, nil
+}
+
+// GetNodeSourceACL returns the source-IP lists configured on a node, or nil if it does not exist.
+func (r *Repository) GetNodeSourceACL(nodeID int64) (*model.SourceACL, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var n model.Node
+	err := r.db.Select("allow_cidrs", "deny_cidrs").Where("id = ?", nodeID).First(&n).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &model.SourceACL{AllowCIDRs: n.AllowCIDRs, DenyCIDRs: n.DenyCIDRs}, nil
+}
+
+// UpdateForwardSourceACL replaces the source-IP lists of a forward.
+func (r *Repository) UpdateForwardSourceACL(forwardID int64, allowCIDRs, denyCIDRs string, now int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Model(&model.Forward{}).
+		Where("id = ?", forwardID).
+		Updates(map[string]interface{}{
+			"allow_cidrs":  allowCIDRs,
+			"deny_cidrs":   denyCIDRs,
+			"updated_time": now,
+		}).Error
+}
+
+// UpdateUserTunnelSourceACL replaces the source-IP lists of a user tunnel permission.
+func (r *Repository) UpdateUserTunnelSourceACL(userTunnelID int64, allowCIDRs, denyCIDRs string) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Model(&model.UserTunnel{}).
+		Where("id = ?", userTunnelID).
+		Updates(map[string]interface{}{
+			"allow_cidrs": allowCIDRs,
+			"deny_cidrs":  denyCIDRs,
+		}).Error
+}
+
+// UpdateNodeSourceACL replaces the source-IP lists of a node.
+func (r *Repository) UpdateNodeSourceACL(nodeID int64, allowCIDRs, denyCIDRs string, now int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Model(&model.Node{}).
+		Where("id = ?", nodeID).
+		Updates(map[string]interface{}{
+			"allow_cidrs":  allowCIDRs,
+			"deny_cidrs":   denyCIDRs,
+			"updated_time": now,
+		}).Error
+}
+
+// ListForwardIDsByNode returns the ids of all forwards with an entry port on the node, paused ones included.
+func (r *Repository) ListForwardIDsByNode(nodeID int64) ([]int64, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var ids []int64
+	err := r.db.Model(&model.ForwardPort{}).
+		Where("node_id = ?", nodeID).
+		Distinct("forward_id").
+		Order("forward_id ASC").
+		Pluck("forward_id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// GetForwardGeoACL returns the country lists configured on a forward, or nil if it does not exist.
+func (r *Repository) GetForwardGeoACL(forwardID int64) (*model.GeoACL, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var f model.Forward
+	err := r.db.Select("allow_countries", "deny_countries").Where("id = ?", forwardID).First(&f).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &model.GeoACL{AllowCountries: f.AllowCountries, DenyCountries: f.DenyCountries}, nil
+}
+
+// GetTunnelGeoACL returns the country lists configured on a tunnel, or nil if it does not exist.
+func (r *Repository) GetTunnelGeoACL(tunnelID int64) (*model.GeoACL, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var t model.Tunnel
+	err := r.db.Select("allow_countries", "deny_countries").Where("id = ?", tunnelID).First(&t).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &model.GeoACL{AllowCountries: t.AllowCountries, DenyCountries: t.DenyCountries}, nil
+}
+
+// UpdateForwardGeoACL replaces the country lists of a forward.
+func (r *Repository) UpdateForwardGeoACL(forwardID int64, allowCountries, denyCountries string, now int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Model(&model.Forward{}).
+		Where("id = ?", forwardID).
+		Updates(map[string]interface{}{
+			"allow_countries": allowCountries,
+			"deny_countries":  denyCountries,
+			"updated_time":    now,
+		}).Error
+}
+
+// UpdateTunnelGeoACL replaces the country lists of a tunnel.
+func (r *Repository) UpdateTunnelGeoACL(tunnelID int64, allowCountries, denyCountries string, now int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Model(&model.Tunnel{}).
+		Where("id = ?", tunnelID).
+		Updates(map[string]interface{}{
+			"allow_countries": allowCountries,
+			"deny_countries":  denyCountries,
+			"updated_time":    now,
+		}).Error
+}
+
+// AddForwardGeoRejected adds to the number of connections a forward's country rules have rejected.
+func (r *Repository) AddForwardGeoRejected(forwardID, count int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Model(&model.Forward{}).
+		Where("id = ?", forwardID).
+		UpdateColumn("geo_rejected", gorm.Expr("geo_rejected + ?", count)).Error
+}
+
+// GetNodeProtocolACL returns the protocol lists configured on a node, or nil if it does not exist.
+func (r *Repository) GetNodeProtocolACL(nodeID int64) (*model.ProtocolACL, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var n model.Node
+	err := r.db.Select("allow_protocols", "deny_protocols").Where("id = ?", nodeID).First(&n).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &model.ProtocolACL{AllowProtocols: n.AllowProtocols, DenyProtocols: n.DenyProtocols}, nil
+}
+
+// GetTunnelProtocolACL returns the protocol lists configured on a tunnel, or nil if it does not exist.
+func (r *Repository) GetTunnelProtocolACL(tunnelID int64) (*model.ProtocolACL, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var t model.Tunnel
+	err := r.db.Select("allow_protocols", "deny_protocols").Where("id = ?", tunnelID).First(&t).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &model.ProtocolACL{AllowProtocols: t.AllowProtocols, DenyProtocols: t.DenyProtocols}, nil
+}
+
+// GetForwardProtocolACL returns the protocol lists configured on a forward, or nil if it does not exist.
+func (r *Repository) GetForwardProtocolACL(forwardID int64) (*model.ProtocolACL, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var f model.Forward
+	err := r.db.Select("allow_protocols", "deny_protocols").Where("id = ?", forwardID).First(&f).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &model.ProtocolACL{AllowProtocols: f.AllowProtocols, DenyProtocols: f.DenyProtocols}, nil
+}
+
+// UpdateNodeProtocolACL replaces the protocol lists of a node.
+func (r *Repository) UpdateNodeProtocolACL(nodeID int64, allowProtocols, denyProtocols string, now int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Model(&model.Node{}).
+		Where("id = ?", nodeID).
+		Updates(map[string]interface{}{
+			"allow_protocols": allowProtocols,
+			"deny_protocols":  denyProtocols,
+			"updated_time":    now,
+		}).Error
+}
+
+// UpdateTunnelProtocolACL replaces the protocol lists of a tunnel.
+func (r *Repository) UpdateTunnelProtocolACL(tunnelID int64, allowProtocols, denyProtocols string, now int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Model(&model.Tunnel{}).
+		Where("id = ?", tunnelID).
+		Updates(map[string]interface{}{
+			"allow_protocols": allowProtocols,
+			"deny_protocols":  denyProtocols,
+			"updated_time":    now,
+		}).Error
+}
+
+// UpdateForwardProtocolACL replaces the protocol lists of a forward.
+func (r *Repository) UpdateForwardProtocolACL(forwardID int64, allowProtocols, denyProtocols string, now int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Model(&model.Forward{}).
+		Where("id = ?", forwardID).
+		Updates(map[string]interface{}{
+			"allow_protocols": allowProtocols,
+			"deny_protocols":  denyProtocols,
+			"updated_time":    now,
+		}).Error
+}
+
+// AddForwardProtocolBlocked adds to the number of connections a forward's protocol rules have blocked.
+func (r *Repository) AddForwardProtocolBlocked(forwardID, count int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Model(&model.Forward{}).
+		Where("id = ?", forwardID).
+		UpdateColumn("protocol_blocked", gorm.Expr("protocol_blocked + ?", count)).Error
+}
+
+// AddNodeProtocolBlocked adds to the number of connections blocked by protocol on a node, across all of its services.
+func (r *Repository) AddNodeProtocolBlocked(nodeID, count int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Model(&model.Node{}).
+		Where("id = ?", nodeID).
+		UpdateColumn("protocol_blocked", gorm.Expr("protocol_blocked + ?", count)).Error
+}
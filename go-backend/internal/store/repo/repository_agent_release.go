@@ -0,0 +1,69 @@
+package repo
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"go-backend/internal/store/model"
+)
+
+// SaveAgentRelease stores a hosted agent binary, replacing the record of the
+// same version and architecture.
+func (r *Repository) SaveAgentRelease(release *model.AgentRelease) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "version"}, {Name: "arch"}},
+		DoUpdates: clause.AssignmentColumns([]string{"file_name", "size", "sha256", "signature", "created_time"}),
+	}).Create(release).Error
+}
+
+// GetAgentRelease returns the hosted binary of a version and architecture,
+// or nil if there is none.
+func (r *Repository) GetAgentRelease(version, arch string) (*model.AgentRelease, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var release model.AgentRelease
+	err := r.db.Where("version = ? AND arch = ?", version, arch).First(&release).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &release, nil
+}
+
+// ListAgentReleases returns every hosted binary, newest upload first.
+func (r *Repository) ListAgentReleases() ([]model.AgentRelease, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var releases []model.AgentRelease
+	err := r.db.Order("created_time DESC, id DESC").Find(&releases).Error
+	return releases, err
+}
+
+// DeleteAgentRelease removes a hosted binary record and returns it, or nil
+// if it does not exist.
+func (r *Repository) DeleteAgentRelease(id int64) (*model.AgentRelease, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var release model.AgentRelease
+	err := r.db.Where("id = ?", id).First(&release).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := r.db.Delete(&model.AgentRelease{}, id).Error; err != nil {
+		return nil, err
+	}
+	return &release, nil
+}
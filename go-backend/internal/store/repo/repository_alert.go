@@ -0,0 +1,179 @@
+package repo
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+
+	"go-backend/internal/store/model"
+)
+
+// ListAllNodes returns every node row ordered by id.
+func (r *Repository) ListAllNodes() ([]model.Node, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var nodes []model.Node
+	if err := r.db.Order("id ASC").Find(&nodes).Error; err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// ListAlertRules returns all alert rules ordered by id.
+func (r *Repository) ListAlertRules() ([]model.AlertRule, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var rules []model.AlertRule
+	if err := r.db.Order("id ASC").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// GetAlertRule returns an alert rule, or nil if it does not exist.
+func (r *Repository) GetAlertRule(id int64) (*model.AlertRule, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var rule model.AlertRule
+	err := r.db.Where("id = ?", id).First(&rule).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// SaveAlertRule inserts a rule when its id is 0 and updates it otherwise.
+func (r *Repository) SaveAlertRule(rule *model.AlertRule) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	if rule.ID == 0 {
+		return r.db.Create(rule).Error
+	}
+	return r.db.Select("*").Omit("created_time").Where("id = ?", rule.ID).Updates(rule).Error
+}
+
+// DeleteAlertRule removes a rule and its events.
+func (r *Repository) DeleteAlertRule(id int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("rule_id = ?", id).Delete(&model.AlertEvent{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", id).Delete(&model.AlertRule{}).Error
+	})
+}
+
+// ListAlertChannels returns all notification channels ordered by id.
+func (r *Repository) ListAlertChannels() ([]model.AlertChannel, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var channels []model.AlertChannel
+	if err := r.db.Order("id ASC").Find(&channels).Error; err != nil {
+		return nil, err
+	}
+	return channels, nil
+}
+
+// GetAlertChannel returns a notification channel, or nil if it does not exist.
+func (r *Repository) GetAlertChannel(id int64) (*model.AlertChannel, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var channel model.AlertChannel
+	err := r.db.Where("id = ?", id).First(&channel).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &channel, nil
+}
+
+// SaveAlertChannel inserts a channel when its id is 0 and updates it otherwise.
+func (r *Repository) SaveAlertChannel(channel *model.AlertChannel) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	if channel.ID == 0 {
+		return r.db.Create(channel).Error
+	}
+	return r.db.Select("*").Omit("created_time").Where("id = ?", channel.ID).Updates(channel).Error
+}
+
+// DeleteAlertChannel removes a notification channel.
+func (r *Repository) DeleteAlertChannel(id int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Where("id = ?", id).Delete(&model.AlertChannel{}).Error
+}
+
+// CreateAlertEvent records a fired alert and returns its id.
+func (r *Repository) CreateAlertEvent(event *model.AlertEvent) (int64, error) {
+	if r == nil || r.db == nil {
+		return 0, errors.New("repository not initialized")
+	}
+	if err := r.db.Create(event).Error; err != nil {
+		return 0, err
+	}
+	return event.ID, nil
+}
+
+// ResolveAlertEvent marks an alert as resolved.
+func (r *Repository) ResolveAlertEvent(id int64, resolvedTime int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Model(&model.AlertEvent{}).Where("id = ?", id).Update("resolved_time", resolvedTime).Error
+}
+
+// SetAlertEventDelivery records the outcome of an alert's latest
+// notification.
+func (r *Repository) SetAlertEventDelivery(id int64, status, errText string) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Model(&model.AlertEvent{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"notify_status": status,
+		"notify_error":  errText,
+	}).Error
+}
+
+// ListOpenAlertEvents returns alerts that have not resolved yet.
+func (r *Repository) ListOpenAlertEvents() ([]model.AlertEvent, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var events []model.AlertEvent
+	if err := r.db.Where("resolved_time = 0").Order("id ASC").Find(&events).Error; err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// ListAlertEvents returns the newest alerts, optionally only active ones.
+func (r *Repository) ListAlertEvents(activeOnly bool, limit int) ([]model.AlertEvent, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	q := r.db.Order("id DESC").Limit(limit)
+	if activeOnly {
+		q = q.Where("resolved_time = 0")
+	}
+	var events []model.AlertEvent
+	if err := q.Find(&events).Error; err != nil {
+		return nil, err
+	}
+	return events, nil
+}
@@ -0,0 +1,149 @@
+package repo
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+
+	"go-backend/internal/store/model"
+)
+
+// CreateCertificate inserts a managed certificate and returns its id.
+func (r *Repository) CreateCertificate(cert *model.Certificate) (int64, error) {
+	if r == nil || r.db == nil {
+		return 0, errors.New("repository not initialized")
+	}
+	if err := r.db.Create(cert).Error; err != nil {
+		return 0, err
+	}
+	return cert.ID, nil
+}
+
+// GetCertificate returns a managed certificate, or nil if it does not exist.
+func (r *Repository) GetCertificate(id int64) (*model.Certificate, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var cert model.Certificate
+	err := r.db.Where("id = ?", id).First(&cert).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// ListCertificates returns every managed certificate ordered by id.
+func (r *Repository) ListCertificates() ([]model.Certificate, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var certs []model.Certificate
+	if err := r.db.Order("id ASC").Find(&certs).Error; err != nil {
+		return nil, err
+	}
+	return certs, nil
+}
+
+// ListACMECertificatesDue returns ACME certificates that expire before the
+// given time or have never been issued.
+func (r *Repository) ListACMECertificatesDue(before int64) ([]model.Certificate, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var certs []model.Certificate
+	err := r.db.Where("source = ? AND not_after < ?", "acme", before).Order("not_after ASC").Find(&certs).Error
+	if err != nil {
+		return nil, err
+	}
+	return certs, nil
+}
+
+// UpdateCertificateMaterial stores newly issued or uploaded key material and marks the certificate issued.
+func (r *Repository) UpdateCertificateMaterial(id int64, domains, certData, keyData string, notBefore, notAfter, now int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Model(&model.Certificate{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"domains":      domains,
+		"cert_data":    certData,
+		"key_data":     keyData,
+		"not_before":   notBefore,
+		"not_after":    notAfter,
+		"status":       1,
+		"last_error":   "",
+		"updated_time": now,
+	}).Error
+}
+
+// UpdateCertificateError records a failed issuance; the current key material is kept.
+func (r *Repository) UpdateCertificateError(id int64, lastError string, now int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Model(&model.Certificate{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":       2,
+		"last_error":   lastError,
+		"updated_time": now,
+	}).Error
+}
+
+// UpdateCertificateACMEAccount stores the encrypted ACME account key of a certificate.
+func (r *Repository) UpdateCertificateACMEAccount(id int64, accountKey string, now int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Model(&model.Certificate{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"acme_account_key": accountKey,
+		"updated_time":     now,
+	}).Error
+}
+
+// DeleteCertificate removes a managed certificate.
+func (r *Repository) DeleteCertificate(id int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Where("id = ?", id).Delete(&model.Certificate{}).Error
+}
+
+// ListForwardIDsByCertificate returns the forwards terminating TLS with a certificate.
+func (r *Repository) ListForwardIDsByCertificate(certificateID int64) ([]int64, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var ids []int64
+	err := r.db.Model(&model.Forward{}).Where("certificate_id = ?", certificateID).Order("id ASC").Pluck("id", &ids).Error
+	return ids, err
+}
+
+// ListCertificateNodeIDs returns the entry nodes of the forwards using a certificate.
+func (r *Repository) ListCertificateNodeIDs(certificateID int64) ([]int64, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var ids []int64
+	err := r.db.Model(&model.ForwardPort{}).
+		Joins("JOIN forward ON forward.id = forward_port.forward_id").
+		Where("forward.certificate_id = ?", certificateID).
+		Distinct("forward_port.node_id").
+		Order("forward_port.node_id ASC").
+		Pluck("forward_port.node_id", &ids).Error
+	return ids, err
+}
+
+// UpdateForwardHTTPProxy sets the reverse proxy settings of a forward.
+func (r *Repository) UpdateForwardHTTPProxy(forwardID int64, mode string, certificateID int64, host, headers string, now int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Model(&model.Forward{}).Where("id = ?", forwardID).Updates(map[string]interface{}{
+		"proxy_mode":     mode,
+		"certificate_id": certificateID,
+		"proxy_host":     host,
+		"proxy_headers":  headers,
+		"updated_time":   now,
+	}).Error
+}
@@ -14,7 +14,7 @@ func (r *Repository) UpdateForwardStatus(forwardID int64, status int, now int64)
 		return errors.New("repository not initialized")
 	}
 	return r.db.Model(&model.Forward{}).Where("id = ?", forwardID).Updates(map[string]interface{}{
-		"status": status, "updated_time": now,
+		"status": status, "schedule_paused": 0, "updated_time": now,
 	}).Error
 }
 
@@ -30,14 +30,19 @@ func (r *Repository) ListActiveForwardsByUser(userID int64) ([]model.ForwardReco
 	rows := make([]model.ForwardRecord, 0, len(forwards))
 	for _, f := range forwards {
 		rows = append(rows, model.ForwardRecord{
-			ID:         f.ID,
-			UserID:     f.UserID,
-			UserName:   f.UserName,
-			Name:       f.Name,
-			TunnelID:   f.TunnelID,
-			RemoteAddr: f.RemoteAddr,
-			Strategy:   f.Strategy,
-			Status:     f.Status,
+			ID:            f.ID,
+			UserID:        f.UserID,
+			UserName:      f.UserName,
+			Name:          f.Name,
+			TunnelID:      f.TunnelID,
+			RemoteAddr:    f.RemoteAddr,
+			Strategy:      f.Strategy,
+			Status:        f.Status,
+			Domain:        f.Domain,
+			ProxyMode:     f.ProxyMode,
+			CertificateID: f.CertificateID,
+			ProxyHost:     f.ProxyHost,
+			ProxyHeaders:  f.ProxyHeaders,
 		})
 	}
 	for i := range rows {
@@ -60,14 +65,19 @@ func (r *Repository) ListActiveForwardsByUserTunnel(userID, tunnelID int64) ([]m
 	rows := make([]model.ForwardRecord, 0, len(forwards))
 	for _, f := range forwards {
 		rows = append(rows, model.ForwardRecord{
-			ID:         f.ID,
-			UserID:     f.UserID,
-			UserName:   f.UserName,
-			Name:       f.Name,
-			TunnelID:   f.TunnelID,
-			RemoteAddr: f.RemoteAddr,
-			Strategy:   f.Strategy,
-			Status:     f.Status,
+			ID:            f.ID,
+			UserID:        f.UserID,
+			UserName:      f.UserName,
+			Name:          f.Name,
+			TunnelID:      f.TunnelID,
+			RemoteAddr:    f.RemoteAddr,
+			Strategy:      f.Strategy,
+			Status:        f.Status,
+			Domain:        f.Domain,
+			ProxyMode:     f.ProxyMode,
+			CertificateID: f.CertificateID,
+			ProxyHost:     f.ProxyHost,
+			ProxyHeaders:  f.ProxyHeaders,
 		})
 	}
 	for i := range rows {
@@ -91,14 +101,19 @@ func (r *Repository) GetForwardRecord(forwardID int64) (*model.ForwardRecord, er
 		return nil, err
 	}
 	fr := model.ForwardRecord{
-		ID:         f.ID,
-		UserID:     f.UserID,
-		UserName:   f.UserName,
-		Name:       f.Name,
-		TunnelID:   f.TunnelID,
-		RemoteAddr: f.RemoteAddr,
-		Strategy:   f.Strategy,
-		Status:     f.Status,
+		ID:            f.ID,
+		UserID:        f.UserID,
+		UserName:      f.UserName,
+		Name:          f.Name,
+		TunnelID:      f.TunnelID,
+		RemoteAddr:    f.RemoteAddr,
+		Strategy:      f.Strategy,
+		Status:        f.Status,
+		Domain:        f.Domain,
+		ProxyMode:     f.ProxyMode,
+		CertificateID: f.CertificateID,
+		ProxyHost:     f.ProxyHost,
+		ProxyHeaders:  f.ProxyHeaders,
 	}
 	if strings.TrimSpace(fr.Strategy) == "" {
 		fr.Strategy = "fifo"
@@ -0,0 +1,62 @@
+package repo
+
+import (
+	"errors"
+
+	"go-backend/internal/store/model"
+)
+
+// ListScheduledForwards returns forwards with a schedule or an expiry.
+func (r *Repository) ListScheduledForwards() ([]model.Forward, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var forwards []model.Forward
+	err := r.db.Where("schedule_start > 0 OR schedule_end > 0 OR schedule_windows <> '' OR expire_time > 0").
+		Order("id ASC").Find(&forwards).Error
+	if err != nil {
+		return nil, err
+	}
+	return forwards, nil
+}
+
+// GetForwardSchedule returns the forward row including its schedule, or nil if it does not exist.
+func (r *Repository) GetForwardSchedule(forwardID int64) (*model.Forward, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var forwards []model.Forward
+	if err := r.db.Where("id = ?", forwardID).Limit(1).Find(&forwards).Error; err != nil {
+		return nil, err
+	}
+	if len(forwards) == 0 {
+		return nil, nil
+	}
+	return &forwards[0], nil
+}
+
+// UpdateForwardSchedule sets the active period, weekly windows and expiry of a forward.
+func (r *Repository) UpdateForwardSchedule(forward *model.Forward, now int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Model(&model.Forward{}).Where("id = ?", forward.ID).Updates(map[string]interface{}{
+		"schedule_start":    forward.ScheduleStart,
+		"schedule_end":      forward.ScheduleEnd,
+		"schedule_windows":  forward.ScheduleWindows,
+		"schedule_timezone": forward.ScheduleTimezone,
+		"expire_time":       forward.ExpireTime,
+		"expire_action":     forward.ExpireAction,
+		"updated_time":      now,
+	}).Error
+}
+
+// UpdateForwardScheduleState records a status change made by the scheduler.
+func (r *Repository) UpdateForwardScheduleState(forwardID int64, status int, schedulePaused int, now int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Model(&model.Forward{}).Where("id = ?", forwardID).Updates(map[string]interface{}{
+		"status": status, "schedule_paused": schedulePaused, "updated_time": now,
+	}).Error
+}
@@ -0,0 +1,91 @@
+package repo
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+
+	"go-backend/internal/store/model"
+)
+
+// GetNodeCA returns the internal node CA, or nil before it is created.
+func (r *Repository) GetNodeCA() (*model.NodeCA, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var ca model.NodeCA
+	err := r.db.Order("id ASC").First(&ca).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ca, nil
+}
+
+// CreateNodeCA stores the internal node CA.
+func (r *Repository) CreateNodeCA(ca *model.NodeCA) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Create(ca).Error
+}
+
+// CreateNodeCertificate records a certificate issued to a node.
+func (r *Repository) CreateNodeCertificate(cert *model.NodeCertificate) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Create(cert).Error
+}
+
+// GetNodeCertificateBySerial returns an issued certificate, or nil if the
+// serial is unknown.
+func (r *Repository) GetNodeCertificateBySerial(serial string) (*model.NodeCertificate, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var cert model.NodeCertificate
+	err := r.db.Where("serial = ?", serial).First(&cert).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// ListNodeCertificates returns the certificates issued to a node, newest first.
+func (r *Repository) ListNodeCertificates(nodeID int64) ([]model.NodeCertificate, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var certs []model.NodeCertificate
+	err := r.db.Where("node_id = ?", nodeID).Order("id DESC").Find(&certs).Error
+	return certs, err
+}
+
+// NodeHasActiveCertificate reports whether a node holds an unrevoked
+// certificate that has not expired.
+func (r *Repository) NodeHasActiveCertificate(nodeID, now int64) (bool, error) {
+	if r == nil || r.db == nil {
+		return false, errors.New("repository not initialized")
+	}
+	var count int64
+	err := r.db.Model(&model.NodeCertificate{}).
+		Where("node_id = ? AND revoked = 0 AND not_after > ?", nodeID, now).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// RevokeNodeCertificates revokes every certificate of a node and returns
+// how many were revoked.
+func (r *Repository) RevokeNodeCertificates(nodeID int64) (int64, error) {
+	if r == nil || r.db == nil {
+		return 0, errors.New("repository not initialized")
+	}
+	res := r.db.Model(&model.NodeCertificate{}).Where("node_id = ? AND revoked = 0", nodeID).Update("revoked", 1)
+	return res.RowsAffected, res.Error
+}
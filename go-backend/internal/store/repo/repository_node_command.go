@@ -0,0 +1,130 @@
+package repo
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+
+	"go-backend/internal/store/model"
+)
+
+// EnqueueNodeCommand appends a command to a node's outbox, replacing any
+// pending command with the same idempotency key.
+func (r *Repository) EnqueueNodeCommand(cmd *model.NodeCommand) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if cmd.IdempotencyKey != "" {
+			if err := tx.Model(&model.NodeCommand{}).
+				Where("node_id = ? AND idempotency_key = ? AND status = ?", cmd.NodeID, cmd.IdempotencyKey, model.NodeCommandPending).
+				Updates(map[string]interface{}{"status": model.NodeCommandCancelled, "last_error": "superseded", "updated_time": cmd.CreatedTime}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(cmd).Error
+	})
+}
+
+// ListPendingNodeCommands returns a node's pending commands in replay order.
+func (r *Repository) ListPendingNodeCommands(nodeID int64) ([]model.NodeCommand, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var cmds []model.NodeCommand
+	err := r.db.Where("node_id = ? AND status = ?", nodeID, model.NodeCommandPending).Order("id ASC").Find(&cmds).Error
+	return cmds, err
+}
+
+// ListNodeCommands returns a node's outbox, newest first. A negative status
+// returns every state.
+func (r *Repository) ListNodeCommands(nodeID int64, status int, limit int) ([]model.NodeCommand, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	q := r.db.Where("node_id = ?", nodeID)
+	if status >= 0 {
+		q = q.Where("status = ?", status)
+	}
+	var cmds []model.NodeCommand
+	err := q.Order("id DESC").Limit(limit).Find(&cmds).Error
+	return cmds, err
+}
+
+// CountPendingNodeCommands returns the number of pending commands per node.
+func (r *Repository) CountPendingNodeCommands() (map[int64]int, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var rows []struct {
+		NodeID int64
+		N      int
+	}
+	err := r.db.Model(&model.NodeCommand{}).
+		Select("node_id, COUNT(1) AS n").
+		Where("status = ?", model.NodeCommandPending).
+		Group("node_id").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[int64]int, len(rows))
+	for _, row := range rows {
+		out[row.NodeID] = row.N
+	}
+	return out, nil
+}
+
+// FinishNodeCommand records the outcome of a replay attempt.
+func (r *Repository) FinishNodeCommand(id int64, status int, lastError string, now int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Model(&model.NodeCommand{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":       status,
+		"attempts":     gorm.Expr("attempts + 1"),
+		"last_error":   lastError,
+		"updated_time": now,
+	}).Error
+}
+
+// CancelNodeCommand cancels a pending command. It reports whether one was
+// cancelled.
+func (r *Repository) CancelNodeCommand(id int64, now int64) (bool, error) {
+	if r == nil || r.db == nil {
+		return false, errors.New("repository not initialized")
+	}
+	res := r.db.Model(&model.NodeCommand{}).
+		Where("id = ? AND status = ?", id, model.NodeCommandPending).
+		Updates(map[string]interface{}{"status": model.NodeCommandCancelled, "updated_time": now})
+	return res.RowsAffected > 0, res.Error
+}
+
+// ExpireNodeCommands marks pending commands past their expiry and deletes
+// finished ones last updated before purgeBefore.
+func (r *Repository) ExpireNodeCommands(now, purgeBefore int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	if err := r.db.Model(&model.NodeCommand{}).
+		Where("status = ? AND expire_time <= ?", model.NodeCommandPending, now).
+		Updates(map[string]interface{}{"status": model.NodeCommandExpired, "updated_time": now}).Error; err != nil {
+		return err
+	}
+	return r.db.Where("status <> ? AND updated_time < ?", model.NodeCommandPending, purgeBefore).Delete(&model.NodeCommand{}).Error
+}
+
+// NodeDisconnectedAt returns when a node that has connected before went
+// offline, or 0 when it is online or has never connected.
+func (r *Repository) NodeDisconnectedAt(nodeID int64) (int64, error) {
+	if r == nil || r.db == nil {
+		return 0, errors.New("repository not initialized")
+	}
+	var n model.Node
+	if err := r.db.Select("id, status, version, disconnected_time").Where("id = ?", nodeID).First(&n).Error; err != nil {
+		return 0, err
+	}
+	if n.Status == 1 || !n.Version.Valid || n.Version.String == "" {
+		return 0, nil
+	}
+	return n.DisconnectedTime, nil
+}
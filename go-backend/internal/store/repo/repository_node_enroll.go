@@ -0,0 +1,112 @@
+package repo
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+
+	"go-backend/internal/store/model"
+)
+
+// CreateNodeEnrollToken stores a new enrollment token.
+func (r *Repository) CreateNodeEnrollToken(token *model.NodeEnrollToken) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Create(token).Error
+}
+
+// ListNodeEnrollTokens returns all enrollment tokens, newest first.
+func (r *Repository) ListNodeEnrollTokens() ([]model.NodeEnrollToken, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var tokens []model.NodeEnrollToken
+	if err := r.db.Order("id DESC").Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// DeleteNodeEnrollToken revokes an enrollment token.
+func (r *Repository) DeleteNodeEnrollToken(id int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Where("id = ?", id).Delete(&model.NodeEnrollToken{}).Error
+}
+
+// ConsumeNodeEnrollToken takes one use of an unexpired token and returns it,
+// or nil when the token is unknown, expired or used up.
+func (r *Repository) ConsumeNodeEnrollToken(tokenHash string, now int64) (*model.NodeEnrollToken, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	res := r.db.Model(&model.NodeEnrollToken{}).
+		Where("token_hash = ? AND expire_time > ? AND (max_uses = 0 OR used_count < max_uses)", tokenHash, now).
+		Updates(map[string]interface{}{"used_count": gorm.Expr("used_count + 1"), "last_used_time": now})
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, nil
+	}
+	var token model.NodeEnrollToken
+	if err := r.db.Where("token_hash = ?", tokenHash).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &token, nil
+}
+
+// FindEnrolledNode returns the node an earlier enrollment with the same
+// token and enrollment id created, or nil. The token may since have expired
+// or been used up, but not revoked.
+func (r *Repository) FindEnrolledNode(tokenHash, enrollID string) (*model.Node, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var node model.Node
+	err := r.db.Model(&model.Node{}).
+		Joins("JOIN node_enroll_token ON node_enroll_token.id = node.enroll_token_id").
+		Where("node_enroll_token.token_hash = ? AND node.enroll_id = ?", tokenHash, enrollID).
+		First(&node).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &node, nil
+}
+
+// SetNodeEnrollment records the enrollment that created a node.
+func (r *Repository) SetNodeEnrollment(nodeID, tokenID int64, enrollID string) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Model(&model.Node{}).Where("id = ?", nodeID).
+		Updates(map[string]interface{}{"enroll_token_id": tokenID, "enroll_id": enrollID}).Error
+}
+
+// ReleaseNodeEnrollToken gives back a use taken by ConsumeNodeEnrollToken
+// when enrollment failed afterwards.
+func (r *Repository) ReleaseNodeEnrollToken(id int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Model(&model.NodeEnrollToken{}).Where("id = ? AND used_count > 0", id).
+		Update("used_count", gorm.Expr("used_count - 1")).Error
+}
+
+// NodeNameTaken reports whether a node with the given name exists.
+func (r *Repository) NodeNameTaken(name string) (bool, error) {
+	if r == nil || r.db == nil {
+		return false, errors.New("repository not initialized")
+	}
+	var n int64
+	err := r.db.Model(&model.Node{}).Where("name = ?", name).Count(&n).Error
+	return n > 0, err
+}
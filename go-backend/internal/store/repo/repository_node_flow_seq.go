@@ -0,0 +1,42 @@
+package repo
+
+import (
+	"database/sql"
+	"errors"
+
+	"gorm.io/gorm/clause"
+
+	"go-backend/internal/store/model"
+)
+
+// ClaimNodeFlowSeq records a traffic report sequence number for a node. It
+// reports false when the sequence number was already recorded.
+func (r *Repository) ClaimNodeFlowSeq(nodeID, seq, now int64) (bool, error) {
+	if r == nil || r.db == nil {
+		return false, errors.New("repository not initialized")
+	}
+	res := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&model.NodeFlowSeq{NodeID: nodeID, Seq: seq, CreatedTime: now})
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected > 0, nil
+}
+
+// PruneNodeFlowSeqs deletes sequence numbers recorded before the given time.
+func (r *Repository) PruneNodeFlowSeqs(before int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Where("created_time < ?", before).Delete(&model.NodeFlowSeq{}).Error
+}
+
+// LastNodeFlowReportTime returns when the node last delivered a traffic
+// report, or 0 if none is recorded.
+func (r *Repository) LastNodeFlowReportTime(nodeID int64) (int64, error) {
+	if r == nil || r.db == nil {
+		return 0, errors.New("repository not initialized")
+	}
+	var last sql.NullInt64
+	err := r.db.Model(&model.NodeFlowSeq{}).Where("node_id = ?", nodeID).Select("MAX(created_time)").Scan(&last).Error
+	return last.Int64, err
+}
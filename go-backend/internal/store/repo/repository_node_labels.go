@@ -0,0 +1,122 @@
+package repo
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"go-backend/internal/store/model"
+)
+
+// decodeNodeLabels returns the labels of a node as a map, never nil.
+func decodeNodeLabels(raw string) map[string]string {
+	labels := map[string]string{}
+	if strings.TrimSpace(raw) != "" {
+		_ = json.Unmarshal([]byte(raw), &labels)
+	}
+	return labels
+}
+
+// UpdateNodePlacement sets a node's labels, declared capacity and forward limit.
+func (r *Repository) UpdateNodePlacement(nodeID int64, labels string, capacityMbps int64, maxForwards int) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Model(&model.Node{}).Where("id = ?", nodeID).Updates(map[string]interface{}{
+		"labels": labels, "capacity_mbps": capacityMbps, "max_forwards": maxForwards,
+	}).Error
+}
+
+// CountForwardsOnNode returns how many forwards listen on a node.
+func (r *Repository) CountForwardsOnNode(nodeID int64) (int64, error) {
+	if r == nil || r.db == nil {
+		return 0, errors.New("repository not initialized")
+	}
+	var n int64
+	err := r.db.Model(&model.ForwardPort{}).Where("node_id = ?", nodeID).Distinct("forward_id").Count(&n).Error
+	return n, err
+}
+
+// GetTunnelNodeSelectors returns a tunnel's entry and exit node selectors.
+func (r *Repository) GetTunnelNodeSelectors(tunnelID int64) (string, string, error) {
+	if r == nil || r.db == nil {
+		return "", "", errors.New("repository not initialized")
+	}
+	var t model.Tunnel
+	err := r.db.Select("in_node_selector, out_node_selector").Where("id = ?", tunnelID).First(&t).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", "", nil
+	}
+	return t.InNodeSelector, t.OutNodeSelector, err
+}
+
+// UpdateTunnelNodeSelectorsTx sets a tunnel's node selectors.
+func (r *Repository) UpdateTunnelNodeSelectorsTx(tx *gorm.DB, tunnelID int64, inSelector, outSelector string) error {
+	if tx == nil {
+		return errors.New("database unavailable")
+	}
+	return tx.Model(&model.Tunnel{}).Where("id = ?", tunnelID).Updates(map[string]interface{}{
+		"in_node_selector": inSelector, "out_node_selector": outSelector,
+	}).Error
+}
+
+// ListTunnelsWithInNodeSelector returns enabled tunnels that pick entry nodes by label.
+func (r *Repository) ListTunnelsWithInNodeSelector() ([]model.Tunnel, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var tunnels []model.Tunnel
+	if err := r.db.Where("in_node_selector <> '' AND status = 1").Order("id ASC").Find(&tunnels).Error; err != nil {
+		return nil, err
+	}
+	return tunnels, nil
+}
+
+// AddTunnelEntryNode appends an entry node to a tunnel.
+func (r *Repository) AddTunnelEntryNode(tunnelID, nodeID int64, strategy, protocol string) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Create(&model.ChainTunnel{
+		TunnelID:  tunnelID,
+		ChainType: "1",
+		NodeID:    nodeID,
+		Strategy:  sql.NullString{String: strategy, Valid: true},
+		Inx:       sql.NullInt64{Int64: 0, Valid: true},
+		Protocol:  sql.NullString{String: protocol, Valid: true},
+	}).Error
+}
+
+// RemoveTunnelEntryNode removes an entry node from a tunnel.
+func (r *Repository) RemoveTunnelEntryNode(tunnelID, nodeID int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Where("tunnel_id = ? AND node_id = ? AND chain_type = ?", tunnelID, nodeID, "1").Delete(&model.ChainTunnel{}).Error
+}
+
+// UpdateTunnelInIP sets the entry addresses shown for a tunnel.
+func (r *Repository) UpdateTunnelInIP(tunnelID int64, inIP string, now int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Model(&model.Tunnel{}).Where("id = ?", tunnelID).Updates(map[string]interface{}{
+		"in_ip": inIP, "updated_time": now,
+	}).Error
+}
+
+// GetNodePlacement returns a node's labels, declared capacity and forward limit.
+func (r *Repository) GetNodePlacement(nodeID int64) (string, int64, int, error) {
+	if r == nil || r.db == nil {
+		return "", 0, 0, errors.New("repository not initialized")
+	}
+	var n model.Node
+	err := r.db.Select("labels, capacity_mbps, max_forwards").Where("id = ?", nodeID).First(&n).Error
+	if err != nil {
+		return "", 0, 0, err
+	}
+	return n.Labels, n.CapacityMbps, n.MaxForwards, nil
+}
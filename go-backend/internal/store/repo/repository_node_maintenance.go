@@ -0,0 +1,83 @@
+package repo
+
+import (
+	"errors"
+
+	"go-backend/internal/store/model"
+)
+
+// SetNodeMaintenance switches a node's maintenance mode.
+func (r *Repository) SetNodeMaintenance(nodeID int64, maintenance int, now int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	since := int64(0)
+	if maintenance == 1 {
+		since = now
+	}
+	return r.db.Model(&model.Node{}).Where("id = ?", nodeID).Updates(map[string]interface{}{
+		"maintenance": maintenance, "maintenance_time": since,
+	}).Error
+}
+
+// TunnelActiveEntryNodeIDs returns the entry nodes of a tunnel that are not in maintenance.
+func (r *Repository) TunnelActiveEntryNodeIDs(tunnelID int64) ([]int64, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var ids []int64
+	err := r.db.Model(&model.ChainTunnel{}).
+		Joins("JOIN node ON node.id = chain_tunnel.node_id").
+		Where("chain_tunnel.tunnel_id = ? AND chain_tunnel.chain_type = ? AND node.maintenance = 0", tunnelID, "1").
+		Order("chain_tunnel.inx ASC, chain_tunnel.id ASC").
+		Pluck("chain_tunnel.node_id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// ListForwardIDsOnNode returns the forwards listening on a node.
+func (r *Repository) ListForwardIDsOnNode(nodeID int64) ([]int64, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var ids []int64
+	err := r.db.Model(&model.ForwardPort{}).
+		Where("node_id = ?", nodeID).
+		Distinct("forward_id").
+		Order("forward_id ASC").
+		Pluck("forward_id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// CreateNodeMaintenanceMove records a forward moved off a node.
+func (r *Repository) CreateNodeMaintenanceMove(nodeID, forwardID int64, port int, now int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Create(&model.NodeMaintenanceMove{NodeID: nodeID, ForwardID: forwardID, Port: port, CreatedTime: now}).Error
+}
+
+// ListNodeMaintenanceMoves returns the forwards moved off a node.
+func (r *Repository) ListNodeMaintenanceMoves(nodeID int64) ([]model.NodeMaintenanceMove, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var moves []model.NodeMaintenanceMove
+	if err := r.db.Where("node_id = ?", nodeID).Order("id ASC").Find(&moves).Error; err != nil {
+		return nil, err
+	}
+	return moves, nil
+}
+
+// DeleteNodeMaintenanceMoves forgets the forwards moved off a node.
+func (r *Repository) DeleteNodeMaintenanceMoves(nodeID int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Where("node_id = ?", nodeID).Delete(&model.NodeMaintenanceMove{}).Error
+}
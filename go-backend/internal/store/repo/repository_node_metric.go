@@ -0,0 +1,101 @@
+package repo
+
+import (
+	"errors"
+
+	"gorm.io/gorm/clause"
+
+	"go-backend/internal/store/model"
+)
+
+var nodeMetricColumns = []string{
+	"samples", "cpu", "cpu_max", "memory", "memory_max", "rx_rate", "rx_rate_max",
+	"tx_rate", "tx_rate_max", "connections", "connections_max", "uptime",
+}
+
+// UpsertNodeMetrics writes metric buckets, replacing existing buckets with the same node, resolution and time.
+func (r *Repository) UpsertNodeMetrics(metrics []model.NodeMetric) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	if len(metrics) == 0 {
+		return nil
+	}
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "node_id"}, {Name: "resolution"}, {Name: "bucket_time"}},
+		DoUpdates: clause.AssignmentColumns(nodeMetricColumns),
+	}).Create(&metrics).Error
+}
+
+// ListNodeMetrics returns a node's buckets of one resolution in [start, end), oldest first.
+func (r *Repository) ListNodeMetrics(nodeID int64, resolution int, start, end int64) ([]model.NodeMetric, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var metrics []model.NodeMetric
+	err := r.db.Where("node_id = ? AND resolution = ? AND bucket_time >= ? AND bucket_time < ?", nodeID, resolution, start, end).
+		Order("bucket_time ASC").Find(&metrics).Error
+	if err != nil {
+		return nil, err
+	}
+	return metrics, nil
+}
+
+// RollupNodeMetrics aggregates the buckets of resolution from in [start, end)
+// into one bucket of resolution to at start for every node. Averages are
+// weighted by sample count.
+func (r *Repository) RollupNodeMetrics(from, to int, start, end int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	var rows []model.NodeMetric
+	err := r.db.Model(&model.NodeMetric{}).
+		Select(`node_id,
+			SUM(samples) AS samples,
+			SUM(cpu * samples) / SUM(samples) AS cpu, MAX(cpu_max) AS cpu_max,
+			SUM(memory * samples) / SUM(samples) AS memory, MAX(memory_max) AS memory_max,
+			CAST(SUM(rx_rate * samples) / SUM(samples) AS BIGINT) AS rx_rate, MAX(rx_rate_max) AS rx_rate_max,
+			CAST(SUM(tx_rate * samples) / SUM(samples) AS BIGINT) AS tx_rate, MAX(tx_rate_max) AS tx_rate_max,
+			CAST(SUM(connections * samples) / SUM(samples) AS BIGINT) AS connections, MAX(connections_max) AS connections_max,
+			MAX(uptime) AS uptime`).
+		Where("resolution = ? AND bucket_time >= ? AND bucket_time < ? AND samples > 0", from, start, end).
+		Group("node_id").
+		Find(&rows).Error
+	if err != nil {
+		return err
+	}
+	for i := range rows {
+		rows[i].ID = 0
+		rows[i].Resolution = to
+		rows[i].BucketTime = start
+	}
+	return r.UpsertNodeMetrics(rows)
+}
+
+// ListNodeMetricRollupsDue returns the start of every bucket of resolution to
+// before the given time that has buckets of resolution from but no rollup
+// yet for at least one node, oldest first.
+func (r *Repository) ListNodeMetricRollupsDue(from, to int, before int64) ([]int64, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	span := int64(to) * 1000
+	var starts []int64
+	err := r.db.Raw(`SELECT DISTINCT m.bucket_time - m.bucket_time % ? AS start FROM node_metric m
+		WHERE m.resolution = ? AND m.bucket_time < ? AND m.samples > 0
+		AND NOT EXISTS (SELECT 1 FROM node_metric h
+			WHERE h.node_id = m.node_id AND h.resolution = ? AND h.bucket_time = m.bucket_time - m.bucket_time % ?)
+		ORDER BY start ASC`, span, from, before, to, span).Scan(&starts).Error
+	if err != nil {
+		return nil, err
+	}
+	return starts, nil
+}
+
+// PurgeNodeMetrics deletes buckets of one resolution older than before.
+func (r *Repository) PurgeNodeMetrics(resolution int, before int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Where("resolution = ? AND bucket_time < ?", resolution, before).Delete(&model.NodeMetric{}).Error
+}
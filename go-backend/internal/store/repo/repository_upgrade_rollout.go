@@ -0,0 +1,61 @@
+package repo
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+
+	"go-backend/internal/store/model"
+)
+
+// CreateUpgradeRollout stores a new rollout plan.
+func (r *Repository) CreateUpgradeRollout(rollout *model.UpgradeRollout) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Create(rollout).Error
+}
+
+// GetUpgradeRollout returns a rollout, or nil if it does not exist.
+func (r *Repository) GetUpgradeRollout(id int64) (*model.UpgradeRollout, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var rollout model.UpgradeRollout
+	err := r.db.Where("id = ?", id).First(&rollout).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rollout, nil
+}
+
+// ListUpgradeRollouts returns rollouts, newest first.
+func (r *Repository) ListUpgradeRollouts(limit int) ([]model.UpgradeRollout, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var rollouts []model.UpgradeRollout
+	err := r.db.Order("id DESC").Limit(limit).Find(&rollouts).Error
+	return rollouts, err
+}
+
+// SaveUpgradeRollout writes the progress of a rollout.
+func (r *Repository) SaveUpgradeRollout(rollout *model.UpgradeRollout) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Save(rollout).Error
+}
+
+// PauseUpgradeRollouts moves rollouts in one status to paused, for runs that
+// were interrupted by a panel restart.
+func (r *Repository) PauseUpgradeRollouts(status, message string, now int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	return r.db.Model(&model.UpgradeRollout{}).Where("status = ?", status).
+		Updates(map[string]interface{}{"status": "paused", "message": message, "updated_time": now}).Error
+}
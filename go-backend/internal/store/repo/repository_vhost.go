@@ -0,0 +1,47 @@
+package repo
+
+import (
+	"errors"
+
+	"go-backend/internal/store/model"
+)
+
+// ForwardDomainExists reports whether another forward already claims the shared-listener domain.
+func (r *Repository) ForwardDomainExists(domain string, excludeForwardID int64) (bool, error) {
+	if r == nil || r.db == nil {
+		return false, errors.New("repository not initialized")
+	}
+	var count int64
+	err := r.db.Model(&model.Forward{}).
+		Where("domain = ? AND id <> ?", domain, excludeForwardID).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// CountForwardsOnNodePort counts the other forwards listening on a node port,
+// split into shared-listener (domain routed) and dedicated ones.
+func (r *Repository) CountForwardsOnNodePort(nodeID int64, port int, excludeForwardID int64) (shared int64, dedicated int64, err error) {
+	if r == nil || r.db == nil {
+		return 0, 0, errors.New("repository not initialized")
+	}
+	count := func(domainCond string) (int64, error) {
+		var n int64
+		err := r.db.Model(&model.ForwardPort{}).
+			Joins("JOIN forward ON forward.id = forward_port.forward_id").
+			Where("forward_port.node_id = ? AND forward_port.port = ? AND forward.id <> ?", nodeID, port, excludeForwardID).
+			Where(domainCond).
+			Distinct("forward.id").
+			Count(&n).Error
+		return n, err
+	}
+	if shared, err = count("forward.domain <> ''"); err != nil {
+		return 0, 0, err
+	}
+	if dedicated, err = count("forward.domain = ''"); err != nil {
+		return 0, 0, err
+	}
+	return shared, dedicated, nil
+}
@@ -72,6 +72,8 @@ type Server struct {
 	jwtSecret    string
 	upgrader     websocket.Upgrader
 	onNodeOnline func(nodeID int64)
+	onNodeInfo   func(nodeID int64, info string)
+	authorize    func(r *http.Request, nodeID int64) bool
 
 	mu      sync.RWMutex
 	admins  map[*connWrap]struct{}
@@ -89,6 +91,28 @@ func (s *Server) SetNodeOnlineHook(fn func(nodeID int64)) {
 	s.mu.Unlock()
 }
 
+// SetNodeInfoHook registers a callback for the periodic system info reports
+// of nodes. It runs on the node's read loop and must not block.
+func (s *Server) SetNodeInfoHook(fn func(nodeID int64, info string)) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.onNodeInfo = fn
+	s.mu.Unlock()
+}
+
+// SetNodeAuthorizer registers a check run before a node session is
+// accepted, on top of the secret.
+func (s *Server) SetNodeAuthorizer(fn func(r *http.Request, nodeID int64) bool) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.authorize = fn
+	s.mu.Unlock()
+}
+
 func NewServer(repo *repo.Repository, jwtSecret string) *Server {
 	return &Server{
 		repo:      repo,
@@ -114,6 +138,13 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "forbidden", http.StatusForbidden)
 			return
 		}
+		s.mu.RLock()
+		authorize := s.authorize
+		s.mu.RUnlock()
+		if authorize != nil && !authorize(r, node.ID) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
 		s.handleNode(w, r, node.ID, secret)
 		return
 	}
@@ -176,6 +207,7 @@ func (s *Server) handleNode(w http.ResponseWriter, r *http.Request, nodeID int64
 	go startKeepalive(cw, done)
 
 	version := r.URL.Query().Get("version")
+	arch := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("arch")))
 	httpVal := parseIntDefault(r.URL.Query().Get("http"), 0)
 	tlsVal := parseIntDefault(r.URL.Query().Get("tls"), 0)
 	socksVal := parseIntDefault(r.URL.Query().Get("socks"), 0)
@@ -190,7 +222,7 @@ func (s *Server) handleNode(w http.ResponseWriter, r *http.Request, nodeID int64
 	s.byConn[conn] = ns
 	s.mu.Unlock()
 
-	_ = s.repo.UpdateNodeOnline(nodeID, 1, version, httpVal, tlsVal, socksVal)
+	_ = s.repo.UpdateNodeOnline(nodeID, 1, version, arch, httpVal, tlsVal, socksVal)
 	s.broadcastStatus(nodeID, 1)
 
 	s.mu.RLock()
@@ -231,12 +263,39 @@ func (s *Server) handleNode(w http.ResponseWriter, r *http.Request, nodeID int64
 		var parsed struct {
 			Type string `json:"type"`
 		}
-		if json.Unmarshal([]byte(msg), &parsed) == nil && parsed.Type == "UpgradeProgress" {
+		parseErr := json.Unmarshal([]byte(msg), &parsed)
+		if parseErr == nil && parsed.Type == "UpgradeProgress" {
 			s.broadcastTyped(nodeID, "upgrade_progress", msg)
+		} else if parseErr == nil && parsed.Type == "LogEntries" {
+			s.broadcastTyped(nodeID, "logs", msg)
 		} else {
 			s.broadcastInfo(nodeID, msg)
 		}
+		if parseErr == nil && parsed.Type == "" {
+			s.mu.RLock()
+			infoHook := s.onNodeInfo
+			s.mu.RUnlock()
+			if infoHook != nil {
+				infoHook(nodeID, msg)
+			}
+		}
+	}
+}
+
+// DisconnectNode closes a node's live session, if any. The read loop then
+// marks the node offline and the agent reconnects through the usual checks.
+func (s *Server) DisconnectNode(nodeID int64) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.RLock()
+	ns, ok := s.nodes[nodeID]
+	s.mu.RUnlock()
+	if !ok || ns == nil || ns.conn == nil || ns.conn.conn == nil {
+		return false
 	}
+	_ = ns.conn.conn.Close()
+	return true
 }
 
 func (s *Server) SendCommand(nodeID int64, cmdType string, data interface{}, timeout time.Duration) (CommandResult, error) {
@@ -438,6 +497,21 @@ func (s *Server) broadcastTyped(nodeID int64, msgType string, data string) {
 	s.broadcastToAdmins(string(raw))
 }
 
+// BroadcastUpgradeProgress sends a panel-side upgrade update to admins in the
+// same shape as the UpgradeProgress messages reported by agents.
+func (s *Server) BroadcastUpgradeProgress(nodeID int64, stage string, percent int, success bool, message string) {
+	raw, _ := json.Marshal(map[string]interface{}{
+		"type":    "UpgradeProgress",
+		"success": success,
+		"message": message,
+		"data": map[string]interface{}{
+			"stage":   stage,
+			"percent": percent,
+		},
+	})
+	s.broadcastTyped(nodeID, "upgrade_progress", string(raw))
+}
+
 func (s *Server) broadcastToAdmins(message string) {
 	s.mu.RLock()
 	admins := make([]*connWrap, 0, len(s.admins))
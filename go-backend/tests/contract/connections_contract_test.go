@@ -0,0 +1,178 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-backend/internal/auth"
+	"go-backend/internal/http/response"
+)
+
+func TestConnectionTableListAndClose(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, r := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	adminToken, err := auth.GenerateToken(1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	userToken, err := auth.GenerateToken(2, "conn_user", 1, secret)
+	if err != nil {
+		t.Fatalf("generate user token: %v", err)
+	}
+	call := func(path, token string, body map[string]interface{}) response.R {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(raw))
+		req.Header.Set("Authorization", token)
+		req.Header.Set("Content-Type", "application/json")
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		var out response.R
+		if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+			t.Fatalf("decode %s response: %v", path, err)
+		}
+		return out
+	}
+	connectionIDs := func(out response.R) []string {
+		t.Helper()
+		if out.Code != 0 {
+			t.Fatalf("expected success, got %+v", out)
+		}
+		ids := []string{}
+		for _, raw := range out.Data.(map[string]interface{})["connections"].([]interface{}) {
+			ids = append(ids, raw.(map[string]interface{})["id"].(string))
+		}
+		return ids
+	}
+
+	now := time.Now().UnixMilli()
+	for _, user := range []struct {
+		id   int
+		name string
+	}{{2, "conn_user"}, {3, "other_user"}} {
+		if err := r.DB().Exec(`
+			INSERT INTO user(id, user, pwd, role_id, exp_time, flow, in_flow, out_flow, flow_reset_time, num, created_time, updated_time, status)
+			VALUES(?, ?, '3c85cdebade1c51cf64ca9f3c09d182d', 1, 2727251700000, 99999, 0, 0, 1, 99999, ?, ?, 1)
+		`, user.id, user.name, now, now).Error; err != nil {
+			t.Fatalf("insert user %s: %v", user.name, err)
+		}
+	}
+	if err := r.DB().Exec(`
+		INSERT INTO node(name, secret, server_ip, server_ip_v4, server_ip_v6, port, interface_name, version, http, tls, socks, created_time, updated_time, status, tcp_listen_addr, udp_listen_addr, inx)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, "conn-node", "conn-node-secret", "10.0.6.10", "10.0.6.10", "", "30000-30010", "", "v1", 1, 1, 1, now, now, 0, "[::]", "[::]", 0).Error; err != nil {
+		t.Fatalf("insert node: %v", err)
+	}
+	nodeID := mustLastInsertID(t, r, "conn-node")
+
+	// The connection table the node reports, filtered by service prefix.
+	table := []map[string]interface{}{
+		{"id": "c1", "service": "7_2_1_tcp", "client": "198.51.100.1:50000", "target": "10.1.1.1:80", "startTime": 2000},
+		{"id": "c2", "service": "8_3_0_udp", "client": "198.51.100.2:50001", "target": "10.1.1.2:53", "startTime": 1000},
+		{"id": "c3", "service": "5_tls", "client": "198.51.100.3:50002", "startTime": 3000},
+	}
+	handlers := map[string]mockCommandHandler{
+		"ListConnections": func(data json.RawMessage, _ func(map[string]interface{})) interface{} {
+			var scope struct {
+				Prefixes []string `json:"prefixes"`
+			}
+			_ = json.Unmarshal(data, &scope)
+			connections := make([]map[string]interface{}, 0)
+			for _, c := range table {
+				matched := len(scope.Prefixes) == 0
+				for _, prefix := range scope.Prefixes {
+					matched = matched || strings.HasPrefix(valueAsString(c["service"]), prefix)
+				}
+				if matched {
+					connections = append(connections, c)
+				}
+			}
+			return map[string]interface{}{"total": len(connections), "connections": connections}
+		},
+		"CloseConnections": func(data json.RawMessage, _ func(map[string]interface{})) interface{} {
+			var req struct {
+				IDs []string `json:"ids"`
+			}
+			_ = json.Unmarshal(data, &req)
+			return map[string]interface{}{"closed": len(req.IDs)}
+		},
+	}
+	var commands []string
+	stop := startMockNodeSessionWithHandlers(t, server.URL, "conn-node-secret", func(cmdType string) {
+		commands = append(commands, cmdType)
+	}, handlers)
+	defer stop()
+	waitNodeStatus(t, r, nodeID, 1)
+
+	for _, forward := range []struct {
+		id, userID int64
+		name       string
+	}{{7, 2, "conn_user"}, {8, 3, "other_user"}} {
+		if err := r.DB().Exec(`
+			INSERT INTO forward(id, user_id, user_name, name, tunnel_id, remote_addr, strategy, in_flow, out_flow, created_time, updated_time, status, inx)
+			VALUES(?, ?, ?, ?, 1, '10.1.1.1:80', 'fifo', 0, 0, ?, ?, 1, 0)
+		`, forward.id, forward.userID, forward.name, forward.name+"-forward", now, now).Error; err != nil {
+			t.Fatalf("insert forward: %v", err)
+		}
+		if err := r.DB().Exec(`INSERT INTO forward_port(forward_id, node_id, port) VALUES(?, ?, 30001)`, forward.id, nodeID).Error; err != nil {
+			t.Fatalf("insert forward port: %v", err)
+		}
+	}
+
+	out := call("/api/v1/node/connections", adminToken, map[string]interface{}{"nodeId": nodeID})
+	if ids := connectionIDs(out); len(ids) != 3 || ids[0] != "c2" || ids[2] != "c3" {
+		t.Fatalf("expected every connection on the node oldest first, got %v", ids)
+	}
+	for _, raw := range out.Data.(map[string]interface{})["connections"].([]interface{}) {
+		item := raw.(map[string]interface{})
+		if item["id"] == "c1" && (item["forwardId"] != float64(7) || item["userId"] != float64(2) || item["nodeName"] != "conn-node") {
+			t.Fatalf("expected forward connection to be annotated, got %+v", item)
+		}
+		if _, ok := item["forwardId"]; item["id"] == "c3" && ok {
+			t.Fatalf("expected tunnel connection to carry no forward, got %+v", item)
+		}
+	}
+
+	if ids := connectionIDs(call("/api/v1/node/connections", adminToken, map[string]interface{}{"userId": 3})); len(ids) != 1 || ids[0] != "c2" {
+		t.Fatalf("expected only the user's connections, got %v", ids)
+	}
+	if ids := connectionIDs(call("/api/v1/forward/connections", userToken, map[string]interface{}{"forwardId": 7})); len(ids) != 1 || ids[0] != "c1" {
+		t.Fatalf("expected only the forward's connections, got %v", ids)
+	}
+	if out := call("/api/v1/forward/connections", userToken, map[string]interface{}{"forwardId": 8}); out.Code == 0 {
+		t.Fatalf("expected another user's forward to be hidden")
+	}
+	if out := call("/api/v1/node/connections", userToken, map[string]interface{}{"nodeId": nodeID}); out.Code == 0 {
+		t.Fatalf("expected node connection listing to require admin")
+	}
+
+	if out := call("/api/v1/node/connections/close", adminToken, map[string]interface{}{"nodeId": nodeID}); out.Code == 0 {
+		t.Fatalf("expected closing without ids or service to be rejected")
+	}
+	out = call("/api/v1/forward/connections/close", userToken, map[string]interface{}{"forwardId": 7, "ids": []string{"c1"}})
+	if out.Code != 0 || out.Data.(map[string]interface{})["closed"] != float64(1) {
+		t.Fatalf("expected one connection closed, got %+v", out)
+	}
+	out = call("/api/v1/node/connections/close", adminToken, map[string]interface{}{"nodeId": nodeID, "service": "5_tls"})
+	if out.Code != 0 {
+		t.Fatalf("expected closing a service to succeed, got %+v", out)
+	}
+
+	closes := 0
+	for _, cmd := range commands {
+		if cmd == "CloseConnections" {
+			closes++
+		}
+	}
+	if closes != 2 {
+		t.Fatalf("expected two CloseConnections commands, got %v", commands)
+	}
+}
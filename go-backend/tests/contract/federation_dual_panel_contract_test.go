@@ -510,10 +510,30 @@ func assertCount(t *testing.T, r *repo.Repository, query string, arg interface{}
 }
 
 func startMockNodeSession(t *testing.T, baseURL string, nodeSecret string) func() {
-	return startMockNodeSessionWithHook(t, baseURL, nodeSecret, nil)
+	return startMockNodeSessionWithHandlers(t, baseURL, nodeSecret, nil, nil)
 }
 
 func startMockNodeSessionWithHook(t *testing.T, baseURL string, nodeSecret string, onCommand func(cmdType string)) func() {
+	return startMockNodeSessionWithHandlers(t, baseURL, nodeSecret, onCommand, nil)
+}
+
+// mockCommandHandler answers one command a mock node receives and returns the
+// response data. Messages passed to push reach the panel after the response.
+type mockCommandHandler func(data json.RawMessage, push func(msg map[string]interface{})) interface{}
+
+// mockTcpPingOK answers TcpPing on mock nodes whose test does not handle it.
+func mockTcpPingOK(json.RawMessage, func(map[string]interface{})) interface{} {
+	return map[string]interface{}{
+		"success":     true,
+		"averageTime": 8.5,
+		"packetLoss":  0,
+		"message":     "mock tcp ok",
+	}
+}
+
+// startMockNodeSessionWithHandlers connects a mock node that acknowledges
+// every command. handlers supply the response data per command type.
+func startMockNodeSessionWithHandlers(t *testing.T, baseURL string, nodeSecret string, onCommand func(cmdType string), handlers map[string]mockCommandHandler) func() {
 	t.Helper()
 	u, err := url.Parse(baseURL)
 	if err != nil {
@@ -564,8 +584,9 @@ func startMockNodeSessionWithHook(t *testing.T, baseURL string, nodeSecret strin
 			}
 
 			var cmd struct {
-				Type      string `json:"type"`
-				RequestID string `json:"requestId"`
+				Type      string          `json:"type"`
+				RequestID string          `json:"requestId"`
+				Data      json.RawMessage `json:"data"`
 			}
 			if err := json.Unmarshal(plain, &cmd); err != nil {
 				continue
@@ -573,30 +594,39 @@ func startMockNodeSessionWithHook(t *testing.T, baseURL string, nodeSecret strin
 			if strings.TrimSpace(cmd.RequestID) == "" {
 				continue
 			}
+			cmdType := strings.TrimSpace(cmd.Type)
 			if onCommand != nil {
-				onCommand(strings.TrimSpace(cmd.Type))
+				onCommand(cmdType)
 			}
 
-			respType := fmt.Sprintf("%sResponse", cmd.Type)
 			respPayload := map[string]interface{}{
-				"type":      respType,
+				"type":      fmt.Sprintf("%sResponse", cmdType),
 				"success":   true,
 				"message":   "OK",
 				"requestId": cmd.RequestID,
 			}
-			if strings.EqualFold(strings.TrimSpace(cmd.Type), "TcpPing") {
-				respPayload["data"] = map[string]interface{}{
-					"success":     true,
-					"averageTime": 8.5,
-					"packetLoss":  0,
-					"message":     "mock tcp ok",
-				}
+			handler := handlers[cmdType]
+			if handler == nil && cmdType == "TcpPing" {
+				handler = mockTcpPingOK
+			}
+			var pushes []map[string]interface{}
+			if handler != nil {
+				respPayload["data"] = handler(cmd.Data, func(msg map[string]interface{}) {
+					pushes = append(pushes, msg)
+				})
 			}
 			respBytes, err := json.Marshal(respPayload)
 			if err != nil {
 				continue
 			}
 			_ = conn.WriteMessage(websocket.TextMessage, respBytes)
+			for _, msg := range pushes {
+				pushBytes, err := json.Marshal(msg)
+				if err != nil {
+					continue
+				}
+				_ = conn.WriteMessage(websocket.TextMessage, pushBytes)
+			}
 		}
 	}()
 
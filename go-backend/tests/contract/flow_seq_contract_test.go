@@ -0,0 +1,108 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFlowUploadDeduplicatesJournaledReports(t *testing.T) {
+	router, repo := setupContractRouter(t, "contract-jwt-secret")
+	now := time.Now().UnixMilli()
+
+	if err := repo.DB().Exec(`
+		INSERT INTO user(id, user, pwd, role_id, exp_time, flow, in_flow, out_flow, flow_reset_time, num, created_time, updated_time, status)
+		VALUES(2, 'seq_user', '3c85cdebade1c51cf64ca9f3c09d182d', 1, 2727251700000, 99999, 0, 0, 1, 99999, ?, ?, 1)
+	`, now, now).Error; err != nil {
+		t.Fatalf("insert user: %v", err)
+	}
+	if err := repo.DB().Exec(`
+		INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+		VALUES('seq-tunnel', 1.0, 1, 'tls', 99999, ?, ?, 1, NULL, 0)
+	`, now, now).Error; err != nil {
+		t.Fatalf("insert tunnel: %v", err)
+	}
+	tunnelID := mustLastInsertID(t, repo, "seq-tunnel")
+	if err := repo.DB().Exec(`
+		INSERT INTO node(name, secret, server_ip, server_ip_v4, server_ip_v6, port, interface_name, version, http, tls, socks, created_time, updated_time, status, tcp_listen_addr, udp_listen_addr, inx)
+		VALUES('seq-node', 'seq-node-secret', '10.50.0.1', '10.50.0.1', '', '26000-26010', '', 'v1', 1, 1, 1, ?, ?, 0, '[::]', '[::]', 0)
+	`, now, now).Error; err != nil {
+		t.Fatalf("insert node: %v", err)
+	}
+	if err := repo.DB().Exec(`
+		INSERT INTO forward(user_id, user_name, name, tunnel_id, remote_addr, strategy, in_flow, out_flow, created_time, updated_time, status, inx)
+		VALUES(2, 'seq_user', 'seq-forward', ?, '8.8.8.8:53', 'fifo', 0, 0, ?, ?, 1, 0)
+	`, tunnelID, now, now).Error; err != nil {
+		t.Fatalf("insert forward: %v", err)
+	}
+	forwardID := mustLastInsertID(t, repo, "seq-forward")
+
+	report, _ := json.Marshal([]map[string]interface{}{
+		{"n": fmt.Sprintf("%d_2_0_tcp", forwardID), "u": 100, "d": 1000},
+	})
+	upload := func(query string) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/flow/upload?secret=seq-node-secret"+query, bytes.NewReader(report))
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		body, _ := io.ReadAll(res.Body)
+		if string(body) != "ok" {
+			t.Fatalf("upload %q: expected ok, got %q", query, body)
+		}
+	}
+	inFlow := func() int64 {
+		return mustQueryInt64(t, repo, `SELECT in_flow FROM forward WHERE id = ?`, forwardID)
+	}
+
+	upload("&seq=1000")
+	applied := inFlow()
+	if applied <= 0 {
+		t.Fatalf("expected the first report to be applied")
+	}
+
+	// A resend whose first response was lost is ignored.
+	upload("&seq=1000")
+	if got := inFlow(); got != applied {
+		t.Fatalf("expected the resent report to be ignored, got %d want %d", got, applied)
+	}
+
+	upload("&seq=1001")
+	// Agents that do not number their reports are applied as before.
+	upload("")
+	if got := inFlow(); got != 3*applied {
+		t.Fatalf("expected three applied reports, got %d want %d", got, 3*applied)
+	}
+	if n := mustQueryInt(t, repo, `SELECT COUNT(1) FROM node_flow_seq WHERE node_id = (SELECT id FROM node WHERE name = 'seq-node')`); n != 2 {
+		t.Fatalf("expected two recorded sequence numbers, got %d", n)
+	}
+
+	// A failed write rolls back the whole report, sequence number included,
+	// so the agent's resend is applied once the database recovers.
+	if err := repo.DB().Exec(`
+		CREATE TRIGGER seq_user_locked BEFORE UPDATE ON user
+		BEGIN SELECT RAISE(ABORT, 'locked'); END
+	`).Error; err != nil {
+		t.Fatalf("create trigger: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/flow/upload?secret=seq-node-secret&seq=1002", bytes.NewReader(report))
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	if res.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 on a failed write, got %d", res.Code)
+	}
+	if got := inFlow(); got != 3*applied {
+		t.Fatalf("expected the failed report to be rolled back, got %d want %d", got, 3*applied)
+	}
+	if err := repo.DB().Exec(`DROP TRIGGER seq_user_locked`).Error; err != nil {
+		t.Fatalf("drop trigger: %v", err)
+	}
+	upload("&seq=1002")
+	if got := inFlow(); got != 4*applied {
+		t.Fatalf("expected the resent report to be applied, got %d want %d", got, 4*applied)
+	}
+}
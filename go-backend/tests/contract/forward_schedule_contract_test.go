@@ -0,0 +1,98 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-backend/internal/auth"
+	"go-backend/internal/http/response"
+)
+
+func TestForwardScheduleExpiryIsAdminOnly(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, r := setupContractRouter(t, secret)
+	now := time.Now().UnixMilli()
+	expireTime := now + int64(30*24*time.Hour/time.Millisecond)
+
+	if err := r.DB().Exec(`
+		INSERT INTO user(id, user, pwd, role_id, exp_time, flow, in_flow, out_flow, flow_reset_time, num, created_time, updated_time, status)
+		VALUES(2, 'normal_user', '3c85cdebade1c51cf64ca9f3c09d182d', 1, 2727251700000, 99999, 0, 0, 1, 99999, ?, ?, 1)
+	`, now, now).Error; err != nil {
+		t.Fatalf("insert user: %v", err)
+	}
+	if err := r.DB().Exec(`
+		INSERT INTO forward(user_id, user_name, name, tunnel_id, remote_addr, strategy, in_flow, out_flow, created_time, updated_time, status, inx, expire_time, expire_action)
+		VALUES(2, 'normal_user', 'short-term', 1, '1.1.1.1:443', 'fifo', 0, 0, ?, ?, 1, 0, ?, 'delete')
+	`, now, now, expireTime).Error; err != nil {
+		t.Fatalf("insert forward: %v", err)
+	}
+	forwardID := mustLastInsertID(t, r, "short-term")
+
+	userToken, err := auth.GenerateToken(2, "normal_user", 1, secret)
+	if err != nil {
+		t.Fatalf("generate user token: %v", err)
+	}
+	adminToken, err := auth.GenerateToken(1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	call := func(token string, body map[string]interface{}) response.R {
+		t.Helper()
+		body["id"] = forwardID
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/forward/schedule", bytes.NewReader(raw))
+		req.Header.Set("Authorization", token)
+		req.Header.Set("Content-Type", "application/json")
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		var out response.R
+		if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+			t.Fatalf("decode schedule response: %v", err)
+		}
+		return out
+	}
+	expiry := func() (int64, string) {
+		t.Helper()
+		var at int64
+		var action string
+		if err := r.DB().Raw(`SELECT expire_time, expire_action FROM forward WHERE id = ?`, forwardID).Row().Scan(&at, &action); err != nil {
+			t.Fatalf("query expiry: %v", err)
+		}
+		return at, action
+	}
+
+	for _, body := range []map[string]interface{}{
+		{"expireTime": 0},
+		{"expireTime": expireTime + 1, "expireAction": "delete"},
+		{"expireTime": expireTime, "expireAction": "pause"},
+	} {
+		if out := call(userToken, body); out.Code == 0 {
+			t.Fatalf("expected the owner to be refused changing the expiry with %v", body)
+		}
+		if at, action := expiry(); at != expireTime || action != "delete" {
+			t.Fatalf("expected the expiry to stay unchanged, got %d %q", at, action)
+		}
+	}
+
+	// Owners can still edit the schedule; the expiry is carried over.
+	if out := call(userToken, map[string]interface{}{"scheduleStart": now - 1000}); out.Code != 0 {
+		t.Fatalf("expected the owner to edit the schedule, got %+v", out)
+	}
+	if out := call(userToken, map[string]interface{}{"scheduleStart": now - 1000, "expireTime": expireTime, "expireAction": "delete"}); out.Code != 0 {
+		t.Fatalf("expected unchanged expiry fields to be accepted, got %+v", out)
+	}
+	if at, action := expiry(); at != expireTime || action != "delete" {
+		t.Fatalf("expected the expiry to be kept, got %d %q", at, action)
+	}
+
+	if out := call(adminToken, map[string]interface{}{"expireTime": 0}); out.Code != 0 {
+		t.Fatalf("expected the admin to clear the expiry, got %+v", out)
+	}
+	if at, action := expiry(); at != 0 || action != "" {
+		t.Fatalf("expected the expiry to be cleared, got %d %q", at, action)
+	}
+}
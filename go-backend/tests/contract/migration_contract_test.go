@@ -652,15 +652,21 @@ func TestOpenMigratesLegacyNodeDualStackColumns(t *testing.T) {
 
 	columns := readTableColumns(t, r.DB(), "node")
 
-	for _, required := range []string{"server_ip_v4", "server_ip_v6", "inx"} {
+	for _, required := range []string{"server_ip_v4", "server_ip_v6", "inx", "maintenance", "maintenance_time", "labels", "capacity_mbps", "max_forwards", "enroll_token_id", "enroll_id", "allow_protocols", "deny_protocols", "protocol_blocked"} {
 		if !columns[required] {
 			t.Fatalf("expected node column %q to exist after migration", required)
 		}
 	}
 
+	if !r.DB().Migrator().HasIndex("node", "idx_node_enroll") {
+		t.Fatalf("expected node index %q to exist after migration", "idx_node_enroll")
+	}
+
 	tunnelColumns := readTableColumns(t, r.DB(), "tunnel")
-	if !tunnelColumns["inx"] {
-		t.Fatalf("expected tunnel column %q to exist after migration", "inx")
+	for _, required := range []string{"inx", "in_node_selector", "out_node_selector", "allow_protocols", "deny_protocols"} {
+		if !tunnelColumns[required] {
+			t.Fatalf("expected tunnel column %q to exist after migration", required)
+		}
 	}
 }
 
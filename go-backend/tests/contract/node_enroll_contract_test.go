@@ -0,0 +1,260 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-backend/internal/auth"
+	"go-backend/internal/http/response"
+)
+
+func TestNodeEnrollmentWithJoinTokens(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	adminToken, err := auth.GenerateToken(1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	call := func(path string, body map[string]interface{}, authed bool) response.R {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(raw))
+		if authed {
+			req.Header.Set("Authorization", adminToken)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = "203.0.113.7:40000"
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		var out response.R
+		if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+			t.Fatalf("decode %s response: %v", path, err)
+		}
+		return out
+	}
+
+	if out := call("/api/v1/node/enroll-token/create", map[string]interface{}{"name": "x"}, false); out.Code != 401 {
+		t.Fatalf("expected token creation to require login, got %+v", out)
+	}
+	out := call("/api/v1/node/enroll-token/create", map[string]interface{}{
+		"name": "hk batch", "maxUses": 1, "expireHours": 2, "labels": "region=hk", "port": "30000-30100",
+	}, true)
+	if out.Code != 0 {
+		t.Fatalf("create enroll token failed: %+v", out)
+	}
+	created := out.Data.(map[string]interface{})
+	token, _ := created["token"].(string)
+	if token == "" {
+		t.Fatalf("expected the token in the create response, got %+v", created)
+	}
+	if n := mustQueryInt(t, repo, `SELECT COUNT(1) FROM node_enroll_token WHERE token_hash = ?`, token); n != 0 {
+		t.Fatalf("expected the token to be stored hashed")
+	}
+
+	out = call("/flow/enroll", map[string]interface{}{
+		"token": token, "name": "edge-1", "serverIpV4": "10.0.0.5", "serverIpV6": "2001:db8::5", "interfaceName": "eth0",
+	}, false)
+	if out.Code != 0 {
+		t.Fatalf("enroll failed: %+v", out)
+	}
+	enrolled := out.Data.(map[string]interface{})
+	nodeSecret, _ := enrolled["secret"].(string)
+	nodeID := int64(enrolled["id"].(float64))
+	if nodeSecret == "" || nodeID <= 0 {
+		t.Fatalf("expected node id and secret, got %+v", enrolled)
+	}
+	// The private IPv4 the agent saw is replaced by the address it came from.
+	var serverIP, ipv4, ipv6, iface, port, labels string
+	if err := repo.DB().Raw(`SELECT server_ip, server_ip_v4, server_ip_v6, interface_name, port, labels FROM node WHERE id = ?`, nodeID).
+		Row().Scan(&serverIP, &ipv4, &ipv6, &iface, &port, &labels); err != nil {
+		t.Fatalf("query node: %v", err)
+	}
+	if serverIP != "203.0.113.7" || ipv4 != "203.0.113.7" || ipv6 != "2001:db8::5" || iface != "eth0" {
+		t.Fatalf("unexpected node addresses: %s %s %s %s", serverIP, ipv4, ipv6, iface)
+	}
+	if port != "30000-30100" || labels != `{"region":"hk"}` {
+		t.Fatalf("expected token port range and labels on the node, got %q %q", port, labels)
+	}
+
+	// Single-use tokens cannot be reused.
+	if out := call("/flow/enroll", map[string]interface{}{"token": token, "name": "edge-2"}, false); out.Code == 0 {
+		t.Fatalf("expected a used token to be rejected")
+	}
+	if out := call("/flow/enroll", map[string]interface{}{"token": "fxe_unknown"}, false); out.Code == 0 {
+		t.Fatalf("expected an unknown token to be rejected")
+	}
+
+	out = call("/api/v1/node/enroll-token/create", map[string]interface{}{"name": "multi", "maxUses": 0}, true)
+	multi := out.Data.(map[string]interface{})
+	for i := 0; i < 2; i++ {
+		if out := call("/flow/enroll", map[string]interface{}{"token": multi["token"], "name": "edge-1"}, false); out.Code != 0 {
+			t.Fatalf("multi-use enroll %d failed: %+v", i, out)
+		}
+	}
+	if n := mustQueryInt(t, repo, `SELECT COUNT(1) FROM node WHERE name IN ('edge-1', 'edge-1-2', 'edge-1-3')`); n != 3 {
+		t.Fatalf("expected duplicate names to get a suffix, got %d nodes", n)
+	}
+
+	out = call("/api/v1/node/enroll-token/list", map[string]interface{}{}, true)
+	items := out.Data.([]interface{})
+	if len(items) != 2 {
+		t.Fatalf("expected 2 tokens, got %+v", items)
+	}
+	for _, raw := range items {
+		item := raw.(map[string]interface{})
+		if _, leaked := item["token"]; leaked {
+			t.Fatalf("token list must not expose tokens")
+		}
+		if item["name"] == "hk batch" && (item["usedCount"].(float64) != 1 || item["exhausted"] != true) {
+			t.Fatalf("expected the single-use token to be exhausted, got %+v", item)
+		}
+	}
+
+	if out := call("/api/v1/node/enroll-token/delete", map[string]interface{}{"id": multi["id"]}, true); out.Code != 0 {
+		t.Fatalf("delete token failed: %+v", out)
+	}
+	if out := call("/flow/enroll", map[string]interface{}{"token": multi["token"]}, false); out.Code == 0 {
+		t.Fatalf("expected a revoked token to be rejected")
+	}
+
+	// The returned secret is the node's normal credential.
+	stop := startMockNodeSession(t, server.URL, nodeSecret)
+	defer stop()
+	waitNodeStatus(t, repo, nodeID, 1)
+
+	out = call("/api/v1/node/enroll-token/create", map[string]interface{}{"name": "late", "expireHours": 1}, true)
+	late := out.Data.(map[string]interface{})
+	if err := repo.DB().Exec(`UPDATE node_enroll_token SET expire_time = ? WHERE id = ?`, time.Now().Add(-time.Second).UnixMilli(), int64(late["id"].(float64))).Error; err != nil {
+		t.Fatalf("expire token: %v", err)
+	}
+	if out := call("/flow/enroll", map[string]interface{}{"token": late["token"]}, false); out.Code == 0 {
+		t.Fatalf("expected an expired token to be rejected")
+	}
+}
+
+func TestNodeEnrollmentRetryReturnsSameNode(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+
+	adminToken, err := auth.GenerateToken(1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	call := func(path string, body map[string]interface{}, authed bool) response.R {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(raw))
+		if authed {
+			req.Header.Set("Authorization", adminToken)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = "203.0.113.8:40000"
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		var out response.R
+		if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+			t.Fatalf("decode %s response: %v", path, err)
+		}
+		return out
+	}
+
+	out := call("/api/v1/node/enroll-token/create", map[string]interface{}{"name": "retry", "maxUses": 1}, true)
+	if out.Code != 0 {
+		t.Fatalf("create enroll token failed: %+v", out)
+	}
+	token := out.Data.(map[string]interface{})["token"].(string)
+
+	// The agent lost the first response and retries with the same id.
+	var first map[string]interface{}
+	for i := 0; i < 2; i++ {
+		out := call("/flow/enroll", map[string]interface{}{"token": token, "enrollId": "a1b2c3", "name": "retry-edge"}, false)
+		if out.Code != 0 {
+			t.Fatalf("enroll attempt %d failed: %+v", i, out)
+		}
+		data := out.Data.(map[string]interface{})
+		if first == nil {
+			first = data
+			continue
+		}
+		if data["id"] != first["id"] || data["secret"] != first["secret"] || data["name"] != first["name"] {
+			t.Fatalf("expected the retry to return the same node, got %+v and %+v", first, data)
+		}
+	}
+	if n := mustQueryInt(t, repo, `SELECT COUNT(1) FROM node WHERE name LIKE 'retry-edge%'`); n != 1 {
+		t.Fatalf("expected a single node, got %d", n)
+	}
+	if n := mustQueryInt(t, repo, `SELECT used_count FROM node_enroll_token WHERE name = 'retry'`); n != 1 {
+		t.Fatalf("expected the retry not to use the token again, got %d uses", n)
+	}
+
+	// Another agent with the same token is still held to its use limit.
+	if out := call("/flow/enroll", map[string]interface{}{"token": token, "enrollId": "d4e5f6", "name": "retry-edge"}, false); out.Code == 0 {
+		t.Fatalf("expected a different enrollment id to be rejected")
+	}
+}
+
+func TestNodeEnrollmentRollsBackWhenMarkFails(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+
+	adminToken, err := auth.GenerateToken(1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	call := func(path string, body map[string]interface{}, authed bool) response.R {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(raw))
+		if authed {
+			req.Header.Set("Authorization", adminToken)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = "203.0.113.9:40000"
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		var out response.R
+		if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+			t.Fatalf("decode %s response: %v", path, err)
+		}
+		return out
+	}
+
+	out := call("/api/v1/node/enroll-token/create", map[string]interface{}{"name": "rollback", "maxUses": 1}, true)
+	if out.Code != 0 {
+		t.Fatalf("create enroll token failed: %+v", out)
+	}
+	token := out.Data.(map[string]interface{})["token"].(string)
+
+	if err := repo.DB().Exec(`
+		CREATE TRIGGER enroll_mark_locked BEFORE UPDATE OF enroll_id ON node
+		BEGIN SELECT RAISE(ABORT, 'enroll mark locked'); END`).Error; err != nil {
+		t.Fatalf("create trigger: %v", err)
+	}
+	body := map[string]interface{}{"token": token, "enrollId": "f00d", "name": "rollback-edge"}
+	if out := call("/flow/enroll", body, false); out.Code == 0 {
+		t.Fatalf("expected the enrollment to fail while the mark cannot be written")
+	}
+	if n := mustQueryInt(t, repo, `SELECT COUNT(1) FROM node WHERE name LIKE 'rollback-edge%'`); n != 0 {
+		t.Fatalf("expected no node without its enrollment mark, got %d", n)
+	}
+	if n := mustQueryInt(t, repo, `SELECT used_count FROM node_enroll_token WHERE name = 'rollback'`); n != 0 {
+		t.Fatalf("expected the token use to be released, got %d uses", n)
+	}
+
+	if err := repo.DB().Exec(`DROP TRIGGER enroll_mark_locked`).Error; err != nil {
+		t.Fatalf("drop trigger: %v", err)
+	}
+	if out := call("/flow/enroll", body, false); out.Code != 0 {
+		t.Fatalf("expected the retry to enroll, got %+v", out)
+	}
+	if n := mustQueryInt(t, repo, `SELECT COUNT(1) FROM node WHERE name = 'rollback-edge' AND enroll_id = 'f00d'`); n != 1 {
+		t.Fatalf("expected the retried node to carry its enrollment, got %d", n)
+	}
+}
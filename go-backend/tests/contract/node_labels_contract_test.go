@@ -0,0 +1,246 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-backend/internal/auth"
+	"go-backend/internal/http/response"
+)
+
+func TestNodeLabelSelectorsPlaceTunnelsAndForwards(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+	now := time.Now().UnixMilli()
+
+	adminToken, err := auth.GenerateToken(1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	call := func(path string, body map[string]interface{}) response.R {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(raw))
+		req.Header.Set("Authorization", adminToken)
+		req.Header.Set("Content-Type", "application/json")
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		var out response.R
+		if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+			t.Fatalf("decode %s response: %v", path, err)
+		}
+		return out
+	}
+
+	insertNode := func(name, ip string) int64 {
+		if err := repo.DB().Exec(`
+			INSERT INTO node(name, secret, server_ip, server_ip_v4, server_ip_v6, port, interface_name, version, http, tls, socks, created_time, updated_time, status, tcp_listen_addr, udp_listen_addr, inx)
+			VALUES(?, ?, ?, ?, '', '22000-22010', '', 'v1', 1, 1, 1, ?, ?, 0, '[::]', '[::]', 0)
+		`, name, name+"-secret", ip, ip, now, now).Error; err != nil {
+			t.Fatalf("insert node %s: %v", name, err)
+		}
+		return mustLastInsertID(t, repo, name)
+	}
+	hk1 := insertNode("label-hk-1", "10.30.0.1")
+	hk2 := insertNode("label-hk-2", "10.30.0.2")
+	jp1 := insertNode("label-jp-1", "10.30.0.3")
+
+	setLabels := func(id int64, name, ip string, extra map[string]interface{}) {
+		body := map[string]interface{}{"id": id, "name": name, "serverIp": ip, "port": "22000-22010"}
+		for k, v := range extra {
+			body[k] = v
+		}
+		if out := call("/api/v1/node/update", body); out.Code != 0 {
+			t.Fatalf("update node %s failed: %+v", name, out)
+		}
+	}
+	setLabels(hk1, "label-hk-1", "10.30.0.1", map[string]interface{}{"labels": "region=hk,tier=premium", "maxForwards": 1})
+	setLabels(hk2, "label-hk-2", "10.30.0.2", map[string]interface{}{"labels": map[string]interface{}{"region": "hk"}, "capacityMbps": 1000})
+	setLabels(jp1, "label-jp-1", "10.30.0.3", map[string]interface{}{"labels": "region=jp"})
+	if out := call("/api/v1/node/update", map[string]interface{}{"id": jp1, "name": "label-jp-1", "serverIp": "10.30.0.3", "labels": "bad key=x"}); out.Code == 0 {
+		t.Fatalf("expected invalid labels to be rejected")
+	}
+
+	out := call("/api/v1/node/list", map[string]interface{}{"labels": "region=hk"})
+	if out.Code != 0 {
+		t.Fatalf("node list failed: %+v", out)
+	}
+	if items := out.Data.([]interface{}); len(items) != 2 {
+		t.Fatalf("expected 2 hk nodes, got %d", len(items))
+	}
+	out = call("/api/v1/node/list", map[string]interface{}{"labels": "region=hk,tier=premium"})
+	if items := out.Data.([]interface{}); len(items) != 1 || items[0].(map[string]interface{})["maxForwards"].(float64) != 1 {
+		t.Fatalf("expected only hk-1 with maxForwards 1, got %+v", items)
+	}
+
+	stopHK1 := startMockNodeSession(t, server.URL, "label-hk-1-secret")
+	defer stopHK1()
+	stopJP1 := startMockNodeSession(t, server.URL, "label-jp-1-secret")
+	defer stopJP1()
+	waitNodeStatus(t, repo, hk1, 1)
+	waitNodeStatus(t, repo, jp1, 1)
+
+	out = call("/api/v1/tunnel/create", map[string]interface{}{
+		"name": "label-tunnel", "type": 1, "flow": 99999, "status": 1,
+		"inNodeSelector": "region=hk", "inNodeProtocol": "tls",
+	})
+	if out.Code != 0 {
+		t.Fatalf("create tunnel by selector failed: %+v", out)
+	}
+	tunnelID := mustLastInsertID(t, repo, "label-tunnel")
+	// hk-2 is offline, so only hk-1 is selected.
+	if n := mustQueryInt(t, repo, `SELECT COUNT(1) FROM chain_tunnel WHERE tunnel_id = ? AND chain_type = 1`, tunnelID); n != 1 {
+		t.Fatalf("expected 1 entry node, got %d", n)
+	}
+	if n := mustQueryInt(t, repo, `SELECT COUNT(1) FROM tunnel WHERE id = ? AND in_node_selector = 'region=hk'`, tunnelID); n != 1 {
+		t.Fatalf("expected the selector to be stored")
+	}
+	if out := call("/api/v1/tunnel/create", map[string]interface{}{
+		"name": "label-tunnel-none", "type": 1, "flow": 99999, "status": 1, "inNodeSelector": "region=us",
+	}); out.Code == 0 {
+		t.Fatalf("expected a selector without matches to fail")
+	}
+
+	if err := repo.DB().Exec(`
+		INSERT INTO user(id, user, pwd, role_id, exp_time, flow, in_flow, out_flow, flow_reset_time, num, created_time, updated_time, status)
+		VALUES(2, 'label_user', '3c85cdebade1c51cf64ca9f3c09d182d', 1, 2727251700000, 99999, 0, 0, 1, 99999, ?, ?, 1)
+	`, now, now).Error; err != nil {
+		t.Fatalf("insert user: %v", err)
+	}
+	out = call("/api/v1/forward/create", map[string]interface{}{"name": "label-forward-1", "tunnelId": tunnelID, "remoteAddr": "1.1.1.1:80"})
+	if out.Code != 0 {
+		t.Fatalf("create forward failed: %+v", out)
+	}
+	forwardID := mustQueryInt64(t, repo, `SELECT id FROM forward WHERE name = 'label-forward-1'`)
+	// hk-1 allows a single forward.
+	if out := call("/api/v1/forward/create", map[string]interface{}{"name": "label-forward-2", "tunnelId": tunnelID, "remoteAddr": "1.1.1.1:81"}); out.Code == 0 {
+		t.Fatalf("expected forward creation on a full node to fail")
+	}
+
+	// hk-2 coming online joins the tunnel and receives its forwards.
+	stopHK2 := startMockNodeSession(t, server.URL, "label-hk-2-secret")
+	defer stopHK2()
+	waitNodeStatus(t, repo, hk2, 1)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ports := mustQueryNodePorts(t, repo, `SELECT node_id, port FROM forward_port WHERE forward_id = ?`, forwardID)
+		if _, ok := ports[hk2]; ok && len(ports) == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the forward on hk-2 after it came online, got %v", ports)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if n := mustQueryInt(t, repo, `SELECT COUNT(1) FROM chain_tunnel WHERE tunnel_id = ? AND chain_type = 1 AND node_id = ?`, tunnelID, hk2); n != 1 {
+		t.Fatalf("expected hk-2 to be an entry node of the tunnel")
+	}
+}
+
+func TestSelectorJoinAvoidsPortsUsedOnJoiningNode(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+	now := time.Now().UnixMilli()
+
+	adminToken, err := auth.GenerateToken(1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	call := func(path string, body map[string]interface{}) response.R {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(raw))
+		req.Header.Set("Authorization", adminToken)
+		req.Header.Set("Content-Type", "application/json")
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		var out response.R
+		if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+			t.Fatalf("decode %s response: %v", path, err)
+		}
+		return out
+	}
+
+	insertNode := func(name, ip string) int64 {
+		if err := repo.DB().Exec(`
+			INSERT INTO node(name, secret, server_ip, server_ip_v4, server_ip_v6, port, interface_name, version, http, tls, socks, created_time, updated_time, status, tcp_listen_addr, udp_listen_addr, inx)
+			VALUES(?, ?, ?, ?, '', '22000-22002', '', 'v1', 1, 1, 1, ?, ?, 0, '[::]', '[::]', 0)
+		`, name, name+"-secret", ip, ip, now, now).Error; err != nil {
+			t.Fatalf("insert node %s: %v", name, err)
+		}
+		id := mustLastInsertID(t, repo, name)
+		if out := call("/api/v1/node/update", map[string]interface{}{"id": id, "name": name, "serverIp": ip, "port": "22000-22002", "labels": "region=hk"}); out.Code != 0 {
+			t.Fatalf("update node %s failed: %+v", name, out)
+		}
+		return id
+	}
+	hk1 := insertNode("join-hk-1", "10.31.0.1")
+	hk2 := insertNode("join-hk-2", "10.31.0.2")
+
+	stopHK1 := startMockNodeSession(t, server.URL, "join-hk-1-secret")
+	defer stopHK1()
+	waitNodeStatus(t, repo, hk1, 1)
+
+	out := call("/api/v1/tunnel/create", map[string]interface{}{
+		"name": "join-tunnel", "type": 1, "flow": 99999, "status": 1,
+		"inNodeSelector": "region=hk", "inNodeProtocol": "tls",
+	})
+	if out.Code != 0 {
+		t.Fatalf("create tunnel by selector failed: %+v", out)
+	}
+	tunnelID := mustLastInsertID(t, repo, "join-tunnel")
+	out = call("/api/v1/forward/create", map[string]interface{}{"name": "join-forward", "tunnelId": tunnelID, "remoteAddr": "1.1.1.1:80"})
+	if out.Code != 0 {
+		t.Fatalf("create forward failed: %+v", out)
+	}
+	forwardID := mustQueryInt64(t, repo, `SELECT id FROM forward WHERE name = 'join-forward'`)
+	port := mustQueryInt(t, repo, `SELECT port FROM forward_port WHERE forward_id = ? AND node_id = ?`, forwardID, hk1)
+
+	// hk-2 already serves another tunnel's forward on the same port.
+	if err := repo.DB().Exec(`
+		INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+		VALUES('join-other-tunnel', 1.0, 1, 'tls', 99999, ?, ?, 1, NULL, 0)
+	`, now, now).Error; err != nil {
+		t.Fatalf("insert tunnel: %v", err)
+	}
+	otherTunnelID := mustLastInsertID(t, repo, "join-other-tunnel")
+	if err := repo.DB().Exec(`
+		INSERT INTO forward(user_id, user_name, name, tunnel_id, remote_addr, strategy, in_flow, out_flow, created_time, updated_time, status, inx)
+		VALUES(1, 'admin_user', 'join-other-forward', ?, '8.8.8.8:53', 'fifo', 0, 0, ?, ?, 1, 0)
+	`, otherTunnelID, now, now).Error; err != nil {
+		t.Fatalf("insert forward: %v", err)
+	}
+	otherID := mustLastInsertID(t, repo, "join-other-forward")
+	if err := repo.DB().Exec(`INSERT INTO forward_port(forward_id, node_id, port) VALUES(?, ?, ?)`, otherID, hk2, port).Error; err != nil {
+		t.Fatalf("insert forward_port: %v", err)
+	}
+
+	stopHK2 := startMockNodeSession(t, server.URL, "join-hk-2-secret")
+	defer stopHK2()
+	waitNodeStatus(t, repo, hk2, 1)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ports := mustQueryNodePorts(t, repo, `SELECT node_id, port FROM forward_port WHERE forward_id = ?`, forwardID)
+		if p, ok := ports[hk2]; ok {
+			if p == port || p < 22000 || p > 22002 {
+				t.Fatalf("expected a free port on hk-2 instead of %d, got %d", port, p)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the forward on hk-2 after it came online, got %v", ports)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if n := mustQueryInt(t, repo, `SELECT COUNT(1) FROM forward_port WHERE node_id = ? AND port = ?`, hk2, port); n != 1 {
+		t.Fatalf("expected the other forward to keep port %d on hk-2, got %d rows", port, n)
+	}
+}
@@ -0,0 +1,191 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"go-backend/internal/auth"
+	"go-backend/internal/http/response"
+)
+
+func TestNodeLogsTailAndLevel(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, r := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	adminToken, err := auth.GenerateToken(1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	call := func(path string, body map[string]interface{}) response.R {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(raw))
+		req.Header.Set("Authorization", adminToken)
+		req.Header.Set("Content-Type", "application/json")
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		var out response.R
+		if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+			t.Fatalf("decode %s response: %v", path, err)
+		}
+		return out
+	}
+
+	now := time.Now().UnixMilli()
+	if err := r.DB().Exec(`
+		INSERT INTO node(name, secret, server_ip, server_ip_v4, server_ip_v6, port, interface_name, version, http, tls, socks, created_time, updated_time, status, tcp_listen_addr, udp_listen_addr, inx)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, "logs-node", "logs-node-secret", "10.0.7.10", "10.0.7.10", "", "30000-30010", "", "v1", 1, 1, 1, now, now, 0, "[::]", "[::]", 0).Error; err != nil {
+		t.Fatalf("insert node: %v", err)
+	}
+	nodeID := mustLastInsertID(t, r, "logs-node")
+
+	admin, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/system-info?type=0&secret="+adminToken, nil)
+	if err != nil {
+		t.Fatalf("dial admin websocket: %v", err)
+	}
+	defer admin.Close()
+	// readAdmin returns the next admin message of the given type.
+	readAdmin := func(msgType string) map[string]interface{} {
+		t.Helper()
+		_ = admin.SetReadDeadline(time.Now().Add(3 * time.Second))
+		for {
+			_, raw, err := admin.ReadMessage()
+			if err != nil {
+				t.Fatalf("read admin websocket waiting for %q: %v", msgType, err)
+			}
+			var msg map[string]interface{}
+			if json.Unmarshal(raw, &msg) == nil && valueAsString(msg["type"]) == msgType {
+				return msg
+			}
+		}
+	}
+
+	// A followed tail gets tailID and one more entry pushed after the
+	// response.
+	const tailID = "mock-tail"
+	handlers := map[string]mockCommandHandler{
+		"TailLogs": func(data json.RawMessage, push func(map[string]interface{})) interface{} {
+			var req struct {
+				Service string `json:"service"`
+				Follow  bool   `json:"follow"`
+			}
+			_ = json.Unmarshal(data, &req)
+			out := map[string]interface{}{
+				"entries": []map[string]interface{}{
+					{"seq": 41, "time": 1000, "level": "info", "source": "gost", "service": req.Service, "message": "listening"},
+				},
+			}
+			if req.Follow {
+				out["tailId"] = tailID
+				push(map[string]interface{}{
+					"type":    "LogEntries",
+					"success": true,
+					"message": "OK",
+					"data": map[string]interface{}{
+						"tailId": tailID,
+						"entries": []map[string]interface{}{
+							{"seq": 42, "time": 2000, "level": "error", "source": "agent", "message": "❌ mock failure"},
+						},
+					},
+				})
+			}
+			return out
+		},
+		"SetLogLevel": func(data json.RawMessage, _ func(map[string]interface{})) interface{} {
+			var req struct {
+				Service string `json:"service"`
+				Level   string `json:"level"`
+			}
+			_ = json.Unmarshal(data, &req)
+			return map[string]interface{}{"service": req.Service, "level": req.Level, "expiresAt": 2000}
+		},
+		"StopLogTail": func(json.RawMessage, func(map[string]interface{})) interface{} {
+			return map[string]interface{}{"stopped": 1}
+		},
+	}
+	var commands []string
+	stop := startMockNodeSessionWithHandlers(t, server.URL, "logs-node-secret", func(cmdType string) {
+		commands = append(commands, cmdType)
+	}, handlers)
+	defer stop()
+	waitNodeStatus(t, r, nodeID, 1)
+	readAdmin("status")
+
+	if out := call("/api/v1/node/logs", map[string]interface{}{"nodeId": nodeID, "level": "verbose"}); out.Code == 0 {
+		t.Fatalf("expected invalid level to be rejected, got %+v", out)
+	}
+
+	out := call("/api/v1/node/logs", map[string]interface{}{
+		"nodeId":  nodeID,
+		"service": "7_2_1_tcp",
+		"level":   "WARNING",
+		"follow":  true,
+	})
+	if out.Code != 0 {
+		t.Fatalf("expected tail success, got %+v", out)
+	}
+	data := out.Data.(map[string]interface{})
+	if valueAsString(data["tailId"]) != tailID || valueAsInt(data["nodeId"]) != int(nodeID) {
+		t.Fatalf("unexpected tail response: %+v", data)
+	}
+	entries := data["entries"].([]interface{})
+	if len(entries) != 1 || valueAsString(entries[0].(map[string]interface{})["service"]) != "7_2_1_tcp" {
+		t.Fatalf("unexpected tail entries: %+v", entries)
+	}
+
+	msg := readAdmin("logs")
+	if valueAsInt(msg["id"]) != int(nodeID) {
+		t.Fatalf("expected logs message for node %d, got %+v", nodeID, msg)
+	}
+	var pushed struct {
+		Data struct {
+			TailID  string `json:"tailId"`
+			Entries []struct {
+				Seq   int    `json:"seq"`
+				Level string `json:"level"`
+			} `json:"entries"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(valueAsString(msg["data"])), &pushed); err != nil {
+		t.Fatalf("decode pushed entries: %v", err)
+	}
+	if pushed.Data.TailID != tailID || len(pushed.Data.Entries) != 1 || pushed.Data.Entries[0].Seq != 42 {
+		t.Fatalf("unexpected pushed entries: %+v", pushed)
+	}
+
+	if out := call("/api/v1/node/logs/stop", map[string]interface{}{"nodeId": nodeID, "tailId": tailID}); out.Code != 0 ||
+		valueAsInt(out.Data.(map[string]interface{})["stopped"]) != 1 {
+		t.Fatalf("expected tail stop success, got %+v", out)
+	}
+
+	if out := call("/api/v1/node/log-level", map[string]interface{}{"nodeId": nodeID, "level": "debug"}); out.Code == 0 {
+		t.Fatalf("expected missing service to be rejected, got %+v", out)
+	}
+	out = call("/api/v1/node/log-level", map[string]interface{}{
+		"nodeId":   nodeID,
+		"service":  "7_2_1_tcp",
+		"level":    "debug",
+		"duration": 300,
+	})
+	if out.Code != 0 {
+		t.Fatalf("expected log level success, got %+v", out)
+	}
+	if data := out.Data.(map[string]interface{}); valueAsString(data["level"]) != "debug" || valueAsInt(data["expiresAt"]) == 0 {
+		t.Fatalf("unexpected log level response: %+v", data)
+	}
+
+	want := []string{"TailLogs", "StopLogTail", "SetLogLevel"}
+	if strings.Join(commands, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected commands %v, got %v", want, commands)
+	}
+}
@@ -0,0 +1,322 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"go-backend/internal/auth"
+	"go-backend/internal/http/response"
+)
+
+func TestNodeMaintenanceMigratesAndRestoresForwards(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+	now := time.Now().UnixMilli()
+
+	adminToken, err := auth.GenerateToken(1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	if err := repo.DB().Exec(`
+		INSERT INTO user(id, user, pwd, role_id, exp_time, flow, in_flow, out_flow, flow_reset_time, num, created_time, updated_time, status)
+		VALUES(2, 'maint_user', '3c85cdebade1c51cf64ca9f3c09d182d', 1, 2727251700000, 99999, 0, 0, 1, 99999, ?, ?, 1)
+	`, now, now).Error; err != nil {
+		t.Fatalf("insert user: %v", err)
+	}
+	if err := repo.DB().Exec(`
+		INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+		VALUES('maint-tunnel', 1.0, 1, 'tls', 99999, ?, ?, 1, NULL, 0)
+	`, now, now).Error; err != nil {
+		t.Fatalf("insert tunnel: %v", err)
+	}
+	tunnelID := mustLastInsertID(t, repo, "maint-tunnel")
+
+	insertNode := func(name, ip, portRange string) int64 {
+		if err := repo.DB().Exec(`
+			INSERT INTO node(name, secret, server_ip, server_ip_v4, server_ip_v6, port, interface_name, version, http, tls, socks, created_time, updated_time, status, tcp_listen_addr, udp_listen_addr, inx)
+			VALUES(?, ?, ?, ?, '', ?, '', 'v1', 1, 1, 1, ?, ?, 0, '[::]', '[::]', 0)
+		`, name, name+"-secret", ip, ip, portRange, now, now).Error; err != nil {
+			t.Fatalf("insert node %s: %v", name, err)
+		}
+		id := mustLastInsertID(t, repo, name)
+		if err := repo.DB().Exec(`
+			INSERT INTO chain_tunnel(tunnel_id, chain_type, node_id, port, strategy, inx, protocol)
+			VALUES(?, 1, ?, NULL, 'round', 1, 'tls')
+		`, tunnelID, id).Error; err != nil {
+			t.Fatalf("insert chain_tunnel %s: %v", name, err)
+		}
+		return id
+	}
+	nodeA := insertNode("maint-node-a", "10.20.0.1", "21000-21010")
+	nodeB := insertNode("maint-node-b", "10.20.0.2", "21000-21010")
+
+	var mu sync.Mutex
+	commandsA := map[string]int{}
+	stopA := startMockNodeSessionWithHook(t, server.URL, "maint-node-a-secret", func(cmdType string) {
+		mu.Lock()
+		commandsA[cmdType]++
+		mu.Unlock()
+	})
+	defer stopA()
+	stopB := startMockNodeSession(t, server.URL, "maint-node-b-secret")
+	defer stopB()
+	waitNodeStatus(t, repo, nodeA, 1)
+	waitNodeStatus(t, repo, nodeB, 1)
+
+	// The forward only listens on node A, so migration has to place it on B.
+	if err := repo.DB().Exec(`
+		INSERT INTO forward(user_id, user_name, name, tunnel_id, remote_addr, strategy, in_flow, out_flow, created_time, updated_time, status, inx)
+		VALUES(2, 'maint_user', 'maint-forward', ?, '8.8.8.8:53', 'fifo', 0, 0, ?, ?, 1, 0)
+	`, tunnelID, now, now).Error; err != nil {
+		t.Fatalf("insert forward: %v", err)
+	}
+	forwardID := mustLastInsertID(t, repo, "maint-forward")
+	if err := repo.DB().Exec(`INSERT INTO forward_port(forward_id, node_id, port) VALUES(?, ?, 21003)`, forwardID, nodeA).Error; err != nil {
+		t.Fatalf("insert forward_port: %v", err)
+	}
+
+	call := func(path string, body map[string]interface{}) response.R {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(raw))
+		req.Header.Set("Authorization", adminToken)
+		req.Header.Set("Content-Type", "application/json")
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		var out response.R
+		if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+			t.Fatalf("decode %s response: %v", path, err)
+		}
+		return out
+	}
+
+	out := call("/api/v1/node/maintenance", map[string]interface{}{"id": nodeA, "enabled": true, "migrate": true})
+	if out.Code != 0 {
+		t.Fatalf("enter maintenance failed: %+v", out)
+	}
+	data := out.Data.(map[string]interface{})
+	forwards := data["forwards"].([]interface{})
+	if len(forwards) != 1 || forwards[0].(map[string]interface{})["action"] != "moved" {
+		t.Fatalf("expected the forward to be moved, got %+v", forwards)
+	}
+	users := data["users"].([]interface{})
+	if len(users) != 1 || users[0].(map[string]interface{})["userName"] != "maint_user" {
+		t.Fatalf("expected maint_user to be reported, got %+v", users)
+	}
+	ports := mustQueryNodePorts(t, repo, `SELECT node_id, port FROM forward_port WHERE forward_id = ?`, forwardID)
+	if len(ports) != 1 || ports[nodeB] != 21003 {
+		t.Fatalf("expected the forward on node B port 21003, got %v", ports)
+	}
+	mu.Lock()
+	deleted := commandsA["DeleteService"]
+	mu.Unlock()
+	if deleted == 0 {
+		t.Fatalf("expected the service to be deleted from node A")
+	}
+	if n := mustQueryInt(t, repo, `SELECT maintenance FROM node WHERE id = ?`, nodeA); n != 1 {
+		t.Fatalf("expected node A in maintenance")
+	}
+
+	// New forwards and tunnels stay off the node.
+	out = call("/api/v1/forward/create", map[string]interface{}{"name": "new-forward", "tunnelId": tunnelID, "remoteAddr": "1.1.1.1:80"})
+	if out.Code != 0 {
+		t.Fatalf("create forward failed: %+v", out)
+	}
+	newID := mustQueryInt64(t, repo, `SELECT id FROM forward WHERE name = 'new-forward'`)
+	if n := mustQueryInt(t, repo, `SELECT COUNT(1) FROM forward_port WHERE forward_id = ? AND node_id = ?`, newID, nodeA); n != 0 {
+		t.Fatalf("expected no port on the node in maintenance")
+	}
+	out = call("/api/v1/tunnel/create", map[string]interface{}{
+		"name": "maint-tunnel-2", "type": 1, "flow": 99999, "status": 1,
+		"inNodeId": []map[string]interface{}{{"nodeId": nodeA, "protocol": "tls", "strategy": "round"}},
+	})
+	if out.Code == 0 {
+		t.Fatalf("expected tunnel creation on a node in maintenance to fail")
+	}
+
+	out = call("/api/v1/node/maintenance", map[string]interface{}{"id": nodeA, "enabled": false, "restore": true})
+	if out.Code != 0 {
+		t.Fatalf("leave maintenance failed: %+v", out)
+	}
+	forwards = out.Data.(map[string]interface{})["forwards"].([]interface{})
+	if len(forwards) != 1 || forwards[0].(map[string]interface{})["action"] != "restored" {
+		t.Fatalf("expected the forward to be restored, got %+v", forwards)
+	}
+	ports = mustQueryNodePorts(t, repo, `SELECT node_id, port FROM forward_port WHERE forward_id = ?`, forwardID)
+	if len(ports) != 2 || ports[nodeA] != 21003 {
+		t.Fatalf("expected the forward back on node A port 21003, got %v", ports)
+	}
+	if n := mustQueryInt(t, repo, `SELECT COUNT(1) FROM node_maintenance_move`); n != 0 {
+		t.Fatalf("expected maintenance moves to be cleared, got %d", n)
+	}
+}
+
+func TestNodeMaintenanceMigrationAvoidsUsedPorts(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+	now := time.Now().UnixMilli()
+
+	adminToken, err := auth.GenerateToken(1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	if err := repo.DB().Exec(`
+		INSERT INTO user(id, user, pwd, role_id, exp_time, flow, in_flow, out_flow, flow_reset_time, num, created_time, updated_time, status)
+		VALUES(2, 'maint_user', '3c85cdebade1c51cf64ca9f3c09d182d', 1, 2727251700000, 99999, 0, 0, 1, 99999, ?, ?, 1)
+	`, now, now).Error; err != nil {
+		t.Fatalf("insert user: %v", err)
+	}
+	insertTunnel := func(name string) int64 {
+		if err := repo.DB().Exec(`
+			INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+			VALUES(?, 1.0, 1, 'tls', 99999, ?, ?, 1, NULL, 0)
+		`, name, now, now).Error; err != nil {
+			t.Fatalf("insert tunnel: %v", err)
+		}
+		return mustLastInsertID(t, repo, name)
+	}
+	tunnelID := insertTunnel("maint-tunnel")
+	otherTunnelID := insertTunnel("maint-other-tunnel")
+
+	insertNode := func(name, ip string) int64 {
+		if err := repo.DB().Exec(`
+			INSERT INTO node(name, secret, server_ip, server_ip_v4, server_ip_v6, port, interface_name, version, http, tls, socks, created_time, updated_time, status, tcp_listen_addr, udp_listen_addr, inx)
+			VALUES(?, ?, ?, ?, '', '21000-21002', '', 'v1', 1, 1, 1, ?, ?, 0, '[::]', '[::]', 0)
+		`, name, name+"-secret", ip, ip, now, now).Error; err != nil {
+			t.Fatalf("insert node %s: %v", name, err)
+		}
+		id := mustLastInsertID(t, repo, name)
+		if err := repo.DB().Exec(`
+			INSERT INTO chain_tunnel(tunnel_id, chain_type, node_id, port, strategy, inx, protocol)
+			VALUES(?, 1, ?, NULL, 'round', 1, 'tls')
+		`, tunnelID, id).Error; err != nil {
+			t.Fatalf("insert chain_tunnel %s: %v", name, err)
+		}
+		return id
+	}
+	nodeA := insertNode("maint-node-a", "10.20.0.1")
+	nodeB := insertNode("maint-node-b", "10.20.0.2")
+	stopA := startMockNodeSession(t, server.URL, "maint-node-a-secret")
+	defer stopA()
+	stopB := startMockNodeSession(t, server.URL, "maint-node-b-secret")
+	defer stopB()
+	waitNodeStatus(t, repo, nodeA, 1)
+	waitNodeStatus(t, repo, nodeB, 1)
+
+	insertForward := func(name string, tunnel, nodeID int64, port int) int64 {
+		if err := repo.DB().Exec(`
+			INSERT INTO forward(user_id, user_name, name, tunnel_id, remote_addr, strategy, in_flow, out_flow, created_time, updated_time, status, inx)
+			VALUES(2, 'maint_user', ?, ?, '8.8.8.8:53', 'fifo', 0, 0, ?, ?, 1, 0)
+		`, name, tunnel, now, now).Error; err != nil {
+			t.Fatalf("insert forward: %v", err)
+		}
+		id := mustLastInsertID(t, repo, name)
+		if err := repo.DB().Exec(`INSERT INTO forward_port(forward_id, node_id, port) VALUES(?, ?, ?)`, id, nodeID, port).Error; err != nil {
+			t.Fatalf("insert forward_port: %v", err)
+		}
+		return id
+	}
+	forwardID := insertForward("maint-forward", tunnelID, nodeA, 21001)
+	// Another tunnel's forward already owns port 21001 on node B.
+	insertForward("maint-other-forward", otherTunnelID, nodeB, 21001)
+
+	raw, _ := json.Marshal(map[string]interface{}{"id": nodeA, "enabled": true, "migrate": true})
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/node/maintenance", bytes.NewReader(raw))
+	req.Header.Set("Authorization", adminToken)
+	req.Header.Set("Content-Type", "application/json")
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	var out response.R
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if out.Code != 0 {
+		t.Fatalf("enter maintenance failed: %+v", out)
+	}
+
+	ports := mustQueryNodePorts(t, repo, `SELECT node_id, port FROM forward_port WHERE forward_id = ?`, forwardID)
+	port, ok := ports[nodeB]
+	if len(ports) != 1 || !ok {
+		t.Fatalf("expected the forward on node B, got %v", ports)
+	}
+	if port == 21001 || port < 21000 || port > 21002 {
+		t.Fatalf("expected a free port in node B's range, got %d", port)
+	}
+}
+
+func TestForwardUpdateKeepsPortOnNodeInMaintenance(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+	now := time.Now().UnixMilli()
+
+	adminToken, err := auth.GenerateToken(1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	if err := repo.DB().Exec(`
+		INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+		VALUES('maint-only-tunnel', 1.0, 1, 'tls', 99999, ?, ?, 1, NULL, 0)
+	`, now, now).Error; err != nil {
+		t.Fatalf("insert tunnel: %v", err)
+	}
+	tunnelID := mustLastInsertID(t, repo, "maint-only-tunnel")
+	if err := repo.DB().Exec(`
+		INSERT INTO node(name, secret, server_ip, server_ip_v4, server_ip_v6, port, interface_name, version, http, tls, socks, created_time, updated_time, status, tcp_listen_addr, udp_listen_addr, inx)
+		VALUES('maint-only-node', 'maint-only-secret', '10.30.0.1', '10.30.0.1', '', '21000-21010', '', 'v1', 1, 1, 1, ?, ?, 0, '[::]', '[::]', 0)
+	`, now, now).Error; err != nil {
+		t.Fatalf("insert node: %v", err)
+	}
+	nodeID := mustLastInsertID(t, repo, "maint-only-node")
+	if err := repo.DB().Exec(`
+		INSERT INTO chain_tunnel(tunnel_id, chain_type, node_id, port, strategy, inx, protocol)
+		VALUES(?, 1, ?, NULL, 'round', 1, 'tls')
+	`, tunnelID, nodeID).Error; err != nil {
+		t.Fatalf("insert chain_tunnel: %v", err)
+	}
+	stop := startMockNodeSession(t, server.URL, "maint-only-secret")
+	defer stop()
+	waitNodeStatus(t, repo, nodeID, 1)
+
+	if err := repo.DB().Exec(`
+		INSERT INTO forward(user_id, user_name, name, tunnel_id, remote_addr, strategy, in_flow, out_flow, created_time, updated_time, status, inx)
+		VALUES(1, 'admin_user', 'maint-only-forward', ?, '8.8.8.8:53', 'fifo', 0, 0, ?, ?, 1, 0)
+	`, tunnelID, now, now).Error; err != nil {
+		t.Fatalf("insert forward: %v", err)
+	}
+	forwardID := mustLastInsertID(t, repo, "maint-only-forward")
+	if err := repo.DB().Exec(`INSERT INTO forward_port(forward_id, node_id, port) VALUES(?, ?, 21004)`, forwardID, nodeID).Error; err != nil {
+		t.Fatalf("insert forward_port: %v", err)
+	}
+	if err := repo.DB().Exec(`UPDATE node SET maintenance = 1 WHERE id = ?`, nodeID).Error; err != nil {
+		t.Fatalf("set maintenance: %v", err)
+	}
+
+	raw, _ := json.Marshal(map[string]interface{}{"id": forwardID, "remoteAddr": "1.1.1.1:53"})
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/forward/update", bytes.NewReader(raw))
+	req.Header.Set("Authorization", adminToken)
+	req.Header.Set("Content-Type", "application/json")
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	var out response.R
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if out.Code != 0 {
+		t.Fatalf("update forward failed: %+v", out)
+	}
+	ports := mustQueryNodePorts(t, repo, `SELECT node_id, port FROM forward_port WHERE forward_id = ?`, forwardID)
+	if len(ports) != 1 || ports[nodeID] != 21004 {
+		t.Fatalf("expected the forward to keep node port 21004, got %v", ports)
+	}
+}
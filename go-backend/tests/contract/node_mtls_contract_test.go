@@ -0,0 +1,196 @@
+package contract_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"go-backend/internal/auth"
+	httpserver "go-backend/internal/http"
+	"go-backend/internal/http/handler"
+	"go-backend/internal/http/response"
+	"go-backend/internal/store/repo"
+)
+
+func TestNodeMutualTLSEnrollmentAndPinning(t *testing.T) {
+	secret := "contract-jwt-secret"
+	r, err := repo.Open(filepath.Join(t.TempDir(), "contract.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = r.Close() })
+	h := handler.New(r, secret)
+	router := httpserver.NewRouter(h, secret)
+	plain := httptest.NewServer(router)
+	defer plain.Close()
+
+	tlsConfig, err := h.NodeTLSConfig()
+	if err != nil {
+		t.Fatalf("node tls config: %v", err)
+	}
+	h.EnableNodeTLS("panel.example.com:6367")
+	secure := httptest.NewUnstartedServer(httpserver.NewNodeRouter(router))
+	secure.TLS = tlsConfig
+	secure.StartTLS()
+	defer secure.Close()
+
+	adminToken, err := auth.GenerateToken(1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	call := func(path string, body map[string]interface{}) response.R {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(raw))
+		req.Header.Set("Authorization", adminToken)
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = "203.0.113.9:40000"
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		var out response.R
+		if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+			t.Fatalf("decode %s response: %v", path, err)
+		}
+		return out
+	}
+
+	out := call("/api/v1/node/enroll-token/create", map[string]interface{}{"name": "mtls"})
+	if out.Code != 0 {
+		t.Fatalf("create enroll token failed: %+v", out)
+	}
+	token := out.Data.(map[string]interface{})["token"].(string)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "edge"}}, key)
+	if err != nil {
+		t.Fatalf("create csr: %v", err)
+	}
+	csrPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER}))
+
+	out = call("/flow/enroll", map[string]interface{}{"token": token, "name": "mtls-edge", "csr": csrPEM})
+	if out.Code != 0 {
+		t.Fatalf("enroll failed: %+v", out)
+	}
+	enrolled := out.Data.(map[string]interface{})
+	nodeSecret, _ := enrolled["secret"].(string)
+	certPEM, _ := enrolled["certificate"].(string)
+	pin, _ := enrolled["panelPin"].(string)
+	if certPEM == "" || pin == "" || enrolled["tlsAddr"] != "panel.example.com:6367" {
+		t.Fatalf("expected certificate material in the enroll response, got %+v", enrolled)
+	}
+	nodeID := int64(enrolled["id"].(float64))
+
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	clientCert, err := tls.X509KeyPair([]byte(certPEM), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	if err != nil {
+		t.Fatalf("load issued certificate: %v", err)
+	}
+	pinned := func(certs []tls.Certificate) *tls.Config {
+		return &tls.Config{
+			Certificates:       certs,
+			InsecureSkipVerify: true,
+			VerifyPeerCertificate: func(raw [][]byte, _ [][]*x509.Certificate) error {
+				leaf, err := x509.ParseCertificate(raw[0])
+				if err != nil {
+					return err
+				}
+				sum := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+				if "sha256/"+base64.StdEncoding.EncodeToString(sum[:]) != pin {
+					return errors.New("pin mismatch")
+				}
+				return nil
+			},
+		}
+	}
+	secureClient := &http.Client{Transport: &http.Transport{TLSClientConfig: pinned([]tls.Certificate{clientCert})}}
+
+	upload := func(client *http.Client, base string) (int, string) {
+		t.Helper()
+		res, err := client.Post(base+"/flow/upload?secret="+nodeSecret, "application/json", strings.NewReader("[]"))
+		if err != nil {
+			return 0, err.Error()
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return res.StatusCode, strings.TrimSpace(string(body))
+	}
+
+	// The node now holds a certificate, so its secret alone is refused.
+	if status, _ := upload(http.DefaultClient, plain.URL); status != http.StatusForbidden {
+		t.Fatalf("expected the plain upload to be refused, got %d", status)
+	}
+	if status, body := upload(secureClient, secure.URL); status != http.StatusOK || body != "ok" {
+		t.Fatalf("expected the mTLS upload to succeed, got %d %q", status, body)
+	}
+	// Without a client certificate the handshake fails.
+	noCert := &http.Client{Transport: &http.Transport{TLSClientConfig: pinned(nil)}}
+	if status, _ := upload(noCert, secure.URL); status != 0 {
+		t.Fatalf("expected a connection without certificate to fail, got %d", status)
+	}
+	res, err := secureClient.Post(secure.URL+"/api/v1/node/list", "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatalf("post node list: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected only node endpoints on the mTLS listener, got %d", res.StatusCode)
+	}
+
+	wsURL := "wss" + strings.TrimPrefix(secure.URL, "https") + "/system-info?type=1&version=v1&secret=" + nodeSecret
+	dialer := websocket.Dialer{TLSClientConfig: pinned([]tls.Certificate{clientCert})}
+	conn, _, err := dialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial node websocket over mTLS: %v", err)
+	}
+	defer conn.Close()
+	waitNodeStatus(t, r, nodeID, 1)
+	if _, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(plain.URL, "http")+"/system-info?type=1&version=v1&secret="+nodeSecret, nil); err == nil {
+		t.Fatalf("expected the plain websocket to be refused")
+	}
+
+	if out := call("/api/v1/node/certificate/list", map[string]interface{}{"id": nodeID}); out.Code != 0 || len(out.Data.([]interface{})) != 1 {
+		t.Fatalf("expected one issued certificate, got %+v", out)
+	}
+	if out := call("/api/v1/node/certificate/revoke", map[string]interface{}{"id": nodeID}); out.Code != 0 {
+		t.Fatalf("revoke failed: %+v", out)
+	}
+	// Revoking closes the live session so the agent has to reconnect.
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				t.Fatalf("expected the session to be closed after revocation")
+			}
+			break
+		}
+	}
+	waitNodeStatus(t, r, nodeID, 0)
+	if status, _ := upload(secureClient, secure.URL); status != http.StatusForbidden {
+		t.Fatalf("expected a revoked certificate to be refused, got %d", status)
+	}
+	if status, body := upload(http.DefaultClient, plain.URL); status != http.StatusOK || body != "ok" {
+		t.Fatalf("expected secret-only mode after revocation, got %d %q", status, body)
+	}
+}
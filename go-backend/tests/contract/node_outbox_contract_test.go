@@ -0,0 +1,288 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"go-backend/internal/auth"
+	"go-backend/internal/http/response"
+)
+
+func TestNodeOutboxQueuesCommandsForDisconnectedNode(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+	now := time.Now().UnixMilli()
+
+	adminToken, err := auth.GenerateToken(1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	call := func(path string, body map[string]interface{}) response.R {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(raw))
+		req.Header.Set("Authorization", adminToken)
+		req.Header.Set("Content-Type", "application/json")
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		var out response.R
+		if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+			t.Fatalf("decode %s response: %v", path, err)
+		}
+		return out
+	}
+
+	// A node that dropped just now and one that has been gone for two days
+	// but was edited since.
+	insertNodeWithTunnel := func(name string, disconnected int64) (int64, int64) {
+		if err := repo.DB().Exec(`
+			INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+			VALUES(?, 1.0, 1, 'tls', 99999, ?, ?, 1, NULL, 0)
+		`, name+"-tunnel", now, now).Error; err != nil {
+			t.Fatalf("insert tunnel: %v", err)
+		}
+		tunnelID := mustLastInsertID(t, repo, name+"-tunnel")
+		if err := repo.DB().Exec(`
+			INSERT INTO node(name, secret, server_ip, server_ip_v4, server_ip_v6, port, interface_name, version, http, tls, socks, created_time, updated_time, disconnected_time, status, tcp_listen_addr, udp_listen_addr, inx)
+			VALUES(?, ?, '10.40.0.1', '10.40.0.1', '', '25000-25010', '', 'v1', 1, 1, 1, ?, ?, ?, 0, '[::]', '[::]', 0)
+		`, name, name+"-secret", now, now, disconnected).Error; err != nil {
+			t.Fatalf("insert node %s: %v", name, err)
+		}
+		nodeID := mustLastInsertID(t, repo, name)
+		if err := repo.DB().Exec(`
+			INSERT INTO chain_tunnel(tunnel_id, chain_type, node_id, port, strategy, inx, protocol)
+			VALUES(?, 1, ?, NULL, 'round', 1, 'tls')
+		`, tunnelID, nodeID).Error; err != nil {
+			t.Fatalf("insert chain_tunnel %s: %v", name, err)
+		}
+		return nodeID, tunnelID
+	}
+	nodeID, tunnelID := insertNodeWithTunnel("outbox-node", now)
+	_, staleTunnelID := insertNodeWithTunnel("outbox-stale", now-int64(48*time.Hour/time.Millisecond))
+
+	if out := call("/api/v1/forward/create", map[string]interface{}{"name": "stale-forward", "tunnelId": staleTunnelID, "remoteAddr": "1.1.1.1:80"}); out.Code == 0 {
+		t.Fatalf("expected a node offline for days to fail immediately")
+	}
+
+	out := call("/api/v1/forward/create", map[string]interface{}{"name": "queued-forward", "tunnelId": tunnelID, "remoteAddr": "1.1.1.1:80"})
+	if out.Code != 0 {
+		t.Fatalf("expected the forward to be queued, got %+v", out)
+	}
+	forwardID := mustQueryInt64(t, repo, `SELECT id FROM forward WHERE name = 'queued-forward'`)
+
+	pending := func() map[string]int {
+		t.Helper()
+		out := call("/api/v1/node/outbox", map[string]interface{}{"id": nodeID})
+		if out.Code != 0 {
+			t.Fatalf("list outbox failed: %+v", out)
+		}
+		counts := map[string]int{}
+		for _, raw := range out.Data.([]interface{}) {
+			item := raw.(map[string]interface{})
+			if item["status"] != "pending" {
+				t.Fatalf("expected only pending commands, got %+v", item)
+			}
+			counts[item["commandType"].(string)]++
+		}
+		return counts
+	}
+	if counts := pending(); counts["AddService"] != 1 {
+		t.Fatalf("expected the service deployment to be queued, got %v", counts)
+	}
+
+	// Pausing twice queues the same commands once.
+	for i := 0; i < 2; i++ {
+		if out := call("/api/v1/forward/pause", map[string]interface{}{"id": forwardID}); out.Code != 0 {
+			t.Fatalf("pause %d failed: %+v", i, out)
+		}
+	}
+	counts := pending()
+	pauses := counts["PauseService"]
+	if pauses == 0 || pauses > 3 {
+		t.Fatalf("expected pause commands to be de-duplicated, got %v", counts)
+	}
+
+	if err := repo.DB().Exec(`UPDATE node_command SET expire_time = ? WHERE id = (SELECT MIN(id) FROM node_command WHERE command_type = 'PauseService' AND status = 0)`, now-1).Error; err != nil {
+		t.Fatalf("expire command: %v", err)
+	}
+
+	var mu sync.Mutex
+	received := make([]string, 0)
+	stop := startMockNodeSessionWithHook(t, server.URL, "outbox-node-secret", func(cmdType string) {
+		mu.Lock()
+		received = append(received, cmdType)
+		mu.Unlock()
+	})
+	defer stop()
+	waitNodeStatus(t, repo, nodeID, 1)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for mustQueryInt(t, repo, `SELECT COUNT(1) FROM node_command WHERE node_id = ? AND status = 0`, nodeID) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the outbox to be replayed")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	mu.Lock()
+	firstAdd, firstPause := -1, -1
+	for i, cmdType := range received {
+		if cmdType == "AddService" && firstAdd < 0 {
+			firstAdd = i
+		}
+		if cmdType == "PauseService" && firstPause < 0 {
+			firstPause = i
+		}
+	}
+	paused := 0
+	for _, cmdType := range received {
+		if cmdType == "PauseService" {
+			paused++
+		}
+	}
+	mu.Unlock()
+	if firstAdd < 0 || firstPause < firstAdd {
+		t.Fatalf("expected commands replayed in order, got %v", received)
+	}
+	if paused != pauses-1 {
+		t.Fatalf("expected the expired command to be skipped, got %d of %d pauses", paused, pauses)
+	}
+
+	out = call("/api/v1/node/outbox", map[string]interface{}{"id": nodeID, "all": true})
+	statuses := map[string]int{}
+	for _, raw := range out.Data.([]interface{}) {
+		statuses[raw.(map[string]interface{})["status"].(string)]++
+	}
+	if statuses["done"] == 0 || statuses["expired"] != 1 || statuses["cancelled"] == 0 {
+		t.Fatalf("unexpected outbox history: %v", statuses)
+	}
+	if out := call("/api/v1/node/outbox/cancel", map[string]interface{}{"id": 1}); out.Code == 0 {
+		t.Fatalf("expected a finished command not to be cancellable")
+	}
+
+	// Closing the session records when the node went offline.
+	stop()
+	waitNodeStatus(t, repo, nodeID, 0)
+	if at := mustQueryInt64(t, repo, `SELECT disconnected_time FROM node WHERE id = ?`, nodeID); at <= now {
+		t.Fatalf("expected the disconnect time to be recorded, got %d", at)
+	}
+}
+
+func TestNodeOutboxQueuesCommandsIssuedDuringReplay(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+	now := time.Now().UnixMilli()
+
+	adminToken, err := auth.GenerateToken(1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	call := func(path string, body map[string]interface{}) response.R {
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(raw))
+		req.Header.Set("Authorization", adminToken)
+		req.Header.Set("Content-Type", "application/json")
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		var out response.R
+		_ = json.NewDecoder(res.Body).Decode(&out)
+		return out
+	}
+
+	if err := repo.DB().Exec(`
+		INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+		VALUES('replay-tunnel', 1.0, 1, 'tls', 99999, ?, ?, 1, NULL, 0)
+	`, now, now).Error; err != nil {
+		t.Fatalf("insert tunnel: %v", err)
+	}
+	tunnelID := mustLastInsertID(t, repo, "replay-tunnel")
+	if err := repo.DB().Exec(`
+		INSERT INTO node(name, secret, server_ip, server_ip_v4, server_ip_v6, port, interface_name, version, http, tls, socks, created_time, updated_time, disconnected_time, status, tcp_listen_addr, udp_listen_addr, inx)
+		VALUES('replay-node', 'replay-node-secret', '10.41.0.1', '10.41.0.1', '', '25000-25010', '', 'v1', 1, 1, 1, ?, ?, ?, 0, '[::]', '[::]', 0)
+	`, now, now, now).Error; err != nil {
+		t.Fatalf("insert node: %v", err)
+	}
+	nodeID := mustLastInsertID(t, repo, "replay-node")
+	if err := repo.DB().Exec(`
+		INSERT INTO chain_tunnel(tunnel_id, chain_type, node_id, port, strategy, inx, protocol)
+		VALUES(?, 1, ?, NULL, 'round', 1, 'tls')
+	`, tunnelID, nodeID).Error; err != nil {
+		t.Fatalf("insert chain_tunnel: %v", err)
+	}
+
+	if out := call("/api/v1/forward/create", map[string]interface{}{"name": "replay-forward", "tunnelId": tunnelID, "remoteAddr": "1.1.1.1:80"}); out.Code != 0 {
+		t.Fatalf("expected the forward to be queued, got %+v", out)
+	}
+	forwardID := mustQueryInt64(t, repo, `SELECT id FROM forward WHERE name = 'replay-forward'`)
+
+	// The forward is paused while the node is still replaying its deployment;
+	// the pause must be sent after it, not ahead of it.
+	var (
+		mu       sync.Mutex
+		once     sync.Once
+		received []string
+		pauseOut response.R
+	)
+	stop := startMockNodeSessionWithHook(t, server.URL, "replay-node-secret", func(cmdType string) {
+		mu.Lock()
+		received = append(received, cmdType)
+		mu.Unlock()
+		if cmdType == "AddService" {
+			once.Do(func() {
+				out := call("/api/v1/forward/pause", map[string]interface{}{"id": forwardID})
+				mu.Lock()
+				pauseOut = out
+				mu.Unlock()
+			})
+		}
+	})
+	defer stop()
+	waitNodeStatus(t, repo, nodeID, 1)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		paused := false
+		for _, cmdType := range received {
+			paused = paused || cmdType == "PauseService"
+		}
+		mu.Unlock()
+		if paused && mustQueryInt(t, repo, `SELECT COUNT(1) FROM node_command WHERE node_id = ? AND status = 0`, nodeID) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the pause to be replayed, got %v", received)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if pauseOut.Code != 0 {
+		t.Fatalf("pause during replay failed: %+v", pauseOut)
+	}
+	firstAdd, firstPause := -1, -1
+	for i, cmdType := range received {
+		if cmdType == "AddService" && firstAdd < 0 {
+			firstAdd = i
+		}
+		if cmdType == "PauseService" && firstPause < 0 {
+			firstPause = i
+		}
+	}
+	if firstAdd < 0 || firstPause < firstAdd {
+		t.Fatalf("expected the queued deployment before the pause, got %v", received)
+	}
+	if n := mustQueryInt(t, repo, `SELECT COUNT(1) FROM node_command WHERE node_id = ? AND command_type = 'PauseService' AND status = 1`, nodeID); n == 0 {
+		t.Fatalf("expected the pause to go through the outbox")
+	}
+}
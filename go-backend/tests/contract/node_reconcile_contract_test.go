@@ -0,0 +1,359 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"go-backend/internal/auth"
+	"go-backend/internal/http/response"
+)
+
+func TestNodeReconcileRepairsDrift(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+	now := time.Now().UnixMilli()
+
+	adminToken, err := auth.GenerateToken(1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	if err := repo.DB().Exec(`
+		INSERT INTO user(id, user, pwd, role_id, exp_time, flow, in_flow, out_flow, flow_reset_time, num, created_time, updated_time, status)
+		VALUES(2, 'drift_user', '3c85cdebade1c51cf64ca9f3c09d182d', 1, 2727251700000, 99999, 0, 0, 1, 99999, ?, ?, 1)
+	`, now, now).Error; err != nil {
+		t.Fatalf("insert user: %v", err)
+	}
+	if err := repo.DB().Exec(`
+		INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+		VALUES('drift-tunnel', 1.0, 1, 'tls', 99999, ?, ?, 1, NULL, 0)
+	`, now, now).Error; err != nil {
+		t.Fatalf("insert tunnel: %v", err)
+	}
+	tunnelID := mustLastInsertID(t, repo, "drift-tunnel")
+
+	insertNode := func(name string) int64 {
+		if err := repo.DB().Exec(`
+			INSERT INTO node(name, secret, server_ip, server_ip_v4, server_ip_v6, port, interface_name, version, http, tls, socks, created_time, updated_time, status, tcp_listen_addr, udp_listen_addr, inx)
+			VALUES(?, ?, '10.30.0.1', '10.30.0.1', '', '22000-22010', '', 'v1', 1, 1, 1, ?, ?, 0, '[::]', '[::]', 0)
+		`, name, name+"-secret", now, now).Error; err != nil {
+			t.Fatalf("insert node %s: %v", name, err)
+		}
+		id := mustLastInsertID(t, repo, name)
+		if err := repo.DB().Exec(`
+			INSERT INTO chain_tunnel(tunnel_id, chain_type, node_id, port, strategy, inx, protocol)
+			VALUES(?, 1, ?, NULL, 'round', 1, 'tls')
+		`, tunnelID, id).Error; err != nil {
+			t.Fatalf("insert chain_tunnel %s: %v", name, err)
+		}
+		return id
+	}
+	nodeA := insertNode("drift-node-a")
+	nodeB := insertNode("drift-node-b")
+
+	insertForward := func(name string, status int, nodeID int64, port int) int64 {
+		if err := repo.DB().Exec(`
+			INSERT INTO forward(user_id, user_name, name, tunnel_id, remote_addr, strategy, in_flow, out_flow, created_time, updated_time, status, inx)
+			VALUES(2, 'drift_user', ?, ?, '8.8.8.8:53', 'fifo', 0, 0, ?, ?, ?, 0)
+		`, name, tunnelID, now, now, status).Error; err != nil {
+			t.Fatalf("insert forward %s: %v", name, err)
+		}
+		id := mustLastInsertID(t, repo, name)
+		if err := repo.DB().Exec(`INSERT INTO forward_port(forward_id, node_id, port) VALUES(?, ?, ?)`, id, nodeID, port).Error; err != nil {
+			t.Fatalf("insert forward_port %s: %v", name, err)
+		}
+		return id
+	}
+	running := insertForward("drift-running", 1, nodeA, 22001)
+	paused := insertForward("drift-paused", 0, nodeA, 22002)
+	moved := insertForward("drift-moved", 1, nodeB, 22003)
+
+	var mu sync.Mutex
+	commands := map[string]int{}
+	stop := startMockNodeSessionWithHook(t, server.URL, "drift-node-a-secret", func(cmdType string) {
+		mu.Lock()
+		commands[cmdType]++
+		mu.Unlock()
+	})
+	defer stop()
+	waitNodeStatus(t, repo, nodeA, 1)
+
+	call := func(path string, body map[string]interface{}) response.R {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(raw))
+		req.Header.Set("Authorization", adminToken)
+		req.Header.Set("Content-Type", "application/json")
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		var out response.R
+		if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+			t.Fatalf("decode %s response: %v", path, err)
+		}
+		return out
+	}
+
+	if out := call("/api/v1/node/drift", map[string]interface{}{"id": nodeA}); out.Code == 0 {
+		t.Fatalf("expected drift to require a config report, got %+v", out)
+	}
+
+	service := func(name string, port int, target string, paused bool) map[string]interface{} {
+		svc := map[string]interface{}{
+			"name":      name,
+			"addr":      fmt.Sprintf("[::]:%d", port),
+			"handler":   map[string]interface{}{"type": "tcp"},
+			"listener":  map[string]interface{}{"type": "tcp"},
+			"forwarder": map[string]interface{}{"nodes": []map[string]interface{}{{"name": "node_1", "addr": target}}},
+		}
+		if paused {
+			svc["metadata"] = map[string]interface{}{"paused": true}
+		}
+		return svc
+	}
+	udp := func(svc map[string]interface{}) map[string]interface{} {
+		svc["name"] = svc["name"].(string)[:len(svc["name"].(string))-4] + "_udp"
+		svc["handler"] = map[string]interface{}{"type": "udp"}
+		svc["listener"] = map[string]interface{}{"type": "udp"}
+		return svc
+	}
+	runningBase := fmt.Sprintf("%d_2_0", running)
+	pausedBase := fmt.Sprintf("%d_2_0", paused)
+	movedBase := fmt.Sprintf("%d_2_0", moved)
+	report, _ := json.Marshal(map[string]interface{}{
+		"services": []map[string]interface{}{
+			{"name": "web_api", "addr": ":18080"},
+			// Pointing at a stale target; its UDP half is missing.
+			service(runningBase+"_tcp", 22001, "1.1.1.1:53", false),
+			// Paused in the database but running on the node.
+			service(pausedBase+"_tcp", 22002, "8.8.8.8:53", false),
+			udp(service(pausedBase+"_tcp", 22002, "8.8.8.8:53", false)),
+			// The forward now listens on node B only.
+			service(movedBase+"_tcp", 22003, "8.8.8.8:53", false),
+		},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/flow/config?secret=drift-node-a-secret", bytes.NewReader(report))
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	// The report is repaired in the background.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		done := commands["UpdateService"] > 0 && commands["PauseService"] > 0 && commands["DeleteService"] > 0
+		mu.Unlock()
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			mu.Lock()
+			t.Fatalf("expected the report to be repaired, got commands %v", commands)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	statuses := func(data map[string]interface{}) map[string]map[string]interface{} {
+		out := map[string]map[string]interface{}{}
+		for _, raw := range data["items"].([]interface{}) {
+			item := raw.(map[string]interface{})
+			out[item["name"].(string)] = item
+		}
+		return out
+	}
+	expected := map[string]string{
+		runningBase + "_tcp": "drifted",
+		runningBase + "_udp": "missing",
+		pausedBase + "_tcp":  "unpaused",
+		pausedBase + "_udp":  "unpaused",
+		movedBase + "_tcp":   "orphaned",
+	}
+
+	out := call("/api/v1/node/drift", map[string]interface{}{"id": nodeA})
+	if out.Code != 0 {
+		t.Fatalf("drift failed: %+v", out)
+	}
+	drift := out.Data.(map[string]interface{})
+	items := statuses(drift)
+	if len(items) != len(expected) || drift["inSync"] != false {
+		t.Fatalf("unexpected drift report: %+v", drift)
+	}
+	for name, status := range expected {
+		if items[name] == nil || items[name]["status"] != status || items[name]["repaired"] != false {
+			t.Fatalf("expected %s to be %s, got %+v", name, status, items[name])
+		}
+	}
+	if fields := items[runningBase+"_tcp"]["fields"].([]interface{}); len(fields) != 1 || fields[0] != "forwarder" {
+		t.Fatalf("expected only the forwarder to differ, got %v", fields)
+	}
+
+	// The mock agent returns no config, so the last report is reconciled.
+	out = call("/api/v1/node/reconcile", map[string]interface{}{"id": nodeA})
+	if out.Code != 0 {
+		t.Fatalf("reconcile failed: %+v", out)
+	}
+	result := out.Data.(map[string]interface{})
+	if result["source"] != "report" {
+		t.Fatalf("expected the last report to be used, got %v", result["source"])
+	}
+	for name, item := range statuses(result) {
+		if item["status"] != expected[name] || item["repaired"] != true {
+			t.Fatalf("expected %s to be repaired, got %+v", name, item)
+		}
+	}
+
+	if out := call("/api/v1/node/reconcile", map[string]interface{}{"id": nodeB}); out.Code == 0 {
+		t.Fatalf("expected reconcile of an offline node to fail")
+	}
+}
+
+func TestNodeReconcileComparesAdmissionsAndProtocolRules(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+	now := time.Now().UnixMilli()
+
+	adminToken, err := auth.GenerateToken(1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	if err := repo.DB().Exec(`
+		INSERT INTO user(id, user, pwd, role_id, exp_time, flow, in_flow, out_flow, flow_reset_time, num, created_time, updated_time, status)
+		VALUES(2, 'acl_user', '3c85cdebade1c51cf64ca9f3c09d182d', 1, 2727251700000, 99999, 0, 0, 1, 99999, ?, ?, 1)
+	`, now, now).Error; err != nil {
+		t.Fatalf("insert user: %v", err)
+	}
+	if err := repo.DB().Exec(`
+		INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+		VALUES('acl-tunnel', 1.0, 1, 'tls', 99999, ?, ?, 1, NULL, 0)
+	`, now, now).Error; err != nil {
+		t.Fatalf("insert tunnel: %v", err)
+	}
+	tunnelID := mustLastInsertID(t, repo, "acl-tunnel")
+	if err := repo.DB().Exec(`
+		INSERT INTO node(name, secret, server_ip, server_ip_v4, server_ip_v6, port, interface_name, version, http, tls, socks, created_time, updated_time, status, tcp_listen_addr, udp_listen_addr, inx, deny_cidrs, deny_protocols)
+		VALUES('acl-node', 'acl-node-secret', '10.30.0.1', '10.30.0.1', '', '22000-22010', '', 'v1', 1, 1, 1, ?, ?, 0, '[::]', '[::]', 0, '203.0.113.0/24', 'bittorrent')
+	`, now, now).Error; err != nil {
+		t.Fatalf("insert node: %v", err)
+	}
+	nodeID := mustLastInsertID(t, repo, "acl-node")
+	if err := repo.DB().Exec(`
+		INSERT INTO chain_tunnel(tunnel_id, chain_type, node_id, port, strategy, inx, protocol)
+		VALUES(?, 1, ?, NULL, 'round', 1, 'tls')
+	`, tunnelID, nodeID).Error; err != nil {
+		t.Fatalf("insert chain_tunnel: %v", err)
+	}
+	if err := repo.DB().Exec(`
+		INSERT INTO forward(user_id, user_name, name, tunnel_id, remote_addr, strategy, in_flow, out_flow, created_time, updated_time, status, inx)
+		VALUES(2, 'acl_user', 'acl-forward', ?, '8.8.8.8:53', 'fifo', 0, 0, ?, ?, 1, 0)
+	`, tunnelID, now, now).Error; err != nil {
+		t.Fatalf("insert forward: %v", err)
+	}
+	forwardID := mustLastInsertID(t, repo, "acl-forward")
+	if err := repo.DB().Exec(`INSERT INTO forward_port(forward_id, node_id, port) VALUES(?, ?, 22001)`, forwardID, nodeID).Error; err != nil {
+		t.Fatalf("insert forward_port: %v", err)
+	}
+
+	var mu sync.Mutex
+	commands := map[string]int{}
+	stop := startMockNodeSessionWithHook(t, server.URL, "acl-node-secret", func(cmdType string) {
+		mu.Lock()
+		commands[cmdType]++
+		mu.Unlock()
+	})
+	defer stop()
+	waitNodeStatus(t, repo, nodeID, 1)
+
+	call := func(path string, body map[string]interface{}) response.R {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(raw))
+		req.Header.Set("Authorization", adminToken)
+		req.Header.Set("Content-Type", "application/json")
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		var out response.R
+		if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+			t.Fatalf("decode %s response: %v", path, err)
+		}
+		return out
+	}
+
+	// The services match the forward but were pushed before the node got
+	// its source and protocol rules, so neither the admissions nor the
+	// protocol metadata are there.
+	base := fmt.Sprintf("%d_2_0", forwardID)
+	service := func(network string) map[string]interface{} {
+		return map[string]interface{}{
+			"name":      base + "_" + network,
+			"addr":      "[::]:22001",
+			"handler":   map[string]interface{}{"type": network},
+			"listener":  map[string]interface{}{"type": network},
+			"forwarder": map[string]interface{}{"nodes": []map[string]interface{}{{"name": "node_1", "addr": "8.8.8.8:53"}}},
+		}
+	}
+	report, _ := json.Marshal(map[string]interface{}{
+		"services":   []map[string]interface{}{service("tcp"), service("udp")},
+		"admissions": []map[string]interface{}{{"name": base + "_deny"}},
+	})
+	mu.Lock()
+	commands = map[string]int{}
+	mu.Unlock()
+	req := httptest.NewRequest(http.MethodPost, "/flow/config?secret=acl-node-secret", bytes.NewReader(report))
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	// The report is repaired in the background by re-syncing the forward,
+	// which pushes the admissions again.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		done := commands["UpdateAdmissions"] > 0 && commands["UpdateService"] > 0
+		mu.Unlock()
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			mu.Lock()
+			t.Fatalf("expected the admissions to be pushed again, got commands %v", commands)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	out := call("/api/v1/node/drift", map[string]interface{}{"id": nodeID})
+	if out.Code != 0 {
+		t.Fatalf("drift failed: %+v", out)
+	}
+	expected := map[string]string{
+		base + "_tcp":   "drifted",
+		base + "_udp":   "drifted",
+		base + "_allow": "missing",
+		base + "_deny":  "drifted",
+		base + "_geo":   "missing",
+	}
+	items := out.Data.(map[string]interface{})["items"].([]interface{})
+	if len(items) != len(expected) {
+		t.Fatalf("unexpected drift report: %+v", items)
+	}
+	for _, raw := range items {
+		item := raw.(map[string]interface{})
+		name := item["name"].(string)
+		if item["status"] != expected[name] {
+			t.Fatalf("expected %s to be %s, got %+v", name, expected[name], item)
+		}
+		switch name {
+		case base + "_tcp", base + "_udp":
+			if fields := fmt.Sprint(item["fields"]); fields != "[admissions protocol]" {
+				t.Fatalf("expected %s to drift in admissions and protocol, got %s", name, fields)
+			}
+		case base + "_deny":
+			if fields := fmt.Sprint(item["fields"]); fields != "[matchers]" {
+				t.Fatalf("expected the deny admission to drift in matchers, got %s", fields)
+			}
+		}
+	}
+}
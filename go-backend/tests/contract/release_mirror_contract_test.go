@@ -0,0 +1,195 @@
+package contract_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go-backend/internal/auth"
+	httpserver "go-backend/internal/http"
+	"go-backend/internal/http/handler"
+	"go-backend/internal/http/response"
+	"go-backend/internal/store/repo"
+)
+
+func TestReleaseMirrorUploadServeAndUpgrade(t *testing.T) {
+	secret := "contract-jwt-secret"
+	r, err := repo.Open(filepath.Join(t.TempDir(), "contract.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = r.Close() })
+	releaseDir := t.TempDir()
+	h := handler.New(r, secret)
+	h.SetReleaseDir(releaseDir)
+	router := httpserver.NewRouter(h, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	adminToken, err := auth.GenerateToken(1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	decode := func(path string, res *httptest.ResponseRecorder) response.R {
+		t.Helper()
+		var out response.R
+		if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+			t.Fatalf("decode %s response: %v", path, err)
+		}
+		return out
+	}
+	call := func(path string, body map[string]interface{}) response.R {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(raw))
+		req.Header.Set("Authorization", adminToken)
+		req.Header.Set("Content-Type", "application/json")
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		return decode(path, res)
+	}
+	upload := func(fields map[string]string, file []byte) response.R {
+		t.Helper()
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		for k, v := range fields {
+			_ = mw.WriteField(k, v)
+		}
+		part, _ := mw.CreateFormFile("file", "gost")
+		_, _ = part.Write(file)
+		_ = mw.Close()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/node/release/upload", &buf)
+		req.Header.Set("Authorization", adminToken)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		return decode("/api/v1/node/release/upload", res)
+	}
+
+	binary := bytes.Repeat([]byte("flux-agent-binary"), 1024)
+	_, signingKey, _ := ed25519.GenerateKey(nil)
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(signingKey, binary))
+	sum := sha256.Sum256(binary)
+	checksum := hex.EncodeToString(sum[:])
+
+	if out := upload(map[string]string{"version": "../etc", "arch": "amd64"}, binary); out.Code == 0 {
+		t.Fatalf("expected a path-like version to be rejected")
+	}
+	if out := upload(map[string]string{"version": "2.1.0", "arch": "amd64"}, binary); out.Code == 0 {
+		t.Fatalf("expected an unsigned upload to be rejected")
+	}
+	if out := upload(map[string]string{"version": "2.1.0", "arch": "amd64", "signature": "bm90LWEtc2lnbmF0dXJl"}, binary); out.Code == 0 {
+		t.Fatalf("expected a malformed signature to be rejected")
+	}
+	out := upload(map[string]string{"version": "2.1.0", "arch": "amd64", "signature": signature}, binary)
+	if out.Code != 0 {
+		t.Fatalf("upload failed: %+v", out)
+	}
+	artifact := out.Data.(map[string]interface{})
+	if artifact["sha256"] != checksum || artifact["signed"] != true {
+		t.Fatalf("unexpected artifact %+v", artifact)
+	}
+	if _, err := os.Stat(filepath.Join(releaseDir, "2.1.0", "gost-amd64")); err != nil {
+		t.Fatalf("expected the binary in the release dir: %v", err)
+	}
+
+	out = call("/api/v1/node/releases", map[string]interface{}{"source": "local"})
+	items, _ := out.Data.([]interface{})
+	if out.Code != 0 || len(items) != 1 {
+		t.Fatalf("expected one local release, got %+v", out)
+	}
+	if item := items[0].(map[string]interface{}); item["version"] != "2.1.0" || item["source"] != "local" {
+		t.Fatalf("unexpected local release item %+v", item)
+	}
+
+	now := time.Now().UnixMilli()
+	if err := r.DB().Exec(`
+		INSERT INTO node(name, secret, server_ip, server_ip_v4, server_ip_v6, port, interface_name, version, http, tls, socks, created_time, updated_time, status, tcp_listen_addr, udp_listen_addr, inx)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, "mirror-node", "mirror-secret", "10.0.2.10", "10.0.2.10", "", "30000-30010", "", "v1", 1, 1, 1, now, now, 0, "[::]", "[::]", 0).Error; err != nil {
+		t.Fatalf("insert node: %v", err)
+	}
+	nodeID := mustLastInsertID(t, r, "mirror-node")
+
+	fetch := func(path string) (int, string) {
+		t.Helper()
+		res, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("get %s: %v", path, err)
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return res.StatusCode, string(body)
+	}
+	if status, _ := fetch("/flow/release/download?version=2.1.0&arch=amd64&secret=wrong"); status != http.StatusForbidden {
+		t.Fatalf("expected an unknown secret to be refused, got %d", status)
+	}
+	if status, body := fetch("/flow/release/download?version=2.1.0&arch=amd64&secret=mirror-secret"); status != http.StatusOK || body != string(binary) {
+		t.Fatalf("unexpected download: %d (%d bytes)", status, len(body))
+	}
+	if status, body := fetch("/flow/release/checksum?version=2.1.0&arch=amd64&secret=mirror-secret"); status != http.StatusOK || strings.Fields(body)[0] != checksum {
+		t.Fatalf("unexpected checksum: %d %q", status, body)
+	}
+	if status, body := fetch("/flow/release/signature?version=2.1.0&arch=amd64&secret=mirror-secret"); status != http.StatusOK || strings.TrimSpace(body) != signature {
+		t.Fatalf("unexpected signature: %d %q", status, body)
+	}
+	if status, _ := fetch("/flow/release/download?version=2.1.0&arch=arm64&secret=mirror-secret"); status != http.StatusNotFound {
+		t.Fatalf("expected a missing arch to be 404, got %d", status)
+	}
+
+	var mu sync.Mutex
+	var commands []string
+	stop := startMockNodeSessionWithHook(t, server.URL, "mirror-secret", func(cmdType string) {
+		mu.Lock()
+		commands = append(commands, cmdType)
+		mu.Unlock()
+	})
+	defer stop()
+	waitNodeStatus(t, r, nodeID, 1)
+
+	out = call("/api/v1/node/upgrade", map[string]interface{}{"id": nodeID, "source": "local"})
+	if out.Code != 0 || out.Data.(map[string]interface{})["version"] != "2.1.0" {
+		t.Fatalf("expected the upgrade to use the hosted version, got %+v", out)
+	}
+	mu.Lock()
+	sawUpgrade := false
+	for _, c := range commands {
+		sawUpgrade = sawUpgrade || c == "UpgradeAgent"
+	}
+	mu.Unlock()
+	if !sawUpgrade {
+		t.Fatalf("expected an UpgradeAgent command, got %v", commands)
+	}
+	if out := call("/api/v1/node/upgrade", map[string]interface{}{"id": nodeID, "source": "local", "version": "9.9.9"}); out.Code == 0 {
+		t.Fatalf("expected an unhosted version to be rejected for the local source")
+	}
+
+	out = call("/api/v1/node/release/artifacts", map[string]interface{}{})
+	listed, _ := out.Data.([]interface{})
+	if out.Code != 0 || len(listed) != 1 {
+		t.Fatalf("expected one artifact, got %+v", out)
+	}
+	id := listed[0].(map[string]interface{})["id"]
+	if out := call("/api/v1/node/release/delete", map[string]interface{}{"id": id}); out.Code != 0 {
+		t.Fatalf("delete failed: %+v", out)
+	}
+	if _, err := os.Stat(filepath.Join(releaseDir, "2.1.0", "gost-amd64")); !os.IsNotExist(err) {
+		t.Fatalf("expected the binary to be removed, got %v", err)
+	}
+	if status, _ := fetch("/flow/release/download?version=2.1.0&arch=amd64&secret=mirror-secret"); status != http.StatusNotFound {
+		t.Fatalf("expected a deleted release to be 404, got %d", status)
+	}
+}
@@ -0,0 +1,173 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"go-backend/internal/auth"
+	"go-backend/internal/http/response"
+)
+
+func TestNodeSpeedTestRunsSinkAndSender(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, r := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	adminToken, err := auth.GenerateToken(1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	call := func(body map[string]interface{}) response.R {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/node/speedtest", bytes.NewReader(raw))
+		req.Header.Set("Authorization", adminToken)
+		req.Header.Set("Content-Type", "application/json")
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		var out response.R
+		if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+			t.Fatalf("decode speedtest response: %v", err)
+		}
+		return out
+	}
+
+	now := time.Now().UnixMilli()
+	var mu sync.Mutex
+	commands := make(map[string][]string)
+	nodeIDs := make([]int64, 0, 2)
+	for _, node := range []struct{ name, ip string }{{"speed-src", "10.0.5.10"}, {"speed-dst", "10.0.5.20"}} {
+		name := node.name
+		if err := r.DB().Exec(`
+			INSERT INTO node(name, secret, server_ip, server_ip_v4, server_ip_v6, port, interface_name, version, http, tls, socks, created_time, updated_time, status, tcp_listen_addr, udp_listen_addr, inx)
+			VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, name, name+"-secret", node.ip, node.ip, "", "30000-30010", "", "v1", 1, 1, 1, now, now, 0, "[::]", "[::]", 0).Error; err != nil {
+			t.Fatalf("insert node %s: %v", name, err)
+		}
+		nodeID := mustLastInsertID(t, r, name)
+		nodeIDs = append(nodeIDs, nodeID)
+		stop := startMockNodeSessionWithHandlers(t, server.URL, name+"-secret", func(cmdType string) {
+			mu.Lock()
+			commands[name] = append(commands[name], cmdType)
+			mu.Unlock()
+		}, mockSpeedTestHandlers(0))
+		defer stop()
+		waitNodeStatus(t, r, nodeID, 1)
+	}
+
+	if out := call(map[string]interface{}{"fromNodeId": nodeIDs[0], "toNodeId": nodeIDs[0]}); out.Code == 0 {
+		t.Fatalf("expected a test against the same node to be rejected")
+	}
+	if out := call(map[string]interface{}{"fromNodeId": nodeIDs[0], "toNodeId": nodeIDs[1], "network": "sctp"}); out.Code == 0 {
+		t.Fatalf("expected an unknown network to be rejected")
+	}
+
+	out := call(map[string]interface{}{"fromNodeId": nodeIDs[0], "toNodeId": nodeIDs[1], "network": "udp", "duration": 1})
+	if out.Code != 0 {
+		t.Fatalf("speed test failed: %+v", out)
+	}
+	result := out.Data.(map[string]interface{})
+	if result["protocol"] != "tls" || result["throughputMbps"] != 93.5 || result["jitterMs"] != 0.8 || result["packetLoss"] != float64(1) {
+		t.Fatalf("unexpected udp result %+v", result)
+	}
+
+	out = call(map[string]interface{}{"fromNodeId": nodeIDs[0], "toNodeId": nodeIDs[1], "protocol": "ws", "duration": 1})
+	if out.Code != 0 {
+		t.Fatalf("speed test failed: %+v", out)
+	}
+	result = out.Data.(map[string]interface{})
+	if result["protocol"] != "ws" || result["retransmits"] != float64(3) {
+		t.Fatalf("unexpected tcp result %+v", result)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got := commands["speed-src"]; len(got) != 2 || got[0] != "RunSpeedTest" {
+		t.Fatalf("expected the source node to only transmit, got %v", got)
+	}
+	want := []string{"StartSpeedTestSink", "StopSpeedTestSink", "StartSpeedTestSink", "StopSpeedTestSink"}
+	got := commands["speed-dst"]
+	if len(got) != len(want) {
+		t.Fatalf("expected sink start/stop pairs, got %v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected sink start/stop pairs, got %v", got)
+		}
+	}
+}
+
+func TestNodeSpeedTestOutlastsWriteTimeout(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, r := setupContractRouter(t, secret)
+	server := httptest.NewUnstartedServer(router)
+	server.Config.WriteTimeout = 300 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	adminToken, err := auth.GenerateToken(1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	now := time.Now().UnixMilli()
+	nodeIDs := make([]int64, 0, 2)
+	for _, name := range []string{"slow-src", "slow-dst"} {
+		if err := r.DB().Exec(`
+			INSERT INTO node(name, secret, server_ip, server_ip_v4, server_ip_v6, port, interface_name, version, http, tls, socks, created_time, updated_time, status, tcp_listen_addr, udp_listen_addr, inx)
+			VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, name, name+"-secret", "10.0.5.30", "10.0.5.30", "", "30000-30010", "", "v1", 1, 1, 1, now, now, 0, "[::]", "[::]", 0).Error; err != nil {
+			t.Fatalf("insert node %s: %v", name, err)
+		}
+		nodeID := mustLastInsertID(t, r, name)
+		nodeIDs = append(nodeIDs, nodeID)
+		// The sender takes longer than the server's WriteTimeout.
+		stop := startMockNodeSessionWithHandlers(t, server.URL, name+"-secret", nil, mockSpeedTestHandlers(time.Second))
+		defer stop()
+		waitNodeStatus(t, r, nodeID, 1)
+	}
+
+	raw, _ := json.Marshal(map[string]interface{}{"fromNodeId": nodeIDs[0], "toNodeId": nodeIDs[1], "duration": 1})
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/api/v1/node/speedtest", bytes.NewReader(raw))
+	req.Header.Set("Authorization", adminToken)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("expected the result to reach the client, got %v", err)
+	}
+	defer res.Body.Close()
+	var out response.R
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil || out.Code != 0 {
+		t.Fatalf("expected a successful speed test, got %+v (%v)", out, err)
+	}
+}
+
+// mockSpeedTestHandlers answer the speed test commands: the sender pushes
+// 100 Mbps after delay and the sink receives 93.5 Mbps of it.
+func mockSpeedTestHandlers(delay time.Duration) map[string]mockCommandHandler {
+	return map[string]mockCommandHandler{
+		"RunSpeedTest": func(json.RawMessage, func(map[string]interface{})) interface{} {
+			time.Sleep(delay)
+			return map[string]interface{}{
+				"bytes":          125000000,
+				"throughputMbps": 100,
+				"packets":        1000,
+				"retransmits":    3,
+				"retransmitRate": 0.1,
+			}
+		},
+		"StopSpeedTestSink": func(json.RawMessage, func(map[string]interface{})) interface{} {
+			return map[string]interface{}{
+				"bytes":          116875000,
+				"throughputMbps": 93.5,
+				"packets":        990,
+				"jitterMs":       0.8,
+			}
+		},
+	}
+}
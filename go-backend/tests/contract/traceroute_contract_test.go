@@ -0,0 +1,205 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"go-backend/internal/auth"
+	"go-backend/internal/http/response"
+)
+
+func TestTunnelDiagnoseTracesEveryHop(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, r := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	adminToken, err := auth.GenerateToken(1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	call := func(body map[string]interface{}) response.R {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/tunnel/diagnose", bytes.NewReader(raw))
+		req.Header.Set("Authorization", adminToken)
+		req.Header.Set("Content-Type", "application/json")
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		var out response.R
+		if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+			t.Fatalf("decode diagnose response: %v", err)
+		}
+		return out
+	}
+
+	now := time.Now().UnixMilli()
+	if err := r.DB().Exec(`
+		INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, "trace-tunnel", 1.0, 2, "tls", 99999, now, now, 1, nil, 0).Error; err != nil {
+		t.Fatalf("insert tunnel: %v", err)
+	}
+	tunnelID := mustLastInsertID(t, r, "trace-tunnel")
+
+	var mu sync.Mutex
+	traces := 0
+	nodeIDs := make([]int64, 0, 2)
+	for _, node := range []struct{ name, ip string }{{"trace-entry", "10.0.4.10"}, {"trace-exit", "10.0.4.20"}} {
+		name := node.name
+		if err := r.DB().Exec(`
+			INSERT INTO node(name, secret, server_ip, server_ip_v4, server_ip_v6, port, interface_name, version, http, tls, socks, created_time, updated_time, status, tcp_listen_addr, udp_listen_addr, inx)
+			VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, name, name+"-secret", node.ip, node.ip, "", "30000-30010", "", "v1", 1, 1, 1, now, now, 0, "[::]", "[::]", 0).Error; err != nil {
+			t.Fatalf("insert node %s: %v", name, err)
+		}
+		nodeID := mustLastInsertID(t, r, name)
+		nodeIDs = append(nodeIDs, nodeID)
+		stop := startMockNodeSessionWithHandlers(t, server.URL, name+"-secret", func(cmdType string) {
+			if cmdType == "Traceroute" {
+				mu.Lock()
+				traces++
+				mu.Unlock()
+			}
+		}, map[string]mockCommandHandler{"Traceroute": mockTraceroute})
+		defer stop()
+		waitNodeStatus(t, r, nodeID, 1)
+	}
+	if err := r.DB().Exec(`
+		INSERT INTO chain_tunnel(tunnel_id, chain_type, node_id, port, strategy, inx, protocol)
+		VALUES(?, 1, ?, 30001, 'round', 1, 'tls'), (?, 3, ?, 30003, 'round', 1, 'tls')
+	`, tunnelID, nodeIDs[0], tunnelID, nodeIDs[1]).Error; err != nil {
+		t.Fatalf("insert chain: %v", err)
+	}
+
+	if out := call(map[string]interface{}{"tunnelId": tunnelID, "trace": "sctp"}); out.Code == 0 {
+		t.Fatalf("expected an unknown trace protocol to be rejected")
+	}
+
+	out := call(map[string]interface{}{"tunnelId": tunnelID})
+	if out.Code != 0 {
+		t.Fatalf("diagnose failed: %+v", out)
+	}
+	for _, raw := range out.Data.(map[string]interface{})["results"].([]interface{}) {
+		if _, ok := raw.(map[string]interface{})["trace"]; ok {
+			t.Fatalf("expected no trace unless requested, got %+v", raw)
+		}
+	}
+
+	out = call(map[string]interface{}{"tunnelId": tunnelID, "trace": "icmp"})
+	if out.Code != 0 {
+		t.Fatalf("diagnose with trace failed: %+v", out)
+	}
+	results := out.Data.(map[string]interface{})["results"].([]interface{})
+	if len(results) != 2 {
+		t.Fatalf("expected entry->exit and exit->internet results, got %+v", results)
+	}
+	for _, raw := range results {
+		item := raw.(map[string]interface{})
+		trace, ok := item["trace"].(map[string]interface{})
+		if !ok {
+			t.Fatalf("expected a trace on every hop, got %+v", item)
+		}
+		hops, _ := trace["hops"].([]interface{})
+		if trace["success"] != true || len(hops) != 2 {
+			t.Fatalf("unexpected trace %+v", trace)
+		}
+		if hop := hops[1].(map[string]interface{}); hop["ip"] != "198.51.100.7" || hop["loss"] != float64(20) {
+			t.Fatalf("unexpected hop %+v", hop)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if traces != len(results) {
+		t.Fatalf("expected one Traceroute command per hop, got %d", traces)
+	}
+}
+
+func TestTunnelDiagnoseTracesHopsConcurrently(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, r := setupContractRouter(t, secret)
+	server := httptest.NewUnstartedServer(router)
+	server.Config.WriteTimeout = 300 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	adminToken, err := auth.GenerateToken(1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	now := time.Now().UnixMilli()
+	if err := r.DB().Exec(`
+		INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, "slow-trace-tunnel", 1.0, 2, "tls", 99999, now, now, 1, nil, 0).Error; err != nil {
+		t.Fatalf("insert tunnel: %v", err)
+	}
+	tunnelID := mustLastInsertID(t, r, "slow-trace-tunnel")
+
+	nodeIDs := make([]int64, 0, 2)
+	for _, name := range []string{"slow-trace-entry", "slow-trace-exit"} {
+		if err := r.DB().Exec(`
+			INSERT INTO node(name, secret, server_ip, server_ip_v4, server_ip_v6, port, interface_name, version, http, tls, socks, created_time, updated_time, status, tcp_listen_addr, udp_listen_addr, inx)
+			VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, name, name+"-secret", "10.0.4.30", "10.0.4.30", "", "30000-30010", "", "v1", 1, 1, 1, now, now, 0, "[::]", "[::]", 0).Error; err != nil {
+			t.Fatalf("insert node %s: %v", name, err)
+		}
+		nodeID := mustLastInsertID(t, r, name)
+		nodeIDs = append(nodeIDs, nodeID)
+		// Each trace takes longer than the server's WriteTimeout.
+		stop := startMockNodeSessionWithHandlers(t, server.URL, name+"-secret", nil, map[string]mockCommandHandler{
+			"Traceroute": func(data json.RawMessage, push func(map[string]interface{})) interface{} {
+				time.Sleep(time.Second)
+				return mockTraceroute(data, push)
+			},
+		})
+		defer stop()
+		waitNodeStatus(t, r, nodeID, 1)
+	}
+	if err := r.DB().Exec(`
+		INSERT INTO chain_tunnel(tunnel_id, chain_type, node_id, port, strategy, inx, protocol)
+		VALUES(?, 1, ?, 30001, 'round', 1, 'tls'), (?, 3, ?, 30003, 'round', 1, 'tls')
+	`, tunnelID, nodeIDs[0], tunnelID, nodeIDs[1]).Error; err != nil {
+		t.Fatalf("insert chain: %v", err)
+	}
+
+	raw, _ := json.Marshal(map[string]interface{}{"tunnelId": tunnelID, "trace": "icmp"})
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/api/v1/tunnel/diagnose", bytes.NewReader(raw))
+	req.Header.Set("Authorization", adminToken)
+	req.Header.Set("Content-Type", "application/json")
+	start := time.Now()
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("expected the result to reach the client, got %v", err)
+	}
+	defer res.Body.Close()
+	var out response.R
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil || out.Code != 0 {
+		t.Fatalf("expected a successful diagnosis, got %+v (%v)", out, err)
+	}
+	if elapsed := time.Since(start); elapsed >= 2*time.Second {
+		t.Fatalf("expected the hops to be traced concurrently, took %s", elapsed)
+	}
+	results := out.Data.(map[string]interface{})["results"].([]interface{})
+	if len(results) != 2 || results[0].(map[string]interface{})["fromChainType"] != float64(1) || results[1].(map[string]interface{})["fromChainType"] != float64(3) {
+		t.Fatalf("expected results in hop order, got %+v", results)
+	}
+}
+
+// mockTraceroute answers Traceroute with a two-hop path that reaches the
+// target.
+func mockTraceroute(json.RawMessage, func(map[string]interface{})) interface{} {
+	return map[string]interface{}{
+		"success": true,
+		"reached": true,
+		"hops": []map[string]interface{}{
+			{"ttl": 1, "ip": "192.0.2.1", "sent": 5, "received": 5, "loss": 0, "avgTime": 1.2},
+			{"ttl": 2, "ip": "198.51.100.7", "sent": 5, "received": 4, "loss": 20, "avgTime": 9.8},
+		},
+	}
+}
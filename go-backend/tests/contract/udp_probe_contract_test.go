@@ -0,0 +1,177 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"go-backend/internal/auth"
+	"go-backend/internal/http/response"
+)
+
+func TestForwardDiagnoseProbesUDPTargets(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, r := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	adminToken, err := auth.GenerateToken(1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	call := func(body map[string]interface{}) response.R {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/forward/diagnose", bytes.NewReader(raw))
+		req.Header.Set("Authorization", adminToken)
+		req.Header.Set("Content-Type", "application/json")
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		var out response.R
+		if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+			t.Fatalf("decode diagnose response: %v", err)
+		}
+		return out
+	}
+
+	now := time.Now().UnixMilli()
+	if err := r.DB().Exec(`
+		INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, "udp-probe-tunnel", 1.0, 1, "tls", 99999, now, now, 1, nil, 0).Error; err != nil {
+		t.Fatalf("insert tunnel: %v", err)
+	}
+	tunnelID := mustLastInsertID(t, r, "udp-probe-tunnel")
+
+	if err := r.DB().Exec(`
+		INSERT INTO node(name, secret, server_ip, server_ip_v4, server_ip_v6, port, interface_name, version, http, tls, socks, created_time, updated_time, status, tcp_listen_addr, udp_listen_addr, inx)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, "udp-probe-entry", "udp-probe-entry-secret", "10.0.5.10", "10.0.5.10", "", "30000-30010", "", "v1", 1, 1, 1, now, now, 0, "[::]", "[::]", 0).Error; err != nil {
+		t.Fatalf("insert node: %v", err)
+	}
+	nodeID := mustLastInsertID(t, r, "udp-probe-entry")
+
+	// The node refuses TCP to udpOnlyPort, standing in for a game server
+	// that only listens on UDP.
+	const udpOnlyPort = 27015
+	handlers := map[string]mockCommandHandler{
+		"TcpPing": func(data json.RawMessage, _ func(map[string]interface{})) interface{} {
+			var target struct {
+				Port int `json:"port"`
+			}
+			_ = json.Unmarshal(data, &target)
+			if target.Port == udpOnlyPort {
+				return map[string]interface{}{
+					"success":      false,
+					"averageTime":  0,
+					"packetLoss":   100,
+					"errorMessage": "connection refused",
+				}
+			}
+			return mockTcpPingOK(data, nil)
+		},
+		"UdpProbe": func(data json.RawMessage, _ func(map[string]interface{})) interface{} {
+			var probe struct {
+				Mode string `json:"mode"`
+			}
+			_ = json.Unmarshal(data, &probe)
+			return map[string]interface{}{
+				"mode":        probe.Mode,
+				"success":     true,
+				"averageTime": 12.5,
+				"packetLoss":  25,
+				"received":    3,
+			}
+		},
+	}
+	var mu sync.Mutex
+	var probes []string
+	stop := startMockNodeSessionWithHandlers(t, server.URL, "udp-probe-entry-secret", func(cmdType string) {
+		mu.Lock()
+		probes = append(probes, cmdType)
+		mu.Unlock()
+	}, handlers)
+	defer stop()
+	waitNodeStatus(t, r, nodeID, 1)
+
+	if err := r.DB().Exec(`
+		INSERT INTO chain_tunnel(tunnel_id, chain_type, node_id, port, strategy, inx, protocol)
+		VALUES(?, 1, ?, 30001, 'round', 1, 'tls')
+	`, tunnelID, nodeID).Error; err != nil {
+		t.Fatalf("insert chain: %v", err)
+	}
+	if err := r.DB().Exec(`
+		INSERT INTO forward(user_id, user_name, name, tunnel_id, remote_addr, strategy, in_flow, out_flow, created_time, updated_time, status, inx)
+		VALUES(?, ?, ?, ?, ?, ?, 0, 0, ?, ?, 1, ?)
+	`, 1, "admin_user", "udp-probe-forward", tunnelID, "203.0.113.9:27015,203.0.113.9:443", "fifo", now, now, 0).Error; err != nil {
+		t.Fatalf("insert forward: %v", err)
+	}
+	forwardID := mustLastInsertID(t, r, "udp-probe-forward")
+	resultsOf := func(out response.R) map[float64]map[string]interface{} {
+		t.Helper()
+		if out.Code != 0 {
+			t.Fatalf("diagnose failed: %+v", out)
+		}
+		byPort := map[float64]map[string]interface{}{}
+		for _, raw := range out.Data.(map[string]interface{})["results"].([]interface{}) {
+			item := raw.(map[string]interface{})
+			byPort[item["targetPort"].(float64)] = item
+		}
+		return byPort
+	}
+	resetProbes := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		seen := probes
+		probes = nil
+		return seen
+	}
+
+	for _, body := range []map[string]interface{}{
+		{"forwardId": forwardID, "probe": "icmp"},
+		{"forwardId": forwardID, "probe": "udp", "payloadHex": "zz"},
+	} {
+		if out := call(body); out.Code == 0 {
+			t.Fatalf("expected %+v to be rejected", body)
+		}
+	}
+
+	byPort := resultsOf(call(map[string]interface{}{"forwardId": forwardID}))
+	udpOnly, tcpOpen := byPort[27015], byPort[443]
+	if udpOnly["success"] != true || udpOnly["probe"] != "udp" || udpOnly["averageTime"] != 12.5 {
+		t.Fatalf("expected the TCP-closed target to be reported by its UDP probe, got %+v", udpOnly)
+	}
+	if _, ok := udpOnly["udpProbe"].(map[string]interface{}); !ok {
+		t.Fatalf("expected the raw UDP probe report, got %+v", udpOnly)
+	}
+	if tcpOpen["success"] != true || tcpOpen["probe"] != "tcp" {
+		t.Fatalf("expected the TCP target to stay on TCP, got %+v", tcpOpen)
+	}
+	if _, ok := tcpOpen["udpProbe"]; ok {
+		t.Fatalf("expected no UDP fallback for a reachable TCP target, got %+v", tcpOpen)
+	}
+	if seen := resetProbes(); len(seen) != 3 {
+		t.Fatalf("expected two TCP pings and one UDP probe, got %v", seen)
+	}
+
+	byPort = resultsOf(call(map[string]interface{}{"forwardId": forwardID, "probe": "stun", "payload": "ignored"}))
+	for port, item := range byPort {
+		if item["success"] != true || item["probe"] != "stun" || item["packetLoss"] != float64(25) {
+			t.Fatalf("expected a STUN probe for port %v, got %+v", port, item)
+		}
+	}
+	for _, cmdType := range resetProbes() {
+		if cmdType != "UdpProbe" {
+			t.Fatalf("expected only UDP probes in stun mode, got %s", cmdType)
+		}
+	}
+
+	byPort = resultsOf(call(map[string]interface{}{"forwardId": forwardID, "probe": "tcp"}))
+	if byPort[27015]["success"] != false || byPort[27015]["probe"] != "tcp" {
+		t.Fatalf("expected tcp mode to skip the UDP fallback, got %+v", byPort[27015])
+	}
+}
@@ -0,0 +1,184 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"go-backend/internal/auth"
+	"go-backend/internal/http/response"
+)
+
+func TestUpgradeRolloutWavesAndAbort(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, r := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	adminToken, err := auth.GenerateToken(1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	call := func(path string, body map[string]interface{}) response.R {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(raw))
+		req.Header.Set("Authorization", adminToken)
+		req.Header.Set("Content-Type", "application/json")
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		var out response.R
+		if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+			t.Fatalf("decode %s response: %v", path, err)
+		}
+		return out
+	}
+
+	now := time.Now().UnixMilli()
+	names := []string{"rollout-a", "rollout-b", "rollout-c"}
+	nodeIDs := make([]int64, 0, len(names))
+	for _, name := range names {
+		if err := r.DB().Exec(`
+			INSERT INTO node(name, secret, server_ip, server_ip_v4, server_ip_v6, port, interface_name, version, http, tls, socks, created_time, updated_time, status, tcp_listen_addr, udp_listen_addr, inx)
+			VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, name, name+"-secret", "10.0.3.10", "10.0.3.10", "", "30000-30010", "", "v0", 1, 1, 1, now, now, 0, "[::]", "[::]", 0).Error; err != nil {
+			t.Fatalf("insert node %s: %v", name, err)
+		}
+		nodeIDs = append(nodeIDs, mustLastInsertID(t, r, name))
+	}
+
+	// Each mock agent acknowledges the upgrade; the test then reconnects it,
+	// which reports the target version "v1" in the handshake.
+	var mu sync.Mutex
+	upgrades := make(map[int64]int)
+	sessions := make(map[int64]func())
+	connect := func(i int) {
+		nodeID := nodeIDs[i]
+		stop := startMockNodeSessionWithHook(t, server.URL, names[i]+"-secret", func(cmdType string) {
+			if cmdType == "UpgradeAgent" {
+				mu.Lock()
+				upgrades[nodeID]++
+				mu.Unlock()
+			}
+		})
+		mu.Lock()
+		sessions[nodeID] = stop
+		mu.Unlock()
+		waitNodeStatus(t, r, nodeID, 1)
+	}
+	defer func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, stop := range sessions {
+			stop()
+		}
+	}()
+	for i := range nodeIDs {
+		connect(i)
+	}
+	waitUpgrade := func(nodeID int64) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			mu.Lock()
+			n := upgrades[nodeID]
+			mu.Unlock()
+			if n > 0 {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("node %d did not receive UpgradeAgent", nodeID)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	restart := func(i int) {
+		t.Helper()
+		mu.Lock()
+		stop := sessions[nodeIDs[i]]
+		mu.Unlock()
+		stop()
+		connect(i)
+	}
+	rolloutStatus := func(id interface{}) map[string]interface{} {
+		t.Helper()
+		out := call("/api/v1/node/upgrade/rollout/get", map[string]interface{}{"id": id})
+		if out.Code != 0 {
+			t.Fatalf("get rollout failed: %+v", out)
+		}
+		return out.Data.(map[string]interface{})
+	}
+	waitRollout := func(id interface{}, status string) map[string]interface{} {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			got := rolloutStatus(id)
+			if got["status"] == status {
+				return got
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("rollout did not reach %q: %+v", status, got)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	out := call("/api/v1/node/upgrade/rollout/create", map[string]interface{}{
+		"ids":           []int64{nodeIDs[0], nodeIDs[1]},
+		"version":       "v1",
+		"source":        "github",
+		"canarySize":    1,
+		"waveSize":      1,
+		"stableSeconds": 0,
+	})
+	if out.Code != 0 {
+		t.Fatalf("create rollout failed: %+v", out)
+	}
+	rolloutID := out.Data.(map[string]interface{})["id"]
+
+	waitUpgrade(nodeIDs[0])
+	time.Sleep(200 * time.Millisecond)
+	mu.Lock()
+	early := upgrades[nodeIDs[1]]
+	mu.Unlock()
+	if early != 0 {
+		t.Fatalf("expected the second wave to wait for the canary")
+	}
+	restart(0)
+	waitUpgrade(nodeIDs[1])
+	restart(1)
+	done := waitRollout(rolloutID, "completed")
+	for _, res := range done["results"].([]interface{}) {
+		if res.(map[string]interface{})["status"] != "healthy" {
+			t.Fatalf("expected every node to be healthy, got %+v", done["results"])
+		}
+	}
+
+	out = call("/api/v1/node/upgrade/rollout/create", map[string]interface{}{
+		"ids":        []int64{nodeIDs[2]},
+		"version":    "v2",
+		"source":     "github",
+		"canarySize": 1,
+	})
+	if out.Code != 0 {
+		t.Fatalf("create second rollout failed: %+v", out)
+	}
+	abortID := out.Data.(map[string]interface{})["id"]
+	waitUpgrade(nodeIDs[2])
+	if out := call("/api/v1/node/upgrade/rollout/abort", map[string]interface{}{"id": abortID}); out.Code != 0 {
+		t.Fatalf("abort failed: %+v", out)
+	}
+	waitRollout(abortID, "aborted")
+	if out := call("/api/v1/node/upgrade/rollout/resume", map[string]interface{}{"id": abortID}); out.Code == 0 {
+		t.Fatalf("expected an aborted rollout not to resume")
+	}
+
+	out = call("/api/v1/node/upgrade/rollout/list", map[string]interface{}{})
+	if list, _ := out.Data.([]interface{}); out.Code != 0 || len(list) != 2 {
+		t.Fatalf("expected two rollouts, got %+v", out)
+	}
+}
@@ -10,9 +10,21 @@ import (
 type Config struct {
 	Addr   string `json:"addr"`
 	Secret string `json:"secret"`
+	Token  string `json:"token,omitempty"` // 注册令牌，无密钥时用于向面板注册节点
 	Http   int    `json:"http"`
 	Tls    int    `json:"tls"`
 	Socks  int    `json:"socks"`
+	GeoIP  string `json:"geoip,omitempty"` // MaxMind 国家库路径，默认 GeoLite2-Country.mmdb
+
+	EnrollID string `json:"enroll_id,omitempty"` // 注册请求标识，重试注册时面板据此返回同一个节点
+
+	MTLS     bool   `json:"mtls,omitempty"`      // 已有节点申请面板 mTLS 证书
+	TLSAddr  string `json:"tls_addr,omitempty"`  // 面板 mTLS 监听地址，":端口" 表示沿用 addr 的主机
+	PanelPin string `json:"panel_pin,omitempty"` // 面板证书公钥指纹
+
+	UpgradePublicKey string `json:"upgrade_public_key,omitempty"` // 升级包签名公钥，仅在编译时未固定公钥时使用
+
+	StatusSocket string `json:"status_socket,omitempty"` // 本地状态接口 unix socket 路径，默认 /etc/flux_agent/flux_agent.sock，"-" 表示关闭
 }
 
 // LoadConfig 加载配置文件
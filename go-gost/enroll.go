@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-gost/x/socket"
+)
+
+const (
+	enrollAttempts = 5
+	enrollTimeout  = 10 * time.Second
+)
+
+// enrollResponse 面板 /flow/enroll 的响应
+type enrollResponse struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+	Data struct {
+		ID     int64  `json:"id"`
+		Name   string `json:"name"`
+		Secret string `json:"secret"`
+		nodeTLSMaterial
+	} `json:"data"`
+}
+
+// enrollNode 使用注册令牌向面板注册本节点，成功后把密钥写回配置文件并移除令牌
+func enrollNode(configPath string, config *Config) error {
+	ipv4, ipv6, iface := detectNodeAddresses()
+	hostname, _ := os.Hostname()
+	// 附带证书请求，面板启用 mTLS 时会签发节点证书
+	keyPEM, csrPEM, err := newNodeCSR()
+	if err != nil {
+		fmt.Printf("⚠️ 生成节点证书请求失败，仅使用密钥注册: %v\n", err)
+	}
+	// 注册标识先写入配置文件，响应丢失或进程重启后重试仍使用同一标识，面板不会重复创建节点
+	if config.EnrollID == "" {
+		config.EnrollID = newEnrollID()
+		if err := socket.UpdateConfigFile(configPath, map[string]interface{}{"enroll_id": config.EnrollID}); err != nil {
+			fmt.Printf("⚠️ 保存注册标识失败: %v\n", err)
+		}
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"token":         config.Token,
+		"enrollId":      config.EnrollID,
+		"name":          hostname,
+		"serverIpV4":    ipv4,
+		"serverIpV6":    ipv6,
+		"interfaceName": iface,
+		"version":       version,
+		"csr":           string(csrPEM),
+	})
+	fmt.Printf("📝 使用注册令牌注册节点 (ipv4=%s ipv6=%s interface=%s)\n", ipv4, ipv6, iface)
+
+	var (
+		res     *enrollResponse
+		lastErr error
+	)
+	for attempt := 1; attempt <= enrollAttempts; attempt++ {
+		res, lastErr = postEnroll("http://"+config.Addr+"/flow/enroll", body)
+		if lastErr == nil {
+			break
+		}
+		fmt.Printf("⚠️ 注册请求失败 (%d/%d): %v\n", attempt, enrollAttempts, lastErr)
+		time.Sleep(time.Duration(attempt) * 2 * time.Second)
+	}
+	if lastErr != nil {
+		return lastErr
+	}
+	if res.Code != 0 || res.Data.Secret == "" {
+		return fmt.Errorf("面板拒绝注册: %s", res.Msg)
+	}
+
+	if err := saveEnrolledSecret(configPath, res.Data.Secret); err != nil {
+		return err
+	}
+	config.Secret = res.Data.Secret
+	config.Token = ""
+	config.EnrollID = ""
+	fmt.Printf("✅ 节点注册成功 - id: %d, name: %s\n", res.Data.ID, res.Data.Name)
+
+	switch {
+	case res.Data.Certificate != "":
+		if err := saveNodeTLS(configPath, config, keyPEM, res.Data.nodeTLSMaterial); err != nil {
+			fmt.Printf("⚠️ 保存节点证书失败，继续使用密钥连接: %v\n", err)
+		}
+	case res.Data.CertificateError != "":
+		fmt.Printf("⚠️ 面板未签发节点证书: %s\n", res.Data.CertificateError)
+	}
+	return nil
+}
+
+func postEnroll(url string, body []byte) (*enrollResponse, error) {
+	client := &http.Client{Timeout: enrollTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP响应错误: %s", resp.Status)
+	}
+	var res enrollResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, fmt.Errorf("解析注册响应失败: %v", err)
+	}
+	return &res, nil
+}
+
+// saveEnrolledSecret 写入密钥并删除令牌和注册标识，保留配置文件中的其它字段
+func saveEnrolledSecret(configPath, secret string) error {
+	return socket.UpdateConfigFile(configPath, map[string]interface{}{"secret": secret}, "token", "enroll_id")
+}
+
+func newEnrollID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// detectNodeAddresses 返回出口网卡上的公网 IPv4/IPv6 和网卡名；
+// 处于 NAT 之后时地址为空，由面板按请求来源地址补全
+func detectNodeAddresses() (ipv4, ipv6, iface string) {
+	if ip := outboundIP("udp4", "8.8.8.8:53"); ip != nil {
+		iface = interfaceOf(ip)
+		if isPublicIP(ip) {
+			ipv4 = ip.String()
+		}
+	}
+	if ip := outboundIP("udp6", "[2001:4860:4860::8888]:53"); ip != nil {
+		if iface == "" {
+			iface = interfaceOf(ip)
+		}
+		if isPublicIP(ip) {
+			ipv6 = ip.String()
+		}
+	}
+	return ipv4, ipv6, iface
+}
+
+// outboundIP 返回访问 target 时使用的本地地址（UDP 不会真正发送数据）
+func outboundIP(network, target string) net.IP {
+	conn, err := net.DialTimeout(network, target, 2*time.Second)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+		return addr.IP
+	}
+	return nil
+}
+
+func interfaceOf(ip net.IP) string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return ""
+	}
+	for _, ifc := range ifaces {
+		addrs, err := ifc.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if n, ok := a.(*net.IPNet); ok && n.IP.Equal(ip) {
+				return ifc.Name
+			}
+		}
+	}
+	return ""
+}
+
+func isPublicIP(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
@@ -13,6 +13,7 @@ import (
 	"sync"
 
 	"github.com/go-gost/core/logger"
+	"github.com/go-gost/x/admission/geoip"
 	xlogger "github.com/go-gost/x/logger"
 	"github.com/go-gost/x/service"
 	"github.com/go-gost/x/socket"
@@ -106,6 +107,10 @@ func init() {
 }
 
 func main() {
+	if flag.Arg(0) == "status" {
+		os.Exit(runStatus(flag.Args()[1:]))
+	}
+
 	// 加载配置文件
 	config, err := LoadConfig("config.json")
 	if err != nil {
@@ -116,13 +121,42 @@ func main() {
 
 	fmt.Println("✅ 配置加载成功 - addr: %s", config.Addr)
 
+	if config.Secret == "" && config.Token != "" {
+		if err := enrollNode("config.json", config); err != nil {
+			fmt.Printf("❌ 节点注册失败: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	setupPanelTLS("config.json", config)
+
 	log := xlogger.NewLogger()
 	logger.SetDefault(log)
 
+	if err := geoip.Open(config.GeoIP); err != nil {
+		fmt.Printf("⚠️ GeoIP 国家库未加载，按国家准入的规则将无法识别来源: %v\n", err)
+	} else {
+		info := geoip.Info()
+		fmt.Printf("🌍 GeoIP 国家库已加载: %s (%s %s)\n", info.Path, info.Type, info.BuildTimeString())
+	}
+
+	socket.SetUpgradePublicKey(config.UpgradePublicKey)
+	// 在启动任何上报协程之前接管标准输出
+	socket.StartLogCapture()
+	go renewPanelTLSLoop("config.json")
 	wsReporter := socket.StartWebSocketReporterWithConfig(config.Addr, config.Secret, config.Http, config.Tls, config.Socks, version)
 	defer wsReporter.Stop()
 	service.SetHTTPReportURL(config.Addr, config.Secret)
 
+	if config.StatusSocket != "-" {
+		statusServer, err := socket.StartStatusServer(config.StatusSocket, wsReporter)
+		if err != nil {
+			fmt.Printf("⚠️ 本地状态接口启动失败: %v\n", err)
+		} else {
+			defer statusServer.Close()
+		}
+	}
+
 	p := &program{}
 	if err := svc.Run(p); err != nil {
 		logger.Default().Fatal(err)
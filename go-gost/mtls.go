@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-gost/x/paneltls"
+	"github.com/go-gost/x/socket"
+)
+
+const (
+	nodeCertFile = "node_cert.pem"
+	nodeKeyFile  = "node_key.pem"
+	// nodeCertRenewBefore 证书剩余有效期不足该时长时续期
+	nodeCertRenewBefore = 30 * 24 * time.Hour
+	// nodeCertCheckInterval 运行期间检查证书有效期的间隔
+	nodeCertCheckInterval = 12 * time.Hour
+)
+
+// nodeTLSMaterial 面板签发节点证书时返回的内容
+type nodeTLSMaterial struct {
+	Certificate      string `json:"certificate"`
+	PanelPin         string `json:"panelPin"`
+	TLSAddr          string `json:"tlsAddr"`
+	CertificateError string `json:"certificateError"`
+}
+
+// newNodeCSR 生成节点私钥和证书请求，私钥只保存在本地
+func newNodeCSR() (keyPEM, csrPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	hostname, _ := os.Hostname()
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: hostname},
+	}, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	csrPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})
+	return keyPEM, csrPEM, nil
+}
+
+// saveNodeTLS 保存节点证书和私钥，并把 mTLS 地址和面板公钥指纹写入配置文件
+func saveNodeTLS(configPath string, config *Config, keyPEM []byte, m nodeTLSMaterial) error {
+	if len(keyPEM) == 0 || m.Certificate == "" || m.PanelPin == "" || m.TLSAddr == "" {
+		return errors.New("面板返回的证书信息不完整")
+	}
+	if err := writeFileAtomic(nodeKeyFile, keyPEM); err != nil {
+		return fmt.Errorf("写入节点私钥失败: %v", err)
+	}
+	if err := writeFileAtomic(nodeCertFile, []byte(m.Certificate)); err != nil {
+		return fmt.Errorf("写入节点证书失败: %v", err)
+	}
+	if err := socket.UpdateConfigFile(configPath, map[string]interface{}{
+		"tls_addr":  m.TLSAddr,
+		"panel_pin": m.PanelPin,
+	}); err != nil {
+		return err
+	}
+	config.TLSAddr = m.TLSAddr
+	config.PanelPin = m.PanelPin
+	fmt.Printf("🔐 已获取节点证书，面板连接将使用 mTLS (%s)\n", m.TLSAddr)
+	return nil
+}
+
+// setupPanelTLS 按配置启用到面板的 mTLS：证书缺失或即将过期时先向面板申请，
+// 再加载证书并固定面板公钥。未配置 mTLS 的节点保持仅密钥认证
+func setupPanelTLS(configPath string, config *Config) {
+	if !config.MTLS && config.PanelPin == "" {
+		return
+	}
+	configure := func() error {
+		return paneltls.Configure(resolveTLSAddr(config), nodeCertFile, nodeKeyFile, config.PanelPin)
+	}
+	haveCert := config.PanelPin != "" && config.TLSAddr != "" && !certificateExpiresSoon(nodeCertFile)
+	if config.PanelPin != "" && config.TLSAddr != "" && fileExists(nodeCertFile) && !certificateExpired(nodeCertFile) {
+		// 已有证书时通过 mTLS 通道续期；证书已过期则无法握手，改走密钥通道重新申请
+		if err := configure(); err != nil {
+			fmt.Printf("⚠️ 加载节点证书失败: %v\n", err)
+		}
+	}
+	if !haveCert {
+		if err := requestNodeCertificate(configPath, config); err != nil {
+			fmt.Printf("⚠️ 申请节点证书失败: %v\n", err)
+		}
+	}
+	if config.PanelPin == "" || config.TLSAddr == "" {
+		return
+	}
+	if err := configure(); err != nil {
+		fmt.Printf("❌ 启用面板 mTLS 失败，继续使用密钥连接: %v\n", err)
+		return
+	}
+	fmt.Printf("🔐 面板连接使用 mTLS: %s\n", resolveTLSAddr(config))
+}
+
+// renewPanelTLSLoop 定期检查节点证书，剩余有效期不足时续期，长期运行的节点证书不会过期。
+// 每次重新读取配置文件，证书被面板吊销后本地 mTLS 配置已清除，不再续期
+func renewPanelTLSLoop(configPath string) {
+	ticker := time.NewTicker(nodeCertCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		config, err := LoadConfig(configPath)
+		if err != nil || config.PanelPin == "" || config.TLSAddr == "" || !certificateExpiresSoon(nodeCertFile) {
+			continue
+		}
+		if paneltls.Expired() {
+			paneltls.Disable()
+		}
+		if err := requestNodeCertificate(configPath, config); err != nil {
+			fmt.Printf("⚠️ 续期节点证书失败: %v\n", err)
+			continue
+		}
+		if err := paneltls.Configure(resolveTLSAddr(config), nodeCertFile, nodeKeyFile, config.PanelPin); err != nil {
+			fmt.Printf("❌ 启用新节点证书失败: %v\n", err)
+		}
+	}
+}
+
+// requestNodeCertificate 通过 /flow/certificate 为已有节点申请或续期证书
+func requestNodeCertificate(configPath string, config *Config) error {
+	keyPEM, csrPEM, err := newNodeCSR()
+	if err != nil {
+		return err
+	}
+	body, _ := json.Marshal(map[string]string{"csr": string(csrPEM)})
+	url := paneltls.HTTPBase(config.Addr) + "/flow/certificate?secret=" + config.Secret
+	resp, err := paneltls.HTTPClient(enrollTimeout).Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP响应错误: %s", resp.Status)
+	}
+	var res struct {
+		Code int             `json:"code"`
+		Msg  string          `json:"msg"`
+		Data nodeTLSMaterial `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return fmt.Errorf("解析证书响应失败: %v", err)
+	}
+	if res.Code != 0 {
+		return fmt.Errorf("面板拒绝签发证书: %s", res.Msg)
+	}
+	return saveNodeTLS(configPath, config, keyPEM, res.Data)
+}
+
+// resolveTLSAddr 将 ":端口" 形式的 mTLS 地址补全为面板主机
+func resolveTLSAddr(config *Config) string {
+	if !strings.HasPrefix(config.TLSAddr, ":") {
+		return config.TLSAddr
+	}
+	host := config.Addr
+	if h, _, err := net.SplitHostPort(config.Addr); err == nil {
+		host = h
+	}
+	return net.JoinHostPort(host, strings.TrimPrefix(config.TLSAddr, ":"))
+}
+
+// certificateExpiresSoon 证书不存在、无法解析或即将过期时返回 true
+func certificateExpiresSoon(file string) bool {
+	notAfter, ok := certificateNotAfter(file)
+	return !ok || time.Until(notAfter) < nodeCertRenewBefore
+}
+
+// certificateExpired 证书不存在、无法解析或已过期时返回 true
+func certificateExpired(file string) bool {
+	notAfter, ok := certificateNotAfter(file)
+	return !ok || time.Now().After(notAfter)
+}
+
+func certificateNotAfter(file string) (time.Time, bool) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return time.Time{}, false
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return time.Time{}, false
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return cert.NotAfter, true
+}
+
+func fileExists(file string) bool {
+	_, err := os.Stat(file)
+	return err == nil
+}
+
+// writeFileAtomic 先写临时文件再重命名，私钥文件仅所有者可读
+func writeFileAtomic(file string, data []byte) error {
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
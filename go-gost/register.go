@@ -105,5 +105,6 @@ import (
 	_ "github.com/go-gost/x/listener/tun"
 	_ "github.com/go-gost/x/listener/udp"
 	_ "github.com/go-gost/x/listener/unix"
+	_ "github.com/go-gost/x/listener/vhost"
 	_ "github.com/go-gost/x/listener/ws"
 )
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-gost/x/socket"
+)
+
+// runStatus 实现 status 子命令：通过本地 unix socket 查询运行中节点程序的状态
+func runStatus(args []string) int {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	socketPath := fs.String("socket", statusSocketPath("config.json"), "status socket path")
+	asJSON := fs.Bool("json", false, "print raw JSON")
+	fs.Parse(args)
+
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", *socketPath)
+			},
+		},
+	}
+	resp, err := client.Get("http://agent/status")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "无法连接节点程序 (%s): %v\n", *socketPath, err)
+		return 1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "状态接口返回 %s\n", resp.Status)
+		return 1
+	}
+
+	var status socket.AgentStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		fmt.Fprintf(os.Stderr, "解析状态失败: %v\n", err)
+		return 1
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(status)
+		return 0
+	}
+	printStatus(status)
+	return 0
+}
+
+// statusSocketPath 读取配置文件中的 status_socket，未配置时使用默认路径
+func statusSocketPath(configPath string) string {
+	var cfg Config
+	if data, err := os.ReadFile(configPath); err == nil {
+		json.Unmarshal(data, &cfg)
+	}
+	if cfg.StatusSocket == "" || cfg.StatusSocket == "-" {
+		return socket.DefaultStatusSocket
+	}
+	return cfg.StatusSocket
+}
+
+func printStatus(s socket.AgentStatus) {
+	now := time.Now()
+	fmt.Printf("版本:     %s (pid %d, 已运行 %s)\n", s.Version, s.PID, since(now, s.StartedAt))
+
+	panel := "未连接"
+	if s.Panel.Connected {
+		panel = "已连接 (" + since(now, s.Panel.ConnectedAt) + ")"
+	} else if s.Panel.DisconnectedAt > 0 {
+		panel = "未连接 (" + since(now, s.Panel.DisconnectedAt) + "前断开)"
+	}
+	fmt.Printf("面板:     %s %s\n", s.Panel.Addr, panel)
+	if s.Panel.LastError != "" {
+		fmt.Printf("          最近连接失败 (%s前): %s\n", since(now, s.Panel.LastErrorAt), s.Panel.LastError)
+	}
+
+	report := "从未成功"
+	if s.Traffic.LastSuccess > 0 {
+		report = since(now, s.Traffic.LastSuccess) + "前成功"
+	}
+	fmt.Printf("流量上报: %s，待补发 %d 条\n", report, s.Traffic.Pending)
+	if s.Traffic.LastError != "" && s.Traffic.LastErrorAt > s.Traffic.LastSuccess {
+		fmt.Printf("          最近失败 (%s前): %s\n", since(now, s.Traffic.LastErrorAt), s.Traffic.LastError)
+	}
+
+	switch {
+	case s.Upgrade.Pending != nil:
+		fmt.Printf("升级:     等待确认 %s (原版本 %s)，期限 %s\n", s.Upgrade.Pending.Version, s.Upgrade.Pending.Previous,
+			time.Unix(s.Upgrade.Pending.Deadline, 0).Format("2006-01-02 15:04:05"))
+	case s.Upgrade.Progress != nil:
+		p := s.Upgrade.Progress
+		fmt.Printf("升级:     %s %d%% %s (%s前)\n", p.Stage, p.Percent, p.Message, since(now, p.UpdatedAt))
+	default:
+		fmt.Println("升级:     无")
+	}
+
+	fmt.Printf("\n服务 (%d):\n", len(s.Services))
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tADDR\tTYPE\tSTATE\tCONNS\tIN\tOUT\tLIMITER")
+	for _, svc := range s.Services {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n", svc.Name, svc.Addr, strings.Trim(svc.Handler+"/"+svc.Listener, "/"),
+			svc.State, svc.CurrentConns, formatBytes(svc.InputBytes), formatBytes(svc.OutputBytes), svc.Limiter)
+	}
+	tw.Flush()
+
+	fmt.Printf("\n限速器 (%d):\n", len(s.Limiters))
+	for _, l := range s.Limiters {
+		fmt.Printf("  %s: %s\n", l.Name, strings.Join(l.Limits, ", "))
+	}
+}
+
+// since 返回毫秒时间戳距今的时长，精确到秒
+func since(now time.Time, ms int64) string {
+	if ms <= 0 {
+		return "-"
+	}
+	return now.Sub(time.UnixMilli(ms)).Round(time.Second).String()
+}
+
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := uint64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
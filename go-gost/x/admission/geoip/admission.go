@@ -0,0 +1,154 @@
+package geoip
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-gost/core/admission"
+	"github.com/go-gost/core/logger"
+)
+
+type options struct {
+	allow     []string
+	deny      []string
+	whitelist bool
+	logger    logger.Logger
+}
+
+type Option func(opts *options)
+
+// AllowOption 允许的国家代码，仅在 WhitelistOption(true) 时生效
+func AllowOption(countries []string) Option {
+	return func(opts *options) {
+		opts.allow = countries
+	}
+}
+
+// DenyOption 拒绝的国家代码
+func DenyOption(countries []string) Option {
+	return func(opts *options) {
+		opts.deny = countries
+	}
+}
+
+// WhitelistOption 开启后只放行 allow 列表中的国家（列表为空则全部拒绝）
+func WhitelistOption(whitelist bool) Option {
+	return func(opts *options) {
+		opts.whitelist = whitelist
+	}
+}
+
+func LoggerOption(logger logger.Logger) Option {
+	return func(opts *options) {
+		opts.logger = logger
+	}
+}
+
+type geoAdmission struct {
+	name    string
+	allow   map[string]struct{}
+	deny    map[string]struct{}
+	options options
+}
+
+// NewAdmission 创建按来源国家判定的准入规则。
+// 无法识别国家（库未加载、私有地址等）时：开启白名单则拒绝，否则放行。
+func NewAdmission(name string, opts ...Option) admission.Admission {
+	var options options
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	return &geoAdmission{
+		name:    name,
+		allow:   countrySet(options.allow),
+		deny:    countrySet(options.deny),
+		options: options,
+	}
+}
+
+func (p *geoAdmission) Admit(ctx context.Context, addr string, opts ...admission.Option) bool {
+	if addr == "" || p == nil {
+		return true
+	}
+
+	host := addr
+	if h, _, _ := net.SplitHostPort(addr); h != "" {
+		host = h
+	}
+
+	code, err := Country(net.ParseIP(host))
+	if err != nil && p.options.logger != nil {
+		p.options.logger.Debugf("geoip lookup %s: %v", host, err)
+	}
+
+	if p.admitCountry(code) {
+		return true
+	}
+
+	addRejected(p.name)
+	if p.options.logger != nil {
+		p.options.logger.Debugf("%s (%s) is denied", addr, code)
+	}
+	return false
+}
+
+func (p *geoAdmission) admitCountry(code string) bool {
+	if code != "" {
+		if _, ok := p.deny[code]; ok {
+			return false
+		}
+	}
+	if !p.options.whitelist {
+		return true
+	}
+	if code == "" {
+		return false
+	}
+	_, ok := p.allow[code]
+	return ok
+}
+
+func countrySet(countries []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(countries))
+	for _, c := range countries {
+		if c = strings.ToUpper(strings.TrimSpace(c)); c != "" {
+			set[c] = struct{}{}
+		}
+	}
+	return set
+}
+
+var rejected sync.Map // admission name -> *atomic.Int64
+
+func addRejected(name string) {
+	if name == "" {
+		return
+	}
+	v, _ := rejected.LoadOrStore(name, new(atomic.Int64))
+	v.(*atomic.Int64).Add(1)
+}
+
+// RejectedSnapshot 返回各准入规则自上次成功上报以来拒绝的连接数
+func RejectedSnapshot() map[string]int64 {
+	snapshot := make(map[string]int64)
+	rejected.Range(func(key, value any) bool {
+		if n := value.(*atomic.Int64).Load(); n > 0 {
+			snapshot[key.(string)] = n
+		}
+		return true
+	})
+	return snapshot
+}
+
+// SubtractRejected 上报成功后扣除已上报的计数
+func SubtractRejected(reported map[string]int64) {
+	for name, n := range reported {
+		if v, ok := rejected.Load(name); ok {
+			v.(*atomic.Int64).Add(-n)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package geoip
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// mmdbCountry 编码 {"country": {"iso_code": code}} 数据记录
+func mmdbCountry(code string) []byte {
+	b := []byte{0xe1, 0x47}
+	b = append(b, "country"...)
+	b = append(b, 0xe1, 0x48)
+	b = append(b, "iso_code"...)
+	b = append(b, 0x40|byte(len(code)))
+	return append(b, code...)
+}
+
+// writeTestDatabase 生成一个最小的 IPv4 国家库：
+// 0.0.0.0/2 为 US，64.0.0.0/2 查不到，128.0.0.0/1 为 CN。
+func writeTestDatabase(t *testing.T) string {
+	t.Helper()
+
+	us, cn := mmdbCountry("US"), mmdbCountry("CN")
+	const nodeCount = 2
+	data := func(offset int) uint32 { return uint32(nodeCount + 16 + offset) }
+	records := []uint32{
+		1, data(len(us)), // 节点 0：0 -> 节点 1，1 -> CN
+		data(0), nodeCount, // 节点 1：00 -> US，01 -> 查不到
+	}
+
+	var b []byte
+	for _, r := range records {
+		b = append(b, byte(r>>16), byte(r>>8), byte(r))
+	}
+	b = append(b, make([]byte, 16)...)
+	b = append(b, us...)
+	b = append(b, cn...)
+
+	b = append(b, "\xab\xcd\xefMaxMind.com"...)
+	uint16Field := func(key string, v uint16) {
+		b = append(b, 0x40|byte(len(key)))
+		b = append(b, key...)
+		b = append(b, 0xa2)
+		b = binary.BigEndian.AppendUint16(b, v)
+	}
+	b = append(b, 0xe5)
+	b = append(b, 0x4a)
+	b = append(b, "node_count"...)
+	b = append(b, 0xc1, nodeCount)
+	uint16Field("record_size", 24)
+	uint16Field("ip_version", 4)
+	uint16Field("binary_format_major_version", 2)
+	b = append(b, 0x4d)
+	b = append(b, "database_type"...)
+	b = append(b, 0x4c)
+	b = append(b, "Test-Country"...)
+
+	path := filepath.Join(t.TempDir(), "country.mmdb")
+	if err := os.WriteFile(path, b, 0o644); err != nil {
+		t.Fatalf("write database: %v", err)
+	}
+	return path
+}
+
+func openTestDatabase(t *testing.T) {
+	t.Helper()
+	path := writeTestDatabase(t)
+	if err := Open(path); err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	// 打开不存在的文件会卸载国家库，避免影响其他测试
+	t.Cleanup(func() { _ = Open(filepath.Join(filepath.Dir(path), "missing.mmdb")) })
+}
+
+func TestCountryLookup(t *testing.T) {
+	openTestDatabase(t)
+
+	for ip, want := range map[string]string{"10.0.0.1": "US", "100.0.0.1": "", "200.0.0.1": "CN"} {
+		if got, err := Country(net.ParseIP(ip)); err != nil || got != want {
+			t.Fatalf("%s: expected %q, got %q (%v)", ip, want, got, err)
+		}
+	}
+	if info := Info(); !info.Loaded || info.Type != "Test-Country" {
+		t.Fatalf("unexpected database info: %+v", info)
+	}
+}
+
+func TestAdmissionDecision(t *testing.T) {
+	openTestDatabase(t)
+
+	const us, unknown, cn = "10.0.0.1:443", "100.0.0.1:443", "200.0.0.1:443"
+	cases := []struct {
+		name string
+		opts []Option
+		addr string
+		want bool
+	}{
+		{"no rules", nil, cn, true},
+		{"no rules unknown", nil, unknown, true},
+		{"deny", []Option{DenyOption([]string{"CN"})}, cn, false},
+		{"deny other", []Option{DenyOption([]string{"CN"})}, us, true},
+		{"deny unknown", []Option{DenyOption([]string{"CN"})}, unknown, true},
+		{"deny lower case", []Option{DenyOption([]string{" cn "})}, cn, false},
+		{"allow without whitelist", []Option{AllowOption([]string{"US"})}, cn, true},
+		{"whitelist", []Option{WhitelistOption(true), AllowOption([]string{"US"})}, us, true},
+		{"whitelist other", []Option{WhitelistOption(true), AllowOption([]string{"US"})}, cn, false},
+		{"whitelist unknown", []Option{WhitelistOption(true), AllowOption([]string{"US"})}, unknown, false},
+		{"whitelist empty", []Option{WhitelistOption(true)}, us, false},
+		{"deny over allow", []Option{WhitelistOption(true), AllowOption([]string{"CN"}), DenyOption([]string{"CN"})}, cn, false},
+		{"address without port", []Option{DenyOption([]string{"CN"})}, "200.0.0.1", false},
+		{"empty address", []Option{WhitelistOption(true)}, "", true},
+	}
+	for _, tc := range cases {
+		adm := NewAdmission("decision_"+tc.name, tc.opts...)
+		if got := adm.Admit(context.Background(), tc.addr); got != tc.want {
+			t.Fatalf("%s: expected %v, got %v", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestAdmissionWithoutDatabase(t *testing.T) {
+	// 国家库未加载时所有地址都视为未知国家
+	if err := Open(filepath.Join(t.TempDir(), "missing.mmdb")); err == nil {
+		t.Fatalf("expected a missing database to fail")
+	}
+	if info := Info(); info.Loaded || info.Error == "" {
+		t.Fatalf("expected the load error to be kept, got %+v", info)
+	}
+
+	if !NewAdmission("nodb_deny", DenyOption([]string{"CN"})).Admit(context.Background(), "200.0.0.1:443") {
+		t.Fatalf("expected a deny list to admit unknown countries")
+	}
+	if NewAdmission("nodb_whitelist", WhitelistOption(true), AllowOption([]string{"CN"})).Admit(context.Background(), "200.0.0.1:443") {
+		t.Fatalf("expected a whitelist to reject unknown countries")
+	}
+}
+
+func TestAdmissionRejectedCounter(t *testing.T) {
+	openTestDatabase(t)
+
+	adm := NewAdmission("counter_geo", DenyOption([]string{"CN"}))
+	for _, addr := range []string{"200.0.0.1:1", "10.0.0.1:1", "200.0.0.2:2", "201.0.0.1:3"} {
+		adm.Admit(context.Background(), addr)
+	}
+	// 未命名的准入规则不计数
+	NewAdmission("", DenyOption([]string{"CN"})).Admit(context.Background(), "200.0.0.1:1")
+
+	snapshot := RejectedSnapshot()
+	if snapshot["counter_geo"] != 3 {
+		t.Fatalf("expected 3 rejections, got %d", snapshot["counter_geo"])
+	}
+	if _, ok := snapshot[""]; ok {
+		t.Fatalf("expected unnamed admissions not to be counted")
+	}
+
+	// 上报期间新增的拒绝在扣除后保留到下次上报
+	adm.Admit(context.Background(), "200.0.0.3:4")
+	SubtractRejected(map[string]int64{"counter_geo": snapshot["counter_geo"]})
+	if n := RejectedSnapshot()["counter_geo"]; n != 1 {
+		t.Fatalf("expected 1 rejection left after the report, got %d", n)
+	}
+	SubtractRejected(map[string]int64{"counter_geo": 1})
+	if _, ok := RejectedSnapshot()["counter_geo"]; ok {
+		t.Fatalf("expected no rejections left")
+	}
+}
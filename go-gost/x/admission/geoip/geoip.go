@@ -0,0 +1,113 @@
+package geoip
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// DefaultDatabasePath 未在 config.json 中指定时使用的国家库路径（相对于工作目录）
+const DefaultDatabasePath = "GeoLite2-Country.mmdb"
+
+// DatabaseInfo 当前加载的国家库信息，随系统信息一起上报给面板
+type DatabaseInfo struct {
+	Path      string `json:"path"`
+	Type      string `json:"type,omitempty"`
+	BuildTime int64  `json:"build_time,omitempty"` // 数据库构建时间（Unix 秒）
+	Loaded    bool   `json:"loaded"`
+	Error     string `json:"error,omitempty"`
+}
+
+type countryRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+var (
+	dbMu   sync.RWMutex
+	db     *maxminddb.Reader
+	dbInfo DatabaseInfo
+)
+
+// Open 加载（或重新加载）MaxMind 国家库。加载失败时保留错误信息，
+// 所有查询都返回未知国家。
+func Open(path string) error {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		path = DefaultDatabasePath
+	}
+
+	reader, err := maxminddb.Open(path)
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	if err != nil {
+		dbInfo = DatabaseInfo{Path: path, Error: err.Error()}
+		if db != nil {
+			_ = db.Close()
+			db = nil
+		}
+		return err
+	}
+
+	if db != nil {
+		_ = db.Close()
+	}
+	db = reader
+	dbInfo = DatabaseInfo{
+		Path:      path,
+		Type:      reader.Metadata.DatabaseType,
+		BuildTime: int64(reader.Metadata.BuildEpoch),
+		Loaded:    true,
+	}
+	return nil
+}
+
+// Info 返回当前国家库的状态
+func Info() DatabaseInfo {
+	dbMu.RLock()
+	defer dbMu.RUnlock()
+	return dbInfo
+}
+
+// Country 返回 IP 所属国家的 ISO 3166-1 两位代码（大写），
+// 查不到时返回空字符串。
+func Country(ip net.IP) (string, error) {
+	if ip == nil {
+		return "", errors.New("invalid ip")
+	}
+
+	dbMu.RLock()
+	defer dbMu.RUnlock()
+
+	if db == nil {
+		return "", errors.New("geoip database not loaded")
+	}
+
+	var record countryRecord
+	if err := db.Lookup(ip, &record); err != nil {
+		return "", err
+	}
+	code := record.Country.ISOCode
+	if code == "" {
+		code = record.RegisteredCountry.ISOCode
+	}
+	return strings.ToUpper(code), nil
+}
+
+// BuildTimeString 用于日志展示的构建时间
+func (i DatabaseInfo) BuildTimeString() string {
+	if i.BuildTime <= 0 {
+		return ""
+	}
+	return time.Unix(i.BuildTime, 0).UTC().Format(time.RFC3339)
+}
@@ -12,8 +12,8 @@ import (
 	"github.com/go-gost/core/service"
 	"github.com/go-gost/x/config"
 	parser "github.com/go-gost/x/config/parsing/service"
-	kill "github.com/go-gost/x/internal/util/port"
 	"github.com/go-gost/x/registry"
+	xservice "github.com/go-gost/x/service"
 )
 
 // swagger:parameters createServiceRequest
@@ -537,20 +537,8 @@ func pauseService(ctx *gin.Context) {
 		return
 	}
 
-	// 获取服务地址用于强制断开连接
-	var serviceAddr string
-	cfg := config.Global()
-	for _, s := range cfg.Services {
-		if s.Name == name {
-			serviceAddr = s.Addr
-			break
-		}
-	}
-
-	// 强制断开端口的所有连接
-	if serviceAddr != "" {
-		_ = kill.ForceClosePortConnections(serviceAddr)
-	}
+	// 断开该服务的所有活动连接
+	xservice.CloseServiceConnections(name)
 
 	// 更新配置中的暂停状态
 	config.OnUpdate(func(c *config.Config) error {
@@ -908,10 +896,8 @@ func pauseServices(ctx *gin.Context) {
 			return
 		}
 
-		// 强制断开端口的所有连接
-		if serviceConfig.Addr != "" {
-			_ = kill.ForceClosePortConnections(serviceConfig.Addr)
-		}
+		// 断开该服务的所有活动连接
+		xservice.CloseServiceConnections(stp.name)
 
 		// 记录已暂停的服务
 		pausedServices = append(pausedServices, struct {
@@ -164,6 +164,14 @@ type AdmissionConfig struct {
 	Redis     *RedisLoader  `yaml:",omitempty" json:"redis,omitempty"`
 	HTTP      *HTTPLoader   `yaml:"http,omitempty" json:"http,omitempty"`
 	Plugin    *PluginConfig `yaml:",omitempty" json:"plugin,omitempty"`
+	GeoIP     *GeoIPConfig  `yaml:"geoip,omitempty" json:"geoip,omitempty"`
+}
+
+// GeoIPConfig 按来源国家准入，国家代码为 ISO 3166-1 两位代码。
+// Whitelist 开启时只放行 Allow 中的国家，Deny 始终生效。
+type GeoIPConfig struct {
+	Allow []string `yaml:",omitempty" json:"allow,omitempty"`
+	Deny  []string `yaml:",omitempty" json:"deny,omitempty"`
 }
 
 type BypassConfig struct {
@@ -7,6 +7,7 @@ import (
 	"github.com/go-gost/core/admission"
 	"github.com/go-gost/core/logger"
 	xadmission "github.com/go-gost/x/admission"
+	"github.com/go-gost/x/admission/geoip"
 	admission_plugin "github.com/go-gost/x/admission/plugin"
 	"github.com/go-gost/x/config"
 	"github.com/go-gost/x/internal/loader"
@@ -43,6 +44,18 @@ func ParseAdmission(cfg *config.AdmissionConfig) admission.Admission {
 		}
 	}
 
+	if cfg.GeoIP != nil {
+		return geoip.NewAdmission(cfg.Name,
+			geoip.AllowOption(cfg.GeoIP.Allow),
+			geoip.DenyOption(cfg.GeoIP.Deny),
+			geoip.WhitelistOption(cfg.Reverse || cfg.Whitelist),
+			geoip.LoggerOption(logger.Default().WithFields(map[string]any{
+				"kind":      "admission",
+				"admission": cfg.Name,
+			})),
+		)
+	}
+
 	opts := []xadmission.Option{
 		xadmission.MatchersOption(cfg.Matchers),
 		xadmission.WhitelistOption(cfg.Reverse || cfg.Whitelist),
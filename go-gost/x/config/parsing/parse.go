@@ -29,4 +29,8 @@ const (
 	MDKeyNetnsOut = "netns.out"
 
 	MDKeyDialTimeout = "dialTimeout"
+
+	MDKeyProtocolAllow     = "protocol.allow"
+	MDKeyProtocolDeny      = "protocol.deny"
+	MDKeyProtocolWhitelist = "protocol.whitelist"
 )
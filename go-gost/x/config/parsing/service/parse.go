@@ -2,6 +2,7 @@ package service
 
 import (
 	"fmt"
+	"io"
 	"runtime"
 	"strconv"
 	"strings"
@@ -11,6 +12,7 @@ import (
 	"github.com/go-gost/core/chain"
 	"github.com/go-gost/core/handler"
 	"github.com/go-gost/core/hop"
+	"github.com/go-gost/core/limiter/traffic"
 	"github.com/go-gost/core/listener"
 	"github.com/go-gost/core/logger"
 	"github.com/go-gost/core/observer"
@@ -42,31 +44,9 @@ import (
 )
 
 func ParseService(cfg *config.ServiceConfig) (service.Service, error) {
-	if cfg.Listener == nil {
-		cfg.Listener = &config.ListenerConfig{}
-	}
-	if strings.TrimSpace(cfg.Listener.Type) == "" {
-		cfg.Listener.Type = "tcp"
-	}
-
-	if cfg.Handler == nil {
-		cfg.Handler = &config.HandlerConfig{}
-	}
-	if strings.TrimSpace(cfg.Handler.Type) == "" {
-		cfg.Handler.Type = "auto"
-	}
-
-	log := logger.Default()
-	if loggers := logger_parser.List(cfg.Logger, cfg.Loggers...); len(loggers) > 0 {
-		log = logger.LoggerGroup(loggers...)
-	}
+	setServiceDefaults(cfg)
 
-	serviceLogger := log.WithFields(map[string]any{
-		"kind":     "service",
-		"service":  cfg.Name,
-		"listener": cfg.Listener.Type,
-		"handler":  cfg.Handler.Type,
-	})
+	log, serviceLogger := serviceLoggers(cfg)
 
 	tlsCfg := cfg.Listener.TLS
 	if tlsCfg == nil {
@@ -95,73 +75,15 @@ func ParseService(cfg *config.ServiceConfig) (service.Service, error) {
 
 	admissions := admission_parser.List(cfg.Admission, cfg.Admissions...)
 
-	var sockOpts *chain.SockOpts
-	if cfg.SockOpts != nil {
-		sockOpts = &chain.SockOpts{
-			Mark: cfg.SockOpts.Mark,
-		}
+	opts := parseServiceOptions(cfg)
+	if err := opts.protocolPolicy.Validate(); err != nil {
+		serviceLogger.Error(err)
+		return nil, err
 	}
 
-	var ppv int
-	ifce := cfg.Interface
-	var preUp, preDown, postUp, postDown []string
-	var ignoreChain bool
 	var pStats stats.Stats
-	var observerPeriod time.Duration
-	var netnsIn, netnsOut string
-	var dialTimeout time.Duration
-
-	var limiterRefreshInterval time.Duration
-	var limiterCleanupInterval time.Duration
-	var limiterScope string
-
-	enableStats := true
-	observerPeriod = 5 * time.Second
-
-	if cfg.Metadata != nil {
-		md := metadata.NewMetadata(cfg.Metadata)
-		ppv = mdutil.GetInt(md, parsing.MDKeyProxyProtocol)
-		if v := mdutil.GetString(md, parsing.MDKeyInterface); v != "" {
-			ifce = v
-		}
-		if v := mdutil.GetInt(md, parsing.MDKeySoMark); v > 0 {
-			sockOpts = &chain.SockOpts{
-				Mark: v,
-			}
-		}
-		preUp = mdutil.GetStrings(md, parsing.MDKeyPreUp)
-		preDown = mdutil.GetStrings(md, parsing.MDKeyPreDown)
-		postUp = mdutil.GetStrings(md, parsing.MDKeyPostUp)
-		postDown = mdutil.GetStrings(md, parsing.MDKeyPostDown)
-		ignoreChain = mdutil.GetBool(md, parsing.MDKeyIgnoreChain)
-
-		if md.IsExists(parsing.MDKeyEnableStats) {
-			enableStats = mdutil.GetBool(md, parsing.MDKeyEnableStats)
-		}
-
-		if period := mdutil.GetDuration(md, parsing.MDKeyObserverPeriod, "observePeriod"); period > 0 {
-			observerPeriod = period
-		}
-
-		netnsIn = mdutil.GetString(md, parsing.MDKeyNetns)
-		netnsOut = mdutil.GetString(md, parsing.MDKeyNetnsOut)
-
-		dialTimeout = mdutil.GetDuration(md, parsing.MDKeyDialTimeout)
-
-		limiterRefreshInterval = mdutil.GetDuration(md, parsing.MDKeyLimiterRefreshInterval)
-		limiterCleanupInterval = mdutil.GetDuration(md, parsing.MDKeyLimiterCleanupInterval)
-		limiterScope = mdutil.GetString(md, parsing.MDKeyLimiterScope)
-	}
-
-	if enableStats {
-		resetTraffic := true
-		if cfg.Metadata != nil {
-			md := metadata.NewMetadata(cfg.Metadata)
-			if md.IsExists(parsing.MDKeyObserverResetTraffic) {
-				resetTraffic = mdutil.GetBool(md, parsing.MDKeyObserverResetTraffic)
-			}
-		}
-		pStats = xstats.NewStats(resetTraffic)
+	if opts.enableStats {
+		pStats = xstats.NewStats(opts.resetTraffic)
 	}
 
 	listenerLogger := serviceLogger.WithFields(map[string]any{
@@ -169,45 +91,23 @@ func ParseService(cfg *config.ServiceConfig) (service.Service, error) {
 	})
 
 	routerOpts := []chain.RouterOption{
-		chain.TimeoutRouterOption(dialTimeout),
-		chain.InterfaceRouterOption(ifce),
-		chain.NetnsRouterOption(netnsOut),
-		chain.SockOptsRouterOption(sockOpts),
+		chain.TimeoutRouterOption(opts.dialTimeout),
+		chain.InterfaceRouterOption(opts.ifce),
+		chain.NetnsRouterOption(opts.netnsOut),
+		chain.SockOptsRouterOption(opts.sockOpts),
 		chain.ResolverRouterOption(registry.ResolverRegistry().Get(cfg.Resolver)),
 		chain.HostMapperRouterOption(registry.HostsRegistry().Get(cfg.Hosts)),
 		chain.LoggerRouterOption(listenerLogger),
 	}
-	if !ignoreChain {
+	if !opts.ignoreChain {
 		routerOpts = append(routerOpts,
 			chain.ChainRouterOption(chainGroup(cfg.Listener.Chain, cfg.Listener.ChainGroup)),
 		)
 	}
 
-	var trafficLimiter listener.Option
-	if cfg.Limiter != "" {
-		lim := registry.TrafficLimiterRegistry().Get(cfg.Limiter)
-		if lim == nil {
-			// Try to parse as simple number (bandwidth in bytes/sec)
-			if val, err := strconv.Atoi(cfg.Limiter); err == nil && val > 0 {
-				lim = xtraffic.NewTrafficLimiter(
-					xtraffic.LimitsOption(fmt.Sprintf("%s %dB %dB", xtraffic.ServiceLimitKey, val, val)),
-				)
-			}
-			if lim == nil {
-				lim = xtraffic.NewTrafficLimiter(
-					xtraffic.LimitsOption(fmt.Sprintf("%s %s %s", xtraffic.ServiceLimitKey, cfg.Limiter, cfg.Limiter)),
-				)
-			}
-		}
-		trafficLimiter = listener.TrafficLimiterOption(
-			cache_limiter.NewCachedTrafficLimiter(
-				lim,
-				cache_limiter.RefreshIntervalOption(limiterRefreshInterval),
-				cache_limiter.CleanupIntervalOption(limiterCleanupInterval),
-				cache_limiter.ScopeOption(limiterScope),
-			),
-		)
-	}
+	// The listener always gets a switch so that the limit can be added,
+	// changed or removed later without rebuilding the service.
+	limiterSwitch := xservice.NewTrafficLimiterSwitch(parseTrafficLimiter(cfg, opts))
 
 	listenOpts := []listener.Option{
 		listener.AddrOption(cfg.Addr),
@@ -217,17 +117,15 @@ func ParseService(cfg *config.ServiceConfig) (service.Service, error) {
 		listener.TLSConfigOption(tlsConfig),
 		listener.AdmissionOption(xadmission.AdmissionGroup(admissions...)),
 		listener.ConnLimiterOption(registry.ConnLimiterRegistry().Get(cfg.CLimiter)),
+		listener.TrafficLimiterOption(limiterSwitch),
 		listener.ServiceOption(cfg.Name),
-		listener.ProxyProtocolOption(ppv),
+		listener.ProxyProtocolOption(opts.ppv),
 		listener.StatsOption(pStats),
-		listener.NetnsOption(netnsIn),
+		listener.NetnsOption(opts.netnsIn),
 		listener.LoggerOption(listenerLogger),
 	}
-	if trafficLimiter != nil {
-		listenOpts = append(listenOpts, trafficLimiter)
-	}
 
-	if netnsIn != "" {
+	if opts.netnsIn != "" {
 		runtime.LockOSThread()
 		defer runtime.UnlockOSThread()
 
@@ -239,18 +137,18 @@ func ParseService(cfg *config.ServiceConfig) (service.Service, error) {
 
 		var ns netns.NsHandle
 
-		if strings.HasPrefix(netnsIn, "/") {
-			ns, err = netns.GetFromPath(netnsIn)
+		if strings.HasPrefix(opts.netnsIn, "/") {
+			ns, err = netns.GetFromPath(opts.netnsIn)
 		} else {
-			ns, err = netns.GetFromName(netnsIn)
+			ns, err = netns.GetFromName(opts.netnsIn)
 		}
 		if err != nil {
-			return nil, fmt.Errorf("netns.Get(%s): %v", netnsIn, err)
+			return nil, fmt.Errorf("netns.Get(%s): %v", opts.netnsIn, err)
 		}
 		defer ns.Close()
 
 		if err := netns.Set(ns); err != nil {
-			return nil, fmt.Errorf("netns.Set(%s): %v", netnsIn, err)
+			return nil, fmt.Errorf("netns.Set(%s): %v", opts.netnsIn, err)
 		}
 	}
 
@@ -270,32 +168,254 @@ func ParseService(cfg *config.ServiceConfig) (service.Service, error) {
 		return nil, err
 	}
 
+	h, recorders, err := parseHandler(cfg, opts, log, serviceLogger)
+	if err != nil {
+		return nil, err
+	}
+
+	var observer observer.Observer
+	// 如果服务名以_tls结尾，则不启用观察器
+	if strings.HasSuffix(cfg.Name, "_tls") {
+		observer = nil
+		fmt.Println("服务名以_tls结尾，跳过观察器启用")
+	} else if cfg.Observer != "" {
+		observer = registry.ObserverRegistry().Get(cfg.Observer)
+	} else if pStats != nil {
+		observer = registry.ObserverRegistry().Get("console")
+	}
+
+	s := xservice.NewService(cfg.Name, ln, h,
+		xservice.AdmissionOption(xadmission.AdmissionGroup(admissions...)),
+		xservice.PreUpOption(opts.preUp),
+		xservice.PreDownOption(opts.preDown),
+		xservice.PostUpOption(opts.postUp),
+		xservice.PostDownOption(opts.postDown),
+		xservice.RecordersOption(recorders...),
+		xservice.StatsOption(pStats),
+		xservice.ObserverOption(observer),
+		xservice.ObserverPeriodOption(opts.observerPeriod),
+		xservice.LoggerOption(serviceLogger),
+		xservice.TrafficLimiterSwitchOption(limiterSwitch),
+		xservice.ProtocolPolicyOption(opts.protocolPolicy),
+	)
+
+	serviceLogger.Infof("listening on %s/%s", s.Addr().String(), s.Addr().Network())
+	return s, nil
+}
+
+// UpdateService rebuilds the handler (including the forwarder and its
+// selector) and the service traffic limiter from cfg and swaps them into
+// the running service. The listener and established connections are kept.
+func UpdateService(svc service.Service, cfg *config.ServiceConfig) error {
+	updater, ok := svc.(xservice.Updater)
+	if !ok {
+		return fmt.Errorf("service %s does not support update", cfg.Name)
+	}
+
+	setServiceDefaults(cfg)
+	log, serviceLogger := serviceLoggers(cfg)
+
+	opts := parseServiceOptions(cfg)
+	if opts.netnsIn != "" {
+		// the handler may need to be created inside the namespace
+		return fmt.Errorf("service %s runs in netns %s", cfg.Name, opts.netnsIn)
+	}
+	if err := opts.protocolPolicy.Validate(); err != nil {
+		return err
+	}
+
+	h, _, err := parseHandler(cfg, opts, log, serviceLogger)
+	if err != nil {
+		return err
+	}
+	if err := updater.Update(h, parseTrafficLimiter(cfg, opts)); err != nil {
+		if closer, ok := h.(io.Closer); ok {
+			closer.Close()
+		}
+		return err
+	}
+	if setter, ok := svc.(xservice.ProtocolPolicySetter); ok {
+		setter.SetProtocolPolicy(opts.protocolPolicy)
+	}
+
+	serviceLogger.Infof("updated in place on %s/%s", svc.Addr().String(), svc.Addr().Network())
+	return nil
+}
+
+func setServiceDefaults(cfg *config.ServiceConfig) {
+	if cfg.Listener == nil {
+		cfg.Listener = &config.ListenerConfig{}
+	}
+	if strings.TrimSpace(cfg.Listener.Type) == "" {
+		cfg.Listener.Type = "tcp"
+	}
+
+	if cfg.Handler == nil {
+		cfg.Handler = &config.HandlerConfig{}
+	}
+	if strings.TrimSpace(cfg.Handler.Type) == "" {
+		cfg.Handler.Type = "auto"
+	}
+}
+
+func serviceLoggers(cfg *config.ServiceConfig) (log logger.Logger, serviceLogger logger.Logger) {
+	log = logger.Default()
+	if loggers := logger_parser.List(cfg.Logger, cfg.Loggers...); len(loggers) > 0 {
+		log = logger.LoggerGroup(loggers...)
+	}
+
+	serviceLogger = log.WithFields(map[string]any{
+		"kind":     "service",
+		"service":  cfg.Name,
+		"listener": cfg.Listener.Type,
+		"handler":  cfg.Handler.Type,
+	})
+	return
+}
+
+// serviceOptions holds the settings taken from the service config and its metadata.
+type serviceOptions struct {
+	ppv            int
+	ifce           string
+	sockOpts       *chain.SockOpts
+	preUp          []string
+	preDown        []string
+	postUp         []string
+	postDown       []string
+	ignoreChain    bool
+	enableStats    bool
+	resetTraffic   bool
+	observerPeriod time.Duration
+	netnsIn        string
+	netnsOut       string
+	dialTimeout    time.Duration
+
+	limiterRefreshInterval time.Duration
+	limiterCleanupInterval time.Duration
+	limiterScope           string
+
+	protocolPolicy *xservice.ProtocolPolicy
+}
+
+func parseServiceOptions(cfg *config.ServiceConfig) serviceOptions {
+	opts := serviceOptions{
+		ifce:           cfg.Interface,
+		enableStats:    true,
+		resetTraffic:   true,
+		observerPeriod: 5 * time.Second,
+	}
+	if cfg.SockOpts != nil {
+		opts.sockOpts = &chain.SockOpts{
+			Mark: cfg.SockOpts.Mark,
+		}
+	}
+
+	if cfg.Metadata == nil {
+		return opts
+	}
+
+	md := metadata.NewMetadata(cfg.Metadata)
+	opts.ppv = mdutil.GetInt(md, parsing.MDKeyProxyProtocol)
+	if v := mdutil.GetString(md, parsing.MDKeyInterface); v != "" {
+		opts.ifce = v
+	}
+	if v := mdutil.GetInt(md, parsing.MDKeySoMark); v > 0 {
+		opts.sockOpts = &chain.SockOpts{
+			Mark: v,
+		}
+	}
+	opts.preUp = mdutil.GetStrings(md, parsing.MDKeyPreUp)
+	opts.preDown = mdutil.GetStrings(md, parsing.MDKeyPreDown)
+	opts.postUp = mdutil.GetStrings(md, parsing.MDKeyPostUp)
+	opts.postDown = mdutil.GetStrings(md, parsing.MDKeyPostDown)
+	opts.ignoreChain = mdutil.GetBool(md, parsing.MDKeyIgnoreChain)
+
+	if md.IsExists(parsing.MDKeyEnableStats) {
+		opts.enableStats = mdutil.GetBool(md, parsing.MDKeyEnableStats)
+	}
+	if md.IsExists(parsing.MDKeyObserverResetTraffic) {
+		opts.resetTraffic = mdutil.GetBool(md, parsing.MDKeyObserverResetTraffic)
+	}
+
+	if period := mdutil.GetDuration(md, parsing.MDKeyObserverPeriod, "observePeriod"); period > 0 {
+		opts.observerPeriod = period
+	}
+
+	opts.netnsIn = mdutil.GetString(md, parsing.MDKeyNetns)
+	opts.netnsOut = mdutil.GetString(md, parsing.MDKeyNetnsOut)
+
+	opts.dialTimeout = mdutil.GetDuration(md, parsing.MDKeyDialTimeout)
+
+	opts.limiterRefreshInterval = mdutil.GetDuration(md, parsing.MDKeyLimiterRefreshInterval)
+	opts.limiterCleanupInterval = mdutil.GetDuration(md, parsing.MDKeyLimiterCleanupInterval)
+	opts.limiterScope = mdutil.GetString(md, parsing.MDKeyLimiterScope)
+
+	if md.IsExists(parsing.MDKeyProtocolAllow) || md.IsExists(parsing.MDKeyProtocolDeny) || md.IsExists(parsing.MDKeyProtocolWhitelist) {
+		opts.protocolPolicy = &xservice.ProtocolPolicy{
+			Whitelist: mdutil.GetBool(md, parsing.MDKeyProtocolWhitelist),
+			Allow:     mdutil.GetStrings(md, parsing.MDKeyProtocolAllow),
+			Deny:      mdutil.GetStrings(md, parsing.MDKeyProtocolDeny),
+		}
+	}
+	return opts
+}
+
+// parseTrafficLimiter returns the service level traffic limiter, or nil if
+// the service is not limited.
+func parseTrafficLimiter(cfg *config.ServiceConfig, opts serviceOptions) traffic.TrafficLimiter {
+	if cfg.Limiter == "" {
+		return nil
+	}
+
+	lim := registry.TrafficLimiterRegistry().Get(cfg.Limiter)
+	if lim == nil {
+		// Try to parse as simple number (bandwidth in bytes/sec)
+		if val, err := strconv.Atoi(cfg.Limiter); err == nil && val > 0 {
+			lim = xtraffic.NewTrafficLimiter(
+				xtraffic.LimitsOption(fmt.Sprintf("%s %dB %dB", xtraffic.ServiceLimitKey, val, val)),
+			)
+		}
+		if lim == nil {
+			lim = xtraffic.NewTrafficLimiter(
+				xtraffic.LimitsOption(fmt.Sprintf("%s %s %s", xtraffic.ServiceLimitKey, cfg.Limiter, cfg.Limiter)),
+			)
+		}
+	}
+	return cache_limiter.NewCachedTrafficLimiter(
+		lim,
+		cache_limiter.RefreshIntervalOption(opts.limiterRefreshInterval),
+		cache_limiter.CleanupIntervalOption(opts.limiterCleanupInterval),
+		cache_limiter.ScopeOption(opts.limiterScope),
+	)
+}
+
+func parseHandler(cfg *config.ServiceConfig, opts serviceOptions, log logger.Logger, serviceLogger logger.Logger) (handler.Handler, []recorder.RecorderObject, error) {
 	handlerLogger := serviceLogger.WithFields(map[string]any{
 		"kind": "handler",
 	})
 
-	tlsCfg = cfg.Handler.TLS
+	tlsCfg := cfg.Handler.TLS
 	if tlsCfg == nil {
 		tlsCfg = &config.TLSConfig{}
 	}
-	tlsConfig, err = tls_util.LoadServerConfig(tlsCfg)
+	tlsConfig, err := tls_util.LoadServerConfig(tlsCfg)
 	if err != nil {
 		handlerLogger.Error(err)
-		return nil, err
+		return nil, nil, err
 	}
 	if tlsConfig == nil {
 		tlsConfig = parsing.DefaultTLSConfig().Clone()
 		tls_util.SetTLSOptions(tlsConfig, tlsCfg.Options)
 	}
 
-	authers = auth_parser.List(cfg.Handler.Auther, cfg.Handler.Authers...)
+	authers := auth_parser.List(cfg.Handler.Auther, cfg.Handler.Authers...)
 	if len(authers) == 0 {
 		if auther := auth_parser.ParseAutherFromAuth(cfg.Handler.Auth); auther != nil {
 			authers = append(authers, auther)
 		}
 	}
 
-	auther = nil
+	var auther auth.Authenticator
 	if len(authers) > 0 {
 		auther = xauth.AuthenticatorGroup(authers...)
 	}
@@ -316,18 +436,18 @@ func ParseService(cfg *config.ServiceConfig) (service.Service, error) {
 		})
 	}
 
-	routerOpts = []chain.RouterOption{
+	routerOpts := []chain.RouterOption{
 		chain.RetriesRouterOption(cfg.Handler.Retries),
-		chain.TimeoutRouterOption(dialTimeout),
-		chain.InterfaceRouterOption(ifce),
-		chain.NetnsRouterOption(netnsOut),
-		chain.SockOptsRouterOption(sockOpts),
+		chain.TimeoutRouterOption(opts.dialTimeout),
+		chain.InterfaceRouterOption(opts.ifce),
+		chain.NetnsRouterOption(opts.netnsOut),
+		chain.SockOptsRouterOption(opts.sockOpts),
 		chain.ResolverRouterOption(registry.ResolverRegistry().Get(cfg.Resolver)),
 		chain.HostMapperRouterOption(registry.HostsRegistry().Get(cfg.Hosts)),
 		chain.RecordersRouterOption(recorders...),
 		chain.LoggerRouterOption(handlerLogger),
 	}
-	if !ignoreChain {
+	if !opts.ignoreChain {
 		routerOpts = append(routerOpts,
 			chain.ChainRouterOption(chainGroup(cfg.Handler.Chain, cfg.Handler.ChainGroup)),
 		)
@@ -347,16 +467,16 @@ func ParseService(cfg *config.ServiceConfig) (service.Service, error) {
 			handler.RecordersOption(recorders...),
 			handler.LoggerOption(handlerLogger),
 			handler.ServiceOption(cfg.Name),
-			handler.NetnsOption(netnsIn),
+			handler.NetnsOption(opts.netnsIn),
 		)
 	} else {
-		return nil, fmt.Errorf("unknown handler: %s", cfg.Handler.Type)
+		return nil, nil, fmt.Errorf("unknown handler: %s", cfg.Handler.Type)
 	}
 
 	if forwarder, ok := h.(handler.Forwarder); ok {
 		hop, err := parseForwarder(cfg.Forwarder, log)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		forwarder.Forward(hop)
 	}
@@ -367,34 +487,9 @@ func ParseService(cfg *config.ServiceConfig) (service.Service, error) {
 	handlerLogger.Debugf("metadata: %v", cfg.Handler.Metadata)
 	if err := h.Init(metadata.NewMetadata(cfg.Handler.Metadata)); err != nil {
 		handlerLogger.Error("init: ", err)
-		return nil, err
-	}
-	var observer observer.Observer
-	// 如果服务名以_tls结尾，则不启用观察器
-	if strings.HasSuffix(cfg.Name, "_tls") {
-		observer = nil
-		fmt.Println("服务名以_tls结尾，跳过观察器启用")
-	} else if cfg.Observer != "" {
-		observer = registry.ObserverRegistry().Get(cfg.Observer)
-	} else if pStats != nil {
-		observer = registry.ObserverRegistry().Get("console")
+		return nil, nil, err
 	}
-
-	s := xservice.NewService(cfg.Name, ln, h,
-		xservice.AdmissionOption(xadmission.AdmissionGroup(admissions...)),
-		xservice.PreUpOption(preUp),
-		xservice.PreDownOption(preDown),
-		xservice.PostUpOption(postUp),
-		xservice.PostDownOption(postDown),
-		xservice.RecordersOption(recorders...),
-		xservice.StatsOption(pStats),
-		xservice.ObserverOption(observer),
-		xservice.ObserverPeriodOption(observerPeriod),
-		xservice.LoggerOption(serviceLogger),
-	)
-
-	serviceLogger.Infof("listening on %s/%s", s.Addr().String(), s.Addr().Network())
-	return s, nil
+	return h, recorders, nil
 }
 
 func parseForwarder(cfg *config.ForwarderConfig, log logger.Logger) (hop.Hop, error) {
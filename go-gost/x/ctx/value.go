@@ -129,3 +129,25 @@ func ExcludeNodesFromContext(ctx context.Context) []string {
 	v, _ := ctx.Value(keyExcludeNodes).([]string)
 	return v
 }
+
+// connTargetKey saves the callback that records the upstream target of the
+// connection being handled. Services set it to fill in their connection table.
+type connTargetKey struct{}
+
+type ConnTargetFunc func(network, addr string)
+
+var (
+	keyConnTarget = &connTargetKey{}
+)
+
+func ContextWithConnTarget(ctx context.Context, f ConnTargetFunc) context.Context {
+	return context.WithValue(ctx, keyConnTarget, f)
+}
+
+// SetConnTarget reports the dialed target of the current connection, if the
+// service tracks it.
+func SetConnTarget(ctx context.Context, network, addr string) {
+	if f, _ := ctx.Value(keyConnTarget).(ConnTargetFunc); f != nil {
+		f(network, addr)
+	}
+}
@@ -141,6 +141,9 @@ func (h *forwardHandler) Handle(ctx context.Context, conn net.Conn, opts ...hand
 			var buf bytes.Buffer
 			cc, err := h.options.Router.Dial(ctxvalue.ContextWithBuffer(ctx, &buf), "tcp", address)
 			ro.Route = buf.String()
+			if err == nil {
+				ctxvalue.SetConnTarget(ctx, "tcp", address)
+			}
 			return cc, err
 		}
 		sniffer := &forwarder.Sniffer{
@@ -153,6 +156,7 @@ func (h *forwardHandler) Handle(ctx context.Context, conn net.Conn, opts ...hand
 			NegotiatedProtocol:  h.md.alpn,
 			CertPool:            h.certPool,
 			MitmBypass:          h.md.mitmBypass,
+			ForwardedFor:        h.md.httpForwardedFor,
 			ReadTimeout:         h.md.readTimeout,
 		}
 
@@ -251,6 +255,7 @@ func (h *forwardHandler) Handle(ctx context.Context, conn net.Conn, opts ...hand
 			marker.Reset()
 		}
 		defer cc.Close()
+		ctxvalue.SetConnTarget(ctx, network, addr)
 
 		if err := xnet.Transport(conn, cc); err != nil {
 			if marker := target.Marker(); marker != nil {
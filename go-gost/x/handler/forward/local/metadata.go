@@ -13,8 +13,9 @@ import (
 )
 
 type metadata struct {
-	readTimeout   time.Duration
-	httpKeepalive bool
+	readTimeout      time.Duration
+	httpKeepalive    bool
+	httpForwardedFor bool
 
 	sniffing                    bool
 	sniffingTimeout             time.Duration
@@ -40,6 +41,7 @@ func (h *forwardHandler) parseMetadata(md mdata.Metadata) (err error) {
 	}
 
 	h.md.httpKeepalive = mdutil.GetBool(md, "http.keepalive")
+	h.md.httpForwardedFor = mdutil.GetBool(md, "http.forwardedFor")
 
 	h.md.sniffing = mdutil.GetBool(md, "sniffing")
 	h.md.sniffingTimeout = mdutil.GetDuration(md, "sniffing.timeout")
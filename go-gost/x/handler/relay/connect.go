@@ -113,6 +113,7 @@ func (h *relayHandler) handleConnect(ctx context.Context, conn net.Conn, network
 		return err
 	}
 	defer cc.Close()
+	ctxvalue.SetConnTarget(ctx, network, address)
 
 	if h.md.noDelay {
 		if _, err := resp.WriteTo(conn); err != nil {
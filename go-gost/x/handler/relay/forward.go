@@ -79,6 +79,7 @@ func (h *relayHandler) handleForward(ctx context.Context, conn net.Conn, network
 	if marker := target.Marker(); marker != nil {
 		marker.Reset()
 	}
+	ctxvalue.SetConnTarget(ctx, network, target.Addr)
 
 	if h.md.noDelay {
 		if _, err := resp.WriteTo(conn); err != nil {
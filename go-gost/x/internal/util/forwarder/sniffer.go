@@ -115,6 +115,10 @@ type Sniffer struct {
 	CertPool           tls_util.CertPool
 	MitmBypass         bypass.Bypass
 
+	// ForwardedFor appends the peer address to X-Forwarded-For and sets
+	// X-Real-IP, for reverse proxying to web backends.
+	ForwardedFor bool
+
 	ReadTimeout time.Duration
 }
 
@@ -341,6 +345,25 @@ func (h *Sniffer) dial(ctx context.Context, conn net.Conn, req *http.Request, ho
 	return nil, nil, errors.New("all nodes failed")
 }
 
+// setForwardedFor records the direct peer of the proxy in the request.
+// X-Forwarded-For entries sent by the client are kept in front of it, while
+// X-Real-IP always carries the peer itself.
+func setForwardedFor(req *http.Request, remoteAddr string) {
+	ip := remoteAddr
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		ip = host
+	}
+	if ip == "" {
+		return
+	}
+	forwarded := ip
+	if prior := req.Header.Values("X-Forwarded-For"); len(prior) > 0 {
+		forwarded = strings.Join(prior, ", ") + ", " + ip
+	}
+	req.Header.Set("X-Forwarded-For", forwarded)
+	req.Header.Set("X-Real-IP", ip)
+}
+
 func (h *Sniffer) serveH2(ctx context.Context, conn net.Conn, ho *HandleOptions) error {
 	const expectedBody = "SM\r\n\r\n"
 
@@ -439,6 +462,10 @@ func (h *Sniffer) httpRoundTrip(ctx context.Context, rw, cc io.ReadWriter, node
 		}
 	}
 
+	if h.ForwardedFor {
+		setForwardedFor(req, ro.RemoteAddr)
+	}
+
 	var responseHeader map[string]string
 	var respBodyRewrites []chain.HTTPBodyRewriteSettings
 	if httpSettings := node.Options().HTTP; httpSettings != nil {
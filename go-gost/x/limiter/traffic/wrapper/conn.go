@@ -44,6 +44,10 @@ func WrapConn(c net.Conn, tlimiter traffic.TrafficLimiter, key string, opts ...l
 func (c *limitConn) Read(b []byte) (n int, err error) {
 	limiter := c.limiter.In(context.Background(), c.key, c.opts...)
 	if limiter == nil || limiter.Limit() <= 0 {
+		// the limit may be lifted while data is still buffered
+		if c.rbuf.Len() > 0 {
+			return c.rbuf.Read(b)
+		}
 		return c.Conn.Read(b)
 	}
 
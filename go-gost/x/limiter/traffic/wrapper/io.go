@@ -34,6 +34,10 @@ func WrapReadWriter(limiter traffic.TrafficLimiter, rw io.ReadWriter, key string
 func (p *readWriter) Read(b []byte) (n int, err error) {
 	limiter := p.limiter.In(context.Background(), p.key, p.opts...)
 	if limiter == nil || limiter.Limit() <= 0 {
+		// the limit may be lifted while data is still buffered
+		if p.rbuf.Len() > 0 {
+			return p.rbuf.Read(b)
+		}
 		return p.ReadWriter.Read(b)
 	}
 
@@ -0,0 +1,153 @@
+package vhost
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const acmeChallengePrefix = "/.well-known/acme-challenge/"
+
+// ACME HTTP-01 challenges are answered on port 80 of the node. When a vhost
+// socket already owns port 80 it answers them before routing; otherwise a
+// temporary server is started for as long as challenges are pending.
+var (
+	challengesMu    sync.Mutex
+	challenges      = make(map[string]acmeChallenge)
+	challengeServer *http.Server
+)
+
+type acmeChallenge struct {
+	keyAuth string
+	expires time.Time
+}
+
+// SetACMEChallenge publishes the key authorization for an HTTP-01 token.
+func SetACMEChallenge(token, keyAuth string, ttl time.Duration) error {
+	if token == "" || strings.ContainsAny(token, "/?#") || keyAuth == "" {
+		return errors.New("invalid acme challenge")
+	}
+	if ttl <= 0 {
+		ttl = 10 * time.Minute
+	}
+
+	challengesMu.Lock()
+	defer challengesMu.Unlock()
+
+	purgeChallengesLocked()
+	challenges[token] = acmeChallenge{keyAuth: keyAuth, expires: time.Now().Add(ttl)}
+
+	if challengeServer != nil || port80Bound() {
+		return nil
+	}
+	ln, err := net.Listen("tcp", ":80")
+	if err != nil {
+		delete(challenges, token)
+		return err
+	}
+	challengeServer = &http.Server{
+		Handler:           http.HandlerFunc(serveChallengeHTTP),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go challengeServer.Serve(ln)
+	return nil
+}
+
+// DeleteACMEChallenge removes a token and stops the temporary server once
+// nothing is pending.
+func DeleteACMEChallenge(token string) {
+	challengesMu.Lock()
+	defer challengesMu.Unlock()
+
+	delete(challenges, token)
+	purgeChallengesLocked()
+	if len(challenges) == 0 {
+		stopChallengeServerLocked()
+	}
+}
+
+func lookupChallenge(path string) (string, bool) {
+	token, ok := strings.CutPrefix(path, acmeChallengePrefix)
+	if !ok {
+		return "", false
+	}
+
+	challengesMu.Lock()
+	defer challengesMu.Unlock()
+
+	c, ok := challenges[token]
+	if !ok || time.Now().After(c.expires) {
+		return "", false
+	}
+	return c.keyAuth, true
+}
+
+func serveChallengeHTTP(w http.ResponseWriter, r *http.Request) {
+	keyAuth, ok := lookupChallenge(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(keyAuth))
+}
+
+// answerChallenge writes the key authorization as a complete HTTP response
+// on a connection accepted by a vhost socket.
+func answerChallenge(conn net.Conn, keyAuth string) {
+	defer conn.Close()
+	resp := &http.Response{
+		StatusCode:    http.StatusOK,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"text/plain"}},
+		ContentLength: int64(len(keyAuth)),
+		Body:          io.NopCloser(strings.NewReader(keyAuth)),
+		Close:         true,
+	}
+	resp.Write(conn)
+}
+
+func purgeChallengesLocked() {
+	now := time.Now()
+	for token, c := range challenges {
+		if now.After(c.expires) {
+			delete(challenges, token)
+		}
+	}
+}
+
+func stopChallengeServerLocked() {
+	if challengeServer != nil {
+		challengeServer.Close()
+		challengeServer = nil
+	}
+}
+
+// stopChallengeServer releases port 80 for a vhost socket, which answers the
+// pending challenges from then on.
+func stopChallengeServer() {
+	challengesMu.Lock()
+	defer challengesMu.Unlock()
+	stopChallengeServerLocked()
+}
+
+func port80Bound() bool {
+	muxesMu.Lock()
+	defer muxesMu.Unlock()
+	for addr := range muxes {
+		if isPort80(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func isPort80(addr string) bool {
+	_, port, err := net.SplitHostPort(addr)
+	return err == nil && port == "80"
+}
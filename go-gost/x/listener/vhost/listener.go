@@ -0,0 +1,209 @@
+package vhost
+
+import (
+	"crypto/tls"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/go-gost/core/limiter"
+	"github.com/go-gost/core/listener"
+	"github.com/go-gost/core/logger"
+	md "github.com/go-gost/core/metadata"
+	admission "github.com/go-gost/x/admission/wrapper"
+	climiter "github.com/go-gost/x/limiter/conn/wrapper"
+	limiter_wrapper "github.com/go-gost/x/limiter/traffic/wrapper"
+	metrics "github.com/go-gost/x/metrics/wrapper"
+	stats "github.com/go-gost/x/observer/stats/wrapper"
+	"github.com/go-gost/x/registry"
+)
+
+func init() {
+	registry.ListenerRegistry().Register("vhost", NewListener)
+}
+
+// vhostListener is one service's view of a shared socket: it only receives
+// the connections whose TLS SNI or HTTP Host matches its hosts. Closing it
+// also closes the connections it handed out, since the shared socket stays
+// open for the other services.
+type vhostListener struct {
+	ln      net.Listener
+	mux     *mux
+	connCh  chan net.Conn
+	closed  chan struct{}
+	once    sync.Once
+	connsMu sync.Mutex
+	conns   map[*trackedConn]struct{}
+	logger  logger.Logger
+	md      metadata
+	options listener.Options
+}
+
+func NewListener(opts ...listener.Option) listener.Listener {
+	options := listener.Options{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return &vhostListener{
+		logger:  options.Logger,
+		options: options,
+	}
+}
+
+func (l *vhostListener) Init(md md.Metadata) (err error) {
+	if err = l.parseMetadata(md); err != nil {
+		return
+	}
+
+	l.connCh = make(chan net.Conn, l.md.backlog)
+	l.closed = make(chan struct{})
+	l.conns = make(map[*trackedConn]struct{})
+
+	m, err := bind(l.options.Addr, l.md.hosts, l)
+	if err != nil {
+		return
+	}
+	l.mux = m
+
+	var ln net.Listener = &acceptor{l: l}
+	ln = metrics.WrapListener(l.options.Service, ln)
+	ln = stats.WrapListener(ln, l.options.Stats)
+	ln = admission.WrapListener(l.options.Admission, ln)
+	ln = limiter_wrapper.WrapListener(l.options.Service, ln, l.options.TrafficLimiter)
+	ln = climiter.WrapListener(l.options.ConnLimiter, ln)
+	if l.md.tls {
+		// Routing has already used the SNI, so the service terminates TLS
+		// itself with its own certificate.
+		ln = tls.NewListener(ln, l.options.TLSConfig)
+	}
+	l.ln = ln
+
+	return
+}
+
+func (l *vhostListener) Accept() (conn net.Conn, err error) {
+	conn, err = l.ln.Accept()
+	if err != nil {
+		return
+	}
+
+	conn = limiter_wrapper.WrapConn(
+		conn,
+		l.options.TrafficLimiter,
+		conn.RemoteAddr().String(),
+		limiter.ScopeOption(limiter.ScopeConn),
+		limiter.ServiceOption(l.options.Service),
+		limiter.NetworkOption(conn.LocalAddr().Network()),
+		limiter.SrcOption(conn.RemoteAddr().String()),
+	)
+
+	return
+}
+
+func (l *vhostListener) Addr() net.Addr {
+	if l.mux == nil {
+		return nil
+	}
+	return l.mux.ln.Addr()
+}
+
+func (l *vhostListener) Close() error {
+	l.once.Do(func() {
+		close(l.closed)
+		if l.mux != nil {
+			l.mux.unbind(l)
+		}
+
+		l.connsMu.Lock()
+		for c := range l.conns {
+			c.Conn.Close()
+		}
+		l.conns = nil
+		l.connsMu.Unlock()
+
+		for {
+			select {
+			case c := <-l.connCh:
+				c.Close()
+			default:
+				return
+			}
+		}
+	})
+	return nil
+}
+
+// deliver queues a routed connection; it is dropped when the service is
+// closed or does not keep up.
+func (l *vhostListener) deliver(conn net.Conn) {
+	select {
+	case <-l.closed:
+		conn.Close()
+		return
+	default:
+	}
+
+	select {
+	case l.connCh <- conn:
+	default:
+		l.logger.Warnf("vhost %s: backlog full, dropping %s", strings.Join(l.md.hosts, ","), conn.RemoteAddr())
+		conn.Close()
+	}
+}
+
+func (l *vhostListener) track(c *trackedConn) bool {
+	l.connsMu.Lock()
+	defer l.connsMu.Unlock()
+	if l.conns == nil {
+		return false
+	}
+	l.conns[c] = struct{}{}
+	return true
+}
+
+func (l *vhostListener) untrack(c *trackedConn) {
+	l.connsMu.Lock()
+	defer l.connsMu.Unlock()
+	if l.conns != nil {
+		delete(l.conns, c)
+	}
+}
+
+// acceptor adapts the routed connection queue to net.Listener so the usual
+// listener wrappers apply.
+type acceptor struct {
+	l *vhostListener
+}
+
+func (a *acceptor) Accept() (net.Conn, error) {
+	select {
+	case conn := <-a.l.connCh:
+		c := &trackedConn{Conn: conn, l: a.l}
+		if !a.l.track(c) {
+			conn.Close()
+			return nil, net.ErrClosed
+		}
+		return c, nil
+	case <-a.l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (a *acceptor) Addr() net.Addr {
+	return a.l.Addr()
+}
+
+func (a *acceptor) Close() error {
+	return a.l.Close()
+}
+
+type trackedConn struct {
+	net.Conn
+	l    *vhostListener
+	once sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(func() { c.l.untrack(c) })
+	return c.Conn.Close()
+}
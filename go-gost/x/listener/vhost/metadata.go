@@ -0,0 +1,47 @@
+package vhost
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	md "github.com/go-gost/core/metadata"
+	mdutil "github.com/go-gost/x/metadata/util"
+)
+
+const (
+	defaultBacklog         = 128
+	defaultSniffingTimeout = 10 * time.Second
+)
+
+type metadata struct {
+	hosts           []string
+	backlog         int
+	sniffingTimeout time.Duration
+	tls             bool
+}
+
+func (l *vhostListener) parseMetadata(md md.Metadata) (err error) {
+	for _, host := range mdutil.GetStrings(md, "hosts", "host") {
+		for _, h := range strings.Split(host, ",") {
+			if h = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(h)), "."); h != "" {
+				l.md.hosts = append(l.md.hosts, h)
+			}
+		}
+	}
+	if len(l.md.hosts) == 0 {
+		return errors.New("vhost: at least one host is required")
+	}
+
+	l.md.backlog = mdutil.GetInt(md, "backlog")
+	if l.md.backlog <= 0 {
+		l.md.backlog = defaultBacklog
+	}
+	l.md.sniffingTimeout = mdutil.GetDuration(md, "sniffing.timeout")
+	if l.md.sniffingTimeout <= 0 {
+		l.md.sniffingTimeout = defaultSniffingTimeout
+	}
+	l.md.tls = mdutil.GetBool(md, "tls")
+
+	return
+}
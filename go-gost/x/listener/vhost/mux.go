@@ -0,0 +1,209 @@
+package vhost
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-gost/core/logger"
+	dissector "github.com/go-gost/tls-dissector"
+	xnet "github.com/go-gost/x/internal/net"
+	"github.com/go-gost/x/internal/util/sniffing"
+)
+
+var (
+	muxesMu sync.Mutex
+	muxes   = make(map[string]*mux)
+)
+
+// mux owns the physical socket of one address and routes every accepted
+// connection by TLS SNI or HTTP Host to the vhost listener bound to it.
+type mux struct {
+	addr    string
+	ln      net.Listener
+	timeout time.Duration
+	logger  logger.Logger
+
+	mu     sync.RWMutex
+	routes map[string]*vhostListener
+}
+
+// bind attaches l to the shared socket of addr for the given hosts, opening
+// the socket on first use.
+func bind(addr string, hosts []string, l *vhostListener) (*mux, error) {
+	if isPort80(addr) {
+		stopChallengeServer()
+	}
+
+	muxesMu.Lock()
+	defer muxesMu.Unlock()
+
+	m := muxes[addr]
+	if m == nil {
+		network := "tcp"
+		if xnet.IsIPv4(addr) {
+			network = "tcp4"
+		}
+		ln, err := net.Listen(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		m = &mux{
+			addr:    addr,
+			ln:      ln,
+			timeout: l.md.sniffingTimeout,
+			logger:  l.logger,
+			routes:  make(map[string]*vhostListener),
+		}
+		muxes[addr] = m
+		go m.serve()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, host := range hosts {
+		if owner, ok := m.routes[host]; ok && owner != l {
+			return nil, fmt.Errorf("host %s is already bound on %s by %s", host, addr, owner.options.Service)
+		}
+	}
+	for _, host := range hosts {
+		m.routes[host] = l
+	}
+	return m, nil
+}
+
+// unbind detaches l and closes the socket once no listener is left.
+func (m *mux) unbind(l *vhostListener) {
+	muxesMu.Lock()
+	defer muxesMu.Unlock()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for host, owner := range m.routes {
+		if owner == l {
+			delete(m.routes, host)
+		}
+	}
+	if len(m.routes) == 0 {
+		m.closeLocked()
+	}
+}
+
+func (m *mux) closeLocked() {
+	if muxes[m.addr] == m {
+		delete(muxes, m.addr)
+	}
+	m.ln.Close()
+}
+
+func (m *mux) serve() {
+	for {
+		conn, err := m.ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			m.logger.Warnf("vhost %s accept: %v", m.addr, err)
+			time.Sleep(50 * time.Millisecond)
+			continue
+		}
+		go m.dispatch(conn)
+	}
+}
+
+func (m *mux) dispatch(conn net.Conn) {
+	if m.timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(m.timeout))
+	}
+	host, path, c, err := sniffHost(conn)
+	if m.timeout > 0 {
+		conn.SetReadDeadline(time.Time{})
+	}
+	if err != nil {
+		m.logger.Debugf("vhost %s: %s: %v", m.addr, conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+
+	if keyAuth, ok := lookupChallenge(path); ok {
+		answerChallenge(c, keyAuth)
+		return
+	}
+
+	l := m.lookup(host)
+	if l == nil {
+		m.logger.Debugf("vhost %s: no route for host %q from %s", m.addr, host, conn.RemoteAddr())
+		conn.Close()
+		return
+	}
+	l.deliver(c)
+}
+
+// lookup matches the exact host first, then wildcard entries from the most
+// to the least specific ("*.b.example.com" before "*.example.com").
+func (m *mux) lookup(host string) *vhostListener {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if l, ok := m.routes[host]; ok {
+		return l
+	}
+	for rest := host; ; {
+		i := strings.IndexByte(rest, '.')
+		if i < 0 {
+			return nil
+		}
+		rest = rest[i+1:]
+		if l, ok := m.routes["*."+rest]; ok {
+			return l
+		}
+	}
+}
+
+// sniffHost reads the TLS ClientHello or HTTP request header from conn and
+// returns the requested host, the HTTP request path if any, and a conn that
+// replays what was read.
+func sniffHost(conn net.Conn) (host string, path string, c net.Conn, err error) {
+	br := bufio.NewReader(conn)
+	proto, err := sniffing.Sniff(context.Background(), br)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	switch proto {
+	case sniffing.ProtoTLS:
+		hello, err := dissector.ParseClientHello(io.TeeReader(br, buf))
+		if err != nil {
+			return "", "", nil, err
+		}
+		host = hello.ServerName
+	case sniffing.ProtoHTTP:
+		req, err := http.ReadRequest(bufio.NewReader(io.TeeReader(br, buf)))
+		if err != nil {
+			return "", "", nil, err
+		}
+		host = req.Host
+		path = req.URL.Path
+	default:
+		return "", "", nil, errors.New("unsupported protocol")
+	}
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" {
+		return "", "", nil, errors.New("missing host")
+	}
+
+	return host, path, xnet.NewReadWriteConn(io.MultiReader(buf, br), conn, conn), nil
+}
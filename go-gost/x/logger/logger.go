@@ -83,6 +83,7 @@ func NewLogger(opts ...Option) logger.Logger {
 	default:
 		log.SetLevel(logrus.InfoLevel)
 	}
+	log.AddHook(tapHook{})
 
 	l := &logrusLogger{
 		logger: logrus.NewEntry(log),
@@ -164,25 +165,51 @@ func (l *logrusLogger) Fatalf(format string, args ...any) {
 }
 
 func (l *logrusLogger) GetLevel() logger.LogLevel {
-	return logger.LogLevel(l.logger.Logger.GetLevel().String())
+	return logger.LogLevel(l.level().String())
 }
 
 func (l *logrusLogger) IsLevelEnabled(level logger.LogLevel) bool {
 	lvl, _ := logrus.ParseLevel(string(level))
-	return l.logger.Logger.IsLevelEnabled(lvl)
+	return l.level() >= lvl
+}
+
+// level returns the effective level, taking a raised service level into account.
+func (l *logrusLogger) level() logrus.Level {
+	lvl := l.logger.Logger.GetLevel()
+	if v, ok := overrideLevel(l.logger); ok && v > lvl {
+		return v
+	}
+	return lvl
+}
+
+// entry returns the entry to log with at the given level, or nil if the level is disabled.
+func (l *logrusLogger) entry(level logrus.Level) *logrus.Entry {
+	if l.logger.Logger.IsLevelEnabled(level) {
+		return l.logger
+	}
+	if v, ok := overrideLevel(l.logger); ok && v >= level {
+		return logrus.NewEntry(verboseLogger(l.logger.Logger)).WithFields(l.logger.Data)
+	}
+	return nil
 }
 
 func (l *logrusLogger) log(level logrus.Level, args ...any) {
-	lg := l.logger
-	if l.logger.Logger.IsLevelEnabled(logrus.DebugLevel) {
+	lg := l.entry(level)
+	if lg == nil {
+		return
+	}
+	if l.level() >= logrus.DebugLevel {
 		lg = lg.WithField("caller", l.caller(3))
 	}
 	lg.Log(level, args...)
 }
 
 func (l *logrusLogger) logf(level logrus.Level, format string, args ...any) {
-	lg := l.logger
-	if l.logger.Logger.IsLevelEnabled(logrus.DebugLevel) {
+	lg := l.entry(level)
+	if lg == nil {
+		return
+	}
+	if l.level() >= logrus.DebugLevel {
 		lg = lg.WithField("caller", l.caller(3))
 	}
 	lg.Logf(level, format, args...)
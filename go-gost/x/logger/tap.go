@@ -0,0 +1,135 @@
+package logger
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-gost/core/logger"
+	"github.com/sirupsen/logrus"
+)
+
+// Entry is a log record passed to the tap.
+type Entry struct {
+	Time    time.Time
+	Level   logger.LogLevel
+	Message string
+	Fields  map[string]any
+}
+
+var tap atomic.Pointer[func(Entry)]
+
+// SetTap registers fn to receive every record written by the loggers created
+// with NewLogger, in addition to their normal output. A nil fn removes the tap.
+func SetTap(fn func(Entry)) {
+	if fn == nil {
+		tap.Store(nil)
+		return
+	}
+	tap.Store(&fn)
+}
+
+type tapHook struct{}
+
+func (tapHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (tapHook) Fire(e *logrus.Entry) error {
+	fn := tap.Load()
+	if fn == nil {
+		return nil
+	}
+	fields := make(map[string]any, len(e.Data))
+	for k, v := range e.Data {
+		fields[k] = v
+	}
+	(*fn)(Entry{
+		Time:    e.Time,
+		Level:   logger.LogLevel(e.Level.String()),
+		Message: e.Message,
+		Fields:  fields,
+	})
+	return nil
+}
+
+type serviceLevel struct {
+	level logrus.Level
+	until time.Time
+}
+
+var serviceLevels = struct {
+	sync.RWMutex
+	m map[string]serviceLevel
+	n atomic.Int32
+}{m: make(map[string]serviceLevel)}
+
+// SetServiceLevel temporarily raises the level of the loggers of a service
+// (loggers carrying the "service" field) until the given time. An empty
+// level removes the override.
+func SetServiceLevel(service string, level logger.LogLevel, until time.Time) error {
+	serviceLevels.Lock()
+	defer serviceLevels.Unlock()
+
+	if level == "" {
+		delete(serviceLevels.m, service)
+		serviceLevels.n.Store(int32(len(serviceLevels.m)))
+		return nil
+	}
+	lvl, err := logrus.ParseLevel(string(level))
+	if err != nil {
+		return err
+	}
+	serviceLevels.m[service] = serviceLevel{level: lvl, until: until}
+	serviceLevels.n.Store(int32(len(serviceLevels.m)))
+	return nil
+}
+
+// ServiceLevels returns the active overrides and when they expire.
+func ServiceLevels() map[string]time.Time {
+	now := time.Now()
+	m := make(map[string]time.Time)
+	serviceLevels.RLock()
+	for name, v := range serviceLevels.m {
+		if v.until.After(now) {
+			m[name] = v.until
+		}
+	}
+	serviceLevels.RUnlock()
+	return m
+}
+
+// overrideLevel returns the raised level for the service of the entry, if any.
+func overrideLevel(e *logrus.Entry) (logrus.Level, bool) {
+	if serviceLevels.n.Load() == 0 {
+		return 0, false
+	}
+	name, _ := e.Data["service"].(string)
+	if name == "" {
+		return 0, false
+	}
+	serviceLevels.RLock()
+	v, ok := serviceLevels.m[name]
+	serviceLevels.RUnlock()
+	if !ok || time.Now().After(v.until) {
+		return 0, false
+	}
+	return v.level, true
+}
+
+var verboseLoggers sync.Map // *logrus.Logger -> *logrus.Logger
+
+// verboseLogger returns a copy of l that shares its output, formatter and
+// hooks and logs at every level.
+func verboseLogger(l *logrus.Logger) *logrus.Logger {
+	if v, ok := verboseLoggers.Load(l); ok {
+		return v.(*logrus.Logger)
+	}
+	vl := logrus.New()
+	vl.SetOutput(l.Out)
+	vl.SetFormatter(l.Formatter)
+	vl.ReplaceHooks(l.Hooks)
+	vl.SetLevel(logrus.TraceLevel)
+	v, _ := verboseLoggers.LoadOrStore(l, vl)
+	return v.(*logrus.Logger)
+}
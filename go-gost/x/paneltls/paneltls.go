@@ -0,0 +1,136 @@
+// Package paneltls 管理节点到面板的 mTLS 连接：节点证书由面板内部 CA 签发，
+// 面板证书通过公钥固定校验。未配置时节点继续使用明文通道加密钥认证。
+package paneltls
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	mu        sync.RWMutex
+	panelAddr string
+	clientTLS *tls.Config
+	notAfter  time.Time // 节点证书到期时间
+)
+
+// Configure 加载节点证书并固定面板证书公钥，此后节点到面板的连接改走 addr 上的 mTLS 监听
+func Configure(addr, certFile, keyFile, pin string) error {
+	if addr == "" || pin == "" {
+		return errors.New("mTLS 地址或面板公钥指纹为空")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("加载节点证书失败: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("解析节点证书失败: %v", err)
+	}
+	if time.Now().After(leaf.NotAfter) {
+		return errors.New("节点证书已过期")
+	}
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		// 面板证书由内部 CA 签发，不校验证书链和主机名，改为校验公钥指纹
+		InsecureSkipVerify:    true,
+		VerifyPeerCertificate: VerifyPin(pin),
+	}
+	mu.Lock()
+	panelAddr = addr
+	clientTLS = cfg
+	notAfter = leaf.NotAfter
+	mu.Unlock()
+	return nil
+}
+
+// Disable 停用 mTLS，此后节点到面板的连接退回明文通道加密钥认证
+func Disable() {
+	mu.Lock()
+	clientTLS = nil
+	mu.Unlock()
+}
+
+// Expired 返回已启用的节点证书是否已过期，过期证书无法通过面板的握手校验
+func Expired() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return clientTLS != nil && time.Now().After(notAfter)
+}
+
+// Enabled 返回是否已启用 mTLS
+func Enabled() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return clientTLS != nil
+}
+
+// ClientConfig 返回连接面板使用的 TLS 配置，未启用时为 nil
+func ClientConfig() *tls.Config {
+	mu.RLock()
+	defer mu.RUnlock()
+	if clientTLS == nil {
+		return nil
+	}
+	return clientTLS.Clone()
+}
+
+// HTTPBase 返回面板 HTTP 接口的地址前缀，plainAddr 为明文通道地址
+func HTTPBase(plainAddr string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	if clientTLS != nil {
+		return "https://" + panelAddr
+	}
+	return "http://" + plainAddr
+}
+
+// WSBase 返回面板 WebSocket 的地址前缀
+func WSBase(plainAddr string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	if clientTLS != nil {
+		return "wss://" + panelAddr
+	}
+	return "ws://" + plainAddr
+}
+
+// HTTPClient 返回访问面板使用的 HTTP 客户端
+func HTTPClient(timeout time.Duration) *http.Client {
+	client := &http.Client{Timeout: timeout}
+	if cfg := ClientConfig(); cfg != nil {
+		client.Transport = &http.Transport{TLSClientConfig: cfg, Proxy: http.ProxyFromEnvironment}
+	}
+	return client
+}
+
+// Pin 计算证书公钥指纹（SubjectPublicKeyInfo 的 SHA-256）
+func Pin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "sha256/" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// VerifyPin 返回校验面板证书公钥指纹的回调
+func VerifyPin(pin string) func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("面板未提供证书")
+		}
+		leaf, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return err
+		}
+		if got := Pin(leaf); got != pin {
+			return fmt.Errorf("面板证书公钥指纹不匹配: %s", got)
+		}
+		return nil
+	}
+}
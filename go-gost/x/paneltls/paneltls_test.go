@@ -0,0 +1,75 @@
+package paneltls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestCert(t *testing.T, notAfter time.Time) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "node-1"},
+		NotBefore:    notAfter.Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestConfigureAndDisable(t *testing.T) {
+	t.Cleanup(Disable)
+
+	certFile, keyFile := writeTestCert(t, time.Now().Add(time.Hour))
+	if err := Configure("panel:6367", certFile, keyFile, "sha256/x"); err != nil {
+		t.Fatalf("configure: %v", err)
+	}
+	if !Enabled() || Expired() || WSBase("panel:6365") != "wss://panel:6367" {
+		t.Fatalf("expected mTLS to be enabled with a valid certificate")
+	}
+
+	Disable()
+	if Enabled() || Expired() || WSBase("panel:6365") != "ws://panel:6365" || ClientConfig() != nil {
+		t.Fatalf("expected the plain channel after disabling mTLS")
+	}
+}
+
+func TestConfigureRejectsExpiredCertificate(t *testing.T) {
+	t.Cleanup(Disable)
+
+	certFile, keyFile := writeTestCert(t, time.Now().Add(-time.Minute))
+	if err := Configure("panel:6367", certFile, keyFile, "sha256/x"); err == nil {
+		t.Fatalf("expected an expired certificate to be rejected")
+	}
+	if Enabled() {
+		t.Fatalf("expected mTLS to stay disabled")
+	}
+}
@@ -7,3 +7,14 @@ import (
 type serviceRegistry struct {
 	registry[service.Service]
 }
+
+// ReplaceService registers svc under name and returns the previous service,
+// if any. Unlike Unregister, the previous service is not closed.
+func ReplaceService(name string, svc service.Service) service.Service {
+	if name == "" {
+		return nil
+	}
+	v, _ := serviceReg.(*serviceRegistry).m.Swap(name, svc)
+	old, _ := v.(service.Service)
+	return old
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConnInfo 活动连接快照
+type ConnInfo struct {
+	ID        string `json:"id"`
+	Service   string `json:"service"`
+	Network   string `json:"network"`
+	Client    string `json:"client"`
+	Local     string `json:"local"`
+	Target    string `json:"target,omitempty"`
+	StartTime int64  `json:"startTime"` // 建立时间(毫秒时间戳)
+}
+
+// trackedConn 连接表中的一条记录。只登记原连接而不包装它，
+// 处理器仍能拿到监听器返回的具体连接类型（Metadatable、syscall.Conn、PacketConn 等），
+// 转发时的零拷贝也不受影响；流量按服务统计，不再细分到单个连接
+type trackedConn struct {
+	net.Conn
+	owner   *defaultService
+	id      string
+	service string
+	client  string
+	start   time.Time
+	target  atomic.Value // string
+}
+
+func (c *trackedConn) setTarget(network, addr string) {
+	if network != "" && network != "tcp" && network != "udp" {
+		addr = network + "://" + addr
+	}
+	c.target.Store(addr)
+}
+
+func (c *trackedConn) info() ConnInfo {
+	target, _ := c.target.Load().(string)
+	info := ConnInfo{
+		ID:        c.id,
+		Service:   c.service,
+		Client:    c.client,
+		Target:    target,
+		StartTime: c.start.UnixMilli(),
+	}
+	if addr := c.Conn.LocalAddr(); addr != nil {
+		info.Network = addr.Network()
+		info.Local = addr.String()
+	}
+	return info
+}
+
+// connTable 全部服务的活动连接，key 为连接 ID（与日志中的 sid 相同）
+var connTable = struct {
+	sync.RWMutex
+	m map[string]*trackedConn
+}{m: make(map[string]*trackedConn)}
+
+// trackConn 登记一个新接入的连接，连接处理结束后需调用 untrackConn
+func trackConn(owner *defaultService, id, client string, conn net.Conn) *trackedConn {
+	tc := &trackedConn{
+		Conn:    conn,
+		owner:   owner,
+		id:      id,
+		service: owner.name,
+		client:  client,
+		start:   time.Now(),
+	}
+	connTable.Lock()
+	connTable.m[id] = tc
+	connTable.Unlock()
+	return tc
+}
+
+func untrackConn(id string) {
+	connTable.Lock()
+	delete(connTable.m, id)
+	connTable.Unlock()
+}
+
+// ListConnections 返回服务名满足 match 的活动连接（match 为 nil 时返回全部），按建立时间排序
+func ListConnections(match func(service string) bool) []ConnInfo {
+	connTable.RLock()
+	list := make([]ConnInfo, 0, len(connTable.m))
+	for _, c := range connTable.m {
+		if match != nil && !match(c.service) {
+			continue
+		}
+		list = append(list, c.info())
+	}
+	connTable.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].StartTime != list[j].StartTime {
+			return list[i].StartTime < list[j].StartTime
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// CloseConnections 关闭服务名满足 match 的连接。ids 非空时只关闭其中的连接，
+// 否则关闭匹配服务的全部连接，返回关闭的数量
+func CloseConnections(match func(service string) bool, ids ...string) int {
+	if match == nil {
+		return 0
+	}
+	var targets []*trackedConn
+	connTable.RLock()
+	if len(ids) == 0 {
+		for _, c := range connTable.m {
+			if match(c.service) {
+				targets = append(targets, c)
+			}
+		}
+	} else {
+		for _, id := range ids {
+			if c, ok := connTable.m[id]; ok && match(c.service) {
+				targets = append(targets, c)
+			}
+		}
+	}
+	connTable.RUnlock()
+
+	// 关闭后由连接处理协程自行从连接表中移除
+	for _, c := range targets {
+		c.Conn.Close()
+	}
+	return len(targets)
+}
+
+// CloseServiceConnections 关闭指定服务的全部连接，用于暂停服务
+func CloseServiceConnections(name string) int {
+	return CloseConnections(func(service string) bool { return service == name })
+}
+
+// countOwnedConns 统计属于某个服务实例的连接数。服务替换期间新旧实例同名，需按实例区分
+func countOwnedConns(owner *defaultService) int {
+	n := 0
+	connTable.RLock()
+	for _, c := range connTable.m {
+		if c.owner == owner {
+			n++
+		}
+	}
+	connTable.RUnlock()
+	return n
+}
+
+func closeOwnedConns(owner *defaultService) int {
+	var targets []*trackedConn
+	connTable.RLock()
+	for _, c := range connTable.m {
+		if c.owner == owner {
+			targets = append(targets, c)
+		}
+	}
+	connTable.RUnlock()
+
+	for _, c := range targets {
+		c.Conn.Close()
+	}
+	return len(targets)
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"sync"
 	"time"
+
+	"github.com/go-gost/x/admission/geoip"
 )
 
 // GlobalTrafficManager 全局流量管理器（所有服务共享）
@@ -14,6 +16,7 @@ type GlobalTrafficManager struct {
 	ctx           context.Context
 	cancel        context.CancelFunc
 	reportTicker  *time.Ticker
+	journal       *trafficJournal // 未确认上报的磁盘日志
 }
 
 // ServiceTraffic 单个服务的流量累积
@@ -38,6 +41,7 @@ func GetGlobalTrafficManager() *GlobalTrafficManager {
 			ctx:            ctx,
 			cancel:         cancel,
 			reportTicker:   time.NewTicker(5 * time.Second),
+			journal:        newTrafficJournal(trafficJournalFile),
 		}
 		// 启动定时上报协程
 		go globalManager.startReporting()
@@ -87,10 +91,20 @@ func (m *GlobalTrafficManager) startReporting() {
 
 // collectAndReport 收集所有服务流量并合并上报
 func (m *GlobalTrafficManager) collectAndReport() {
+	// 先按序补发日志中积压的上报，积压未清空时新的上报排在其后
+	backlog := m.journal.Flush(func(seq int64, items []TrafficReportItem) error {
+		_, err := reportTraffic(m.ctx, seq, items)
+		return err
+	})
+
+	// 准入规则拒绝计数和协议拦截计数随流量一起上报
+	rejected := geoip.RejectedSnapshot()
+	blocked := ProtocolBlockedSnapshot()
+
 	m.mu.Lock()
 	
 	// 如果没有流量，直接返回
-	if len(m.serviceTraffic) == 0 {
+	if len(m.serviceTraffic) == 0 && len(rejected) == 0 && len(blocked) == 0 {
 		m.mu.Unlock()
 		return
 	}
@@ -119,12 +133,12 @@ func (m *GlobalTrafficManager) collectAndReport() {
 	m.mu.Unlock()
 
 	// 如果没有需要上报的流量，返回
-	if len(reportData) == 0 {
+	if len(reportData) == 0 && len(rejected) == 0 && len(blocked) == 0 {
 		return
 	}
 
 	// 构建上报数据数组（保持每个服务独立）
-	reportItems := make([]TrafficReportItem, 0, len(reportData))
+	reportItems := make([]TrafficReportItem, 0, len(reportData)+len(rejected)+len(blocked))
 	var totalUp, totalDown int64
 	
 	for serviceName, data := range reportData {
@@ -137,20 +151,39 @@ func (m *GlobalTrafficManager) collectAndReport() {
 		totalDown += data.down
 	}
 
-	// 批量发送上报请求（一次HTTP请求包含所有服务）
-	success, err := sendBatchTrafficReport(m.ctx, reportItems)
-	if err != nil {
-		fmt.Printf("❌ 全局流量上报失败: %v (总流量: ↑%d ↓%d, %d个服务)\n", err, totalUp, totalDown, len(reportItems))
-		return
+	for admissionName, n := range rejected {
+		reportItems = append(reportItems, TrafficReportItem{N: admissionName, R: n})
+	}
+	for serviceName, n := range blocked {
+		reportItems = append(reportItems, TrafficReportItem{N: serviceName + protocolBlockedSuffix, R: n})
 	}
 
-	if !success {
-		fmt.Printf("⚠️ 全局流量上报未成功 (总流量: ↑%d ↓%d, %d个服务)\n", totalUp, totalDown, len(reportItems))
+	if backlog > 0 {
+		// 面板仍不可达，合并进日志等待补发
+		m.journal.Append(0, reportItems)
+		m.clearReportedTraffic(reportData)
+		geoip.SubtractRejected(rejected)
+		SubtractProtocolBlocked(blocked)
 		return
 	}
 
-	// 上报成功，清空已上报的流量
+	// 批量发送上报请求（一次HTTP请求包含所有服务）
+	seq := m.journal.NextSeq()
+	success, err := reportTraffic(m.ctx, seq, reportItems)
+	if err != nil || !success {
+		if err != nil {
+			fmt.Printf("❌ 全局流量上报失败: %v (总流量: ↑%d ↓%d, %d个服务)\n", err, totalUp, totalDown, len(reportItems))
+		} else {
+			fmt.Printf("⚠️ 全局流量上报未成功 (总流量: ↑%d ↓%d, %d个服务)\n", totalUp, totalDown, len(reportItems))
+		}
+		// 面板可能已处理该上报，保留原序号写入日志，由面板按序号去重
+		m.journal.Append(seq, reportItems)
+	}
+
+	// 上报成功或已写入日志，清空已上报的流量
 	m.clearReportedTraffic(reportData)
+	geoip.SubtractRejected(rejected)
+	SubtractProtocolBlocked(blocked)
 }
 
 // clearReportedTraffic 清空已成功上报的流量
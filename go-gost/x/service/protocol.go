@@ -0,0 +1,431 @@
+package service
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-gost/core/logger"
+)
+
+// 可识别的协议。unknown 表示首包无法识别的流量，白名单模式下需显式放行
+const (
+	ProtocolHTTP       = "http"
+	ProtocolTLS        = "tls"
+	ProtocolSOCKS      = "socks"
+	ProtocolSSH        = "ssh"
+	ProtocolBitTorrent = "bittorrent"
+	ProtocolWireGuard  = "wireguard"
+	ProtocolOpenVPN    = "openvpn"
+	ProtocolQUIC       = "quic"
+	ProtocolUnknown    = "unknown"
+)
+
+type protocolSet uint16
+
+const (
+	protoHTTP protocolSet = 1 << iota
+	protoTLS
+	protoSOCKS
+	protoSSH
+	protoBitTorrent
+	protoWireGuard
+	protoOpenVPN
+	protoQUIC
+	protoUnknown
+)
+
+var protocolNames = map[string]protocolSet{
+	ProtocolHTTP:       protoHTTP,
+	ProtocolTLS:        protoTLS,
+	ProtocolSOCKS:      protoSOCKS,
+	ProtocolSSH:        protoSSH,
+	ProtocolBitTorrent: protoBitTorrent,
+	ProtocolWireGuard:  protoWireGuard,
+	ProtocolOpenVPN:    protoOpenVPN,
+	ProtocolQUIC:       protoQUIC,
+	ProtocolUnknown:    protoUnknown,
+}
+
+// protocolDetectors 按顺序匹配首包，tcp/udp 为空表示该协议不走对应传输层
+var protocolDetectors = []struct {
+	proto protocolSet
+	tcp   func([]byte) bool
+	udp   func([]byte) bool
+}{
+	{proto: protoTLS, tcp: detectTLS},
+	{proto: protoHTTP, tcp: detectHTTP},
+	{proto: protoSSH, tcp: detectSSH},
+	{proto: protoBitTorrent, tcp: detectBitTorrent, udp: detectBitTorrentUDP},
+	{proto: protoOpenVPN, tcp: detectOpenVPN, udp: detectOpenVPNUDP},
+	{proto: protoSOCKS, tcp: detectSOCKS},
+	{proto: protoWireGuard, udp: detectWireGuard},
+	{proto: protoQUIC, udp: detectQUIC},
+}
+
+func (p protocolSet) String() string {
+	names := make([]string, 0, 1)
+	for name, v := range protocolNames {
+		if p&v != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// identifyProtocol 按首包识别协议，无法识别时返回 protoUnknown
+func identifyProtocol(data []byte, udp bool) protocolSet {
+	for _, d := range protocolDetectors {
+		detect := d.tcp
+		if udp {
+			detect = d.udp
+		}
+		if detect != nil && detect(data) {
+			return d.proto
+		}
+	}
+	return protoUnknown
+}
+
+// ProtocolPolicy 服务级协议拦截规则（面板已合并节点、隧道、转发三级配置）：
+// Deny 中的协议被拦截；Whitelist 为 true 时只放行 Allow 中的协议
+type ProtocolPolicy struct {
+	Whitelist bool     `json:"whitelist"`
+	Allow     []string `json:"allow"`
+	Deny      []string `json:"deny"`
+}
+
+// Validate 检查协议名称
+func (p *ProtocolPolicy) Validate() error {
+	if p == nil {
+		return nil
+	}
+	for _, name := range append(append([]string{}, p.Allow...), p.Deny...) {
+		if _, ok := protocolNames[strings.ToLower(strings.TrimSpace(name))]; !ok {
+			return fmt.Errorf("未知协议: %s", name)
+		}
+	}
+	return nil
+}
+
+func (p *ProtocolPolicy) rule() *protocolRule {
+	if p == nil {
+		return nil
+	}
+	r := &protocolRule{whitelist: p.Whitelist}
+	for _, name := range p.Allow {
+		r.allow |= protocolNames[strings.ToLower(strings.TrimSpace(name))]
+	}
+	for _, name := range p.Deny {
+		r.deny |= protocolNames[strings.ToLower(strings.TrimSpace(name))]
+	}
+	return r.orNil()
+}
+
+// protocolRule 编译后的拦截规则
+type protocolRule struct {
+	whitelist bool
+	allow     protocolSet
+	deny      protocolSet
+}
+
+func (r *protocolRule) orNil() *protocolRule {
+	if r == nil || (!r.whitelist && r.deny == 0) {
+		return nil
+	}
+	return r
+}
+
+// merge 合并节点开关与服务级规则：拒绝列表取并集，白名单取交集
+func (r *protocolRule) merge(o *protocolRule) *protocolRule {
+	if r == nil {
+		return o
+	}
+	if o == nil {
+		return r
+	}
+	m := &protocolRule{deny: r.deny | o.deny}
+	switch {
+	case r.whitelist && o.whitelist:
+		m.whitelist, m.allow = true, r.allow&o.allow
+	case r.whitelist:
+		m.whitelist, m.allow = true, r.allow
+	case o.whitelist:
+		m.whitelist, m.allow = true, o.allow
+	}
+	return m
+}
+
+func (r *protocolRule) blocks(proto protocolSet) bool {
+	if r.deny&proto != 0 {
+		return true
+	}
+	return r.whitelist && r.allow&proto == 0
+}
+
+// 节点级开关（旧版 http/tls/socks），作用于本节点全部服务
+var nodeProtocolRule atomic.Pointer[protocolRule]
+
+// SetProtocolBlock sets protocol blocking switches and recomputes wrapper need
+func SetProtocolBlock(httpOn int, tlsOn int, socksOn int) {
+	r := &protocolRule{}
+	if httpOn == 1 {
+		r.deny |= protoHTTP
+	}
+	if tlsOn == 1 {
+		r.deny |= protoTLS
+	}
+	if socksOn == 1 {
+		r.deny |= protoSOCKS
+	}
+	nodeProtocolRule.Store(r.orNil())
+}
+
+// ProtocolPolicySetter 支持运行中替换服务级协议规则，新规则对之后接入的连接生效
+type ProtocolPolicySetter interface {
+	SetProtocolPolicy(p *ProtocolPolicy)
+}
+
+func (s *defaultService) SetProtocolPolicy(p *ProtocolPolicy) {
+	s.protocol.Store(p.rule())
+}
+
+// protocolRule 返回对新连接生效的规则，没有任何限制时返回 nil
+func (s *defaultService) protocolRule() *protocolRule {
+	return nodeProtocolRule.Load().merge(s.protocol.Load()).orNil()
+}
+
+// 各服务被协议规则拦截的连接数，随流量一起上报为 {"n": "<服务名>_proto", "r": N}
+const protocolBlockedSuffix = "_proto"
+
+var protocolBlocked sync.Map // service -> *atomic.Int64
+
+func addProtocolBlocked(service string) {
+	v, _ := protocolBlocked.LoadOrStore(service, new(atomic.Int64))
+	v.(*atomic.Int64).Add(1)
+}
+
+// ProtocolBlockedSnapshot 返回各服务自上次成功上报以来被协议规则拦截的连接数
+func ProtocolBlockedSnapshot() map[string]int64 {
+	snapshot := make(map[string]int64)
+	protocolBlocked.Range(func(key, value any) bool {
+		if n := value.(*atomic.Int64).Load(); n > 0 {
+			snapshot[key.(string)] = n
+		}
+		return true
+	})
+	return snapshot
+}
+
+// SubtractProtocolBlocked 上报成功后扣除已上报的计数
+func SubtractProtocolBlocked(reported map[string]int64) {
+	for name, n := range reported {
+		if v, ok := protocolBlocked.Load(name); ok {
+			v.(*atomic.Int64).Add(-n)
+		}
+	}
+}
+
+var errProtocolBlocked = errors.New("connection blocked")
+
+func wrapProtocolDetection(conn net.Conn, service string, rule *protocolRule, log logger.Logger) net.Conn {
+	return &detectConn{
+		Conn:    conn,
+		service: service,
+		rule:    rule,
+		udp:     strings.HasPrefix(conn.LocalAddr().Network(), "udp"),
+		log:     log,
+	}
+}
+
+// detectConn 在首次读取时识别协议，UDP 按会话的第一个数据包识别
+type detectConn struct {
+	net.Conn
+	service  string
+	rule     *protocolRule
+	udp      bool
+	log      logger.Logger
+	detected bool
+}
+
+func (c *detectConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 && !c.detected {
+		c.detected = true
+		if proto := identifyProtocol(b[:n], c.udp); c.rule.blocks(proto) {
+			c.Conn.Close()
+			addProtocolBlocked(c.service)
+			c.log.Debugf("%s traffic from %s is blocked by protocol rules", proto, c.RemoteAddr())
+			return 0, errProtocolBlocked
+		}
+	}
+	return n, err
+}
+
+func detectHTTP(data []byte) bool {
+	if len(data) < 3 {
+		return false
+	}
+	switch {
+	case len(data) >= 3 && data[0] == 'G' && data[1] == 'E' && data[2] == 'T':
+		return true
+	case len(data) >= 4 && data[0] == 'P' && data[1] == 'O' && data[2] == 'S' && data[3] == 'T':
+		return true
+	case len(data) >= 3 && data[0] == 'P' && data[1] == 'U' && data[2] == 'T':
+		return true
+	case len(data) >= 6 && data[0] == 'D' && data[1] == 'E' && data[2] == 'L' &&
+		data[3] == 'E' && data[4] == 'T' && data[5] == 'E':
+		return true
+	case len(data) >= 4 && data[0] == 'H' && data[1] == 'E' && data[2] == 'A' && data[3] == 'D':
+		return true
+	case len(data) >= 7 && data[0] == 'O' && data[1] == 'P' && data[2] == 'T' &&
+		data[3] == 'I' && data[4] == 'O' && data[5] == 'N' && data[6] == 'S':
+		return true
+	case len(data) >= 5 && data[0] == 'P' && data[1] == 'A' && data[2] == 'T' &&
+		data[3] == 'C' && data[4] == 'H':
+		return true
+	case len(data) >= 7 && data[0] == 'C' && data[1] == 'O' && data[2] == 'N' &&
+		data[3] == 'N' && data[4] == 'E' && data[5] == 'C' && data[6] == 'T': // HTTPS proxy
+		return true
+	default:
+		return false
+	}
+}
+
+func detectTLS(data []byte) bool {
+	if len(data) < 5 {
+		return false
+	}
+	if data[0] == 0x16 && data[1] == 0x03 && data[2] >= 0x01 && data[2] <= 0x04 {
+		return true
+	}
+	return false
+}
+
+func detectSOCKS(data []byte) bool {
+	if len(data) == 0 {
+		return false
+	}
+
+	switch data[0] {
+	case 0x04:
+		if len(data) < 7 {
+			return false
+		}
+		cmd := data[1]
+		if cmd != 0x01 && cmd != 0x02 {
+			return false
+		}
+		return true
+	case 0x05:
+		if len(data) < 2 {
+			return false
+		}
+		nMethods := int(data[1])
+		if len(data) < 2+nMethods {
+			return false
+		}
+		for _, method := range data[2 : 2+nMethods] {
+			if method == 0x00 || method == 0x02 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// detectSSH 客户端连接后立即发送版本标识 "SSH-2.0-..."
+func detectSSH(data []byte) bool {
+	return len(data) >= 8 && string(data[:4]) == "SSH-" &&
+		(string(data[4:8]) == "2.0-" || string(data[4:8]) == "1.99" || string(data[4:8]) == "1.5-")
+}
+
+// detectBitTorrent 明文握手：长度 19 + "BitTorrent protocol"
+func detectBitTorrent(data []byte) bool {
+	return len(data) >= 20 && data[0] == 19 && string(data[1:20]) == "BitTorrent protocol"
+}
+
+// detectBitTorrentUDP 识别 DHT 请求、UDP tracker 连接请求和 uTP 的 SYN 包
+func detectBitTorrentUDP(data []byte) bool {
+	switch {
+	case len(data) >= 8 && strings.HasPrefix(string(data), "d1:") && strings.Contains(string(data), "1:y1:"):
+		return true
+	case len(data) >= 16 && binary.BigEndian.Uint64(data) == 0x41727101980 && binary.BigEndian.Uint32(data[8:]) == 0:
+		return true
+	case detectUTPSyn(data):
+		return true
+	}
+	return false
+}
+
+// detectUTPSyn uTP (BEP 29) 的 SYN 包：类型 4、版本 1，连接 ID 与时间戳非零，
+// 尚未收到对端数据所以时间差和 ack_nr 为 0，seq_nr 非零；SYN 不带负载，
+// 扩展头之后不能有多余字节
+func detectUTPSyn(data []byte) bool {
+	if len(data) < 20 || data[0] != 0x41 {
+		return false
+	}
+	if binary.BigEndian.Uint16(data[2:]) == 0 || binary.BigEndian.Uint32(data[4:]) == 0 || binary.BigEndian.Uint32(data[8:]) != 0 {
+		return false
+	}
+	if binary.BigEndian.Uint16(data[16:]) == 0 || binary.BigEndian.Uint16(data[18:]) != 0 {
+		return false
+	}
+	ext, off := data[1], 20
+	for ext != 0 {
+		if off+2 > len(data) {
+			return false
+		}
+		ext = data[off]
+		off += 2 + int(data[off+1])
+		if off > len(data) {
+			return false
+		}
+	}
+	return off == len(data)
+}
+
+// detectWireGuard 握手发起包：类型 1、三字节保留 0、固定 148 字节
+func detectWireGuard(data []byte) bool {
+	return len(data) == 148 && data[0] == 1 && data[1] == 0 && data[2] == 0 && data[3] == 0
+}
+
+// openVPNHardReset 判断首字节是否为客户端 HARD_RESET（V2/V3），key_id 必须为 0
+func openVPNHardReset(b byte) bool {
+	op := b >> 3
+	return (op == 7 || op == 10) && b&0x07 == 0
+}
+
+// detectOpenVPN TCP 模式下每个包带两字节长度前缀
+func detectOpenVPN(data []byte) bool {
+	if len(data) < 16 {
+		return false
+	}
+	plen := int(binary.BigEndian.Uint16(data))
+	return plen >= 14 && plen <= 1024 && plen <= len(data)-2 && openVPNHardReset(data[2])
+}
+
+func detectOpenVPNUDP(data []byte) bool {
+	return len(data) >= 14 && openVPNHardReset(data[0])
+}
+
+// detectQUIC 客户端 Initial 包：长包头、已知版本、至少填充到 1200 字节
+func detectQUIC(data []byte) bool {
+	if len(data) < 1200 || data[0]&0xc0 != 0xc0 {
+		return false
+	}
+	packetType := (data[0] >> 4) & 0x03
+	switch version := binary.BigEndian.Uint32(data[1:5]); {
+	case version == 0x00000001:
+		return packetType == 0
+	case version == 0x6b3343cf: // QUIC v2 的 Initial 类型为 1
+		return packetType == 1
+	case version>>8 == 0xff0000: // draft 版本
+		return packetType == 0
+	}
+	return false
+}
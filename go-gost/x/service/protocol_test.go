@@ -0,0 +1,122 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func utpPacket(ext byte, connID uint16, ts, tsDiff uint32, seq, ack uint16, tail ...byte) []byte {
+	b := make([]byte, 20, 20+len(tail))
+	b[0], b[1] = 0x41, ext
+	binary.BigEndian.PutUint16(b[2:], connID)
+	binary.BigEndian.PutUint32(b[4:], ts)
+	binary.BigEndian.PutUint32(b[8:], tsDiff)
+	binary.BigEndian.PutUint32(b[12:], 1<<20)
+	binary.BigEndian.PutUint16(b[16:], seq)
+	binary.BigEndian.PutUint16(b[18:], ack)
+	return append(b, tail...)
+}
+
+func quicPacket(first byte, version uint32, size int) []byte {
+	b := make([]byte, size)
+	b[0] = first
+	binary.BigEndian.PutUint32(b[1:], version)
+	return b
+}
+
+func withLen(size int, head ...byte) []byte {
+	b := make([]byte, size)
+	copy(b, head)
+	return b
+}
+
+func TestProtocolDetectors(t *testing.T) {
+	btHandshake := append([]byte("\x13BitTorrent protocol"), make([]byte, 48)...)
+	tracker := make([]byte, 16)
+	binary.BigEndian.PutUint64(tracker, 0x41727101980)
+	trackerAnnounce := append([]byte(nil), tracker...)
+	trackerAnnounce[11] = 1
+
+	cases := []struct {
+		name   string
+		detect func([]byte) bool
+		data   []byte
+		want   bool
+	}{
+		{"ssh 2.0", detectSSH, []byte("SSH-2.0-OpenSSH_9.6\r\n"), true},
+		{"ssh 1.99", detectSSH, []byte("SSH-1.99-Cisco\r\n"), true},
+		{"ssh unknown version", detectSSH, []byte("SSH-3.0-x\r\n"), false},
+		{"ssh short", detectSSH, []byte("SSH-2.0"), false},
+		{"ssh http", detectSSH, []byte("GET / HTTP/1.1\r\n"), false},
+
+		{"bt handshake", detectBitTorrent, btHandshake, true},
+		{"bt wrong magic", detectBitTorrent, []byte("\x13BitTorrent protocoX"), false},
+		{"bt short", detectBitTorrent, []byte("\x13BitTorrent"), false},
+
+		{"dht query", detectBitTorrentUDP, []byte("d1:ad2:id20:abcdefghij0123456789e1:q4:ping1:t2:aa1:y1:qe"), true},
+		{"dht without type", detectBitTorrentUDP, []byte("d1:ad2:id20:abcdefghij0123456789ee"), false},
+		{"tracker connect", detectBitTorrentUDP, tracker, true},
+		{"tracker announce", detectBitTorrentUDP, trackerAnnounce, false},
+		{"utp syn", detectBitTorrentUDP, utpPacket(0, 0x1234, 123456, 0, 1, 0), true},
+		{"utp syn with extension", detectBitTorrentUDP, utpPacket(2, 0x1234, 123456, 0, 7, 0, 0, 8, 0, 0, 0, 0, 0, 0, 0, 0), true},
+		{"utp zero connection id", detectBitTorrentUDP, utpPacket(0, 0, 123456, 0, 1, 0), false},
+		{"utp zero timestamp", detectBitTorrentUDP, utpPacket(0, 0x1234, 0, 0, 1, 0), false},
+		{"utp timestamp difference", detectBitTorrentUDP, utpPacket(0, 0x1234, 123456, 99, 1, 0), false},
+		{"utp zero seq", detectBitTorrentUDP, utpPacket(0, 0x1234, 123456, 0, 0, 0), false},
+		{"utp ack", detectBitTorrentUDP, utpPacket(0, 0x1234, 123456, 0, 1, 5), false},
+		{"utp payload", detectBitTorrentUDP, utpPacket(0, 0x1234, 123456, 0, 1, 0, 'x'), false},
+		{"utp truncated extension", detectBitTorrentUDP, utpPacket(2, 0x1234, 123456, 0, 1, 0, 0, 8, 0, 0), false},
+		{"utp random", detectBitTorrentUDP, append([]byte{0x41, 0x00}, bytes.Repeat([]byte{0xa5}, 30)...), false},
+
+		{"wireguard initiation", detectWireGuard, withLen(148, 1, 0, 0, 0), true},
+		{"wireguard wrong size", detectWireGuard, withLen(149, 1, 0, 0, 0), false},
+		{"wireguard response", detectWireGuard, withLen(148, 2, 0, 0, 0), false},
+		{"wireguard reserved", detectWireGuard, withLen(148, 1, 0, 1, 0), false},
+
+		{"openvpn tcp v2", detectOpenVPN, withLen(16, 0, 14, 7<<3), true},
+		{"openvpn tcp v3", detectOpenVPN, withLen(40, 0, 38, 10<<3), true},
+		{"openvpn tcp key id", detectOpenVPN, withLen(16, 0, 14, 7<<3|1), false},
+		{"openvpn tcp data", detectOpenVPN, withLen(16, 0, 14, 9<<3), false},
+		{"openvpn tcp length", detectOpenVPN, withLen(16, 0, 200, 7<<3), false},
+		{"openvpn udp", detectOpenVPNUDP, withLen(14, 7<<3), true},
+		{"openvpn udp ack", detectOpenVPNUDP, withLen(14, 5<<3), false},
+		{"openvpn udp short", detectOpenVPNUDP, withLen(13, 7<<3), false},
+
+		{"quic v1 initial", detectQUIC, quicPacket(0xc3, 0x00000001, 1200), true},
+		{"quic v1 handshake", detectQUIC, quicPacket(0xe3, 0x00000001, 1200), false},
+		{"quic v2 initial", detectQUIC, quicPacket(0xd3, 0x6b3343cf, 1252), true},
+		{"quic v2 type 0", detectQUIC, quicPacket(0xc3, 0x6b3343cf, 1252), false},
+		{"quic draft", detectQUIC, quicPacket(0xc0, 0xff00001d, 1350), true},
+		{"quic unknown version", detectQUIC, quicPacket(0xc0, 0x0a0a0a0a, 1200), false},
+		{"quic short header", detectQUIC, quicPacket(0x43, 0x00000001, 1200), false},
+		{"quic unpadded", detectQUIC, quicPacket(0xc3, 0x00000001, 1199), false},
+	}
+	for _, tc := range cases {
+		if got := tc.detect(tc.data); got != tc.want {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestIdentifyProtocol(t *testing.T) {
+	cases := []struct {
+		name string
+		data []byte
+		udp  bool
+		want protocolSet
+	}{
+		{"ssh", []byte("SSH-2.0-OpenSSH_9.6\r\n"), false, protoSSH},
+		{"tls", []byte{0x16, 0x03, 0x01, 0x02, 0x00}, false, protoTLS},
+		{"wireguard", withLen(148, 1, 0, 0, 0), true, protoWireGuard},
+		// WireGuard 只走 UDP，同样的字节出现在 TCP 上不算
+		{"wireguard over tcp", withLen(148, 1, 0, 0, 0), false, protoUnknown},
+		{"utp", utpPacket(0, 0x1234, 123456, 0, 1, 0), true, protoBitTorrent},
+		{"random udp", bytes.Repeat([]byte{0x41}, 64), true, protoUnknown},
+	}
+	for _, tc := range cases {
+		if got := identifyProtocol(tc.data, tc.udp); got != tc.want {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, got)
+		}
+	}
+}
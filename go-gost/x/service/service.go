@@ -1,18 +1,16 @@
 package service
 
 import (
-	"bufio"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"log"
 	"net"
 	"os"
 	"os/exec"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-gost/core/admission"
@@ -42,33 +40,15 @@ type options struct {
 	observer       observer.Observer
 	observerPeriod time.Duration
 	logger         logger.Logger
-}
-
-var isTls = 0
-
-var isHttp = 0
-
-var isSocks = 0
-
-var needWrap = false
-
-// SetProtocolBlock sets protocol blocking switches and recomputes wrapper need
-func SetProtocolBlock(httpOn int, tlsOn int, socksOn int) {
-	isHttp = httpOn
-	isTls = tlsOn
-	isSocks = socksOn
-	needWrap = isTls+isSocks+isHttp > 0
+	limiter        *TrafficLimiterSwitch
+	protocolPolicy *ProtocolPolicy
 }
 
 type Option func(opts *options)
 
 func init() {
-	_, err := LoadConfig("config.json")
-	fmt.Println("config.json loaded")
-	if err != nil {
-		log.Fatal(err)
-	}
-	needWrap = isTls+isSocks+isHttp > 0
+	// 节点程序在 main 中校验配置文件；status 子命令可在任意目录运行，读取失败时不退出
+	_, _ = LoadConfig("config.json")
 }
 
 func AdmissionOption(admission admission.Admission) Option {
@@ -131,12 +111,32 @@ func LoggerOption(logger logger.Logger) Option {
 	}
 }
 
+// TrafficLimiterSwitchOption 监听器使用的可替换流量限制器，用于原地更新限速
+func TrafficLimiterSwitchOption(limiter *TrafficLimiterSwitch) Option {
+	return func(opts *options) {
+		opts.limiter = limiter
+	}
+}
+
+// ProtocolPolicyOption 服务级协议拦截规则，与节点级规则合并生效
+func ProtocolPolicyOption(p *ProtocolPolicy) Option {
+	return func(opts *options) {
+		opts.protocolPolicy = p
+	}
+}
+
 type defaultService struct {
 	name     string
 	listener listener.Listener
-	handler  handler.Handler
+	mu       sync.RWMutex
+	handler  *handlerRef
+	draining atomic.Bool
+	protocol atomic.Pointer[protocolRule]
 	status   *Status
 	options  options
+	// 已转入全局流量管理器的累计字节数，供本地状态接口查看
+	inputBytes  atomic.Uint64
+	outputBytes atomic.Uint64
 }
 
 func NewService(name string, ln listener.Listener, h handler.Handler, opts ...Option) service.Service {
@@ -147,7 +147,7 @@ func NewService(name string, ln listener.Listener, h handler.Handler, opts ...Op
 	s := &defaultService{
 		name:     name,
 		listener: ln,
-		handler:  h,
+		handler:  &handlerRef{Handler: h},
 		options:  options,
 		status: &Status{
 			createTime: time.Now(),
@@ -155,6 +155,7 @@ func NewService(name string, ln listener.Listener, h handler.Handler, opts ...Op
 			stats:      options.stats,
 		},
 	}
+	s.SetProtocolPolicy(options.protocolPolicy)
 	s.setState(StateRunning)
 
 	s.execCmds("pre-up", s.options.preUp)
@@ -256,6 +257,11 @@ func (s *defaultService) Serve() error {
 				break
 			}
 		}
+		if s.draining.Load() {
+			conn.Close()
+			log.Debugf("service is draining, %s is rejected", clientAddr)
+			continue
+		}
 		if s.options.admission != nil &&
 			!s.options.admission.Admit(ctx, clientAddr) {
 			conn.Close()
@@ -263,10 +269,16 @@ func (s *defaultService) Serve() error {
 			continue
 		}
 
+		tc := trackConn(s, sid, clientAddr, conn)
+		ctx = ctxvalue.ContextWithConnTarget(ctx, tc.setTarget)
+
+		h := s.acquireHandler()
 		wg.Add(1)
 
 		go func() {
 			defer wg.Done()
+			defer h.release()
+			defer untrackConn(sid)
 
 			if v := xmetrics.GetCounter(xmetrics.MetricServiceRequestsCounter,
 				metrics.Labels{"service": s.name, "client": clientIP}); v != nil {
@@ -287,11 +299,11 @@ func (s *defaultService) Serve() error {
 				}()
 			}
 
-			if needWrap {
-				conn = wrapConnPDetection(conn)
+			if rule := s.protocolRule(); rule != nil {
+				conn = wrapProtocolDetection(conn, s.name, rule, log)
 			}
 
-			if err := s.handler.Handle(ctx, conn); err != nil {
+			if err := h.Handle(ctx, conn); err != nil {
 				if !errors.Is(err, net.ErrClosed) {
 					log.Error(err)
 				}
@@ -311,13 +323,24 @@ func (s *defaultService) Status() *Status {
 	return s.status
 }
 
+// TrafficTotals 返回服务启动以来的累计收发字节数，含尚未转入全局流量管理器的部分
+func (s *defaultService) TrafficTotals() (inputBytes, outputBytes uint64) {
+	inputBytes, outputBytes = s.inputBytes.Load(), s.outputBytes.Load()
+	if st := s.status.Stats(); st != nil {
+		inputBytes += st.Get(stats.KindInputBytes)
+		outputBytes += st.Get(stats.KindOutputBytes)
+	}
+	return
+}
+
 func (s *defaultService) Close() error {
 	s.execCmds("pre-down", s.options.preDown)
 	defer s.execCmds("post-down", s.options.postDown)
 
-	if closer, ok := s.handler.(io.Closer); ok {
-		closer.Close()
-	}
+	s.mu.RLock()
+	h := s.handler
+	s.mu.RUnlock()
+	h.close()
 	return s.listener.Close()
 }
 
@@ -408,6 +431,8 @@ func (s *defaultService) observeStats(ctx context.Context) {
 
 				// 将流量累积到全局管理器，而不是立即上报
 				if outputBytes > 0 || inputBytes > 0 {
+					s.inputBytes.Add(inputBytes)
+					s.outputBytes.Add(outputBytes)
 					globalManager := GetGlobalTrafficManager()
 					globalManager.AddTraffic(s.name, int64(outputBytes), int64(inputBytes))
 
@@ -440,126 +465,6 @@ func (ServiceEvent) Type() observer.EventType {
 	return observer.EventStatus
 }
 
-func wrapConnPDetection(conn net.Conn) net.Conn {
-	return &detectConn{
-		Conn:   conn,
-		reader: bufio.NewReader(conn),
-	}
-}
-
-type detectConn struct {
-	net.Conn
-	reader   *bufio.Reader
-	detected bool
-}
-
-func (c *detectConn) Read(b []byte) (int, error) {
-	n, err := c.reader.Read(b)
-	if n > 0 && !c.detected {
-		c.detected = true
-		if detectProtocol(b[:n], c.Conn) {
-			return 0, fmt.Errorf("connection blocked")
-		}
-	}
-	return n, err
-}
-
-func detectProtocol(data []byte, conn net.Conn) (blocked bool) {
-	// 如果是 UDP，则不检测，直接放行
-	if conn.RemoteAddr().Network() == "udp" || conn.RemoteAddr().Network() == "udp4" || conn.RemoteAddr().Network() == "udp6" {
-		return false
-	}
-
-	if isHttp == 1 && detectHTTP(data) {
-		conn.Close()
-		return true
-	}
-
-	if isTls == 1 && detectTLS(data) {
-		conn.Close()
-		return true
-	}
-
-	if isSocks == 1 && detectSOCKS(data) {
-		conn.Close()
-		return true
-	}
-
-	return false
-}
-
-func detectHTTP(data []byte) bool {
-	if len(data) < 3 {
-		return false
-	}
-	switch {
-	case len(data) >= 3 && data[0] == 'G' && data[1] == 'E' && data[2] == 'T':
-		return true
-	case len(data) >= 4 && data[0] == 'P' && data[1] == 'O' && data[2] == 'S' && data[3] == 'T':
-		return true
-	case len(data) >= 3 && data[0] == 'P' && data[1] == 'U' && data[2] == 'T':
-		return true
-	case len(data) >= 6 && data[0] == 'D' && data[1] == 'E' && data[2] == 'L' &&
-		data[3] == 'E' && data[4] == 'T' && data[5] == 'E':
-		return true
-	case len(data) >= 4 && data[0] == 'H' && data[1] == 'E' && data[2] == 'A' && data[3] == 'D':
-		return true
-	case len(data) >= 7 && data[0] == 'O' && data[1] == 'P' && data[2] == 'T' &&
-		data[3] == 'I' && data[4] == 'O' && data[5] == 'N' && data[6] == 'S':
-		return true
-	case len(data) >= 5 && data[0] == 'P' && data[1] == 'A' && data[2] == 'T' &&
-		data[3] == 'C' && data[4] == 'H':
-		return true
-	case len(data) >= 7 && data[0] == 'C' && data[1] == 'O' && data[2] == 'N' &&
-		data[3] == 'N' && data[4] == 'E' && data[5] == 'C' && data[6] == 'T': // HTTPS proxy
-		return true
-	default:
-		return false
-	}
-}
-
-func detectTLS(data []byte) bool {
-	if len(data) < 5 {
-		return false
-	}
-	if data[0] == 0x16 && data[1] == 0x03 && data[2] >= 0x01 && data[2] <= 0x04 {
-		return true
-	}
-	return false
-}
-
-func detectSOCKS(data []byte) bool {
-	if len(data) == 0 {
-		return false
-	}
-
-	switch data[0] {
-	case 0x04:
-		if len(data) < 7 {
-			return false
-		}
-		cmd := data[1]
-		if cmd != 0x01 && cmd != 0x02 {
-			return false
-		}
-		return true
-	case 0x05:
-		if len(data) < 2 {
-			return false
-		}
-		nMethods := int(data[1])
-		if len(data) < 2+nMethods {
-			return false
-		}
-		for _, method := range data[2 : 2+nMethods] {
-			if method == 0x00 || method == 0x02 {
-				return true
-			}
-		}
-	}
-	return false
-}
-
 // Config 配置结构体
 type Config struct {
 	Addr   string `json:"addr"`
@@ -584,9 +489,7 @@ func LoadConfig(configPath string) (string, error) {
 		return "", fmt.Errorf("解析配置文件失败: %v", err)
 	}
 
-	isTls = config.Tls
-	isSocks = config.Socks
-	isHttp = config.Http
+	SetProtocolBlock(config.Http, config.Tls, config.Socks)
 
 	return "", nil
 
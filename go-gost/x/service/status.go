@@ -18,6 +18,8 @@ const (
 	StateReady   State = "ready"
 	StateFailed  State = "failed"
 	StateClosed  State = "closed"
+	// StateDraining 服务已被替换，不再接受新连接，等待已有连接结束
+	StateDraining State = "draining"
 )
 
 type Event struct {
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// 面板不可达时，未确认的流量上报写入工作目录下的日志文件，面板恢复后按序号顺序补发，
+// 面板按 (节点, 序号) 去重。序号取毫秒时间戳且严格递增，重装后清空日志也不会与旧序号冲突。
+
+const (
+	trafficJournalFile = "traffic_journal.json"
+	// trafficJournalMaxEntries 日志条目上限，超出后丢弃最早的条目
+	trafficJournalMaxEntries = 2000
+	// trafficJournalMaxAge 条目最长保留时间，须短于面板记录已处理序号的时长
+	trafficJournalMaxAge = 72 * time.Hour
+	// trafficJournalFlushBatch 每轮最多补发的条目数
+	trafficJournalFlushBatch = 20
+)
+
+// trafficJournalEntry 一次未确认的流量上报
+type trafficJournalEntry struct {
+	Seq   int64               `json:"seq"`
+	Time  int64               `json:"time"`
+	Items []TrafficReportItem `json:"items"`
+	// sent 是否已尝试发送。已尝试的条目面板可能已经处理，不能再合并新流量
+	sent bool
+}
+
+// trafficJournal 有界的流量上报磁盘日志
+type trafficJournal struct {
+	mu      sync.Mutex
+	path    string
+	entries []*trafficJournalEntry
+	lastSeq int64
+}
+
+// newTrafficJournal 打开日志文件，恢复上次退出时未确认的上报
+func newTrafficJournal(path string) *trafficJournal {
+	j := &trafficJournal{path: path}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			fmt.Printf("⚠️ 读取流量日志失败: %v\n", err)
+		}
+		return j
+	}
+	if err := json.Unmarshal(data, &j.entries); err != nil {
+		fmt.Printf("⚠️ 解析流量日志失败，已忽略: %v\n", err)
+		j.entries = nil
+		return j
+	}
+	for _, e := range j.entries {
+		// 重启前可能已发出，按已发送处理
+		e.sent = true
+		if e.Seq > j.lastSeq {
+			j.lastSeq = e.Seq
+		}
+	}
+	if len(j.entries) > 0 {
+		fmt.Printf("📒 恢复 %d 条未确认的流量上报\n", len(j.entries))
+	}
+	return j
+}
+
+// NextSeq 分配下一个上报序号
+func (j *trafficJournal) NextSeq() int64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.nextSeqLocked()
+}
+
+func (j *trafficJournal) nextSeqLocked() int64 {
+	seq := time.Now().UnixMilli()
+	if seq <= j.lastSeq {
+		seq = j.lastSeq + 1
+	}
+	j.lastSeq = seq
+	return seq
+}
+
+// Append 记录一次未确认的上报。seq 非 0 表示已用该序号发送失败；为 0 表示尚未发送，
+// 若末尾条目也未发送则合并进去，面板长时间不可达时日志不会随时间增长。
+func (j *trafficJournal) Append(seq int64, items []TrafficReportItem) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if seq == 0 && len(j.entries) > 0 {
+		if tail := j.entries[len(j.entries)-1]; !tail.sent {
+			tail.Items = mergeTrafficItems(tail.Items, items)
+			j.persistLocked()
+			return
+		}
+	}
+	entry := &trafficJournalEntry{
+		Seq:   seq,
+		Time:  time.Now().UnixMilli(),
+		Items: mergeTrafficItems(nil, items),
+		sent:  seq != 0,
+	}
+	if seq == 0 {
+		entry.Seq = j.nextSeqLocked()
+	}
+	j.entries = append(j.entries, entry)
+	j.trimLocked()
+	j.persistLocked()
+}
+
+// Flush 按序号顺序补发日志条目，遇到失败即停止。返回剩余条目数
+func (j *trafficJournal) Flush(send func(seq int64, items []TrafficReportItem) error) int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if len(j.entries) == 0 {
+		return 0
+	}
+	j.trimLocked()
+
+	sent := 0
+	for sent < len(j.entries) && sent < trafficJournalFlushBatch {
+		e := j.entries[sent]
+		e.sent = true
+		if err := send(e.Seq, e.Items); err != nil {
+			fmt.Printf("⚠️ 补发流量上报失败 (seq=%d, 剩余%d条): %v\n", e.Seq, len(j.entries)-sent, err)
+			break
+		}
+		sent++
+	}
+	if sent > 0 {
+		j.entries = j.entries[sent:]
+		fmt.Printf("📤 已补发 %d 条流量上报，剩余 %d 条\n", sent, len(j.entries))
+	}
+	j.persistLocked()
+	return len(j.entries)
+}
+
+// Len 返回等待补发的条目数
+func (j *trafficJournal) Len() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.entries)
+}
+
+// trimLocked 丢弃超出数量上限或过期的最早条目
+func (j *trafficJournal) trimLocked() {
+	cutoff := time.Now().Add(-trafficJournalMaxAge).UnixMilli()
+	drop := 0
+	for drop < len(j.entries) && (len(j.entries)-drop > trafficJournalMaxEntries || j.entries[drop].Time < cutoff) {
+		drop++
+	}
+	if drop > 0 {
+		fmt.Printf("⚠️ 流量日志已满或过期，丢弃最早的 %d 条上报 (seq %d-%d)\n", drop, j.entries[0].Seq, j.entries[drop-1].Seq)
+		j.entries = j.entries[drop:]
+	}
+}
+
+// persistLocked 将日志写回磁盘，清空后删除文件。写入失败时条目仍保留在内存中
+func (j *trafficJournal) persistLocked() {
+	if len(j.entries) == 0 {
+		if err := os.Remove(j.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			fmt.Printf("⚠️ 删除流量日志失败: %v\n", err)
+		}
+		return
+	}
+	data, err := json.Marshal(j.entries)
+	if err != nil {
+		fmt.Printf("⚠️ 序列化流量日志失败: %v\n", err)
+		return
+	}
+	tmp := j.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		fmt.Printf("⚠️ 写入流量日志失败: %v\n", err)
+		return
+	}
+	if err := os.Rename(tmp, j.path); err != nil {
+		os.Remove(tmp)
+		fmt.Printf("⚠️ 写入流量日志失败: %v\n", err)
+	}
+}
+
+// mergeTrafficItems 按服务名累加流量
+func mergeTrafficItems(dst, src []TrafficReportItem) []TrafficReportItem {
+	index := make(map[string]int, len(dst))
+	for i, item := range dst {
+		index[item.N] = i
+	}
+	for _, item := range src {
+		if i, ok := index[item.N]; ok {
+			dst[i].U += item.U
+			dst[i].D += item.D
+			dst[i].R += item.R
+			continue
+		}
+		index[item.N] = len(dst)
+		dst = append(dst, item)
+	}
+	return dst
+}
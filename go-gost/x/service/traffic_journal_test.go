@@ -0,0 +1,125 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testTrafficJournal(t *testing.T) *trafficJournal {
+	t.Helper()
+	return newTrafficJournal(filepath.Join(t.TempDir(), trafficJournalFile))
+}
+
+func TestTrafficJournalAppendMergesUnsentTail(t *testing.T) {
+	j := testTrafficJournal(t)
+	j.Append(0, []TrafficReportItem{{N: "a", U: 1, D: 2}})
+	seq := j.entries[0].Seq
+	j.Append(0, []TrafficReportItem{{N: "a", U: 10, D: 20}, {N: "b", U: 5}})
+
+	if j.Len() != 1 || j.entries[0].Seq != seq {
+		t.Fatalf("expected unsent reports merged into one entry with seq %d, got %d entries", seq, j.Len())
+	}
+	want := []TrafficReportItem{{N: "a", U: 11, D: 22}, {N: "b", U: 5}}
+	if !reflect.DeepEqual(j.entries[0].Items, want) {
+		t.Fatalf("expected %v, got %v", want, j.entries[0].Items)
+	}
+
+	// 已发送过的条目面板可能已处理，新流量须另起一条
+	j.Flush(func(int64, []TrafficReportItem) error { return errors.New("offline") })
+	j.Append(0, []TrafficReportItem{{N: "a", U: 1}})
+	if j.Len() != 2 || j.entries[0].Seq != seq || j.entries[1].Seq <= seq {
+		t.Fatalf("expected a new entry after the sent one, got %+v", j.entries)
+	}
+	if !reflect.DeepEqual(j.entries[0].Items, want) {
+		t.Fatalf("expected the sent entry to stay unchanged, got %v", j.entries[0].Items)
+	}
+}
+
+func TestTrafficJournalAppendKeepsSentSeq(t *testing.T) {
+	j := testTrafficJournal(t)
+	j.Append(42, []TrafficReportItem{{N: "a", U: 1}})
+	j.Append(0, []TrafficReportItem{{N: "a", U: 2}})
+
+	if j.Len() != 2 || j.entries[0].Seq != 42 || !j.entries[0].sent {
+		t.Fatalf("expected the failed send to keep seq 42, got %+v", j.entries)
+	}
+	if j.entries[0].Items[0].U != 1 || j.entries[1].sent {
+		t.Fatalf("expected new traffic in a separate unsent entry, got %+v", j.entries)
+	}
+}
+
+func TestTrafficJournalFlushStopsOnFirstFailure(t *testing.T) {
+	j := testTrafficJournal(t)
+	for _, seq := range []int64{1, 2, 3} {
+		j.Append(seq, []TrafficReportItem{{N: "a", U: seq}})
+	}
+
+	var sent []int64
+	left := j.Flush(func(seq int64, _ []TrafficReportItem) error {
+		sent = append(sent, seq)
+		if seq == 2 {
+			return errors.New("offline")
+		}
+		return nil
+	})
+	if left != 2 || !reflect.DeepEqual(sent, []int64{1, 2}) {
+		t.Fatalf("expected to stop at seq 2 with 2 left, sent %v, %d left", sent, left)
+	}
+	if j.entries[0].Seq != 2 || j.entries[1].Seq != 3 {
+		t.Fatalf("expected seq 2 and 3 to remain in order, got %+v", j.entries)
+	}
+
+	sent = nil
+	if left := j.Flush(func(seq int64, _ []TrafficReportItem) error {
+		sent = append(sent, seq)
+		return nil
+	}); left != 0 || !reflect.DeepEqual(sent, []int64{2, 3}) {
+		t.Fatalf("expected the rest to be sent in order, sent %v, %d left", sent, left)
+	}
+	if _, err := os.Stat(j.path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the empty journal file to be removed, got %v", err)
+	}
+}
+
+func TestTrafficJournalTrim(t *testing.T) {
+	j := testTrafficJournal(t)
+	now := time.Now().UnixMilli()
+	expired := time.Now().Add(-trafficJournalMaxAge - time.Minute).UnixMilli()
+	j.entries = append(j.entries, &trafficJournalEntry{Seq: 1, Time: expired})
+	for i := 0; i < trafficJournalMaxEntries+5; i++ {
+		j.entries = append(j.entries, &trafficJournalEntry{Seq: int64(i + 2), Time: now})
+	}
+
+	j.trimLocked()
+	if j.Len() != trafficJournalMaxEntries {
+		t.Fatalf("expected %d entries, got %d", trafficJournalMaxEntries, j.Len())
+	}
+	// 过期条目和超出上限的最早 5 条被丢弃
+	if j.entries[0].Seq != 7 || j.entries[len(j.entries)-1].Seq != trafficJournalMaxEntries+6 {
+		t.Fatalf("expected the oldest entries to be dropped, got seq %d-%d", j.entries[0].Seq, j.entries[len(j.entries)-1].Seq)
+	}
+}
+
+func TestTrafficJournalReloadMarksEntriesSent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), trafficJournalFile)
+	j := newTrafficJournal(path)
+	j.Append(0, []TrafficReportItem{{N: "a", U: 1}})
+	seq := j.entries[0].Seq
+
+	// 重启前可能已发出，恢复后不能再合并新流量，新序号也不能回退
+	reloaded := newTrafficJournal(path)
+	if reloaded.Len() != 1 || reloaded.entries[0].Seq != seq || !reloaded.entries[0].sent {
+		t.Fatalf("expected the entry to be restored as sent, got %+v", reloaded.entries)
+	}
+	reloaded.Append(0, []TrafficReportItem{{N: "a", U: 2}})
+	if reloaded.Len() != 2 || reloaded.entries[1].Seq <= seq {
+		t.Fatalf("expected a new entry with a later seq, got %+v", reloaded.entries)
+	}
+	if next := reloaded.NextSeq(); next <= reloaded.entries[1].Seq {
+		t.Fatalf("expected seq to keep increasing, got %d", next)
+	}
+}
@@ -6,12 +6,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-gost/core/observer/stats"
 	"github.com/go-gost/x/config"
 	"github.com/go-gost/x/internal/util/crypto"
+	"github.com/go-gost/x/paneltls"
 	"github.com/go-gost/x/registry"
 )
 
@@ -21,14 +24,55 @@ var httpAESCrypto *crypto.AESCrypto // 新增：HTTP上报加密器
 
 // TrafficReportItem 流量报告项（压缩格式）
 type TrafficReportItem struct {
-	N string `json:"n"` // 服务名（name缩写）
-	U int64  `json:"u"` // 上行流量（up缩写）
-	D int64  `json:"d"` // 下行流量（down缩写）
+	N string `json:"n"`           // 服务名（name缩写）
+	U int64  `json:"u"`           // 上行流量（up缩写）
+	D int64  `json:"d"`           // 下行流量（down缩写）
+	R int64  `json:"r,omitempty"` // 准入规则拒绝或协议规则拦截的连接数（rejected缩写），此时 N 为准入规则名或 "<服务名>_proto"
+}
+
+// TrafficReportState 最近的流量上报结果，供本地状态接口查看
+type TrafficReportState struct {
+	LastSuccess int64  `json:"lastSuccess"` // 最近一次上报成功时间（毫秒时间戳）
+	LastError   string `json:"lastError,omitempty"`
+	LastErrorAt int64  `json:"lastErrorAt,omitempty"`
+	Pending     int    `json:"pending"` // 日志中等待补发的上报数
+}
+
+var (
+	reportStateMu sync.Mutex
+	reportState   TrafficReportState
+)
+
+// GetTrafficReportState 返回最近的流量上报结果
+func GetTrafficReportState() TrafficReportState {
+	reportStateMu.Lock()
+	state := reportState
+	reportStateMu.Unlock()
+	state.Pending = GetGlobalTrafficManager().journal.Len()
+	return state
+}
+
+// reportTraffic 发送流量报告并记录结果
+func reportTraffic(ctx context.Context, seq int64, reportItems []TrafficReportItem) (bool, error) {
+	success, err := sendBatchTrafficReport(ctx, seq, reportItems)
+	reportStateMu.Lock()
+	switch {
+	case err != nil:
+		reportState.LastError, reportState.LastErrorAt = err.Error(), time.Now().UnixMilli()
+	case !success:
+		reportState.LastError, reportState.LastErrorAt = "上报未成功", time.Now().UnixMilli()
+	default:
+		reportState.LastSuccess = time.Now().UnixMilli()
+	}
+	reportStateMu.Unlock()
+	return success, err
 }
 
 func SetHTTPReportURL(addr string, secret string) {
-	httpReportURL = "http://" + addr + "/flow/upload?secret=" + secret
-	configReportURL = "http://" + addr + "/flow/config?secret=" + secret
+	// 启用 mTLS 时改用 mTLS 监听地址
+	base := paneltls.HTTPBase(addr)
+	httpReportURL = base + "/flow/upload?secret=" + secret
+	configReportURL = base + "/flow/config?secret=" + secret
 
 	// 创建 AES 加密器
 	var err error
@@ -39,10 +83,13 @@ func SetHTTPReportURL(addr string, secret string) {
 	} else {
 		fmt.Printf("🔐 HTTP AES 加密器创建成功\n")
 	}
+
+	// 立即启动上报器，补发上次退出前未确认的流量
+	GetGlobalTrafficManager()
 }
 
-// sendBatchTrafficReport 批量发送多个服务的流量报告到HTTP接口
-func sendBatchTrafficReport(ctx context.Context, reportItems []TrafficReportItem) (bool, error) {
+// sendBatchTrafficReport 批量发送多个服务的流量报告到HTTP接口，seq 供面板去重重发的报告
+func sendBatchTrafficReport(ctx context.Context, seq int64, reportItems []TrafficReportItem) (bool, error) {
 	jsonData, err := json.Marshal(reportItems)
 	if err != nil {
 		return false, fmt.Errorf("序列化报告数据失败: %v", err)
@@ -73,7 +120,15 @@ func sendBatchTrafficReport(ctx context.Context, reportItems []TrafficReportItem
 		requestBody = jsonData
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", httpReportURL, bytes.NewBuffer(requestBody))
+	if httpReportURL == "" {
+		return false, fmt.Errorf("流量上报URL未设置")
+	}
+	reportURL := httpReportURL
+	if seq > 0 {
+		reportURL += "&seq=" + strconv.FormatInt(seq, 10)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", reportURL, bytes.NewBuffer(requestBody))
 	if err != nil {
 		return false, fmt.Errorf("创建HTTP请求失败: %v", err)
 	}
@@ -81,9 +136,7 @@ func sendBatchTrafficReport(ctx context.Context, reportItems []TrafficReportItem
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("User-Agent", "GOST-Traffic-Reporter/1.0")
 
-	client := &http.Client{
-		Timeout: 5 * time.Second,
-	}
+	client := paneltls.HTTPClient(5 * time.Second)
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -158,9 +211,7 @@ func sendConfigReport(ctx context.Context) (bool, error) {
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("User-Agent", "Config-Reporter/1.0")
 
-	client := &http.Client{
-		Timeout: 10 * time.Second, // 配置上报可以稍长一些
-	}
+	client := paneltls.HTTPClient(10 * time.Second) // 配置上报可以稍长一些
 
 	resp, err := client.Do(req)
 	if err != nil {
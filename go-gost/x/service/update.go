@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-gost/core/handler"
+	"github.com/go-gost/core/limiter"
+	"github.com/go-gost/core/limiter/traffic"
+)
+
+// Updater 支持在不关闭监听的情况下替换处理器和流量限制器，已建立的连接不受影响
+type Updater interface {
+	Update(h handler.Handler, limiter traffic.TrafficLimiter) error
+}
+
+// Drainer 支持优雅下线：不再接受新连接，已有连接在宽限期内自然结束，超时后强制关闭
+type Drainer interface {
+	Drain(grace time.Duration)
+}
+
+// handlerRef 引用计数的处理器。被替换后由最后一个使用它的连接负责关闭
+type handlerRef struct {
+	handler.Handler
+	mu      sync.Mutex
+	active  int
+	retired bool
+}
+
+func (r *handlerRef) acquire() {
+	r.mu.Lock()
+	r.active++
+	r.mu.Unlock()
+}
+
+func (r *handlerRef) release() {
+	r.mu.Lock()
+	r.active--
+	idle := r.retired && r.active == 0
+	r.mu.Unlock()
+	if idle {
+		r.close()
+	}
+}
+
+// retire 标记处理器已被替换，没有活动连接时立即关闭
+func (r *handlerRef) retire() {
+	r.mu.Lock()
+	r.retired = true
+	idle := r.active == 0
+	r.mu.Unlock()
+	if idle {
+		r.close()
+	}
+}
+
+func (r *handlerRef) close() error {
+	if closer, ok := r.Handler.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// TrafficLimiterSwitch 可替换的流量限制器。监听器始终持有同一个 Switch，
+// 限速的增加、修改和取消只需替换其中的限制器，已建立的连接在下一次读写时生效
+type TrafficLimiterSwitch struct {
+	v atomic.Pointer[trafficLimiterBox]
+}
+
+type trafficLimiterBox struct {
+	limiter traffic.TrafficLimiter
+}
+
+func NewTrafficLimiterSwitch(limiter traffic.TrafficLimiter) *TrafficLimiterSwitch {
+	s := &TrafficLimiterSwitch{}
+	s.Set(limiter)
+	return s
+}
+
+func (s *TrafficLimiterSwitch) Set(limiter traffic.TrafficLimiter) {
+	s.v.Store(&trafficLimiterBox{limiter: limiter})
+}
+
+func (s *TrafficLimiterSwitch) get() traffic.TrafficLimiter {
+	if box := s.v.Load(); box != nil {
+		return box.limiter
+	}
+	return nil
+}
+
+func (s *TrafficLimiterSwitch) In(ctx context.Context, key string, opts ...limiter.Option) traffic.Limiter {
+	if lim := s.get(); lim != nil {
+		return lim.In(ctx, key, opts...)
+	}
+	return nil
+}
+
+func (s *TrafficLimiterSwitch) Out(ctx context.Context, key string, opts ...limiter.Option) traffic.Limiter {
+	if lim := s.get(); lim != nil {
+		return lim.Out(ctx, key, opts...)
+	}
+	return nil
+}
+
+// Update 替换处理器（含转发目标和选择策略）和服务级流量限制器。
+// 新连接使用新处理器，已有连接继续使用旧处理器直到结束
+func (s *defaultService) Update(h handler.Handler, limiter traffic.TrafficLimiter) error {
+	if h == nil {
+		return errors.New("handler is nil")
+	}
+	if s.draining.Load() {
+		return fmt.Errorf("service %s is draining", s.name)
+	}
+
+	s.mu.Lock()
+	old := s.handler
+	s.handler = &handlerRef{Handler: h}
+	s.mu.Unlock()
+
+	if s.options.limiter != nil {
+		s.options.limiter.Set(limiter)
+	}
+	if old != nil {
+		old.retire()
+	}
+
+	s.status.addEvent(Event{
+		Time:    time.Now(),
+		Message: fmt.Sprintf("service %s is updated", s.name),
+	})
+	return nil
+}
+
+// Drain 让服务进入下线状态：监听保持打开但立即关闭新接入的连接（UDP 会话依赖同一个 socket），
+// 已有连接结束或宽限期到达后关闭服务及剩余连接
+func (s *defaultService) Drain(grace time.Duration) {
+	if !s.draining.CompareAndSwap(false, true) {
+		return
+	}
+	s.setState(StateDraining)
+
+	go func() {
+		deadline := time.Now().Add(grace)
+		for countOwnedConns(s) > 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Second)
+		}
+		if n := closeOwnedConns(s); n > 0 {
+			s.options.logger.Infof("drain timeout, %d connections closed", n)
+		}
+		s.Close()
+	}()
+}
+
+func (s *defaultService) acquireHandler() *handlerRef {
+	s.mu.RLock()
+	h := s.handler
+	h.acquire()
+	s.mu.RUnlock()
+	return h
+}
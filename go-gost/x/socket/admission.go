@@ -0,0 +1,103 @@
+package socket
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/go-gost/x/config"
+	parser "github.com/go-gost/x/config/parsing/admission"
+	"github.com/go-gost/x/registry"
+)
+
+// upsertAdmissions 注册或替换准入规则。服务通过名称引用准入规则，
+// 每次连接时才从注册表中查找，因此替换后立即生效，无需重启监听。
+func upsertAdmissions(req upsertAdmissionsRequest) error {
+	if len(req.Data) == 0 {
+		return errors.New("admissions list cannot be empty")
+	}
+
+	for i := range req.Data {
+		name := strings.TrimSpace(req.Data[i].Name)
+		if name == "" {
+			return errors.New("admission name is required")
+		}
+		req.Data[i].Name = name
+	}
+
+	for i := range req.Data {
+		cfg := req.Data[i]
+
+		v := parser.ParseAdmission(&cfg)
+		if v == nil {
+			return errors.New("create admission " + cfg.Name + " failed")
+		}
+
+		if registry.AdmissionRegistry().IsRegistered(cfg.Name) {
+			registry.AdmissionRegistry().Unregister(cfg.Name)
+		}
+		if err := registry.AdmissionRegistry().Register(cfg.Name, v); err != nil {
+			return errors.New("admission " + cfg.Name + " already exists")
+		}
+	}
+
+	config.OnUpdate(func(c *config.Config) error {
+		for i := range req.Data {
+			cfgCopy := req.Data[i]
+			found := false
+			for j := range c.Admissions {
+				if c.Admissions[j].Name == cfgCopy.Name {
+					c.Admissions[j] = &cfgCopy
+					found = true
+					break
+				}
+			}
+			if !found {
+				c.Admissions = append(c.Admissions, &cfgCopy)
+			}
+		}
+		return nil
+	})
+
+	return nil
+}
+
+func deleteAdmissions(req deleteAdmissionsRequest) error {
+	if len(req.Admissions) == 0 {
+		return errors.New("admissions list cannot be empty")
+	}
+
+	names := make(map[string]struct{}, len(req.Admissions))
+	for _, admissionName := range req.Admissions {
+		name := strings.TrimSpace(admissionName)
+		if name == "" {
+			return errors.New("admission name is required")
+		}
+		names[name] = struct{}{}
+
+		if registry.AdmissionRegistry().IsRegistered(name) {
+			registry.AdmissionRegistry().Unregister(name)
+		}
+	}
+
+	config.OnUpdate(func(c *config.Config) error {
+		admissions := c.Admissions
+		c.Admissions = nil
+		for _, a := range admissions {
+			if _, ok := names[a.Name]; ok {
+				continue
+			}
+			c.Admissions = append(c.Admissions, a)
+		}
+		return nil
+	})
+
+	return nil
+}
+
+type upsertAdmissionsRequest struct {
+	Data []config.AdmissionConfig `json:"data"`
+}
+
+type deleteAdmissionsRequest struct {
+	Admissions []string `json:"admissions"`
+}
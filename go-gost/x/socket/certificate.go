@@ -0,0 +1,144 @@
+package socket
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-gost/x/listener/vhost"
+)
+
+// certificateDir 存放面板下发的证书，服务的 TLS 配置通过
+// certs/<name>.crt 与 certs/<name>.key 引用。
+const certificateDir = "certs"
+
+type certificateItem struct {
+	Name string `json:"name"`
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+}
+
+type deleteCertificatesRequest struct {
+	Certificates []string `json:"certificates"`
+}
+
+type acmeChallengeItem struct {
+	Token   string `json:"token"`
+	KeyAuth string `json:"keyAuth"`
+}
+
+type setACMEChallengesRequest struct {
+	Challenges []acmeChallengeItem `json:"challenges"`
+	TTL        int                 `json:"ttl"`
+}
+
+type deleteACMEChallengesRequest struct {
+	Tokens []string `json:"tokens"`
+}
+
+// upsertCertificates 校验并写入证书。监听器只在启动时读取证书，
+// 因此更新后需要面板重新下发引用该证书的服务。
+func upsertCertificates(items []certificateItem) error {
+	if len(items) == 0 {
+		return errors.New("certificates list cannot be empty")
+	}
+
+	for i := range items {
+		name := strings.TrimSpace(items[i].Name)
+		if !validCertificateName(name) {
+			return fmt.Errorf("invalid certificate name: %q", items[i].Name)
+		}
+		items[i].Name = name
+		if _, err := tls.X509KeyPair([]byte(items[i].Cert), []byte(items[i].Key)); err != nil {
+			return fmt.Errorf("certificate %s: %v", name, err)
+		}
+	}
+
+	if err := os.MkdirAll(certificateDir, 0700); err != nil {
+		return err
+	}
+	for _, item := range items {
+		certFile, keyFile := certificatePaths(item.Name)
+		if err := writeFileAtomic(keyFile, []byte(item.Key)); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(certFile, []byte(item.Cert)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteCertificates(req deleteCertificatesRequest) error {
+	if len(req.Certificates) == 0 {
+		return errors.New("certificates list cannot be empty")
+	}
+
+	for _, raw := range req.Certificates {
+		name := strings.TrimSpace(raw)
+		if !validCertificateName(name) {
+			return fmt.Errorf("invalid certificate name: %q", raw)
+		}
+		certFile, keyFile := certificatePaths(name)
+		for _, file := range []string{certFile, keyFile} {
+			if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// setACMEChallenges 在 80 端口应答 HTTP-01 验证，面板签发证书时使用。
+func setACMEChallenges(req setACMEChallengesRequest) error {
+	if len(req.Challenges) == 0 {
+		return errors.New("challenges list cannot be empty")
+	}
+	ttl := time.Duration(req.TTL) * time.Second
+	for _, c := range req.Challenges {
+		if err := vhost.SetACMEChallenge(c.Token, c.KeyAuth, ttl); err != nil {
+			return fmt.Errorf("acme challenge %s: %v", c.Token, err)
+		}
+	}
+	return nil
+}
+
+func deleteACMEChallenges(req deleteACMEChallengesRequest) error {
+	for _, token := range req.Tokens {
+		vhost.DeleteACMEChallenge(token)
+	}
+	return nil
+}
+
+func certificatePaths(name string) (certFile, keyFile string) {
+	return filepath.Join(certificateDir, name+".crt"), filepath.Join(certificateDir, name+".key")
+}
+
+func validCertificateName(name string) bool {
+	if name == "" || len(name) > 64 {
+		return false
+	}
+	for _, c := range name {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '_' && c != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// writeFileAtomic 先写临时文件再重命名，避免监听器读到半个证书。
+func writeFileAtomic(file string, data []byte) error {
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -1,7 +1,10 @@
 package socket
 
 import (
+	"encoding/json"
+	"fmt"
 	"os"
+	"path/filepath"
 	"sync"
 
 	"github.com/go-gost/x/config"
@@ -28,3 +31,39 @@ func saveConfig() error {
 
 	return nil
 }
+
+// localConfigMutex 保护节点配置文件 config.json 的并发读改写
+var localConfigMutex sync.Mutex
+
+// UpdateConfigFile 修改节点配置文件中的部分字段，保留其它字段
+func UpdateConfigFile(configPath string, set map[string]interface{}, remove ...string) error {
+	localConfigMutex.Lock()
+	defer localConfigMutex.Unlock()
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("读取配置文件失败: %v", err)
+	}
+	raw := map[string]interface{}{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("解析配置文件失败: %v", err)
+	}
+	for k, v := range set {
+		raw[k] = v
+	}
+	for _, k := range remove {
+		delete(raw, k)
+	}
+	out, err := json.MarshalIndent(raw, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(configPath), "."+filepath.Base(configPath)+".tmp")
+	if err := os.WriteFile(tmp, out, 0600); err != nil {
+		return fmt.Errorf("写入配置文件失败: %v", err)
+	}
+	if err := os.Rename(tmp, configPath); err != nil {
+		return fmt.Errorf("写入配置文件失败: %v", err)
+	}
+	return nil
+}
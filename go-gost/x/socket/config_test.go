@@ -0,0 +1,66 @@
+package socket
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestUpdateConfigFileKeepsOtherFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	orig := map[string]interface{}{
+		"addr":               "panel:6365",
+		"secret":             "s",
+		"http":               float64(0),
+		"tls":                float64(0),
+		"socks":              float64(0),
+		"tls_addr":           ":6366",
+		"panel_pin":          "sha256/abc",
+		"geoip":              "/etc/gost/country.mmdb",
+		"enroll_id":          "e1",
+		"upgrade_public_key": "key",
+		"status_socket":      "-",
+	}
+	data, _ := json.Marshal(orig)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// 与 SetProtocol 写入方式一致，只改协议开关
+	if err := UpdateConfigFile(path, map[string]interface{}{"http": 1, "tls": 1, "socks": 0}, "enroll_id"); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]interface{}{}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	want := map[string]interface{}{}
+	for k, v := range orig {
+		want[k] = v
+	}
+	want["http"], want["tls"] = float64(1), float64(1)
+	delete(want, "enroll_id")
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(path), ".config.json.tmp")); !os.IsNotExist(err) {
+		t.Fatalf("expected the temp file to be renamed away, got %v", err)
+	}
+}
+
+func TestUpdateConfigFileMissing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := UpdateConfigFile(path, map[string]interface{}{"http": 1}); err == nil {
+		t.Fatalf("expected an error for a missing config file")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected no config file to be created, got %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package socket
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-gost/x/service"
+)
+
+const (
+	defaultConnectionListLimit = 500
+	maxConnectionListLimit     = 5000
+)
+
+// ConnectionScope 按服务名或服务名前缀限定连接范围，两者都为空表示全部服务
+type ConnectionScope struct {
+	Services []string `json:"services"`
+	Prefixes []string `json:"prefixes"`
+}
+
+type ListConnectionsRequest struct {
+	ConnectionScope
+	Limit int `json:"limit"`
+}
+
+type ListConnectionsResponse struct {
+	Total       int                `json:"total"`
+	Connections []service.ConnInfo `json:"connections"`
+}
+
+type CloseConnectionsRequest struct {
+	ConnectionScope
+	IDs []string `json:"ids"` // 为空时关闭范围内的全部连接
+}
+
+// match 返回服务名的匹配函数，范围为空时返回 nil（匹配全部）
+func (s ConnectionScope) match() func(string) bool {
+	if len(s.Services) == 0 && len(s.Prefixes) == 0 {
+		return nil
+	}
+	names := make(map[string]struct{}, len(s.Services))
+	for _, name := range s.Services {
+		names[name] = struct{}{}
+	}
+	return func(name string) bool {
+		if _, ok := names[name]; ok {
+			return true
+		}
+		for _, prefix := range s.Prefixes {
+			if prefix != "" && strings.HasPrefix(name, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// handleListConnections 列出活动连接（只读）
+func (w *WebSocketReporter) handleListConnections(data interface{}) (ListConnectionsResponse, error) {
+	var req ListConnectionsRequest
+	if err := decodeCommandData(data, &req); err != nil {
+		return ListConnectionsResponse{}, err
+	}
+	limit := clampInt(req.Limit, defaultConnectionListLimit, maxConnectionListLimit)
+
+	list := service.ListConnections(req.match())
+	resp := ListConnectionsResponse{Total: len(list), Connections: list}
+	if len(list) > limit {
+		resp.Connections = list[len(list)-limit:] // 保留最新的连接
+	}
+	return resp, nil
+}
+
+// handleCloseConnections 关闭指定连接，或关闭范围内服务的全部连接
+func (w *WebSocketReporter) handleCloseConnections(data interface{}) (map[string]interface{}, error) {
+	var req CloseConnectionsRequest
+	if err := decodeCommandData(data, &req); err != nil {
+		return nil, err
+	}
+	match := req.match()
+	if match == nil {
+		if len(req.IDs) == 0 {
+			return nil, errors.New("必须指定要断开的连接或服务")
+		}
+		match = func(string) bool { return true }
+	}
+
+	closed := service.CloseConnections(match, req.IDs...)
+	fmt.Printf("✂️ 已断开 %d 个连接\n", closed)
+	return map[string]interface{}{"closed": closed}, nil
+}
@@ -0,0 +1,396 @@
+package socket
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-gost/core/logger"
+	xlogger "github.com/go-gost/x/logger"
+	"github.com/rs/xid"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	logRingSize          = 2000
+	defaultLogTailLines  = 200
+	defaultLogTailTime   = 300  // 实时跟踪默认时长(秒)
+	maxLogTailTime       = 1800 // 实时跟踪最长时长(秒)
+	maxLogTailBatch      = 200  // 每次推送的最大条数
+	logTailPushInterval  = time.Second
+	defaultLogLevelTime  = 600  // 临时日志级别默认时长(秒)
+	maxLogLevelTime      = 3600 // 临时日志级别最长时长(秒)
+	maxLogTailSubscriber = 8
+)
+
+// logLevelRank 日志级别从低到高排序，用于最低级别过滤
+var logLevelRank = map[string]int{
+	"trace": 0,
+	"debug": 1,
+	"info":  2,
+	"warn":  3,
+	"error": 4,
+	"fatal": 5,
+	"panic": 5,
+}
+
+// LogEntry 日志环形缓冲中的一条记录
+type LogEntry struct {
+	Seq     int64             `json:"seq"`
+	Time    int64             `json:"time"` // 毫秒时间戳
+	Level   string            `json:"level"`
+	Source  string            `json:"source"` // gost: 服务日志，agent: 节点程序输出
+	Service string            `json:"service,omitempty"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// LogFilter 日志过滤条件，service 同时匹配服务字段和程序输出中提到的服务名
+type LogFilter struct {
+	Level   string `json:"level"`
+	Service string `json:"service"`
+}
+
+func (f LogFilter) match(e *LogEntry) bool {
+	if f.Level != "" && logLevelRank[e.Level] < logLevelRank[f.Level] {
+		return false
+	}
+	if f.Service != "" && e.Service != f.Service &&
+		!(e.Service == "" && strings.Contains(e.Message, f.Service)) {
+		return false
+	}
+	return true
+}
+
+// logRing 最近日志的环形缓冲
+type logRing struct {
+	mu      sync.RWMutex
+	entries []LogEntry
+	next    int64 // 下一条日志的序号
+}
+
+var agentLogs = &logRing{entries: make([]LogEntry, logRingSize)}
+
+func (r *logRing) add(e LogEntry) {
+	r.mu.Lock()
+	e.Seq = r.next
+	r.entries[r.next%logRingSize] = e
+	r.next++
+	r.mu.Unlock()
+}
+
+// since 返回序号大于 after 且满足过滤条件的日志，最多 limit 条（保留最新的）
+func (r *logRing) since(after int64, f LogFilter, limit int) ([]LogEntry, int64) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	start := after + 1
+	if oldest := r.next - logRingSize; start < oldest {
+		start = oldest
+	}
+	if start < 0 {
+		start = 0
+	}
+	list := make([]LogEntry, 0)
+	for seq := start; seq < r.next; seq++ {
+		e := &r.entries[seq%logRingSize]
+		if f.match(e) {
+			list = append(list, *e)
+		}
+	}
+	if len(list) > limit {
+		list = list[len(list)-limit:]
+	}
+	return list, r.next - 1
+}
+
+var captureOnce sync.Once
+
+// StartLogCapture 开始收集日志：gost 服务日志通过 logger 的 tap 获取，
+// 节点程序自身的输出（fmt.Print 到标准输出）通过管道转发并逐行记录。
+// 会替换 os.Stdout，须在程序启动时、其它协程开始输出之前调用
+func StartLogCapture() {
+	captureOnce.Do(func() {
+		xlogger.SetTap(func(e xlogger.Entry) {
+			entry := LogEntry{
+				Time:    e.Time.UnixMilli(),
+				Level:   strings.Replace(string(e.Level), "warning", "warn", 1),
+				Source:  "gost",
+				Message: e.Message,
+			}
+			if v, ok := e.Fields["service"].(string); ok {
+				entry.Service = v
+			}
+			if len(e.Fields) > 0 {
+				entry.Fields = make(map[string]string, len(e.Fields))
+				for k, v := range e.Fields {
+					if k != "service" {
+						entry.Fields[k] = fmt.Sprint(v)
+					}
+				}
+			}
+			agentLogs.add(entry)
+		})
+
+		r, w, err := os.Pipe()
+		if err != nil {
+			fmt.Printf("⚠️ 无法收集程序输出日志: %v\n", err)
+			return
+		}
+		stdout := os.Stdout
+		os.Stdout = w
+		done := make(chan struct{})
+		// 致命错误退出前关闭管道并等待转发完成，否则退出前的最后几行输出会丢失
+		logrus.RegisterExitHandler(func() {
+			w.Close()
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+			}
+		})
+		go func() {
+			defer close(done)
+			scanner := bufio.NewScanner(r)
+			scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+			for scanner.Scan() {
+				line := scanner.Text()
+				stdout.WriteString(line + "\n")
+				if strings.TrimSpace(line) == "" {
+					continue
+				}
+				agentLogs.add(LogEntry{
+					Time:    time.Now().UnixMilli(),
+					Level:   agentLineLevel(line),
+					Source:  "agent",
+					Message: line,
+				})
+			}
+			// 读取失败（如单行过长）后不再记录，但继续把管道内容转发到原始输出，
+			// 否则写满管道的程序输出会阻塞
+			if err := scanner.Err(); err != nil {
+				fmt.Fprintf(stdout, "⚠️ 程序输出日志收集已停止: %v\n", err)
+			}
+			io.Copy(stdout, r)
+		}()
+	})
+}
+
+// agentLineLevel 按程序输出的前缀符号推断级别
+func agentLineLevel(line string) string {
+	line = strings.TrimSpace(line)
+	switch {
+	case strings.HasPrefix(line, "❌"):
+		return "error"
+	case strings.HasPrefix(line, "⚠️"):
+		return "warn"
+	default:
+		return "info"
+	}
+}
+
+// logTail 实时日志订阅，由推送协程按间隔把新日志发送给面板
+type logTail struct {
+	id     string
+	filter LogFilter
+	cursor int64
+	until  time.Time
+}
+
+type TailLogsRequest struct {
+	LogFilter
+	Lines    int  `json:"lines"`
+	Follow   bool `json:"follow"`
+	Duration int  `json:"duration"` // 实时跟踪时长(秒)
+}
+
+type TailLogsResponse struct {
+	TailId    string     `json:"tailId,omitempty"`
+	ExpiresAt int64      `json:"expiresAt,omitempty"`
+	Entries   []LogEntry `json:"entries"`
+}
+
+type SetLogLevelRequest struct {
+	Service  string `json:"service"`
+	Level    string `json:"level"`    // 为空表示恢复默认级别
+	Duration int    `json:"duration"` // 生效时长(秒)
+}
+
+var logTails = struct {
+	sync.Mutex
+	m       map[string]*logTail
+	pushing bool // 推送协程是否在运行
+}{m: make(map[string]*logTail)}
+
+func normalizeLogLevel(level string) (string, error) {
+	level = strings.ToLower(strings.TrimSpace(level))
+	if level == "warning" {
+		level = "warn"
+	}
+	if level == "" {
+		return "", nil
+	}
+	if _, ok := logLevelRank[level]; !ok {
+		return "", fmt.Errorf("无效的日志级别: %s", level)
+	}
+	return level, nil
+}
+
+// handleTailLogs 返回最近的日志，follow 时创建实时订阅
+func (w *WebSocketReporter) handleTailLogs(data interface{}) (TailLogsResponse, error) {
+	var req TailLogsRequest
+	if err := decodeCommandData(data, &req); err != nil {
+		return TailLogsResponse{}, err
+	}
+	level, err := normalizeLogLevel(req.Level)
+	if err != nil {
+		return TailLogsResponse{}, err
+	}
+	req.Level = level
+	req.Service = strings.TrimSpace(req.Service)
+	lines := clampInt(req.Lines, defaultLogTailLines, logRingSize)
+
+	entries, last := agentLogs.since(-1, req.LogFilter, lines)
+	resp := TailLogsResponse{Entries: entries}
+	if !req.Follow {
+		return resp, nil
+	}
+
+	tail := &logTail{
+		id:     xid.New().String(),
+		filter: req.LogFilter,
+		cursor: last,
+		until:  time.Now().Add(time.Duration(clampInt(req.Duration, defaultLogTailTime, maxLogTailTime)) * time.Second),
+	}
+	logTails.Lock()
+	if len(logTails.m) >= maxLogTailSubscriber {
+		logTails.Unlock()
+		return TailLogsResponse{}, errors.New("实时日志订阅数已达上限")
+	}
+	logTails.m[tail.id] = tail
+	start := !logTails.pushing
+	logTails.pushing = true
+	logTails.Unlock()
+	if start {
+		go w.pushLogTails()
+	}
+
+	resp.TailId = tail.id
+	resp.ExpiresAt = tail.until.UnixMilli()
+	return resp, nil
+}
+
+// handleStopLogTail 结束实时订阅，未指定 tailId 时结束全部订阅
+func (w *WebSocketReporter) handleStopLogTail(data interface{}) (map[string]interface{}, error) {
+	var req struct {
+		TailId string `json:"tailId"`
+	}
+	if err := decodeCommandData(data, &req); err != nil {
+		return nil, err
+	}
+	logTails.Lock()
+	stopped := 0
+	for id := range logTails.m {
+		if req.TailId == "" || id == req.TailId {
+			delete(logTails.m, id)
+			stopped++
+		}
+	}
+	logTails.Unlock()
+	return map[string]interface{}{"stopped": stopped}, nil
+}
+
+// pushLogTails 推送各订阅的新日志，所有订阅结束后退出
+func (w *WebSocketReporter) pushLogTails() {
+	ticker := time.NewTicker(logTailPushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.ctx.Done():
+			logTails.Lock()
+			logTails.pushing = false
+			logTails.Unlock()
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		logTails.Lock()
+		tails := make([]*logTail, 0, len(logTails.m))
+		for id, tail := range logTails.m {
+			if now.After(tail.until) {
+				delete(logTails.m, id)
+				continue
+			}
+			tails = append(tails, tail)
+		}
+		if len(tails) == 0 {
+			logTails.pushing = false
+			logTails.Unlock()
+			return
+		}
+		logTails.Unlock()
+		if !w.isConnected() {
+			continue
+		}
+
+		for _, tail := range tails {
+			entries, last := agentLogs.since(tail.cursor, tail.filter, maxLogTailBatch)
+			tail.cursor = last
+			if len(entries) == 0 {
+				continue
+			}
+			w.sendResponse(CommandResponse{
+				Type:    "LogEntries",
+				Success: true,
+				Message: "OK",
+				Data: map[string]interface{}{
+					"tailId":  tail.id,
+					"entries": entries,
+				},
+			})
+		}
+	}
+}
+
+func (w *WebSocketReporter) isConnected() bool {
+	w.connMutex.Lock()
+	defer w.connMutex.Unlock()
+	return w.conn != nil && w.connected
+}
+
+// handleSetLogLevel 临时调整某个服务的日志级别（如 debug），到期自动恢复
+func (w *WebSocketReporter) handleSetLogLevel(data interface{}) (map[string]interface{}, error) {
+	var req SetLogLevelRequest
+	if err := decodeCommandData(data, &req); err != nil {
+		return nil, err
+	}
+	req.Service = strings.TrimSpace(req.Service)
+	if req.Service == "" {
+		return nil, errors.New("必须指定服务名称")
+	}
+	level, err := normalizeLogLevel(req.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	until := time.Now().Add(time.Duration(clampInt(req.Duration, defaultLogLevelTime, maxLogLevelTime)) * time.Second)
+	if err := xlogger.SetServiceLevel(req.Service, logger.LogLevel(level), until); err != nil {
+		return nil, err
+	}
+	if level == "" {
+		fmt.Printf("📝 服务 %s 已恢复默认日志级别\n", req.Service)
+		return map[string]interface{}{"service": req.Service}, nil
+	}
+	fmt.Printf("📝 服务 %s 日志级别临时调整为 %s，至 %s\n", req.Service, level, until.Format("15:04:05"))
+	return map[string]interface{}{
+		"service":   req.Service,
+		"level":     level,
+		"expiresAt": until.UnixMilli(),
+	}, nil
+}
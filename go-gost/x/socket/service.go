@@ -1,16 +1,20 @@
 package socket
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"strings"
 	"time"
 
 	"github.com/go-gost/core/service"
 	"github.com/go-gost/x/config"
 	parser "github.com/go-gost/x/config/parsing/service"
-	kill "github.com/go-gost/x/internal/util/port"
+	"github.com/go-gost/x/metadata"
+	mdutil "github.com/go-gost/x/metadata/util"
 	"github.com/go-gost/x/registry"
+	xservice "github.com/go-gost/x/service"
 )
 
 func createServices(req createServicesRequest) error {
@@ -103,27 +107,58 @@ func updateServices(req updateServicesRequest) error {
 
 		// 1. 获取旧服务
 		old := registry.ServiceRegistry().Get(name)
+		oldConfig := runningServiceConfig(name)
+
+		// 2. 监听不变时原地替换处理器和限速，已建立的连接不受影响
+		if old != nil && oldConfig != nil && canUpdateInPlace(oldConfig, serviceConfig) {
+			err := parser.UpdateService(old, serviceConfig)
+			if err == nil {
+				fmt.Printf("♻️ 服务 %s 已原地更新\n", name)
+				continue
+			}
+			fmt.Printf("⚠️ 服务 %s 原地更新失败，改为重建: %v\n", name, err)
+		}
+
+		// 3. 监听地址变更时先启动新服务，旧服务进入下线状态，已有连接在宽限期内继续使用。
+		// 新旧地址端口冲突（如 [::]:p -> 0.0.0.0:p）或新地址监听失败时，按原方式关闭后重建
+		if old != nil && oldConfig != nil && oldConfig.Addr != serviceConfig.Addr && !listenAddrsConflict(oldConfig.Addr, serviceConfig.Addr) {
+			svc, err := parser.ParseService(serviceConfig)
+			if err == nil {
+				registry.ReplaceService(name, svc)
+				go svc.Serve()
+
+				if drainer, ok := old.(xservice.Drainer); ok {
+					grace := drainGrace(serviceConfig)
+					drainer.Drain(grace)
+					fmt.Printf("⏳ 服务 %s 监听地址 %s -> %s，旧连接保留 %v\n", name, oldConfig.Addr, serviceConfig.Addr, grace)
+				} else {
+					old.Close()
+				}
+				continue
+			}
+			fmt.Printf("⚠️ 服务 %s 新地址 %s 监听失败，关闭旧服务后重建: %v\n", name, serviceConfig.Addr, err)
+		}
 
-		// 2. 关闭旧服务 (如果存在)
+		// 4. 关闭旧服务 (如果存在)
 		if old != nil {
 			old.Close()
-			// 3. 从注册表移除旧服务
+			// 从注册表移除旧服务
 			registry.ServiceRegistry().Unregister(name)
 		}
 
-		// 4. 解析新服务配置
+		// 5. 解析新服务配置
 		svc, err := parser.ParseService(serviceConfig)
 		if err != nil {
 			return errors.New("create service " + name + " failed: " + err.Error())
 		}
 
-		// 5. 注册新服务
+		// 6. 注册新服务
 		if err := registry.ServiceRegistry().Register(name, svc); err != nil {
 			svc.Close()
 			return errors.New("service " + name + " already exists")
 		}
 
-		// 6. 启动新服务
+		// 7. 启动新服务
 		go svc.Serve()
 	}
 
@@ -286,10 +321,8 @@ func pauseServices(req pauseServicesRequest) error {
 		// 暂停服务
 		stp.service.Close()
 
-		// 强制断开端口的所有连接
-		if serviceConfig.Addr != "" {
-			_ = kill.ForceClosePortConnections(serviceConfig.Addr)
-		}
+		// 断开该服务的所有活动连接
+		xservice.CloseServiceConnections(stp.name)
 
 		// 记录已暂停的服务
 		pausedServices = append(pausedServices, struct {
@@ -532,3 +565,124 @@ type updateServicesRequest struct {
 type createServicesRequest struct {
 	Data []config.ServiceConfig `json:"data"`
 }
+
+// defaultDrainGrace 监听地址变更后旧连接的默认保留时间，可通过服务 metadata.drainGrace 调整
+const defaultDrainGrace = 60 * time.Second
+
+func drainGrace(cfg *config.ServiceConfig) time.Duration {
+	if cfg.Metadata != nil {
+		md := metadata.NewMetadata(cfg.Metadata)
+		if md.IsExists("drainGrace") {
+			if v := mdutil.GetDuration(md, "drainGrace"); v >= 0 {
+				return v
+			}
+		}
+	}
+	return defaultDrainGrace
+}
+
+// listenAddrsConflict 判断两个监听地址能否同时绑定：端口相同且主机相同或任一方为通配地址时冲突
+func listenAddrsConflict(a, b string) bool {
+	hostA, portA, errA := net.SplitHostPort(a)
+	hostB, portB, errB := net.SplitHostPort(b)
+	if errA != nil || errB != nil {
+		return true
+	}
+	if portA != portB {
+		return false
+	}
+	return hostA == hostB || isWildcardHost(hostA) || isWildcardHost(hostB)
+}
+
+func isWildcardHost(host string) bool {
+	if host == "" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsUnspecified()
+}
+
+// runningServiceConfig 返回正在运行的服务配置，服务不存在或已暂停时返回 nil
+func runningServiceConfig(name string) *config.ServiceConfig {
+	for _, s := range config.Global().Services {
+		if s.Name != name {
+			continue
+		}
+		if paused, _ := s.Metadata["paused"].(bool); paused {
+			return nil
+		}
+		return s
+	}
+	return nil
+}
+
+// inPlaceKeys 只作用于处理器的配置项，变更时可以原地更新
+var inPlaceKeys = []string{"handler", "forwarder", "limiter", "rlimiter", "bypass", "bypasses", "status"}
+
+// inPlaceMetadataKeys 不影响监听的服务 metadata：旧连接保留时间和协议拦截规则
+var inPlaceMetadataKeys = []string{"drainGrace", "protocol.allow", "protocol.deny", "protocol.whitelist"}
+
+// inPlaceRouteKeys 出站路由相关配置，监听器没有转发链时只影响处理器
+var (
+	inPlaceRouteKeys         = []string{"interface", "sockopts", "resolver", "hosts"}
+	inPlaceRouteMetadataKeys = []string{"interface", "so_mark", "dialTimeout", "netns.out"}
+)
+
+// canUpdateInPlace 判断新旧配置是否只有处理器侧的差异（监听器、准入、连接数限制等均不变）
+func canUpdateInPlace(old, new *config.ServiceConfig) bool {
+	a, b := listenerIdentity(old), listenerIdentity(new)
+	return a != "" && a == b
+}
+
+// listenerIdentity 去掉可原地更新的配置项后序列化，用于比较监听侧配置
+func listenerIdentity(cfg *config.ServiceConfig) string {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return ""
+	}
+	var m map[string]any
+	if err := json.Unmarshal(data, &m); err != nil {
+		return ""
+	}
+
+	for _, key := range inPlaceKeys {
+		delete(m, key)
+	}
+
+	ln, _ := m["listener"].(map[string]any)
+	if ln == nil {
+		ln = map[string]any{}
+		m["listener"] = ln
+	}
+	if t, _ := ln["type"].(string); strings.TrimSpace(t) == "" {
+		ln["type"] = "tcp"
+	}
+	if md, _ := ln["metadata"].(map[string]any); len(md) == 0 {
+		delete(ln, "metadata")
+	}
+
+	if ln["chain"] == nil && ln["chainGroup"] == nil {
+		for _, key := range inPlaceRouteKeys {
+			delete(m, key)
+		}
+		if md, _ := m["metadata"].(map[string]any); md != nil {
+			for _, key := range inPlaceRouteMetadataKeys {
+				delete(md, key)
+			}
+		}
+	}
+	if md, _ := m["metadata"].(map[string]any); md != nil {
+		for _, key := range inPlaceMetadataKeys {
+			delete(md, key)
+		}
+		if len(md) == 0 {
+			delete(m, "metadata")
+		}
+	}
+
+	data, err = json.Marshal(m)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
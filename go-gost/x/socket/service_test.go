@@ -0,0 +1,135 @@
+package socket
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-gost/core/logger"
+	"github.com/go-gost/x/config"
+	_ "github.com/go-gost/x/connector/http"
+	_ "github.com/go-gost/x/dialer/tcp"
+	_ "github.com/go-gost/x/handler/forward/local"
+	_ "github.com/go-gost/x/listener/tcp"
+	xlogger "github.com/go-gost/x/logger"
+	"github.com/go-gost/x/registry"
+)
+
+func init() {
+	logger.SetDefault(xlogger.NewLogger())
+}
+
+func testServiceConfig(name, addr string) config.ServiceConfig {
+	return config.ServiceConfig{
+		Name:     name,
+		Addr:     addr,
+		Handler:  &config.HandlerConfig{Type: "tcp"},
+		Listener: &config.ListenerConfig{Type: "tcp"},
+		Forwarder: &config.ForwarderConfig{
+			Nodes: []*config.ForwardNodeConfig{{Name: "target", Addr: "127.0.0.1:9"}},
+		},
+	}
+}
+
+func TestCanUpdateInPlace(t *testing.T) {
+	base := testServiceConfig("1_2_3_tcp", "0.0.0.0:30001")
+	base.Metadata = map[string]any{"drainGrace": "30s"}
+
+	cases := []struct {
+		name   string
+		mutate func(c *config.ServiceConfig)
+		want   bool
+	}{
+		{"unchanged", func(c *config.ServiceConfig) {}, true},
+		{"target", func(c *config.ServiceConfig) {
+			c.Forwarder = &config.ForwarderConfig{Nodes: []*config.ForwardNodeConfig{{Name: "target", Addr: "10.0.0.1:80"}}}
+		}, true},
+		{"limiter", func(c *config.ServiceConfig) { c.Limiter = "7" }, true},
+		{"drain grace", func(c *config.ServiceConfig) { c.Metadata = map[string]any{"drainGrace": "5s"} }, true},
+		{"protocol rules", func(c *config.ServiceConfig) {
+			c.Metadata = map[string]any{"drainGrace": "30s", "protocol.deny": []string{"ssh"}}
+		}, true},
+		{"interface without chain", func(c *config.ServiceConfig) {
+			c.Metadata = map[string]any{"drainGrace": "30s", "interface": "eth1"}
+		}, true},
+		{"addr", func(c *config.ServiceConfig) { c.Addr = "0.0.0.0:30002" }, false},
+		{"listener type", func(c *config.ServiceConfig) { c.Listener = &config.ListenerConfig{Type: "tls"} }, false},
+		{"admission", func(c *config.ServiceConfig) { c.Admissions = []string{"1_2_3_geo"} }, false},
+		{"listener chain", func(c *config.ServiceConfig) { c.Listener = &config.ListenerConfig{Type: "tcp", Chain: "c"} }, false},
+	}
+	for _, tc := range cases {
+		next := base
+		tc.mutate(&next)
+		if got := canUpdateInPlace(&base, &next); got != tc.want {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, got)
+		}
+	}
+
+	// 未指定监听器类型时按 tcp 处理
+	implicit := base
+	implicit.Listener = nil
+	if listenerIdentity(&implicit) != listenerIdentity(&base) {
+		t.Errorf("expected missing listener to equal tcp listener")
+	}
+}
+
+func TestListenAddrsConflict(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want bool
+	}{
+		{"[::]:30001", "0.0.0.0:30001", true},
+		{"0.0.0.0:30001", "10.0.0.1:30001", true},
+		{":30001", "[::1]:30001", true},
+		{"10.0.0.1:30001", "10.0.0.2:30001", false},
+		{"[::]:30001", "[::]:30002", false},
+		{"bad", "0.0.0.0:30001", true},
+	}
+	for _, tc := range cases {
+		if got := listenAddrsConflict(tc.a, tc.b); got != tc.want {
+			t.Errorf("%s -> %s: expected %v, got %v", tc.a, tc.b, tc.want, got)
+		}
+	}
+}
+
+func freeTCPPort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestUpdateServicesRebindsConflictingAddr(t *testing.T) {
+	port := strconv.Itoa(freeTCPPort(t))
+	name := "rebind_test_tcp"
+	if err := createServices(createServicesRequest{Data: []config.ServiceConfig{testServiceConfig(name, "0.0.0.0:"+port)}}); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	defer func() {
+		if svc := registry.ServiceRegistry().Get(name); svc != nil {
+			registry.ServiceRegistry().Unregister(name)
+			svc.Close()
+		}
+	}()
+
+	// 同一端口从通配地址改为具体地址，新旧监听冲突，应关闭旧服务后重建
+	if err := updateServices(updateServicesRequest{Data: []config.ServiceConfig{testServiceConfig(name, "127.0.0.1:"+port)}}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	svc := registry.ServiceRegistry().Get(name)
+	if svc == nil {
+		t.Fatalf("expected service to be registered")
+	}
+	if got := svc.Addr().String(); got != "127.0.0.1:"+port {
+		t.Fatalf("expected new addr, got %s", got)
+	}
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:"+port, time.Second)
+	if err != nil {
+		t.Fatalf("dial new listener: %v", err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,554 @@
+package socket
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-gost/core/chain"
+	"github.com/go-gost/core/logger"
+	"github.com/go-gost/core/service"
+	xchain "github.com/go-gost/x/chain"
+	"github.com/go-gost/x/config"
+	chainparser "github.com/go-gost/x/config/parsing/chain"
+	parser "github.com/go-gost/x/config/parsing/service"
+)
+
+const (
+	maxSpeedTestDuration   = 60  // 单次测速最长时间(秒)
+	maxSpeedTestStreams    = 8   // TCP 最大并发流
+	defaultSpeedTestUDPBw  = 100 // UDP 默认发送速率(Mbps)
+	speedTestUDPPacketSize = 1200
+	speedTestSinkGrace     = 30 * time.Second // 接收端在测试时长之外额外保留的时间
+)
+
+// StartSpeedTestSinkRequest 在本节点启动临时测速接收端
+type StartSpeedTestSinkRequest struct {
+	ID       string `json:"id"`
+	Token    string `json:"token"`
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"` // 隧道协议，即监听器类型(tls/ws/wss/mtls...)
+	Network  string `json:"network"`  // tcp / udp
+	Duration int    `json:"duration"` // 测试时长(秒)，接收端在此基础上额外保留一段时间后自动关闭
+}
+
+// StopSpeedTestSinkRequest 关闭测速接收端并取回接收统计
+type StopSpeedTestSinkRequest struct {
+	ID string `json:"id"`
+}
+
+// RunSpeedTestRequest 通过隧道协议向接收端发送测速流量
+type RunSpeedTestRequest struct {
+	ID        string `json:"id"`
+	Token     string `json:"token"`
+	Addr      string `json:"addr"` // 接收端节点地址 host:port
+	Protocol  string `json:"protocol"`
+	Network   string `json:"network"`
+	Duration  int    `json:"duration"`  // 发送时长(秒)
+	Streams   int    `json:"streams"`   // TCP 并发流数量
+	Bandwidth int    `json:"bandwidth"` // UDP 目标发送速率(Mbps)
+	Interface string `json:"interface,omitempty"`
+}
+
+// SpeedTestSinkReport 接收端统计
+type SpeedTestSinkReport struct {
+	ID             string  `json:"id"`
+	Network        string  `json:"network"`
+	Bytes          int64   `json:"bytes"`
+	DurationMs     int64   `json:"durationMs"` // 首个到最后一个数据到达的时间
+	ThroughputMbps float64 `json:"throughputMbps"`
+	Packets        int64   `json:"packets,omitempty"`    // UDP 收到的数据报
+	OutOfOrder     int64   `json:"outOfOrder,omitempty"` // UDP 乱序数据报
+	JitterMs       float64 `json:"jitterMs,omitempty"`   // RFC 3550 抖动估计
+}
+
+// SpeedTestSendReport 发送端统计
+type SpeedTestSendReport struct {
+	ID             string  `json:"id"`
+	Network        string  `json:"network"`
+	Bytes          int64   `json:"bytes"`
+	DurationMs     int64   `json:"durationMs"`
+	ThroughputMbps float64 `json:"throughputMbps"`
+	Streams        int     `json:"streams,omitempty"`
+	Packets        int64   `json:"packets,omitempty"`
+	Retransmits    int64   `json:"retransmits"`    // 测试期间本机 TCP 重传段数（系统级估计）
+	RetransmitRate float64 `json:"retransmitRate"` // 重传段占发送段的百分比
+}
+
+// speedTestSink 临时接收端：隧道监听器 + relay 转发到本地统计端口
+type speedTestSink struct {
+	id      string
+	network string
+	svc     service.Service
+	ln      net.Listener
+	pc      net.PacketConn
+	timer   *time.Timer
+
+	bytes   atomic.Int64
+	mu      sync.Mutex
+	first   time.Time
+	last    time.Time
+	packets int64
+	maxSeq  int64
+	ooo     int64
+	transit int64
+	jitter  float64
+}
+
+var speedTestSinks = struct {
+	sync.Mutex
+	m map[string]*speedTestSink
+}{m: make(map[string]*speedTestSink)}
+
+func speedTestServiceName(id string) string {
+	return "speedtest_" + id
+}
+
+func normalizeSpeedTestNetwork(network string) (string, error) {
+	network = strings.ToLower(strings.TrimSpace(network))
+	switch network {
+	case "":
+		return "tcp", nil
+	case "tcp", "udp":
+		return network, nil
+	}
+	return "", fmt.Errorf("不支持的测速网络类型: %s", network)
+}
+
+// handleStartSpeedTestSink 启动测速接收端，返回监听端口
+func (w *WebSocketReporter) handleStartSpeedTestSink(data interface{}) (map[string]interface{}, error) {
+	var req StartSpeedTestSinkRequest
+	if err := decodeCommandData(data, &req); err != nil {
+		return nil, err
+	}
+	req.ID = strings.TrimSpace(req.ID)
+	if req.ID == "" || req.Token == "" {
+		return nil, errors.New("测速 ID 和令牌不能为空")
+	}
+	if req.Port <= 0 || req.Port > 65535 {
+		return nil, errors.New("无效的端口号，范围应为1-65535")
+	}
+	if strings.TrimSpace(req.Protocol) == "" {
+		req.Protocol = "tls"
+	}
+	network, err := normalizeSpeedTestNetwork(req.Network)
+	if err != nil {
+		return nil, err
+	}
+	duration := clampInt(req.Duration, 10, maxSpeedTestDuration)
+
+	speedTestSinks.Lock()
+	defer speedTestSinks.Unlock()
+	if _, ok := speedTestSinks.m[req.ID]; ok {
+		return nil, fmt.Errorf("测速 %s 已存在", req.ID)
+	}
+
+	sink := &speedTestSink{id: req.ID, network: network}
+	var local string
+	if network == "udp" {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			return nil, fmt.Errorf("创建本地接收端失败: %v", err)
+		}
+		sink.pc = pc
+		local = pc.LocalAddr().String()
+		go sink.servePackets()
+	} else {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, fmt.Errorf("创建本地接收端失败: %v", err)
+		}
+		sink.ln = ln
+		local = ln.Addr().String()
+		go sink.serveStreams()
+	}
+
+	// relay 处于转发模式，只会转发到本地统计端口，并要求测速令牌
+	svcCfg := config.ServiceConfig{
+		Name: speedTestServiceName(req.ID),
+		Addr: fmt.Sprintf(":%d", req.Port),
+		Handler: &config.HandlerConfig{
+			Type:     "relay",
+			Auth:     &config.AuthConfig{Username: req.ID, Password: req.Token},
+			Metadata: map[string]any{"nodelay": true},
+		},
+		Listener: &config.ListenerConfig{Type: req.Protocol},
+		Forwarder: &config.ForwarderConfig{
+			Nodes: []*config.ForwardNodeConfig{{Name: "sink", Addr: local}},
+		},
+	}
+	svc, err := parser.ParseService(&svcCfg)
+	if err != nil {
+		sink.closeLocal()
+		return nil, fmt.Errorf("创建测速服务失败: %v", err)
+	}
+	sink.svc = svc
+	go svc.Serve()
+
+	sink.timer = time.AfterFunc(time.Duration(duration)*time.Second+speedTestSinkGrace, func() {
+		if s := takeSpeedTestSink(req.ID); s != nil {
+			s.close()
+			fmt.Printf("⏱️ 测速接收端 %s 超时自动关闭\n", req.ID)
+		}
+	})
+	speedTestSinks.m[req.ID] = sink
+
+	fmt.Printf("📶 测速接收端已启动: %s，端口: %d，协议: %s/%s\n", req.ID, req.Port, req.Protocol, network)
+	return map[string]interface{}{"id": req.ID, "port": req.Port}, nil
+}
+
+// handleStopSpeedTestSink 关闭测速接收端并返回接收统计
+func (w *WebSocketReporter) handleStopSpeedTestSink(data interface{}) (SpeedTestSinkReport, error) {
+	var req StopSpeedTestSinkRequest
+	if err := decodeCommandData(data, &req); err != nil {
+		return SpeedTestSinkReport{}, err
+	}
+	sink := takeSpeedTestSink(strings.TrimSpace(req.ID))
+	if sink == nil {
+		return SpeedTestSinkReport{}, fmt.Errorf("测速 %s 不存在或已结束", req.ID)
+	}
+	sink.close()
+	report := sink.report()
+	fmt.Printf("📶 测速接收端已关闭: %s，接收 %d 字节，%.2f Mbps\n", report.ID, report.Bytes, report.ThroughputMbps)
+	return report, nil
+}
+
+func takeSpeedTestSink(id string) *speedTestSink {
+	speedTestSinks.Lock()
+	defer speedTestSinks.Unlock()
+	sink := speedTestSinks.m[id]
+	delete(speedTestSinks.m, id)
+	return sink
+}
+
+func (s *speedTestSink) close() {
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	if s.svc != nil {
+		s.svc.Close()
+	}
+	s.closeLocal()
+}
+
+func (s *speedTestSink) closeLocal() {
+	if s.ln != nil {
+		s.ln.Close()
+	}
+	if s.pc != nil {
+		s.pc.Close()
+	}
+}
+
+func (s *speedTestSink) mark(now time.Time) {
+	if s.first.IsZero() {
+		s.first = now
+	}
+	s.last = now
+}
+
+func (s *speedTestSink) serveStreams() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			buf := make([]byte, 64*1024)
+			for {
+				n, err := conn.Read(buf)
+				if n > 0 {
+					s.bytes.Add(int64(n))
+					s.mu.Lock()
+					s.mark(time.Now())
+					s.mu.Unlock()
+				}
+				if err != nil {
+					return
+				}
+			}
+		}(conn)
+	}
+}
+
+// servePackets 统计 UDP 数据报。数据报头部为 8 字节序号和 8 字节发送时间(纳秒)，
+// 抖动按 RFC 3550 用相邻数据报的传输时间差平滑计算，两端时钟偏差会被抵消
+func (s *speedTestSink) servePackets() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, _, err := s.pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		now := time.Now()
+		s.bytes.Add(int64(n))
+		if n < 16 {
+			continue
+		}
+		seq := int64(binary.BigEndian.Uint64(buf[0:8]))
+		sent := int64(binary.BigEndian.Uint64(buf[8:16]))
+
+		s.mu.Lock()
+		s.mark(now)
+		s.packets++
+		if seq < s.maxSeq {
+			s.ooo++
+		} else {
+			s.maxSeq = seq + 1
+		}
+		transit := now.UnixNano() - sent
+		if s.packets > 1 {
+			d := float64(transit - s.transit)
+			if d < 0 {
+				d = -d
+			}
+			s.jitter += (d - s.jitter) / 16
+		}
+		s.transit = transit
+		s.mu.Unlock()
+	}
+}
+
+func (s *speedTestSink) report() SpeedTestSinkReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := SpeedTestSinkReport{
+		ID:      s.id,
+		Network: s.network,
+		Bytes:   s.bytes.Load(),
+	}
+	if !s.first.IsZero() {
+		r.DurationMs = s.last.Sub(s.first).Milliseconds()
+		r.ThroughputMbps = throughputMbps(r.Bytes, s.last.Sub(s.first))
+	}
+	if s.network == "udp" {
+		r.Packets = s.packets
+		r.OutOfOrder = s.ooo
+		r.JitterMs = s.jitter / float64(time.Millisecond)
+	}
+	return r
+}
+
+func throughputMbps(bytes int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(bytes) * 8 / d.Seconds() / 1e6
+}
+
+// handleRunSpeedTest 通过临时链路（relay + 隧道协议拨号器）向接收端发送测速流量
+func (w *WebSocketReporter) handleRunSpeedTest(data interface{}) (SpeedTestSendReport, error) {
+	var req RunSpeedTestRequest
+	if err := decodeCommandData(data, &req); err != nil {
+		return SpeedTestSendReport{}, err
+	}
+	if strings.TrimSpace(req.ID) == "" || req.Token == "" {
+		return SpeedTestSendReport{}, errors.New("测速 ID 和令牌不能为空")
+	}
+	if _, _, err := net.SplitHostPort(req.Addr); err != nil {
+		return SpeedTestSendReport{}, fmt.Errorf("无效的接收端地址: %s", req.Addr)
+	}
+	if strings.TrimSpace(req.Protocol) == "" {
+		req.Protocol = "tls"
+	}
+	network, err := normalizeSpeedTestNetwork(req.Network)
+	if err != nil {
+		return SpeedTestSendReport{}, err
+	}
+	duration := time.Duration(clampInt(req.Duration, 10, maxSpeedTestDuration)) * time.Second
+
+	hop := &config.HopConfig{
+		Name:      "hop_" + req.ID,
+		Interface: req.Interface,
+		Nodes: []*config.NodeConfig{{
+			Name: "sink",
+			Addr: req.Addr,
+			Connector: &config.ConnectorConfig{
+				Type:     "relay",
+				Auth:     &config.AuthConfig{Username: req.ID, Password: req.Token},
+				Metadata: map[string]any{"nodelay": true},
+			},
+			Dialer: &config.DialerConfig{Type: req.Protocol},
+		}},
+	}
+	ch, err := chainparser.ParseChain(&config.ChainConfig{
+		Name: speedTestServiceName(req.ID),
+		Hops: []*config.HopConfig{hop},
+	}, logger.Default())
+	if err != nil {
+		return SpeedTestSendReport{}, fmt.Errorf("创建测速链路失败: %v", err)
+	}
+	router := xchain.NewRouter(chain.ChainRouterOption(ch), chain.TimeoutRouterOption(10*time.Second))
+
+	fmt.Printf("📶 开始测速: %s -> %s，协议: %s/%s，时长: %s\n", req.ID, req.Addr, req.Protocol, network, duration)
+
+	var report SpeedTestSendReport
+	if network == "udp" {
+		report, err = runUDPSpeedTest(router, duration, req.Bandwidth)
+	} else {
+		report, err = runTCPSpeedTest(router, duration, clampInt(req.Streams, 1, maxSpeedTestStreams))
+	}
+	if err != nil {
+		return SpeedTestSendReport{}, err
+	}
+	report.ID = req.ID
+	report.Network = network
+	fmt.Printf("✅ 测速完成: %s，发送 %d 字节，%.2f Mbps\n", req.ID, report.Bytes, report.ThroughputMbps)
+	return report, nil
+}
+
+// 转发模式下 relay 忽略目标地址，这里只需一个合法地址
+const speedTestTarget = "127.0.0.1:9"
+
+func runTCPSpeedTest(router *xchain.Router, duration time.Duration, streams int) (SpeedTestSendReport, error) {
+	conns := make([]net.Conn, 0, streams)
+	defer func() {
+		for _, c := range conns {
+			c.Close()
+		}
+	}()
+	for i := 0; i < streams; i++ {
+		conn, err := router.Dial(context.Background(), "tcp", speedTestTarget)
+		if err != nil {
+			return SpeedTestSendReport{}, fmt.Errorf("连接测速接收端失败: %v", err)
+		}
+		conns = append(conns, conn)
+	}
+
+	outBefore, retransBefore, countersOK := tcpRetransCounters()
+	start := time.Now()
+	deadline := start.Add(duration)
+	var total atomic.Int64
+	var wg sync.WaitGroup
+	for _, conn := range conns {
+		wg.Add(1)
+		go func(conn net.Conn) {
+			defer wg.Done()
+			buf := make([]byte, 32*1024)
+			_ = conn.SetWriteDeadline(deadline)
+			for time.Now().Before(deadline) {
+				n, err := conn.Write(buf)
+				total.Add(int64(n))
+				if err != nil {
+					return
+				}
+			}
+		}(conn)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	report := SpeedTestSendReport{
+		Bytes:          total.Load(),
+		DurationMs:     elapsed.Milliseconds(),
+		ThroughputMbps: throughputMbps(total.Load(), elapsed),
+		Streams:        streams,
+	}
+	if outAfter, retransAfter, ok := tcpRetransCounters(); ok && countersOK {
+		report.Retransmits = retransAfter - retransBefore
+		if sent := outAfter - outBefore; sent > 0 {
+			report.RetransmitRate = float64(report.Retransmits) / float64(sent) * 100
+		}
+	}
+	if report.Bytes == 0 {
+		return report, errors.New("测速期间未能发送任何数据")
+	}
+	return report, nil
+}
+
+func runUDPSpeedTest(router *xchain.Router, duration time.Duration, bandwidth int) (SpeedTestSendReport, error) {
+	if bandwidth <= 0 {
+		bandwidth = defaultSpeedTestUDPBw
+	}
+	conn, err := router.Dial(context.Background(), "udp", speedTestTarget)
+	if err != nil {
+		return SpeedTestSendReport{}, fmt.Errorf("连接测速接收端失败: %v", err)
+	}
+	defer conn.Close()
+
+	// 每毫秒按目标速率补发数据报
+	perMs := float64(bandwidth) * 1e6 / 8 / speedTestUDPPacketSize / 1000
+	buf := make([]byte, speedTestUDPPacketSize)
+	start := time.Now()
+	deadline := start.Add(duration)
+	_ = conn.SetWriteDeadline(deadline.Add(time.Second))
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+
+	var seq, bytes int64
+	for now := start; now.Before(deadline); now = <-ticker.C {
+		due := int64(perMs * float64(now.Sub(start)) / float64(time.Millisecond))
+		for ; seq <= due; seq++ {
+			binary.BigEndian.PutUint64(buf[0:8], uint64(seq))
+			binary.BigEndian.PutUint64(buf[8:16], uint64(time.Now().UnixNano()))
+			n, err := conn.Write(buf)
+			bytes += int64(n)
+			if err != nil {
+				return SpeedTestSendReport{}, fmt.Errorf("发送测速数据失败: %v", err)
+			}
+		}
+	}
+	elapsed := time.Since(start)
+	return SpeedTestSendReport{
+		Bytes:          bytes,
+		DurationMs:     elapsed.Milliseconds(),
+		ThroughputMbps: throughputMbps(bytes, elapsed),
+		Packets:        seq,
+	}, nil
+}
+
+// tcpRetransCounters 读取 /proc/net/snmp 中的 TCP 发送段数和重传段数
+func tcpRetransCounters() (outSegs, retransSegs int64, ok bool) {
+	f, err := os.Open("/proc/net/snmp")
+	if err != nil {
+		return 0, 0, false
+	}
+	defer f.Close()
+
+	var header []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] != "Tcp:" {
+			continue
+		}
+		if header == nil {
+			header = fields
+			continue
+		}
+		for i := 1; i < len(fields) && i < len(header); i++ {
+			v, _ := strconv.ParseInt(fields[i], 10, 64)
+			switch header[i] {
+			case "OutSegs":
+				outSegs = v
+			case "RetransSegs":
+				retransSegs = v
+			}
+		}
+		return outSegs, retransSegs, true
+	}
+	return 0, 0, false
+}
+
+// decodeCommandData 将命令数据解析为请求结构体
+func decodeCommandData(data interface{}, v interface{}) error {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("序列化命令数据失败: %v", err)
+	}
+	if err := json.Unmarshal(jsonData, v); err != nil {
+		return fmt.Errorf("解析命令数据失败: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package socket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/go-gost/core/observer/stats"
+	"github.com/go-gost/x/config"
+	"github.com/go-gost/x/registry"
+	"github.com/go-gost/x/service"
+)
+
+// DefaultStatusSocket 本地状态接口默认监听的 unix socket
+const DefaultStatusSocket = "/etc/flux_agent/flux_agent.sock"
+
+// AgentStatus 本地状态接口 GET /status 的响应
+type AgentStatus struct {
+	Version   string                     `json:"version"`
+	PID       int                        `json:"pid"`
+	StartedAt int64                      `json:"startedAt"` // 毫秒时间戳
+	Panel     PanelStatus                `json:"panel"`
+	Traffic   service.TrafficReportState `json:"traffic"`
+	Services  []ServiceStatus            `json:"services"`
+	Limiters  []LimiterStatus            `json:"limiters"`
+	Upgrade   UpgradeStatus              `json:"upgrade"`
+}
+
+// PanelStatus 与面板的 WebSocket 连接状态，时间均为毫秒时间戳
+type PanelStatus struct {
+	Addr           string `json:"addr"`
+	Connected      bool   `json:"connected"`
+	ConnectedAt    int64  `json:"connectedAt,omitempty"`
+	DisconnectedAt int64  `json:"disconnectedAt,omitempty"`
+	LastError      string `json:"lastError,omitempty"`
+	LastErrorAt    int64  `json:"lastErrorAt,omitempty"`
+}
+
+// ServiceStatus 运行中的服务，字节数为服务启动以来的累计值
+type ServiceStatus struct {
+	Name         string `json:"name"`
+	Addr         string `json:"addr"`
+	Handler      string `json:"handler,omitempty"`
+	Listener     string `json:"listener,omitempty"`
+	Limiter      string `json:"limiter,omitempty"`
+	State        string `json:"state"`
+	CurrentConns uint64 `json:"currentConns"`
+	TotalConns   uint64 `json:"totalConns"`
+	TotalErrs    uint64 `json:"totalErrs"`
+	InputBytes   uint64 `json:"inputBytes"`
+	OutputBytes  uint64 `json:"outputBytes"`
+}
+
+// LimiterStatus 已加载的限速器
+type LimiterStatus struct {
+	Name   string   `json:"name"`
+	Limits []string `json:"limits"`
+}
+
+// UpgradeStatus 升级状态：Pending 为等待新版本确认的升级标记，Progress 为本进程最近一次升级的进度
+type UpgradeStatus struct {
+	Pending  *upgradePending  `json:"pending,omitempty"`
+	Progress *UpgradeProgress `json:"progress,omitempty"`
+}
+
+// StatusServer 只在本机 unix socket 上提供的状态接口，供面板不可达时在节点上排查
+type StatusServer struct {
+	path      string
+	reporter  *WebSocketReporter
+	startedAt time.Time
+	listener  net.Listener
+	server    *http.Server
+}
+
+// StartStatusServer 在 path 上启动状态接口，path 为空时使用默认路径。
+// 旧进程遗留的 socket 文件会被删除，socket 只允许属主访问
+func StartStatusServer(path string, reporter *WebSocketReporter) (*StatusServer, error) {
+	if path == "" {
+		path = DefaultStatusSocket
+	}
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("状态接口已被其它进程占用: %s", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("删除旧的 socket 文件失败: %v", err)
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+
+	s := &StatusServer{
+		path:      path,
+		reporter:  reporter,
+		startedAt: time.Now(),
+		listener:  ln,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.handleStatus)
+	s.server = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := s.server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Printf("⚠️ 本地状态接口已停止: %v\n", err)
+		}
+	}()
+	return s, nil
+}
+
+// Close 停止状态接口并删除 socket 文件
+func (s *StatusServer) Close() error {
+	if s == nil {
+		return nil
+	}
+	err := s.server.Close()
+	os.Remove(s.path)
+	return err
+}
+
+func (s *StatusServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(s.collect())
+}
+
+func (s *StatusServer) collect() AgentStatus {
+	status := AgentStatus{
+		PID:       os.Getpid(),
+		StartedAt: s.startedAt.UnixMilli(),
+		Traffic:   service.GetTrafficReportState(),
+		Services:  collectServiceStatus(),
+		Limiters:  collectLimiterStatus(),
+		Upgrade: UpgradeStatus{
+			Pending:  readUpgradePending(),
+			Progress: getUpgradeProgress(),
+		},
+	}
+	if w := s.reporter; w != nil {
+		status.Version = w.version
+		w.connMutex.Lock()
+		status.Panel = PanelStatus{
+			Addr:           w.addr,
+			Connected:      w.conn != nil && w.connected,
+			ConnectedAt:    unixMilli(w.connectedAt),
+			DisconnectedAt: unixMilli(w.disconnectedAt),
+			LastError:      w.lastError,
+			LastErrorAt:    unixMilli(w.lastErrorAt),
+		}
+		w.connMutex.Unlock()
+	}
+	return status
+}
+
+func unixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+// collectServiceStatus 按名称排序列出注册表中的服务，类型和限速器取自当前配置
+func collectServiceStatus() []ServiceStatus {
+	configs := make(map[string]*config.ServiceConfig)
+	for _, c := range config.Global().Services {
+		if c != nil {
+			configs[c.Name] = c
+		}
+	}
+
+	list := make([]ServiceStatus, 0)
+	for name, svc := range registry.ServiceRegistry().GetAll() {
+		item := ServiceStatus{Name: name}
+		if addr := svc.Addr(); addr != nil {
+			item.Addr = addr.String()
+		}
+		if c := configs[name]; c != nil {
+			if c.Handler != nil {
+				item.Handler = c.Handler.Type
+			}
+			if c.Listener != nil {
+				item.Listener = c.Listener.Type
+			}
+			item.Limiter = c.Limiter
+		}
+		if ss, ok := svc.(interface{ Status() *service.Status }); ok && ss.Status() != nil {
+			item.State = string(ss.Status().State())
+			if st := ss.Status().Stats(); st != nil {
+				item.CurrentConns = st.Get(stats.KindCurrentConns)
+				item.TotalConns = st.Get(stats.KindTotalConns)
+				item.TotalErrs = st.Get(stats.KindTotalErrs)
+			}
+		}
+		if tt, ok := svc.(interface{ TrafficTotals() (uint64, uint64) }); ok {
+			item.InputBytes, item.OutputBytes = tt.TrafficTotals()
+		}
+		list = append(list, item)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// collectLimiterStatus 列出当前配置中的限速器
+func collectLimiterStatus() []LimiterStatus {
+	list := make([]LimiterStatus, 0)
+	for _, c := range config.Global().Limiters {
+		if c == nil || !registry.TrafficLimiterRegistry().IsRegistered(c.Name) {
+			continue
+		}
+		limits := c.Limits
+		if limits == nil {
+			limits = make([]string, 0)
+		}
+		list = append(list, LimiterStatus{Name: c.Name, Limits: limits})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
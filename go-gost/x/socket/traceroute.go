@@ -0,0 +1,573 @@
+package socket
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	defaultTraceCount   = 3
+	maxTraceCount       = 20
+	defaultTraceMaxHops = 30
+	maxTraceMaxHops     = 64
+	defaultTraceTimeout = 1000 // 单个探测超时(毫秒)
+	maxTraceTimeout     = 5000
+	traceBaseUDPPort    = 33434
+)
+
+// TracerouteRequest 路由追踪请求，按 MTR 的方式对每一跳重复探测 Count 轮
+type TracerouteRequest struct {
+	Target    string `json:"target"`
+	Port      int    `json:"port"`     // tcp 必填；udp 为空时使用 33434 起的递增端口
+	Protocol  string `json:"protocol"` // icmp / udp / tcp，默认 icmp
+	Count     int    `json:"count"`
+	MaxHops   int    `json:"maxHops"`
+	Timeout   int    `json:"timeout"` // 单个探测超时(毫秒)
+	RequestId string `json:"requestId,omitempty"`
+}
+
+// TracerouteHop 单跳统计，只包含 IP，不做 ASN/地理位置查询
+type TracerouteHop struct {
+	TTL       int      `json:"ttl"`
+	IP        string   `json:"ip,omitempty"`  // 首个响应的地址，全部超时为空
+	IPs       []string `json:"ips,omitempty"` // 多路径时本跳出现过的全部地址
+	Sent      int      `json:"sent"`
+	Received  int      `json:"received"`
+	Loss      float64  `json:"loss"`      // 丢包率(%)
+	AvgTime   float64  `json:"avgTime"`   // 平均往返时间(ms)
+	BestTime  float64  `json:"bestTime"`  // 最小往返时间(ms)
+	WorstTime float64  `json:"worstTime"` // 最大往返时间(ms)
+}
+
+// TracerouteResponse 路由追踪结果
+type TracerouteResponse struct {
+	Target       string          `json:"target"`
+	ResolvedIP   string          `json:"resolvedIp,omitempty"`
+	Protocol     string          `json:"protocol"`
+	Port         int             `json:"port,omitempty"`
+	Success      bool            `json:"success"`
+	Reached      bool            `json:"reached"` // 是否收到目标本身的响应
+	Hops         []TracerouteHop `json:"hops"`
+	ErrorMessage string          `json:"errorMessage,omitempty"`
+	RequestId    string          `json:"requestId,omitempty"`
+}
+
+// traceReply 一个探测收到的响应
+type traceReply struct {
+	ip      string
+	rtt     time.Duration
+	reached bool
+}
+
+type traceProbe struct {
+	ttl  int
+	sent time.Time
+}
+
+// tracer 一次路由追踪的运行状态。ICMP 差错报文统一从原始套接字读取，
+// 按原始报文中的 ICMP 序号或 UDP/TCP 源端口匹配回对应的探测
+type tracer struct {
+	protocol string
+	dst      net.IP
+	v6       bool
+	port     int
+	timeout  time.Duration
+	id       int
+
+	conn *icmp.PacketConn
+
+	mu      sync.Mutex
+	pending map[int]traceProbe
+	replies map[int]traceReply
+	notify  chan struct{}
+}
+
+// handleTraceroute 处理路由追踪诊断命令
+func (w *WebSocketReporter) handleTraceroute(data interface{}) (TracerouteResponse, error) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return TracerouteResponse{}, fmt.Errorf("序列化路由追踪数据失败: %v", err)
+	}
+
+	var req TracerouteRequest
+	if err := json.Unmarshal(jsonData, &req); err != nil {
+		return TracerouteResponse{}, fmt.Errorf("解析路由追踪请求失败: %v", err)
+	}
+
+	req.Protocol = strings.ToLower(strings.TrimSpace(req.Protocol))
+	if req.Protocol == "" {
+		req.Protocol = "icmp"
+	}
+	response := TracerouteResponse{
+		Target:    req.Target,
+		Protocol:  req.Protocol,
+		Port:      req.Port,
+		RequestId: req.RequestId,
+		Hops:      []TracerouteHop{},
+	}
+	fail := func(msg string) (TracerouteResponse, error) {
+		response.ErrorMessage = msg
+		return response, nil
+	}
+
+	if net.ParseIP(req.Target) == nil && !isValidHostname(req.Target) {
+		return fail("无效的IP地址或主机名")
+	}
+	switch req.Protocol {
+	case "icmp", "udp":
+		if req.Port < 0 || req.Port > 65535 {
+			return fail("无效的端口号，范围应为1-65535")
+		}
+	case "tcp":
+		if req.Port <= 0 || req.Port > 65535 {
+			return fail("TCP 路由追踪需要有效的端口号")
+		}
+	default:
+		return fail("不支持的探测协议: " + req.Protocol)
+	}
+
+	req.Count = clampInt(req.Count, defaultTraceCount, maxTraceCount)
+	req.MaxHops = clampInt(req.MaxHops, defaultTraceMaxHops, maxTraceMaxHops)
+	req.Timeout = clampInt(req.Timeout, defaultTraceTimeout, maxTraceTimeout)
+
+	dst, err := resolveTraceTarget(req.Target)
+	if err != nil {
+		return fail(err.Error())
+	}
+	response.ResolvedIP = dst.String()
+
+	fmt.Printf("🔍 开始路由追踪: %s (%s)，协议: %s，轮数: %d，最大跳数: %d\n",
+		req.Target, dst, req.Protocol, req.Count, req.MaxHops)
+
+	hops, reached, err := traceRoute(req.Protocol, dst, req.Port, req.Count, req.MaxHops,
+		time.Duration(req.Timeout)*time.Millisecond)
+	if err != nil {
+		return fail(err.Error())
+	}
+	response.Success = true
+	response.Reached = reached
+	response.Hops = hops
+
+	fmt.Printf("✅ 路由追踪完成: %s，%d 跳，到达目标: %v\n", req.Target, len(hops), reached)
+	return response, nil
+}
+
+func clampInt(v, def, max int) int {
+	if v <= 0 {
+		return def
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// resolveTraceTarget 解析目标地址，域名取第一个解析结果
+func resolveTraceTarget(target string) (net.IP, error) {
+	if ip := net.ParseIP(target); ip != nil {
+		return ip, nil
+	}
+	addrs, err := net.LookupIP(target)
+	if err != nil {
+		return nil, fmt.Errorf("DNS解析失败: %v", err)
+	}
+	if len(addrs) == 0 {
+		return nil, errors.New("DNS解析未返回任何IP地址")
+	}
+	return addrs[0], nil
+}
+
+// traceRoute 执行 count 轮探测，每轮同时发出 TTL 1..maxHops 的探测包；
+// 首次到达目标后，后续轮次只探测到目标所在的跳数
+func traceRoute(protocol string, dst net.IP, port, count, maxHops int, timeout time.Duration) ([]TracerouteHop, bool, error) {
+	t := &tracer{
+		protocol: protocol,
+		dst:      dst,
+		v6:       dst.To4() == nil,
+		port:     port,
+		timeout:  timeout,
+		id:       rand.Intn(0xffff),
+		notify:   make(chan struct{}, 1),
+	}
+	network, address := "ip4:icmp", "0.0.0.0"
+	if t.v6 {
+		network, address = "ip6:ipv6-icmp", "::"
+	}
+	conn, err := icmp.ListenPacket(network, address)
+	if err != nil {
+		return nil, false, fmt.Errorf("创建 ICMP 原始套接字失败（需要 root 或 CAP_NET_RAW 权限）: %v", err)
+	}
+	t.conn = conn
+	defer conn.Close()
+	go t.readLoop()
+
+	stats := make([]traceHopStats, maxHops)
+	reachedTTL := 0
+	for round := 0; round < count; round++ {
+		limit := maxHops
+		if reachedTTL > 0 {
+			limit = reachedTTL
+		}
+		replies := t.runRound(round, limit)
+		for ttl := 1; ttl <= limit; ttl++ {
+			reply, ok := replies[ttl]
+			stats[ttl-1].add(reply, ok)
+			if ok && reply.reached && (reachedTTL == 0 || ttl < reachedTTL) {
+				reachedTTL = ttl
+			}
+		}
+		if round < count-1 {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+
+	last := reachedTTL
+	if last == 0 {
+		// 未到达目标时保留到最后一个有响应的跳，再多保留一跳用于显示中断位置
+		for i := maxHops - 1; i >= 0; i-- {
+			if stats[i].received > 0 {
+				last = i + 1
+				break
+			}
+		}
+		if last < maxHops {
+			last++
+		}
+	}
+	hops := make([]TracerouteHop, 0, last)
+	for i := 0; i < last; i++ {
+		hops = append(hops, stats[i].hop(i+1))
+	}
+	return hops, reachedTTL > 0, nil
+}
+
+// runRound 发出一轮探测并等待响应，返回 TTL -> 响应
+func (t *tracer) runRound(round, limit int) map[int]traceReply {
+	t.mu.Lock()
+	t.pending = make(map[int]traceProbe)
+	t.replies = make(map[int]traceReply)
+	t.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for ttl := 1; ttl <= limit; ttl++ {
+		switch t.protocol {
+		case "icmp":
+			t.sendICMP(round*maxTraceMaxHops+ttl, ttl)
+		case "udp":
+			wg.Add(1)
+			go func(ttl int) {
+				defer wg.Done()
+				t.probeUDP(ttl)
+			}(ttl)
+		case "tcp":
+			wg.Add(1)
+			go func(ttl int) {
+				defer wg.Done()
+				t.probeTCP(ttl)
+			}(ttl)
+		}
+	}
+
+	deadline := time.NewTimer(t.timeout)
+	defer deadline.Stop()
+wait:
+	for !t.roundDone(limit) {
+		select {
+		case <-t.notify:
+		case <-deadline.C:
+			break wait
+		}
+	}
+	wg.Wait()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	replies := t.replies
+	t.pending = nil
+	t.replies = nil
+	return replies
+}
+
+// roundDone 目标之前的每一跳都已响应时提前结束本轮
+func (t *tracer) roundDone(limit int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for ttl := 1; ttl <= limit; ttl++ {
+		reply, ok := t.replies[ttl]
+		if !ok {
+			return false
+		}
+		if reply.reached {
+			return true
+		}
+	}
+	return true
+}
+
+func (t *tracer) register(key, ttl int) {
+	t.mu.Lock()
+	if t.pending != nil {
+		t.pending[key] = traceProbe{ttl: ttl, sent: time.Now()}
+	}
+	t.mu.Unlock()
+}
+
+// resolve 将响应归属到 key 对应的探测，同一跳只记录第一个响应
+func (t *tracer) resolve(key int, ip string, reached bool) {
+	t.mu.Lock()
+	probe, ok := t.pending[key]
+	if ok {
+		delete(t.pending, key)
+		if _, exists := t.replies[probe.ttl]; !exists {
+			t.replies[probe.ttl] = traceReply{ip: ip, rtt: time.Since(probe.sent), reached: reached}
+		}
+	}
+	t.mu.Unlock()
+	if ok {
+		select {
+		case t.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (t *tracer) sendICMP(seq, ttl int) {
+	var typ icmp.Type = ipv4.ICMPTypeEcho
+	if t.v6 {
+		typ = ipv6.ICMPTypeEchoRequest
+	}
+	msg := icmp.Message{
+		Type: typ,
+		Body: &icmp.Echo{ID: t.id, Seq: seq & 0xffff, Data: []byte("flux-trace")},
+	}
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return
+	}
+	if t.v6 {
+		err = t.conn.IPv6PacketConn().SetHopLimit(ttl)
+	} else {
+		err = t.conn.IPv4PacketConn().SetTTL(ttl)
+	}
+	if err != nil {
+		return
+	}
+	t.register(seq&0xffff, ttl)
+	_, _ = t.conn.WriteTo(b, &net.IPAddr{IP: t.dst})
+}
+
+// probeUDP 每个探测使用独立的 UDP 套接字，以源端口区分；目标端口有应答时视为到达
+func (t *tracer) probeUDP(ttl int) {
+	network := "udp4"
+	if t.v6 {
+		network = "udp6"
+	}
+	conn, err := net.ListenPacket(network, "")
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	if t.v6 {
+		err = ipv6.NewPacketConn(conn).SetHopLimit(ttl)
+	} else {
+		err = ipv4.NewPacketConn(conn).SetTTL(ttl)
+	}
+	if err != nil {
+		return
+	}
+	port := t.port
+	if port == 0 {
+		port = traceBaseUDPPort + ttl
+	}
+	key := conn.LocalAddr().(*net.UDPAddr).Port
+	t.register(key, ttl)
+	if _, err := conn.WriteTo([]byte("flux-trace"), &net.UDPAddr{IP: t.dst, Port: port}); err != nil {
+		return
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(t.timeout))
+	buf := make([]byte, 512)
+	if _, addr, err := conn.ReadFrom(buf); err == nil {
+		t.resolve(key, addr.(*net.UDPAddr).IP.String(), true)
+	}
+}
+
+// probeTCP 以指定 TTL 发起 TCP 连接，握手成功或被 RST 拒绝都说明已到达目标
+func (t *tracer) probeTCP(ttl int) {
+	key := 0
+	dialer := net.Dialer{
+		Timeout: t.timeout,
+		Control: func(network, address string, c syscall.RawConn) error {
+			var sockErr error
+			if err := c.Control(func(fd uintptr) {
+				key, sockErr = setProbeSocket(fd, t.v6, ttl)
+				if sockErr == nil {
+					t.register(key, ttl)
+				}
+			}); err != nil {
+				return err
+			}
+			return sockErr
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(t.dst.String(), fmt.Sprintf("%d", t.port)))
+	if err == nil {
+		conn.Close()
+		t.resolve(key, t.dst.String(), true)
+		return
+	}
+	if key != 0 && errors.Is(err, syscall.ECONNREFUSED) {
+		t.resolve(key, t.dst.String(), true)
+	}
+}
+
+// readLoop 读取 ICMP 报文直到套接字关闭
+func (t *tracer) readLoop() {
+	proto := 1
+	if t.v6 {
+		proto = 58
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := t.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		msg, err := icmp.ParseMessage(proto, buf[:n])
+		if err != nil {
+			continue
+		}
+		from := peer.(*net.IPAddr).IP
+		fromTarget := from.Equal(t.dst)
+
+		switch body := msg.Body.(type) {
+		case *icmp.Echo:
+			if t.protocol == "icmp" && body.ID == t.id &&
+				(msg.Type == ipv4.ICMPTypeEchoReply || msg.Type == ipv6.ICMPTypeEchoReply) {
+				t.resolve(body.Seq, from.String(), true)
+			}
+		case *icmp.TimeExceeded:
+			if key, ok := t.quotedKey(body.Data); ok {
+				t.resolve(key, from.String(), false)
+			}
+		case *icmp.DstUnreach:
+			// 目标返回的不可达（如 UDP 端口不可达）表示已到达；中间路由器返回的不可达记为该跳的响应
+			if key, ok := t.quotedKey(body.Data); ok {
+				t.resolve(key, from.String(), fromTarget)
+			}
+		}
+	}
+}
+
+// quotedKey 从 ICMP 差错报文引用的原始报文中取出探测标识
+func (t *tracer) quotedKey(data []byte) (int, bool) {
+	var proto int
+	var dst net.IP
+	var transport []byte
+	if t.v6 {
+		if len(data) < 40 {
+			return 0, false
+		}
+		proto = int(data[6])
+		dst = net.IP(data[24:40])
+		transport = data[40:]
+	} else {
+		if len(data) < 20 {
+			return 0, false
+		}
+		ihl := int(data[0]&0x0f) * 4
+		if ihl < 20 || len(data) < ihl {
+			return 0, false
+		}
+		proto = int(data[9])
+		dst = net.IP(data[16:20])
+		transport = data[ihl:]
+	}
+	if !dst.Equal(t.dst) || len(transport) < 8 {
+		return 0, false
+	}
+
+	switch t.protocol {
+	case "icmp":
+		if (t.v6 && proto != 58) || (!t.v6 && proto != 1) {
+			return 0, false
+		}
+		if int(binary.BigEndian.Uint16(transport[4:6])) != t.id {
+			return 0, false
+		}
+		return int(binary.BigEndian.Uint16(transport[6:8])), true
+	case "udp":
+		if proto != 17 {
+			return 0, false
+		}
+		return int(binary.BigEndian.Uint16(transport[0:2])), true
+	case "tcp":
+		if proto != 6 {
+			return 0, false
+		}
+		return int(binary.BigEndian.Uint16(transport[0:2])), true
+	}
+	return 0, false
+}
+
+// traceHopStats 单跳的累计统计
+type traceHopStats struct {
+	sent     int
+	received int
+	total    time.Duration
+	best     time.Duration
+	worst    time.Duration
+	ips      []string
+}
+
+func (s *traceHopStats) add(reply traceReply, ok bool) {
+	s.sent++
+	if !ok {
+		return
+	}
+	s.received++
+	s.total += reply.rtt
+	if s.best == 0 || reply.rtt < s.best {
+		s.best = reply.rtt
+	}
+	if reply.rtt > s.worst {
+		s.worst = reply.rtt
+	}
+	for _, ip := range s.ips {
+		if ip == reply.ip {
+			return
+		}
+	}
+	s.ips = append(s.ips, reply.ip)
+}
+
+func (s *traceHopStats) hop(ttl int) TracerouteHop {
+	hop := TracerouteHop{TTL: ttl, Sent: s.sent, Received: s.received, Loss: 100}
+	if s.sent > 0 {
+		hop.Loss = float64(s.sent-s.received) / float64(s.sent) * 100
+	}
+	if s.received > 0 {
+		hop.IP = s.ips[0]
+		if len(s.ips) > 1 {
+			hop.IPs = s.ips
+		}
+		hop.AvgTime = s.total.Seconds() * 1000 / float64(s.received)
+		hop.BestTime = s.best.Seconds() * 1000
+		hop.WorstTime = s.worst.Seconds() * 1000
+	}
+	return hop
+}
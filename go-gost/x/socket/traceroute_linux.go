@@ -0,0 +1,36 @@
+package socket
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// setProbeSocket 在 connect 之前设置 TTL/HopLimit，并绑定临时端口，
+// 返回本地端口用于匹配 ICMP 差错报文中引用的原始 TCP 头
+func setProbeSocket(fd uintptr, v6 bool, ttl int) (int, error) {
+	var sa unix.Sockaddr
+	if v6 {
+		if err := unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_UNICAST_HOPS, ttl); err != nil {
+			return 0, err
+		}
+		sa = &unix.SockaddrInet6{}
+	} else {
+		if err := unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TTL, ttl); err != nil {
+			return 0, err
+		}
+		sa = &unix.SockaddrInet4{}
+	}
+	if err := unix.Bind(int(fd), sa); err != nil {
+		return 0, err
+	}
+	bound, err := unix.Getsockname(int(fd))
+	if err != nil {
+		return 0, err
+	}
+	switch addr := bound.(type) {
+	case *unix.SockaddrInet4:
+		return addr.Port, nil
+	case *unix.SockaddrInet6:
+		return addr.Port, nil
+	}
+	return 0, unix.EAFNOSUPPORT
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package socket
+
+import "errors"
+
+func setProbeSocket(fd uintptr, v6 bool, ttl int) (int, error) {
+	return 0, errors.New("当前平台不支持 TCP 路由追踪")
+}
@@ -0,0 +1,205 @@
+package socket
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultUDPProbeCount   = 4
+	maxUDPProbeCount       = 20
+	defaultUDPProbeTimeout = 2000 // 单次探测超时(毫秒)
+	maxUDPProbeTimeout     = 10000
+	defaultUDPProbePayload = "flux-probe"
+	stunMagicCookie        = 0x2112A442
+)
+
+// UdpProbeRequest UDP 探测请求。UDP 没有握手，只有收到应答才算可达：
+// echo 模式发送自定义负载并等待任意应答，dns/stun 模式发送标准请求并校验应答
+type UdpProbeRequest struct {
+	IP         string `json:"ip"`
+	Port       int    `json:"port"`
+	Mode       string `json:"mode"`       // echo / dns / stun，默认 echo
+	Payload    string `json:"payload"`    // echo 模式的负载文本
+	PayloadHex string `json:"payloadHex"` // echo 模式的十六进制负载，优先于 payload
+	Count      int    `json:"count"`
+	Timeout    int    `json:"timeout"` // 超时时间(毫秒)
+	RequestId  string `json:"requestId,omitempty"`
+}
+
+// UdpProbeResponse UDP 探测结果，字段与 TcpPingResponse 保持一致以便面板复用
+type UdpProbeResponse struct {
+	IP           string  `json:"ip"`
+	Port         int     `json:"port"`
+	Mode         string  `json:"mode"`
+	Success      bool    `json:"success"`
+	AverageTime  float64 `json:"averageTime"` // 平均往返时间(ms)
+	PacketLoss   float64 `json:"packetLoss"`  // 无应答比例(%)
+	Received     int     `json:"received"`
+	Refused      bool    `json:"refused"` // 收到 ICMP 端口不可达，目标端口确定未开放
+	ErrorMessage string  `json:"errorMessage,omitempty"`
+	RequestId    string  `json:"requestId,omitempty"`
+}
+
+// handleUdpProbe 处理 UDP 探测诊断命令
+func (w *WebSocketReporter) handleUdpProbe(data interface{}) (UdpProbeResponse, error) {
+	var req UdpProbeRequest
+	if err := decodeCommandData(data, &req); err != nil {
+		return UdpProbeResponse{}, err
+	}
+
+	req.Mode = strings.ToLower(strings.TrimSpace(req.Mode))
+	if req.Mode == "" || req.Mode == "udp" {
+		req.Mode = "echo"
+	}
+	response := UdpProbeResponse{
+		IP:        req.IP,
+		Port:      req.Port,
+		Mode:      req.Mode,
+		RequestId: req.RequestId,
+	}
+	fail := func(msg string) (UdpProbeResponse, error) {
+		response.PacketLoss = 100
+		response.ErrorMessage = msg
+		return response, nil
+	}
+
+	if net.ParseIP(req.IP) == nil && !isValidHostname(req.IP) {
+		return fail("无效的IP地址或主机名")
+	}
+	if req.Port <= 0 || req.Port > 65535 {
+		return fail("无效的端口号，范围应为1-65535")
+	}
+	payload := []byte(req.Payload)
+	if req.PayloadHex != "" {
+		b, err := hex.DecodeString(strings.TrimSpace(req.PayloadHex))
+		if err != nil {
+			return fail("无效的十六进制负载")
+		}
+		payload = b
+	}
+	if len(payload) == 0 {
+		payload = []byte(defaultUDPProbePayload)
+	}
+	if len(payload) > 1400 {
+		return fail("探测负载不能超过1400字节")
+	}
+	switch req.Mode {
+	case "echo", "dns", "stun":
+	default:
+		return fail("不支持的UDP探测模式: " + req.Mode)
+	}
+	req.Count = clampInt(req.Count, defaultUDPProbeCount, maxUDPProbeCount)
+	req.Timeout = clampInt(req.Timeout, defaultUDPProbeTimeout, maxUDPProbeTimeout)
+
+	target := net.JoinHostPort(req.IP, strconv.Itoa(req.Port))
+	fmt.Printf("🔍 开始UDP探测: %s，模式: %s，次数: %d，超时: %dms\n", target, req.Mode, req.Count, req.Timeout)
+
+	conn, err := net.Dial("udp", target)
+	if err != nil {
+		return fail(fmt.Sprintf("创建UDP连接失败: %v", err))
+	}
+	defer conn.Close()
+
+	timeout := time.Duration(req.Timeout) * time.Millisecond
+	var total time.Duration
+	buf := make([]byte, 4096)
+	for i := 0; i < req.Count; i++ {
+		probe, match := buildUDPProbe(req.Mode, payload)
+		start := time.Now()
+		if _, err := conn.Write(probe); err != nil {
+			if errors.Is(err, syscall.ECONNREFUSED) {
+				response.Refused = true
+				break
+			}
+			continue
+		}
+		_ = conn.SetReadDeadline(start.Add(timeout))
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				if errors.Is(err, syscall.ECONNREFUSED) {
+					response.Refused = true
+				}
+				break
+			}
+			if match(buf[:n]) {
+				rtt := time.Since(start)
+				total += rtt
+				response.Received++
+				fmt.Printf("  第%d次UDP探测收到应答: %.2fms\n", i+1, rtt.Seconds()*1000)
+				break
+			}
+		}
+		if response.Refused {
+			break
+		}
+		if i < req.Count-1 {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+
+	response.PacketLoss = float64(req.Count-response.Received) / float64(req.Count) * 100
+	if response.Received > 0 {
+		response.Success = true
+		response.AverageTime = total.Seconds() * 1000 / float64(response.Received)
+		fmt.Printf("✅ UDP探测完成: 平均往返时间 %.2fms，无应答率 %.1f%%\n", response.AverageTime, response.PacketLoss)
+		return response, nil
+	}
+	if response.Refused {
+		response.PacketLoss = 100
+		response.ErrorMessage = "目标端口不可达(ICMP port unreachable)"
+	} else {
+		response.ErrorMessage = "所有UDP探测均未收到应答"
+	}
+	return response, nil
+}
+
+// buildUDPProbe 构造一次探测请求，返回请求内容和应答校验函数
+func buildUDPProbe(mode string, payload []byte) ([]byte, func([]byte) bool) {
+	switch mode {
+	case "dns":
+		// 查询根域名 NS 记录，任何递归或权威 DNS 服务器都会应答
+		var id [2]byte
+		_, _ = rand.Read(id[:])
+		query := []byte{
+			id[0], id[1], // ID
+			0x01, 0x00, // 标准查询，期望递归
+			0x00, 0x01, // QDCOUNT
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x00,       // 根域名
+			0x00, 0x02, // QTYPE NS
+			0x00, 0x01, // QCLASS IN
+		}
+		return query, func(b []byte) bool {
+			return len(b) >= 12 && b[0] == id[0] && b[1] == id[1] && b[2]&0x80 != 0
+		}
+	case "stun":
+		// RFC 5389 Binding Request，只接受事务 ID 一致的 Binding 成功/错误应答，
+		// 避免把原样回显的请求当成 STUN 服务器
+		req := make([]byte, 20)
+		binary.BigEndian.PutUint16(req[0:2], 0x0001)
+		binary.BigEndian.PutUint32(req[4:8], stunMagicCookie)
+		_, _ = rand.Read(req[8:20])
+		txn := append([]byte(nil), req[8:20]...)
+		return req, func(b []byte) bool {
+			if len(b) < 20 {
+				return false
+			}
+			msgType := binary.BigEndian.Uint16(b[0:2])
+			return (msgType == 0x0101 || msgType == 0x0111) &&
+				binary.BigEndian.Uint32(b[4:8]) == stunMagicCookie && bytes.Equal(b[8:20], txn)
+		}
+	default:
+		return payload, func([]byte) bool { return true }
+	}
+}
@@ -0,0 +1,168 @@
+package socket
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	agentBinaryPath = "/etc/flux_agent/flux_agent"
+	// upgradePendingFile 升级后等待新版本确认的标记，看门狗在期限到达时若仍存在则恢复旧版本
+	upgradePendingFile = "/etc/flux_agent/upgrade_pending.json"
+	// defaultUpgradeHealthDeadline 面板未指定时新版本上报版本号的期限
+	defaultUpgradeHealthDeadline = 120 * time.Second
+)
+
+// upgradePublicKey 升级包签名公钥（base64 编码的 Ed25519 公钥），
+// 编译时通过 -ldflags "-X github.com/go-gost/x/socket.upgradePublicKey=..." 固定
+var upgradePublicKey string
+
+// SetUpgradePublicKey 设置配置文件中的公钥，仅在编译时未固定公钥（自行编译的节点）时生效。
+// 已固定公钥时忽略配置，否则能修改 config.json 的人就能安装自己签名的程序
+func SetUpgradePublicKey(key string) {
+	key = strings.TrimSpace(key)
+	if key == "" || key == upgradePublicKey {
+		return
+	}
+	if upgradePublicKey != "" {
+		fmt.Println("⚠️ 已使用编译时固定的升级签名公钥，忽略配置文件中的 upgrade_public_key")
+		return
+	}
+	upgradePublicKey = key
+}
+
+func upgradeVerifyKey() (ed25519.PublicKey, error) {
+	if upgradePublicKey == "" {
+		return nil, errors.New("未配置升级签名公钥，拒绝升级")
+	}
+	raw, err := base64.StdEncoding.DecodeString(upgradePublicKey)
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, errors.New("升级签名公钥格式错误")
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// verifyUpgradeSignature 校验升级包的 Ed25519 签名，签名为 base64 文本
+func verifyUpgradeSignature(key ed25519.PublicKey, file string, resp *http.Response) error {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("下载签名失败, HTTP状态码: %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return fmt.Errorf("读取签名失败: %v", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(body)))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return errors.New("签名格式错误")
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("读取升级包失败: %v", err)
+	}
+	if !ed25519.Verify(key, data, sig) {
+		return errors.New("签名校验失败")
+	}
+	return nil
+}
+
+// upgradePending 记录正在进行的升级，新版本连上面板后删除
+type upgradePending struct {
+	Version  string `json:"version"`
+	Previous string `json:"previous"`
+	Deadline int64  `json:"deadline"`
+}
+
+func writeUpgradePending(p upgradePending) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(upgradePendingFile, data, 0644)
+}
+
+// readUpgradePending 读取升级标记，不存在时返回 nil
+func readUpgradePending() *upgradePending {
+	data, err := os.ReadFile(upgradePendingFile)
+	if err != nil {
+		return nil
+	}
+	var p upgradePending
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil
+	}
+	return &p
+}
+
+// UpgradeProgress 本进程最近一次升级的进度
+type UpgradeProgress struct {
+	Stage     string `json:"stage"` // downloading / verifying / installing / failed
+	Percent   int    `json:"percent"`
+	Message   string `json:"message"`
+	UpdatedAt int64  `json:"updatedAt"`
+}
+
+var upgradeProgress struct {
+	sync.Mutex
+	last *UpgradeProgress
+}
+
+func setUpgradeProgress(stage string, percent int, message string) {
+	upgradeProgress.Lock()
+	upgradeProgress.last = &UpgradeProgress{
+		Stage:     stage,
+		Percent:   percent,
+		Message:   message,
+		UpdatedAt: time.Now().UnixMilli(),
+	}
+	upgradeProgress.Unlock()
+}
+
+func getUpgradeProgress() *UpgradeProgress {
+	upgradeProgress.Lock()
+	defer upgradeProgress.Unlock()
+	if upgradeProgress.last == nil {
+		return nil
+	}
+	p := *upgradeProgress.last
+	return &p
+}
+
+// confirmUpgrade 在连上面板（已通过握手上报版本号）后调用。
+// 当前版本与升级目标一致时删除标记，看门狗不再回退
+func confirmUpgrade(current string) {
+	p := readUpgradePending()
+	if p == nil {
+		return
+	}
+	if p.Version != "" && trimVersion(p.Version) != trimVersion(current) {
+		fmt.Printf("⚠️ 当前版本 %s 与升级目标 %s 不一致，等待看门狗恢复旧版本\n", current, p.Version)
+		return
+	}
+	if err := os.Remove(upgradePendingFile); err == nil {
+		fmt.Printf("✅ 升级已确认，当前版本 %s\n", current)
+	}
+}
+
+func trimVersion(v string) string {
+	return strings.TrimPrefix(strings.TrimSpace(v), "v")
+}
+
+// upgradeScript 替换二进制并重启，deadline 后若升级标记仍在则恢复备份的旧版本
+func upgradeScript(newPath, backupPath string, deadline time.Duration) string {
+	return fmt.Sprintf(
+		"sleep 1 && systemctl stop flux_agent && mv %[1]s %[2]s && systemctl start flux_agent; "+
+			"sleep %[4]d; "+
+			"if [ -f %[5]s ]; then systemctl stop flux_agent; cp %[3]s %[2]s; rm -f %[5]s; systemctl start flux_agent; fi",
+		newPath, agentBinaryPath, backupPath, int(deadline.Seconds()), upgradePendingFile,
+	)
+}
@@ -0,0 +1,112 @@
+package socket
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func withUpgradePublicKey(t *testing.T, key string) {
+	t.Helper()
+	saved := upgradePublicKey
+	upgradePublicKey = key
+	t.Cleanup(func() { upgradePublicKey = saved })
+}
+
+func TestSetUpgradePublicKey(t *testing.T) {
+	pinned, _, _ := ed25519.GenerateKey(rand.Reader)
+	other, _, _ := ed25519.GenerateKey(rand.Reader)
+	pinnedKey := base64.StdEncoding.EncodeToString(pinned)
+	otherKey := base64.StdEncoding.EncodeToString(other)
+
+	// 编译时已固定公钥，配置文件不能替换
+	withUpgradePublicKey(t, pinnedKey)
+	SetUpgradePublicKey(otherKey)
+	if upgradePublicKey != pinnedKey {
+		t.Fatalf("expected the pinned key to be kept")
+	}
+
+	// 自行编译未固定公钥时使用配置文件中的公钥
+	upgradePublicKey = ""
+	SetUpgradePublicKey(" " + otherKey + " ")
+	key, err := upgradeVerifyKey()
+	if err != nil || !key.Equal(other) {
+		t.Fatalf("expected the configured key to be used, got %v", err)
+	}
+
+	upgradePublicKey = ""
+	SetUpgradePublicKey("")
+	if _, err := upgradeVerifyKey(); err == nil {
+		t.Fatalf("expected upgrades to be refused without a key")
+	}
+	upgradePublicKey = "not-a-key"
+	if _, err := upgradeVerifyKey(); err == nil {
+		t.Fatalf("expected a malformed key to be rejected")
+	}
+}
+
+func signatureResponse(status int, body string) *http.Response {
+	return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(body))}
+}
+
+func TestVerifyUpgradeSignature(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	otherPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	data := []byte("flux agent binary")
+	file := filepath.Join(t.TempDir(), "gost")
+	if err := os.WriteFile(file, data, 0755); err != nil {
+		t.Fatal(err)
+	}
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, data))
+	tampered := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte("other binary")))
+
+	cases := []struct {
+		name string
+		key  ed25519.PublicKey
+		resp *http.Response
+		ok   bool
+	}{
+		{"valid", pub, signatureResponse(http.StatusOK, sig+"\n"), true},
+		{"wrong key", otherPub, signatureResponse(http.StatusOK, sig), false},
+		{"other content", pub, signatureResponse(http.StatusOK, tampered), false},
+		{"not found", pub, signatureResponse(http.StatusNotFound, sig), false},
+		{"malformed", pub, signatureResponse(http.StatusOK, "not base64!"), false},
+		{"short", pub, signatureResponse(http.StatusOK, base64.StdEncoding.EncodeToString([]byte("short"))), false},
+	}
+	for _, tc := range cases {
+		if err := verifyUpgradeSignature(tc.key, file, tc.resp); (err == nil) != tc.ok {
+			t.Errorf("%s: expected ok=%v, got %v", tc.name, tc.ok, err)
+		}
+	}
+}
+
+func TestUpgradeScript(t *testing.T) {
+	script := upgradeScript("/tmp/flux_agent.new", "/etc/flux_agent/flux_agent.old", 90*time.Second)
+
+	// 先替换并重启，等待期限后若升级标记仍在则恢复备份
+	steps := []string{
+		"systemctl stop flux_agent",
+		"mv /tmp/flux_agent.new " + agentBinaryPath,
+		"systemctl start flux_agent",
+		"sleep 90",
+		"if [ -f " + upgradePendingFile + " ]",
+		"cp /etc/flux_agent/flux_agent.old " + agentBinaryPath,
+		"rm -f " + upgradePendingFile,
+		"systemctl start flux_agent; fi",
+	}
+	rest := script
+	for _, step := range steps {
+		i := strings.Index(rest, step)
+		if i < 0 {
+			t.Fatalf("expected %q after the previous steps in %q", step, script)
+		}
+		rest = rest[i+len(step):]
+	}
+}
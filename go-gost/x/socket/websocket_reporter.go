@@ -20,8 +20,12 @@ import (
 	"sync" // 新增：用于管理连接状态的互斥锁
 	"time"
 
+	"github.com/go-gost/core/observer/stats"
+	"github.com/go-gost/x/admission/geoip"
 	"github.com/go-gost/x/config"
 	"github.com/go-gost/x/internal/util/crypto"
+	"github.com/go-gost/x/paneltls"
+	"github.com/go-gost/x/registry"
 	"github.com/go-gost/x/service"
 	"github.com/gorilla/websocket"
 	"github.com/shirou/gopsutil/v3/cpu"
@@ -32,11 +36,13 @@ import (
 
 // SystemInfo 系统信息结构体
 type SystemInfo struct {
-	Uptime           uint64  `json:"uptime"`            // 开机时间	（秒）
-	BytesReceived    uint64  `json:"bytes_received"`    // 接收字节数
-	BytesTransmitted uint64  `json:"bytes_transmitted"` // 发送字节数
-	CPUUsage         float64 `json:"cpu_usage"`         // CPU使用率（百分比）
-	MemoryUsage      float64 `json:"memory_usage"`      // 内存使用率（百分比）
+	Uptime           uint64             `json:"uptime"`            // 开机时间	（秒）
+	BytesReceived    uint64             `json:"bytes_received"`    // 接收字节数
+	BytesTransmitted uint64             `json:"bytes_transmitted"` // 发送字节数
+	CPUUsage         float64            `json:"cpu_usage"`         // CPU使用率（百分比）
+	MemoryUsage      float64            `json:"memory_usage"`      // 内存使用率（百分比）
+	Connections      int64              `json:"connections"`       // 所有服务的当前连接数
+	GeoIP            geoip.DatabaseInfo `json:"geoip"`             // GeoIP 国家库路径与版本
 }
 
 // NetworkStats 网络统计信息
@@ -111,6 +117,10 @@ type WebSocketReporter struct {
 	connecting     bool              // 新增：正在连接状态
 	connMutex      sync.Mutex        // 新增：连接状态锁
 	aesCrypto      *crypto.AESCrypto // 新增：AES加密器
+	connectedAt    time.Time         // 最近一次连上面板的时间
+	disconnectedAt time.Time         // 最近一次与面板断开的时间
+	lastError      string            // 最近一次连接失败原因
+	lastErrorAt    time.Time
 }
 
 // NewWebSocketReporter 创建一个新的WebSocket报告器
@@ -167,6 +177,9 @@ func (w *WebSocketReporter) run() {
 
 			if needConnect {
 				if err := w.connect(); err != nil {
+					w.connMutex.Lock()
+					w.lastError, w.lastErrorAt = err.Error(), time.Now()
+					w.connMutex.Unlock()
 					fmt.Printf("❌ WebSocket连接失败: %v，%v后重试\n", err, w.reconnectTime)
 					select {
 					case <-time.After(w.reconnectTime):
@@ -223,20 +236,36 @@ func (w *WebSocketReporter) connect() error {
 		json.Unmarshal(b, &cfg)
 	}
 
+	// 过期证书无法通过握手，先退回密钥连接，由续期任务重新申请证书
+	if paneltls.Expired() {
+		paneltls.Disable()
+		fmt.Println("⚠️ 节点证书已过期，面板连接改用密钥认证")
+	}
+
 	// 使用最新的配置重新构建 URL
-	currentURL := "ws://" + w.addr + "/system-info?type=1&secret=" + w.secret + "&version=" + w.version +
-		"&http=" + strconv.Itoa(cfg.Http) + "&tls=" + strconv.Itoa(cfg.Tls) + "&socks=" + strconv.Itoa(cfg.Socks)
+	currentURL := paneltls.WSBase(w.addr) + "/system-info?type=1&secret=" + w.secret + "&version=" + w.version +
+		"&http=" + strconv.Itoa(cfg.Http) + "&tls=" + strconv.Itoa(cfg.Tls) + "&socks=" + strconv.Itoa(cfg.Socks) +
+		"&arch=" + runtime.GOARCH
 
 	u, err := url.Parse(currentURL)
 	if err != nil {
 		return fmt.Errorf("解析URL失败: %v", err)
 	}
 
-	dialer := websocket.DefaultDialer
+	dialer := *websocket.DefaultDialer
 	dialer.HandshakeTimeout = 10 * time.Second
+	dialer.TLSClientConfig = paneltls.ClientConfig()
 
-	conn, _, err := dialer.Dial(u.String(), nil)
+	conn, resp, err := dialer.Dial(u.String(), nil)
 	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusForbidden && paneltls.Enabled() {
+			// 证书已被面板吊销，退回密钥连接，并清除本地 mTLS 配置，重启后也不再启用
+			paneltls.Disable()
+			if err := UpdateConfigFile("config.json", nil, "mtls", "tls_addr", "panel_pin"); err != nil {
+				fmt.Printf("⚠️ 清除 mTLS 配置失败: %v\n", err)
+			}
+			fmt.Println("⚠️ 面板拒绝了节点证书，面板连接改用密钥认证")
+		}
 		return fmt.Errorf("连接WebSocket失败: %v", err)
 	}
 
@@ -248,6 +277,7 @@ func (w *WebSocketReporter) connect() error {
 
 	w.conn = conn
 	w.connected = true
+	w.connectedAt = time.Now()
 	_ = conn.SetReadDeadline(time.Now().Add(reporterReadWait))
 	conn.SetPingHandler(func(appData string) error {
 		_ = conn.SetReadDeadline(time.Now().Add(reporterReadWait))
@@ -266,6 +296,7 @@ func (w *WebSocketReporter) connect() error {
 	})
 
 	fmt.Printf("✅ WebSocket连接建立成功 (http=%d, tls=%d, socks=%d)\n", cfg.Http, cfg.Tls, cfg.Socks)
+	confirmUpgrade(w.version)
 	return nil
 }
 
@@ -278,6 +309,7 @@ func (w *WebSocketReporter) handleConnection() {
 			w.conn = nil
 		}
 		w.connected = false
+		w.disconnectedAt = time.Now()
 		w.connMutex.Unlock()
 		fmt.Printf("🔌 WebSocket连接已关闭\n")
 	}()
@@ -325,6 +357,8 @@ func (w *WebSocketReporter) collectSystemInfo() SystemInfo {
 		BytesTransmitted: networkStats.BytesTransmitted,
 		CPUUsage:         cpuInfo.Usage,
 		MemoryUsage:      memoryInfo.Usage,
+		Connections:      getConnectionCount(),
+		GeoIP:            geoip.Info(),
 	}
 }
 
@@ -486,7 +520,7 @@ func (w *WebSocketReporter) handleReceivedMessage(messageType int, message []byt
 
 			if cmdMsg.Type != "call" {
 				// 其他状态变更命令保持同步，确保顺序执行
-				if cmdMsg.Type == "TcpPing" || cmdMsg.Type == "UpgradeAgent" || cmdMsg.Type == "RollbackAgent" {
+				if isAsyncCommand(cmdMsg.Type) {
 					go w.routeCommand(cmdMsg)
 				} else {
 					w.routeCommand(cmdMsg)
@@ -502,7 +536,7 @@ func (w *WebSocketReporter) handleReceivedMessage(messageType int, message []byt
 			}
 			if cmdMsg.Type != "call" {
 				// 其他状态变更命令保持同步，确保顺序执行
-				if cmdMsg.Type == "TcpPing" || cmdMsg.Type == "UpgradeAgent" || cmdMsg.Type == "RollbackAgent" {
+				if isAsyncCommand(cmdMsg.Type) {
 					go w.routeCommand(cmdMsg)
 				} else {
 					w.routeCommand(cmdMsg)
@@ -515,6 +549,15 @@ func (w *WebSocketReporter) handleReceivedMessage(messageType int, message []byt
 	}
 }
 
+// isAsyncCommand 耗时较长的只读诊断和升级命令在独立协程中执行，避免阻塞后续命令
+func isAsyncCommand(cmdType string) bool {
+	switch cmdType {
+	case "TcpPing", "UdpProbe", "Traceroute", "RunSpeedTest", "UpgradeAgent", "RollbackAgent":
+		return true
+	}
+	return false
+}
+
 // routeCommand 路由命令到对应的处理函数
 func (w *WebSocketReporter) routeCommand(cmd CommandMessage) {
 	jsonBytes, errs := json.Marshal(cmd)
@@ -582,6 +625,30 @@ func (w *WebSocketReporter) routeCommand(cmd CommandMessage) {
 		response.Type = "DeleteLimitersResponse"
 		needSaveConfig = true
 
+	// Admission 相关命令（来源 IP 访问控制，热更新不重启监听）
+	case "UpdateAdmissions":
+		err = w.handleUpdateAdmissions(cmd.Data)
+		response.Type = "UpdateAdmissionsResponse"
+		needSaveConfig = true
+	case "DeleteAdmissions":
+		err = w.handleDeleteAdmissions(cmd.Data)
+		response.Type = "DeleteAdmissionsResponse"
+		needSaveConfig = true
+
+	// 证书与 ACME 验证命令（证书写入 certs 目录，不属于 gost 配置）
+	case "UpdateCertificates":
+		err = w.handleUpdateCertificates(cmd.Data)
+		response.Type = "UpdateCertificatesResponse"
+	case "DeleteCertificates":
+		err = w.handleDeleteCertificates(cmd.Data)
+		response.Type = "DeleteCertificatesResponse"
+	case "SetACMEChallenges":
+		err = w.handleSetACMEChallenges(cmd.Data)
+		response.Type = "SetACMEChallengesResponse"
+	case "DeleteACMEChallenges":
+		err = w.handleDeleteACMEChallenges(cmd.Data)
+		response.Type = "DeleteACMEChallengesResponse"
+
 	// TCP Ping 诊断命令（只读，不需要保存配置）
 	case "TcpPing":
 		var tcpPingResult TcpPingResponse
@@ -590,6 +657,55 @@ func (w *WebSocketReporter) routeCommand(cmd CommandMessage) {
 		response.Data = tcpPingResult
 		// needSaveConfig = false (默认值)
 
+	// UDP 探测诊断命令（只读，不需要保存配置）
+	case "UdpProbe":
+		var udpProbeResult UdpProbeResponse
+		udpProbeResult, err = w.handleUdpProbe(cmd.Data)
+		response.Type = "UdpProbeResponse"
+		response.Data = udpProbeResult
+
+	// 路由追踪诊断命令（只读，不需要保存配置）
+	case "Traceroute":
+		var traceResult TracerouteResponse
+		traceResult, err = w.handleTraceroute(cmd.Data)
+		response.Type = "TracerouteResponse"
+		response.Data = traceResult
+
+	// 节点间测速命令（临时服务，不写入配置）
+	case "StartSpeedTestSink":
+		response.Data, err = w.handleStartSpeedTestSink(cmd.Data)
+		response.Type = "StartSpeedTestSinkResponse"
+	case "StopSpeedTestSink":
+		response.Data, err = w.handleStopSpeedTestSink(cmd.Data)
+		response.Type = "StopSpeedTestSinkResponse"
+	case "RunSpeedTest":
+		response.Data, err = w.handleRunSpeedTest(cmd.Data)
+		response.Type = "RunSpeedTestResponse"
+
+	// 活动连接表（不需要保存配置）
+	case "ListConnections":
+		response.Data, err = w.handleListConnections(cmd.Data)
+		response.Type = "ListConnectionsResponse"
+	case "CloseConnections":
+		response.Data, err = w.handleCloseConnections(cmd.Data)
+		response.Type = "CloseConnectionsResponse"
+
+	// 节点日志：查看/实时跟踪最近日志、临时调整服务日志级别（不需要保存配置）
+	case "TailLogs":
+		response.Data, err = w.handleTailLogs(cmd.Data)
+		response.Type = "TailLogsResponse"
+	case "StopLogTail":
+		response.Data, err = w.handleStopLogTail(cmd.Data)
+		response.Type = "StopLogTailResponse"
+	case "SetLogLevel":
+		response.Data, err = w.handleSetLogLevel(cmd.Data)
+		response.Type = "SetLogLevelResponse"
+
+	// 读取运行配置，供面板比对期望状态（只读，不需要保存配置）
+	case "GetConfig":
+		response.Data, err = w.handleGetConfig()
+		response.Type = "GetConfigResponse"
+
 	// Protocol blocking switches
 	case "SetProtocol":
 		err = w.handleSetProtocol(cmd.Data)
@@ -600,6 +716,9 @@ func (w *WebSocketReporter) routeCommand(cmd CommandMessage) {
 	case "UpgradeAgent":
 		err = w.handleUpgradeAgent(cmd.Data)
 		response.Type = "UpgradeAgentResponse"
+		if err != nil {
+			setUpgradeProgress("failed", 0, err.Error())
+		}
 		// needSaveConfig = false (默认值)
 
 	// 回退 Agent 到旧版本
@@ -861,6 +980,91 @@ func (w *WebSocketReporter) handleDeleteLimiter(data interface{}) error {
 	return deleteLimiter(deleteReq)
 }
 
+// Admission 命令处理函数
+func (w *WebSocketReporter) handleUpdateAdmissions(data interface{}) error {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("序列化数据失败: %v", err)
+	}
+
+	var admissions []config.AdmissionConfig
+	if err := json.Unmarshal(jsonData, &admissions); err != nil {
+		return fmt.Errorf("解析准入配置失败: %v", err)
+	}
+
+	return upsertAdmissions(upsertAdmissionsRequest{Data: admissions})
+}
+
+func (w *WebSocketReporter) handleDeleteAdmissions(data interface{}) error {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("序列化数据失败: %v", err)
+	}
+
+	var req deleteAdmissionsRequest
+	if err := json.Unmarshal(jsonData, &req); err != nil {
+		return fmt.Errorf("解析准入删除请求失败: %v", err)
+	}
+
+	return deleteAdmissions(req)
+}
+
+func (w *WebSocketReporter) handleUpdateCertificates(data interface{}) error {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("序列化数据失败: %v", err)
+	}
+
+	var items []certificateItem
+	if err := json.Unmarshal(jsonData, &items); err != nil {
+		return fmt.Errorf("解析证书配置失败: %v", err)
+	}
+
+	return upsertCertificates(items)
+}
+
+func (w *WebSocketReporter) handleDeleteCertificates(data interface{}) error {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("序列化数据失败: %v", err)
+	}
+
+	var req deleteCertificatesRequest
+	if err := json.Unmarshal(jsonData, &req); err != nil {
+		return fmt.Errorf("解析证书删除请求失败: %v", err)
+	}
+
+	return deleteCertificates(req)
+}
+
+func (w *WebSocketReporter) handleSetACMEChallenges(data interface{}) error {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("序列化数据失败: %v", err)
+	}
+
+	var req setACMEChallengesRequest
+	if err := json.Unmarshal(jsonData, &req); err != nil {
+		return fmt.Errorf("解析证书验证请求失败: %v", err)
+	}
+
+	return setACMEChallenges(req)
+}
+
+func (w *WebSocketReporter) handleDeleteACMEChallenges(data interface{}) error {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("序列化数据失败: %v", err)
+	}
+
+	var req deleteACMEChallengesRequest
+	if err := json.Unmarshal(jsonData, &req); err != nil {
+		return fmt.Errorf("解析证书验证请求失败: %v", err)
+	}
+
+	return deleteACMEChallenges(req)
+}
+
 // handleSetProtocol 处理设置屏蔽协议的命令
 func (w *WebSocketReporter) handleSetProtocol(data interface{}) error {
 	jsonData, err := json.Marshal(data)
@@ -903,8 +1107,12 @@ func (w *WebSocketReporter) handleSetProtocol(data interface{}) error {
 	// 设置至 service，全量传递（未提供的值沿用0）
 	service.SetProtocolBlock(httpVal, tlsVal, socksVal)
 
-	// 同步写入本地 config.json
-	if err := updateLocalConfigJSON(httpVal, tlsVal, socksVal); err != nil {
+	// 同步写入本地 config.json，只改这三个字段，其它配置原样保留
+	if err := UpdateConfigFile("config.json", map[string]interface{}{
+		"http":  httpVal,
+		"tls":   tlsVal,
+		"socks": socksVal,
+	}); err != nil {
 		return fmt.Errorf("写入config.json失败: %v", err)
 	}
 	return nil
@@ -912,6 +1120,7 @@ func (w *WebSocketReporter) handleSetProtocol(data interface{}) error {
 
 // sendUpgradeProgress 通过 WS 发送升级进度消息
 func (w *WebSocketReporter) sendUpgradeProgress(stage string, percent int, message string) {
+	setUpgradeProgress(stage, percent, message)
 	response := CommandResponse{
 		Type:    "UpgradeProgress",
 		Success: true,
@@ -924,6 +1133,20 @@ func (w *WebSocketReporter) sendUpgradeProgress(stage string, percent int, messa
 	w.sendResponse(response)
 }
 
+// fetchUpgradeFile 下载升级文件。以 "/" 开头的地址为面板托管的升级包，
+// 经面板通道（含 mTLS）访问并附带节点密钥
+func (w *WebSocketReporter) fetchUpgradeFile(rawURL string) (*http.Response, error) {
+	if !strings.HasPrefix(rawURL, "/") {
+		return http.Get(rawURL)
+	}
+	sep := "?"
+	if strings.Contains(rawURL, "?") {
+		sep = "&"
+	}
+	full := paneltls.HTTPBase(w.addr) + rawURL + sep + "secret=" + url.QueryEscape(w.secret)
+	return paneltls.HTTPClient(0).Get(full)
+}
+
 func (w *WebSocketReporter) handleUpgradeAgent(data interface{}) error {
 	jsonData, err := json.Marshal(data)
 	if err != nil {
@@ -931,8 +1154,11 @@ func (w *WebSocketReporter) handleUpgradeAgent(data interface{}) error {
 	}
 
 	var req struct {
-		DownloadURL string `json:"downloadUrl"`
-		ChecksumURL string `json:"checksumUrl"`
+		DownloadURL    string `json:"downloadUrl"`
+		ChecksumURL    string `json:"checksumUrl"`
+		SignatureURL   string `json:"signatureUrl"`
+		Version        string `json:"version"`
+		HealthDeadline int    `json:"healthDeadline"` // 秒
 	}
 	if err := json.Unmarshal(jsonData, &req); err != nil {
 		return fmt.Errorf("解析升级参数失败: %v", err)
@@ -940,20 +1166,32 @@ func (w *WebSocketReporter) handleUpgradeAgent(data interface{}) error {
 	if strings.TrimSpace(req.DownloadURL) == "" {
 		return fmt.Errorf("下载地址不能为空")
 	}
+	if strings.TrimSpace(req.SignatureURL) == "" {
+		return fmt.Errorf("缺少升级包签名地址，拒绝升级")
+	}
+	verifyKey, err := upgradeVerifyKey()
+	if err != nil {
+		return err
+	}
+	healthDeadline := defaultUpgradeHealthDeadline
+	if req.HealthDeadline > 0 {
+		healthDeadline = time.Duration(req.HealthDeadline) * time.Second
+	}
 
 	// 替换架构占位符
 	downloadURL := strings.ReplaceAll(req.DownloadURL, "{ARCH}", runtime.GOARCH)
 	checksumURL := strings.ReplaceAll(req.ChecksumURL, "{ARCH}", runtime.GOARCH)
+	signatureURL := strings.ReplaceAll(req.SignatureURL, "{ARCH}", runtime.GOARCH)
 
 	w.sendUpgradeProgress("downloading", 0, "开始下载升级包...")
 	fmt.Printf("📦 开始下载升级包: %s\n", downloadURL)
 
 	// 下载新版本二进制
-	const binaryPath = "/etc/flux_agent/flux_agent"
+	binaryPath := agentBinaryPath
 	tmpPath := binaryPath + ".new"
 	backupPath := binaryPath + ".old"
 
-	resp, err := http.Get(downloadURL)
+	resp, err := w.fetchUpgradeFile(downloadURL)
 	if err != nil {
 		return fmt.Errorf("下载升级包失败: %v", err)
 	}
@@ -1011,28 +1249,43 @@ func (w *WebSocketReporter) handleUpgradeAgent(data interface{}) error {
 
 	w.sendUpgradeProgress("downloading", 100, fmt.Sprintf("下载完成 (%d bytes)", downloaded))
 
-	// Checksum 校验
+	// Checksum 校验，校验文件无法获取时中止升级
 	if checksumURL != "" {
 		w.sendUpgradeProgress("verifying", 0, "校验文件完整性...")
-		checksumResp, err := http.Get(checksumURL)
-		if err == nil {
-			defer checksumResp.Body.Close()
-			if checksumResp.StatusCode == http.StatusOK {
-				checksumBody, err := io.ReadAll(checksumResp.Body)
-				if err == nil {
-					// 格式: "<hash>  <filename>" 或 "<hash>"
-					expectedHash := strings.TrimSpace(strings.Split(string(checksumBody), " ")[0])
-					actualHash := hex.EncodeToString(hasher.Sum(nil))
-					if !strings.EqualFold(expectedHash, actualHash) {
-						os.Remove(tmpPath)
-						return fmt.Errorf("校验失败: 期望 %s, 实际 %s", expectedHash, actualHash)
-					}
-					fmt.Printf("✅ Checksum 校验通过: %s\n", actualHash)
-				}
-			}
+		checksumResp, err := w.fetchUpgradeFile(checksumURL)
+		if err != nil {
+			os.Remove(tmpPath)
+			return fmt.Errorf("下载校验文件失败: %v", err)
+		}
+		checksumBody, err := io.ReadAll(checksumResp.Body)
+		checksumResp.Body.Close()
+		if err != nil || checksumResp.StatusCode != http.StatusOK {
+			os.Remove(tmpPath)
+			return fmt.Errorf("下载校验文件失败, HTTP状态码: %d", checksumResp.StatusCode)
+		}
+		// 格式: "<hash>  <filename>" 或 "<hash>"
+		expectedHash := strings.TrimSpace(strings.Split(string(checksumBody), " ")[0])
+		actualHash := hex.EncodeToString(hasher.Sum(nil))
+		if !strings.EqualFold(expectedHash, actualHash) {
+			os.Remove(tmpPath)
+			return fmt.Errorf("校验失败: 期望 %s, 实际 %s", expectedHash, actualHash)
 		}
-		w.sendUpgradeProgress("verifying", 100, "校验通过")
+		fmt.Printf("✅ Checksum 校验通过: %s\n", actualHash)
+	}
+
+	// 签名校验
+	w.sendUpgradeProgress("verifying", 50, "校验升级包签名...")
+	signatureResp, err := w.fetchUpgradeFile(signatureURL)
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("下载签名失败: %v", err)
+	}
+	if err := verifyUpgradeSignature(verifyKey, tmpPath, signatureResp); err != nil {
+		os.Remove(tmpPath)
+		return err
 	}
+	fmt.Println("✅ 升级包签名校验通过")
+	w.sendUpgradeProgress("verifying", 100, "校验通过")
 
 	if err := os.Chmod(tmpPath, 0755); err != nil {
 		os.Remove(tmpPath)
@@ -1045,9 +1298,21 @@ func (w *WebSocketReporter) handleUpgradeAgent(data interface{}) error {
 		// 复制旧文件作为备份（不用 rename，因为可能正在运行）
 		oldData, err := os.ReadFile(binaryPath)
 		if err == nil {
-			_ = os.WriteFile(backupPath, oldData, 0755)
-			fmt.Println("📦 旧版本已备份到", backupPath)
+			err = os.WriteFile(backupPath, oldData, 0755)
+		}
+		if err != nil {
+			os.Remove(tmpPath)
+			return fmt.Errorf("备份旧版本失败: %v", err)
 		}
+		fmt.Println("📦 旧版本已备份到", backupPath)
+	}
+	if err := writeUpgradePending(upgradePending{
+		Version:  req.Version,
+		Previous: w.version,
+		Deadline: time.Now().Add(healthDeadline).Unix(),
+	}); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("写入升级标记失败: %v", err)
 	}
 
 	w.sendUpgradeProgress("installing", 80, "准备重启...")
@@ -1056,20 +1321,35 @@ func (w *WebSocketReporter) handleUpgradeAgent(data interface{}) error {
 	// 执行重启脚本
 	// 使用 systemd-run 在独立的 transient unit 中运行重启脚本，
 	// 避免 systemctl stop 杀死 flux_agent cgroup 内所有进程（包括此脚本自身）导致 mv 未执行。
-	script := fmt.Sprintf("sleep 1 && systemctl stop flux_agent && mv %s %s && systemctl start flux_agent", tmpPath, binaryPath)
+	// 脚本同时充当看门狗：新版本未在期限内连上面板时恢复旧版本。
+	script := upgradeScript(tmpPath, backupPath, healthDeadline)
 	cmd := exec.Command("systemd-run", "--quiet", "/bin/sh", "-c", script)
 	if err := cmd.Start(); err != nil {
 		os.Remove(tmpPath)
+		os.Remove(upgradePendingFile)
 		return fmt.Errorf("启动重启脚本失败: %v", err)
 	}
 
 	w.sendUpgradeProgress("installing", 100, "重启中...")
-	fmt.Println("🔄 重启脚本已启动, Agent 将在 1 秒后重启...")
+	fmt.Printf("🔄 重启脚本已启动, Agent 将在 1 秒后重启, 新版本需在 %v 内连上面板\n", healthDeadline)
 	return nil
 }
 
+// handleGetConfig 返回内存中的 gost 配置，与定时上报到 /flow/config 的内容一致
+func (w *WebSocketReporter) handleGetConfig() (map[string]interface{}, error) {
+	var buf bytes.Buffer
+	if err := config.Global().Write(&buf, "json"); err != nil {
+		return nil, fmt.Errorf("序列化配置失败: %v", err)
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &data); err != nil {
+		return nil, fmt.Errorf("解析配置失败: %v", err)
+	}
+	return data, nil
+}
+
 func (w *WebSocketReporter) handleRollbackAgent(data interface{}) error {
-	const binaryPath = "/etc/flux_agent/flux_agent"
+	binaryPath := agentBinaryPath
 	backupPath := binaryPath + ".old"
 
 	// 检查备份文件是否存在
@@ -1090,36 +1370,6 @@ func (w *WebSocketReporter) handleRollbackAgent(data interface{}) error {
 	return nil
 }
 
-// updateLocalConfigJSON 将 http/tls/socks 写入工作目录下的 config.json
-func updateLocalConfigJSON(httpVal int, tlsVal int, socksVal int) error {
-	path := "config.json"
-
-	// 读取现有配置
-	type LocalConfig struct {
-		Addr   string `json:"addr"`
-		Secret string `json:"secret"`
-		Http   int    `json:"http"`
-		Tls    int    `json:"tls"`
-		Socks  int    `json:"socks"`
-	}
-
-	var cfg LocalConfig
-	if b, err := os.ReadFile(path); err == nil {
-		_ = json.Unmarshal(b, &cfg)
-	}
-
-	cfg.Http = httpVal
-	cfg.Tls = tlsVal
-	cfg.Socks = socksVal
-
-	// 写回
-	data, err := json.MarshalIndent(cfg, "", "  ")
-	if err != nil {
-		return err
-	}
-	return os.WriteFile(path, data, 0644)
-}
-
 // handleCall 处理服务端的call回调消息
 func (w *WebSocketReporter) handleCall(data interface{}) error {
 	// 解析call数据
@@ -1286,11 +1536,30 @@ func getMemoryInfo() MemoryInfo {
 	return memInfo
 }
 
+// getConnectionCount 汇总所有服务的当前连接数
+func getConnectionCount() int64 {
+	var total int64
+	for _, svc := range registry.ServiceRegistry().GetAll() {
+		ss, ok := svc.(interface{ Status() *service.Status })
+		if !ok {
+			continue
+		}
+		status := ss.Status()
+		if status == nil {
+			continue
+		}
+		if st := status.Stats(); st != nil {
+			total += int64(st.Get(stats.KindCurrentConns))
+		}
+	}
+	return total
+}
+
 // StartWebSocketReporterWithConfig 使用配置字段启动WebSocket报告器
 func StartWebSocketReporterWithConfig(addr string, secret string, http int, tls int, socks int, version string) *WebSocketReporter {
 
 	// 构建初始 WebSocket URL
-	fullURL := "ws://" + addr + "/system-info?type=1&secret=" + secret + "&version=" + version + "&http=" + strconv.Itoa(http) + "&tls=" + strconv.Itoa(tls) + "&socks=" + strconv.Itoa(socks)
+	fullURL := paneltls.WSBase(addr) + "/system-info?type=1&secret=" + secret + "&version=" + version + "&http=" + strconv.Itoa(http) + "&tls=" + strconv.Itoa(tls) + "&socks=" + strconv.Itoa(socks) + "&arch=" + runtime.GOARCH
 
 	fmt.Printf("🔗 WebSocket连接URL: %s\n", fullURL)
 
@@ -109,89 +109,20 @@ delete_self() {
   rm -f "$SCRIPT_PATH" && echo "✅ 脚本文件已删除" || echo "❌ 删除脚本文件失败"
 }
 
-# 检查并安装 tcpkill
-check_and_install_tcpkill() {
-  # 检查 tcpkill 是否已安装
-  if command -v tcpkill &> /dev/null; then
-    return 0
-  fi
-  
-  # 检测操作系统类型
-  OS_TYPE=$(uname -s)
-  
-  # 检查是否需要 sudo
-  if [[ $EUID -ne 0 ]]; then
-    SUDO_CMD="sudo"
-  else
-    SUDO_CMD=""
-  fi
-  
-  if [[ "$OS_TYPE" == "Darwin" ]]; then
-    if command -v brew &> /dev/null; then
-      brew install dsniff &> /dev/null
-    fi
-    return 0
-  fi
-  
-  # 检测 Linux 发行版并安装对应的包
-  if [ -f /etc/os-release ]; then
-    . /etc/os-release
-    DISTRO=$ID
-  elif [ -f /etc/redhat-release ]; then
-    DISTRO="rhel"
-  elif [ -f /etc/debian_version ]; then
-    DISTRO="debian"
-  else
-    return 0
-  fi
-  
-  case $DISTRO in
-    ubuntu|debian)
-      $SUDO_CMD apt update &> /dev/null
-      $SUDO_CMD apt install -y dsniff &> /dev/null
-      ;;
-    centos|rhel|fedora)
-      if command -v dnf &> /dev/null; then
-        $SUDO_CMD dnf install -y dsniff &> /dev/null
-      elif command -v yum &> /dev/null; then
-        $SUDO_CMD yum install -y dsniff &> /dev/null
-      fi
-      ;;
-    alpine)
-      $SUDO_CMD apk add --no-cache dsniff &> /dev/null
-      ;;
-    arch|manjaro)
-      $SUDO_CMD pacman -S --noconfirm dsniff &> /dev/null
-      ;;
-    opensuse*|sles)
-      $SUDO_CMD zypper install -y dsniff &> /dev/null
-      ;;
-    gentoo)
-      $SUDO_CMD emerge --ask=n net-analyzer/dsniff &> /dev/null
-      ;;
-    void)
-      $SUDO_CMD xbps-install -Sy dsniff &> /dev/null
-      ;;
-  esac
-  
-  return 0
-}
-
-
 # 获取用户输入的配置参数
 get_config_params() {
-  if [[ -z "$SERVER_ADDR" || -z "$SECRET" ]]; then
+  if [[ -z "$SERVER_ADDR" || ( -z "$SECRET" && -z "$ENROLL_TOKEN" ) ]]; then
     echo "请输入配置参数："
     
     if [[ -z "$SERVER_ADDR" ]]; then
       read -p "服务器地址: " SERVER_ADDR
     fi
     
-    if [[ -z "$SECRET" ]]; then
+    if [[ -z "$SECRET" && -z "$ENROLL_TOKEN" ]]; then
       read -p "密钥: " SECRET
     fi
     
-    if [[ -z "$SERVER_ADDR" || -z "$SECRET" ]]; then
+    if [[ -z "$SERVER_ADDR" || ( -z "$SECRET" && -z "$ENROLL_TOKEN" ) ]]; then
       echo "❌ 参数不完整，操作取消。"
       exit 1
     fi
@@ -199,10 +130,11 @@ get_config_params() {
 }
 
 # 解析命令行参数
-while getopts "a:s:" opt; do
+while getopts "a:s:t:" opt; do
   case $opt in
     a) SERVER_ADDR="$OPTARG" ;;
     s) SECRET="$OPTARG" ;;
+    t) ENROLL_TOKEN="$OPTARG" ;;
     *) echo "❌ 无效参数"; exit 1 ;;
   esac
 done
@@ -212,9 +144,6 @@ install_flux_agent() {
   echo "🚀 开始安装 flux_agent..."
   get_config_params
 
-    # 检查并安装 tcpkill
-  check_and_install_tcpkill
-  
 
   mkdir -p "$INSTALL_DIR"
 
@@ -244,12 +173,22 @@ install_flux_agent() {
   # 写入 config.json (安装时总是创建新的)
   CONFIG_FILE="$INSTALL_DIR/config.json"
   echo "📄 创建新配置: config.json"
-  cat > "$CONFIG_FILE" <<EOF
+  if [[ -z "$SECRET" && -n "$ENROLL_TOKEN" ]]; then
+    # 使用注册令牌，首次启动时由面板创建节点并写回密钥
+    cat > "$CONFIG_FILE" <<EOF
+{
+  "addr": "$SERVER_ADDR",
+  "token": "$ENROLL_TOKEN"
+}
+EOF
+  else
+    cat > "$CONFIG_FILE" <<EOF
 {
   "addr": "$SERVER_ADDR",
   "secret": "$SECRET"
 }
 EOF
+  fi
 
   # 写入 gost.json
   GOST_CONFIG="$INSTALL_DIR/gost.json"
@@ -311,9 +250,6 @@ update_flux_agent() {
   
   echo "📥 使用下载地址: $DOWNLOAD_URL"
   
-  # 检查并安装 tcpkill
-  check_and_install_tcpkill
-  
   # 先下载新版本
   echo "⬇️ 下载最新版本..."
   curl -L "$DOWNLOAD_URL" -o "$INSTALL_DIR/flux_agent.new"
@@ -380,7 +316,7 @@ uninstall_flux_agent() {
 # 主逻辑
 main() {
   # 如果提供了命令行参数，直接执行安装
-  if [[ -n "$SERVER_ADDR" && -n "$SECRET" ]]; then
+  if [[ -n "$SERVER_ADDR" && ( -n "$SECRET" || -n "$ENROLL_TOKEN" ) ]]; then
     install_flux_agent
     delete_self
     exit 0